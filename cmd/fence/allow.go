@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/allowance"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// defaultAllowanceDuration is how long fence allow grants without --for.
+const defaultAllowanceDuration = time.Hour
+
+// newAllowCmd creates the allow subcommand.
+func newAllowCmd() *cobra.Command {
+	var (
+		duration time.Duration
+		list     bool
+		revoke   bool
+		clearAll bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "allow [DOMAIN]",
+		Short: "Temporarily allow a domain the config does not",
+		Long: `Let fenced commands connect to a domain for a while, without editing the
+config. The allowance is kept in ~/.fence/allowances with its expiry time and
+applies to every fence, including commands already running, until it
+expires. Expired allowances are ignored and dropped the next time one is
+added or revoked.
+
+An allowance only covers hosts no domain rule matches: a network.deniedDomains
+entry still denies the host. It does not apply with --policy-plugin or
+policy.rego, which decide connections themselves.
+
+Examples:
+  fence allow api.example.com --for 30m
+  fence allow '*.example.com' --for 2h
+  fence allow --list
+  fence allow --revoke api.example.com
+  fence allow --clear`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := allowance.DefaultStore()
+			if err != nil {
+				return err
+			}
+			switch {
+			case clearAll:
+				return store.Clear()
+			case list:
+				return listAllowances(store)
+			case len(args) == 0:
+				return errors.New("a domain is required")
+			case revoke:
+				removed, err := store.Remove(args[0])
+				if err != nil {
+					return err
+				}
+				if !removed {
+					return fmt.Errorf("%s has no active allowance", args[0])
+				}
+				logging.Infof("", "Revoked the allowance for %s", args[0])
+				return nil
+			}
+
+			a, err := store.Add(args[0], duration)
+			if err != nil {
+				return err
+			}
+			logging.Infof("", "Allowed %s until %s", a.Domain, a.Expires.Local().Format(time.DateTime))
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&duration, "for", defaultAllowanceDuration, "How long to allow the domain, e.g. 30m or 2h")
+	cmd.Flags().BoolVar(&list, "list", false, "List the active allowances")
+	cmd.Flags().BoolVar(&revoke, "revoke", false, "Revoke the domain's allowance")
+	cmd.Flags().BoolVar(&clearAll, "clear", false, "Revoke every allowance")
+	cmd.MarkFlagsMutuallyExclusive("list", "revoke", "clear")
+
+	return cmd
+}
+
+// listAllowances prints the active allowances in store.
+func listAllowances(store *allowance.Store) error {
+	allowances, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if len(allowances) == 0 {
+		fmt.Println("No active allowances")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tEXPIRES\tREMAINING")
+	for _, a := range allowances {
+		remaining := time.Until(a.Expires).Round(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Domain, a.Expires.Local().Format(time.DateTime), remaining)
+	}
+	return tw.Flush()
+}
+
+// allowanceOptions returns the option that applies fence allow's
+// allowances, or none if the store cannot be found.
+func allowanceOptions() []sandbox.Option {
+	store, err := allowance.DefaultStore()
+	if err != nil {
+		logging.Debugf("", "Temporary allowances are off: %v", err)
+		return nil
+	}
+	return []sandbox.Option{sandbox.WithAllowances(allowance.NewWatcher(store))}
+}
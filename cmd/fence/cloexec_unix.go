@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// closeOnExec keeps fd from being inherited by child processes.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
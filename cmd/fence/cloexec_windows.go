@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// closeOnExec does nothing on Windows, where handles are not inherited by default.
+func closeOnExec(fd int) {}
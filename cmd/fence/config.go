@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/history"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/managed"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/templates"
+	"github.com/spf13/cobra"
+)
+
+// loadConfigLayers resolves the config layers for the given template name or
+// settings path, base first, under the managed config policyURL names, if
+// any. Precedence: template > settings file > default path. Falls back to
+// the default config (block all network) if no file is found. Config files
+// must be signed if the trust file requires it.
+func loadConfigLayers(templateName, settingsPath string) ([]config.Layer, error) {
+	layers, err := resolveConfigLayers(templateName, settingsPath)
+	if err != nil {
+		return nil, err
+	}
+	trust, err := config.LoadTrust(config.DefaultTrustPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := trust.VerifyLayers(layers); err != nil {
+		return nil, err
+	}
+	cache, err := managed.DefaultCache()
+	if err != nil {
+		return nil, err
+	}
+	return managed.Layers(context.Background(), cache, layers)
+}
+
+// cliSource labels the config layer the command-line flags set.
+const cliSource = "cli"
+
+// addConfigFlags adds the flags that override config settings: the resource
+// limits, --private-home, and --supervisor.
+func addConfigFlags(cmd *cobra.Command) {
+	addResourceFlags(cmd)
+	cmd.Flags().BoolVar(&privateHome, "private-home", false, "Linux: replace $HOME with an empty directory, so only the allowWrite paths and the working directory in it are visible (sets filesystem.privateHome)")
+	cmd.Flags().StringVar(&supervisorURL, "supervisor", "", "Stream the run's violations and heartbeats to this wss:// supervisor (or ws:// on localhost), which can push network policy and stop the command (sets supervisor.url)")
+}
+
+// withCLILayer returns layers followed by a layer labeled cliSource with the
+// settings the flags addConfigFlags adds override, if any are given.
+func withCLILayer(cmd *cobra.Command, layers []config.Layer) ([]config.Layer, error) {
+	cfg := &config.Config{}
+	if err := applyResourceFlags(cmd, cfg); err != nil {
+		return nil, err
+	}
+	cfg.Filesystem.PrivateHome = privateHome
+	cfg.Supervisor.URL = supervisorURL
+	if cfg.Resources == (config.ResourcesConfig{}) && !cfg.Filesystem.PrivateHome && cfg.Supervisor.URL == "" {
+		return layers, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return append(layers, config.Layer{Source: cliSource, Config: cfg}), nil
+}
+
+// resolveConfigLayers resolves the local config layers for loadConfigLayers.
+func resolveConfigLayers(templateName, settingsPath string) ([]config.Layer, error) {
+	switch {
+	case templateName != "":
+		layers, err := templates.LoadLayers(templateName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load template: %w\nUse --list-templates to see available templates", err)
+		}
+		if debug {
+			logging.Debugf("", "Using template: %s", templateName)
+		}
+		return layers, nil
+	case settingsPath != "":
+		cfg, err := config.Load(settingsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		absPath, _ := filepath.Abs(settingsPath)
+		layers, err := templates.ResolveLayersWithBaseDir(cfg, absPath, filepath.Dir(absPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve extends: %w", err)
+		}
+		return layers, nil
+	default:
+		configPath := config.DefaultConfigPath()
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		if cfg == nil {
+			if debug {
+				logging.Debugf("", "No config found at %s, using default (block all network)", configPath)
+			}
+			return []config.Layer{{Source: "default", Config: config.Default()}}, nil
+		}
+		layers, err := templates.ResolveLayersWithBaseDir(cfg, configPath, filepath.Dir(configPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve extends: %w", err)
+		}
+		return layers, nil
+	}
+}
+
+// newConfigCmd creates the config subcommand.
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect fence configuration",
+	}
+	cmd.AddCommand(newConfigImpactCmd())
+	cmd.AddCommand(newConfigKeygenCmd())
+	cmd.AddCommand(newConfigShowCmd())
+	cmd.AddCommand(newConfigSignCmd())
+	cmd.AddCommand(newConfigVerifyCmd())
+	return cmd
+}
+
+// newConfigShowCmd creates the config show subcommand.
+func newConfigShowCmd() *cobra.Command {
+	var (
+		showSettings string
+		showTemplate string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the effective merged configuration",
+		Long: `Print the fully resolved configuration that fence would use, after applying
+defaults, templates, "extends" chains, the user config file, and the flags
+that override config settings (--memory, --cpus, --pids-max, --private-home,
+and --supervisor), which take the same values as when running a command.
+
+Each rule is annotated with a trailing comment naming the layer it came from:
+  default           Built-in default config (no config file found)
+  template:<name>   A built-in template (directly or via "extends")
+  policy:<url>      The managed config policyURL names
+  <path>            A config file (--settings, ~/.fence.json, or an extended file)
+  cli               A command-line flag
+
+The output is JSONC and can be saved and used as a settings file.
+
+Examples:
+  fence config show
+  fence config show --settings ./fence.json
+  fence config show -t code
+  fence config show --memory 2G --private-home`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(showTemplate, showSettings)
+			if err != nil {
+				return err
+			}
+			if layers, err = withCLILayer(cmd, layers); err != nil {
+				return err
+			}
+
+			data, err := config.MarshalAnnotated(layers)
+			if err != nil {
+				return fmt.Errorf("failed to render config: %w", err)
+			}
+			fmt.Print(string(data))
+
+			merged := config.MergeLayers(layers)
+			if merged.Command.UseDefaultDeniedCommands() {
+				fmt.Println()
+				fmt.Println(`// Built-in command deny list (disable with "command": {"useDefaults": false}):`)
+				for _, c := range config.DefaultDeniedCommands {
+					fmt.Printf("//   %s\n", c)
+				}
+				for _, r := range config.DefaultDeniedCommandFlags {
+					fmt.Printf("//   %s\n", r)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&showSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&showTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	addConfigFlags(cmd)
+
+	return cmd
+}
+
+// newConfigImpactCmd creates the config impact subcommand.
+func newConfigImpactCmd() *cobra.Command {
+	var (
+		sessions string
+		asJSON   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "impact <current> <proposed>",
+		Short: "Show what a config change would block or allow in recorded runs",
+		Long: `Replay the runs recorded with fence --record against two settings files, and
+report the operations the proposed config would block that the current one
+allows, and the other way round. Nothing is executed.
+
+The runs record the hosts the proxies were asked to connect to, the commands
+fence ran, and, as with --changes, the files the commands created, modified,
+or deleted under filesystem.allowWrite. Reads and the commands' own child
+processes are not recorded, so they are not replayed.
+
+--sessions selects the runs:
+  all        Every recorded run (the last 100 are kept)
+  last:N     The N most recent runs
+  ID,ID...   The runs with these IDs (the file names in ~/.fence/history)
+
+Exits with status 1 if the proposed config blocks anything the current one allows.
+
+Examples:
+  fence --record -- npm test
+  fence config impact ~/.fence.json ./fence.proposed.json
+  fence config impact --sessions last:10 old.json new.json
+  fence config impact --json old.json new.json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var evaluators []history.Evaluator
+			for _, path := range args {
+				layers, err := loadConfigLayers("", path)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				evaluators = append(evaluators, configEvaluator(config.MergeLayers(layers)))
+			}
+
+			store, err := history.DefaultStore()
+			if err != nil {
+				return err
+			}
+			runs, err := store.Select(sessions)
+			if err != nil {
+				return err
+			}
+
+			impact := history.Analyze(runs, evaluators[0], evaluators[1])
+			if asJSON {
+				err = impact.WriteJSON(os.Stdout)
+			} else {
+				err = impact.WriteReport(os.Stdout)
+			}
+			if err != nil {
+				return err
+			}
+			if len(impact.NewlyBlocked) > 0 {
+				exitCode = 1
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&sessions, "sessions", "all", "Recorded runs to replay: all, last:N, or a comma-separated list of run IDs")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Write the result as JSON")
+
+	return cmd
+}
+
+// configEvaluator returns a history.Evaluator that decides operations as
+// fence explain does under cfg. Filesystem operations are writes.
+func configEvaluator(cfg *config.Config) history.Evaluator {
+	return func(kind, target string) policy.Decision {
+		switch kind {
+		case policy.KindNetwork:
+			host, _, err := net.SplitHostPort(target)
+			if err != nil {
+				host = target
+			}
+			return policy.EvaluateDomain(cfg, host)
+		case policy.KindCommand:
+			return sandbox.EvaluateCommand(target, cfg)
+		default:
+			return sandbox.EvaluatePath(target, true, cfg)
+		}
+	}
+}
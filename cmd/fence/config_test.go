@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func TestWithCLILayer(t *testing.T) {
+	base := []config.Layer{{Source: "default", Config: config.Default()}}
+	newCmd := func(args ...string) *cobra.Command {
+		t.Helper()
+		t.Cleanup(func() { memoryLimit, cpuLimit, pidsLimit, privateHome, supervisorURL = "", 0, 0, false, "" })
+		cmd := &cobra.Command{}
+		addConfigFlags(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	layers, err := withCLILayer(newCmd(), base)
+	if err != nil || len(layers) != 1 {
+		t.Errorf("withCLILayer() without flags = %v, %v; want the layers unchanged", layers, err)
+	}
+
+	layers, err = withCLILayer(newCmd("--memory", "512M", "--private-home"), base)
+	if err != nil {
+		t.Fatalf("withCLILayer() error = %v", err)
+	}
+	if len(layers) != 2 || layers[1].Source != cliSource {
+		t.Fatalf("withCLILayer() = %v, want a %s layer last", layers, cliSource)
+	}
+	cfg := config.MergeLayers(layers)
+	if cfg.Resources.Memory != 512<<20 || !cfg.Filesystem.PrivateHome {
+		t.Errorf("merged config has memory %d, privateHome %v; want the flags' values", cfg.Resources.Memory, cfg.Filesystem.PrivateHome)
+	}
+
+	if _, err := withCLILayer(newCmd("--cpus", "-1"), base); err == nil {
+		t.Error("withCLILayer() with --cpus -1 should fail")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Use-Tusk/fence/internal/cow"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// cowWorkspace returns the directory --cow mounts the overlay over: the
+// current directory, with symlinks resolved as the mounts need.
+func cowWorkspace() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(cwd)
+}
+
+// cowOption opens the current directory's copy-on-write layer and returns
+// the sandbox option that mounts it.
+func cowOption() (sandbox.Option, *cow.Layer, error) {
+	if platform.Detect() != platform.Linux {
+		return nil, nil, errors.New("--cow is only supported on Linux")
+	}
+	workspace, err := cowWorkspace()
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := cow.DefaultStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	layer, err := store.Open(workspace)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sandbox.WithCopyOnWrite(sandbox.WorkspaceOverlay{Dir: workspace, Upper: layer.Upper(), Work: layer.Work()}), layer, nil
+}
+
+// printCowSummary tells the user where a --cow run's changes went.
+func printCowSummary(layer *cow.Layer) {
+	changes, err := layer.Changes()
+	if err != nil {
+		logging.Warnf("", "%v", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	logging.Infof("", "%d change(s) kept in the copy-on-write layer: review them with \"fence diff\", apply them with \"fence commit\"", len(changes))
+}
+
+// findCowLayer returns the copy-on-write layer of the current directory.
+func findCowLayer() (*cow.Layer, error) {
+	workspace, err := cowWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	store, err := cow.DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	layer, err := store.Find(workspace)
+	if errors.Is(err, cow.ErrNoLayer) {
+		return nil, fmt.Errorf("%w (run a command with fence --cow first)", err)
+	}
+	return layer, err
+}
+
+// newDiffCmd creates the diff subcommand.
+func newDiffCmd() *cobra.Command {
+	var nameStatus bool
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show the changes fence --cow kept for the current directory",
+		Long: `Show the changes that commands run with fence --cow made to the current
+directory, which are kept in a copy-on-write layer instead of the directory
+itself. The changes are shown as a unified diff, or with --name-status as
+one line per path: A (added), M (modified), or D (deleted).
+
+Examples:
+  fence --cow -- npm install
+  fence diff
+  fence diff --name-status`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layer, err := findCowLayer()
+			if err != nil {
+				return err
+			}
+			changes, err := layer.Changes()
+			if err != nil {
+				return err
+			}
+			if nameStatus {
+				return cow.WriteStatus(os.Stdout, changes)
+			}
+			return layer.WriteDiff(os.Stdout, changes)
+		},
+	}
+
+	cmd.Flags().BoolVar(&nameStatus, "name-status", false, "List the changed paths instead of showing a diff")
+	return cmd
+}
+
+// newCommitCmd creates the commit subcommand.
+func newCommitCmd() *cobra.Command {
+	var discard bool
+
+	cmd := &cobra.Command{
+		Use:   "commit",
+		Short: "Apply the changes fence --cow kept to the current directory",
+		Long: `Apply the changes that commands run with fence --cow made to the current
+directory, then remove the copy-on-write layer. With --discard, remove the
+layer without applying them. Do not commit while a --cow command is still
+running in the directory.
+
+Examples:
+  fence commit
+  fence commit --discard`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layer, err := findCowLayer()
+			if err != nil {
+				return err
+			}
+			changes, err := layer.Changes()
+			if err != nil {
+				return err
+			}
+			if discard {
+				if err := layer.Discard(); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Discarded %d change(s)\n", len(changes))
+				return nil
+			}
+			if err := layer.Commit(); err != nil {
+				return err
+			}
+			_ = cow.WriteStatus(os.Stdout, changes)
+			fmt.Fprintf(os.Stderr, "Applied %d change(s) to %s\n", len(changes), layer.Workspace)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&discard, "discard", false, "Drop the changes instead of applying them")
+	return cmd
+}
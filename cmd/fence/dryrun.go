@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// bwrapOperands maps bwrap options used by fence to their operand count, so
+// the argument list can be printed one option per line.
+var bwrapOperands = map[string]int{
+	"--bind":     2,
+	"--ro-bind":  2,
+	"--dev-bind": 2,
+	"--tmpfs":    1,
+	"--proc":     1,
+	"--dev":      1,
+	"--seccomp":  1,
+	"--uid":      1,
+	"--gid":      1,
+	"-p":         1, // aa-exec
+	"-t":         1, // runcon
+}
+
+// printSpec writes the sandbox spec for a dry run.
+func printSpec(w io.Writer, spec *sandbox.Spec) {
+	fmt.Fprintf(w, "# fence dry run (%s)\n", spec.Platform)
+	fmt.Fprintf(w, "# command: %s\n", spec.Command)
+
+	if len(spec.BwrapArgs) > 0 {
+		fmt.Fprintf(w, "\n## bwrap arguments\n")
+		script := printBwrapArgs(w, spec.BwrapArgs)
+		if script != "" {
+			fmt.Fprintf(w, "\n## inner script\n%s\n", strings.TrimSpace(script))
+		}
+	}
+
+	if spec.BwrapArgs != nil {
+		fmt.Fprintf(w, "\n## seccomp\n")
+		if len(spec.SeccompSyscalls) == 0 {
+			fmt.Fprintf(w, "not applied\n")
+		} else if spec.SeccompAllowlist {
+			fmt.Fprintf(w, "allowed syscalls (%d): %s\n", len(spec.SeccompSyscalls), strings.Join(spec.SeccompSyscalls, " "))
+			fmt.Fprintf(w, "other syscalls: %s\n", spec.SeccompAction)
+		} else {
+			fmt.Fprintf(w, "blocked syscalls (%d): %s\n", len(spec.SeccompSyscalls), strings.Join(spec.SeccompSyscalls, " "))
+			fmt.Fprintf(w, "action: %s\n", spec.SeccompAction)
+		}
+
+		fmt.Fprintf(w, "\n## landlock\n")
+		if len(spec.LandlockRules) == 0 {
+			fmt.Fprintf(w, "not applied\n")
+		}
+		for _, rule := range spec.LandlockRules {
+			fmt.Fprintf(w, "%-10s  %s\n", rule.Access(), rule.Path)
+		}
+		if net := spec.LandlockNet; net != nil {
+			if net.BindPorts != nil {
+				fmt.Fprintf(w, "%-10s  tcp ports %s\n", "bind", formatPorts(net.BindPorts))
+			}
+			if net.ConnectPorts != nil {
+				fmt.Fprintf(w, "%-10s  tcp ports %s\n", "connect", formatPorts(net.ConnectPorts))
+			}
+		}
+	}
+
+	if spec.LSM != "" {
+		fmt.Fprintf(w, "\n## lsm layer\n%s", spec.LSM)
+		if spec.SELinuxLevel != "" {
+			fmt.Fprintf(w, " (level %s, chosen for each run)", spec.SELinuxLevel)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if spec.AppArmorProfile != "" {
+		fmt.Fprintf(w, "\n## apparmor profile\n%s\n", strings.TrimSpace(spec.AppArmorProfile))
+	}
+
+	if spec.SELinuxModule != "" {
+		fmt.Fprintf(w, "\n## selinux module\n%s\n", strings.TrimSpace(spec.SELinuxModule))
+	}
+
+	if spec.Cgroup != "" {
+		fmt.Fprintf(w, "\n## cgroup\n%s\n", spec.Cgroup)
+		for _, limit := range spec.CgroupLimits {
+			fmt.Fprintf(w, "  %s\n", limit)
+		}
+	}
+
+	if len(spec.Env) > 0 {
+		fmt.Fprintf(w, "\n## environment\n%s\n", strings.Join(spec.Env, "\n"))
+	}
+
+	if spec.SeatbeltProfile != "" {
+		fmt.Fprintf(w, "\n## sandbox-exec profile\n%s\n", strings.TrimSpace(spec.SeatbeltProfile))
+	}
+}
+
+// printBwrapArgs prints bwrap options one per line and returns the inner
+// script passed to the shell after "--", which is printed separately.
+func printBwrapArgs(w io.Writer, args []string) string {
+	fmt.Fprintf(w, "%s\n", args[0])
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--" {
+			rest := args[i+1:]
+			if len(rest) == 0 {
+				break
+			}
+			script := rest[len(rest)-1]
+			fmt.Fprintf(w, "  -- %s <inner script>\n", sandbox.ShellQuote(rest[:len(rest)-1]))
+			return script
+		}
+
+		n := bwrapOperands[args[i]]
+		end := min(i+1+n, len(args))
+		fmt.Fprintf(w, "  %s\n", sandbox.ShellQuote(args[i:end]))
+		i = end - 1
+	}
+	return ""
+}
+
+// formatPorts lists ports for the dry run, or "none".
+func formatPorts(ports []int) string {
+	if len(ports) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+	return strings.Join(parts, " ")
+}
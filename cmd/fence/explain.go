@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// newExplainCmd creates the explain subcommand.
+func newExplainCmd() *cobra.Command {
+	var (
+		explainSettings string
+		explainTemplate string
+		write           bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "explain <kind>:<value>...",
+		Short: "Explain whether a domain, path, or command is allowed",
+		Long: `Evaluate the loaded config and report whether a domain, path, or command
+would be allowed, and the exact rule that decides it. Nothing is executed.
+
+Queries:
+  domain:<host>      Network access through the proxy
+  path:<path>        Filesystem read access (write access with --write)
+  cmd:<command>      Command policy (chains and nested shells are checked)
+
+Exits with status 1 if any query is denied.
+
+Examples:
+  fence explain domain:api.github.com
+  fence explain path:/etc/passwd
+  fence explain --write path:./node_modules
+  fence explain cmd:"git push origin main"
+  fence explain -t code domain:statsig.anthropic.com`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(explainTemplate, explainSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+
+			denied := false
+			for _, arg := range args {
+				kind, value, ok := strings.Cut(arg, ":")
+				if !ok || value == "" {
+					return fmt.Errorf("invalid query %q: expected domain:<host>, path:<path>, or cmd:<command>", arg)
+				}
+
+				var d policy.Decision
+				label := kind
+				switch kind {
+				case "domain":
+					d = policy.EvaluateDomain(cfg, value)
+				case "path":
+					d = sandbox.EvaluatePath(value, write, cfg)
+					if write {
+						label = "path (write)"
+					} else {
+						label = "path (read)"
+					}
+				case "cmd":
+					d = sandbox.EvaluateCommand(value, cfg)
+				default:
+					return fmt.Errorf("unknown query kind %q: expected domain, path, or cmd", kind)
+				}
+
+				printDecision(label, value, d)
+				if !d.Allowed {
+					denied = true
+				}
+			}
+
+			if denied {
+				exitCode = 1
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&explainSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&explainTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "Evaluate write access for path queries")
+
+	return cmd
+}
+
+// printDecision prints a single explain result.
+func printDecision(label, value string, d policy.Decision) {
+	icon := output.Render(output.Allowed)
+	switch {
+	case d.Warn:
+		icon = output.Render(output.Warning)
+	case !d.Allowed:
+		icon = output.Render(output.Blocked)
+	}
+	fmt.Printf("%s %s %s: %s\n", icon, label, value, d.Verdict())
+	if d.Rule != "" {
+		fmt.Printf("    rule:   %s\n", d.Rule)
+	}
+	fmt.Printf("    reason: %s\n", d.Reason)
+}
@@ -0,0 +1,13 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
+
+// handleStops does nothing on macOS, which cannot collect a child's stops
+// without also reaping it: a command stopped with Ctrl-Z stays stopped while
+// fence waits, so use --tty for interactive programs that may be suspended.
+func (j *job) handleStops() {}
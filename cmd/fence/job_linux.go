@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
+
+// handleStops suspends fence whenever the command is stopped, by Ctrl-Z or
+// by reading the terminal in the background, until the command exits.
+func (j *job) handleStops() {
+	children := make(chan os.Signal, 1)
+	signal.Notify(children, syscall.SIGCHLD)
+	defer signal.Stop(children)
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-children:
+		}
+		// Only stops are collected here; Wait still reaps the command
+		var info unix.Siginfo
+		err := unix.Waitid(unix.P_PID, j.cmd.Process.Pid, &info, unix.WSTOPPED|unix.WNOHANG, nil)
+		if err == nil && info.Signo == int32(syscall.SIGCHLD) {
+			j.suspend()
+		}
+	}
+}
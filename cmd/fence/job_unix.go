@@ -0,0 +1,189 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// ptyDrainTimeout bounds how long output still buffered in the PTY is copied
+// after the command exits, in case something it started keeps the PTY open.
+const ptyDrainTimeout = time.Second
+
+// job is the sandboxed command running in its own process group, so that
+// signals reach everything it started and not just the shell that wraps it.
+// When fence is the terminal's foreground job, the group takes the terminal
+// over, as a shell does for a job. With usePTY, the command instead runs in
+// a session of its own on a new PTY, which fence connects to its stdio.
+type job struct {
+	cmd        *exec.Cmd
+	tty        int  // fence's stdin
+	foreground bool // The group was given the terminal
+	pty        *os.File
+	saved      *unix.Termios // fence's terminal settings before raw mode
+	output     chan struct{} // Closed when the PTY output is copied
+	done       chan struct{} // Closed when the command has exited
+}
+
+// startJob starts cmd in its own process group, or on a new PTY.
+func startJob(cmd *exec.Cmd, usePTY bool) (*job, error) {
+	j := &job{cmd: cmd, tty: int(os.Stdin.Fd()), done: make(chan struct{})}
+	if usePTY {
+		if err := j.startPTY(); err != nil {
+			return nil, err
+		}
+		return j, nil
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if fg, err := unix.IoctlGetInt(j.tty, unix.TIOCGPGRP); err == nil && fg == syscall.Getpgrp() {
+		j.foreground = true
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0 // The command's stdin
+	}
+	if err := cmd.Start(); err != nil {
+		j.takeTerminal()
+		return nil, err
+	}
+	if j.foreground {
+		go j.handleStops()
+	}
+	go j.forwardResizes()
+	return j, nil
+}
+
+func (j *job) startPTY() error {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open a PTY: %w", err)
+	}
+	defer func() { _ = slave.Close() }()
+
+	j.cmd.Stdin, j.cmd.Stdout, j.cmd.Stderr = slave, slave, slave
+	// The command leads a new session with the PTY as its controlling
+	// terminal, so its process group is the PTY's foreground group
+	j.cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := j.cmd.Start(); err != nil {
+		_ = master.Close()
+		return err
+	}
+	j.pty = master
+	j.resize()
+	go j.forwardResizes()
+
+	// Keystrokes, Ctrl-C included, go to the PTY as is
+	if t, err := unix.IoctlGetTermios(j.tty, ioctlGetTermios); err == nil {
+		j.saved = t
+		raw := *t
+		raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		raw.Oflag &^= unix.OPOST
+		raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		raw.Cflag &^= unix.CSIZE | unix.PARENB
+		raw.Cflag |= unix.CS8
+		raw.Cc[unix.VMIN] = 1
+		raw.Cc[unix.VTIME] = 0
+		_ = unix.IoctlSetTermios(j.tty, ioctlSetTermios, &raw)
+	}
+
+	j.output = make(chan struct{})
+	go func() { _, _ = io.Copy(master, os.Stdin) }()
+	go func() {
+		defer close(j.output)
+		_, _ = io.Copy(os.Stdout, master)
+	}()
+	return nil
+}
+
+// signal sends sig to the command's process group.
+func (j *job) signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return j.cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-j.cmd.Process.Pid, s)
+}
+
+// forwardResizes calls resize on every SIGWINCH until the command exits.
+func (j *job) forwardResizes() {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-winch:
+			j.resize()
+		}
+	}
+}
+
+// resize handles SIGWINCH: it gives the PTY fence's terminal size, which
+// signals the command, or passes the signal on to a command without a PTY.
+func (j *job) resize() {
+	if j.pty == nil {
+		_ = j.signal(syscall.SIGWINCH)
+		return
+	}
+	_ = pty.InheritSize(os.Stdin, j.pty)
+}
+
+// wait waits for the command to exit and gives fence its terminal back.
+func (j *job) wait() error {
+	err := j.cmd.Wait()
+	close(j.done)
+	if j.pty != nil {
+		select {
+		case <-j.output:
+		case <-time.After(ptyDrainTimeout):
+		}
+		_ = j.pty.Close()
+		if j.saved != nil {
+			_ = unix.IoctlSetTermios(j.tty, ioctlSetTermios, j.saved)
+		}
+	}
+	j.takeTerminal()
+	return err
+}
+
+// takeTerminal makes fence's process group the terminal's foreground group
+// again, if it gave the terminal to the command.
+func (j *job) takeTerminal() {
+	if !j.foreground {
+		return
+	}
+	// A background process setting the foreground group gets SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	_ = unix.IoctlSetPointerInt(j.tty, unix.TIOCSPGRP, syscall.Getpgrp())
+}
+
+// giveTerminal makes the command's process group the terminal's foreground
+// group, if fence is in the foreground.
+func (j *job) giveTerminal() {
+	if fg, err := unix.IoctlGetInt(j.tty, unix.TIOCGPGRP); err != nil || fg != syscall.Getpgrp() {
+		return
+	}
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	_ = unix.IoctlSetPointerInt(j.tty, unix.TIOCSPGRP, j.cmd.Process.Pid)
+}
+
+// suspend stops fence along with the command, which Ctrl-Z stopped, so the
+// shell sees its job stopped. When the shell resumes fence, the command gets
+// the terminal back and is resumed too.
+func (j *job) suspend() {
+	j.takeTerminal()
+	_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	j.giveTerminal()
+	_ = j.signal(syscall.SIGCONT)
+}
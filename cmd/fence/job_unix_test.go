@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestTimeoutExitCode(t *testing.T) {
+	// The command exits 0 on SIGTERM, as a timeout stops it
+	cmd := exec.Command("sh", "-c", `trap 'exit 0' TERM; echo ready; while :; do sleep 0.1; done`)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := startJob(cmd, false)
+	if err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatalf("waiting for the command: %v", err)
+	}
+	if err := j.signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+	waitErr := j.wait()
+	if waitErr != nil {
+		t.Fatalf("expected the command to exit 0, got %v", waitErr)
+	}
+
+	noLimit := func() int { return 0 }
+	if code, err := commandExitCode(waitErr, noLimit, true); err != nil || code != exitTimeout {
+		t.Errorf("commandExitCode after a timeout = %d, %v; want %d", code, err, exitTimeout)
+	}
+	if code, err := commandExitCode(waitErr, noLimit, false); err != nil || code != 0 {
+		t.Errorf("commandExitCode without a timeout = %d, %v; want 0", code, err)
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	if code, err := commandExitCode(exitErr, func() int { return 0 }, false); err != nil || code != 3 {
+		t.Errorf("commandExitCode = %d, %v; want 3", code, err)
+	}
+	if code, _ := commandExitCode(exitErr, func() int { return exitMemoryLimit }, true); code != exitMemoryLimit {
+		t.Errorf("commandExitCode with a limit = %d, want %d", code, exitMemoryLimit)
+	}
+	if _, err := commandExitCode(exec.ErrNotFound, func() int { return 0 }, false); err == nil {
+		t.Error("expected an error that is not an exit status to be returned")
+	}
+}
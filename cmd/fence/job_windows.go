@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// job is the sandboxed command. Windows has no process groups, so signals
+// reach the command alone.
+type job struct {
+	cmd *exec.Cmd
+}
+
+// startJob starts cmd. PTYs are not supported on Windows.
+func startJob(cmd *exec.Cmd, usePTY bool) (*job, error) {
+	if usePTY {
+		return nil, errors.New("--tty is not supported on Windows")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &job{cmd: cmd}, nil
+}
+
+// signal sends sig to the command.
+func (j *job) signal(sig os.Signal) error {
+	return j.cmd.Process.Signal(sig)
+}
+
+// wait waits for the command to exit.
+func (j *job) wait() error {
+	return j.cmd.Wait()
+}
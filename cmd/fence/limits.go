@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses for a command stopped by a resource limit or the disk write
+// quota. They are above the 128+signal range shells report, so a plain kill
+// or crash of the command is not mistaken for one.
+const (
+	exitMemoryLimit = 250
+	exitPidsLimit   = 251
+	exitWriteQuota  = 252
+)
+
+// exitTimeout is the exit status for a command killed by --timeout, as with
+// timeout(1).
+const exitTimeout = 124
+
+var (
+	memoryLimit string
+	cpuLimit    float64
+	pidsLimit   int
+)
+
+// addResourceFlags adds the flags that override the resources config.
+func addResourceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&memoryLimit, "memory", "", "Limit the command's memory, e.g. 512M or 2G (Linux, cgroup v2)")
+	cmd.Flags().Float64Var(&cpuLimit, "cpus", 0, "Limit the command's CPU time to this many cores, e.g. 1.5 (Linux, cgroup v2)")
+	cmd.Flags().IntVar(&pidsLimit, "pids-max", 0, "Limit the number of processes and threads the command can run (Linux, cgroup v2)")
+}
+
+// applyResourceFlags sets the resource limits given on the command line in
+// cfg, which the caller validates.
+func applyResourceFlags(cmd *cobra.Command, cfg *config.Config) error {
+	if cmd.Flags().Changed("memory") {
+		n, err := config.ParseSize(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid --memory: %w", err)
+		}
+		cfg.Resources.Memory = n
+	}
+	if cmd.Flags().Changed("cpus") {
+		cfg.Resources.CPUs = cpuLimit
+	}
+	if cmd.Flags().Changed("pids-max") {
+		cfg.Resources.PidsMax = pidsLimit
+	}
+	return nil
+}
+
+// limitExitCode reports a command that failed because of a resource limit or
+// the disk write quota to w and returns its exit status, or 0 if no limit was
+// involved.
+func limitExitCode(w io.Writer, events sandbox.LimitEvents, cfg *config.Config) int {
+	switch {
+	case events.WriteQuotaExceeded:
+		fmt.Fprintf(w, "[fence] Command killed: exceeded the disk write quota (%s)\n", formatSize(cfg.Filesystem.MaxWriteBytes))
+		return exitWriteQuota
+	case events.OOMKills > 0:
+		fmt.Fprintf(w, "[fence] Command killed: exceeded the memory limit (%s)\n", formatSize(cfg.Resources.Memory))
+		return exitMemoryLimit
+	case events.PidsLimited > 0:
+		fmt.Fprintf(w, "[fence] Command failed after reaching the process limit (%d)\n", cfg.Resources.PidsMax)
+		return exitPidsLimit
+	}
+	return 0
+}
+
+// commandExitCode returns the exit status for a command whose wait returned
+// err: the status from limitCode if the command failed on a resource limit,
+// exitTimeout if --timeout stopped it, even if it then exited successfully,
+// or else the command's own. Errors other than the command's exit are
+// returned.
+func commandExitCode(err error, limitCode func() int, timedOut bool) (int, error) {
+	code := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 0, err
+		}
+		code = exitErr.ExitCode()
+		if limit := limitCode(); limit != 0 {
+			return limit, nil
+		}
+	}
+	if timedOut {
+		return exitTimeout, nil
+	}
+	return code, nil
+}
+
+// formatSize formats a byte count in the largest unit that divides it.
+func formatSize(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if n >= u.size && n%u.size == 0 {
+			return fmt.Sprintf("%d%s", n/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// Set by the --log-level and --log-format flags.
+var (
+	logLevel  string
+	logFormat string
+)
+
+// configureLogging sets the level and format of fence's own log messages.
+// The flags win over cfg's logging settings; cfg may be nil before the
+// config is loaded. Without a level, fence logs notices, warnings, and
+// errors, plus debug messages if *debugFlag is set, or only warnings and
+// errors with -q. A debug level sets *debugFlag, as -d would.
+func configureLogging(cfg *config.Config, debugFlag *bool) error {
+	level, format := logLevel, logFormat
+	if cfg != nil {
+		if level == "" {
+			level = cfg.Logging.Level
+		}
+		if format == "" {
+			format = cfg.Logging.Format
+		}
+	}
+
+	log := logging.Default()
+	if format != "" {
+		if err := log.SetFormat(format); err != nil {
+			return err
+		}
+	}
+	switch {
+	case level != "":
+		l, err := logging.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		log.SetLevel(l)
+		if l == logging.LevelDebug && debugFlag != nil {
+			*debugFlag = true
+		}
+	case debugFlag != nil && *debugFlag:
+		log.SetLevel(logging.LevelDebug)
+	case quiet:
+		log.SetLevel(logging.LevelWarn)
+	default:
+		log.SetLevel(logging.LevelInfo)
+	}
+	return nil
+}
@@ -2,20 +2,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
-	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/cow"
+	"github.com/Use-Tusk/fence/internal/history"
 	"github.com/Use-Tusk/fence/internal/importer"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
 	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
 	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/stats"
+	"github.com/Use-Tusk/fence/internal/telemetry"
 	"github.com/Use-Tusk/fence/internal/templates"
 	"github.com/spf13/cobra"
 )
@@ -30,6 +41,7 @@ var (
 var (
 	debug         bool
 	monitor       bool
+	quiet         bool
 	settingsPath  string
 	templateName  string
 	listTemplates bool
@@ -38,6 +50,37 @@ var (
 	exitCode      int
 	showVersion   bool
 	linuxFeatures bool
+	dryRun        bool
+	audit         bool
+	reportPath    string
+	execLogPath   string
+	backend       string
+	monitorFormat string
+	monitorFD     int
+	monitorES     bool
+
+	noNetworkSandbox bool
+	networkOnly      bool
+	timeout          time.Duration
+	killAfter        time.Duration
+	usePTY           bool
+	runAsUser        string
+	cowMode          bool
+	privateHome      bool
+	showChanges      bool
+	changesPath      string
+	recordRun        bool
+	supervisorURL    string
+	colorMode        string
+
+	policyPlugin        string
+	policyPluginTimeout time.Duration
+)
+
+// Formats for --monitor-format.
+const (
+	monitorFormatText   = "text"
+	monitorFormatNDJSON = "ndjson"
 )
 
 func main() {
@@ -47,6 +90,24 @@ func main() {
 		runLandlockWrapper()
 		return
 	}
+	// Check for internal --exec-guard mode (started by the wrapper)
+	if len(os.Args) >= 2 && os.Args[1] == sandbox.ExecGuardFlag {
+		os.Exit(sandbox.RunExecGuardChild(os.Args[2:]))
+	}
+	// Check for internal --bridge mode (the sandbox's end of the proxy bridges)
+	if len(os.Args) >= 2 && os.Args[1] == sandbox.BridgeHelperFlag {
+		os.Exit(runBridgeHelper(os.Args[2:]))
+	}
+	// Check for internal native backend modes (the sandbox itself)
+	if len(os.Args) >= 2 && os.Args[1] == sandbox.NativeSandboxFlag {
+		os.Exit(sandbox.RunNativeSandbox(os.Args[2:]))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == sandbox.NativeSandboxInitFlag {
+		os.Exit(sandbox.RunNativeSandboxInit(os.Args[2:]))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == sandbox.GVisorSandboxFlag {
+		os.Exit(sandbox.RunGVisorSandbox(os.Args[2:]))
+	}
 
 	rootCmd := &cobra.Command{
 		Use:   "fence [flags] -- [command...]",
@@ -66,7 +127,26 @@ Examples:
   fence -t npm-install npm install        # Use built-in npm-install template
   fence -t ai-coding-agents -- agent-cmd  # Use AI coding agents template
   fence -p 3000 -c "npm run dev"          # Expose port 3000 for inbound connections
+  fence --dry-run -- npm install          # Print the sandbox spec without running
+  fence --cow -- npm install              # Linux: keep changes aside; see fence diff, fence commit
+  fence --private-home -- npm test        # Linux: hide the dotfiles in $HOME
+  fence --backend native -- npm test      # Linux: sandbox without bubblewrap
+  fence --backend gvisor -- npm test      # Linux: run under gVisor (runsc)
+  fence --audit -t code -- npm test       # Trial a policy: report what would be blocked
+  fence --report out.json -- npm test     # Also write blocked operations as JSON
+  sudo fence --exec-log execs.ndjson -- agent-cmd  # Log every process the command runs
+  fence --policy-plugin ./approve -- agent-cmd     # Ask ./approve about hosts and commands no rule covers
+  fence --changes -- agent-cmd            # List the files the command changed
+  fence --record -- npm test              # Record the run for fence config impact
+  fence --monitor-format ndjson --monitor-fd 3 -- npm test 3>violations.ndjson
   fence --list-templates                  # Show available built-in templates
+  fence config show                       # Show the effective merged config
+  fence explain domain:api.github.com     # Explain which rule allows/denies a request
+  fence mcp -t code                       # Serve sandboxed tools to MCP clients over stdio
+  fence parallel -j4 --file cmds.txt      # Run commands concurrently in separate sandboxes
+  fence serve -t code                     # Serve an HTTP API for running sandboxed commands
+  fence session start --name ci -t code   # Share one sandbox across "fence session exec" steps
+  fence shell -t code                     # Explore the policy from an interactive shell in the sandbox
 
 Configuration file format (~/.fence.json):
 {
@@ -83,14 +163,27 @@ Configuration file format (~/.fence.json):
     "deny": ["git push", "npm publish"]
   }
 }`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.SetColorMode(colorMode, os.Stderr); err != nil {
+				return err
+			}
+			return configureLogging(nil, nil)
+		},
 		RunE:          runCommand,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Args:          cobra.ArbitraryArgs,
 	}
 
+	rootCmd.PersistentFlags().StringVar(&colorMode, "color", output.ColorAuto, "Color status markers: auto (if stderr is a terminal and NO_COLOR is unset), always, or never")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log messages at this level and above: debug (same as -d), info, warn, or error (sets logging.level)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Log message format: text, or json (one JSON object per message) (sets logging.format)")
 	rootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
 	rootCmd.Flags().BoolVarP(&monitor, "monitor", "m", false, "Monitor and log sandbox violations (macOS: log stream, all: proxy denials)")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only log warnings and errors, and don't print the request and violation totals when the command exits")
+	rootCmd.Flags().StringVar(&monitorFormat, "monitor-format", monitorFormatText, "Monitor output format: text, or ndjson (one JSON object per violation); implies -m")
+	rootCmd.Flags().IntVar(&monitorFD, "monitor-fd", 2, "Write monitor output to this file descriptor instead of stderr; implies -m")
+	rootCmd.Flags().BoolVar(&monitorES, "monitor-es", false, "macOS: log the processes the command runs and the files it creates, modifies, deletes, and renames as they happen, from Endpoint Security (needs root, eslogger from macOS 13, and Full Disk Access)")
 	rootCmd.Flags().StringVarP(&settingsPath, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
 	rootCmd.Flags().StringVarP(&templateName, "template", "t", "", "Use built-in template (e.g., ai-coding-agents, npm-install)")
 	rootCmd.Flags().BoolVar(&listTemplates, "list-templates", false, "List available templates")
@@ -98,10 +191,45 @@ Configuration file format (~/.fence.json):
 	rootCmd.Flags().StringArrayVarP(&exposePorts, "port", "p", nil, "Expose port for inbound connections (can be used multiple times)")
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Show version information")
 	rootCmd.Flags().BoolVar(&linuxFeatures, "linux-features", false, "Show available Linux security features and exit")
-
+	rootCmd.Flags().BoolVar(&audit, "audit", false, "Allow everything the network and command policy would block, and report it at exit")
+	rootCmd.Flags().StringVar(&reportPath, "report", "", "Write the blocked operations to a JSON file when the command exits")
+	rootCmd.Flags().StringVar(&execLogPath, "exec-log", "", "Write every process the command executes, with its arguments and parent PID, to this file as NDJSON (needs root: bpftrace on Linux, eslogger or dtrace on macOS)")
+	rootCmd.Flags().StringVar(&backend, "backend", "", "Linux sandbox backend: bwrap (bubblewrap), native (no external dependencies), gvisor (runsc), apparmor (aa-exec, as root), or selinux (runcon, as root); default bwrap, or apparmor or selinux if bwrap and Landlock are unavailable")
+	addConfigFlags(rootCmd)
+	rootCmd.Flags().BoolVar(&noNetworkSandbox, "no-network-sandbox", false, "Enforce only filesystem and command policy: no proxies, and the command uses the host network")
+	rootCmd.Flags().BoolVar(&networkOnly, "network-only", false, "Enforce only network policy: run the command unsandboxed with HTTP_PROXY and ALL_PROXY set (only clients that honor them are filtered)")
+	rootCmd.Flags().StringVar(&runAsUser, "user", "", "When run as root, switch to this user before loading the config and setting up the sandbox")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", 0, "Kill the command and everything it started if it runs longer than this, e.g. 10m; exits with status 124")
+	rootCmd.Flags().DurationVar(&killAfter, "kill-after", 5*time.Second, "After SIGTERM from --timeout or a forwarded signal, wait this long for the command to exit before sending SIGKILL")
+	rootCmd.Flags().BoolVar(&usePTY, "tty", false, "Run the command on a new pseudo-terminal, with fence's terminal in raw mode")
+	rootCmd.Flags().BoolVar(&showChanges, "changes", false, "Print the files the command created, modified, or deleted under filesystem.allowWrite when it exits")
+	rootCmd.Flags().StringVar(&changesPath, "changes-json", "", "Write the files the command created, modified, or deleted to a JSON file when it exits")
+	rootCmd.Flags().BoolVar(&recordRun, "record", false, "Record the hosts, commands, and file writes of the run in ~/.fence/history, for fence config impact")
+	rootCmd.Flags().StringVar(&policyPlugin, "policy-plugin", "", "Ask this program, over its stdin and stdout as NDJSON, about the hosts and commands no config rule decides; they are denied if it fails to answer")
+	rootCmd.Flags().DurationVar(&policyPluginTimeout, "policy-plugin-timeout", sandbox.DefaultPluginTimeout, "Deny what the policy plugin has not answered about within this long")
+	rootCmd.Flags().BoolVar(&cowMode, "cow", false, "Linux: mount a copy-on-write overlay over the current directory, keeping the command's changes to it for fence diff and fence commit")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the generated sandbox spec (bwrap args, seccomp, Landlock, or macOS profile) without running the command")
+
+	rootCmd.MarkFlagsMutuallyExclusive("no-network-sandbox", "network-only")
+	rootCmd.MarkFlagsMutuallyExclusive("network-only", "dry-run")
+	rootCmd.MarkFlagsMutuallyExclusive("network-only", "cow")
 	rootCmd.Flags().SetInterspersed(true)
 
+	rootCmd.AddCommand(newAllowCmd())
 	rootCmd.AddCommand(newImportCmd())
+	rootCmd.AddCommand(newCommitCmd())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newExplainCmd())
+	rootCmd.AddCommand(newMCPCmd())
+	rootCmd.AddCommand(newTopCmd())
+	rootCmd.AddCommand(newParallelCmd())
+	rootCmd.AddCommand(newProfileCmd())
+	rootCmd.AddCommand(newRedteamCmd())
+	rootCmd.AddCommand(newServeCmd())
+	rootCmd.AddCommand(newSessionCmd())
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newShellCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -129,109 +257,271 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if err := configureLogging(nil, &debug); err != nil {
+		return err
+	}
+
 	var command string
 	switch {
 	case cmdString != "":
 		command = cmdString
 	case len(args) > 0:
-		command = strings.Join(args, " ")
+		// Quote each argument so the sandboxed process gets the exact argv
+		command = sandbox.ShellQuote(args)
 	default:
 		return fmt.Errorf("no command specified. Use -c <command> or provide command arguments")
 	}
 
 	if debug {
-		fmt.Fprintf(os.Stderr, "[fence] Command: %s\n", command)
+		logging.Debugf("", "Command: %s", command)
 	}
 
-	var ports []int
-	for _, p := range exposePorts {
-		port, err := strconv.Atoi(p)
-		if err != nil || port < 1 || port > 65535 {
-			return fmt.Errorf("invalid port: %s", p)
-		}
-		ports = append(ports, port)
+	ports, err := parsePorts(exposePorts)
+	if err != nil {
+		return err
 	}
 
 	if debug && len(ports) > 0 {
-		fmt.Fprintf(os.Stderr, "[fence] Exposing ports: %v\n", ports)
+		logging.Debugf("", "Exposing ports: %v", ports)
 	}
 
-	// Load config: template > settings file > default path
-	var cfg *config.Config
-	var err error
+	if monitorFormat != monitorFormatText && monitorFormat != monitorFormatNDJSON {
+		return fmt.Errorf("invalid --monitor-format %q (valid: %s, %s)", monitorFormat, monitorFormatText, monitorFormatNDJSON)
+	}
+	// Choosing how or where violations are reported implies monitoring them
+	if cmd.Flags().Changed("monitor-format") || cmd.Flags().Changed("monitor-fd") {
+		monitor = true
+	}
+	monitorOut, err := monitorOutput(monitorFD)
+	if err != nil {
+		return err
+	}
+	// Denials go to --monitor-fd, so color them for it rather than stderr
+	if f, ok := monitorOut.(*os.File); ok && monitorFD != 2 {
+		if err := output.SetColorMode(colorMode, f); err != nil {
+			return err
+		}
+	}
 
-	switch {
-	case templateName != "":
-		cfg, err = templates.Load(templateName)
-		if err != nil {
-			return fmt.Errorf("failed to load template: %w\nUse --list-templates to see available templates", err)
+	// Everything from here on runs as the target user, as if they had run fence
+	if runAsUser != "" {
+		if err := dropPrivileges(runAsUser); err != nil {
+			return err
 		}
 		if debug {
-			fmt.Fprintf(os.Stderr, "[fence] Using template: %s\n", templateName)
+			logging.Debugf("", "Running as %s (uid %d, gid %d), HOME=%s", runAsUser, os.Getuid(), os.Getgid(), os.Getenv("HOME"))
 		}
-	case settingsPath != "":
-		cfg, err = config.Load(settingsPath)
-		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Load config: template > settings file > default path
+	layers, err := loadConfigLayers(templateName, settingsPath)
+	if err != nil {
+		return err
+	}
+	if layers, err = withCLILayer(cmd, layers); err != nil {
+		return err
+	}
+	cfg := config.MergeLayers(layers)
+	if err := configureLogging(cfg, &debug); err != nil {
+		return err
+	}
+
+	opts := []sandbox.Option{sandbox.WithDebug(debug), sandbox.WithMonitor(monitor), sandbox.WithBackend(backend)}
+	opts = append(opts, allowanceOptions()...)
+	switch {
+	case noNetworkSandbox:
+		opts = append(opts, sandbox.WithoutNetworkSandbox())
+	case networkOnly:
+		if cfg.Resources.Limited() || cfg.Filesystem.MaxWriteBytes > 0 || cfg.Filesystem.PrivateHome {
+			return fmt.Errorf("resource limits, filesystem.maxWriteBytes, and filesystem.privateHome need the sandbox, which --network-only leaves out")
 		}
-		absPath, _ := filepath.Abs(settingsPath)
-		cfg, err = templates.ResolveExtendsWithBaseDir(cfg, filepath.Dir(absPath))
-		if err != nil {
-			return fmt.Errorf("failed to resolve extends: %w", err)
+		opts = append(opts, sandbox.WithoutSandbox())
+	}
+	if usePTY {
+		opts = append(opts, sandbox.WithTerminal())
+	}
+	var cowLayer *cow.Layer
+	var recorder *history.Recorder
+	if recordRun {
+		recorder = history.NewRecorder()
+		opts = append(opts, sandbox.WithHistory(recorder))
+	}
+	var counter *stats.Recorder
+	if cfg.StatsEnabled() && !dryRun {
+		counter = stats.NewRecorder()
+		opts = append(opts, sandbox.WithStats(counter))
+	}
+	if cowMode {
+		var cowOpt sandbox.Option
+		if cowOpt, cowLayer, err = cowOption(); err != nil {
+			return err
 		}
-	default:
-		configPath := config.DefaultConfigPath()
-		cfg, err = config.Load(configPath)
-		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+		opts = append(opts, cowOpt)
+	}
+	var exporter *telemetry.Exporter
+	if cfg.Telemetry.OTLPEndpoint != "" && !dryRun {
+		// Deferred first, so the run's span ends after the violation monitors flush
+		exporter = startTelemetry(cfg, command)
+		defer func() { exporter.Finish(exitCode, telemetryExitTimeout) }()
+		opts = append(opts, sandbox.WithTelemetry(exporter))
+	}
+	if policyPlugin != "" {
+		if cfg.Policy.Rego != "" {
+			return fmt.Errorf("--policy-plugin cannot be combined with policy.rego in the config")
 		}
-		if cfg == nil {
-			if debug {
-				fmt.Fprintf(os.Stderr, "[fence] No config found at %s, using default (block all network)\n", configPath)
-			}
-			cfg = config.Default()
-		} else {
-			cfg, err = templates.ResolveExtendsWithBaseDir(cfg, filepath.Dir(configPath))
-			if err != nil {
-				return fmt.Errorf("failed to resolve extends: %w", err)
-			}
+		plugin, err := startPolicyPlugin(policyPlugin, cfg, policyPluginTimeout)
+		if err != nil {
+			return err
 		}
+		defer func() { _ = plugin.Close() }()
+		opts = append(opts, sandbox.WithPolicy(plugin))
+	}
+	reload := reloadsConfig(cfg, policyPlugin)
+	if reload {
+		protectWatchedFiles(cfg, layers)
+	}
+	manager, err := sandbox.New(cfg, opts...)
+	if err != nil {
+		return err
+	}
+	if exporter != nil {
+		defer manager.Violations().Subscribe(exporter.Violation)()
+	}
+	if cfg.Webhook.URL != "" && !dryRun {
+		defer startWebhook(cfg, manager.Violations(), command)()
 	}
-
-	manager := sandbox.NewManager(cfg, debug, monitor)
 	manager.SetExposedPorts(ports)
+	manager.SetAuditMode(audit)
 	defer manager.Cleanup()
 
-	if err := manager.Initialize(); err != nil {
+	// Let Ctrl-C abort a slow setup; once the command runs, signals are forwarded to it instead
+	setupCtx, stopSetup := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSetup()
+
+	if err := manager.Initialize(setupCtx); err != nil {
 		return fmt.Errorf("failed to initialize sandbox: %w", err)
 	}
 
+	if dryRun {
+		spec, err := manager.Spec(setupCtx, command)
+		if err != nil {
+			return fmt.Errorf("failed to generate sandbox spec: %w", err)
+		}
+		printSpec(os.Stdout, spec)
+		return nil
+	}
+
+	if counter != nil {
+		defer saveStats(counter)
+	}
+	if reload {
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		go watchConfig(watchCtx, manager, templateName, settingsPath, layers)
+	}
+	if audit {
+		logging.Infof("audit", "Audit mode: network and command policy are not enforced (filesystem rules still apply)")
+	}
+
+	if cowLayer != nil {
+		defer printCowSummary(cowLayer)
+	}
+	if recorder != nil {
+		// Deferred before the change report, which records the file writes
+		defer saveRun(recorder, command, time.Now())
+	}
+	// Taken just before the command starts
+	var snapshot *sandbox.FileSnapshot
+	defer func() {
+		if snapshot != nil {
+			reportChanges(snapshot, recorder)
+		}
+	}()
+
+	// Summarize violations after the command exits (deferred before the monitors so they flush first)
+	violations := manager.Violations()
+	defer func() {
+		policy.MonitorOutput.Flush()
+		_ = manager.Downloads().WriteReport(os.Stderr)
+		_ = violations.WriteReport(os.Stderr)
+		if reportPath != "" {
+			if err := writeViolationReport(reportPath, violations); err != nil {
+				logging.Warnf("", "failed to write report: %v", err)
+			}
+		}
+		if !quiet {
+			_ = manager.Stats().WriteSummary(os.Stderr)
+		}
+	}()
+
+	// In NDJSON format, each violation is written as it is recorded, in place
+	// of the human-readable lines
+	if monitorFormat == monitorFormatNDJSON {
+		policy.MonitorOutput.SetOutput(io.Discard)
+		stopStream := violations.StreamNDJSON(monitorOut)
+		defer stopStream()
+	} else {
+		policy.MonitorOutput.SetOutput(monitorOut)
+	}
+
 	var logMonitor *sandbox.LogMonitor
 	if monitor {
 		logMonitor = sandbox.NewLogMonitor(sandbox.GetSessionSuffix())
 		if logMonitor != nil {
-			if err := logMonitor.Start(); err != nil {
-				fmt.Fprintf(os.Stderr, "[fence] Warning: failed to start log monitor: %v\n", err)
+			logMonitor.SetViolationLog(violations)
+			if err := logMonitor.Start(context.Background()); err != nil {
+				logging.Warnf("", "failed to start log monitor: %v", err)
 			} else {
 				defer logMonitor.Stop()
 			}
 		}
 	}
 
-	sandboxedCommand, err := manager.WrapCommand(command)
-	if err != nil {
-		return fmt.Errorf("failed to wrap command: %w", err)
+	// With --network-only the command runs as is, pointed at the proxies
+	sandboxedCommand := command
+	hardenedEnv := manager.Env(os.Environ())
+	if cfg.Supervisor.URL != "" {
+		hardenedEnv = withoutSupervisorToken(hardenedEnv, cfg)
+	}
+	if cfg.Webhook.URL != "" {
+		hardenedEnv = withoutWebhookSecret(hardenedEnv, cfg)
+	}
+	if exporter != nil {
+		hardenedEnv = withTraceParent(hardenedEnv, exporter)
+	}
+	if networkOnly {
+		hardenedEnv = append(hardenedEnv, manager.ProxyEnv()...)
+	} else {
+		sandboxedCommand, err = manager.WrapCommand(setupCtx, command)
+		if err != nil {
+			return fmt.Errorf("failed to wrap command: %w", err)
+		}
 	}
 
 	if debug {
-		fmt.Fprintf(os.Stderr, "[fence] Sandboxed command: %s\n", sandboxedCommand)
+		logging.Debugf("", "Sandboxed command: %s", sandboxedCommand)
 	}
 
-	hardenedEnv := sandbox.GetHardenedEnv()
 	if debug {
 		if stripped := sandbox.GetStrippedEnvVars(os.Environ()); len(stripped) > 0 {
-			fmt.Fprintf(os.Stderr, "[fence] Stripped dangerous env vars: %v\n", stripped)
+			logging.Debugf("", "Stripped dangerous env vars: %v", stripped)
+		}
+	}
+
+	if showChanges || changesPath != "" || recorder != nil {
+		if snapshot, err = sandbox.SnapshotFiles(sandbox.ChangeRoots(cfg)); err != nil {
+			logging.Warnf("", "not reporting changes: %v", err)
+		}
+	}
+
+	// Started before the command, so its first exec is traced too
+	var execTracer *sandbox.ExecTracer
+	if execLogPath != "" {
+		var stopExecLog func()
+		if execTracer, stopExecLog, err = startExecLog(manager, execLogPath); err != nil {
+			return err
 		}
+		defer stopExecLog()
 	}
 
 	execCmd := exec.Command("sh", "-c", sandboxedCommand) //nolint:gosec // sandboxedCommand is constructed from user input - intentional
@@ -240,24 +530,59 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	execCmd.Stdout = os.Stdout
 	execCmd.Stderr = os.Stderr
 
+	// Forward termination signals to the command's whole process group
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	stopSetup()
 
 	// Start the command (non-blocking) so we can get the PID
-	if err := execCmd.Start(); err != nil {
+	job, err := startJob(execCmd, usePTY)
+	if err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
+	manager.TrackProcess(execCmd.Process)
+	if execTracer != nil {
+		execTracer.SetRoot(execCmd.Process.Pid)
+	}
 
-	// Start Linux monitors (eBPF tracing for filesystem violations)
-	var linuxMonitors *sandbox.LinuxMonitors
-	if monitor && execCmd.Process != nil {
-		linuxMonitors, _ = sandbox.StartLinuxMonitor(execCmd.Process.Pid, sandbox.LinuxSandboxOptions{
-			Monitor: true,
-			Debug:   debug,
-			UseEBPF: true,
+	// Stopping the command sends its group SIGTERM (or the forwarded signal),
+	// then SIGKILL if it is still running after --kill-after. Shutdown then
+	// tears down the bridges and proxies, and the cgroup if there is one.
+	var stopping, timedOut atomic.Bool
+	stop := func(sig os.Signal) {
+		_ = job.signal(sig)
+		if stopping.Swap(true) {
+			return
+		}
+		time.AfterFunc(killAfter, func() { _ = job.signal(syscall.SIGKILL) })
+	}
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			logging.Infof("", "Command timed out after %s, stopping it", timeout)
+			stop(syscall.SIGTERM)
 		})
-		if linuxMonitors != nil {
-			defer linuxMonitors.Stop()
+		defer timer.Stop()
+	}
+	if cfg.Supervisor.URL != "" {
+		client := startSupervisor(cfg, manager, command, stop)
+		defer func() { client.Finish(exitCode, supervisorExitTimeout) }()
+	}
+
+	// Report the command's process and file events from Endpoint Security
+	if monitorES && execCmd.Process != nil {
+		if stopES, err := manager.StartESMonitor(context.Background(), execCmd.Process.Pid); err != nil {
+			logging.Warnf("", "not monitoring Endpoint Security events: %v", err)
+		} else {
+			defer stopES()
+		}
+	}
+
+	// Start Linux monitors (eBPF tracing for filesystem violations, or
+	// fanotify for reads of denyRead paths without it)
+	if monitor && execCmd.Process != nil && platform.Detect() == platform.Linux {
+		if stopMonitor, err := manager.StartMonitor(context.Background(), execCmd.Process.Pid); err == nil {
+			defer stopMonitor()
 		}
 	}
 
@@ -268,38 +593,159 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	// Landlock code exists for future integration (e.g., via a wrapper binary).
 
 	go func() {
-		sigCount := 0
 		for sig := range sigChan {
-			sigCount++
-			if execCmd.Process == nil {
-				continue
-			}
-			// First signal: graceful termination; second signal: force kill
-			if sigCount >= 2 {
-				_ = execCmd.Process.Kill()
+			if stopping.Load() {
+				// Second signal: force kill
+				_ = job.signal(syscall.SIGKILL)
 			} else {
-				_ = execCmd.Process.Signal(sig)
+				stop(sig)
 			}
 		}
 	}()
 
 	// Wait for command to finish
-	if err := execCmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// Set exit code but don't os.Exit() here - let deferred cleanup run
-			exitCode = exitErr.ExitCode()
-			return nil
-		}
+	err = job.wait()
+	signal.Stop(sigChan)
+	if stopping.Load() {
+		// Kill whatever the command left running in its group
+		_ = job.signal(syscall.SIGKILL)
+	}
+	// Set exit code but don't os.Exit() here - let deferred cleanup run
+	exitCode, err = commandExitCode(err, func() int {
+		return limitExitCode(os.Stderr, manager.LimitEvents(), cfg)
+	}, timedOut.Load())
+	if err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
 
 	return nil
 }
 
+// parsePorts parses the --port values.
+func parsePorts(values []string) ([]int, error) {
+	var ports []int
+	for _, p := range values {
+		port, err := strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %s", p)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// monitorOutput returns the writer for --monitor-fd. The descriptor is not
+// passed on to the sandboxed command.
+func monitorOutput(fd int) (io.Writer, error) {
+	if fd == 2 {
+		return os.Stderr, nil
+	}
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid --monitor-fd %d", fd)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("--monitor-fd %d: %w", fd, err)
+	}
+	closeOnExec(fd)
+	return f, nil
+}
+
+// reportChanges prints and writes the changes since snapshot, as --changes
+// and --changes-json ask, and records them in rec for --record.
+func reportChanges(snapshot *sandbox.FileSnapshot, rec *history.Recorder) {
+	changes, err := snapshot.Changes()
+	if err != nil {
+		logging.Warnf("", "failed to report changes: %v", err)
+		return
+	}
+	for _, c := range changes {
+		rec.Record(policy.KindFilesystem, c.Path, true)
+	}
+	if showChanges {
+		_ = sandbox.WriteChangeReport(os.Stderr, changes)
+	}
+	if changesPath != "" {
+		if err := writeChangesJSON(changesPath, changes); err != nil {
+			logging.Warnf("", "failed to write changes: %v", err)
+		}
+	}
+}
+
+// writeChangesJSON writes changes to the file at path as JSON.
+func writeChangesJSON(path string, changes []sandbox.FileChange) error {
+	f, err := os.Create(path) //nolint:gosec // path is provided by the user
+	if err != nil {
+		return err
+	}
+	if err := sandbox.WriteChangesJSON(f, changes); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// saveRun saves the run --record recorded in rec to the history.
+func saveRun(rec *history.Recorder, command string, start time.Time) {
+	dir, _ := os.Getwd()
+	run := &history.Run{Start: start, End: time.Now(), Command: command, Dir: dir, Events: rec.Events()}
+	store, err := history.DefaultStore()
+	if err == nil {
+		err = store.Save(run)
+	}
+	if err != nil {
+		logging.Warnf("", "failed to record run: %v", err)
+	}
+}
+
+// writeViolationReport writes the violation log as JSON to path.
+func writeViolationReport(path string, violations *policy.ViolationLog) error {
+	f, err := os.Create(path) //nolint:gosec // path is provided by the user
+	if err != nil {
+		return err
+	}
+	if err := violations.WriteJSON(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// startPolicyPlugin starts the program at path as cfg's policy plugin. Its
+// stderr is fence's.
+func startPolicyPlugin(path string, cfg *config.Config, timeout time.Duration) (*sandbox.PluginPolicy, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	return sandbox.StartPolicyPlugin(cmd, cfg, timeout)
+}
+
+// startExecLog starts tracing the processes the sandboxed command executes,
+// streaming each to path as a line of JSON. stop stops the tracer and
+// closes the file.
+func startExecLog(manager *sandbox.Manager, path string) (tracer *sandbox.ExecTracer, stop func(), err error) {
+	f, err := os.Create(path) //nolint:gosec // path is provided by the user
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create exec log: %w", err)
+	}
+	stopStream := manager.Execs().StreamNDJSON(f)
+	tracer, err = manager.StartExecTrace(context.Background())
+	if err != nil {
+		stopStream()
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to start exec log: %w", err)
+	}
+	return tracer, func() {
+		tracer.Stop()
+		stopStream()
+		_ = f.Close()
+	}, nil
+}
+
 // newImportCmd creates the import subcommand.
 func newImportCmd() *cobra.Command {
 	var (
 		claudeMode bool
+		bazelMode  bool
 		inputFile  string
 		outputFile string
 		extendTmpl string
@@ -313,10 +759,13 @@ func newImportCmd() *cobra.Command {
 
 Currently supported sources:
   --claude    Import from Claude Code settings
+  --bazel     Import the remote cache and execution endpoints and the output
+              paths from a workspace's .bazelrc (and the files it imports)
 
-By default, imports extend the "code" template which provides sensible defaults
-for network access (npm, GitHub, LLM providers) and filesystem protections.
-Use --no-extend for a minimal config, or --extend to choose a different template.
+By default, Claude imports extend the "code" template which provides sensible
+defaults for network access (npm, GitHub, LLM providers) and filesystem
+protections, and Bazel imports extend the "bazel" template. Use --no-extend
+for a minimal config, or --extend to choose a different template.
 
 Examples:
   # Import from default Claude Code settings (~/.claude/settings.json)
@@ -335,21 +784,32 @@ Examples:
   fence import --claude --extend local-dev-server
 
   # Import from project-level Claude settings
-  fence import --claude -f .claude/settings.local.json -o .fence.json`,
+  fence import --claude -f .claude/settings.local.json -o .fence.json
+
+  # Import the Bazel settings of the workspace in the current directory
+  fence import --bazel -o .fence.json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if !claudeMode {
-				return fmt.Errorf("no import source specified. Use --claude to import from Claude Code")
+			if !claudeMode && !bazelMode {
+				return fmt.Errorf("no import source specified. Use --claude to import from Claude Code or --bazel to import from .bazelrc")
 			}
 
 			opts := importer.DefaultImportOptions()
+			if bazelMode {
+				opts.Extends = "bazel"
+			}
 			if noExtend {
 				opts.Extends = ""
 			} else if extendTmpl != "" {
 				opts.Extends = extendTmpl
 			}
 
-			result, err := importer.ImportFromClaude(inputFile, opts)
-			if err != nil {
+			var result *importer.ImportResult
+			var err error
+			if bazelMode {
+				if result, err = importer.ImportFromBazelrc(inputFile, opts); err != nil {
+					return fmt.Errorf("failed to import Bazel settings: %w", err)
+				}
+			} else if result, err = importer.ImportFromClaude(inputFile, opts); err != nil {
 				return fmt.Errorf("failed to import Claude settings: %w", err)
 			}
 
@@ -382,11 +842,13 @@ Examples:
 	}
 
 	cmd.Flags().BoolVar(&claudeMode, "claude", false, "Import from Claude Code settings")
-	cmd.Flags().StringVarP(&inputFile, "file", "f", "", "Path to settings file (default: ~/.claude/settings.json for --claude)")
+	cmd.Flags().BoolVar(&bazelMode, "bazel", false, "Import from a Bazel workspace's .bazelrc")
+	cmd.Flags().StringVarP(&inputFile, "file", "f", "", "Path to settings file (default: ~/.claude/settings.json for --claude, ./.bazelrc for --bazel)")
 	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: stdout)")
-	cmd.Flags().StringVar(&extendTmpl, "extend", "", "Template to extend (default: code)")
+	cmd.Flags().StringVar(&extendTmpl, "extend", "", "Template to extend (default: code, or bazel for --bazel)")
 	cmd.Flags().BoolVar(&noExtend, "no-extend", false, "Don't extend any template (minimal config)")
 	cmd.MarkFlagsMutuallyExclusive("extend", "no-extend")
+	cmd.MarkFlagsMutuallyExclusive("claude", "bazel")
 
 	return cmd
 }
@@ -404,20 +866,48 @@ func printTemplates() {
 }
 
 // runLandlockWrapper runs in "wrapper mode" inside the sandbox.
-// It applies Landlock restrictions and then execs the user command.
-// Usage: fence --landlock-apply [--debug] -- <command...>
+// It applies Landlock restrictions and then execs the user command, or with
+// command.enforceExec, runs it under the exec guard.
+// Usage: fence --landlock-apply [--debug] [--audit] -- <command...>
 // Config is passed via FENCE_CONFIG_JSON environment variable.
 func runLandlockWrapper() {
-	// Parse arguments: --landlock-apply [--debug] -- <command...>
+	// Parse arguments: --landlock-apply [--debug] [--audit] [--seccomp-notify] -- <command...>
 	args := os.Args[2:] // Skip "fence" and "--landlock-apply"
 
-	var debugMode bool
+	var debugMode, auditMode, seccompNotify bool
+	var net *sandbox.LandlockNetRules
 	var cmdStart int
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "--debug":
 			debugMode = true
+		case "--audit":
+			auditMode = true
+		case "--seccomp-notify":
+			seccompNotify = true
+		case "--bind-ports", "--connect-ports":
+			if i+1 >= len(args) {
+				logging.Errorf("landlock-wrapper", "%s needs a value", args[i])
+				os.Exit(1)
+			}
+			ports, err := parsePorts(strings.FieldsFunc(args[i+1], func(r rune) bool { return r == ',' }))
+			if err != nil {
+				logging.Errorf("landlock-wrapper", "%s: %v", args[i], err)
+				os.Exit(1)
+			}
+			if ports == nil {
+				ports = []int{} // No port at all
+			}
+			if net == nil {
+				net = &sandbox.LandlockNetRules{}
+			}
+			if args[i] == "--bind-ports" {
+				net.BindPorts = ports
+			} else {
+				net.ConnectPorts = ports
+			}
+			i++
 		case "--":
 			cmdStart = i + 1
 			goto parseCommand
@@ -430,25 +920,29 @@ func runLandlockWrapper() {
 
 parseCommand:
 	if cmdStart >= len(args) {
-		fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Error: no command specified\n")
+		logging.Errorf("landlock-wrapper", "no command specified")
 		os.Exit(1)
 	}
 
 	command := args[cmdStart:]
 
 	if debugMode {
-		fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Applying Landlock restrictions\n")
+		logging.Debugf("landlock-wrapper", "Applying Landlock restrictions")
 	}
 
+	// Landlock restricts only the thread that applies it, which must be the
+	// one that execs the command
+	runtime.LockOSThread()
+
 	// Only apply Landlock on Linux
+	var cfg *config.Config
 	if platform.Detect() == platform.Linux {
 		// Load config from environment variable (passed by parent fence process)
-		var cfg *config.Config
 		if configJSON := os.Getenv("FENCE_CONFIG_JSON"); configJSON != "" {
 			cfg = &config.Config{}
 			if err := json.Unmarshal([]byte(configJSON), cfg); err != nil {
 				if debugMode {
-					fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Warning: failed to parse config: %v\n", err)
+					logging.Warnf("landlock-wrapper", "failed to parse config: %v", err)
 				}
 				cfg = nil
 			}
@@ -461,35 +955,112 @@ parseCommand:
 		cwd, _ := os.Getwd()
 
 		// Apply Landlock restrictions
-		err := sandbox.ApplyLandlockFromConfig(cfg, cwd, nil, debugMode)
+		err := sandbox.ApplyLandlockFromConfig(cfg, cwd, nil, net, debugMode)
 		if err != nil {
 			if debugMode {
-				fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Warning: Landlock not applied: %v\n", err)
+				logging.Warnf("landlock-wrapper", "Landlock not applied: %v", err)
 			}
 			// Continue without Landlock - bwrap still provides isolation
 		} else if debugMode {
-			fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Landlock restrictions applied\n")
+			logging.Debugf("landlock-wrapper", "Landlock restrictions applied")
 		}
 	}
 
 	// Find the executable
 	execPath, err := exec.LookPath(command[0])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Error: command not found: %s\n", command[0])
+		logging.Errorf("landlock-wrapper", "command not found: %s", command[0])
 		os.Exit(127)
 	}
 
 	if debugMode {
-		fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Exec: %s %v\n", execPath, command[1:])
+		logging.Debugf("landlock-wrapper", "Exec: %s %v", execPath, command[1:])
 	}
 
 	// Sanitize environment (strips LD_PRELOAD, etc.)
 	hardenedEnv := sandbox.FilterDangerousEnv(os.Environ())
 
+	// The exec guard checks this exec and every later one, and reports the
+	// syscalls seccomp blocks
+	if cfg != nil && (cfg.Command.UseExecEnforcement() || seccompNotify) {
+		os.Exit(sandbox.RunWithExecGuard(cfg, execPath, command, hardenedEnv, seccompNotify, auditMode, debugMode))
+	}
+
 	// Exec the command (replaces this process)
 	err = syscall.Exec(execPath, command, hardenedEnv) //nolint:gosec
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[fence:landlock-wrapper] Exec failed: %v\n", err)
+		logging.Errorf("landlock-wrapper", "Exec failed: %v", err)
 		os.Exit(1)
 	}
 }
+
+// runBridgeHelper runs inside the sandbox: it listens on the sandbox's ends
+// of the proxy and reverse bridges, then runs the command and returns its exit
+// code. The listeners live in this process, so they go away with the command.
+func runBridgeHelper(args []string) int {
+	// Parse arguments: --bridge [--debug] [--forward LISTEN=DIAL]... -- <command...>
+	var debugMode bool
+	var forwards []string
+	var command []string
+
+parse:
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--debug":
+			debugMode = true
+		case "--forward":
+			if i+1 < len(args) {
+				i++
+				forwards = append(forwards, args[i])
+			}
+		case "--":
+			command = args[i+1:]
+			break parse
+		default:
+			command = args[i:]
+			break parse
+		}
+	}
+
+	if len(command) == 0 {
+		logging.Errorf("bridge", "no command specified")
+		return 1
+	}
+
+	stop, err := sandbox.StartBridgeHelper(forwards, debugMode)
+	if err != nil {
+		logging.Errorf("bridge", "%v", err)
+		return 1
+	}
+	defer stop()
+
+	execCmd := exec.Command(command[0], command[1:]...) //nolint:gosec // command is the sandboxed shell built by fence
+	execCmd.Stdin = os.Stdin
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = os.Stderr
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	if err := execCmd.Start(); err != nil {
+		logging.Errorf("bridge", "failed to start %s: %v", command[0], err)
+		return 127
+	}
+	go func() {
+		for sig := range sigChan {
+			_ = execCmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := execCmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return exitErr.ExitCode()
+		}
+		return 1
+	}
+	return 0
+}
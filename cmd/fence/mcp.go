@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/mcp"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// newMCPCmd creates the mcp subcommand.
+func newMCPCmd() *cobra.Command {
+	var (
+		mcpSettings string
+		mcpTemplate string
+		mcpDebug    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mcp",
+		Short: "Serve sandboxed tools over the Model Context Protocol (stdio)",
+		Long: `Run a Model Context Protocol server on stdin/stdout so agents can run
+commands through fence. Every command runs in the sandbox with the loaded
+config; the proxies are started once and shared by all tool calls.
+
+Tools:
+  run_command       Run a shell command in the sandbox
+  read_policy       Show the effective config
+  list_violations   List operations blocked since the server started
+
+Logs go to stderr. Register the server with an MCP client, for example:
+
+  {
+    "mcpServers": {
+      "fence": { "command": "fence", "args": ["mcp", "-t", "code"] }
+    }
+  }
+
+Examples:
+  fence mcp
+  fence mcp -t code
+  fence mcp --settings ./fence.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(mcpTemplate, mcpSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			manager := sandbox.NewManager(cfg, mcpDebug, false)
+			defer manager.Cleanup()
+
+			if err := manager.Initialize(ctx); err != nil {
+				return fmt.Errorf("failed to initialize sandbox: %w", err)
+			}
+
+			server := mcp.NewServer(manager, cfg, version, mcpDebug)
+			if err := server.Serve(ctx, os.Stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&mcpSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&mcpTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().BoolVarP(&mcpDebug, "debug", "d", false, "Enable debug logging (to stderr)")
+
+	return cmd
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// shard is one command run by fence parallel.
+type shard struct {
+	index    int
+	command  string
+	wrapped  string
+	dir      string // Private scratch directory, writable only by this shard
+	exitCode int
+	duration time.Duration
+	output   bytes.Buffer
+	err      error
+}
+
+// newParallelCmd creates the parallel subcommand.
+func newParallelCmd() *cobra.Command {
+	var (
+		parallelSettings string
+		parallelTemplate string
+		parallelFile     string
+		parallelReport   string
+		parallelDebug    bool
+		jobs             int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "parallel [flags] [command...]",
+		Short: "Run several commands concurrently, each in its own sandbox",
+		Long: `Run commands concurrently, each in its own sandbox. The proxies are started
+once and shared, so every command gets the same network policy, but each one
+has a private scratch directory that only it can write to, in addition to
+the config's allowWrite paths.
+
+Commands are given as arguments or read from a file, one per line (blank
+lines and lines starting with # are skipped). Each command runs with:
+  FENCE_SHARD       Its 0-based position in the list
+  FENCE_SHARDS      The number of commands
+  FENCE_SHARD_DIR   Its scratch directory (removed when fence exits)
+
+Each command's output is buffered and printed when it finishes. At the end
+fence prints the commands that failed and the operations that were blocked.
+The exit status is that of the first failing command in list order, or 0.
+
+Examples:
+  fence parallel -j4 --file cmds.txt
+  fence parallel -t code "npm test -- --shard=1/2" "npm test -- --shard=2/2"
+  fence parallel --report violations.json -f cmds.txt`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			commands, err := parallelCommands(parallelFile, args)
+			if err != nil {
+				return err
+			}
+			if len(commands) == 0 {
+				return errors.New("no commands specified. Pass commands as arguments or use --file")
+			}
+			if jobs < 1 {
+				return fmt.Errorf("invalid --jobs %d: must be at least 1", jobs)
+			}
+
+			layers, err := loadConfigLayers(parallelTemplate, parallelSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+			if err := configureLogging(cfg, &parallelDebug); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			manager := sandbox.NewManager(cfg, parallelDebug, false)
+			defer manager.Cleanup()
+			if err := manager.Initialize(ctx); err != nil {
+				return fmt.Errorf("failed to initialize sandbox: %w", err)
+			}
+
+			scratch, err := os.MkdirTemp("", "fence-parallel-")
+			if err != nil {
+				return fmt.Errorf("failed to create scratch directory: %w", err)
+			}
+			defer func() { _ = os.RemoveAll(scratch) }()
+
+			violations := manager.Violations()
+			defer func() {
+				_ = violations.WriteReport(os.Stderr)
+				if parallelReport != "" {
+					if err := writeViolationReport(parallelReport, violations); err != nil {
+						logging.Warnf("", "failed to write report: %v", err)
+					}
+				}
+			}()
+
+			// Wrap every command before starting any: wrapping regenerates
+			// per-process files (such as the seccomp filter) that running
+			// sandboxes read at startup.
+			shards := make([]*shard, len(commands))
+			for i, command := range commands {
+				s, err := prepareShard(ctx, manager, cfg, scratch, i, command)
+				if err != nil {
+					return err
+				}
+				shards[i] = s
+			}
+
+			runShards(ctx, shards, manager.Env(os.Environ()), jobs, parallelDebug)
+			exitCode = summarizeShards(os.Stderr, shards)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of commands to run at once")
+	cmd.Flags().StringVarP(&parallelFile, "file", "f", "", "Read commands from a file, one per line (- for stdin)")
+	cmd.Flags().StringVarP(&parallelSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&parallelTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringVar(&parallelReport, "report", "", "Write the blocked operations to a JSON file when all commands exit")
+	cmd.Flags().BoolVarP(&parallelDebug, "debug", "d", false, "Enable debug logging")
+
+	return cmd
+}
+
+// parallelCommands returns the commands given as args followed by those in
+// path, skipping blank lines and # comments.
+func parallelCommands(path string, args []string) ([]string, error) {
+	commands := append([]string(nil), args...)
+	if path == "" {
+		return commands, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path) //nolint:gosec // path is provided by the user
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commands: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	return commands, nil
+}
+
+// prepareShard creates the shard's scratch directory and wraps its command
+// in a sub-sandbox that may also write there.
+func prepareShard(ctx context.Context, manager *sandbox.Manager, cfg *config.Config, scratch string, index int, command string) (*shard, error) {
+	dir := filepath.Join(scratch, strconv.Itoa(index))
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+
+	shardCfg := config.Merge(cfg, &config.Config{
+		Filesystem: config.FilesystemConfig{AllowWrite: []string{dir}},
+	})
+	sub, err := manager.WithConfig(shardCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &shard{index: index, command: command, dir: dir}
+	s.wrapped, err = sub.WrapCommand(ctx, command)
+	if err != nil {
+		var violation *sandbox.PolicyViolationError
+		if !errors.As(err, &violation) {
+			return nil, fmt.Errorf("failed to wrap command %q: %w", command, err)
+		}
+		// A blocked command fails on its own; the others still run
+		s.err = err
+		s.exitCode = 1
+	}
+	return s, nil
+}
+
+// runShards runs the shards in env with at most jobs at a time, printing
+// each one's output as it finishes.
+func runShards(ctx context.Context, shards []*shard, env []string, jobs int, debug bool) {
+	var (
+		wg      sync.WaitGroup
+		printMu sync.Mutex
+		done    int
+	)
+	sem := make(chan struct{}, jobs)
+
+	for _, s := range shards {
+		if s.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				s.err = ctx.Err()
+				s.exitCode = 1
+				return
+			}
+			defer func() { <-sem }()
+
+			s.run(ctx, env, len(shards), debug)
+
+			printMu.Lock()
+			defer printMu.Unlock()
+			done++
+			fmt.Fprintf(os.Stdout, "[fence:parallel] [%d/%d] %s (exit %d, %s)\n", done, len(shards), s.command, s.exitCode, s.duration.Round(time.Millisecond))
+			_, _ = os.Stdout.Write(s.output.Bytes())
+		}()
+	}
+	wg.Wait()
+}
+
+// run runs the shard's sandboxed command, recording its exit code and output.
+func (s *shard) run(ctx context.Context, env []string, total int, debug bool) {
+	if debug {
+		logging.Debugf("", "Shard %d sandboxed command: %s", s.index, s.wrapped)
+	}
+
+	execCmd := exec.CommandContext(ctx, "sh", "-c", s.wrapped) //nolint:gosec // wrapped is the sandboxed user command - intentional
+	execCmd.Env = append(slices.Clone(env),
+		"FENCE_SHARD="+strconv.Itoa(s.index),
+		"FENCE_SHARDS="+strconv.Itoa(total),
+		"FENCE_SHARD_DIR="+s.dir,
+	)
+	execCmd.Stdout = &s.output
+	execCmd.Stderr = &s.output
+	execCmd.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	err := execCmd.Run()
+	s.duration = time.Since(start)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			s.exitCode = exitErr.ExitCode()
+		} else {
+			s.err = err
+			s.exitCode = 1
+		}
+	}
+	if ctx.Err() != nil && s.exitCode != 0 {
+		s.err = ctx.Err()
+	}
+}
+
+// summarizeShards writes the failed shards to w and returns the exit code of
+// the first one in list order, or 0 if all succeeded.
+func summarizeShards(w io.Writer, shards []*shard) int {
+	code, failed := 0, 0
+	for _, s := range shards {
+		if s.exitCode == 0 {
+			continue
+		}
+		failed++
+		if code == 0 {
+			code = s.exitCode
+		}
+	}
+
+	if failed == 0 {
+		fmt.Fprintf(w, "[fence] %d command(s) succeeded\n", len(shards))
+		return 0
+	}
+
+	fmt.Fprintf(w, "[fence] %d of %d command(s) failed:\n", failed, len(shards))
+	for _, s := range shards {
+		switch {
+		case s.err != nil:
+			fmt.Fprintf(w, "  [%d] %s: %v\n", s.index, s.command, s.err)
+		case s.exitCode != 0:
+			fmt.Fprintf(w, "  [%d] %s: exit %d\n", s.index, s.command, s.exitCode)
+		}
+	}
+	return code
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// newProfileCmd creates the profile subcommand.
+func newProfileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Work with the generated macOS sandbox profile",
+	}
+	cmd.AddCommand(newProfileExportCmd())
+	return cmd
+}
+
+// newProfileExportCmd creates the profile export subcommand.
+func newProfileExportCmd() *cobra.Command {
+	var (
+		exportSettings string
+		exportTemplate string
+		outputPath     string
+		exposePorts    []string
+		httpPort       int
+		socksPort      int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export [flags] [-- command]",
+		Short: "Write the sandbox-exec profile fence generates on macOS",
+		Long: `Write the Seatbelt profile fence would run the command under on macOS with
+sandbox-exec, including the rules in macos.extraProfileRules. Nothing is
+executed, and the profile can be generated on any platform, for review or to
+run with sandbox-exec -f directly.
+
+The proxies listen on ports chosen at run time unless network.httpProxyPort
+and network.socksProxyPort are set; pass --http-port and --socks-port to
+allow others. Without them, a profile that restricts the network allows no
+proxy. The command, if given, only tags the profile's denial messages.
+
+Examples:
+  fence profile export
+  fence profile export -o fence.sb -- npm test
+  fence profile export -t code --http-port 3128 --socks-port 1080
+  sandbox-exec -f fence.sb npm test`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(exportTemplate, exportSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+			ports, err := parsePorts(exposePorts)
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("http-port") {
+				httpPort = cfg.Network.HTTPProxyPort
+			}
+			if !cmd.Flags().Changed("socks-port") {
+				socksPort = cfg.Network.SOCKSProxyPort
+			}
+			if httpPort == 0 && socksPort == 0 && !slices.Contains(cfg.Network.AllowedDomains, "*") {
+				logging.Warnf("", "no proxy ports set; the profile allows no proxy (see --http-port and --socks-port)")
+			}
+
+			spec := sandbox.MacOSSpec(cfg, strings.Join(args, " "), httpPort, socksPort, ports)
+			if outputPath == "" || outputPath == "-" {
+				_, err = io.WriteString(os.Stdout, spec.SeatbeltProfile)
+			} else {
+				err = os.WriteFile(outputPath, []byte(spec.SeatbeltProfile), 0o644) //nolint:gosec // a profile, not a secret
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write profile: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&exportSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&exportTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Write the profile to this file instead of stdout")
+	cmd.Flags().StringArrayVarP(&exposePorts, "port", "p", nil, "Expose port for inbound connections (can be used multiple times)")
+	cmd.Flags().IntVar(&httpPort, "http-port", 0, "Port of the HTTP proxy to allow (default: network.httpProxyPort)")
+	cmd.Flags().IntVar(&socksPort, "socks-port", 0, "Port of the SOCKS proxy to allow (default: network.socksProxyPort)")
+
+	return cmd
+}
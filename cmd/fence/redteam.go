@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/redteam"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// newRedteamCmd creates the redteam subcommand.
+func newRedteamCmd() *cobra.Command {
+	var (
+		redteamSettings string
+		redteamTemplate string
+		redteamBackend  string
+		redteamDebug    bool
+		jsonOutput      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "redteam",
+		Short: "Try known sandbox bypasses against your config",
+		Long: `Run a curated set of bypass attempts inside a fresh sandbox built from the
+loaded config, and report which of them the sandbox stops on this host.
+
+Probes:
+  direct-ip      Connect to an IP address directly, ignoring the proxy
+  dns-resolver   Query a public DNS resolver over UDP port 53
+  dns-tunnel     Leak data in the name of a lookup through the system resolver
+  write-outside  Write a file outside the writable paths
+  proc-root      Write outside the writable paths through /proc/self/root
+  git-hook       Plant a git pre-commit hook in the workspace
+  shell-rc       Append to a shell startup file in the workspace
+  proc-environ   Read fence's own environment through /proc
+  preload-env    Pass LD_PRELOAD or DYLD_INSERT_LIBRARIES to the command
+
+The probes run from a scratch workspace, which is added to the writable
+paths, and only ever write to scratch directories. Network probes are run
+outside the sandbox first; if they fail there too (e.g. offline), they are
+reported as inconclusive.
+
+Exits with status 1 if any probe bypassed the sandbox.
+
+Examples:
+  fence redteam
+  fence redteam -t code
+  fence redteam --backend native --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(redteamTemplate, redteamSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+
+			scratch, err := redteam.NewScratch()
+			if err != nil {
+				return fmt.Errorf("failed to create scratch directories: %w", err)
+			}
+			defer scratch.Remove()
+			cfg.Filesystem.AllowWrite = append(cfg.Filesystem.AllowWrite, scratch.Workspace)
+
+			skip := make(map[string]string)
+			// A rule allowing the write makes it no bypass; Linux's private /tmp
+			// allows it without one, and the write never reaches the host
+			if d := sandbox.EvaluatePath(scratch.Outside, true, cfg); d.Allowed && d.Rule != "" {
+				reason := fmt.Sprintf("%s allows writing %s", d.Rule, scratch.Outside)
+				skip["write-outside"], skip["proc-root"] = reason, reason
+			}
+
+			// Fence protects the files in the directory it is started from
+			if err := os.Chdir(scratch.Workspace); err != nil {
+				return err
+			}
+			manager, err := sandbox.New(cfg, sandbox.WithDebug(redteamDebug), sandbox.WithBackend(redteamBackend))
+			if err != nil {
+				return err
+			}
+			defer manager.Cleanup()
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			if err := manager.Initialize(ctx); err != nil {
+				return fmt.Errorf("failed to initialize sandbox: %w", err)
+			}
+
+			report := redteam.Report{
+				Platform: string(platform.Detect()),
+				Features: sandbox.HardeningFeatures(),
+				Results: redteam.Run(ctx, manager, redteam.Probes(), redteam.Options{
+					Scratch: scratch,
+					Skip:    skip,
+					Debug:   redteamDebug,
+				}),
+			}
+			if runtime.GOOS == "linux" {
+				report.Backend = redteamBackend
+				if report.Backend == "" {
+					report.Backend = "default"
+				}
+				report.Features = sandbox.DetectLinuxFeatures().Summary() + ", " + report.Features
+			}
+
+			if jsonOutput {
+				err = report.WriteJSON(os.Stdout)
+			} else {
+				err = report.WriteReport(os.Stdout)
+			}
+			if err != nil {
+				return err
+			}
+			if report.Count(redteam.StatusBypassed) > 0 {
+				exitCode = 1
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&redteamSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&redteamTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringVar(&redteamBackend, "backend", "", "Linux sandbox backend to attack (see fence --help)")
+	cmd.Flags().BoolVarP(&redteamDebug, "debug", "d", false, "Enable debug logging")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+
+	return cmd
+}
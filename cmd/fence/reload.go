@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// configPollInterval is how often the config files are checked for changes.
+const configPollInterval = time.Second
+
+// fileStamp identifies a version of a file; the zero stamp is a missing file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchedFiles returns the files watchConfig watches for layers: each config
+// file, the domain files it reads, and their signatures.
+func watchedFiles(layers []config.Layer) []string {
+	var files []string
+	for _, l := range layers {
+		if !filepath.IsAbs(l.Source) {
+			continue
+		}
+		for _, file := range slices.Concat([]string{l.Source}, l.Config.Network.AllowedDomainsFrom, l.Config.Network.DeniedDomainsFrom) {
+			files = append(files, file, file+config.SignatureSuffix)
+		}
+	}
+	return files
+}
+
+// stampFiles returns the current stamp of each of the watchedFiles of layers.
+func stampFiles(layers []config.Layer) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, file := range watchedFiles(layers) {
+		stamps[file] = stampFile(file)
+	}
+	return stamps
+}
+
+// protectWatchedFiles adds the watchedFiles of layers that exist to cfg's
+// filesystem.denyWrite, so that the sandboxed command cannot change the
+// network rules it runs under by editing them.
+func protectWatchedFiles(cfg *config.Config, layers []config.Layer) {
+	var files []string
+	for _, file := range watchedFiles(layers) {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	cfg.Filesystem.DenyWrite = slices.Concat(cfg.Filesystem.DenyWrite, files)
+}
+
+// stampFile returns the current stamp of the file at path.
+func stampFile(path string) fileStamp {
+	if info, err := os.Stat(path); err == nil {
+		return fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return fileStamp{}
+}
+
+// reloadsConfig reports whether the domain rules are reloaded as cfg's
+// files change. They are not when a policy, or a supervisor, decides them.
+func reloadsConfig(cfg *config.Config, policyPlugin string) bool {
+	return policyPlugin == "" && cfg.Policy.Rego == "" && cfg.Supervisor.URL == ""
+}
+
+// watchConfig reloads the config that templateName and settingsPath name
+// whenever one of the files in layers changes, and applies its domain rules
+// to manager, until ctx is done. If the changed config does not load, the
+// current rules stay. Other settings only apply to the next run.
+func watchConfig(ctx context.Context, manager *sandbox.Manager, templateName, settingsPath string, layers []config.Layer) {
+	stamps := stampFiles(layers)
+	if len(stamps) == 0 {
+		return
+	}
+	current := config.MergeLayers(layers).Network
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		latest := stampFiles(layers)
+		if maps.Equal(latest, stamps) {
+			continue
+		}
+		stamps = latest
+
+		reloaded, err := loadConfigLayers(templateName, settingsPath)
+		if err != nil {
+			logging.Warnf("config", "Keeping the current network rules: %v", err)
+			continue
+		}
+		// The changed files may extend different files now
+		layers = reloaded
+		stamps = stampFiles(layers)
+		cfg := config.MergeLayers(layers)
+		if slices.Equal(cfg.Network.AllowedDomains, current.AllowedDomains) &&
+			slices.Equal(cfg.Network.DeniedDomains, current.DeniedDomains) &&
+			slices.Equal(cfg.Network.WarnDomains, current.WarnDomains) {
+			continue
+		}
+		if err := manager.ReloadDomainRules(cfg); err != nil {
+			logging.Warnf("config", "Keeping the current network rules: %v", err)
+			continue
+		}
+		current = cfg.Network
+		logging.Infof("config", "Reloaded the network rules: %d allowed and %d denied domain(s)",
+			len(cfg.Network.AllowedDomains), len(cfg.Network.DeniedDomains)+len(cfg.Network.WarnDomains))
+	}
+}
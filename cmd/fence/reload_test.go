@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestProtectWatchedFiles(t *testing.T) {
+	dir := t.TempDir()
+	settings := filepath.Join(dir, "fence.json")
+	domains := filepath.Join(dir, "allow.txt")
+	for path, content := range map[string]string{
+		settings:                          `{"network": {"allowedDomainsFrom": ["allow.txt"]}}`,
+		settings + config.SignatureSuffix: "signature\n",
+		domains:                           "example.com\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, err := config.Load(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := []config.Layer{{Source: "template:code", Config: config.Default()}, {Source: settings, Config: loaded}}
+
+	cfg := config.MergeLayers(layers)
+	protectWatchedFiles(cfg, layers)
+
+	// Every watched file that exists is read-only in the sandbox
+	for _, file := range watchedFiles(layers) {
+		_, err := os.Stat(file)
+		if protected := slices.Contains(cfg.Filesystem.DenyWrite, file); protected != (err == nil) {
+			t.Errorf("%s in denyWrite = %v, want %v", file, protected, err == nil)
+		}
+	}
+	for _, file := range []string{settings, settings + config.SignatureSuffix, domains} {
+		if !slices.Contains(cfg.Filesystem.DenyWrite, file) {
+			t.Errorf("denyWrite = %v, missing %s", cfg.Filesystem.DenyWrite, file)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/api"
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// newServeCmd creates the serve subcommand.
+func newServeCmd() *cobra.Command {
+	var (
+		serveSettings  string
+		serveTemplate  string
+		serveListen    string
+		serveTokenFile string
+		serveDebug     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API for running sandboxed commands",
+		Long: `Serve an authenticated HTTP API so orchestration systems can run fenced
+commands without invoking the CLI for each one.
+
+  POST /run   Run a command. Body: {"command": "...", "config": {...},
+              "cwd": "...", "timeoutSeconds": N}. The response streams
+              newline-delimited JSON events: stdout, stderr, violation, and
+              finally exit (with exitCode) or error.
+  GET /health Returns "ok".
+
+Each run gets its own sandbox using the loaded config merged with the
+request's "config" overrides. Every request needs "Authorization: Bearer
+<token>". The token is read from --token-file or FENCE_API_TOKEN; if neither
+is set, a random token is generated and printed to stderr.
+
+Examples:
+  fence serve
+  fence serve -t code --listen 127.0.0.1:7878 --token-file ~/.fence-token
+  curl -N -H "Authorization: Bearer $TOKEN" -d '{"command":"npm test"}' http://127.0.0.1:7878/run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			layers, err := loadConfigLayers(serveTemplate, serveSettings)
+			if err != nil {
+				return err
+			}
+			cfg := config.MergeLayers(layers)
+			if err := configureLogging(cfg, &serveDebug); err != nil {
+				return err
+			}
+
+			token, generated, err := apiToken(serveTokenFile)
+			if err != nil {
+				return err
+			}
+
+			newSandbox := func(ctx context.Context, cfg *config.Config) (api.Sandbox, error) {
+				manager := sandbox.NewManager(cfg, serveDebug, false)
+				if err := manager.Initialize(ctx); err != nil {
+					manager.Cleanup()
+					return nil, err
+				}
+				return manager, nil
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			listener, err := net.Listen("tcp", serveListen)
+			if err != nil {
+				return fmt.Errorf("failed to listen: %w", err)
+			}
+
+			server := &http.Server{
+				Handler:           api.NewServer(cfg, token, newSandbox, serveDebug).Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+				BaseContext:       func(net.Listener) context.Context { return ctx },
+			}
+
+			logging.Infof("", "Serving API on http://%s", listener.Addr())
+			if generated {
+				fmt.Fprintf(os.Stderr, "[fence] API token: %s\n", token) // Not logged: it would be redacted
+			}
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- server.Serve(listener) }()
+
+			select {
+			case err := <-errCh:
+				return err
+			case <-ctx.Done():
+			}
+
+			// Runs see the canceled base context and stop; give them time to report it
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&serveSettings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&serveTemplate, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringVarP(&serveListen, "listen", "l", "127.0.0.1:7878", "Address to listen on")
+	cmd.Flags().StringVar(&serveTokenFile, "token-file", "", "Read the API token from a file (default: $FENCE_API_TOKEN, or generate one)")
+	cmd.Flags().BoolVarP(&serveDebug, "debug", "d", false, "Enable debug logging")
+
+	return cmd
+}
+
+// apiToken returns the API token from path or FENCE_API_TOKEN, or generates
+// one. generated reports whether the token was generated.
+func apiToken(path string) (token string, generated bool, err error) {
+	if path != "" {
+		data, err := os.ReadFile(path) //nolint:gosec // path is provided by the user
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return "", false, fmt.Errorf("token file %s is empty", path)
+		}
+		return token, false, nil
+	}
+	if token = os.Getenv("FENCE_API_TOKEN"); token != "" {
+		// Sandboxed commands inherit the environment; they must not be able
+		// to call the API (and loosen their own policy) with it
+		_ = os.Unsetenv("FENCE_API_TOKEN")
+		return token, false, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, fmt.Errorf("failed to generate API token: %w", err)
+	}
+	return hex.EncodeToString(b), true, nil
+}
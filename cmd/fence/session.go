@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/session"
+	"github.com/Use-Tusk/fence/internal/stats"
+	"github.com/spf13/cobra"
+)
+
+// Timeouts for starting and stopping a background session.
+const (
+	sessionStartTimeout = time.Minute
+	sessionStopTimeout  = 10 * time.Second
+	sessionPollInterval = 50 * time.Millisecond
+)
+
+// newSessionCmd creates the session subcommand.
+func newSessionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Run many commands under one long-lived sandbox",
+		Long: `Run many commands under one sandbox whose proxies and bridges are set up
+once. "fence session start" starts a named session in the background,
+"fence session exec" runs commands under it, and "fence session stop" shuts
+it down and reports what its commands were blocked from doing. This
+amortizes fence's startup cost over pipelines with many fenced steps.
+
+Sessions are registered in ~/.fence/sessions. Relative paths in the
+session's config, such as ".", are resolved against the directory the
+session was started in; commands run in the directory they are started in.
+
+Examples:
+  fence session start --name build -t code
+  fence session exec --name build -- npm ci
+  fence session exec --name build -c "npm test && npm run lint"
+  fence session list
+  fence session stop --name build`,
+	}
+
+	cmd.AddCommand(newSessionStartCmd())
+	cmd.AddCommand(newSessionExecCmd())
+	cmd.AddCommand(newSessionStopCmd())
+	cmd.AddCommand(newSessionListCmd())
+	return cmd
+}
+
+func newSessionStartCmd() *cobra.Command {
+	var (
+		name       string
+		settings   string
+		template   string
+		ports      []string
+		backend    string
+		debug      bool
+		monitor    bool
+		foreground bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "start --name NAME",
+		Short: "Start a named session in the background",
+		Long: `Start a named session: set up the sandbox's proxies and bridges, then wait
+in the background for "fence session exec" to run commands under it. The
+command returns once the session is ready. The session's output goes to
+~/.fence/sessions/NAME.log.
+
+With --foreground, the session runs in fence itself until it is stopped or
+interrupted, which suits supervisors and CI steps that manage the process.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := openSession(name)
+			if err != nil {
+				return err
+			}
+			if info, err := reg.Lookup(name); err == nil {
+				if session.Ping(info.Socket) == nil {
+					return fmt.Errorf("session %q is already running (pid %d)", name, info.PID)
+				}
+				// Left by a session that was killed
+				_ = reg.Remove(name)
+			}
+
+			if foreground {
+				return runSession(reg, name, settings, template, ports, backend, debug, monitor)
+			}
+			return startSession(reg, name)
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Session name (required)")
+	cmd.Flags().StringVarP(&settings, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&template, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringArrayVarP(&ports, "port", "p", nil, "Expose port for inbound connections (can be used multiple times)")
+	cmd.Flags().StringVar(&backend, "backend", "", "Linux sandbox backend: bwrap, native, gvisor, apparmor, or selinux")
+	cmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
+	cmd.Flags().BoolVarP(&monitor, "monitor", "m", false, "Watch for filesystem denials in the session's commands, for fence top and the stop report")
+	cmd.Flags().BoolVar(&foreground, "foreground", false, "Run the session in this process instead of in the background")
+	_ = cmd.MarkFlagRequired("name")
+
+	return cmd
+}
+
+// startSession starts the session in a background fence process, the same
+// command line with --foreground, and waits until it answers.
+func startSession(reg *session.Registry, name string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find fence executable: %w", err)
+	}
+	logPath := reg.LogPath(name)
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) //nolint:gosec // path is in the private registry directory
+	if err != nil {
+		return fmt.Errorf("failed to create session log: %w", err)
+	}
+	defer func() { _ = log.Close() }()
+
+	child := exec.Command(exe, append(os.Args[1:], "--foreground")...) //nolint:gosec // re-executes fence with its own arguments
+	child.Stdout = log
+	child.Stderr = log
+	detachProcess(child)
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = child.Wait()
+		close(exited)
+	}()
+
+	socket := reg.SocketPath(name)
+	deadline := time.After(sessionStartTimeout)
+	for session.Ping(socket) != nil {
+		select {
+		case <-exited:
+			output, _ := os.ReadFile(logPath) //nolint:gosec // path is in the private registry directory
+			return fmt.Errorf("session %q failed to start:\n%s", name, strings.TrimSpace(string(output)))
+		case <-deadline:
+			_ = child.Process.Kill()
+			return fmt.Errorf("session %q did not start within %s (see %s)", name, sessionStartTimeout, logPath)
+		case <-time.After(sessionPollInterval):
+		}
+	}
+	logging.Infof("", "Session %q started (pid %d)", name, child.Process.Pid)
+	return nil
+}
+
+// runSession sets up the sandbox and serves the session until it is stopped
+// or fence is interrupted.
+func runSession(reg *session.Registry, name, settings, template string, exposePorts []string, backend string, debug, monitor bool) error {
+	ports, err := parsePorts(exposePorts)
+	if err != nil {
+		return err
+	}
+	layers, err := loadConfigLayers(template, settings)
+	if err != nil {
+		return err
+	}
+	cfg := config.MergeLayers(layers)
+	if err := configureLogging(cfg, &debug); err != nil {
+		return err
+	}
+
+	opts := []sandbox.Option{sandbox.WithDebug(debug), sandbox.WithMonitor(monitor), sandbox.WithBackend(backend)}
+	opts = append(opts, allowanceOptions()...)
+	var counter *stats.Recorder
+	if cfg.StatsEnabled() {
+		counter = stats.NewRecorder()
+		opts = append(opts, sandbox.WithStats(counter))
+	}
+	manager, err := sandbox.New(cfg, opts...)
+	if err != nil {
+		return err
+	}
+	manager.SetExposedPorts(ports)
+	defer manager.Cleanup()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := manager.Initialize(ctx); err != nil {
+		return fmt.Errorf("failed to initialize sandbox: %w", err)
+	}
+	if counter != nil {
+		defer saveStats(counter)
+	}
+	if reloadsConfig(cfg, "") {
+		go watchConfig(ctx, manager, template, settings, layers)
+	}
+
+	if monitor {
+		defer startSessionMonitors(ctx, manager)()
+	}
+
+	socket := reg.SocketPath(name)
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	info := &session.Info{
+		Name:    name,
+		PID:     os.Getpid(),
+		Socket:  socket,
+		Dir:     cwd,
+		Config:  sessionConfigName(template, settings),
+		Started: time.Now(),
+	}
+	if err := reg.Register(info); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to register session: %w", err)
+	}
+
+	logging.Infof("", "Session %q listening on %s", name, socket)
+	err = session.NewServer(manager).Serve(ctx, listener)
+
+	// fence session stop waits for the record to go, so tear the sandbox
+	// down first
+	manager.Cleanup()
+	_ = reg.Remove(name)
+	return err
+}
+
+// startSessionMonitors starts the violation monitors for the commands the
+// session will run, which are all started after it, and returns a function
+// that stops them.
+func startSessionMonitors(ctx context.Context, manager *sandbox.Manager) (stop func()) {
+	var stops []func()
+	if logMonitor := sandbox.NewLogMonitor(sandbox.GetSessionSuffix()); logMonitor != nil {
+		logMonitor.SetViolationLog(manager.Violations())
+		if err := logMonitor.Start(ctx); err != nil {
+			logging.Warnf("", "failed to start log monitor: %v", err)
+		} else {
+			stops = append(stops, logMonitor.Stop)
+		}
+	}
+	if platform.Detect() == platform.Linux {
+		if stop, err := manager.StartMonitor(ctx, os.Getpid()); err == nil {
+			stops = append(stops, stop)
+		}
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// sessionConfigName describes where a session's config comes from, for
+// fence session list.
+func sessionConfigName(template, settings string) string {
+	switch {
+	case template != "":
+		return "template " + template
+	case settings != "":
+		return settings
+	}
+	return "default"
+}
+
+func newSessionExecCmd() *cobra.Command {
+	var (
+		name      string
+		command   string
+		debugExec bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "exec --name NAME [-c command | -- command...]",
+		Short: "Run a command under a running session",
+		Long: `Run a command under a running session's sandbox. The command is checked
+against the session's command policy and wrapped by the session, then runs
+here, with fence's stdio. fence exits with the command's exit code.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case command != "":
+			case len(args) > 0:
+				command = sandbox.ShellQuote(args)
+			default:
+				return fmt.Errorf("no command specified. Use -c <command> or provide command arguments")
+			}
+
+			reg, err := openSession(name)
+			if err != nil {
+				return err
+			}
+			info, err := reg.Lookup(name)
+			if err != nil {
+				return err
+			}
+			wrapped, env, err := session.Wrap(info.Socket, command, os.Environ())
+			if err != nil {
+				return fmt.Errorf("session %q: %w", name, err)
+			}
+			if debugExec {
+				logging.Debugf("", "Sandboxed command: %s", wrapped)
+			}
+			return runSessionCommand(wrapped, env)
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Session name (required)")
+	cmd.Flags().StringVarP(&command, "c", "c", "", "Run command string directly (like sh -c)")
+	cmd.Flags().BoolVarP(&debugExec, "debug", "d", false, "Print the sandboxed command")
+	_ = cmd.MarkFlagRequired("name")
+
+	return cmd
+}
+
+// runSessionCommand runs a command wrapped by a session in env, forwarding
+// termination signals to it, and sets exitCode to its exit code.
+func runSessionCommand(wrapped string, env []string) error {
+	execCmd := exec.Command("sh", "-c", wrapped) //nolint:gosec // wrapped is constructed from user input - intentional
+	execCmd.Env = env
+	execCmd.Stdin = os.Stdin
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = os.Stderr
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	job, err := startJob(execCmd, false)
+	if err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	go func() {
+		signaled := false
+		for sig := range sigChan {
+			if signaled {
+				// Second signal: force kill
+				sig = syscall.SIGKILL
+			}
+			_ = job.signal(sig)
+			signaled = true
+		}
+	}()
+
+	if err := job.wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+			return nil
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+func newSessionStopCmd() *cobra.Command {
+	var name string
+
+	cmd := &cobra.Command{
+		Use:   "stop --name NAME",
+		Short: "Stop a session and report what it blocked",
+		Long: `Stop a session: tear down its sandbox's proxies and bridges, and print what
+commands run under it were blocked from doing.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := openSession(name)
+			if err != nil {
+				return err
+			}
+			info, err := reg.Lookup(name)
+			if err != nil {
+				return err
+			}
+			commands, report, err := session.Stop(info.Socket)
+			if err != nil {
+				_ = reg.Remove(name)
+				return fmt.Errorf("session %q is not running; removed its record", name)
+			}
+
+			// The session removes its record once the sandbox is torn down
+			deadline := time.Now().Add(sessionStopTimeout)
+			for time.Now().Before(deadline) {
+				if _, err := reg.Lookup(name); errors.Is(err, session.ErrNotFound) {
+					break
+				}
+				time.Sleep(sessionPollInterval)
+			}
+
+			fmt.Fprint(os.Stderr, report)
+			logging.Infof("", "Session %q stopped after %d command(s)", name, commands)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Session name (required)")
+	_ = cmd.MarkFlagRequired("name")
+
+	return cmd
+}
+
+func newSessionListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List sessions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := session.DefaultRegistry()
+			if err != nil {
+				return err
+			}
+			infos, err := reg.List()
+			if err != nil {
+				return err
+			}
+			if len(infos) == 0 {
+				fmt.Println("No sessions")
+				return nil
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tPID\tSTARTED\tCONFIG\tDIR")
+			for _, info := range infos {
+				status := info.Started.Local().Format(time.DateTime)
+				if session.Ping(info.Socket) != nil {
+					status = "not running"
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", info.Name, info.PID, status, info.Config, info.Dir)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+// openSession validates name and returns the registry, created if needed.
+func openSession(name string) (*session.Registry, error) {
+	if err := session.ValidateName(name); err != nil {
+		return nil, err
+	}
+	reg, err := session.DefaultRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if err := reg.Init(); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// defaultShell is the shell fence shell starts when $SHELL is not one of
+// shellNames.
+const defaultShell = "bash"
+
+// shellNames are the interactive shells fence shell starts.
+var shellNames = []string{"bash", "zsh"}
+
+// newShellCmd creates the shell subcommand.
+func newShellCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shell [bash|zsh]",
+		Short: "Start an interactive shell inside the sandbox",
+		Long: `Start an interactive shell inside the sandbox, to explore what the policy
+allows. The sandbox and its proxies are set up once, so commands typed in
+the shell start without fence's startup cost. The shell is $SHELL if that is
+bash or zsh, or bash; it runs with FENCE_SANDBOX=1 set, which a prompt can
+show.
+
+When fence's input is a terminal, the shell gets a pseudo-terminal of its
+own, as with --tty, so job control and Ctrl-C work as in any shell. Blocked
+requests and violations are reported when the shell exits.
+
+Examples:
+  fence shell
+  fence shell -t code
+  fence shell --settings ./fence.json zsh`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shell, err := interactiveShell(args, os.Getenv("SHELL"))
+			if err != nil {
+				return err
+			}
+			cmdString = sandbox.ShellQuote([]string{shell, "-i"})
+			if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				usePTY = true
+			}
+			return runCommand(cmd, nil)
+		},
+	}
+
+	// The shell runs through runCommand, so the flags set the root
+	// command's variables
+	cmd.Flags().StringVarP(&settingsPath, "settings", "s", "", "Path to settings file (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&templateName, "template", "t", "", "Use built-in template (e.g., code, npm-install)")
+	cmd.Flags().StringArrayVarP(&exposePorts, "port", "p", nil, "Expose port for inbound connections (can be used multiple times)")
+	cmd.Flags().StringVar(&backend, "backend", "", "Linux sandbox backend: bwrap, native, gvisor, apparmor, or selinux")
+	cmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
+	cmd.Flags().BoolVarP(&monitor, "monitor", "m", false, "Monitor and log sandbox violations")
+	cmd.Flags().BoolVar(&cowMode, "cow", false, "Linux: keep changes to the current directory in a copy-on-write layer, for fence diff and fence commit")
+	cmd.Flags().BoolVar(&privateHome, "private-home", false, "Linux: start the shell with an empty home directory")
+	cmd.Flags().BoolVar(&recordRun, "record", false, "Record the session's hosts, commands, and file writes, for fence config impact")
+
+	return cmd
+}
+
+// interactiveShell returns the shell to start: the one named in args, or
+// $SHELL (given as env) if it is bash or zsh, or defaultShell.
+func interactiveShell(args []string, env string) (string, error) {
+	if len(args) > 0 {
+		for _, name := range shellNames {
+			if args[0] == name {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("unsupported shell %q (supported: bash, zsh)", args[0])
+	}
+	for _, name := range shellNames {
+		if filepath.Base(env) == name {
+			return name, nil
+		}
+	}
+	return defaultShell, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/stats"
+	"github.com/spf13/cobra"
+)
+
+// newStatsCmd creates the stats subcommand.
+func newStatsCmd() *cobra.Command {
+	var (
+		settings string
+		template string
+		top      int
+		asJSON   bool
+		reset    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show how often each domain, rule, and command was allowed or denied",
+		Long: `Show the counters fence keeps across runs in ~/.fence/stats: how often each
+config rule matched, each domain was connected to, and each command was run,
+allowed or denied. Rules in the config that never matched are listed at the
+end, as candidates to trim from an over-broad allowlist.
+
+Rules are network.allowedDomains, network.deniedDomains, command.allow, and
+command.deny entries. Commands are counted by program and subcommand, e.g.
+"git push". Set "stats": false in the config to stop counting.
+
+Examples:
+  fence stats
+  fence stats -t code --top 50
+  fence stats --json
+  fence stats --reset`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := stats.DefaultStore()
+			if err != nil {
+				return err
+			}
+			if reset {
+				return store.Reset()
+			}
+
+			layers, err := loadConfigLayers(template, settings)
+			if err != nil {
+				return err
+			}
+			counted, err := store.Load()
+			if err != nil {
+				return err
+			}
+			report := stats.NewReport(counted, config.MergeLayers(layers), top)
+			if asJSON {
+				return report.WriteJSON(os.Stdout)
+			}
+			return report.WriteReport(os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&settings, "settings", "s", "", "Config whose unused rules to list (default: ~/.fence.json)")
+	cmd.Flags().StringVarP(&template, "template", "t", "", "Built-in template whose unused rules to list")
+	cmd.Flags().IntVar(&top, "top", 20, "Show at most this many domains and commands (0 for all)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Write the counters as JSON")
+	cmd.Flags().BoolVar(&reset, "reset", false, "Delete the counters and start over")
+
+	return cmd
+}
+
+// saveStats adds the counters of a run to ~/.fence/stats.
+func saveStats(rec *stats.Recorder) {
+	store, err := stats.DefaultStore()
+	if err == nil {
+		err = store.Add(rec.Stats())
+	}
+	if err != nil {
+		logging.Warnf("", "failed to save stats: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/supervisor"
+)
+
+// supervisorExitTimeout bounds how long fence waits at exit for the
+// supervisor to receive the last messages.
+const supervisorExitTimeout = 2 * time.Second
+
+// supervisorTokenEnv returns the environment variable holding the
+// supervisor's bearer token.
+func supervisorTokenEnv(cfg *config.Config) string {
+	if cfg.Supervisor.TokenEnv != "" {
+		return cfg.Supervisor.TokenEnv
+	}
+	return supervisor.DefaultTokenEnv
+}
+
+// withoutSupervisorToken removes the supervisor's token from env, so the
+// sandboxed command cannot act as the node.
+func withoutSupervisorToken(env []string, cfg *config.Config) []string {
+	prefix := supervisorTokenEnv(cfg) + "="
+	return slices.DeleteFunc(env, func(v string) bool {
+		return strings.HasPrefix(v, prefix)
+	})
+}
+
+// startSupervisor connects the run to the supervisor in cfg, which may stop
+// the command through stop.
+func startSupervisor(cfg *config.Config, manager *sandbox.Manager, command string, stop func(os.Signal)) *supervisor.Client {
+	client := supervisor.New(manager, supervisor.Options{
+		URL:       cfg.Supervisor.URL,
+		Token:     os.Getenv(supervisorTokenEnv(cfg)),
+		Node:      cfg.Supervisor.Node,
+		Session:   sandbox.GetSessionSuffix(),
+		Command:   policy.Redact(command),
+		Version:   version,
+		Heartbeat: time.Duration(cfg.Supervisor.Heartbeat) * time.Second,
+		OnKill: func(reason string) {
+			logging.Infof("", "Supervisor stopped the command: %s", reason)
+			stop(syscall.SIGTERM)
+		},
+		Debug: debug,
+	})
+	go client.Run(context.Background())
+	return client
+}
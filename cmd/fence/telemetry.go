@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/telemetry"
+)
+
+// telemetryExitTimeout bounds how long fence waits at exit for the last
+// spans to reach the collector.
+const telemetryExitTimeout = 3 * time.Second
+
+// startTelemetry starts exporting the run to the collector in cfg. The run's
+// span continues the caller's trace if TRACEPARENT is set.
+func startTelemetry(cfg *config.Config, command string) *telemetry.Exporter {
+	headersEnv := cfg.Telemetry.HeadersEnv
+	if headersEnv == "" {
+		headersEnv = telemetry.DefaultHeadersEnv
+	}
+	exp := telemetry.New(telemetry.Options{
+		Endpoint:    cfg.Telemetry.OTLPEndpoint,
+		Headers:     telemetry.ParseHeaders(os.Getenv(headersEnv)),
+		ServiceName: cfg.Telemetry.ServiceName,
+		Session:     sandbox.GetSessionSuffix(),
+		Command:     policy.Redact(command),
+		Version:     version,
+		TraceParent: os.Getenv("TRACEPARENT"),
+		Debug:       debug,
+	})
+	go exp.Run(context.Background())
+	return exp
+}
+
+// withTraceParent sets TRACEPARENT in env to the run's span, so instrumented
+// commands continue the run's trace.
+func withTraceParent(env []string, exp *telemetry.Exporter) []string {
+	env = slices.DeleteFunc(env, func(v string) bool {
+		return strings.HasPrefix(v, "TRACEPARENT=")
+	})
+	return append(env, "TRACEPARENT="+exp.TraceParent())
+}
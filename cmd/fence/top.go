@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// Rows shown per section of fence top.
+const topRows = 10
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// newTopCmd creates the top subcommand.
+func newTopCmd() *cobra.Command {
+	var (
+		name     string
+		interval time.Duration
+		once     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "top [--name NAME]",
+		Short: "Watch a session's connections and blocks live",
+		Long: `Show what a running session is doing, refreshed in place: the connections
+its proxies have open, the latest blocked operations, the domains it
+connects to most, and the processes with the most filesystem denials.
+Press Ctrl-C to quit.
+
+Without --name, watches the only running session. Filesystem denials are
+only seen if the session was started with --monitor.
+
+Examples:
+  fence session start --name build --monitor -t code
+  fence top --name build
+  fence top --name build --once`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info, err := topSession(name)
+			if err != nil {
+				return err
+			}
+			if once {
+				snap, err := session.Top(info.Socket)
+				if err != nil {
+					return fmt.Errorf("session %q: %w", info.Name, err)
+				}
+				return renderTop(os.Stdout, info, snap)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				snap, err := session.Top(info.Socket)
+				if err != nil {
+					return fmt.Errorf("session %q: %w", info.Name, err)
+				}
+				fmt.Fprint(os.Stdout, clearScreen)
+				if err := renderTop(os.Stdout, info, snap); err != nil {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Session to watch (default: the only running session)")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Time between refreshes")
+	cmd.Flags().BoolVar(&once, "once", false, "Print the session's state once and exit")
+
+	return cmd
+}
+
+// topSession returns the session named name, or the only running session
+// if name is empty.
+func topSession(name string) (*session.Info, error) {
+	if name != "" {
+		reg, err := openSession(name)
+		if err != nil {
+			return nil, err
+		}
+		return reg.Lookup(name)
+	}
+
+	reg, err := session.DefaultRegistry()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := reg.List()
+	if err != nil {
+		return nil, err
+	}
+	var running []*session.Info
+	for _, info := range infos {
+		if session.Ping(info.Socket) == nil {
+			running = append(running, info)
+		}
+	}
+	switch len(running) {
+	case 0:
+		return nil, errors.New(`no running sessions; start one with "fence session start"`)
+	case 1:
+		return running[0], nil
+	}
+	names := make([]string, len(running))
+	for i, info := range running {
+		names[i] = info.Name
+	}
+	return nil, fmt.Errorf("several sessions are running (%s); choose one with --name", strings.Join(names, ", "))
+}
+
+// renderTop writes one screen of fence top.
+func renderTop(w io.Writer, info *session.Info, snap *session.Snapshot) error {
+	fmt.Fprintf(w, "fence top: session %s (pid %d), up %s, %d command(s)\n",
+		info.Name, info.PID, snap.Time.Sub(info.Started).Truncate(time.Second), snap.Commands)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nCONNECTIONS (%d open)\n", len(snap.Connections))
+	if len(snap.Connections) > 0 {
+		fmt.Fprintln(tw, "  TARGET\tAGE\tSENT\tRECEIVED")
+	}
+	// The newest connections, as the oldest are usually idle keep-alives
+	for _, c := range snap.Connections[max(0, len(snap.Connections)-topRows):] {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", c.Target, snap.Time.Sub(c.Started).Truncate(time.Second), formatBytes(c.BytesOut), formatBytes(c.BytesIn))
+	}
+
+	fmt.Fprintln(tw, "\nRECENT BLOCKS")
+	for _, e := range snap.Recent[:min(len(snap.Recent), topRows)] {
+		marker := output.Render(output.Blocked)
+		if e.Audit {
+			marker = output.Render(output.Warning)
+		}
+		fmt.Fprintf(tw, "  %s\t%s %s\t%s\t%s\n", e.Time.Local().Format(time.TimeOnly), marker, e.Kind, e.Target, e.Decision.Basis())
+	}
+
+	fmt.Fprintln(tw, "\nTOP DOMAINS")
+	if len(snap.Domains) > 0 {
+		fmt.Fprintln(tw, "  HOST\tALLOWED\tBLOCKED")
+	}
+	for _, d := range snap.Domains[:min(len(snap.Domains), topRows)] {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\n", d.Host, d.Allowed, d.Blocked)
+	}
+
+	fmt.Fprintln(tw, "\nFILESYSTEM DENIALS BY PROCESS")
+	for _, p := range snap.Processes[:min(len(snap.Processes), topRows)] {
+		fmt.Fprintf(tw, "  %s\t%d\n", p.Process, p.Denials)
+	}
+	return tw.Flush()
+}
+
+// formatBytes formats n bytes for display, e.g. "1.5 MB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
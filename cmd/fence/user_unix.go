@@ -0,0 +1,83 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// sessionEnvVars describe the invoking user's login session, which the
+// target user of --user cannot use.
+var sessionEnvVars = []string{
+	"XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME",
+	"DBUS_SESSION_BUS_ADDRESS", "XAUTHORITY", "MAIL",
+}
+
+// dropPrivileges switches fence from root to the named user (or numeric uid)
+// and their groups for good, and points HOME and the other per-user
+// variables at that user, so the config, the sandbox's home directory
+// rules, and the mandatory deny patterns are the target user's.
+func dropPrivileges(name string) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("--user requires running fence as root")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		var idErr error
+		if u, idErr = user.LookupId(name); idErr != nil {
+			return fmt.Errorf("--user: %w", err)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("--user: invalid uid %q", u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("--user: invalid gid %q", u.Gid)
+	}
+	if uid == 0 {
+		return fmt.Errorf("--user %s is root; nothing to drop", name)
+	}
+	groups := []int{gid}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil && g != gid {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	// Groups first: once the uid changes they can no longer be set
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("--user: failed to set groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("--user: failed to set gid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("--user: failed to set uid %d: %w", uid, err)
+	}
+	if syscall.Setuid(0) == nil {
+		return fmt.Errorf("--user: root privileges could not be dropped")
+	}
+
+	_ = os.Setenv("HOME", u.HomeDir)
+	_ = os.Setenv("USER", u.Username)
+	_ = os.Setenv("LOGNAME", u.Username)
+	for _, key := range sessionEnvVars {
+		_ = os.Unsetenv(key)
+	}
+	runtimeDir := filepath.Join("/run/user", u.Uid)
+	if info, err := os.Stat(runtimeDir); err == nil && info.IsDir() {
+		_ = os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	} else {
+		_ = os.Unsetenv("XDG_RUNTIME_DIR")
+	}
+	return nil
+}
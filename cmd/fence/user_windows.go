@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// dropPrivileges is not supported on Windows.
+func dropPrivileges(name string) error {
+	return errors.New("--user is not supported on Windows")
+}
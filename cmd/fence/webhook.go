@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+	"github.com/Use-Tusk/fence/internal/webhook"
+)
+
+// webhookExitTimeout bounds how long fence waits at exit for the last
+// violations to be posted.
+const webhookExitTimeout = 3 * time.Second
+
+// webhookSecretEnv returns the environment variable holding the webhook's
+// signing secret.
+func webhookSecretEnv(cfg *config.Config) string {
+	if cfg.Webhook.SecretEnv != "" {
+		return cfg.Webhook.SecretEnv
+	}
+	return webhook.DefaultSecretEnv
+}
+
+// withoutWebhookSecret removes the webhook's signing secret from env, so the
+// sandboxed command cannot forge notifications.
+func withoutWebhookSecret(env []string, cfg *config.Config) []string {
+	prefix := webhookSecretEnv(cfg) + "="
+	return slices.DeleteFunc(env, func(v string) bool {
+		return strings.HasPrefix(v, prefix)
+	})
+}
+
+// startWebhook starts posting the violations recorded in violations to the
+// webhook in cfg.
+func startWebhook(cfg *config.Config, violations *policy.ViolationLog, command string) (finish func()) {
+	notifier := webhook.New(webhook.Options{
+		URL:     cfg.Webhook.URL,
+		Secret:  os.Getenv(webhookSecretEnv(cfg)),
+		Session: sandbox.GetSessionSuffix(),
+		Command: policy.Redact(command),
+		Version: version,
+		Debug:   debug,
+	})
+	unsubscribe := violations.Subscribe(notifier.Notify)
+	go notifier.Run(context.Background())
+	return func() {
+		unsubscribe()
+		notifier.Finish(webhookExitTimeout)
+	}
+}
@@ -0,0 +1,232 @@
+// Package allowance implements temporary allowances: domains "fence allow"
+// lets every fenced command connect to until an expiry time, as one-off
+// exceptions that need no config change. They are kept in
+// ~/.fence/allowances, which running fences read on every connection, so an
+// allowance takes effect without restarting anything.
+package allowance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// lockTimeout bounds how long Add and Remove wait for another fence to
+// finish updating the allowances, and how old a lock must be to be
+// considered left behind by a crashed fence.
+const lockTimeout = 5 * time.Second
+
+// Allowance lets connections to Domain through until Expires.
+type Allowance struct {
+	Domain  string    `json:"domain"` // A domain pattern, as in network.allowedDomains
+	Added   time.Time `json:"added"`
+	Expires time.Time `json:"expires"`
+}
+
+// Active reports whether a has not expired at now.
+func (a Allowance) Active(now time.Time) bool {
+	return now.Before(a.Expires)
+}
+
+// Store is the directory holding the allowances.
+type Store struct {
+	Dir string
+}
+
+// DefaultStore returns the store in ~/.fence/allowances.
+func DefaultStore() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Store{Dir: filepath.Join(home, ".fence", "allowances")}, nil
+}
+
+// Path returns the file the allowances are kept in.
+func (s *Store) Path() string {
+	return filepath.Join(s.Dir, "allowances.json")
+}
+
+// Load returns the allowances that have not expired.
+func (s *Store) Load() ([]Allowance, error) {
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return active(all, time.Now()), nil
+}
+
+func (s *Store) load() ([]Allowance, error) {
+	data, err := os.ReadFile(s.Path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []Allowance
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("invalid allowances file %s: %w", s.Path(), err)
+	}
+	return all, nil
+}
+
+// Add allows domain for d from now, replacing any allowance it already has,
+// and drops the allowances that have expired.
+func (s *Store) Add(domain string, d time.Duration) (Allowance, error) {
+	if err := config.ValidateDomainPattern(domain); err != nil {
+		return Allowance{}, fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+	if d <= 0 {
+		return Allowance{}, fmt.Errorf("invalid duration %s: must be positive", d)
+	}
+	now := time.Now()
+	a := Allowance{Domain: domain, Added: now, Expires: now.Add(d)}
+	err := s.update(func(all []Allowance) []Allowance {
+		all = slices.DeleteFunc(all, func(b Allowance) bool { return b.Domain == domain })
+		return append(all, a)
+	})
+	return a, err
+}
+
+// Remove revokes domain's allowance, reporting whether it had one, and
+// drops the allowances that have expired.
+func (s *Store) Remove(domain string) (bool, error) {
+	removed := false
+	err := s.update(func(all []Allowance) []Allowance {
+		return slices.DeleteFunc(all, func(a Allowance) bool {
+			if a.Domain == domain {
+				removed = true
+				return true
+			}
+			return false
+		})
+	})
+	return removed, err
+}
+
+// Clear revokes every allowance.
+func (s *Store) Clear() error {
+	if err := os.Remove(s.Path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// update rewrites the allowances with change applied to the active ones.
+// Concurrent fences may update at the same time.
+func (s *Store) update(change func([]Allowance) []Allowance) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create allowances directory: %w", err)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all = change(active(all, time.Now()))
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file whole, so a running fence never reads it half written
+	tmp := s.Path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write allowances: %w", err)
+	}
+	if err := os.Rename(tmp, s.Path()); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write allowances: %w", err)
+	}
+	return nil
+}
+
+// lock takes the store's lock file, removing it first if a crashed fence
+// left it behind.
+func (s *Store) lock() (unlock func(), err error) {
+	path := filepath.Join(s.Dir, "allowances.lock")
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec // path is in our private allowances directory
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock allowances: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockTimeout {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock allowances: %s is held by another fence", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// active returns the allowances in all that have not expired at now.
+func active(all []Allowance, now time.Time) []Allowance {
+	return slices.DeleteFunc(all, func(a Allowance) bool { return !a.Active(now) })
+}
+
+// Watcher answers whether a host has an active allowance, rereading the
+// store only when the file changes. It is safe for concurrent use.
+type Watcher struct {
+	store *Store
+
+	mu         sync.Mutex
+	modTime    time.Time
+	size       int64
+	allowances []Allowance
+}
+
+// NewWatcher returns a Watcher for s.
+func NewWatcher(s *Store) *Watcher {
+	return &Watcher{store: s}
+}
+
+// Store returns the store w reads.
+func (w *Watcher) Store() *Store {
+	return w.store
+}
+
+// Match returns the active allowance whose domain pattern matches host, if
+// there is one. If the store cannot be read, no host matches.
+func (w *Watcher) Match(host string) (Allowance, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.store.Path())
+	switch {
+	case err != nil:
+		w.allowances, w.modTime, w.size = nil, time.Time{}, 0
+	case !info.ModTime().Equal(w.modTime) || info.Size() != w.size:
+		all, err := w.store.load()
+		if err != nil {
+			all = nil
+		}
+		w.allowances, w.modTime, w.size = all, info.ModTime(), info.Size()
+	}
+
+	now := time.Now()
+	for _, a := range w.allowances {
+		if a.Active(now) && config.MatchesDomain(host, a.Domain) {
+			return a, true
+		}
+	}
+	return Allowance{}, false
+}
@@ -0,0 +1,133 @@
+package allowance
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+
+	if got, err := s.Load(); err != nil || len(got) != 0 {
+		t.Fatalf("Load() on an empty store = %v, %v", got, err)
+	}
+	if _, err := s.Add("api.example.com", 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	a, err := s.Add("api.example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := a.Expires.Sub(a.Added); d != time.Hour {
+		t.Errorf("allowance lasts %s, want 1h", d)
+	}
+	if _, err := s.Add("*.example.org", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Domain != "api.example.com" || got[1].Domain != "*.example.org" {
+		t.Errorf("Load() = %+v, want one allowance per domain", got)
+	}
+
+	if removed, err := s.Remove("api.example.com"); err != nil || !removed {
+		t.Errorf("Remove() = %v, %v, want the allowance removed", removed, err)
+	}
+	if removed, err := s.Remove("api.example.com"); err != nil || removed {
+		t.Errorf("Remove() again = %v, %v, want nothing removed", removed, err)
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Load(); len(got) != 0 {
+		t.Errorf("Load() after Clear() = %+v", got)
+	}
+}
+
+func TestStoreInvalid(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	if _, err := s.Add("https://example.com", time.Hour); err == nil {
+		t.Error("Add should reject a URL")
+	}
+	if _, err := s.Add("*.com", time.Hour); err == nil {
+		t.Error("Add should reject an overly broad wildcard")
+	}
+	if _, err := s.Add("example.com", -time.Minute); err == nil {
+		t.Error("Add should reject a negative duration")
+	}
+}
+
+// writeAllowances replaces the store's file with all, as if written by
+// another fence.
+func writeAllowances(t *testing.T, s *Store, all []Allowance) {
+	t.Helper()
+	data, err := json.Marshal(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.Path(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiredAllowances(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	now := time.Now()
+	writeAllowances(t, s, []Allowance{
+		{Domain: "old.example.com", Added: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour)},
+		{Domain: "new.example.com", Added: now, Expires: now.Add(time.Hour)},
+	})
+
+	w := NewWatcher(s)
+	if _, ok := w.Match("old.example.com"); ok {
+		t.Error("an expired allowance should not match")
+	}
+	if _, ok := w.Match("new.example.com"); !ok {
+		t.Error("an active allowance should match")
+	}
+
+	// Expired allowances are dropped the next time the file is written
+	if _, err := s.Add("other.example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	all, err := s.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range all {
+		if a.Domain == "old.example.com" {
+			t.Errorf("expired allowance was kept: %+v", all)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	w := NewWatcher(s)
+	if _, ok := w.Match("api.example.com"); ok {
+		t.Error("no allowance should match without a store file")
+	}
+
+	if _, err := s.Add("*.example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	a, ok := w.Match("api.example.com")
+	if !ok || a.Domain != "*.example.com" {
+		t.Errorf("Match() = %+v, %v, want the new wildcard allowance", a, ok)
+	}
+	if _, ok := w.Match("example.org"); ok {
+		t.Error("an allowance should not match other domains")
+	}
+
+	if _, err := s.Remove("*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Match("api.example.com"); ok {
+		t.Error("a revoked allowance should not match")
+	}
+}
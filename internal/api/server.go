@@ -0,0 +1,239 @@
+// Package api implements the HTTP control API served by "fence serve", which
+// lets orchestration systems run sandboxed commands without invoking the CLI
+// for each one.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Limits for POST /run.
+const (
+	maxRequestBytes = 1 << 20
+	maxTimeout      = time.Hour
+)
+
+// Sandbox is the part of sandbox.Manager a run needs.
+type Sandbox interface {
+	WrapCommand(ctx context.Context, command string) (string, error)
+	Subscribe(fn func(policy.Event)) (unsubscribe func())
+	Env(environ []string) []string
+	Cleanup()
+}
+
+// NewSandboxFunc creates and initializes a sandbox for one run under cfg.
+type NewSandboxFunc func(ctx context.Context, cfg *config.Config) (Sandbox, error)
+
+// Server handles API requests. Every run gets its own sandbox, so config
+// overrides (including network rules) and violations are scoped to the run.
+type Server struct {
+	config     *config.Config
+	token      string
+	newSandbox NewSandboxFunc
+	debug      bool
+}
+
+// NewServer creates a server that runs commands under cfg, merged with each
+// request's overrides. Requests must carry "Authorization: Bearer <token>".
+func NewServer(cfg *config.Config, token string, newSandbox NewSandboxFunc, debug bool) *Server {
+	return &Server{config: cfg, token: token, newSandbox: newSandbox, debug: debug}
+}
+
+// RunRequest is the body of POST /run.
+type RunRequest struct {
+	Command string `json:"command"`
+	// Config is merged over the server's config: slices are appended and
+	// booleans ORed, so overrides can only add to what is allowed or denied.
+	Config         *config.Config `json:"config,omitempty"`
+	Cwd            string         `json:"cwd,omitempty"`
+	TimeoutSeconds int            `json:"timeoutSeconds,omitempty"`
+}
+
+// Event is one line of the newline-delimited JSON stream returned by POST /run.
+type Event struct {
+	Type      string        `json:"type"` // One of the Event* constants
+	Data      string        `json:"data,omitempty"`
+	Violation *policy.Event `json:"violation,omitempty"`
+	ExitCode  *int          `json:"exitCode,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Event types. A stream always ends with exactly one EventExit or EventError.
+const (
+	EventStdout    = "stdout"
+	EventStderr    = "stderr"
+	EventViolation = "violation"
+	EventExit      = "exit"
+	EventError     = "error"
+)
+
+// Handler returns the HTTP handler for the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run", s.handleRun)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fence"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	var req RunRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		http.Error(w, "invalid request: command is required", http.StatusBadRequest)
+		return
+	}
+	if req.TimeoutSeconds < 0 {
+		http.Error(w, "invalid request: timeoutSeconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	cfg := s.config
+	if req.Config != nil {
+		if req.Config.Extends != "" {
+			http.Error(w, "invalid request: config.extends is not supported", http.StatusBadRequest)
+			return
+		}
+		if err := req.Config.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %v", err), http.StatusBadRequest)
+			return
+		}
+		cfg = config.Merge(s.config, req.Config)
+	}
+
+	ctx := r.Context()
+	if req.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, min(time.Duration(req.TimeoutSeconds)*time.Second, maxTimeout))
+		defer cancel()
+	}
+
+	sb, err := s.newSandbox(ctx, cfg)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to initialize sandbox: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer sb.Cleanup()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	stream := newEventStream(w)
+	unsubscribe := sb.Subscribe(func(e policy.Event) {
+		stream.send(Event{Type: EventViolation, Violation: &e})
+	})
+	defer unsubscribe()
+
+	exitCode, err := s.run(ctx, sb, req, stream)
+	if err != nil {
+		stream.send(Event{Type: EventError, Error: err.Error()})
+		return
+	}
+	stream.send(Event{Type: EventExit, ExitCode: &exitCode})
+}
+
+// run runs the sandboxed command, streaming its output, and returns its exit code.
+func (s *Server) run(ctx context.Context, sb Sandbox, req RunRequest, stream *eventStream) (int, error) {
+	wrapped, err := sb.WrapCommand(ctx, req.Command)
+	if err != nil {
+		return 0, err
+	}
+	if s.debug {
+		logging.Debugf("api", "Sandboxed command: %s", wrapped)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", wrapped) //nolint:gosec // wrapped is the sandboxed user command - intentional
+	cmd.Env = sb.Env(os.Environ())
+	cmd.Dir = req.Cwd
+	cmd.Stdout = stream.writer(EventStdout)
+	cmd.Stderr = stream.writer(EventStderr)
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if errors.Is(ctxErr, context.DeadlineExceeded) {
+				return 0, fmt.Errorf("command timed out after %ds", req.TimeoutSeconds)
+			}
+			return 0, ctxErr
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, fmt.Errorf("failed to run command: %w", err)
+	}
+	return 0, nil
+}
+
+// eventStream writes events as newline-delimited JSON, flushing after each.
+// It is safe for concurrent use.
+type eventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	w   http.ResponseWriter
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	return &eventStream{enc: json.NewEncoder(w), w: w}
+}
+
+func (s *eventStream) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enc.Encode(e) != nil {
+		return // Client went away; the request context cancels the run
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writer returns an io.Writer that sends each write as an event of typ.
+func (s *eventStream) writer(typ string) io.Writer {
+	return streamWriter{stream: s, typ: typ}
+}
+
+type streamWriter struct {
+	stream *eventStream
+	typ    string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.stream.send(Event{Type: w.typ, Data: string(p)})
+	return len(p), nil
+}
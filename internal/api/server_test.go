@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+const testToken = "secret"
+
+// fakeSandbox runs commands unwrapped, enforcing only command.deny.
+type fakeSandbox struct {
+	cfg        *config.Config
+	violations *policy.ViolationLog
+}
+
+func (f *fakeSandbox) WrapCommand(_ context.Context, command string) (string, error) {
+	for _, prefix := range f.cfg.Command.Deny {
+		if strings.HasPrefix(command, prefix) {
+			d := policy.Decision{Rule: policy.RuleRef("command.deny", prefix), Reason: "matches a deny rule"}
+			f.violations.Record(policy.Event{Source: policy.SourceCommand, Kind: policy.KindCommand, Target: command, Decision: d})
+			return "", &sandbox.PolicyViolationError{Rule: d.Rule}
+		}
+	}
+	return command, nil
+}
+
+func (f *fakeSandbox) Subscribe(fn func(policy.Event)) func() {
+	return f.violations.Subscribe(fn)
+}
+
+func (f *fakeSandbox) Env(environ []string) []string {
+	return sandbox.FilterEnv(environ, &f.cfg.Env)
+}
+
+func (f *fakeSandbox) Cleanup() {}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"git push"}
+	newSandbox := func(_ context.Context, cfg *config.Config) (Sandbox, error) {
+		return &fakeSandbox{cfg: cfg, violations: policy.NewViolationLog(false)}, nil
+	}
+	ts := httptest.NewServer(NewServer(cfg, testToken, newSandbox, false).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func post(t *testing.T, ts *httptest.Server, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/run", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func readEvents(t *testing.T, resp *http.Response) []Event {
+	t.Helper()
+	var events []Event
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("invalid event: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestRunAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+	for _, token := range []string{"", "wrong"} {
+		if resp := post(t, ts, token, `{"command":"true"}`); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, resp.StatusCode)
+		}
+	}
+}
+
+func TestRunBadRequest(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid json", `{`},
+		{"missing command", `{}`},
+		{"unknown field", `{"command":"true","shell":"bash"}`},
+		{"negative timeout", `{"command":"true","timeoutSeconds":-1}`},
+		{"extends", `{"command":"true","config":{"extends":"code"}}`},
+		{"invalid config", `{"command":"true","config":{"network":{"allowedDomains":["*"]}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := post(t, ts, testToken, tt.body); resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRunStreamsOutput(t *testing.T) {
+	ts := newTestServer(t)
+	resp := post(t, ts, testToken, `{"command":"echo out; echo err >&2; exit 3"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var stdout, stderr string
+	events := readEvents(t, resp)
+	for _, e := range events {
+		switch e.Type {
+		case EventStdout:
+			stdout += e.Data
+		case EventStderr:
+			stderr += e.Data
+		}
+	}
+	if stdout != "out\n" || stderr != "err\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout, stderr)
+	}
+	last := events[len(events)-1]
+	if last.Type != EventExit || last.ExitCode == nil || *last.ExitCode != 3 {
+		t.Errorf("last event = %+v, want exit 3", last)
+	}
+}
+
+func TestRunConfigOverrides(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name    string
+		body    string
+		blocked bool
+	}{
+		{"base deny", `{"command":"git push origin main"}`, true},
+		{"override deny", `{"command":"npm publish","config":{"command":{"deny":["npm publish"]}}}`, true},
+		{"override is per run", `{"command":"npm publish --dry-run"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := readEvents(t, post(t, ts, testToken, tt.body))
+			types := make([]string, len(events))
+			for i, e := range events {
+				types[i] = e.Type
+			}
+
+			if !tt.blocked {
+				if slices.Contains(types, EventViolation) || types[len(types)-1] != EventExit {
+					t.Errorf("events = %v, want an unblocked run", types)
+				}
+				return
+			}
+			if !slices.Equal(types, []string{EventViolation, EventError}) {
+				t.Fatalf("events = %v, want violation then error", types)
+			}
+			if v := events[0].Violation; v.Kind != policy.KindCommand || v.Decision.Rule == "" {
+				t.Errorf("violation = %+v", v)
+			}
+		})
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	t.Setenv("FENCE_TEST_SECRET", "hunter2")
+	ts := newTestServer(t)
+	body := `{"command":"echo \"$FENCE_TEST_GREETING-$FENCE_TEST_SECRET\"","config":{"env":{"deny":["FENCE_TEST_SECRET"],"set":{"FENCE_TEST_GREETING":"hi"}}}}`
+
+	var stdout string
+	for _, e := range readEvents(t, post(t, ts, testToken, body)) {
+		if e.Type == EventStdout {
+			stdout += e.Data
+		}
+	}
+	if stdout != "hi-\n" {
+		t.Errorf("stdout = %q, want env.set applied and env.deny removed", stdout)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	events := readEvents(t, post(t, ts, testToken, `{"command":"exec sleep 5","timeoutSeconds":1}`))
+	last := events[len(events)-1]
+	if last.Type != EventError || !strings.Contains(last.Error, "timed out") {
+		t.Errorf("last event = %+v, want a timeout error", last)
+	}
+}
+
+func TestNewSandboxError(t *testing.T) {
+	newSandbox := func(context.Context, *config.Config) (Sandbox, error) {
+		return nil, errors.New("bwrap is required but not found")
+	}
+	ts := httptest.NewServer(NewServer(config.Default(), testToken, newSandbox, false).Handler())
+	defer ts.Close()
+	if resp := post(t, ts, testToken, `{"command":"true"}`); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CommandRegexPrefix marks a command rule as a regular expression, e.g.
+// "re:^git\s+push\s+--force". The expression is matched against the whole
+// command line, with the program's directory stripped; it is not anchored
+// unless it says so.
+const CommandRegexPrefix = "re:"
+
+// commandRuleCache holds compiled pattern rules, keyed by rule.
+var commandRuleCache sync.Map
+
+// IsCommandPattern reports whether a command rule is a regular expression or
+// a glob, rather than a prefix such as "git push".
+func IsCommandPattern(rule string) bool {
+	return strings.HasPrefix(rule, CommandRegexPrefix) || strings.Contains(rule, "*")
+}
+
+// CommandRuleRegexp returns the expression a pattern rule matches command
+// lines with. In a glob, * matches any run of characters, spaces included,
+// and the glob must match the whole command line: "curl * --upload-file *"
+// matches "curl -s https://x --upload-file secrets.txt".
+func CommandRuleRegexp(rule string) (*regexp.Regexp, error) {
+	if re, ok := commandRuleCache.Load(rule); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	var expr string
+	if pattern, ok := strings.CutPrefix(rule, CommandRegexPrefix); ok {
+		if strings.TrimSpace(pattern) == "" {
+			return nil, errors.New("empty regular expression")
+		}
+		expr = pattern
+	} else {
+		words := strings.Fields(rule)
+		if len(words) == 0 {
+			return nil, errors.New("empty glob")
+		}
+		words[0] = filepath.Base(words[0])
+		parts := strings.Split(strings.Join(words, " "), "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		expr = "^" + strings.Join(parts, ".*") + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	commandRuleCache.Store(rule, re)
+	return re, nil
+}
+
+// validateCommandRule checks that a command rule is non-empty and, if it is
+// a pattern, compiles.
+func validateCommandRule(rule string) error {
+	if strings.TrimSpace(rule) == "" {
+		return errors.New("empty command")
+	}
+	if IsCommandPattern(rule) {
+		if _, err := CommandRuleRegexp(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommandFlagRule matches a program run with any of a set of flags, such as
+// curl with --upload-file, so a rule can block a tool's risky uses without
+// blocking the tool.
+type CommandFlagRule struct {
+	// Command is the program, optionally followed by a subcommand:
+	// "curl" or "git push".
+	Command string `json:"command"`
+	// Flags are matched among the arguments after Command. A long flag
+	// also matches with "=value"; a short flag such as -T also matches with
+	// its value attached ("-Tfile") or at the end of a group ("-sT").
+	Flags []string `json:"flags"`
+	// Action is ActionWarn to allow the command, but report it; the
+	// default is ActionDeny.
+	Action string `json:"action,omitempty"`
+}
+
+// String returns the rule as it is named in decisions and errors, e.g.
+// "curl -T|--upload-file".
+func (r CommandFlagRule) String() string {
+	return r.Command + " " + strings.Join(r.Flags, "|")
+}
+
+func (r CommandFlagRule) validate() error {
+	if strings.TrimSpace(r.Command) == "" {
+		return errors.New("command is required")
+	}
+	if len(r.Flags) == 0 {
+		return errors.New("flags is required")
+	}
+	if err := validateAction(r.Action); err != nil {
+		return err
+	}
+	for _, flag := range r.Flags {
+		long := len(flag) > 2 && strings.HasPrefix(flag, "--") && !strings.ContainsAny(flag, " =")
+		short := len(flag) == 2 && flag[0] == '-' && flag[1] != '-'
+		if !long && !short {
+			return fmt.Errorf("invalid flag %q: must be -X or --name", flag)
+		}
+	}
+	return nil
+}
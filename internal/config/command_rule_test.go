@@ -0,0 +1,69 @@
+package config
+
+import "testing"
+
+func TestCommandRuleRegexp(t *testing.T) {
+	tests := []struct {
+		rule    string
+		command string
+		want    bool
+	}{
+		{`re:^git\s+push\s+--force`, "git push --force origin main", true},
+		{`re:^git\s+push\s+--force`, "git push origin main", false},
+		{`re:--force`, "git push origin --force", true},
+		{"curl * --upload-file *", "curl -s https://example.com --upload-file secrets.txt", true},
+		{"curl * --upload-file *", "curl https://example.com", false},
+		{"curl * --upload-file *", "xcurl a --upload-file b", false},
+		{"/usr/bin/curl *", "curl https://example.com", true},
+		{"npm run *", "npm run test", true},
+		{"npm run *", "npm install", false},
+		{"git  push  *", "git push origin", true},
+	}
+	for _, tt := range tests {
+		re, err := CommandRuleRegexp(tt.rule)
+		if err != nil {
+			t.Errorf("CommandRuleRegexp(%q) error = %v", tt.rule, err)
+			continue
+		}
+		if got := re.MatchString(tt.command); got != tt.want {
+			t.Errorf("CommandRuleRegexp(%q) matches %q = %v, want %v", tt.rule, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCommandRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"prefix", Config{Command: CommandConfig{Deny: []string{"git push"}}}, false},
+		{"regex", Config{Command: CommandConfig{Deny: []string{`re:^git\s+push`}}}, false},
+		{"glob", Config{Command: CommandConfig{Allow: []string{"npm run *"}}}, false},
+		{"bad regex", Config{Command: CommandConfig{Deny: []string{"re:git (push"}}}, true},
+		{"empty regex", Config{Command: CommandConfig{Allow: []string{"re:"}}}, true},
+		{"blank rule", Config{Command: CommandConfig{Deny: []string{"  "}}}, true},
+		{"bad ssh regex", Config{SSH: SSHConfig{DeniedCommands: []string{"re:[a-"}}}, true},
+		{"flag rule", Config{Command: CommandConfig{DenyFlags: []CommandFlagRule{{Command: "git push", Flags: []string{"-f", "--force"}}}}}, false},
+		{"flag rule without command", Config{Command: CommandConfig{DenyFlags: []CommandFlagRule{{Flags: []string{"-f"}}}}}, true},
+		{"flag rule without flags", Config{Command: CommandConfig{DenyFlags: []CommandFlagRule{{Command: "curl"}}}}, true},
+		{"flag rule with value", Config{Command: CommandConfig{DenyFlags: []CommandFlagRule{{Command: "curl", Flags: []string{"--data=x"}}}}}, true},
+		{"flag rule with short group", Config{Command: CommandConfig{DenyFlags: []CommandFlagRule{{Command: "curl", Flags: []string{"-sT"}}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultDeniedCommandFlagsValid(t *testing.T) {
+	for _, rule := range DefaultDeniedCommandFlags {
+		if err := rule.validate(); err != nil {
+			t.Errorf("default flag rule %s: %v", rule, err)
+		}
+	}
+}
@@ -2,37 +2,242 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/tidwall/jsonc"
 )
 
 // Config is the main configuration for fence.
 type Config struct {
 	Extends    string           `json:"extends,omitempty"`
+	PolicyURL  string           `json:"policyURL,omitempty"` // A centrally managed config, fetched and merged under this one
+	PolicyKey  string           `json:"policyKey,omitempty"` // Base64 Ed25519 public key the managed config must be signed with
 	Network    NetworkConfig    `json:"network"`
 	Filesystem FilesystemConfig `json:"filesystem"`
 	Command    CommandConfig    `json:"command"`
 	SSH        SSHConfig        `json:"ssh"`
+	DBus       DBusConfig       `json:"dbus"`
+	GUI        GUIConfig        `json:"gui"`
+	Devices    DevicesConfig    `json:"devices"`
+	MacOS      MacOSConfig      `json:"macos,omitzero"`
+	Security   SecurityConfig   `json:"security"`
+	Seccomp    SeccompConfig    `json:"seccomp,omitzero"`
+	Resources  ResourcesConfig  `json:"resources,omitzero"`
+	Supervisor SupervisorConfig `json:"supervisor,omitzero"`
+	Telemetry  TelemetryConfig  `json:"telemetry,omitzero"`
+	Webhook    WebhookConfig    `json:"webhook,omitzero"`
+	Logging    LoggingConfig    `json:"logging,omitzero"`
+	Env        EnvConfig        `json:"env,omitzero"`
+	Policy     PolicyConfig     `json:"policy,omitzero"`
 	AllowPty   bool             `json:"allowPty,omitempty"`
+	Stats      *bool            `json:"stats,omitempty"` // Keep allow/deny counters in ~/.fence/stats; defaults to true
+
+	// The bytes Load parsed, and those of the domain files ReadDomainFiles
+	// read by path, so that signatures are checked against what was used
+	raw         []byte
+	domainFiles map[string][]byte
 }
 
 // NetworkConfig defines network restrictions.
 type NetworkConfig struct {
-	AllowedDomains      []string `json:"allowedDomains"`
-	DeniedDomains       []string `json:"deniedDomains"`
-	AllowUnixSockets    []string `json:"allowUnixSockets,omitempty"`
-	AllowAllUnixSockets bool     `json:"allowAllUnixSockets,omitempty"`
-	AllowLocalBinding   bool     `json:"allowLocalBinding,omitempty"`
-	AllowLocalOutbound  *bool    `json:"allowLocalOutbound,omitempty"` // If nil, defaults to AllowLocalBinding value
-	HTTPProxyPort       int      `json:"httpProxyPort,omitempty"`
-	SOCKSProxyPort      int      `json:"socksProxyPort,omitempty"`
+	AllowedDomains      []string        `json:"allowedDomains"`
+	DeniedDomains       []string        `json:"deniedDomains"`
+	WarnDomains         []string        `json:"-"` // deniedDomains entries whose action is warn
+	AllowUnixSockets    []string        `json:"allowUnixSockets,omitempty"`
+	AllowAllUnixSockets bool            `json:"allowAllUnixSockets,omitempty"`
+	AllowLocalBinding   bool            `json:"allowLocalBinding,omitempty"`
+	AllowLocalOutbound  *bool           `json:"allowLocalOutbound,omitempty"` // If nil, defaults to AllowLocalBinding value
+	HTTPProxyPort       int             `json:"httpProxyPort,omitempty"`
+	SOCKSProxyPort      int             `json:"socksProxyPort,omitempty"`
+	TLS                 []TLSRule       `json:"tls,omitempty"`
+	HTTPRules           []HTTPRule      `json:"httpRules,omitempty"`
+	Downloads           DownloadsConfig `json:"downloads,omitzero"`
+	Uploads             UploadsConfig   `json:"uploads,omitzero"`
+	UpstreamProxy       string          `json:"upstreamProxy,omitempty"` // "" uses the host's HTTPS_PROXY/HTTP_PROXY/ALL_PROXY, "direct" ignores them, or an http:// or socks5:// URL
+	// InspectTLS makes the HTTP proxy terminate HTTPS to domains with
+	// httpRules or restricted uploads, using a per-session CA the sandbox
+	// trusts, so the rules apply to the requests inside.
+	InspectTLS bool `json:"inspectTLS,omitempty"`
+	// AllowGitPush lets the HTTP proxy forward git pushes (git-receive-pack
+	// requests of git's smart HTTP protocol), which it blocks by default.
+	AllowGitPush bool `json:"allowGitPush,omitempty"`
+	// BlockPublishing denies the package registries' publishing endpoints,
+	// listed in PublishingRules. If nil, defaults to true.
+	BlockPublishing *bool `json:"blockPublishing,omitempty"`
+	// ProxyAuth makes the proxies require a per-session username and
+	// password, given only to the sandboxed command, so other local
+	// processes cannot use them.
+	ProxyAuth bool `json:"proxyAuth,omitempty"`
+	// UnixSocketProxies makes the proxies listen only on the Unix sockets
+	// bound into the sandbox, with no TCP port on the host (Linux only).
+	UnixSocketProxies bool `json:"unixSocketProxies,omitempty"`
+	// FilterDNS runs a DNS resolver inside the Linux sandbox's network
+	// namespace that answers only for allowed domains, and NXDOMAIN otherwise.
+	FilterDNS bool `json:"filterDNS,omitempty"`
+	// DNS sets the resolvers the proxies and the FilterDNS resolver use
+	// instead of the host's.
+	DNS DNSConfig `json:"dns,omitzero"`
+	// MaxConnectionsPerMinute caps the connections and plain HTTP requests
+	// the proxies allow in any minute; 0 is unlimited.
+	MaxConnectionsPerMinute int `json:"maxConnectionsPerMinute,omitempty"`
+	// MaxBandwidthKbps caps the proxied traffic, both directions together,
+	// in kilobits per second; 0 is unlimited.
+	MaxBandwidthKbps int `json:"maxBandwidthKbps,omitempty"`
+	// AllowedDomainsFrom and DeniedDomainsFrom name files listing more
+	// allowed and denied domains, one per line. Relative paths are resolved
+	// against the config file's directory when it is loaded.
+	AllowedDomainsFrom []string `json:"allowedDomainsFrom,omitempty"`
+	DeniedDomainsFrom  []string `json:"deniedDomainsFrom,omitempty"`
+}
+
+// BlocksPublishing returns whether PublishingRules apply.
+func (n *NetworkConfig) BlocksPublishing() bool {
+	return n.BlockPublishing == nil || *n.BlockPublishing
+}
+
+// DNSConfig sets the resolvers fence uses, for hosts whose own DNS is broken
+// or captive. Without Servers or DoH, the host's resolver is used.
+type DNSConfig struct {
+	Servers []string `json:"servers,omitempty"` // Nameservers such as "1.1.1.1" or "dns.corp:5353", tried in order
+	DoH     string   `json:"doh,omitempty"`     // https:// DNS-over-HTTPS URL queries go to instead; Servers then only resolve its host
+	Search  []string `json:"search,omitempty"`  // Search domains for the sandbox's resolv.conf, with filterDNS
+}
+
+// Configured reports whether resolvers are set in place of the host's.
+func (d DNSConfig) Configured() bool {
+	return len(d.Servers) > 0 || d.DoH != ""
+}
+
+// UpstreamDirect is the network.upstreamProxy value that makes the proxies
+// connect directly even when the host environment names a proxy.
+const UpstreamDirect = "direct"
+
+// DownloadsConfig flags responses the HTTP proxy forwards that are large or
+// executable, for reviewing what a build fetched. Flagged downloads are
+// reported when the command exits and optionally copied to QuarantineDir.
+// Like httpRules, only plain HTTP responses can be inspected.
+type DownloadsConfig struct {
+	MaxSize         int64    `json:"maxSize,omitempty"`         // Flag responses larger than this many bytes; 0 disables
+	FlagExecutables bool     `json:"flagExecutables,omitempty"` // Flag executable and package content types
+	ContentTypes    []string `json:"contentTypes,omitempty"`    // More content types to flag, e.g. "application/zip" or "application/*"
+	QuarantineDir   string   `json:"quarantineDir,omitempty"`   // Copy flagged responses here, listed in index.jsonl
+}
+
+// Enabled reports whether any download is flagged.
+func (d DownloadsConfig) Enabled() bool {
+	return d.MaxSize > 0 || d.FlagExecutables || len(d.ContentTypes) > 0
+}
+
+// UploadsConfig guards against data leaving the sandbox: when Restrict is
+// set, the HTTP proxy blocks POST, PUT, and PATCH requests to domains not in
+// AllowUpload, or, if MaxSize is set, limits their bodies to MaxSize bytes.
+// Like httpRules, only plain HTTP requests can be inspected, unless
+// network.inspectTLS is set.
+type UploadsConfig struct {
+	Restrict    bool     `json:"restrict,omitempty"`    // Restrict uploads to domains not in AllowUpload
+	MaxSize     int64    `json:"maxSize,omitempty"`     // Largest upload allowed to those domains; 0 blocks uploads
+	AllowUpload []string `json:"allowUpload,omitempty"` // Domain patterns, as in allowedDomains, uploads are not restricted to
+}
+
+// UploadMethods are the methods network.uploads restricts.
+var UploadMethods = []string{"POST", "PUT", "PATCH"}
+
+// HTTPRule restricts the requests the HTTP proxy forwards to matching domains.
+// Only plain HTTP requests can be inspected; HTTPS tunnels pass unchanged
+// unless network.inspectTLS is set. When several rules match a domain, a
+// request must satisfy all of them.
+type HTTPRule struct {
+	Domain       string   `json:"domain"`                 // Domain pattern, as in allowedDomains
+	Methods      []string `json:"methods,omitempty"`      // Allowed methods, e.g. "GET"; empty allows any
+	MaxBodyBytes int64    `json:"maxBodyBytes,omitempty"` // Largest request body allowed; 0 means no limit
+	// Allow and Deny are request patterns: a URL path pattern, optionally
+	// after a method, e.g. "GET /repos/**" or "/user/keys". In paths, *
+	// matches within one segment and ** across segments. A request matching
+	// a Deny pattern is denied; if Allow is set, a request must match one.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Action is ActionWarn to allow requests that break the rule, but
+	// report them; the default is ActionDeny.
+	Action string `json:"action,omitempty"`
+}
+
+// httpRuleJSON is HTTPRule as written in JSON, which also accepts
+// maxRequestBytes for maxBodyBytes.
+type httpRuleJSON struct {
+	*httpRuleFields
+	MaxRequestBytes *int64 `json:"maxRequestBytes"`
+}
+
+type httpRuleFields HTTPRule
+
+func (r *HTTPRule) UnmarshalJSON(data []byte) error {
+	aux := httpRuleJSON{httpRuleFields: (*httpRuleFields)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.MaxRequestBytes != nil {
+		if r.MaxBodyBytes != 0 && r.MaxBodyBytes != *aux.MaxRequestBytes {
+			return fmt.Errorf("httpRules entry for %q sets both maxBodyBytes and maxRequestBytes", r.Domain)
+		}
+		r.MaxBodyBytes = *aux.MaxRequestBytes
+	}
+	return nil
+}
+
+// SplitRequestPattern splits an httpRules allow or deny pattern into its
+// method, which is empty if the pattern matches any, and its path pattern.
+func SplitRequestPattern(pattern string) (method, path string) {
+	if m, p, ok := strings.Cut(pattern, " "); ok {
+		return m, strings.TrimSpace(p)
+	}
+	return "", pattern
+}
+
+// TLSRule requires connections to matching domains to negotiate at least
+// MinVersion and, if Ciphers is set, one of the listed cipher suites. The
+// proxies check the server's handshake and cut tunnels that fall short;
+// plain HTTP to these domains is refused. When several rules match a domain,
+// a connection must satisfy all of them.
+type TLSRule struct {
+	Domains    []string `json:"domains"`           // Domain patterns, as in allowedDomains
+	MinVersion string   `json:"minVersion"`        // "1.0", "1.1", "1.2", or "1.3"
+	Ciphers    []string `json:"ciphers,omitempty"` // Cipher suite names, e.g. "TLS_AES_128_GCM_SHA256"
+	Action     string   `json:"action,omitempty"`  // ActionWarn allows connections that fall short, but reports them
+}
+
+// TLSVersions maps the minVersion values accepted in TLS rules to protocol versions.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CipherSuiteID returns the ID of the cipher suite with the given IANA name,
+// as listed by crypto/tls.
+func CipherSuiteID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, cs := range suites {
+			if cs.Name == name {
+				return cs.ID, true
+			}
+		}
+	}
+	return 0, false
 }
 
 // FilesystemConfig defines filesystem restrictions.
@@ -41,13 +246,21 @@ type FilesystemConfig struct {
 	AllowWrite     []string `json:"allowWrite"`
 	DenyWrite      []string `json:"denyWrite"`
 	AllowGitConfig bool     `json:"allowGitConfig,omitempty"`
+	MaxWriteBytes  int64    `json:"maxWriteBytes,omitempty"` // Linux: kill the command once it has written this much to disk; 0 is unlimited
+	PrivateHome    bool     `json:"privateHome,omitempty"`   // Linux: replace $HOME with an empty directory, keeping only allowWrite paths and the working directory
+	DenySecrets    bool     `json:"denySecrets,omitempty"`   // Also deny reading DefaultSecretPaths
 }
 
 // CommandConfig defines command restrictions.
 type CommandConfig struct {
 	Deny        []string `json:"deny"`
+	Warn        []string `json:"-"` // deny entries whose action is warn
 	Allow       []string `json:"allow"`
 	UseDefaults *bool    `json:"useDefaults,omitempty"`
+	EnforceExec *bool    `json:"enforceExec,omitempty"` // Also check every program executed in the sandbox (Linux only)
+
+	// DenyFlags blocks programs only when they are run with certain flags.
+	DenyFlags []CommandFlagRule `json:"denyFlags,omitempty"`
 }
 
 // SSHConfig defines SSH command restrictions.
@@ -55,12 +268,228 @@ type CommandConfig struct {
 type SSHConfig struct {
 	AllowedHosts     []string `json:"allowedHosts"`               // Host patterns to allow SSH to (supports wildcards like *.example.com)
 	DeniedHosts      []string `json:"deniedHosts"`                // Host patterns to deny SSH to (checked before allowed)
+	WarnHosts        []string `json:"-"`                          // deniedHosts entries whose action is warn
 	AllowedCommands  []string `json:"allowedCommands"`            // Commands allowed over SSH (allowlist mode)
 	DeniedCommands   []string `json:"deniedCommands"`             // Commands denied over SSH (checked before allowed)
+	WarnCommands     []string `json:"-"`                          // deniedCommands entries whose action is warn
 	AllowAllCommands bool     `json:"allowAllCommands,omitempty"` // If true, use denylist mode instead of allowlist
 	InheritDeny      bool     `json:"inheritDeny,omitempty"`      // If true, also apply global command.deny rules
 }
 
+// DBusConfig controls D-Bus access from the sandbox (Linux).
+// The session and system buses are blocked unless names are allowed here.
+type DBusConfig struct {
+	Allow       []string `json:"allow"`       // Session bus names the sandbox may talk to
+	Deny        []string `json:"deny"`        // Names never allowed on either bus, even if listed in allow
+	SystemAllow []string `json:"systemAllow"` // System bus names the sandbox may talk to
+}
+
+// DefaultDeniedDBusNames are bus names that are never allowed through the
+// D-Bus filter, since they hand out the user's stored secrets.
+var DefaultDeniedDBusNames = []string{
+	"org.freedesktop.secrets",
+	"org.freedesktop.impl.portal.Secret",
+	"org.gnome.keyring",
+	"org.kde.kwalletd5",
+	"org.kde.kwalletd6",
+}
+
+// GUIConfig controls access to the graphical display from the sandbox.
+// By default X11 and Wayland are hidden, so sandboxed code cannot read
+// keystrokes or take screenshots through the display server.
+type GUIConfig struct {
+	AllowDisplay bool `json:"allowDisplay,omitempty"` // Expose the X11/Wayland sockets and Xauthority read-only
+}
+
+// DevicesConfig controls access to media capture devices from the sandbox.
+// Microphones and cameras are denied by default so sandboxed code cannot
+// record without the user noticing.
+type DevicesConfig struct {
+	AllowAudio  bool `json:"allowAudio,omitempty"`  // Sound devices and audio servers (playback and microphone)
+	AllowCamera bool `json:"allowCamera,omitempty"` // Video capture devices
+}
+
+// MacOSConfig adjusts the sandbox-exec profile fence generates on macOS.
+// ExtraProfileRules are appended to the end of the profile, where they take
+// precedence over fence's own rules, e.g.
+// (allow mach-lookup (global-name "com.apple.pasteboard.1")). A rule that
+// allows too much undoes the sandbox; review it with fence profile export.
+type MacOSConfig struct {
+	ExtraProfileRules []string `json:"extraProfileRules,omitempty"` // Sandbox profile (SBPL) rules, each a parenthesized expression
+}
+
+// SecurityConfig defines additional process isolation options.
+type SecurityConfig struct {
+	MapToNobody bool   `json:"mapToNobody,omitempty"` // Linux: run as uid/gid 65534 in a user namespace
+	LSM         string `json:"lsm,omitempty"`         // Linux: confine the command inside the sandbox with "apparmor", "selinux", or "auto"
+}
+
+// Values of security.lsm. LSMAuto uses AppArmor or SELinux, whichever is
+// active, and neither when both are unavailable; naming one makes it required.
+const (
+	LSMAuto     = "auto"
+	LSMAppArmor = "apparmor"
+	LSMSELinux  = "selinux"
+)
+
+// SeccompConfig adjusts the seccomp filter the command runs under on Linux.
+// The default profile blocks a list of dangerous syscalls; the strict one
+// allows only the syscalls common programs need, and blocks the rest,
+// including syscalls added by future kernels.
+type SeccompConfig struct {
+	Profile string   `json:"profile,omitempty"` // "default" or "strict"; defaults to "default"
+	Block   []string `json:"block,omitempty"`   // Syscalls to block in addition to the profile's
+	Allow   []string `json:"allow,omitempty"`   // Syscalls to allow that the profile blocks; block wins over allow
+	Action  string   `json:"action,omitempty"`  // What blocked syscalls do: "errno" (fail with EPERM), "kill", "log", "notify", or "ask"; defaults to "errno"
+}
+
+// Values of seccomp.profile and seccomp.action. SeccompActionLog only logs
+// the syscalls to the kernel audit log, and lets them run.
+// SeccompActionNotify has fence report each blocked syscall with the
+// process that made it before failing it with EPERM; SeccompActionAsk also
+// asks on the terminal whether to let it run.
+const (
+	SeccompProfileDefault = "default"
+	SeccompProfileStrict  = "strict"
+
+	SeccompActionErrno  = "errno"
+	SeccompActionKill   = "kill"
+	SeccompActionLog    = "log"
+	SeccompActionNotify = "notify"
+	SeccompActionAsk    = "ask"
+)
+
+// ResourcesConfig limits the resources the sandboxed command can use. On
+// Linux the command runs in a cgroup v2 created for the run, which must be
+// delegated to the user; fence reports when a limit kills the command.
+type ResourcesConfig struct {
+	Memory  int64   `json:"memory,omitempty"`  // Memory limit in bytes, with swap disabled; 0 is unlimited
+	CPUs    float64 `json:"cpus,omitempty"`    // CPU time limit in cores, e.g. 1.5; 0 is unlimited
+	PidsMax int     `json:"pidsMax,omitempty"` // Maximum number of processes and threads; 0 is unlimited
+}
+
+// Limited reports whether any resource limit is set.
+func (r ResourcesConfig) Limited() bool {
+	return r.Memory > 0 || r.CPUs > 0 || r.PidsMax > 0
+}
+
+// SupervisorConfig connects fence to a central supervisor, which receives
+// the run's violations and heartbeats over a WebSocket and can push network
+// policy and stop the command.
+type SupervisorConfig struct {
+	URL       string `json:"url,omitempty"`       // wss:// endpoint, or ws:// to a loopback host; empty disables
+	TokenEnv  string `json:"tokenEnv,omitempty"`  // Environment variable holding the bearer token to send
+	Node      string `json:"node,omitempty"`      // Name this host reports; defaults to the hostname
+	Heartbeat int    `json:"heartbeat,omitempty"` // Seconds between heartbeats; 0 is 15
+}
+
+// TelemetryConfig exports the run to an OpenTelemetry collector as a trace,
+// with a span for each proxied connection and each violation.
+type TelemetryConfig struct {
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"` // OTLP/HTTP base URL, e.g. http://localhost:4318; empty disables
+	HeadersEnv   string `json:"headersEnv,omitempty"`   // Environment variable holding "key=value,..." headers to send; defaults to OTEL_EXPORTER_OTLP_HEADERS
+	ServiceName  string `json:"serviceName,omitempty"`  // service.name of the spans; defaults to "fence"
+}
+
+// WebhookConfig posts each violation to a URL as JSON, so blocked
+// operations can be alerted on centrally.
+type WebhookConfig struct {
+	URL       string `json:"url,omitempty"`       // http:// or https:// endpoint; empty disables
+	SecretEnv string `json:"secretEnv,omitempty"` // Environment variable holding the HMAC-SHA256 signing secret; defaults to FENCE_WEBHOOK_SECRET
+}
+
+// LoggingConfig controls fence's own log messages on stderr. The --log-level
+// and --log-format flags take precedence.
+type LoggingConfig struct {
+	Level  string `json:"level,omitempty"`  // debug, info, warn, or error; defaults to info (debug with -d)
+	Format string `json:"format,omitempty"` // text or json; defaults to text
+}
+
+// PolicyConfig hands fence's network, filesystem, and command decisions to a
+// Rego policy, evaluated with Open Policy Agent.
+type PolicyConfig struct {
+	Rego  string `json:"rego,omitempty"`  // A .rego file, a directory of policy and data files, or a bundle .tar.gz; empty disables
+	Query string `json:"query,omitempty"` // Rule that holds the decision; defaults to data.fence.decision
+}
+
+// EnvConfig controls the environment variables sandboxed commands get.
+// Names in Allow and Deny may contain * wildcards, e.g. "AWS_*".
+type EnvConfig struct {
+	Allow []string          `json:"allow,omitempty"` // If set, only these variables are passed on
+	Deny  []string          `json:"deny,omitempty"`  // Variables to remove (checked after allow)
+	Set   map[string]string `json:"set,omitempty"`   // Variables to set, replacing the host's values
+}
+
+// Passes reports whether the variable name is passed on to sandboxed
+// commands under Allow and Deny.
+func (e *EnvConfig) Passes(name string) bool {
+	if len(e.Allow) > 0 && !slices.ContainsFunc(e.Allow, func(p string) bool { return envNameMatches(p, name) }) {
+		return false
+	}
+	return !slices.ContainsFunc(e.Deny, func(p string) bool { return envNameMatches(p, name) })
+}
+
+// envNameMatches reports whether name matches pattern, in which * matches
+// any run of characters.
+func envNameMatches(pattern, name string) bool {
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// DefaultSecretPaths are the credential stores filesystem.denySecrets hides.
+// Paths for both Linux and macOS are listed; those that do not exist on the
+// host are skipped.
+var DefaultSecretPaths = []string{
+	// SSH, GPG, and .netrc credentials
+	"~/.ssh",
+	"~/.gnupg",
+	"~/.netrc",
+
+	// Cloud and cluster credentials
+	"~/.aws",
+	"~/.azure",
+	"~/.config/gcloud",
+	"~/.kube",
+
+	// Browser cookie stores (Chromium moved them under Network/ in version 96)
+	"~/.mozilla/firefox/*/cookies.sqlite",
+	"~/.config/google-chrome/*/Cookies",
+	"~/.config/google-chrome/*/Network/Cookies",
+	"~/.config/chromium/*/Cookies",
+	"~/.config/chromium/*/Network/Cookies",
+	"~/.config/BraveSoftware/Brave-Browser/*/Cookies",
+	"~/.config/BraveSoftware/Brave-Browser/*/Network/Cookies",
+	"~/Library/Application Support/Firefox/Profiles/*/cookies.sqlite",
+	"~/Library/Application Support/Google/Chrome/*/Cookies",
+	"~/Library/Application Support/Google/Chrome/*/Network/Cookies",
+	"~/Library/Application Support/BraveSoftware/Brave-Browser/*/Cookies",
+	"~/Library/Application Support/BraveSoftware/Brave-Browser/*/Network/Cookies",
+	"~/Library/Cookies",
+
+	// Keychains and keyrings
+	"~/Library/Keychains",
+	"~/.local/share/keyrings",
+}
+
+// PublishingRules deny the endpoints that publish, upload, or remove
+// packages on the public registries, while installs from them still work.
+// They apply unless network.blockPublishing is false.
+var PublishingRules = []HTTPRule{
+	// npm publish, unpublish, dist-tag, and deprecate
+	{Domain: "registry.npmjs.org", Deny: []string{"PUT /**", "DELETE /**"}},
+	{Domain: "registry.yarnpkg.com", Deny: []string{"PUT /**", "DELETE /**"}},
+
+	// twine upload and other PyPI uploads
+	{Domain: "upload.pypi.org", Deny: []string{"POST /**"}},
+	{Domain: "test.pypi.org", Deny: []string{"POST /legacy/**"}},
+
+	// cargo publish and yank
+	{Domain: "crates.io", Deny: []string{"PUT /api/v1/crates/new", "DELETE /api/v1/crates/**", "PUT /api/v1/crates/**"}},
+
+	// docker push: blob uploads and manifests
+	{Domain: "registry-1.docker.io", Deny: []string{"POST /v2/**", "PUT /v2/**", "PATCH /v2/**", "DELETE /v2/**"}},
+}
+
 // DefaultDeniedCommands returns commands that are blocked by default.
 // These are system-level dangerous commands that are rarely needed by AI agents.
 var DefaultDeniedCommands = []string{
@@ -104,6 +533,22 @@ var DefaultDeniedCommands = []string{
 	"nsenter",
 }
 
+// DefaultDeniedCommandFlags are the flag rules blocked by default alongside
+// DefaultDeniedCommands: uses of common tools that send local data out or
+// open a way back in, which the tools' other uses do not.
+var DefaultDeniedCommandFlags = []CommandFlagRule{
+	// Uploading files or form data
+	{Command: "curl", Flags: []string{"-T", "--upload-file", "-d", "--data", "--data-binary", "--data-raw", "--data-urlencode", "-F", "--form", "--json"}},
+	{Command: "wget", Flags: []string{"--post-file", "--body-file"}},
+
+	// Remote port forwarding exposes the sandbox to the remote host
+	{Command: "ssh", Flags: []string{"-R"}},
+
+	// Running a shell on a connection
+	{Command: "nc", Flags: []string{"-e"}},
+	{Command: "ncat", Flags: []string{"-e", "--exec", "-c", "--sh-exec"}},
+}
+
 // Default returns the default configuration with all network blocked.
 func Default() *Config {
 	return &Config{
@@ -127,6 +572,11 @@ func Default() *Config {
 			AllowedCommands: []string{},
 			DeniedCommands:  []string{},
 		},
+		DBus: DBusConfig{
+			Allow:       []string{},
+			Deny:        []string{},
+			SystemAllow: []string{},
+		},
 	}
 }
 
@@ -158,10 +608,14 @@ func Load(path string) (*Config, error) {
 	if err := json.Unmarshal(jsonc.ToJSON(data), &cfg); err != nil {
 		return nil, fmt.Errorf("invalid JSON in config file: %w", err)
 	}
+	if err := cfg.ReadDomainFiles(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	cfg.raw = data
 
 	return &cfg, nil
 }
@@ -169,16 +623,119 @@ func Load(path string) (*Config, error) {
 // Validate validates the configuration.
 func (c *Config) Validate() error {
 	for _, domain := range c.Network.AllowedDomains {
-		if err := validateDomainPattern(domain); err != nil {
+		if err := ValidateDomainPattern(domain); err != nil {
 			return fmt.Errorf("invalid allowed domain %q: %w", domain, err)
 		}
 	}
-	for _, domain := range c.Network.DeniedDomains {
-		if err := validateDomainPattern(domain); err != nil {
+	for _, domain := range slices.Concat(c.Network.DeniedDomains, c.Network.WarnDomains) {
+		if err := ValidateDomainPattern(domain); err != nil {
 			return fmt.Errorf("invalid denied domain %q: %w", domain, err)
 		}
 	}
 
+	for i, rule := range c.Network.TLS {
+		if len(rule.Domains) == 0 {
+			return fmt.Errorf("network.tls[%d]: domains is required", i)
+		}
+		for _, domain := range rule.Domains {
+			if err := ValidateDomainPattern(domain); err != nil {
+				return fmt.Errorf("invalid network.tls[%d] domain %q: %w", i, domain, err)
+			}
+		}
+		if _, ok := TLSVersions[rule.MinVersion]; !ok {
+			return fmt.Errorf("invalid network.tls[%d] minVersion %q: must be 1.0, 1.1, 1.2, or 1.3", i, rule.MinVersion)
+		}
+		for _, name := range rule.Ciphers {
+			if _, ok := CipherSuiteID(name); !ok {
+				return fmt.Errorf("invalid network.tls[%d] cipher %q: unknown cipher suite", i, name)
+			}
+		}
+		if err := validateAction(rule.Action); err != nil {
+			return fmt.Errorf("invalid network.tls[%d]: %w", i, err)
+		}
+	}
+
+	for i, rule := range c.Network.HTTPRules {
+		if err := ValidateDomainPattern(rule.Domain); err != nil {
+			return fmt.Errorf("invalid network.httpRules[%d] domain %q: %w", i, rule.Domain, err)
+		}
+		for _, method := range rule.Methods {
+			if !validMethod(method) {
+				return fmt.Errorf("invalid network.httpRules[%d] method %q: must be an uppercase HTTP method", i, method)
+			}
+		}
+		if rule.MaxBodyBytes < 0 {
+			return fmt.Errorf("invalid network.httpRules[%d] maxBodyBytes %d: must not be negative", i, rule.MaxBodyBytes)
+		}
+		for _, pattern := range slices.Concat(rule.Allow, rule.Deny) {
+			method, path := SplitRequestPattern(pattern)
+			if (method != "" && !validMethod(method)) || !strings.HasPrefix(path, "/") || !doublestar.ValidatePattern(path) {
+				return fmt.Errorf("invalid network.httpRules[%d] request pattern %q: must be a path starting with /, optionally after an uppercase HTTP method", i, pattern)
+			}
+		}
+		if err := validateAction(rule.Action); err != nil {
+			return fmt.Errorf("invalid network.httpRules[%d]: %w", i, err)
+		}
+	}
+	if c.Network.MaxConnectionsPerMinute < 0 {
+		return fmt.Errorf("invalid network.maxConnectionsPerMinute %d: must not be negative", c.Network.MaxConnectionsPerMinute)
+	}
+	if c.Network.MaxBandwidthKbps < 0 {
+		return fmt.Errorf("invalid network.maxBandwidthKbps %d: must not be negative", c.Network.MaxBandwidthKbps)
+	}
+	if c.Network.Uploads.MaxSize < 0 {
+		return fmt.Errorf("invalid network.uploads.maxSize %d: must not be negative", c.Network.Uploads.MaxSize)
+	}
+	for _, domain := range c.Network.Uploads.AllowUpload {
+		if err := ValidateDomainPattern(domain); err != nil {
+			return fmt.Errorf("invalid network.uploads.allowUpload domain %q: %w", domain, err)
+		}
+	}
+	if !c.Network.Uploads.Restrict && (c.Network.Uploads.MaxSize > 0 || len(c.Network.Uploads.AllowUpload) > 0) {
+		return errors.New("network.uploads.maxSize and allowUpload require network.uploads.restrict")
+	}
+	if c.Network.UnixSocketProxies && (c.Network.HTTPProxyPort != 0 || c.Network.SOCKSProxyPort != 0) {
+		return errors.New("network.unixSocketProxies cannot be combined with network.httpProxyPort or socksProxyPort")
+	}
+	if c.Network.InspectTLS && len(c.Network.HTTPRules) == 0 && !c.Network.Uploads.Restrict && !c.Network.BlocksPublishing() {
+		return errors.New("network.inspectTLS requires network.httpRules, network.uploads.restrict, or network.blockPublishing")
+	}
+
+	if c.Network.Downloads.MaxSize < 0 {
+		return fmt.Errorf("invalid network.downloads.maxSize %d: must not be negative", c.Network.Downloads.MaxSize)
+	}
+	for _, ct := range c.Network.Downloads.ContentTypes {
+		if !validContentType(ct) {
+			return fmt.Errorf("invalid network.downloads content type %q: must be a lowercase MIME type such as application/zip", ct)
+		}
+	}
+	if c.Network.Downloads.QuarantineDir != "" && !c.Network.Downloads.Enabled() {
+		return errors.New("network.downloads.quarantineDir requires maxSize, flagExecutables, or contentTypes")
+	}
+	for _, server := range c.Network.DNS.Servers {
+		if _, err := ParseNameserver(server); err != nil {
+			return fmt.Errorf("invalid network.dns.servers: %w", err)
+		}
+	}
+	if doh := c.Network.DNS.DoH; doh != "" {
+		if u, err := url.Parse(doh); err != nil || u.Scheme != "https" || u.Hostname() == "" {
+			return fmt.Errorf("invalid network.dns.doh %q: must be an https:// URL", doh)
+		}
+	}
+	for _, domain := range c.Network.DNS.Search {
+		if err := ValidateDomainPattern(domain); err != nil || strings.Contains(domain, "*") || strings.HasPrefix(domain, ".") {
+			return fmt.Errorf("invalid network.dns.search domain %q", domain)
+		}
+	}
+	if len(c.Network.DNS.Search) > 0 && !c.Network.FilterDNS {
+		return errors.New("network.dns.search requires network.filterDNS")
+	}
+	if p := c.Network.UpstreamProxy; p != "" && p != UpstreamDirect {
+		if _, err := ParseProxyURL(p); err != nil {
+			return fmt.Errorf("invalid network.upstreamProxy: %w", err)
+		}
+	}
+
 	if slices.Contains(c.Filesystem.DenyRead, "") {
 		return errors.New("filesystem.denyRead contains empty path")
 	}
@@ -188,13 +745,31 @@ func (c *Config) Validate() error {
 	if slices.Contains(c.Filesystem.DenyWrite, "") {
 		return errors.New("filesystem.denyWrite contains empty path")
 	}
+	if c.Filesystem.MaxWriteBytes < 0 {
+		return fmt.Errorf("invalid filesystem.maxWriteBytes %d: must not be negative", c.Filesystem.MaxWriteBytes)
+	}
 
-	if slices.Contains(c.Command.Deny, "") {
+	if slices.Contains(c.Command.Deny, "") || slices.Contains(c.Command.Warn, "") {
 		return errors.New("command.deny contains empty command")
 	}
 	if slices.Contains(c.Command.Allow, "") {
 		return errors.New("command.allow contains empty command")
 	}
+	for _, rule := range slices.Concat(c.Command.Deny, c.Command.Warn) {
+		if err := validateCommandRule(rule); err != nil {
+			return fmt.Errorf("invalid command.deny rule %q: %w", rule, err)
+		}
+	}
+	for _, rule := range c.Command.Allow {
+		if err := validateCommandRule(rule); err != nil {
+			return fmt.Errorf("invalid command.allow rule %q: %w", rule, err)
+		}
+	}
+	for i, rule := range c.Command.DenyFlags {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("invalid command.denyFlags[%d]: %w", i, err)
+		}
+	}
 
 	// SSH config
 	for _, host := range c.SSH.AllowedHosts {
@@ -202,7 +777,7 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("invalid ssh.allowedHosts %q: %w", host, err)
 		}
 	}
-	for _, host := range c.SSH.DeniedHosts {
+	for _, host := range slices.Concat(c.SSH.DeniedHosts, c.SSH.WarnHosts) {
 		if err := validateHostPattern(host); err != nil {
 			return fmt.Errorf("invalid ssh.deniedHosts %q: %w", host, err)
 		}
@@ -210,19 +785,178 @@ func (c *Config) Validate() error {
 	if slices.Contains(c.SSH.AllowedCommands, "") {
 		return errors.New("ssh.allowedCommands contains empty command")
 	}
-	if slices.Contains(c.SSH.DeniedCommands, "") {
+	if slices.Contains(c.SSH.DeniedCommands, "") || slices.Contains(c.SSH.WarnCommands, "") {
 		return errors.New("ssh.deniedCommands contains empty command")
 	}
+	for _, rule := range c.SSH.AllowedCommands {
+		if err := validateCommandRule(rule); err != nil {
+			return fmt.Errorf("invalid ssh.allowedCommands rule %q: %w", rule, err)
+		}
+	}
+	for _, rule := range slices.Concat(c.SSH.DeniedCommands, c.SSH.WarnCommands) {
+		if err := validateCommandRule(rule); err != nil {
+			return fmt.Errorf("invalid ssh.deniedCommands rule %q: %w", rule, err)
+		}
+	}
+
+	switch c.Security.LSM {
+	case "", LSMAuto, LSMAppArmor, LSMSELinux:
+	default:
+		return fmt.Errorf("invalid security.lsm %q: must be %q, %q, or %q", c.Security.LSM, LSMAuto, LSMAppArmor, LSMSELinux)
+	}
+
+	for _, rule := range c.MacOS.ExtraProfileRules {
+		if err := validateProfileRule(rule); err != nil {
+			return fmt.Errorf("invalid macos.extraProfileRules entry %q: %w", rule, err)
+		}
+	}
+
+	switch c.Seccomp.Profile {
+	case "", SeccompProfileDefault, SeccompProfileStrict:
+	default:
+		return fmt.Errorf("invalid seccomp.profile %q: must be %q or %q", c.Seccomp.Profile, SeccompProfileDefault, SeccompProfileStrict)
+	}
+	switch c.Seccomp.Action {
+	case "", SeccompActionErrno, SeccompActionKill, SeccompActionLog, SeccompActionNotify, SeccompActionAsk:
+	default:
+		return fmt.Errorf("invalid seccomp.action %q: must be %q, %q, %q, %q, or %q", c.Seccomp.Action,
+			SeccompActionErrno, SeccompActionKill, SeccompActionLog, SeccompActionNotify, SeccompActionAsk)
+	}
+	for _, name := range slices.Concat(c.Seccomp.Block, c.Seccomp.Allow) {
+		if !isSyscallName(name) {
+			return fmt.Errorf("invalid seccomp syscall %q: must be a lowercase syscall name such as \"ptrace\"", name)
+		}
+	}
+
+	if c.Resources.Memory < 0 {
+		return fmt.Errorf("invalid resources.memory %d: must not be negative", c.Resources.Memory)
+	}
+	if c.Resources.CPUs < 0 {
+		return fmt.Errorf("invalid resources.cpus %g: must not be negative", c.Resources.CPUs)
+	}
+	if c.Resources.PidsMax < 0 {
+		return fmt.Errorf("invalid resources.pidsMax %d: must not be negative", c.Resources.PidsMax)
+	}
+
+	if u := c.PolicyURL; u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid policyURL %q: must be an http:// or https:// URL", u)
+		}
+		if parsed.Scheme == "http" && c.PolicyKey == "" {
+			return fmt.Errorf("invalid policyURL %q: an http:// URL needs policyKey to verify it", u)
+		}
+	}
+	if k := c.PolicyKey; k != "" {
+		if key, err := base64.StdEncoding.DecodeString(k); err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid policyKey %q: must be a base64 Ed25519 public key", k)
+		}
+	}
+	if u := c.Supervisor.URL; u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "ws" && parsed.Scheme != "wss") || parsed.Host == "" {
+			return fmt.Errorf("invalid supervisor.url %q: must be a ws:// or wss:// URL", u)
+		}
+		if parsed.Scheme == "ws" && !IsLoopbackHost(parsed.Hostname()) {
+			return fmt.Errorf("invalid supervisor.url %q: must be wss://, since the token and policy would be sent in the clear; ws:// is only allowed to a loopback host", u)
+		}
+	}
+	if u := c.Telemetry.OTLPEndpoint; u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid telemetry.otlpEndpoint %q: must be an http:// or https:// URL", u)
+		}
+	}
+	if u := c.Webhook.URL; u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid webhook.url %q: must be an http:// or https:// URL", u)
+		}
+	}
+	switch strings.ToLower(c.Logging.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("invalid logging.level %q: must be debug, info, warn, or error", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid logging.format %q: must be text or json", c.Logging.Format)
+	}
+	for _, field := range []struct {
+		name     string
+		patterns []string
+	}{{"env.allow", c.Env.Allow}, {"env.deny", c.Env.Deny}} {
+		for _, p := range field.patterns {
+			if _, err := path.Match(p, ""); err != nil || p == "" || strings.ContainsAny(p, "=/") {
+				return fmt.Errorf("invalid %s entry %q: must be a variable name, optionally with * wildcards", field.name, p)
+			}
+		}
+	}
+	for name := range c.Env.Set {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid env.set name %q", name)
+		}
+	}
+
+	if q := c.Policy.Query; q != "" && (c.Policy.Rego == "" || !strings.HasPrefix(q, "data.")) {
+		return fmt.Errorf("invalid policy.query %q: must be a data.* reference, with policy.rego set", q)
+	}
+
+	if c.Supervisor.Heartbeat < 0 {
+		return fmt.Errorf("invalid supervisor.heartbeat %d: must not be negative", c.Supervisor.Heartbeat)
+	}
+
+	// D-Bus config
+	for _, names := range []struct {
+		field string
+		names []string
+	}{
+		{"dbus.allow", c.DBus.Allow},
+		{"dbus.deny", c.DBus.Deny},
+		{"dbus.systemAllow", c.DBus.SystemAllow},
+	} {
+		for _, name := range names.names {
+			if err := validateBusName(name); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", names.field, name, err)
+			}
+		}
+	}
 
 	return nil
 }
 
+// DenyReadPaths returns the paths to deny reading: DenyRead, followed by
+// DefaultSecretPaths if DenySecrets is set.
+func (f *FilesystemConfig) DenyReadPaths() []string {
+	if !f.DenySecrets {
+		return f.DenyRead
+	}
+	return append(slices.Clip(f.DenyRead), DefaultSecretPaths...)
+}
+
 // UseDefaultDeniedCommands returns whether to use the default deny list.
 func (c *CommandConfig) UseDefaultDeniedCommands() bool {
 	return c.UseDefaults == nil || *c.UseDefaults
 }
 
-func validateDomainPattern(pattern string) error {
+// UseExecEnforcement returns whether the command rules are enforced on
+// every exec in the sandbox, not only on the command fence runs (default
+// false).
+func (c *CommandConfig) UseExecEnforcement() bool {
+	return c.EnforceExec != nil && *c.EnforceExec
+}
+
+// StatsEnabled returns whether fence runs add to the counters fence stats
+// shows (default true).
+func (c *Config) StatsEnabled() bool {
+	return c.Stats == nil || *c.Stats
+}
+
+// ValidateDomainPattern returns an error if pattern is not a domain pattern
+// network.allowedDomains accepts: a domain, "*." and a domain, "." and a
+// domain, or "localhost".
+func ValidateDomainPattern(pattern string) error {
 	if pattern == "localhost" {
 		return nil
 	}
@@ -231,9 +965,9 @@ func validateDomainPattern(pattern string) error {
 		return errors.New("domain pattern cannot contain protocol, path, or port")
 	}
 
-	// Handle wildcard patterns
-	if strings.HasPrefix(pattern, "*.") {
-		domain := pattern[2:]
+	// Handle wildcard and suffix patterns
+	if strings.HasPrefix(pattern, "*.") || strings.HasPrefix(pattern, ".") {
+		domain := pattern[strings.Index(pattern, ".")+1:]
 		// Must have at least one more dot after the wildcard
 		if !strings.Contains(domain, ".") {
 			return errors.New("wildcard pattern too broad (e.g., *.com not allowed)")
@@ -265,6 +999,106 @@ func validateDomainPattern(pattern string) error {
 	return nil
 }
 
+// validMethod reports whether method is an uppercase HTTP method token, e.g. "GET".
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, r := range method {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validContentType reports whether ct is a lowercase MIME type such as
+// "application/zip", or a type with a wildcard subtype such as "application/*".
+func validContentType(ct string) bool {
+	typ, sub, ok := strings.Cut(ct, "/")
+	if !ok || typ == "" || strings.Contains(typ, "*") || sub == "" || strings.ContainsAny(sub, " ;/") || ct != strings.ToLower(ct) {
+		return false
+	}
+	return sub == "*" || !strings.Contains(sub, "*")
+}
+
+// validateBusName validates a D-Bus well-known name such as org.freedesktop.Notifications.
+func validateBusName(name string) error {
+	if name == "*" {
+		// xdg-dbus-proxy cannot allow every name but some, so the whole bus
+		// would be exposed without dbus.deny and DefaultDeniedDBusNames
+		return errors.New("the whole bus cannot be allowed, since denied names would not be filtered; list names exactly")
+	}
+	if strings.Contains(name, "*") {
+		return errors.New("wildcards are not supported; list names exactly")
+	}
+	if len(name) > 255 {
+		return errors.New("name longer than 255 characters")
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return errors.New("name must have at least two dot-separated elements")
+	}
+	for _, part := range parts {
+		if part == "" {
+			return errors.New("name contains an empty element")
+		}
+		if part[0] >= '0' && part[0] <= '9' {
+			return errors.New("name elements must not start with a digit")
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				return fmt.Errorf("invalid character %q", r)
+			}
+		}
+	}
+	return nil
+}
+
+// validateProfileRule checks that rule is one or more complete SBPL
+// expressions, so that it cannot leave the rest of the profile unbalanced.
+// Strings, #"regex" literals, and ; comments are skipped over.
+func validateProfileRule(rule string) error {
+	depth, exprs := 0, 0
+	for i := 0; i < len(rule); i++ {
+		switch c := rule[i]; {
+		case c == '"' && depth > 0:
+			// A string or #"regex", in which \ escapes the next character
+			for i++; i < len(rule) && rule[i] != '"'; i++ {
+				if rule[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(rule) {
+				return errors.New("unterminated string")
+			}
+		case c == ';':
+			for i < len(rule) && rule[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return errors.New("unbalanced parentheses")
+			}
+			depth--
+			if depth == 0 {
+				exprs++
+			}
+		case depth == 0 && !strings.ContainsRune(" \t\r\n", rune(c)):
+			return errors.New("must be parenthesized expressions, e.g. (allow mach-lookup (global-name \"com.example.service\"))")
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced parentheses")
+	}
+	if exprs == 0 {
+		return errors.New("no rule")
+	}
+	return nil
+}
+
 // validateHostPattern validates an SSH host pattern.
 // Host patterns are more permissive than domain patterns:
 // - Can contain wildcards anywhere (e.g., prod-*.example.com, *.example.com)
@@ -301,6 +1135,28 @@ func isIPv6Pattern(pattern string) bool {
 	return colonCount >= 2
 }
 
+// isSyscallName checks if name looks like a Linux syscall name.
+func isSyscallName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLoopbackHost reports whether host is localhost or a loopback address.
+func IsLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // MatchesDomain checks if a hostname matches a domain pattern.
 func MatchesDomain(hostname, pattern string) bool {
 	hostname = strings.ToLower(hostname)
@@ -317,6 +1173,11 @@ func MatchesDomain(hostname, pattern string) bool {
 		return strings.HasSuffix(hostname, "."+baseDomain)
 	}
 
+	// Suffix pattern like .example.com, which also matches the domain itself
+	if strings.HasPrefix(pattern, ".") {
+		return hostname == pattern[1:] || strings.HasSuffix(hostname, pattern)
+	}
+
 	// Exact match
 	return hostname == pattern
 }
@@ -408,12 +1269,20 @@ func Merge(base, override *Config) *Config {
 		// AllowPty: true if either config enables it
 		AllowPty: base.AllowPty || override.AllowPty,
 
+		// Pointer field: override wins if set
+		Stats: mergeOptionalBool(base.Stats, override.Stats),
+
 		Network: NetworkConfig{
 			// Append slices (base first, then override additions)
 			AllowedDomains:   mergeStrings(base.Network.AllowedDomains, override.Network.AllowedDomains),
 			DeniedDomains:    mergeStrings(base.Network.DeniedDomains, override.Network.DeniedDomains),
+			WarnDomains:      mergeStrings(base.Network.WarnDomains, override.Network.WarnDomains),
 			AllowUnixSockets: mergeStrings(base.Network.AllowUnixSockets, override.Network.AllowUnixSockets),
 
+			// Domain files are kept, resolved, so that a reload notices their changes
+			AllowedDomainsFrom: mergeStrings(base.Network.AllowedDomainsFrom, override.Network.AllowedDomainsFrom),
+			DeniedDomainsFrom:  mergeStrings(base.Network.DeniedDomainsFrom, override.Network.DeniedDomainsFrom),
+
 			// Boolean fields: override wins if set, otherwise base
 			AllowAllUnixSockets: base.Network.AllowAllUnixSockets || override.Network.AllowAllUnixSockets,
 			AllowLocalBinding:   base.Network.AllowLocalBinding || override.Network.AllowLocalBinding,
@@ -424,6 +1293,52 @@ func Merge(base, override *Config) *Config {
 			// Port fields: override wins if non-zero
 			HTTPProxyPort:  mergeInt(base.Network.HTTPProxyPort, override.Network.HTTPProxyPort),
 			SOCKSProxyPort: mergeInt(base.Network.SOCKSProxyPort, override.Network.SOCKSProxyPort),
+
+			// Append TLS rules; all matching rules apply, so order does not matter
+			TLS: append(slices.Clone(base.Network.TLS), override.Network.TLS...),
+
+			// Append HTTP rules; all matching rules apply, so order does not matter
+			HTTPRules: append(slices.Clone(base.Network.HTTPRules), override.Network.HTTPRules...),
+
+			// Upstream proxy: override wins if set
+			UpstreamProxy: mergeString(base.Network.UpstreamProxy, override.Network.UpstreamProxy),
+			InspectTLS:    base.Network.InspectTLS || override.Network.InspectTLS,
+			AllowGitPush:  base.Network.AllowGitPush || override.Network.AllowGitPush,
+			ProxyAuth:     base.Network.ProxyAuth || override.Network.ProxyAuth,
+
+			UnixSocketProxies: base.Network.UnixSocketProxies || override.Network.UnixSocketProxies,
+			FilterDNS:         base.Network.FilterDNS || override.Network.FilterDNS,
+
+			// Resolvers: override wins if set
+			DNS: DNSConfig{
+				Servers: replaceStrings(base.Network.DNS.Servers, override.Network.DNS.Servers),
+				DoH:     mergeString(base.Network.DNS.DoH, override.Network.DNS.DoH),
+				Search:  replaceStrings(base.Network.DNS.Search, override.Network.DNS.Search),
+			},
+
+			// Limits: override wins if set
+			MaxConnectionsPerMinute: mergeInt(base.Network.MaxConnectionsPerMinute, override.Network.MaxConnectionsPerMinute),
+			MaxBandwidthKbps:        mergeInt(base.Network.MaxBandwidthKbps, override.Network.MaxBandwidthKbps),
+
+			// Publishing block: override wins if set
+			BlockPublishing: mergeOptionalBool(base.Network.BlockPublishing, override.Network.BlockPublishing),
+
+			Downloads: DownloadsConfig{
+				// Size threshold and quarantine directory: override wins if set
+				MaxSize:       mergeInt64(base.Network.Downloads.MaxSize, override.Network.Downloads.MaxSize),
+				QuarantineDir: mergeString(base.Network.Downloads.QuarantineDir, override.Network.Downloads.QuarantineDir),
+
+				// Flag what either layer flags
+				FlagExecutables: base.Network.Downloads.FlagExecutables || override.Network.Downloads.FlagExecutables,
+				ContentTypes:    mergeStrings(base.Network.Downloads.ContentTypes, override.Network.Downloads.ContentTypes),
+			},
+
+			Uploads: UploadsConfig{
+				// Restrict if either layer does; the size limit: override wins if set
+				Restrict:    base.Network.Uploads.Restrict || override.Network.Uploads.Restrict,
+				MaxSize:     mergeInt64(base.Network.Uploads.MaxSize, override.Network.Uploads.MaxSize),
+				AllowUpload: mergeStrings(base.Network.Uploads.AllowUpload, override.Network.Uploads.AllowUpload),
+			},
 		},
 
 		Filesystem: FilesystemConfig{
@@ -434,28 +1349,130 @@ func Merge(base, override *Config) *Config {
 
 			// Boolean fields: override wins if set
 			AllowGitConfig: base.Filesystem.AllowGitConfig || override.Filesystem.AllowGitConfig,
+			PrivateHome:    base.Filesystem.PrivateHome || override.Filesystem.PrivateHome,
+			DenySecrets:    base.Filesystem.DenySecrets || override.Filesystem.DenySecrets,
+
+			// Quota: override wins if set
+			MaxWriteBytes: mergeInt64(base.Filesystem.MaxWriteBytes, override.Filesystem.MaxWriteBytes),
 		},
 
 		Command: CommandConfig{
 			// Append slices
-			Deny:  mergeStrings(base.Command.Deny, override.Command.Deny),
-			Allow: mergeStrings(base.Command.Allow, override.Command.Allow),
+			Deny:      mergeStrings(base.Command.Deny, override.Command.Deny),
+			Warn:      mergeStrings(base.Command.Warn, override.Command.Warn),
+			Allow:     mergeStrings(base.Command.Allow, override.Command.Allow),
+			DenyFlags: append(slices.Clone(base.Command.DenyFlags), override.Command.DenyFlags...),
 
 			// Pointer field: override wins if set
 			UseDefaults: mergeOptionalBool(base.Command.UseDefaults, override.Command.UseDefaults),
+			EnforceExec: mergeOptionalBool(base.Command.EnforceExec, override.Command.EnforceExec),
 		},
 
 		SSH: SSHConfig{
 			// Append slices
 			AllowedHosts:    mergeStrings(base.SSH.AllowedHosts, override.SSH.AllowedHosts),
 			DeniedHosts:     mergeStrings(base.SSH.DeniedHosts, override.SSH.DeniedHosts),
+			WarnHosts:       mergeStrings(base.SSH.WarnHosts, override.SSH.WarnHosts),
 			AllowedCommands: mergeStrings(base.SSH.AllowedCommands, override.SSH.AllowedCommands),
 			DeniedCommands:  mergeStrings(base.SSH.DeniedCommands, override.SSH.DeniedCommands),
+			WarnCommands:    mergeStrings(base.SSH.WarnCommands, override.SSH.WarnCommands),
 
 			// Boolean fields: true if either enables it
 			AllowAllCommands: base.SSH.AllowAllCommands || override.SSH.AllowAllCommands,
 			InheritDeny:      base.SSH.InheritDeny || override.SSH.InheritDeny,
 		},
+
+		DBus: DBusConfig{
+			// Append slices
+			Allow:       mergeStrings(base.DBus.Allow, override.DBus.Allow),
+			Deny:        mergeStrings(base.DBus.Deny, override.DBus.Deny),
+			SystemAllow: mergeStrings(base.DBus.SystemAllow, override.DBus.SystemAllow),
+		},
+
+		GUI: GUIConfig{
+			// Boolean fields: true if either enables it
+			AllowDisplay: base.GUI.AllowDisplay || override.GUI.AllowDisplay,
+		},
+
+		Devices: DevicesConfig{
+			// Boolean fields: true if either enables it
+			AllowAudio:  base.Devices.AllowAudio || override.Devices.AllowAudio,
+			AllowCamera: base.Devices.AllowCamera || override.Devices.AllowCamera,
+		},
+
+		MacOS: MacOSConfig{
+			// Append slices
+			ExtraProfileRules: mergeStrings(base.MacOS.ExtraProfileRules, override.MacOS.ExtraProfileRules),
+		},
+
+		Security: SecurityConfig{
+			// Boolean fields: true if either enables it
+			MapToNobody: base.Security.MapToNobody || override.Security.MapToNobody,
+			// LSM: override wins if set
+			LSM: mergeString(base.Security.LSM, override.Security.LSM),
+		},
+
+		Seccomp: SeccompConfig{
+			// Profile and action: override wins if set
+			Profile: mergeString(base.Seccomp.Profile, override.Seccomp.Profile),
+			Action:  mergeString(base.Seccomp.Action, override.Seccomp.Action),
+			// Syscall lists: combine
+			Block: mergeStrings(base.Seccomp.Block, override.Seccomp.Block),
+			Allow: mergeStrings(base.Seccomp.Allow, override.Seccomp.Allow),
+		},
+
+		Resources: ResourcesConfig{
+			// Limits: override wins if set
+			Memory:  mergeInt64(base.Resources.Memory, override.Resources.Memory),
+			CPUs:    mergeFloat64(base.Resources.CPUs, override.Resources.CPUs),
+			PidsMax: mergeInt(base.Resources.PidsMax, override.Resources.PidsMax),
+		},
+
+		Supervisor: SupervisorConfig{
+			// Override wins if set
+			URL:       mergeString(base.Supervisor.URL, override.Supervisor.URL),
+			TokenEnv:  mergeString(base.Supervisor.TokenEnv, override.Supervisor.TokenEnv),
+			Node:      mergeString(base.Supervisor.Node, override.Supervisor.Node),
+			Heartbeat: mergeInt(base.Supervisor.Heartbeat, override.Supervisor.Heartbeat),
+		},
+
+		Telemetry: TelemetryConfig{
+			// Override wins if set
+			OTLPEndpoint: mergeString(base.Telemetry.OTLPEndpoint, override.Telemetry.OTLPEndpoint),
+			HeadersEnv:   mergeString(base.Telemetry.HeadersEnv, override.Telemetry.HeadersEnv),
+			ServiceName:  mergeString(base.Telemetry.ServiceName, override.Telemetry.ServiceName),
+		},
+
+		Webhook: WebhookConfig{
+			// Override wins if set
+			URL:       mergeString(base.Webhook.URL, override.Webhook.URL),
+			SecretEnv: mergeString(base.Webhook.SecretEnv, override.Webhook.SecretEnv),
+		},
+
+		Logging: LoggingConfig{
+			// Override wins if set
+			Level:  mergeString(base.Logging.Level, override.Logging.Level),
+			Format: mergeString(base.Logging.Format, override.Logging.Format),
+		},
+
+		Env: EnvConfig{
+			// Append slices (base first, then override additions)
+			Allow: mergeStrings(base.Env.Allow, override.Env.Allow),
+			Deny:  mergeStrings(base.Env.Deny, override.Env.Deny),
+
+			// Override wins for each variable it sets
+			Set: mergeStringMaps(base.Env.Set, override.Env.Set),
+		},
+
+		// Override wins if set
+		PolicyURL: mergeString(base.PolicyURL, override.PolicyURL),
+		PolicyKey: mergeString(base.PolicyKey, override.PolicyKey),
+
+		Policy: PolicyConfig{
+			// Override wins if set
+			Rego:  mergeString(base.Policy.Rego, override.Policy.Rego),
+			Query: mergeString(base.Policy.Query, override.Policy.Query),
+		},
 	}
 
 	return result
@@ -488,6 +1505,15 @@ func mergeStrings(base, override []string) []string {
 	return result
 }
 
+// replaceStrings returns override if non-empty, otherwise base, for lists
+// whose order matters.
+func replaceStrings(base, override []string) []string {
+	if len(override) > 0 {
+		return override
+	}
+	return base
+}
+
 // mergeOptionalBool returns override if non-nil, otherwise base.
 func mergeOptionalBool(base, override *bool) *bool {
 	if override != nil {
@@ -503,3 +1529,39 @@ func mergeInt(base, override int) int {
 	}
 	return base
 }
+
+// mergeInt64 returns override if non-zero, otherwise base.
+func mergeInt64(base, override int64) int64 {
+	if override != 0 {
+		return override
+	}
+	return base
+}
+
+// mergeFloat64 returns override if non-zero, otherwise base.
+func mergeFloat64(base, override float64) float64 {
+	if override != 0 {
+		return override
+	}
+	return base
+}
+
+// mergeStringMaps returns the entries of base and override, with override's
+// winning where both set a key.
+func mergeStringMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(base)+len(override))
+	maps.Copy(result, base)
+	maps.Copy(result, override)
+	return result
+}
+
+// mergeString returns override if non-empty, otherwise base.
+func mergeString(base, override string) string {
+	if override != "" {
+		return override
+	}
+	return base
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -17,6 +21,7 @@ func TestValidateDomainPattern(t *testing.T) {
 		{"valid subdomain", "api.example.com", false},
 		{"valid wildcard", "*.example.com", false},
 		{"valid wildcard subdomain", "*.api.example.com", false},
+		{"valid suffix", ".example.com", false},
 		{"localhost", "localhost", false},
 
 		// Invalid patterns
@@ -26,7 +31,9 @@ func TestValidateDomainPattern(t *testing.T) {
 		{"wildcard too broad", "*.com", true},
 		{"invalid wildcard position", "example.*.com", true},
 		{"trailing wildcard", "example.com.*", true},
-		{"leading dot", ".example.com", true},
+		{"suffix too broad", ".com", true},
+		{"double leading dot", "..example.com", true},
+		{"wildcard and leading dot", "*..example.com", true},
 		{"trailing dot", "example.com.", true},
 		{"no TLD", "example", true},
 		{"empty wildcard domain part", "*.", true},
@@ -34,9 +41,9 @@ func TestValidateDomainPattern(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateDomainPattern(tt.pattern)
+			err := ValidateDomainPattern(tt.pattern)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("validateDomainPattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+				t.Errorf("ValidateDomainPattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
 			}
 		})
 	}
@@ -60,6 +67,13 @@ func TestMatchesDomain(t *testing.T) {
 		{"wildcard no match base domain", "example.com", "*.example.com", false},
 		{"wildcard no match different domain", "api.other.com", "*.example.com", false},
 		{"wildcard case insensitive", "API.Example.COM", "*.example.com", true},
+
+		// Suffix matches
+		{"suffix match base domain", "example.com", ".example.com", true},
+		{"suffix match subdomain", "api.example.com", ".example.com", true},
+		{"suffix match deep subdomain", "deep.api.example.com", ".example.com", true},
+		{"suffix no match suffix of label", "badexample.com", ".example.com", false},
+		{"suffix case insensitive", "API.Example.COM", ".example.com", true},
 	}
 
 	for _, tt := range tests {
@@ -111,6 +125,352 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid tls rule",
+			config: Config{
+				Network: NetworkConfig{
+					TLS: []TLSRule{{Domains: []string{"*.bank.com"}, MinVersion: "1.2", Ciphers: []string{"TLS_AES_128_GCM_SHA256"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tls rule without domains",
+			config: Config{
+				Network: NetworkConfig{
+					TLS: []TLSRule{{MinVersion: "1.2"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls rule with invalid minVersion",
+			config: Config{
+				Network: NetworkConfig{
+					TLS: []TLSRule{{Domains: []string{"bank.com"}, MinVersion: "1.4"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls rule with unknown cipher",
+			config: Config{
+				Network: NetworkConfig{
+					TLS: []TLSRule{{Domains: []string{"bank.com"}, MinVersion: "1.2", Ciphers: []string{"TLS_NOPE"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid http rule",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "api.internal", Methods: []string{"GET", "HEAD"}, MaxBodyBytes: 1024}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "http rule with invalid domain",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "*.com", Methods: []string{"GET"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http rule with lowercase method",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "api.internal", Methods: []string{"get"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http rule with negative maxBodyBytes",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "api.internal", MaxBodyBytes: -1}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http rule with request patterns and TLS inspection",
+			config: Config{
+				Network: NetworkConfig{
+					InspectTLS: true,
+					HTTPRules:  []HTTPRule{{Domain: "api.github.com", Allow: []string{"GET /repos/**", "/user"}, Deny: []string{"POST /user/keys"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "http rule pattern without leading slash",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "api.github.com", Allow: []string{"GET repos/*"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http rule pattern with lowercase method",
+			config: Config{
+				Network: NetworkConfig{
+					HTTPRules: []HTTPRule{{Domain: "api.github.com", Deny: []string{"post /user/keys"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "inspectTLS with nothing to inspect",
+			config: Config{
+				Network: NetworkConfig{InspectTLS: true, BlockPublishing: boolPtr(false)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "restricted uploads with TLS inspection",
+			config: Config{
+				Network: NetworkConfig{
+					InspectTLS: true,
+					Uploads:    UploadsConfig{Restrict: true, MaxSize: 4096, AllowUpload: []string{"*.github.com"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "uploads allowUpload without restrict",
+			config: Config{
+				Network: NetworkConfig{
+					Uploads: UploadsConfig{AllowUpload: []string{"github.com"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "connection and bandwidth limits",
+			config:  Config{Network: NetworkConfig{MaxConnectionsPerMinute: 120, MaxBandwidthKbps: 10000}},
+			wantErr: false,
+		},
+		{
+			name:    "negative maxConnectionsPerMinute",
+			config:  Config{Network: NetworkConfig{MaxConnectionsPerMinute: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "negative maxBandwidthKbps",
+			config:  Config{Network: NetworkConfig{MaxBandwidthKbps: -1}},
+			wantErr: true,
+		},
+		{
+			name: "negative uploads maxSize",
+			config: Config{
+				Network: NetworkConfig{
+					Uploads: UploadsConfig{Restrict: true, MaxSize: -1},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid downloads config",
+			config: Config{
+				Network: NetworkConfig{
+					Downloads: DownloadsConfig{MaxSize: 1 << 20, FlagExecutables: true, ContentTypes: []string{"application/zip", "image/*"}, QuarantineDir: "./quarantine"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "downloads with negative maxSize",
+			config: Config{
+				Network: NetworkConfig{
+					Downloads: DownloadsConfig{MaxSize: -1},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "valid resources config",
+			config:  Config{Resources: ResourcesConfig{Memory: 512 << 20, CPUs: 1.5, PidsMax: 256}},
+			wantErr: false,
+		},
+		{
+			name:    "filesystem with negative maxWriteBytes",
+			config:  Config{Filesystem: FilesystemConfig{MaxWriteBytes: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "resources with negative cpus",
+			config:  Config{Resources: ResourcesConfig{CPUs: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "resources with negative pidsMax",
+			config:  Config{Resources: ResourcesConfig{PidsMax: -1}},
+			wantErr: true,
+		},
+		{
+			name: "downloads with invalid content type",
+			config: Config{
+				Network: NetworkConfig{
+					Downloads: DownloadsConfig{ContentTypes: []string{"application/*zip"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "downloads quarantine without anything to flag",
+			config: Config{
+				Network: NetworkConfig{
+					Downloads: DownloadsConfig{QuarantineDir: "./quarantine"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "direct upstream proxy",
+			config:  Config{Network: NetworkConfig{UpstreamProxy: "direct"}},
+			wantErr: false,
+		},
+		{
+			name:    "upstream proxy URL",
+			config:  Config{Network: NetworkConfig{UpstreamProxy: "http://proxy.corp:8080"}},
+			wantErr: false,
+		},
+		{
+			name:    "unix socket proxies",
+			config:  Config{Network: NetworkConfig{UnixSocketProxies: true}},
+			wantErr: false,
+		},
+		{
+			name:    "unix socket proxies with a fixed port",
+			config:  Config{Network: NetworkConfig{UnixSocketProxies: true, HTTPProxyPort: 3128}},
+			wantErr: true,
+		},
+		{
+			name:    "socks upstream proxy",
+			config:  Config{Network: NetworkConfig{UpstreamProxy: "socks5://127.0.0.1:1080"}},
+			wantErr: false,
+		},
+		{
+			name:    "socks4 upstream proxy",
+			config:  Config{Network: NetworkConfig{UpstreamProxy: "socks4://127.0.0.1:1080"}},
+			wantErr: true,
+		},
+		{
+			name:    "dns servers",
+			config:  Config{Network: NetworkConfig{DNS: DNSConfig{Servers: []string{"1.1.1.1", "dns.corp:5353"}}}},
+			wantErr: false,
+		},
+		{
+			name:    "dns server with bad port",
+			config:  Config{Network: NetworkConfig{DNS: DNSConfig{Servers: []string{"1.1.1.1:0"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "dns DoH",
+			config:  Config{Network: NetworkConfig{DNS: DNSConfig{DoH: "https://dns.example/dns-query"}}},
+			wantErr: false,
+		},
+		{
+			name:    "dns DoH plain http",
+			config:  Config{Network: NetworkConfig{DNS: DNSConfig{DoH: "http://dns.example/dns-query"}}},
+			wantErr: true,
+		},
+		{
+			name:    "dns search domains",
+			config:  Config{Network: NetworkConfig{FilterDNS: true, DNS: DNSConfig{Search: []string{"corp.example"}}}},
+			wantErr: false,
+		},
+		{
+			name:    "dns search wildcard",
+			config:  Config{Network: NetworkConfig{FilterDNS: true, DNS: DNSConfig{Search: []string{"*.corp.example"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "dns search without filterDNS",
+			config:  Config{Network: NetworkConfig{DNS: DNSConfig{Search: []string{"corp.example"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "lsm apparmor",
+			config:  Config{Security: SecurityConfig{LSM: LSMAppArmor}},
+			wantErr: false,
+		},
+		{
+			name:    "lsm auto",
+			config:  Config{Security: SecurityConfig{LSM: LSMAuto}},
+			wantErr: false,
+		},
+		{
+			name:    "unknown lsm",
+			config:  Config{Security: SecurityConfig{LSM: "smack"}},
+			wantErr: true,
+		},
+		{
+			name: "macos extra profile rules",
+			config: Config{MacOS: MacOSConfig{ExtraProfileRules: []string{
+				`(allow mach-lookup (global-name "com.apple.pasteboard.1"))`,
+				`; Keychain access for git credential helpers
+(allow file-read* (regex #"^/Users/[^/]+/Library/Keychains/"))
+(allow mach-lookup (global-name "com.apple.SecurityServer"))`,
+			}}},
+			wantErr: false,
+		},
+		{
+			name:    "unbalanced macos profile rule",
+			config:  Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`(allow mach-lookup (global-name "a")`}}},
+			wantErr: true,
+		},
+		{
+			name:    "macos profile rule closing the profile's expression",
+			config:  Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`) (allow default`}}},
+			wantErr: true,
+		},
+		{
+			name:    "macos profile rule with a parenthesis in a string",
+			config:  Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`(allow file-read* (literal "/tmp/a)b"))`}}},
+			wantErr: false,
+		},
+		{
+			name:    "bare macos profile rule",
+			config:  Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`allow network*`}}},
+			wantErr: true,
+		},
+		{
+			name:    "empty macos profile rule",
+			config:  Config{MacOS: MacOSConfig{ExtraProfileRules: []string{""}}},
+			wantErr: true,
+		},
+		{
+			name:    "strict seccomp profile",
+			config:  Config{Seccomp: SeccompConfig{Profile: SeccompProfileStrict, Allow: []string{"io_uring_setup"}, Action: SeccompActionKill}},
+			wantErr: false,
+		},
+		{
+			name:    "unknown seccomp profile",
+			config:  Config{Seccomp: SeccompConfig{Profile: "paranoid"}},
+			wantErr: true,
+		},
+		{
+			name:    "seccomp notify action",
+			config:  Config{Seccomp: SeccompConfig{Action: SeccompActionNotify}},
+			wantErr: false,
+		},
+		{
+			name:    "unknown seccomp action",
+			config:  Config{Seccomp: SeccompConfig{Action: "trap"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid seccomp syscall",
+			config:  Config{Seccomp: SeccompConfig{Block: []string{"SYS_PTRACE"}}},
+			wantErr: true,
+		},
 		{
 			name: "empty denyRead path",
 			config: Config{
@@ -138,6 +498,62 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid dbus names",
+			config: Config{
+				DBus: DBusConfig{
+					Allow:       []string{"org.freedesktop.Notifications"},
+					Deny:        []string{"org.freedesktop.secrets"},
+					SystemAllow: []string{"org.freedesktop.NetworkManager"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "dbus allow wildcard",
+			config: Config{
+				DBus: DBusConfig{
+					Allow: []string{"*"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dbus systemAllow wildcard",
+			config: Config{
+				DBus: DBusConfig{
+					SystemAllow: []string{"*"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dbus name with wildcard",
+			config: Config{
+				DBus: DBusConfig{
+					Allow: []string{"org.freedesktop.*"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dbus name with single element",
+			config: Config{
+				DBus: DBusConfig{
+					SystemAllow: []string{"NetworkManager"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dbus deny wildcard",
+			config: Config{
+				DBus: DBusConfig{
+					Deny: []string{"*"},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -241,6 +657,30 @@ func TestLoad(t *testing.T) {
 			wantNil: false,
 			wantErr: true,
 		},
+		{
+			name: "http rule with maxRequestBytes",
+			setup: func(dir string) string {
+				path := filepath.Join(dir, "max_request_bytes.json")
+				content := `{"network":{"httpRules":[{"domain":"api.internal","methods":["GET"],"maxRequestBytes":1048576}]}}`
+				_ = os.WriteFile(path, []byte(content), 0o600)
+				return path
+			},
+			checkConfig: func(t *testing.T, cfg *Config) {
+				if got := cfg.Network.HTTPRules[0].MaxBodyBytes; got != 1048576 {
+					t.Errorf("expected maxRequestBytes to set MaxBodyBytes 1048576, got %d", got)
+				}
+			},
+		},
+		{
+			name: "http rule with conflicting maxRequestBytes and maxBodyBytes",
+			setup: func(dir string) string {
+				path := filepath.Join(dir, "conflicting_max_bytes.json")
+				content := `{"network":{"httpRules":[{"domain":"api.internal","maxBodyBytes":1024,"maxRequestBytes":2048}]}}`
+				_ = os.WriteFile(path, []byte(content), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid domain in config",
 			setup: func(dir string) string {
@@ -687,3 +1127,551 @@ func TestMergeSSHConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestMergeSecurityConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     bool
+		override bool
+		want     bool
+	}{
+		{"neither", false, false, false},
+		{"base only", true, false, true},
+		{"override only", false, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &Config{Security: SecurityConfig{MapToNobody: tt.base}}
+			override := &Config{Security: SecurityConfig{MapToNobody: tt.override}}
+			if got := Merge(base, override).Security.MapToNobody; got != tt.want {
+				t.Errorf("MapToNobody = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDBusConfig(t *testing.T) {
+	base := &Config{DBus: DBusConfig{
+		Allow: []string{"org.freedesktop.Notifications"},
+		Deny:  []string{"org.gnome.keyring"},
+	}}
+	override := &Config{DBus: DBusConfig{
+		Allow:       []string{"org.freedesktop.Notifications", "org.freedesktop.portal.Desktop"},
+		SystemAllow: []string{"org.freedesktop.NetworkManager"},
+	}}
+
+	got := Merge(base, override).DBus
+	if want := []string{"org.freedesktop.Notifications", "org.freedesktop.portal.Desktop"}; !slices.Equal(got.Allow, want) {
+		t.Errorf("Allow = %v, want %v", got.Allow, want)
+	}
+	if want := []string{"org.gnome.keyring"}; !slices.Equal(got.Deny, want) {
+		t.Errorf("Deny = %v, want %v", got.Deny, want)
+	}
+	if want := []string{"org.freedesktop.NetworkManager"}; !slices.Equal(got.SystemAllow, want) {
+		t.Errorf("SystemAllow = %v, want %v", got.SystemAllow, want)
+	}
+}
+
+func TestMergeMacOSConfig(t *testing.T) {
+	base := &Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`(allow mach-lookup (global-name "a"))`}}}
+	override := &Config{MacOS: MacOSConfig{ExtraProfileRules: []string{`(allow mach-lookup (global-name "b"))`, `(allow mach-lookup (global-name "a"))`}}}
+
+	got := Merge(base, override).MacOS.ExtraProfileRules
+	if want := []string{`(allow mach-lookup (global-name "a"))`, `(allow mach-lookup (global-name "b"))`}; !slices.Equal(got, want) {
+		t.Errorf("ExtraProfileRules = %v, want %v", got, want)
+	}
+}
+
+func TestMergeDownloadsConfig(t *testing.T) {
+	base := &Config{Network: NetworkConfig{Downloads: DownloadsConfig{
+		MaxSize:       1024,
+		ContentTypes:  []string{"application/zip"},
+		QuarantineDir: "/var/tmp/quarantine",
+	}}}
+	override := &Config{Network: NetworkConfig{Downloads: DownloadsConfig{
+		FlagExecutables: true,
+		ContentTypes:    []string{"application/gzip"},
+	}}}
+
+	got := Merge(base, override).Network.Downloads
+	if got.MaxSize != 1024 || got.QuarantineDir != "/var/tmp/quarantine" {
+		t.Errorf("MaxSize, QuarantineDir = %d, %q; want base values", got.MaxSize, got.QuarantineDir)
+	}
+	if !got.FlagExecutables {
+		t.Error("FlagExecutables should be set by the override")
+	}
+	if want := []string{"application/zip", "application/gzip"}; !slices.Equal(got.ContentTypes, want) {
+		t.Errorf("ContentTypes = %v, want %v", got.ContentTypes, want)
+	}
+
+	override.Network.Downloads.MaxSize = 2048
+	if got := Merge(base, override).Network.Downloads.MaxSize; got != 2048 {
+		t.Errorf("MaxSize = %d, want the override's 2048", got)
+	}
+}
+
+func TestMergeUploadsConfig(t *testing.T) {
+	base := &Config{Network: NetworkConfig{Uploads: UploadsConfig{
+		Restrict:    true,
+		MaxSize:     1024,
+		AllowUpload: []string{"github.com"},
+	}}}
+	override := &Config{Network: NetworkConfig{Uploads: UploadsConfig{
+		AllowUpload: []string{"*.npmjs.org"},
+	}}}
+
+	got := Merge(base, override).Network.Uploads
+	if !got.Restrict || got.MaxSize != 1024 {
+		t.Errorf("Restrict, MaxSize = %v, %d; want base values", got.Restrict, got.MaxSize)
+	}
+	if want := []string{"github.com", "*.npmjs.org"}; !slices.Equal(got.AllowUpload, want) {
+		t.Errorf("AllowUpload = %v, want %v", got.AllowUpload, want)
+	}
+}
+
+func TestMergeResourcesConfig(t *testing.T) {
+	base := &Config{Resources: ResourcesConfig{Memory: 1 << 30, PidsMax: 512}}
+	override := &Config{Resources: ResourcesConfig{CPUs: 2, PidsMax: 128}}
+
+	got := Merge(base, override).Resources
+	want := ResourcesConfig{Memory: 1 << 30, CPUs: 2, PidsMax: 128}
+	if got != want {
+		t.Errorf("Resources = %+v, want %+v", got, want)
+	}
+	if !got.Limited() {
+		t.Error("Limited() = false, want true")
+	}
+	if (ResourcesConfig{}).Limited() {
+		t.Error("Limited() = true for no limits")
+	}
+}
+
+func TestMergeMaxWriteBytes(t *testing.T) {
+	base := &Config{Filesystem: FilesystemConfig{MaxWriteBytes: 1 << 30}}
+	if got := Merge(base, &Config{}).Filesystem.MaxWriteBytes; got != 1<<30 {
+		t.Errorf("MaxWriteBytes = %d, want the base's %d", got, 1<<30)
+	}
+	override := &Config{Filesystem: FilesystemConfig{MaxWriteBytes: 1 << 20}}
+	if got := Merge(base, override).Filesystem.MaxWriteBytes; got != 1<<20 {
+		t.Errorf("MaxWriteBytes = %d, want the override's %d", got, 1<<20)
+	}
+}
+
+func TestMergePrivateHome(t *testing.T) {
+	base := &Config{Filesystem: FilesystemConfig{PrivateHome: true}}
+	if !Merge(base, &Config{}).Filesystem.PrivateHome {
+		t.Error("PrivateHome = false, want the base's true")
+	}
+	if !Merge(&Config{}, base).Filesystem.PrivateHome {
+		t.Error("PrivateHome = false, want the override's true")
+	}
+}
+
+func TestMergeSupervisorConfig(t *testing.T) {
+	base := &Config{Supervisor: SupervisorConfig{URL: "wss://fleet.example.com/nodes", TokenEnv: "FLEET_TOKEN", Heartbeat: 30}}
+	override := &Config{Supervisor: SupervisorConfig{Node: "builder-1", Heartbeat: 5}}
+	got := Merge(base, override).Supervisor
+	want := SupervisorConfig{URL: "wss://fleet.example.com/nodes", TokenEnv: "FLEET_TOKEN", Node: "builder-1", Heartbeat: 5}
+	if got != want {
+		t.Errorf("Supervisor = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateSupervisorConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		sup     SupervisorConfig
+		wantErr bool
+	}{
+		{"disabled", SupervisorConfig{}, false},
+		{"ws", SupervisorConfig{URL: "ws://127.0.0.1:9000/fence"}, false},
+		{"wss", SupervisorConfig{URL: "wss://fleet.example.com", Heartbeat: 60}, false},
+		{"ws to localhost", SupervisorConfig{URL: "ws://localhost:9000"}, false},
+		{"ws to ipv6 loopback", SupervisorConfig{URL: "ws://[::1]:9000"}, false},
+		{"ws to remote host", SupervisorConfig{URL: "ws://fleet.example.com"}, true},
+		{"ws to remote address", SupervisorConfig{URL: "ws://10.0.0.5:9000"}, true},
+		{"http scheme", SupervisorConfig{URL: "https://fleet.example.com"}, true},
+		{"no host", SupervisorConfig{URL: "ws:///fence"}, true},
+		{"negative heartbeat", SupervisorConfig{URL: "ws://localhost", Heartbeat: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Supervisor = tt.sup
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeTelemetryConfig(t *testing.T) {
+	base := &Config{Telemetry: TelemetryConfig{OTLPEndpoint: "http://localhost:4318", ServiceName: "agents"}}
+	override := &Config{Telemetry: TelemetryConfig{HeadersEnv: "COLLECTOR_HEADERS"}}
+	got := Merge(base, override).Telemetry
+	want := TelemetryConfig{OTLPEndpoint: "http://localhost:4318", HeadersEnv: "COLLECTOR_HEADERS", ServiceName: "agents"}
+	if got != want {
+		t.Errorf("Telemetry = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateTelemetryConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		tel     TelemetryConfig
+		wantErr bool
+	}{
+		{"disabled", TelemetryConfig{}, false},
+		{"http", TelemetryConfig{OTLPEndpoint: "http://localhost:4318"}, false},
+		{"https", TelemetryConfig{OTLPEndpoint: "https://otel.example.com/otlp"}, false},
+		{"grpc scheme", TelemetryConfig{OTLPEndpoint: "grpc://localhost:4317"}, true},
+		{"no host", TelemetryConfig{OTLPEndpoint: "http:///v1/traces"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Telemetry = tt.tel
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeWebhookConfig(t *testing.T) {
+	base := &Config{Webhook: WebhookConfig{URL: "https://alerts.example.com/fence", SecretEnv: "ALERTS_SECRET"}}
+	override := &Config{Webhook: WebhookConfig{URL: "https://hooks.example.com/fence"}}
+	got := Merge(base, override).Webhook
+	want := WebhookConfig{URL: "https://hooks.example.com/fence", SecretEnv: "ALERTS_SECRET"}
+	if got != want {
+		t.Errorf("Webhook = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateWebhookConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"disabled", "", false},
+		{"https", "https://alerts.example.com/fence", false},
+		{"http", "http://localhost:8080/hook", false},
+		{"websocket", "wss://alerts.example.com/fence", true},
+		{"relative", "/hook", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Webhook.URL = tt.url
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStatsEnabled(t *testing.T) {
+	off := false
+	if !Default().StatsEnabled() {
+		t.Error("stats should be kept by default")
+	}
+	if Merge(&Config{Stats: &off}, &Config{}).StatsEnabled() {
+		t.Error("stats: false should carry through a merge")
+	}
+}
+
+func TestUseExecEnforcement(t *testing.T) {
+	on, off := true, false
+	if Default().Command.UseExecEnforcement() {
+		t.Error("exec enforcement should be off by default")
+	}
+	merged := Merge(&Config{Command: CommandConfig{EnforceExec: &on}}, &Config{})
+	if !merged.Command.UseExecEnforcement() {
+		t.Error("enforceExec: true should carry through a merge")
+	}
+	merged = Merge(merged, &Config{Command: CommandConfig{EnforceExec: &off}})
+	if merged.Command.UseExecEnforcement() {
+		t.Error("enforceExec: false in the override should win")
+	}
+}
+
+func TestMergeLoggingConfig(t *testing.T) {
+	base := &Config{Logging: LoggingConfig{Level: "warn", Format: "json"}}
+	override := &Config{Logging: LoggingConfig{Level: "debug"}}
+	got := Merge(base, override).Logging
+	want := LoggingConfig{Level: "debug", Format: "json"}
+	if got != want {
+		t.Errorf("Logging = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateLoggingConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		logging LoggingConfig
+		wantErr bool
+	}{
+		{"default", LoggingConfig{}, false},
+		{"level and format", LoggingConfig{Level: "warn", Format: "json"}, false},
+		{"unknown level", LoggingConfig{Level: "verbose"}, true},
+		{"unknown format", LoggingConfig{Format: "logfmt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Logging = tt.logging
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PolicyConfig
+		wantErr bool
+	}{
+		{"default", PolicyConfig{}, false},
+		{"rego", PolicyConfig{Rego: "fence.rego"}, false},
+		{"rego and query", PolicyConfig{Rego: "fence.rego", Query: "data.acme.allow"}, false},
+		{"query without rego", PolicyConfig{Query: "data.acme.allow"}, true},
+		{"query outside data", PolicyConfig{Rego: "fence.rego", Query: "input.kind"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Policy = tt.policy
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyURL(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	tests := []struct {
+		name    string
+		url     string
+		key     string
+		wantErr bool
+	}{
+		{"none", "", "", false},
+		{"https", "https://fleet.example.com/fence.json", "", false},
+		{"https with key", "https://fleet.example.com/fence.json", key, false},
+		{"http with key", "http://fleet.internal/fence.json", key, false},
+		{"http without key", "http://fleet.internal/fence.json", "", true},
+		{"not a URL", "fleet.example.com/fence.json", "", true},
+		{"short key", "https://fleet.example.com/fence.json", "c2hvcnQ=", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.PolicyURL, cfg.PolicyKey = tt.url, tt.key
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDenySecrets(t *testing.T) {
+	base := &Config{Filesystem: FilesystemConfig{DenySecrets: true}}
+	if !Merge(&Config{}, base).Filesystem.DenySecrets || !Merge(base, &Config{}).Filesystem.DenySecrets {
+		t.Error("DenySecrets should be set if either config sets it")
+	}
+
+	fs := FilesystemConfig{DenyRead: make([]string, 1, 8)}
+	fs.DenyRead[0] = "/secrets"
+	if got := fs.DenyReadPaths(); !slices.Equal(got, []string{"/secrets"}) {
+		t.Errorf("DenyReadPaths() = %q, want only denyRead", got)
+	}
+	fs.DenySecrets = true
+	got := fs.DenyReadPaths()
+	if len(got) != 1+len(DefaultSecretPaths) || got[0] != "/secrets" || !slices.Contains(got, "~/.ssh") {
+		t.Errorf("DenyReadPaths() = %q, want denyRead followed by DefaultSecretPaths", got)
+	}
+	if len(fs.DenyRead) != 1 || fs.DenyRead[:2][1] != "" {
+		t.Error("DenyReadPaths() must not modify denyRead")
+	}
+}
+
+func TestMergeEnvConfig(t *testing.T) {
+	base := &Config{Env: EnvConfig{Deny: []string{"AWS_*"}, Set: map[string]string{"CI": "1", "NODE_ENV": "development"}}}
+	override := &Config{Env: EnvConfig{Allow: []string{"PATH"}, Deny: []string{"*_TOKEN"}, Set: map[string]string{"NODE_ENV": "test"}}}
+	got := Merge(base, override).Env
+	want := EnvConfig{Allow: []string{"PATH"}, Deny: []string{"AWS_*", "*_TOKEN"}, Set: map[string]string{"CI": "1", "NODE_ENV": "test"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Env = %+v, want %+v", got, want)
+	}
+	if Merge(&Config{}, &Config{}).Env.Set != nil {
+		t.Error("Env.Set should stay nil when neither config sets it")
+	}
+}
+
+func TestValidateEnvConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     EnvConfig
+		wantErr bool
+	}{
+		{"empty", EnvConfig{}, false},
+		{"wildcards", EnvConfig{Allow: []string{"PATH", "LC_*"}, Deny: []string{"*_SECRET*"}}, false},
+		{"set", EnvConfig{Set: map[string]string{"CI": "1"}}, false},
+		{"empty deny entry", EnvConfig{Deny: []string{""}}, true},
+		{"assignment in allow", EnvConfig{Allow: []string{"PATH=/bin"}}, true},
+		{"bad pattern", EnvConfig{Deny: []string{"AWS_["}}, true},
+		{"bad set name", EnvConfig{Set: map[string]string{"A=B": "1"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Env = tt.env
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeUpstreamProxy(t *testing.T) {
+	base := &Config{Network: NetworkConfig{UpstreamProxy: "http://proxy.corp:8080"}}
+	if got := Merge(base, &Config{}).Network.UpstreamProxy; got != "http://proxy.corp:8080" {
+		t.Errorf("UpstreamProxy = %q, want the base's", got)
+	}
+	override := &Config{Network: NetworkConfig{UpstreamProxy: UpstreamDirect}}
+	if got := Merge(base, override).Network.UpstreamProxy; got != UpstreamDirect {
+		t.Errorf("UpstreamProxy = %q, want the override's %q", got, UpstreamDirect)
+	}
+}
+
+func TestMergeProxyAuth(t *testing.T) {
+	base := &Config{Network: NetworkConfig{ProxyAuth: true}}
+	if !Merge(base, &Config{}).Network.ProxyAuth {
+		t.Error("ProxyAuth = false, want the base's true")
+	}
+	if !Merge(&Config{}, base).Network.ProxyAuth {
+		t.Error("ProxyAuth = false, want the override's true")
+	}
+}
+
+func TestMergeUnixSocketProxies(t *testing.T) {
+	base := &Config{Network: NetworkConfig{UnixSocketProxies: true}}
+	if !Merge(base, &Config{}).Network.UnixSocketProxies {
+		t.Error("UnixSocketProxies = false, want the base's true")
+	}
+	if !Merge(&Config{}, base).Network.UnixSocketProxies {
+		t.Error("UnixSocketProxies = false, want the override's true")
+	}
+}
+
+func TestMergeFilterDNS(t *testing.T) {
+	base := &Config{Network: NetworkConfig{FilterDNS: true}}
+	if !Merge(&Config{}, base).Network.FilterDNS {
+		t.Error("FilterDNS = false, want the override's true")
+	}
+}
+
+func TestMergeDNS(t *testing.T) {
+	base := &Config{Network: NetworkConfig{DNS: DNSConfig{
+		Servers: []string{"1.1.1.1", "1.0.0.1"},
+		DoH:     "https://dns.example/dns-query",
+		Search:  []string{"corp.example"},
+	}}}
+	override := &Config{Network: NetworkConfig{DNS: DNSConfig{Servers: []string{"9.9.9.9"}}}}
+	got := Merge(base, override).Network.DNS
+	if !slices.Equal(got.Servers, []string{"9.9.9.9"}) {
+		t.Errorf("Servers = %v, want the override's in place of the base's", got.Servers)
+	}
+	if got.DoH != base.Network.DNS.DoH || !slices.Equal(got.Search, base.Network.DNS.Search) {
+		t.Errorf("DoH = %q, Search = %v; want the base's", got.DoH, got.Search)
+	}
+}
+
+func TestParseNameserver(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "1.1.1.1", want: "1.1.1.1:53"},
+		{in: "dns.corp:5353", want: "dns.corp:5353"},
+		{in: "2001:db8::1", want: "[2001:db8::1]:53"},
+		{in: "[2001:db8::1]:5353", want: "[2001:db8::1]:5353"},
+		{in: "https://dns.example/dns-query", wantErr: true},
+		{in: "dns.corp:0", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNameserver(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseNameserver(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeSecurityLSM(t *testing.T) {
+	base := &Config{Security: SecurityConfig{LSM: LSMAuto}}
+	if got := Merge(base, &Config{}).Security.LSM; got != LSMAuto {
+		t.Errorf("LSM = %q, want the base's", got)
+	}
+	override := &Config{Security: SecurityConfig{LSM: LSMSELinux}}
+	if got := Merge(base, override).Security.LSM; got != LSMSELinux {
+		t.Errorf("LSM = %q, want the override's %q", got, LSMSELinux)
+	}
+}
+
+func TestMergeSeccompConfig(t *testing.T) {
+	base := &Config{Seccomp: SeccompConfig{Profile: SeccompProfileStrict, Block: []string{"ptrace"}}}
+	override := &Config{Seccomp: SeccompConfig{Action: SeccompActionLog, Block: []string{"unshare"}, Allow: []string{"personality"}}}
+	got := Merge(base, override).Seccomp
+	want := SeccompConfig{
+		Profile: SeccompProfileStrict,
+		Block:   []string{"ptrace", "unshare"},
+		Allow:   []string{"personality"},
+		Action:  SeccompActionLog,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge().Seccomp = %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeGUIConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     bool
+		override bool
+		want     bool
+	}{
+		{"neither", false, false, false},
+		{"base only", true, false, true},
+		{"override only", false, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &Config{GUI: GUIConfig{AllowDisplay: tt.base}}
+			override := &Config{GUI: GUIConfig{AllowDisplay: tt.override}}
+			if got := Merge(base, override).GUI.AllowDisplay; got != tt.want {
+				t.Errorf("AllowDisplay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDevicesConfig(t *testing.T) {
+	base := &Config{Devices: DevicesConfig{AllowAudio: true}}
+	override := &Config{Devices: DevicesConfig{AllowCamera: true}}
+
+	got := Merge(base, override).Devices
+	if !got.AllowAudio || !got.AllowCamera {
+		t.Errorf("Devices = %+v, want both allowed", got)
+	}
+	if got := Merge(&Config{}, &Config{}).Devices; got.AllowAudio || got.AllowCamera {
+		t.Errorf("Devices = %+v, want both denied by default", got)
+	}
+}
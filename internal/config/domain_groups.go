@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DomainGroups are the built-in named domain lists, which allowedDomains and
+// deniedDomains entries name with an @ prefix: "@npm" stands for the npm
+// registry's domains.
+var DomainGroups = map[string][]string{
+	"crates": {"crates.io", "static.crates.io", "index.crates.io"},
+	"github": {
+		"github.com",
+		"api.github.com",
+		"raw.githubusercontent.com",
+		"codeload.github.com",
+		"objects.githubusercontent.com",
+	},
+	"go":   {"proxy.golang.org", "sum.golang.org"},
+	"npm":  {"registry.npmjs.org", "*.npmjs.org", "registry.yarnpkg.com"},
+	"pypi": {"pypi.org", "files.pythonhosted.org"},
+	"telemetry": {
+		"*.sentry.io",
+		"sentry.io",
+		"*.posthog.com",
+		"*.statsig.com",
+		"statsig.com",
+		"statsig.anthropic.com",
+		"*.segment.io",
+		"*.segment.com",
+		"*.amplitude.com",
+		"*.mixpanel.com",
+		"*.heap.io",
+		"*.heapanalytics.com",
+		"*.fullstory.com",
+		"*.hotjar.com",
+		"*.hotjar.io",
+		"*.logrocket.io",
+		"*.logrocket.com",
+		"*.bugsnag.com",
+		"*.datadoghq.com",
+		"*.newrelic.com",
+	},
+}
+
+// DomainGroupNames returns the names of the built-in domain groups, sorted.
+func DomainGroupNames() []string {
+	names := make([]string, 0, len(DomainGroups))
+	for name := range DomainGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandDomainGroups returns domains with each @group entry replaced by the
+// group's domains.
+func expandDomainGroups(domains []string) ([]string, error) {
+	if !slices.ContainsFunc(domains, isDomainGroup) {
+		return domains, nil
+	}
+	expanded := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if !isDomainGroup(domain) {
+			expanded = append(expanded, domain)
+			continue
+		}
+		group, ok := DomainGroups[domain[1:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown domain group %q: must be one of @%s", domain, strings.Join(DomainGroupNames(), ", @"))
+		}
+		expanded = append(expanded, group...)
+	}
+	return expanded, nil
+}
+
+func isDomainGroup(domain string) bool {
+	return strings.HasPrefix(domain, "@")
+}
+
+// ReadDomainFiles adds the domains listed in the files allowedDomainsFrom
+// and deniedDomainsFrom name to allowedDomains and deniedDomains. Relative
+// paths are resolved against baseDir, the directory of the config file, and
+// are replaced by the absolute paths.
+func (c *Config) ReadDomainFiles(baseDir string) error {
+	var err error
+	c.Network.AllowedDomainsFrom, c.Network.AllowedDomains, err = c.readDomainFiles(c.Network.AllowedDomainsFrom, c.Network.AllowedDomains, baseDir)
+	if err != nil {
+		return fmt.Errorf("allowedDomainsFrom: %w", err)
+	}
+	c.Network.DeniedDomainsFrom, c.Network.DeniedDomains, err = c.readDomainFiles(c.Network.DeniedDomainsFrom, c.Network.DeniedDomains, baseDir)
+	if err != nil {
+		return fmt.Errorf("deniedDomainsFrom: %w", err)
+	}
+	return nil
+}
+
+// readDomainFiles returns paths made absolute, and domains with the entries
+// of the files at paths appended. The files' bytes are kept in c.domainFiles.
+func (c *Config) readDomainFiles(paths, domains []string, baseDir string) ([]string, []string, error) {
+	if len(paths) == 0 {
+		return paths, domains, nil
+	}
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		resolved[i] = filepath.Clean(path)
+		data, err := os.ReadFile(resolved[i]) //nolint:gosec // user-provided domain list path - intentional
+		if err != nil {
+			return nil, nil, err
+		}
+		listed, err := parseDomainFile(resolved[i], data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.domainFiles == nil {
+			c.domainFiles = make(map[string][]byte)
+		}
+		c.domainFiles[resolved[i]] = data
+		domains = append(domains, listed...)
+	}
+	return resolved, domains, nil
+}
+
+// ReadDomainFile reads a domain list file: one domain pattern or @group per
+// line, with blank lines and lines starting with # ignored. Groups are
+// expanded.
+func ReadDomainFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // user-provided domain list path - intentional
+	if err != nil {
+		return nil, err
+	}
+	return parseDomainFile(path, data)
+}
+
+// parseDomainFile parses the contents of the domain list file at path.
+func parseDomainFile(path string, data []byte) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	domains, err := expandDomainGroups(domains)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return domains, nil
+}
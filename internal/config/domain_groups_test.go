@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDomainGroups(t *testing.T) {
+	for name, domains := range DomainGroups {
+		for _, domain := range domains {
+			if err := ValidateDomainPattern(domain); err != nil {
+				t.Errorf("group @%s: %q: %v", name, domain, err)
+			}
+		}
+	}
+}
+
+func TestUnmarshalExpandsDomainGroups(t *testing.T) {
+	var cfg Config
+	data := `{"network": {
+		"allowedDomains": ["@pypi", "api.internal"],
+		"deniedDomains": ["@telemetry", {"rule": "@go", "action": "warn"}]
+	}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pypi.org", "files.pythonhosted.org", "api.internal"}; !slices.Equal(cfg.Network.AllowedDomains, want) {
+		t.Errorf("AllowedDomains = %v, want %v", cfg.Network.AllowedDomains, want)
+	}
+	if !slices.Equal(cfg.Network.DeniedDomains, DomainGroups["telemetry"]) {
+		t.Errorf("DeniedDomains = %v, want the @telemetry group", cfg.Network.DeniedDomains)
+	}
+	if !slices.Equal(cfg.Network.WarnDomains, DomainGroups["go"]) {
+		t.Errorf("WarnDomains = %v, want the @go group", cfg.Network.WarnDomains)
+	}
+
+	err := json.Unmarshal([]byte(`{"network": {"allowedDomains": ["@nope"]}}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), `unknown domain group "@nope"`) {
+		t.Errorf("Unmarshal() of an unknown group = %v", err)
+	}
+}
+
+func TestLoadDomainFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("allow.txt", "# Internal services\napi.internal\n\n  *.corp.example.com  \n@github\n")
+	write("deny.txt", "evil.example.com\n")
+	write("fence.json", `{"network": {
+		"allowedDomains": ["example.com"],
+		"allowedDomainsFrom": ["./allow.txt"],
+		"deniedDomainsFrom": ["deny.txt"]
+	}}`)
+
+	cfg, err := Load(filepath.Join(dir, "fence.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Concat([]string{"example.com", "api.internal", "*.corp.example.com"}, DomainGroups["github"])
+	if !slices.Equal(cfg.Network.AllowedDomains, want) {
+		t.Errorf("AllowedDomains = %v, want %v", cfg.Network.AllowedDomains, want)
+	}
+	if !slices.Equal(cfg.Network.DeniedDomains, []string{"evil.example.com"}) {
+		t.Errorf("DeniedDomains = %v", cfg.Network.DeniedDomains)
+	}
+	if want := []string{filepath.Join(dir, "allow.txt")}; !slices.Equal(cfg.Network.AllowedDomainsFrom, want) {
+		t.Errorf("AllowedDomainsFrom = %v, want the resolved path %v", cfg.Network.AllowedDomainsFrom, want)
+	}
+
+	write("deny.txt", "not a domain!\n")
+	if _, err := Load(filepath.Join(dir, "fence.json")); err == nil {
+		t.Error("Load() with an invalid domain in a domain file should fail")
+	}
+	write("fence.json", `{"network": {"allowedDomainsFrom": ["missing.txt"]}}`)
+	if _, err := Load(filepath.Join(dir, "fence.json")); err == nil {
+		t.Error("Load() with a missing domain file should fail")
+	}
+}
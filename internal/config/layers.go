@@ -0,0 +1,253 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Layer is a single config in a resolution chain, tagged with where it came from.
+// Source is a human-readable label such as "default", "template:code", or a file path.
+type Layer struct {
+	Source string
+	Config *Config
+}
+
+// MergeLayers merges layers in order (base first) using Merge semantics.
+// Returns the default config if there are no layers.
+func MergeLayers(layers []Layer) *Config {
+	var result *Config
+	for _, l := range layers {
+		if l.Config == nil {
+			continue
+		}
+		if result == nil {
+			result = l.Config
+			continue
+		}
+		result = Merge(result, l.Config)
+	}
+	if result == nil {
+		return Default()
+	}
+	return result
+}
+
+// MarshalAnnotated renders the merged result of layers as indented JSONC,
+// with a trailing comment on each rule naming the layer that contributed it.
+//
+// List entries are attributed to the first layer that contains them (since
+// Merge appends), and scalar values to the last layer that sets them.
+// The output is valid input for Load.
+func MarshalAnnotated(layers []Layer) ([]byte, error) {
+	merged := MergeLayers(layers)
+
+	var sources []string
+	var values []reflect.Value
+	for _, l := range layers {
+		if l.Config == nil {
+			continue
+		}
+		sources = append(sources, l.Source)
+		values = append(values, reflect.ValueOf(*l.Config))
+	}
+
+	w := &annotatedWriter{sources: sources}
+	if err := w.writeStruct(reflect.ValueOf(*merged), values, 0); err != nil {
+		return nil, err
+	}
+	w.b.WriteString("\n")
+	return []byte(w.b.String()), nil
+}
+
+// annotatedWriter emits JSONC for a config struct, walking the layer values in parallel.
+type annotatedWriter struct {
+	b       strings.Builder
+	sources []string
+}
+
+// annotatedField is a struct field selected for output.
+type annotatedField struct {
+	name     string
+	value    reflect.Value
+	index    int
+	warnRule int // For a deny list written as a rule list, the index of its warn field; else -1
+}
+
+// warnRuleFields maps the config structs that keep a rule list's warn
+// entries in a field of their own, which JSON leaves out, from each deny
+// list field to that field. The two are written together, as JSON writes them.
+var warnRuleFields = map[reflect.Type]map[string]string{
+	reflect.TypeFor[NetworkConfig](): {"DeniedDomains": "WarnDomains"},
+	reflect.TypeFor[CommandConfig](): {"Deny": "Warn"},
+	reflect.TypeFor[SSHConfig]():     {"DeniedHosts": "WarnHosts", "DeniedCommands": "WarnCommands"},
+}
+
+// fieldValue returns f's value in the struct v, with the warn entries
+// joined to a deny list.
+func (f annotatedField) fieldValue(v reflect.Value) reflect.Value {
+	fv := v.Field(f.index)
+	if f.warnRule < 0 {
+		return fv
+	}
+	l := joinRules(fv.Interface().([]string), v.Field(f.warnRule).Interface().([]string))
+	if l == nil {
+		return reflect.ValueOf([]any(nil))
+	}
+	return reflect.ValueOf(l.items())
+}
+
+func (w *annotatedWriter) writeStruct(v reflect.Value, layers []reflect.Value, depth int) error {
+	var fields []annotatedField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := annotatedField{name: name, index: i, warnRule: -1}
+		if warnName, ok := warnRuleFields[t][f.Name]; ok {
+			warn, _ := t.FieldByName(warnName)
+			field.warnRule = warn.Index[0]
+		}
+		field.value = field.fieldValue(v)
+		if (strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero")) && field.value.IsZero() {
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		w.b.WriteString("{}")
+		return nil
+	}
+
+	indent := strings.Repeat("  ", depth+1)
+	w.b.WriteString("{\n")
+	for n, f := range fields {
+		sub := make([]reflect.Value, len(layers))
+		for i, lv := range layers {
+			if lv.IsValid() {
+				sub[i] = f.fieldValue(lv)
+			}
+		}
+
+		w.b.WriteString(indent)
+		w.b.WriteString(fmt.Sprintf("%q: ", f.name))
+		comma := n < len(fields)-1
+		if err := w.writeValue(f.value, sub, depth+1, comma); err != nil {
+			return err
+		}
+	}
+	w.b.WriteString(strings.Repeat("  ", depth))
+	w.b.WriteString("}")
+	return nil
+}
+
+// writeValue writes a field value followed by its trailing comma (if any),
+// source comment, and newline.
+func (w *annotatedWriter) writeValue(v reflect.Value, layers []reflect.Value, depth int, comma bool) error {
+	trailer := func(source string) {
+		if comma {
+			w.b.WriteString(",")
+		}
+		if source != "" {
+			w.b.WriteString(" // " + source)
+		}
+		w.b.WriteString("\n")
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		if err := w.writeStruct(v, layers, depth); err != nil {
+			return err
+		}
+		trailer("")
+		return nil
+
+	case v.Kind() == reflect.Pointer && !v.IsNil() && v.Elem().Kind() == reflect.Struct:
+		sub := make([]reflect.Value, len(layers))
+		for i, lv := range layers {
+			if lv.IsValid() && !lv.IsNil() {
+				sub[i] = lv.Elem()
+			}
+		}
+		if err := w.writeStruct(v.Elem(), sub, depth); err != nil {
+			return err
+		}
+		trailer("")
+		return nil
+
+	case v.Kind() == reflect.Slice && v.Len() > 0:
+		indent := strings.Repeat("  ", depth+1)
+		w.b.WriteString("[\n")
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			data, err := json.Marshal(elem.Interface())
+			if err != nil {
+				return err
+			}
+			w.b.WriteString(indent)
+			w.b.Write(data)
+			if i < v.Len()-1 {
+				w.b.WriteString(",")
+			}
+			if source := w.elementSource(elem, layers); source != "" {
+				w.b.WriteString(" // " + source)
+			}
+			w.b.WriteString("\n")
+		}
+		w.b.WriteString(strings.Repeat("  ", depth))
+		w.b.WriteString("]")
+		trailer("")
+		return nil
+
+	default:
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			// Empty list: nothing to attribute
+			w.b.WriteString("[]")
+			trailer("")
+			return nil
+		}
+		w.b.Write(data)
+		trailer(w.scalarSource(layers))
+		return nil
+	}
+}
+
+// elementSource returns the first layer whose slice contains elem.
+func (w *annotatedWriter) elementSource(elem reflect.Value, layers []reflect.Value) string {
+	for i, lv := range layers {
+		if !lv.IsValid() || lv.Kind() != reflect.Slice {
+			continue
+		}
+		for j := 0; j < lv.Len(); j++ {
+			if reflect.DeepEqual(lv.Index(j).Interface(), elem.Interface()) {
+				return w.sources[i]
+			}
+		}
+	}
+	return ""
+}
+
+// scalarSource returns the last layer that sets a non-zero value.
+func (w *annotatedWriter) scalarSource(layers []reflect.Value) string {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if layers[i].IsValid() && !layers[i].IsZero() {
+			return w.sources[i]
+		}
+	}
+	return ""
+}
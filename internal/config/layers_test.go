@@ -0,0 +1,121 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/jsonc"
+)
+
+func TestMergeLayers(t *testing.T) {
+	if got := MergeLayers(nil); got == nil {
+		t.Fatal("MergeLayers(nil) should return default config")
+	}
+
+	base := &Config{Network: NetworkConfig{AllowedDomains: []string{"a.com"}}}
+	override := &Config{Network: NetworkConfig{AllowedDomains: []string{"b.com"}}, AllowPty: true}
+
+	got := MergeLayers([]Layer{{Source: "base", Config: base}, {Source: "override", Config: override}})
+	if len(got.Network.AllowedDomains) != 2 {
+		t.Errorf("expected 2 allowed domains, got %v", got.Network.AllowedDomains)
+	}
+	if !got.AllowPty {
+		t.Error("expected AllowPty from override layer")
+	}
+}
+
+func TestMarshalAnnotated(t *testing.T) {
+	useDefaults := false
+	base := &Config{
+		Network:    NetworkConfig{AllowedDomains: []string{"a.com"}, HTTPProxyPort: 8080},
+		Filesystem: FilesystemConfig{AllowWrite: []string{"."}},
+	}
+	override := &Config{
+		Network: NetworkConfig{AllowedDomains: []string{"a.com", "b.com"}, AllowLocalBinding: true},
+		Command: CommandConfig{Deny: []string{"git push"}, UseDefaults: &useDefaults},
+	}
+
+	data, err := MarshalAnnotated([]Layer{
+		{Source: "template:base", Config: base},
+		{Source: "./fence.json", Config: override},
+	})
+	if err != nil {
+		t.Fatalf("MarshalAnnotated() error = %v", err)
+	}
+	out := string(data)
+
+	wantLines := []string{
+		`"a.com", // template:base`,
+		`"b.com" // ./fence.json`,
+		`"httpProxyPort": 8080 // template:base`,
+		`"allowLocalBinding": true, // ./fence.json`,
+		`"git push" // ./fence.json`,
+		`"useDefaults": false // ./fence.json`,
+		`"deniedDomains": [],`,
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+
+	// Output must be loadable JSONC equal to the merged config
+	var parsed Config
+	if err := json.Unmarshal(jsonc.ToJSON(data), &parsed); err != nil {
+		t.Fatalf("annotated output is not valid JSONC: %v\n%s", err, out)
+	}
+	if len(parsed.Network.AllowedDomains) != 2 || parsed.Network.HTTPProxyPort != 8080 {
+		t.Errorf("round-tripped config mismatch: %+v", parsed.Network)
+	}
+	if parsed.Command.UseDefaults == nil || *parsed.Command.UseDefaults {
+		t.Error("expected useDefaults=false to round-trip")
+	}
+}
+
+func TestMarshalAnnotatedWarnRules(t *testing.T) {
+	base := &Config{
+		Network: NetworkConfig{DeniedDomains: []string{"evil.com"}, WarnDomains: []string{"pastebin.com"}},
+	}
+	override := &Config{
+		Command: CommandConfig{Deny: []string{"git push"}, Warn: []string{"npm publish"}},
+		SSH:     SSHConfig{WarnHosts: []string{"db.example.com"}, WarnCommands: []string{"rm"}},
+	}
+
+	data, err := MarshalAnnotated([]Layer{
+		{Source: "template:base", Config: base},
+		{Source: "./fence.json", Config: override},
+	})
+	if err != nil {
+		t.Fatalf("MarshalAnnotated() error = %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`"evil.com", // template:base`,
+		`{"rule":"pastebin.com","action":"warn"} // template:base`,
+		`"git push", // ./fence.json`,
+		`{"rule":"npm publish","action":"warn"} // ./fence.json`,
+		`{"rule":"db.example.com","action":"warn"} // ./fence.json`,
+		`{"rule":"rm","action":"warn"} // ./fence.json`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+
+	// The warn entries round-trip as warn rules
+	var parsed Config
+	if err := json.Unmarshal(jsonc.ToJSON(data), &parsed); err != nil {
+		t.Fatalf("annotated output is not valid JSONC: %v\n%s", err, out)
+	}
+	if !slices.Equal(parsed.Network.DeniedDomains, []string{"evil.com"}) || !slices.Equal(parsed.Network.WarnDomains, []string{"pastebin.com"}) {
+		t.Errorf("round-tripped domains = %v, warn %v", parsed.Network.DeniedDomains, parsed.Network.WarnDomains)
+	}
+	if !slices.Equal(parsed.Command.Deny, []string{"git push"}) || !slices.Equal(parsed.Command.Warn, []string{"npm publish"}) {
+		t.Errorf("round-tripped commands = %v, warn %v", parsed.Command.Deny, parsed.Command.Warn)
+	}
+	if !slices.Equal(parsed.SSH.WarnHosts, []string{"db.example.com"}) || !slices.Equal(parsed.SSH.WarnCommands, []string{"rm"}) {
+		t.Errorf("round-tripped ssh warn rules = %v, %v", parsed.SSH.WarnHosts, parsed.SSH.WarnCommands)
+	}
+}
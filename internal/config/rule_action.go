@@ -0,0 +1,204 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Rule actions, which say what happens to an operation a deny rule matches.
+const (
+	ActionDeny = "deny" // The operation is blocked; the default
+	ActionWarn = "warn" // The operation is allowed, but logged and counted as a violation
+)
+
+// validateAction checks a rule's action, which may be empty for deny.
+func validateAction(action string) error {
+	switch action {
+	case "", ActionDeny, ActionWarn:
+		return nil
+	}
+	return fmt.Errorf("invalid action %q: must be %q or %q", action, ActionDeny, ActionWarn)
+}
+
+// RuleEntry is a rule list entry written as an object rather than a string,
+// to give the rule an action: {"rule": "npm publish", "action": "warn"}.
+type RuleEntry struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action,omitempty"`
+}
+
+// ruleList is a rule list as written in JSON, whose entries are strings or
+// RuleEntry objects.
+type ruleList []RuleEntry
+
+func (l *ruleList) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	entries := make(ruleList, 0, len(raw))
+	for _, item := range raw {
+		var entry RuleEntry
+		if err := json.Unmarshal(item, &entry.Rule); err != nil {
+			if err := json.Unmarshal(item, &entry); err != nil {
+				return fmt.Errorf("rule list entry %s: must be a string or an object with rule and action", item)
+			}
+		}
+		if err := validateAction(entry.Action); err != nil {
+			return fmt.Errorf("rule %q: %w", entry.Rule, err)
+		}
+		entries = append(entries, entry)
+	}
+	*l = entries
+	return nil
+}
+
+func (l ruleList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(l.items())
+}
+
+// items returns the list's entries as written in JSON: a string for a deny
+// rule, and a RuleEntry for a rule with another action.
+func (l ruleList) items() []any {
+	items := make([]any, len(l))
+	for i, entry := range l {
+		if entry.Action == ActionWarn {
+			items[i] = entry
+		} else {
+			items[i] = entry.Rule
+		}
+	}
+	return items
+}
+
+// split returns the list's rules by action.
+func (l ruleList) split() (deny, warn []string) {
+	if l != nil {
+		deny = []string{}
+	}
+	for _, entry := range l {
+		if entry.Action == ActionWarn {
+			warn = append(warn, entry.Rule)
+		} else {
+			deny = append(deny, entry.Rule)
+		}
+	}
+	return deny, warn
+}
+
+// joinRules returns deny and warn as one list, deny rules first.
+func joinRules(deny, warn []string) ruleList {
+	if deny == nil && warn == nil {
+		return nil
+	}
+	l := make(ruleList, 0, len(deny)+len(warn))
+	for _, rule := range deny {
+		l = append(l, RuleEntry{Rule: rule})
+	}
+	for _, rule := range warn {
+		l = append(l, RuleEntry{Rule: rule, Action: ActionWarn})
+	}
+	return l
+}
+
+// networkJSON is NetworkConfig with deniedDomains as written in JSON.
+type networkJSON struct {
+	*networkFields
+	DeniedDomains ruleList `json:"deniedDomains"`
+}
+
+type networkFields NetworkConfig
+
+func (n *NetworkConfig) UnmarshalJSON(data []byte) error {
+	aux := networkJSON{networkFields: (*networkFields)(n)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.DeniedDomains != nil {
+		n.DeniedDomains, n.WarnDomains = aux.DeniedDomains.split()
+	}
+	return n.expandDomainGroups()
+}
+
+// expandDomainGroups replaces the @group entries of the domain lists with
+// the groups' domains.
+func (n *NetworkConfig) expandDomainGroups() error {
+	var err error
+	if n.AllowedDomains, err = expandDomainGroups(n.AllowedDomains); err != nil {
+		return fmt.Errorf("allowedDomains: %w", err)
+	}
+	if n.DeniedDomains, err = expandDomainGroups(n.DeniedDomains); err != nil {
+		return fmt.Errorf("deniedDomains: %w", err)
+	}
+	if n.WarnDomains, err = expandDomainGroups(n.WarnDomains); err != nil {
+		return fmt.Errorf("deniedDomains: %w", err)
+	}
+	return nil
+}
+
+func (n NetworkConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(networkJSON{
+		networkFields: (*networkFields)(&n),
+		DeniedDomains: joinRules(n.DeniedDomains, n.WarnDomains),
+	})
+}
+
+// commandJSON is CommandConfig with deny as written in JSON.
+type commandJSON struct {
+	*commandFields
+	Deny ruleList `json:"deny"`
+}
+
+type commandFields CommandConfig
+
+func (c *CommandConfig) UnmarshalJSON(data []byte) error {
+	aux := commandJSON{commandFields: (*commandFields)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Deny != nil {
+		c.Deny, c.Warn = aux.Deny.split()
+	}
+	return nil
+}
+
+func (c CommandConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(commandJSON{
+		commandFields: (*commandFields)(&c),
+		Deny:          joinRules(c.Deny, c.Warn),
+	})
+}
+
+// sshJSON is SSHConfig with deniedHosts and deniedCommands as written in JSON.
+type sshJSON struct {
+	*sshFields
+	DeniedHosts    ruleList `json:"deniedHosts"`
+	DeniedCommands ruleList `json:"deniedCommands"`
+}
+
+type sshFields SSHConfig
+
+func (s *SSHConfig) UnmarshalJSON(data []byte) error {
+	aux := sshJSON{sshFields: (*sshFields)(s)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.DeniedHosts != nil {
+		s.DeniedHosts, s.WarnHosts = aux.DeniedHosts.split()
+	}
+	if aux.DeniedCommands != nil {
+		s.DeniedCommands, s.WarnCommands = aux.DeniedCommands.split()
+	}
+	return nil
+}
+
+func (s SSHConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sshJSON{
+		sshFields:      (*sshFields)(&s),
+		DeniedHosts:    joinRules(s.DeniedHosts, s.WarnHosts),
+		DeniedCommands: joinRules(s.DeniedCommands, s.WarnCommands),
+	})
+}
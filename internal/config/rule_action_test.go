@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRuleActionsJSON(t *testing.T) {
+	data := `{
+		"network": {"deniedDomains": ["evil.com", {"rule": "staging.example.com", "action": "warn"}, {"rule": "bad.com"}]},
+		"command": {"deny": ["git push", {"rule": "npm publish", "action": "warn"}], "denyFlags": [{"command": "curl", "flags": ["-T"], "action": "warn"}]},
+		"ssh": {"deniedHosts": ["*.prod.example.com", {"rule": "db.example.com", "action": "warn"}], "deniedCommands": [{"rule": "rm", "action": "warn"}, "shutdown"]}
+	}`
+	var cfg Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"evil.com", "bad.com"}; !slices.Equal(cfg.Network.DeniedDomains, want) {
+		t.Errorf("DeniedDomains = %v, want %v", cfg.Network.DeniedDomains, want)
+	}
+	if want := []string{"staging.example.com"}; !slices.Equal(cfg.Network.WarnDomains, want) {
+		t.Errorf("WarnDomains = %v, want %v", cfg.Network.WarnDomains, want)
+	}
+	if !slices.Equal(cfg.Command.Deny, []string{"git push"}) || !slices.Equal(cfg.Command.Warn, []string{"npm publish"}) {
+		t.Errorf("Deny = %v, Warn = %v", cfg.Command.Deny, cfg.Command.Warn)
+	}
+	if !slices.Equal(cfg.SSH.DeniedHosts, []string{"*.prod.example.com"}) || !slices.Equal(cfg.SSH.WarnHosts, []string{"db.example.com"}) {
+		t.Errorf("DeniedHosts = %v, WarnHosts = %v", cfg.SSH.DeniedHosts, cfg.SSH.WarnHosts)
+	}
+	if !slices.Equal(cfg.SSH.DeniedCommands, []string{"shutdown"}) || !slices.Equal(cfg.SSH.WarnCommands, []string{"rm"}) {
+		t.Errorf("DeniedCommands = %v, WarnCommands = %v", cfg.SSH.DeniedCommands, cfg.SSH.WarnCommands)
+	}
+	if cfg.Command.DenyFlags[0].Action != ActionWarn {
+		t.Errorf("DenyFlags[0].Action = %q, want warn", cfg.Command.DenyFlags[0].Action)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	out, err := json.Marshal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"deniedDomains":["evil.com","bad.com",{"rule":"staging.example.com","action":"warn"}]`,
+		`"deny":["git push",{"rule":"npm publish","action":"warn"}]`,
+		`"deniedHosts":["*.prod.example.com",{"rule":"db.example.com","action":"warn"}]`,
+		`"deniedCommands":["shutdown",{"rule":"rm","action":"warn"}]`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Marshal() = %s, missing %s", out, want)
+		}
+	}
+	var again Config
+	if err := json.Unmarshal(out, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.Network.WarnDomains, cfg.Network.WarnDomains) || !slices.Equal(again.Command.Warn, cfg.Command.Warn) ||
+		!slices.Equal(again.SSH.WarnHosts, cfg.SSH.WarnHosts) || !slices.Equal(again.SSH.WarnCommands, cfg.SSH.WarnCommands) {
+		t.Error("warn rules should survive a round trip")
+	}
+}
+
+func TestRuleActionsInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"command": {"deny": [{"rule": "npm publish", "action": "log"}]}}`,
+		`{"command": {"deny": [42]}}`,
+		`{"network": {"deniedDomains": [{"rule": "x.com", "action": "allow"}]}}`,
+		`{"ssh": {"deniedHosts": [{"rule": "x.com", "action": "allow"}]}}`,
+	} {
+		var cfg Config
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+
+	cfg := Default()
+	cfg.Network.HTTPRules = []HTTPRule{{Domain: "api.example.com", Action: "log"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown httpRules action")
+	}
+	cfg = Default()
+	cfg.Command.Warn = []string{"re:("}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should check warn rules like deny rules")
+	}
+	cfg = Default()
+	cfg.SSH.WarnCommands = []string{""}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject an empty ssh.deniedCommands warn rule")
+	}
+}
+
+func TestMergeRuleActions(t *testing.T) {
+	base := &Config{Network: NetworkConfig{WarnDomains: []string{"a.com"}}, Command: CommandConfig{Warn: []string{"npm publish"}}}
+	override := &Config{Network: NetworkConfig{WarnDomains: []string{"b.com"}}, SSH: SSHConfig{WarnHosts: []string{"db.example.com"}, WarnCommands: []string{"rm"}}}
+	got := Merge(base, override)
+	if !slices.Equal(got.SSH.WarnHosts, []string{"db.example.com"}) || !slices.Equal(got.SSH.WarnCommands, []string{"rm"}) {
+		t.Errorf("Merge() ssh warn rules = %v, %v", got.SSH.WarnHosts, got.SSH.WarnCommands)
+	}
+	if !slices.Equal(got.Network.WarnDomains, []string{"a.com", "b.com"}) || !slices.Equal(got.Command.Warn, []string{"npm publish"}) {
+		t.Errorf("Merge() warn rules = %v, %v", got.Network.WarnDomains, got.Command.Warn)
+	}
+}
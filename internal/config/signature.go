@@ -0,0 +1,167 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SignatureSuffix is appended to a config's path or URL for its detached
+// signature: the base64 Ed25519 signature of the config file's bytes.
+const SignatureSuffix = ".sig"
+
+// DefaultTrustPath is the machine-wide file that says whether config files
+// must be signed, and by whom. It belongs to root, so that a sandboxed
+// command that can write the user's config cannot change it.
+const DefaultTrustPath = "/etc/fence/trust.json"
+
+// Trust is the content of the trust file.
+type Trust struct {
+	// RequireSignedConfig makes fence refuse to run with a config file, or a
+	// file it extends, that has no valid signature by one of Keys.
+	RequireSignedConfig bool     `json:"requireSignedConfig"`
+	Keys                []string `json:"keys"` // Base64 Ed25519 public keys
+
+	path string // Where the trust was loaded from
+}
+
+// LoadTrust reads the trust file at path. It returns nil if there is none.
+func LoadTrust(path string) (*Trust, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // trust file path - intentional
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust file: %w", err)
+	}
+	t := Trust{path: path}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid trust file %s: %w", path, err)
+	}
+	for _, k := range t.Keys {
+		if key, err := base64.StdEncoding.DecodeString(k); err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid trust file %s: key %q is not a base64 Ed25519 public key", path, k)
+		}
+	}
+	if t.RequireSignedConfig && len(t.Keys) == 0 {
+		return nil, fmt.Errorf("invalid trust file %s: requireSignedConfig needs keys", path)
+	}
+	return &t, nil
+}
+
+// VerifyFile checks that the config file at path has a signature by one of
+// t's keys at path + SignatureSuffix.
+func (t *Trust) VerifyFile(path string) error {
+	data, err := os.ReadFile(path) //nolint:gosec // user-provided config path - intentional
+	if err != nil {
+		return err
+	}
+	return t.VerifyData(path, data)
+}
+
+// VerifyData checks that data, as read from the file at path, has a
+// signature by one of t's keys at path + SignatureSuffix.
+func (t *Trust) VerifyData(path string, data []byte) error {
+	sig, err := os.ReadFile(path + SignatureSuffix) //nolint:gosec // signature next to the config
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("config %s is not signed", path)
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range t.Keys {
+		if VerifySignature(data, strings.TrimSpace(string(sig)), key) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("config %s: signature does not verify with a trusted key", path)
+}
+
+// VerifyLayers checks the signature of each layer that came from a file,
+// which is labeled with its absolute path, and of the domain files it reads,
+// if t requires signed configs. The bytes checked are those Load and
+// ReadDomainFiles parsed, not the files as they are now. Built-in templates
+// and defaults need no signature.
+func (t *Trust) VerifyLayers(layers []Layer) error {
+	if t == nil || !t.RequireSignedConfig {
+		return nil
+	}
+	for _, l := range layers {
+		if !filepath.IsAbs(l.Source) {
+			continue
+		}
+		if err := t.verifyParsed(l.Source, l.Config.raw); err != nil {
+			return err
+		}
+		for _, path := range slices.Concat(l.Config.Network.AllowedDomainsFrom, l.Config.Network.DeniedDomainsFrom) {
+			if err := t.verifyParsed(path, l.Config.domainFiles[path]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyParsed checks the signature of the bytes parsed from the file at
+// path for VerifyLayers.
+func (t *Trust) verifyParsed(path string, data []byte) error {
+	if data == nil {
+		return fmt.Errorf("config %s was not loaded from the file, so its signature cannot be checked (signed configs are required by %s)", path, t.path)
+	}
+	if err := t.VerifyData(path, data); err != nil {
+		return fmt.Errorf("%w (signed configs are required by %s)", err, t.path)
+	}
+	return nil
+}
+
+// VerifySignature checks that signature, in base64, is key's Ed25519
+// signature of data. key is a base64 public key.
+func VerifySignature(data []byte, signature, key string) error {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if signature == "" {
+		return errors.New("not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), data, sig) {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
+
+// Sign returns the base64 signature of data by key, which is a base64
+// Ed25519 private key as GenerateSigningKey returns.
+func Sign(data []byte, key string) (string, error) {
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid signing key: must be a base64 Ed25519 private key")
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(priv), data)), nil
+}
+
+// GenerateSigningKey returns a new Ed25519 key pair, both in base64.
+func GenerateSigningKey() (public, private string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// SigningKeyPublic returns the base64 public key of the base64 private key.
+func SigningKeyPublic(key string) (string, error) {
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid signing key: must be a base64 Ed25519 private key")
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.PrivateKey(priv).Public().(ed25519.PublicKey)), nil
+}
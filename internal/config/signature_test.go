@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signFile writes path's signature by key next to it.
+func signFile(t *testing.T, path, key string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := Sign(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+SignatureSuffix, []byte(sig+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	public, private, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := SigningKeyPublic(private); err != nil || got != public {
+		t.Errorf("SigningKeyPublic() = %q, %v, want %q", got, err, public)
+	}
+
+	data := []byte(`{"network": {"allowedDomains": ["github.com"]}}`)
+	sig, err := Sign(data, private+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature(data, sig, public); err != nil {
+		t.Errorf("VerifySignature() = %v", err)
+	}
+	if err := VerifySignature(append(data, ' '), sig, public); err == nil {
+		t.Error("a modified config should not verify")
+	}
+	if err := VerifySignature(data, "", public); err == nil {
+		t.Error("a missing signature should not verify")
+	}
+	if _, err := Sign(data, public); err == nil {
+		t.Error("Sign should reject a public key")
+	}
+}
+
+func TestTrustVerifyLayers(t *testing.T) {
+	public, private, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fence.json")
+	if err := os.WriteFile(path, []byte(`{"network": {"allowedDomains": ["github.com"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	trustPath := filepath.Join(dir, "trust.json")
+	if err := os.WriteFile(trustPath, []byte(`{"requireSignedConfig": true, "keys": ["`+public+`"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	trust, err := LoadTrust(trustPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := []Layer{{Source: "template:code", Config: Default()}, {Source: path, Config: loaded}}
+
+	if err := trust.VerifyLayers(layers); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("VerifyLayers() of an unsigned config = %v, want not signed", err)
+	}
+	signFile(t, path, private)
+	if err := trust.VerifyLayers(layers); err != nil {
+		t.Errorf("VerifyLayers() of a signed config = %v", err)
+	}
+
+	// A layer that was not loaded from its file cannot be checked
+	if err := trust.VerifyLayers([]Layer{{Source: path, Config: Default()}}); err == nil {
+		t.Error("VerifyLayers() of a config not loaded from its file should fail")
+	}
+
+	// The domain files a config reads must be signed too
+	domains := filepath.Join(dir, "allow.txt")
+	if err := os.WriteFile(domains, []byte("example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withFilePath := filepath.Join(dir, "with-file.json")
+	if err := os.WriteFile(withFilePath, []byte(`{"network": {"allowedDomainsFrom": ["allow.txt"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signFile(t, withFilePath, private)
+	withFileCfg, err := Load(withFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withFile := []Layer{{Source: withFilePath, Config: withFileCfg}}
+	if err := trust.VerifyLayers(withFile); err == nil || !strings.Contains(err.Error(), "allow.txt is not signed") {
+		t.Errorf("VerifyLayers() with an unsigned domain file = %v, want not signed", err)
+	}
+	signFile(t, domains, private)
+	if err := trust.VerifyLayers(withFile); err != nil {
+		t.Errorf("VerifyLayers() with a signed domain file = %v", err)
+	}
+
+	// The bytes that were parsed are checked, not the file as it is now: a
+	// config swapped for a signed one after loading does not pass
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"network": {"allowedDomains": ["evil.example.com"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, signed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := trust.VerifyLayers([]Layer{{Source: path, Config: tampered}}); err == nil {
+		t.Error("VerifyLayers() of a tampered config should fail")
+	}
+
+	// Without the requirement, or a trust file, nothing is checked
+	if err := (&Trust{Keys: []string{public}}).VerifyLayers(layers); err != nil {
+		t.Errorf("VerifyLayers() without requireSignedConfig = %v", err)
+	}
+	if err := (*Trust)(nil).VerifyLayers(layers); err != nil {
+		t.Errorf("VerifyLayers() without a trust file = %v", err)
+	}
+}
+
+func TestLoadTrust(t *testing.T) {
+	dir := t.TempDir()
+	if trust, err := LoadTrust(filepath.Join(dir, "missing.json")); trust != nil || err != nil {
+		t.Errorf("LoadTrust() of a missing file = %+v, %v, want nil", trust, err)
+	}
+	for _, content := range []string{
+		`{"requireSignedConfig": true}`,
+		`{"keys": ["not a key"]}`,
+		`{`,
+	} {
+		path := filepath.Join(dir, "trust.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTrust(path); err == nil {
+			t.Errorf("LoadTrust(%s) should fail", content)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps the suffixes ParseSize accepts to their multipliers. Units
+// are binary, as in docker's --memory: 1K is 1024 bytes.
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// ParseSize parses a byte count with an optional unit suffix, such as
+// "1048576", "512M", "1.5g", or "2GiB".
+func ParseSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	num := strings.TrimRightFunc(lower, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	mult, ok := sizeUnits[lower[len(num):]]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("invalid size %q: want a number of bytes, optionally with a K, M, G, or T suffix", s)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || n*float64(mult) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: want a number of bytes, optionally with a K, M, G, or T suffix", s)
+	}
+	return int64(n * float64(mult)), nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1048576", 1048576, false},
+		{"100b", 100, false},
+		{"512M", 512 << 20, false},
+		{"512m", 512 << 20, false},
+		{"1.5g", 3 << 29, false},
+		{"2GiB", 2 << 30, false},
+		{"4kb", 4096, false},
+		{" 1T ", 1 << 40, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"-1M", 0, true},
+		{"12X", 0, true},
+		{"1ib", 0, true},
+		{"99999999T", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
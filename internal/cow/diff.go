@@ -0,0 +1,50 @@
+package cow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// WriteDiff writes the layer's changes to w as a unified diff against the
+// workspace, using diff(1). Deleted and replaced directories are listed
+// rather than diffed file by file.
+func (l *Layer) WriteDiff(w io.Writer, changes []Change) error {
+	for _, c := range changes {
+		old := filepath.Join(l.Workspace, c.Path)
+		updated := filepath.Join(l.Upper(), c.Path)
+		switch {
+		case c.Dir && c.Kind == Deleted:
+			if _, err := fmt.Fprintf(w, "Deleted directory %s/\n", c.Path); err != nil {
+				return err
+			}
+			continue
+		case c.Dir && c.Opaque:
+			if _, err := fmt.Fprintf(w, "Replaced directory %s/, removing its previous contents\n", c.Path); err != nil {
+				return err
+			}
+			continue
+		case c.Dir:
+			continue
+		case c.Kind == Added:
+			old = os.DevNull
+		case c.Kind == Deleted:
+			updated = os.DevNull
+		}
+
+		cmd := exec.Command("diff", "-u", "--label", "a/"+c.Path, "--label", "b/"+c.Path, old, updated) //nolint:gosec // paths are in the workspace and layer
+		cmd.Stdout = w
+		cmd.Stderr = w
+		if err := cmd.Run(); err != nil {
+			// Exit status 1 means the files differ
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+				return fmt.Errorf("diff %s: %w", c.Path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// Package cow manages the copy-on-write layers of "fence --cow": the
+// overlayfs upper directories that receive a sandboxed command's writes to
+// the workspace, and the "fence diff" and "fence commit" operations that
+// review them and apply them to the workspace.
+package cow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoLayer is returned when a workspace has no copy-on-write layer.
+var ErrNoLayer = errors.New("no copy-on-write changes for this directory")
+
+// Layer is the copy-on-write layer of one workspace. Every --cow run in the
+// workspace adds to the same layer until it is committed or discarded.
+type Layer struct {
+	// Workspace is the directory the layer is mounted over.
+	Workspace string    `json:"workspace"`
+	Created   time.Time `json:"created"`
+	// Dir holds the layer: upper/ has the changes, work/ is overlayfs's
+	// scratch directory.
+	Dir string `json:"-"`
+}
+
+// Store is the directory holding the layers, one per workspace.
+type Store struct {
+	Dir string
+}
+
+// DefaultStore returns the store in ~/.fence/cow.
+func DefaultStore() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Store{Dir: filepath.Join(home, ".fence", "cow")}, nil
+}
+
+// layerDir returns the directory of workspace's layer, named by a hash of
+// its path.
+func (s *Store) layerDir(workspace string) string {
+	sum := sha256.Sum256([]byte(workspace))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:8]))
+}
+
+// Open returns workspace's layer, creating it if needed. workspace must be
+// an absolute path to a directory that does not contain the store, since
+// overlayfs refuses layers inside the directory they cover.
+func (s *Store) Open(workspace string) (*Layer, error) {
+	if l, err := s.Find(workspace); err == nil || !errors.Is(err, ErrNoLayer) {
+		return l, err
+	}
+	if !filepath.IsAbs(workspace) {
+		return nil, fmt.Errorf("workspace %s is not an absolute path", workspace)
+	}
+	if within(s.Dir, workspace) {
+		return nil, fmt.Errorf("cannot use %s as a copy-on-write workspace: it contains %s, where the changes are kept", workspace, s.Dir)
+	}
+
+	l := &Layer{Workspace: workspace, Created: time.Now(), Dir: s.layerDir(workspace)}
+	for _, dir := range []string{l.Upper(), l.Work()} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create copy-on-write layer: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(l.Dir, "layer.json"), data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to create copy-on-write layer: %w", err)
+	}
+	return l, nil
+}
+
+// Find returns workspace's layer, or ErrNoLayer.
+func (s *Store) Find(workspace string) (*Layer, error) {
+	dir := s.layerDir(workspace)
+	data, err := os.ReadFile(filepath.Join(dir, "layer.json")) //nolint:gosec // path is in the store
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoLayer
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l Layer
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("invalid copy-on-write layer %s: %w", dir, err)
+	}
+	if l.Workspace != workspace {
+		return nil, fmt.Errorf("copy-on-write layer %s belongs to %s", dir, l.Workspace)
+	}
+	l.Dir = dir
+	return &l, nil
+}
+
+// Upper returns the overlayfs upper directory, where the changes are.
+func (l *Layer) Upper() string {
+	return filepath.Join(l.Dir, "upper")
+}
+
+// Work returns the overlayfs work directory.
+func (l *Layer) Work() string {
+	return filepath.Join(l.Dir, "work")
+}
+
+// Kinds of Change.
+const (
+	Added    = "added"
+	Modified = "modified"
+	Deleted  = "deleted"
+)
+
+// Change is a path the layer adds, modifies, or deletes in the workspace.
+type Change struct {
+	Path string // Relative to the workspace
+	Kind string // Added, Modified, or Deleted
+	Dir  bool
+	// Opaque is set on a directory that was deleted and created again: the
+	// workspace's contents of it are gone, not merged with the layer's.
+	Opaque bool
+}
+
+// Changes returns the changes in the layer, sorted by path. Directories
+// that only hold changes are not listed themselves.
+func (l *Layer) Changes() ([]Change, error) {
+	var changes []Change
+	upper := l.Upper()
+	err := filepath.WalkDir(upper, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == upper {
+			return nil
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if isWhiteout(info) {
+			changes = append(changes, Change{Path: rel, Kind: Deleted, Dir: isDir(filepath.Join(l.Workspace, rel))})
+			return nil
+		}
+		lower, lowerErr := os.Lstat(filepath.Join(l.Workspace, rel))
+		exists := lowerErr == nil
+		if d.IsDir() {
+			switch {
+			case isOpaque(path) && exists:
+				changes = append(changes, Change{Path: rel, Kind: Modified, Dir: true, Opaque: true})
+			case !exists:
+				changes = append(changes, Change{Path: rel, Kind: Added, Dir: true})
+			case !lower.IsDir():
+				changes = append(changes, Change{Path: rel, Kind: Modified, Dir: true})
+			}
+			return nil
+		}
+		kind := Added
+		if exists {
+			kind = Modified
+		}
+		changes = append(changes, Change{Path: rel, Kind: kind})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read copy-on-write layer: %w", err)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// WriteStatus writes one line per change: A, M, or D and the path.
+func WriteStatus(w io.Writer, changes []Change) error {
+	for _, c := range changes {
+		path := c.Path
+		if c.Dir {
+			path += "/"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", strings.ToUpper(c.Kind[:1]), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit applies the layer's changes to the workspace and removes the
+// layer. It must not run while a --cow command is using the layer.
+func (l *Layer) Commit() error {
+	changes, err := l.Changes()
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := l.apply(c); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", c.Path, err)
+		}
+	}
+	return l.Discard()
+}
+
+// apply makes one change in the workspace.
+func (l *Layer) apply(c Change) error {
+	dest := filepath.Join(l.Workspace, c.Path)
+	src := filepath.Join(l.Upper(), c.Path)
+	switch {
+	case c.Kind == Deleted:
+		return os.RemoveAll(dest)
+	case c.Dir:
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if c.Opaque || c.Kind == Modified {
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+		}
+		return os.MkdirAll(dest, info.Mode().Perm())
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	// A directory may have replaced the file, or a file the directory
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	}
+	return copyFile(src, dest, info.Mode().Perm())
+}
+
+// Discard removes the layer, dropping its changes.
+func (l *Layer) Discard() error {
+	// overlayfs leaves its work directory unreadable
+	_ = filepath.WalkDir(l.Dir, func(path string, d fs.DirEntry, err error) error {
+		if d != nil && d.IsDir() {
+			_ = os.Chmod(path, 0o700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(l.Dir); err != nil {
+		return fmt.Errorf("failed to remove copy-on-write layer: %w", err)
+	}
+	return nil
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src) //nolint:gosec // path is in the layer
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode) //nolint:gosec // path is in the workspace
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
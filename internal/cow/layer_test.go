@@ -0,0 +1,137 @@
+package cow
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates files (path relative to dir -> contents) under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestLayer(t *testing.T) *Layer {
+	t.Helper()
+	store := &Store{Dir: filepath.Join(t.TempDir(), "cow")}
+	l, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return l
+}
+
+func TestStoreOpenFind(t *testing.T) {
+	root := t.TempDir()
+	store := &Store{Dir: filepath.Join(root, "home", ".fence", "cow")}
+	workspace := filepath.Join(root, "src")
+
+	if _, err := store.Find(workspace); !errors.Is(err, ErrNoLayer) {
+		t.Fatalf("Find() before Open error = %v, want ErrNoLayer", err)
+	}
+	l, err := store.Open(workspace)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for _, dir := range []string{l.Upper(), l.Work()} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("%s should be a directory: %v", dir, err)
+		}
+	}
+	found, err := store.Find(workspace)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if found.Workspace != workspace || found.Dir != l.Dir || !found.Created.Equal(l.Created) {
+		t.Errorf("Find() = %+v, want %+v", found, l)
+	}
+	if again, err := store.Open(workspace); err != nil || again.Dir != l.Dir {
+		t.Errorf("Open() again = %+v, %v; want the same layer", again, err)
+	}
+
+	for _, bad := range []string{"src", filepath.Join(root, "home")} {
+		if _, err := store.Open(bad); err == nil {
+			t.Errorf("Open(%q) should fail", bad)
+		}
+	}
+}
+
+func TestChangesAndCommit(t *testing.T) {
+	l := newTestLayer(t)
+	writeFiles(t, l.Workspace, map[string]string{"a.txt": "one\n", "lib/b.txt": "b\n", "file": "f\n"})
+	writeFiles(t, l.Upper(), map[string]string{"a.txt": "two\n", "lib/c.txt": "c\n", "new/d.txt": "d\n", "file/e": "e\n"})
+	if err := os.Symlink("a.txt", filepath.Join(l.Upper(), "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := l.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	want := []Change{
+		{Path: "a.txt", Kind: Modified},
+		{Path: "file", Kind: Modified, Dir: true},
+		{Path: "file/e", Kind: Added},
+		{Path: "lib/c.txt", Kind: Added},
+		{Path: "link", Kind: Added},
+		{Path: "new", Kind: Added, Dir: true},
+		{Path: "new/d.txt", Kind: Added},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("Changes() = %+v, want %+v", changes, want)
+	}
+
+	var status bytes.Buffer
+	if err := WriteStatus(&status, changes); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := "M a.txt\nM file/\nA file/e\nA lib/c.txt\nA link\nA new/\nA new/d.txt\n"
+	if status.String() != wantStatus {
+		t.Errorf("WriteStatus() = %q, want %q", status.String(), wantStatus)
+	}
+
+	if err := l.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	for name, data := range map[string]string{"a.txt": "two\n", "lib/b.txt": "b\n", "lib/c.txt": "c\n", "new/d.txt": "d\n", "file/e": "e\n", "link": "two\n"} {
+		got, err := os.ReadFile(filepath.Join(l.Workspace, name))
+		if err != nil || string(got) != data {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, data)
+		}
+	}
+	if _, err := os.Stat(l.Dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Commit() should remove the layer, stat error = %v", err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	l := newTestLayer(t)
+	writeFiles(t, l.Workspace, map[string]string{"a.txt": "one\n"})
+	writeFiles(t, l.Upper(), map[string]string{"a.txt": "two\n"})
+	// overlayfs leaves a directory like this in work/
+	if err := os.Mkdir(filepath.Join(l.Work(), "work"), 0o000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Discard(); err != nil {
+		t.Fatalf("Discard() error = %v", err)
+	}
+	if _, err := os.Stat(l.Dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Discard() should remove the layer, stat error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(l.Workspace, "a.txt")); string(got) != "one\n" {
+		t.Errorf("a.txt = %q, Discard() should leave the workspace unchanged", got)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package cow
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// opaqueXattrs mark a directory in the upper layer that hides the lower
+// directory's contents: trusted.* when overlayfs is mounted by root,
+// user.* when it is mounted in a user namespace (userxattr).
+var opaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// isWhiteout reports whether info is an overlayfs whiteout, a 0:0
+// character device that marks a deleted path.
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// isOpaque reports whether the directory at path is opaque.
+func isOpaque(path string) bool {
+	buf := make([]byte, 1)
+	for _, name := range opaqueXattrs {
+		if n, err := unix.Lgetxattr(path, name, buf); err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package cow
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestChangesWhiteoutAndOpaque(t *testing.T) {
+	l := newTestLayer(t)
+	writeFiles(t, l.Workspace, map[string]string{"gone.txt": "x\n", "olddir/a": "a\n", "replaced/old": "old\n"})
+	if err := unix.Mknod(filepath.Join(l.Upper(), "gone.txt"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("cannot create whiteouts: %v", err)
+	}
+	if err := unix.Mknod(filepath.Join(l.Upper(), "olddir"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, l.Upper(), map[string]string{"replaced/new": "new\n"})
+	if err := unix.Setxattr(filepath.Join(l.Upper(), "replaced"), "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("cannot set user xattrs: %v", err)
+	}
+
+	changes, err := l.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	want := []Change{
+		{Path: "gone.txt", Kind: Deleted},
+		{Path: "olddir", Kind: Deleted, Dir: true},
+		{Path: "replaced", Kind: Modified, Dir: true, Opaque: true},
+		{Path: "replaced/new", Kind: Added},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("Changes() = %+v, want %+v", changes, want)
+	}
+
+	var diff bytes.Buffer
+	if err := l.WriteDiff(&diff, changes); err != nil {
+		t.Fatalf("WriteDiff() error = %v", err)
+	}
+	for _, s := range []string{"--- a/gone.txt", "-x", "Deleted directory olddir/", "Replaced directory replaced/", "+++ b/replaced/new", "+new"} {
+		if !strings.Contains(diff.String(), s) {
+			t.Errorf("WriteDiff() = %q, want it to contain %q", diff.String(), s)
+		}
+	}
+
+	if err := l.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	for _, name := range []string{"gone.txt", "olddir", "replaced/old"} {
+		if _, err := os.Lstat(filepath.Join(l.Workspace, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s should be deleted, stat error = %v", name, err)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(l.Workspace, "replaced/new")); string(got) != "new\n" {
+		t.Errorf("replaced/new = %q, want %q", got, "new\n")
+	}
+}
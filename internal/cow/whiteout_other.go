@@ -0,0 +1,16 @@
+//go:build !linux
+
+package cow
+
+import "os"
+
+// isWhiteout reports whether info is an overlayfs whiteout. Layers are
+// only created on Linux.
+func isWhiteout(info os.FileInfo) bool {
+	return false
+}
+
+// isOpaque reports whether the directory at path is opaque.
+func isOpaque(path string) bool {
+	return false
+}
@@ -0,0 +1,220 @@
+// Package history records what fence runs did: the network connections,
+// commands, and file writes they made, allowed or not. "fence config impact"
+// replays the recorded runs against a proposed config to show what it would
+// change before it is adopted.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// MaxRuns is how many runs a Store keeps; saving another removes the oldest.
+const MaxRuns = 100
+
+// ErrNoRuns is returned when there are no recorded runs to select.
+var ErrNoRuns = errors.New("no recorded runs (run commands with fence --record first)")
+
+// Event is an operation a run performed, counted over the run.
+type Event struct {
+	Kind string `json:"kind"` // policy.KindNetwork, KindCommand, or KindFilesystem
+	// Target is "host:port" for network operations, the command line for
+	// commands, and for filesystem operations a path the run wrote to.
+	Target  string `json:"target"`
+	Allowed bool   `json:"allowed"`
+	Count   int    `json:"count"`
+}
+
+// Recorder collects the events of a run. Repeats of the same operation are
+// counted rather than recorded again. It is safe for concurrent use, and a
+// nil Recorder records nothing.
+type Recorder struct {
+	mu     sync.Mutex
+	events []*Event
+	index  map[string]*Event
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{index: make(map[string]*Event)}
+}
+
+// Record adds an operation, redacting secrets in target. The last outcome
+// recorded for it wins.
+func (r *Recorder) Record(kind, target string, allowed bool) {
+	if r == nil {
+		return
+	}
+	target = policy.Redact(target)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := kind + "\x00" + target
+	if e, ok := r.index[key]; ok {
+		e.Count++
+		e.Allowed = allowed
+		return
+	}
+	e := &Event{Kind: kind, Target: target, Allowed: allowed, Count: 1}
+	r.events = append(r.events, e)
+	r.index[key] = e
+}
+
+// Events returns the recorded events, sorted by kind and target.
+func (r *Recorder) Events() []Event {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]Event, len(r.events))
+	for i, e := range r.events {
+		events[i] = *e
+	}
+	sortEvents(events)
+	return events
+}
+
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Kind != events[j].Kind {
+			return events[i].Kind < events[j].Kind
+		}
+		return events[i].Target < events[j].Target
+	})
+}
+
+// Run is a recorded fence run.
+type Run struct {
+	ID      string    `json:"id"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Command string    `json:"command"`
+	Dir     string    `json:"dir"` // Working directory
+	Events  []Event   `json:"events"`
+}
+
+// Store is the directory holding the recorded runs, one file per run.
+type Store struct {
+	Dir string
+}
+
+// DefaultStore returns the store in ~/.fence/history.
+func DefaultStore() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Store{Dir: filepath.Join(home, ".fence", "history")}, nil
+}
+
+// Save writes run to the store, naming it after its start time if it has no
+// ID and redacting secrets in its command, and removes the oldest runs
+// beyond MaxRuns.
+func (s *Store) Save(run *Run) error {
+	if run.ID == "" {
+		// Sorts in start order
+		run.ID = run.Start.UTC().Format("20060102T150405.000000000Z")
+	}
+	run.Command = policy.Redact(run.Command)
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, run.ID+".json"), data, 0o600); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids[:max(len(ids)-MaxRuns, 0)] {
+		_ = os.Remove(filepath.Join(s.Dir, id+".json"))
+	}
+	return nil
+}
+
+// ids returns the IDs of the stored runs, oldest first.
+func (s *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// load reads the run with the given ID.
+func (s *Store) load(id string) (Run, error) {
+	var run Run
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return run, fmt.Errorf("invalid run ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, id+".json")) //nolint:gosec // id cannot leave the store
+	if errors.Is(err, os.ErrNotExist) {
+		return run, fmt.Errorf("no recorded run %q", id)
+	}
+	if err != nil {
+		return run, err
+	}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return run, fmt.Errorf("invalid recorded run %s: %w", id, err)
+	}
+	return run, nil
+}
+
+// Select returns the runs sel names, oldest first: "all", "last:N" for the
+// N most recent, or a comma-separated list of run IDs.
+func (s *Store) Select(sel string) ([]Run, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrNoRuns
+	}
+
+	switch {
+	case sel == "all":
+	case strings.HasPrefix(sel, "last:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(sel, "last:"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid run selection %q: expected last:N with N at least 1", sel)
+		}
+		ids = ids[max(len(ids)-n, 0):]
+	default:
+		ids = strings.Split(sel, ",")
+	}
+
+	runs := make([]Run, 0, len(ids))
+	for _, id := range ids {
+		run, err := s.load(strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
@@ -0,0 +1,118 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Record(policy.KindNetwork, "github.com:443", true)
+		}()
+	}
+	wg.Wait()
+	r.Record(policy.KindCommand, "git push", false)
+	r.Record(policy.KindCommand, "mysql --password=hunter2", true)
+	r.Record(policy.KindNetwork, "evil.com:443", true)
+	r.Record(policy.KindNetwork, "evil.com:443", false)
+
+	want := []Event{
+		{Kind: policy.KindCommand, Target: "git push", Allowed: false, Count: 1},
+		{Kind: policy.KindCommand, Target: "mysql --password=[REDACTED]", Allowed: true, Count: 1},
+		{Kind: policy.KindNetwork, Target: "evil.com:443", Allowed: false, Count: 2},
+		{Kind: policy.KindNetwork, Target: "github.com:443", Allowed: true, Count: 10},
+	}
+	if got := r.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Events() = %+v, want %+v", got, want)
+	}
+
+	var none *Recorder
+	none.Record(policy.KindCommand, "ls", true)
+	if got := none.Events(); got != nil {
+		t.Errorf("nil Recorder Events() = %+v, want nil", got)
+	}
+}
+
+func TestStoreSaveSelect(t *testing.T) {
+	s := &Store{Dir: filepath.Join(t.TempDir(), "history")}
+	if _, err := s.Select("all"); !errors.Is(err, ErrNoRuns) {
+		t.Fatalf("Select() on an empty store error = %v, want ErrNoRuns", err)
+	}
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := range 3 {
+		run := &Run{Start: start.Add(time.Duration(i) * time.Hour), Command: fmt.Sprintf("cmd %d", i)}
+		if err := s.Save(run); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		ids = append(ids, run.ID)
+	}
+
+	tests := []struct {
+		sel  string
+		want []string // Commands
+	}{
+		{"all", []string{"cmd 0", "cmd 1", "cmd 2"}},
+		{"last:2", []string{"cmd 1", "cmd 2"}},
+		{"last:10", []string{"cmd 0", "cmd 1", "cmd 2"}},
+		{ids[2] + "," + ids[0], []string{"cmd 2", "cmd 0"}},
+	}
+	for _, tt := range tests {
+		runs, err := s.Select(tt.sel)
+		if err != nil {
+			t.Errorf("Select(%q) error = %v", tt.sel, err)
+			continue
+		}
+		var got []string
+		for _, r := range runs {
+			got = append(got, r.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Select(%q) = %q, want %q", tt.sel, got, tt.want)
+		}
+	}
+
+	for _, sel := range []string{"last:0", "last:x", "nope", "../history/" + ids[0], ""} {
+		if _, err := s.Select(sel); err == nil {
+			t.Errorf("Select(%q) should fail", sel)
+		}
+	}
+}
+
+func TestStorePrunesOldRuns(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := range MaxRuns + 2 {
+		if err := s.Save(&Run{Start: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != MaxRuns {
+		t.Errorf("store holds %d runs, want %d", len(entries), MaxRuns)
+	}
+	runs, err := s.Select("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(2 * time.Minute); !runs[0].Start.Equal(want) {
+		t.Errorf("oldest kept run started at %v, want %v", runs[0].Start, want)
+	}
+}
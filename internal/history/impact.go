@@ -0,0 +1,136 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Evaluator decides whether an operation is allowed, e.g. under a config.
+type Evaluator func(kind, target string) policy.Decision
+
+// ImpactChange is a replayed operation whose outcome differs between the
+// current and the proposed config.
+type ImpactChange struct {
+	Kind     string          `json:"kind"`
+	Target   string          `json:"target"`
+	Current  policy.Decision `json:"current"`
+	Proposed policy.Decision `json:"proposed"`
+	Count    int             `json:"count"` // Times the runs performed it
+	Runs     int             `json:"runs"`  // Runs that performed it
+}
+
+// Impact is what adopting a proposed config would have changed in a set of
+// recorded runs.
+type Impact struct {
+	Runs       int       `json:"runs"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Operations int       `json:"operations"` // Distinct operations replayed
+	// NewlyBlocked are the operations the current config allows and the
+	// proposed one denies; NewlyAllowed the reverse.
+	NewlyBlocked []ImpactChange `json:"newlyBlocked"`
+	NewlyAllowed []ImpactChange `json:"newlyAllowed"`
+}
+
+// Analyze replays the events of runs against the current and the proposed
+// config. Each distinct operation is evaluated once under each; what the
+// runs were allowed to do at the time does not matter, since they may have
+// used yet another config.
+func Analyze(runs []Run, current, proposed Evaluator) Impact {
+	impact := Impact{Runs: len(runs), NewlyBlocked: []ImpactChange{}, NewlyAllowed: []ImpactChange{}}
+
+	type seen struct {
+		count, runs int
+	}
+	ops := make(map[Event]*seen) // Keyed by kind and target only
+	var order []Event
+	for _, run := range runs {
+		if impact.From.IsZero() || run.Start.Before(impact.From) {
+			impact.From = run.Start
+		}
+		if run.End.After(impact.To) {
+			impact.To = run.End
+		}
+		inRun := make(map[Event]bool)
+		for _, e := range run.Events {
+			key := Event{Kind: e.Kind, Target: e.Target}
+			s, ok := ops[key]
+			if !ok {
+				s = &seen{}
+				ops[key] = s
+				order = append(order, key)
+			}
+			s.count += e.Count
+			if !inRun[key] {
+				inRun[key] = true
+				s.runs++
+			}
+		}
+	}
+	impact.Operations = len(order)
+
+	sortEvents(order)
+	for _, key := range order {
+		before, after := current(key.Kind, key.Target), proposed(key.Kind, key.Target)
+		if before.Allowed == after.Allowed {
+			continue
+		}
+		c := ImpactChange{Kind: key.Kind, Target: key.Target, Current: before, Proposed: after, Count: ops[key].count, Runs: ops[key].runs}
+		if after.Allowed {
+			impact.NewlyAllowed = append(impact.NewlyAllowed, c)
+		} else {
+			impact.NewlyBlocked = append(impact.NewlyBlocked, c)
+		}
+	}
+	return impact
+}
+
+// WriteReport writes a human-readable summary of the impact.
+func (i Impact) WriteReport(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Replayed %d run(s) from %s to %s: %d distinct operation(s)\n",
+		i.Runs, i.From.Local().Format("2006-01-02 15:04"), i.To.Local().Format("2006-01-02 15:04"), i.Operations); err != nil {
+		return err
+	}
+	if len(i.NewlyBlocked) == 0 && len(i.NewlyAllowed) == 0 {
+		_, err := fmt.Fprintln(w, "No change: every operation has the same outcome under both configs")
+		return err
+	}
+
+	sections := []struct {
+		icon    output.Icon
+		header  string
+		changes []ImpactChange
+	}{
+		{output.Blocked, "%d operation(s) allowed now would be blocked:", i.NewlyBlocked},
+		{output.Allowed, "%d operation(s) blocked now would be allowed:", i.NewlyAllowed},
+	}
+	for _, s := range sections {
+		if len(s.changes) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", output.Render(s.icon), fmt.Sprintf(s.header, len(s.changes))); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, c := range s.changes {
+			fmt.Fprintf(tw, "  %s\t%s\tx%d in %d run(s)\t%s\n", c.Kind, c.Target, c.Count, c.Runs, c.Proposed.Basis())
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the impact as a JSON document.
+func (i Impact) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i)
+}
@@ -0,0 +1,94 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// allowOnly returns an Evaluator that allows exactly the given targets.
+func allowOnly(targets ...string) Evaluator {
+	return func(kind, target string) policy.Decision {
+		for _, t := range targets {
+			if t == target {
+				return policy.Allow(policy.RuleRef("test.allow", t), "listed")
+			}
+		}
+		return policy.Deny("", "not listed")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	runs := []Run{
+		{Start: start, End: start.Add(time.Minute), Events: []Event{
+			{Kind: policy.KindNetwork, Target: "github.com:443", Allowed: true, Count: 3},
+			{Kind: policy.KindNetwork, Target: "api.example.com:443", Allowed: true, Count: 5},
+			{Kind: policy.KindCommand, Target: "git push", Allowed: false, Count: 1},
+		}},
+		{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour), Events: []Event{
+			{Kind: policy.KindNetwork, Target: "api.example.com:443", Allowed: true, Count: 2},
+			{Kind: policy.KindFilesystem, Target: "/work/out.txt", Allowed: true, Count: 1},
+		}},
+	}
+
+	current := allowOnly("github.com:443", "api.example.com:443", "/work/out.txt")
+	proposed := allowOnly("github.com:443", "git push", "/work/out.txt")
+	impact := Analyze(runs, current, proposed)
+
+	if impact.Runs != 2 || impact.Operations != 4 {
+		t.Errorf("Runs, Operations = %d, %d, want 2, 4", impact.Runs, impact.Operations)
+	}
+	if !impact.From.Equal(start) || !impact.To.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("From, To = %v, %v", impact.From, impact.To)
+	}
+	if len(impact.NewlyBlocked) != 1 {
+		t.Fatalf("NewlyBlocked = %+v, want api.example.com:443 only", impact.NewlyBlocked)
+	}
+	if c := impact.NewlyBlocked[0]; c.Target != "api.example.com:443" || c.Count != 7 || c.Runs != 2 || c.Proposed.Allowed {
+		t.Errorf("NewlyBlocked[0] = %+v", c)
+	}
+	if len(impact.NewlyAllowed) != 1 || impact.NewlyAllowed[0].Target != "git push" {
+		t.Errorf("NewlyAllowed = %+v, want git push only", impact.NewlyAllowed)
+	}
+}
+
+func TestImpactWriteReport(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	runs := []Run{{Start: start, End: start, Events: []Event{
+		{Kind: policy.KindNetwork, Target: "api.example.com:443", Count: 5},
+	}}}
+
+	var buf bytes.Buffer
+	if err := Analyze(runs, allowOnly("api.example.com:443"), allowOnly()).WriteReport(&buf); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	for _, want := range []string{"Replayed 1 run(s)", "1 distinct operation(s)", "1 operation(s) allowed now would be blocked:", "api.example.com:443", "x5 in 1 run(s)", "not listed"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := Analyze(runs, allowOnly(), allowOnly()).WriteReport(&buf); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "No change") {
+		t.Errorf("report should say nothing changed:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := Analyze(runs, allowOnly(), allowOnly()).WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var doc struct {
+		NewlyBlocked []ImpactChange `json:"newlyBlocked"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil || doc.NewlyBlocked == nil {
+		t.Errorf("WriteJSON() = %s, want an empty newlyBlocked list (err %v)", buf.String(), err)
+	}
+}
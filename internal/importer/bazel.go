@@ -0,0 +1,280 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// bazelEndpointFlags are the .bazelrc flags that name a remote service the
+// build connects to.
+var bazelEndpointFlags = []string{
+	"remote_cache",
+	"remote_executor",
+	"experimental_remote_downloader",
+	"bes_backend",
+}
+
+// bazelPathFlags are the .bazelrc flags that name a directory Bazel writes to.
+var bazelPathFlags = []string{
+	"output_base",
+	"output_user_root",
+	"disk_cache",
+	"repository_cache",
+}
+
+// maxBazelrcImports bounds the import chain, in case files import each other.
+const maxBazelrcImports = 20
+
+// bazelFlag is a flag read from a .bazelrc file.
+type bazelFlag struct {
+	name  string
+	value string
+	file  string
+}
+
+// DefaultBazelrcPath returns the .bazelrc of the workspace in the current
+// directory.
+func DefaultBazelrcPath() string {
+	return ".bazelrc"
+}
+
+// ImportFromBazelrc reads the remote endpoints and output paths configured in
+// a .bazelrc file and the files it imports, and returns a fence config that
+// allows them. If path is empty, it reads the .bazelrc in the current
+// directory. Flags under every config (build:ci and the like) are included.
+func ImportFromBazelrc(path string, opts ImportOptions) (*ImportResult, error) {
+	if path == "" {
+		path = DefaultBazelrcPath()
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// %workspace% in imports and values is the directory of the .bazelrc
+	r := &bazelrcReader{workspace: filepath.Dir(absPath), seen: map[string]bool{}}
+	if err := r.read(absPath, false); err != nil {
+		return nil, err
+	}
+
+	cfg := config.Default()
+	if opts.Extends != "" {
+		cfg.Extends = opts.Extends
+	}
+	result := &ImportResult{Config: cfg, SourcePath: path, Warnings: r.warnings}
+
+	for _, f := range r.flags {
+		switch {
+		case slices.Contains(bazelEndpointFlags, f.name):
+			if f.value == "" {
+				continue
+			}
+			if socket, ok := strings.CutPrefix(f.value, "unix:"); ok {
+				cfg.Network.AllowUnixSockets = appendUnique(cfg.Network.AllowUnixSockets, strings.TrimPrefix(socket, "//"))
+				result.RulesImported++
+				continue
+			}
+			host, grpc, err := bazelEndpointHost(f.value)
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("--%s=%s in %s skipped: %v", f.name, f.value, f.file, err))
+				continue
+			}
+			cfg.Network.AllowedDomains = appendUnique(cfg.Network.AllowedDomains, host)
+			result.RulesImported++
+			if grpc {
+				result.Warnings = append(result.Warnings, fmt.Sprintf(
+					"--%s=%s uses gRPC, which Bazel does not send through HTTP_PROXY; on Linux it needs a sandbox that shares the host network (--no-network-sandbox)", f.name, f.value))
+			}
+		case slices.Contains(bazelPathFlags, f.name):
+			if f.value == "" {
+				continue
+			}
+			cfg.Filesystem.AllowWrite = appendUnique(cfg.Filesystem.AllowWrite, strings.TrimRight(f.value, "/")+"/**")
+			result.RulesImported++
+		}
+	}
+
+	return result, nil
+}
+
+// bazelEndpointHost returns the host of a remote endpoint, given as a URL
+// (grpc://, grpcs://, http://, https://) or as host:port, which Bazel
+// connects to with gRPC.
+func bazelEndpointHost(endpoint string) (host string, grpc bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			host = endpoint
+		}
+		if host == "" {
+			return "", false, errors.New("no host")
+		}
+		return host, true, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, err
+	}
+	switch u.Scheme {
+	case "grpc", "grpcs":
+		grpc = true
+	case "http", "https":
+	default:
+		return "", false, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", false, errors.New("no host")
+	}
+	return u.Hostname(), grpc, nil
+}
+
+// bazelrcReader collects flags from a .bazelrc and its imports.
+type bazelrcReader struct {
+	workspace string
+	seen      map[string]bool
+	flags     []bazelFlag
+	warnings  []string
+}
+
+// read reads the flags in path. A missing file is an error unless optional,
+// as for try-import.
+func (r *bazelrcReader) read(path string, optional bool) error {
+	if r.seen[path] {
+		return nil
+	}
+	if len(r.seen) >= maxBazelrcImports {
+		return fmt.Errorf("too many imports reading %s", path)
+	}
+	r.seen[path] = true
+
+	f, err := os.Open(path) //nolint:gosec // user-provided path - intentional
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read .bazelrc: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	var line strings.Builder
+	for scanner.Scan() {
+		text := scanner.Text()
+		// A trailing backslash continues the line
+		if cont, ok := strings.CutSuffix(text, "\\"); ok {
+			line.WriteString(cont + " ")
+			continue
+		}
+		line.WriteString(text)
+		if err := r.readLine(path, line.String()); err != nil {
+			return err
+		}
+		line.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read .bazelrc: %w", err)
+	}
+	return r.readLine(path, line.String())
+}
+
+func (r *bazelrcReader) readLine(path, line string) error {
+	words, err := splitBazelrcLine(line)
+	if err != nil {
+		r.warnings = append(r.warnings, fmt.Sprintf("line %q in %s skipped: %v", line, path, err))
+		return nil
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	switch words[0] {
+	case "import", "try-import":
+		if len(words) != 2 {
+			r.warnings = append(r.warnings, fmt.Sprintf("line %q in %s skipped: %s takes one file", line, path, words[0]))
+			return nil
+		}
+		target := strings.ReplaceAll(words[1], "%workspace%", r.workspace)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return r.read(target, words[0] == "try-import")
+	}
+
+	// The first word is the command, such as build or build:ci, and the rest
+	// are its flags
+	args := words[1:]
+	for i := 0; i < len(args); i++ {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok {
+			continue
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		if !slices.Contains(bazelEndpointFlags, name) && !slices.Contains(bazelPathFlags, name) {
+			continue
+		}
+		if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			i++
+			value = args[i]
+		}
+		value = strings.ReplaceAll(value, "%workspace%", r.workspace)
+		r.flags = append(r.flags, bazelFlag{name: name, value: value, file: path})
+	}
+	return nil
+}
+
+// splitBazelrcLine splits a .bazelrc line into words, as Bazel does: words
+// are separated by spaces, may be quoted with ' or ", and # starts a
+// comment.
+func splitBazelrcLine(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == '#' && !inWord:
+			return words, nil
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,104 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImportFromBazelrc(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("ci.bazelrc", `build:ci --remote_executor=grpcs://remote.buildbuddy.io
+build:ci --bes_backend remote.buildbuddy.io:443
+`)
+	rc := write(".bazelrc", `# Shared settings
+startup --output_user_root=/var/cache/bazel
+build --remote_cache=https://cache.example.com/bazel \
+    --disk_cache=~/.cache/bazel-disk/
+common --repository_cache="%workspace%/.repo cache"
+build --remote_cache=unix:/run/cache.sock
+build --remote_cache=ftp://cache.example.com
+build --jobs=8  # not a path or endpoint
+import %workspace%/ci.bazelrc
+try-import %workspace%/user.bazelrc
+`)
+
+	result, err := ImportFromBazelrc(rc, ImportOptions{Extends: "bazel"})
+	if err != nil {
+		t.Fatalf("ImportFromBazelrc() error = %v", err)
+	}
+	cfg := result.Config
+	if cfg.Extends != "bazel" {
+		t.Errorf("Extends = %q, want bazel", cfg.Extends)
+	}
+	if want := []string{"cache.example.com", "remote.buildbuddy.io"}; !slices.Equal(cfg.Network.AllowedDomains, want) {
+		t.Errorf("AllowedDomains = %q, want %q", cfg.Network.AllowedDomains, want)
+	}
+	if want := []string{"/run/cache.sock"}; !slices.Equal(cfg.Network.AllowUnixSockets, want) {
+		t.Errorf("AllowUnixSockets = %q, want %q", cfg.Network.AllowUnixSockets, want)
+	}
+	wantWrite := []string{"/var/cache/bazel/**", "~/.cache/bazel-disk/**", filepath.Join(dir, ".repo cache") + "/**"}
+	if !slices.Equal(cfg.Filesystem.AllowWrite, wantWrite) {
+		t.Errorf("AllowWrite = %q, want %q", cfg.Filesystem.AllowWrite, wantWrite)
+	}
+	if result.RulesImported != 7 {
+		t.Errorf("RulesImported = %d, want 7", result.RulesImported)
+	}
+
+	warnings := strings.Join(result.Warnings, "\n")
+	for _, want := range []string{"ftp://cache.example.com in", "grpcs://remote.buildbuddy.io uses gRPC", "remote.buildbuddy.io:443 uses gRPC"} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("warnings missing %q:\n%s", want, warnings)
+		}
+	}
+}
+
+func TestImportFromBazelrcMissing(t *testing.T) {
+	if _, err := ImportFromBazelrc(filepath.Join(t.TempDir(), ".bazelrc"), ImportOptions{}); err == nil {
+		t.Error("ImportFromBazelrc() of a missing file should fail")
+	}
+
+	// A missing import is an error too, unlike try-import
+	rc := filepath.Join(t.TempDir(), ".bazelrc")
+	if err := os.WriteFile(rc, []byte("import %workspace%/missing.bazelrc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportFromBazelrc(rc, ImportOptions{}); err == nil {
+		t.Error("ImportFromBazelrc() with a missing import should fail")
+	}
+}
+
+func TestSplitBazelrcLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"build --jobs=8", []string{"build", "--jobs=8"}, false},
+		{"  # comment", nil, false},
+		{"build --copt=-DX#1 # comment", []string{"build", "--copt=-DX#1"}, false},
+		{`build --disk_cache="/a b" --x='c d'`, []string{"build", "--disk_cache=/a b", "--x=c d"}, false},
+		{`build --x=a\ b`, []string{"build", "--x=a b"}, false},
+		{`build --x="unterminated`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitBazelrcLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitBazelrcLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitBazelrcLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package logging writes fence's own log messages: debug output, notices,
+// warnings, and errors from the CLI, the proxies, the sandbox, and the
+// monitors. Each message has a level and the component that logged it. As
+// text, a message is one line such as "[fence:linux] Bridges ready", with
+// warnings and errors marked (in color unless NO_COLOR is set or color is
+// off); as JSON, it is one object per line. Secrets in messages are
+// redacted either way.
+//
+// Violations are not log messages: they go to policy.MonitorOutput and the
+// violation log.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// Levels, from most to least verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Formats for SetFormat.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name: debug, info, warn (or warning), or error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (valid: debug, info, warn, error)", s)
+}
+
+// Logger writes messages at or above its level. It is safe for concurrent
+// use.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+}
+
+// New returns a text logger that writes every message to w. Debug messages
+// are only logged when debugging is on (fence -d, or sandbox.WithDebug), so
+// the default level shows all of them; raise it to show fewer.
+func New(w io.Writer) *Logger {
+	return &Logger{out: w, level: LevelDebug, format: FormatText}
+}
+
+// WithOutput returns a logger with l's level and format that writes to w.
+func (l *Logger) WithOutput(w io.Writer) *Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &Logger{out: w, level: l.level, format: l.format}
+}
+
+// SetOutput sets where messages are written.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+// SetLevel sets the lowest level that is written.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Level returns the lowest level that is written.
+func (l *Logger) Level() Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// SetFormat sets the format messages are written in: FormatText or
+// FormatJSON.
+func (l *Logger) SetFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q (valid: %s, %s)", format, FormatText, FormatJSON)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+	return nil
+}
+
+// Debugf logs a debug message from component, e.g. "linux". An empty
+// component is fence itself.
+func (l *Logger) Debugf(component, format string, args ...any) {
+	l.log(LevelDebug, component, format, args...)
+}
+
+// Infof logs a notice from component.
+func (l *Logger) Infof(component, format string, args ...any) {
+	l.log(LevelInfo, component, format, args...)
+}
+
+// Warnf logs a warning from component.
+func (l *Logger) Warnf(component, format string, args ...any) {
+	l.log(LevelWarn, component, format, args...)
+}
+
+// Errorf logs an error from component.
+func (l *Logger) Errorf(component, format string, args ...any) {
+	l.log(LevelError, component, format, args...)
+}
+
+func (l *Logger) log(level Level, component, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	msg := policy.Redact(strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+
+	if l.format == FormatJSON {
+		line, err := json.Marshal(struct {
+			Time      time.Time `json:"time"`
+			Level     string    `json:"level"`
+			Component string    `json:"component,omitempty"`
+			Msg       string    `json:"msg"`
+		}{time.Now(), level.String(), component, msg})
+		if err == nil {
+			_, _ = l.out.Write(append(line, '\n'))
+		}
+		return
+	}
+
+	prefix := "[fence]"
+	if component != "" {
+		prefix = "[fence:" + component + "]"
+	}
+	switch level {
+	case LevelWarn:
+		msg = mark("Warning:", "33") + " " + msg
+	case LevelError:
+		msg = mark("Error:", "31") + " " + msg
+	}
+	_, _ = fmt.Fprintf(l.out, "%s %s\n", prefix, msg)
+}
+
+// mark renders a level marker, in color if the output style has color.
+func mark(s, color string) string {
+	if output.Current().Color {
+		return "\x1b[" + color + "m" + s + "\x1b[0m"
+	}
+	return s
+}
+
+var std = New(os.Stderr)
+
+// Default returns the logger the package-level functions write to, which
+// writes to stderr.
+func Default() *Logger {
+	return std
+}
+
+// Debugf logs a debug message with the default logger.
+func Debugf(component, format string, args ...any) {
+	std.Debugf(component, format, args...)
+}
+
+// Infof logs a notice with the default logger.
+func Infof(component, format string, args ...any) {
+	std.Infof(component, format, args...)
+}
+
+// Warnf logs a warning with the default logger.
+func Warnf(component, format string, args ...any) {
+	std.Warnf(component, format, args...)
+}
+
+// Errorf logs an error with the default logger.
+func Errorf(component, format string, args ...any) {
+	std.Errorf(component, format, args...)
+}
@@ -0,0 +1,250 @@
+// Package managed fetches the centrally managed config a config's policyURL
+// names, so that a fleet of machines can share allowlists. The managed
+// config is merged under the local one: its lists are extended by the local
+// config's, and the local config's settings win.
+//
+// Fetched configs are cached in ~/.fence/managed with their ETag, so an
+// unchanged config is not downloaded again, and a machine that cannot reach
+// the server keeps using the last config it fetched. With a policyKey, a
+// config is only used if the detached Ed25519 signature at policyURL + ".sig"
+// verifies, including when it comes from the cache.
+package managed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/tidwall/jsonc"
+)
+
+// Fetch limits: how long fetching may take, and the largest config and
+// signature accepted.
+const (
+	fetchTimeout     = 10 * time.Second
+	maxConfigBytes   = 4 << 20
+	maxSignatureSize = 1 << 10
+)
+
+// Cache is the directory holding the fetched configs.
+type Cache struct {
+	Dir    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// DefaultCache returns the cache in ~/.fence/managed.
+func DefaultCache() (*Cache, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Cache{Dir: filepath.Join(home, ".fence", "managed")}, nil
+}
+
+// entry is the record kept next to a cached config.
+type entry struct {
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+	Fetched   time.Time `json:"fetched"`
+}
+
+// paths returns where the config fetched from url and its record are kept.
+func (c *Cache) paths(url string) (body, record string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:8])
+	return filepath.Join(c.Dir, name+".json"), filepath.Join(c.Dir, name+".meta.json")
+}
+
+// load returns the cached config fetched from url, if there is one.
+func (c *Cache) load(url string) ([]byte, *entry) {
+	bodyPath, recordPath := c.paths(url)
+	data, err := os.ReadFile(recordPath)
+	if err != nil {
+		return nil, nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil || e.URL != url {
+		return nil, nil
+	}
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil
+	}
+	return body, &e
+}
+
+// store caches body, fetched from e.URL.
+func (c *Cache) store(body []byte, e *entry) error {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return err
+	}
+	bodyPath, recordPath := c.paths(e.URL)
+	record, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(bodyPath, body, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(recordPath, record, 0o600)
+}
+
+// Fetch returns the config at url, downloading it unless the cached copy is
+// current. If key is set, the config must carry a valid signature by it. If
+// the server cannot be reached, the cached copy is used.
+func (c *Cache) Fetch(ctx context.Context, url, key string) (*config.Config, error) {
+	cached, e := c.load(url)
+	body, f, err := c.download(ctx, url, key, e)
+	fresh := false
+	switch {
+	case err == nil && body == nil:
+		// Not modified
+		body = cached
+	case err == nil:
+		e = &entry{URL: url, ETag: f.etag, Signature: f.signature, Fetched: time.Now()}
+		fresh = true
+	case cached != nil:
+		logging.Warnf("managed", "Using the managed config fetched %s: %v", e.Fetched.Local().Format(time.DateTime), err)
+		body = cached
+	default:
+		return nil, fmt.Errorf("failed to fetch managed config %s: %w", url, err)
+	}
+
+	// A cached copy is checked too: it is only as trustworthy as its directory
+	if key != "" {
+		if err := config.VerifySignature(body, e.Signature, key); err != nil {
+			return nil, fmt.Errorf("managed config %s: %w", url, err)
+		}
+	}
+	cfg, err := parse(url, body)
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		if err := c.store(body, e); err != nil {
+			logging.Warnf("managed", "Failed to cache the managed config: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// fetched is what download learned besides the body.
+type fetched struct {
+	etag      string
+	signature string // Only fetched with a key
+}
+
+// download fetches url, or returns a nil body if it has not changed since
+// the cached copy described by e. The signature is fetched if key is set.
+func (c *Cache) download(ctx context.Context, url, key string, e *entry) ([]byte, fetched, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	if e != nil && e.ETag != "" && (key == "" || e.Signature != "") {
+		header.Set("If-None-Match", e.ETag)
+	}
+	resp, err := c.get(ctx, url, header)
+	if err != nil {
+		return nil, fetched{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotModified && e != nil {
+		return nil, fetched{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetched{}, fmt.Errorf("server returned %s", resp.Status)
+	}
+	body, err := readLimited(resp.Body, maxConfigBytes)
+	if err != nil {
+		return nil, fetched{}, err
+	}
+	f := fetched{etag: resp.Header.Get("ETag")}
+
+	if key != "" {
+		sigResp, err := c.get(ctx, url+config.SignatureSuffix, nil)
+		if err != nil {
+			return nil, fetched{}, err
+		}
+		defer func() { _ = sigResp.Body.Close() }()
+		if sigResp.StatusCode != http.StatusOK {
+			return nil, fetched{}, fmt.Errorf("signature: server returned %s", sigResp.Status)
+		}
+		sig, err := readLimited(sigResp.Body, maxSignatureSize)
+		if err != nil {
+			return nil, fetched{}, fmt.Errorf("signature: %w", err)
+		}
+		f.signature = strings.TrimSpace(string(sig))
+	}
+	return body, f, nil
+}
+
+func (c *Cache) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// readLimited reads r, failing if it holds more than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// parse decodes and validates a managed config, which cannot name further
+// configs or local files to load.
+func parse(url string, body []byte) (*config.Config, error) {
+	var cfg config.Config
+	if err := json.Unmarshal(jsonc.ToJSON(body), &cfg); err != nil {
+		return nil, fmt.Errorf("invalid JSON in managed config %s: %w", url, err)
+	}
+	if cfg.Extends != "" || cfg.PolicyURL != "" || cfg.PolicyKey != "" ||
+		len(cfg.Network.AllowedDomainsFrom) > 0 || len(cfg.Network.DeniedDomainsFrom) > 0 {
+		return nil, fmt.Errorf("invalid managed config %s: extends, policyURL, policyKey, allowedDomainsFrom, and deniedDomainsFrom cannot be set in it", url)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid managed config %s: %w", url, err)
+	}
+	return &cfg, nil
+}
+
+// Layers returns layers with the managed config their merged result names
+// in policyURL added as the base layer, labeled "policy:" and the URL. If
+// no policyURL is set, layers are returned as they are.
+func Layers(ctx context.Context, c *Cache, layers []config.Layer) ([]config.Layer, error) {
+	merged := config.MergeLayers(layers)
+	if merged.PolicyURL == "" {
+		return layers, nil
+	}
+	cfg, err := c.Fetch(ctx, merged.PolicyURL, merged.PolicyKey)
+	if err != nil {
+		return nil, err
+	}
+	return append([]config.Layer{{Source: "policy:" + merged.PolicyURL, Config: cfg}}, layers...), nil
+}
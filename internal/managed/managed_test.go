@@ -0,0 +1,181 @@
+package managed
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+const managedConfig = `{
+  // Fleet-wide allowlist
+  "network": {"allowedDomains": ["github.com", "registry.npmjs.org"]},
+  "command": {"deny": ["git push"]}
+}`
+
+// policyServer serves body at /fence.json with an ETag, and sig at
+// /fence.json.sig. It counts the full downloads of the config.
+type policyServer struct {
+	*httptest.Server
+	body      atomic.Value // string
+	sig       atomic.Value // string
+	downloads atomic.Int32
+}
+
+func newPolicyServer(t *testing.T, body, sig string) *policyServer {
+	t.Helper()
+	s := &policyServer{}
+	s.body.Store(body)
+	s.sig.Store(sig)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := s.body.Load().(string)
+		switch r.URL.Path {
+		case "/fence.json":
+			sum := sha256.Sum256([]byte(body))
+			etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			s.downloads.Add(1)
+			w.Header().Set("ETag", etag)
+			_, _ = w.Write([]byte(body))
+		case "/fence.json.sig":
+			_, _ = w.Write([]byte(s.sig.Load().(string) + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func sign(t *testing.T, body string) (sig, key string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(body))),
+		base64.StdEncoding.EncodeToString(pub)
+}
+
+func TestFetchCachesByETag(t *testing.T) {
+	srv := newPolicyServer(t, managedConfig, "")
+	cache := &Cache{Dir: t.TempDir()}
+	url := srv.URL + "/fence.json"
+
+	for range 3 {
+		cfg, err := cache.Fetch(context.Background(), url, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cfg.Network.AllowedDomains, []string{"github.com", "registry.npmjs.org"}) {
+			t.Errorf("allowedDomains = %v", cfg.Network.AllowedDomains)
+		}
+	}
+	if n := srv.downloads.Load(); n != 1 {
+		t.Errorf("downloaded %d times, want 1 with the rest answered by ETag", n)
+	}
+
+	srv.body.Store(`{"network": {"allowedDomains": ["example.com"]}}`)
+	cfg, err := cache.Fetch(context.Background(), url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Network.AllowedDomains, []string{"example.com"}) {
+		t.Errorf("allowedDomains = %v, want the changed config", cfg.Network.AllowedDomains)
+	}
+}
+
+func TestFetchOffline(t *testing.T) {
+	srv := newPolicyServer(t, managedConfig, "")
+	cache := &Cache{Dir: t.TempDir()}
+	url := srv.URL + "/fence.json"
+
+	if _, err := cache.Fetch(context.Background(), url, ""); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	cfg, err := cache.Fetch(context.Background(), url, "")
+	if err != nil {
+		t.Fatalf("Fetch() with the server down = %v, want the cached config", err)
+	}
+	if len(cfg.Network.AllowedDomains) != 2 {
+		t.Errorf("allowedDomains = %v", cfg.Network.AllowedDomains)
+	}
+
+	if _, err := (&Cache{Dir: t.TempDir()}).Fetch(context.Background(), url, ""); err == nil {
+		t.Error("Fetch() with the server down and nothing cached should fail")
+	}
+}
+
+func TestFetchSigned(t *testing.T) {
+	sig, key := sign(t, managedConfig)
+	srv := newPolicyServer(t, managedConfig, sig)
+	cache := &Cache{Dir: t.TempDir()}
+	url := srv.URL + "/fence.json"
+
+	if _, err := cache.Fetch(context.Background(), url, key); err != nil {
+		t.Fatalf("Fetch() of a signed config = %v", err)
+	}
+	// From the cache, the signature is checked again
+	if _, err := cache.Fetch(context.Background(), url, key); err != nil {
+		t.Fatalf("Fetch() of a cached signed config = %v", err)
+	}
+
+	_, otherKey := sign(t, managedConfig)
+	if _, err := cache.Fetch(context.Background(), url, otherKey); err == nil || !strings.Contains(err.Error(), "does not verify") {
+		t.Errorf("Fetch() with another key = %v, want a verification error", err)
+	}
+
+	srv.body.Store(strings.Replace(managedConfig, "git push", "git pull", 1))
+	if _, err := cache.Fetch(context.Background(), url, key); err == nil {
+		t.Error("Fetch() of a tampered config should fail")
+	}
+	srv.sig.Store("")
+	if _, err := cache.Fetch(context.Background(), url, key); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("Fetch() of an unsigned config = %v, want not signed", err)
+	}
+}
+
+func TestFetchRejectsNestedSources(t *testing.T) {
+	srv := newPolicyServer(t, `{"extends": "code"}`, "")
+	if _, err := (&Cache{Dir: t.TempDir()}).Fetch(context.Background(), srv.URL+"/fence.json", ""); err == nil {
+		t.Error("a managed config with extends should be rejected")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	srv := newPolicyServer(t, managedConfig, "")
+	cache := &Cache{Dir: t.TempDir()}
+	local := &config.Config{PolicyURL: srv.URL + "/fence.json"}
+	local.Network.AllowedDomains = []string{"api.internal"}
+
+	layers, err := Layers(context.Background(), cache, []config.Layer{{Source: "/home/u/.fence.json", Config: local}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 2 || layers[0].Source != "policy:"+local.PolicyURL {
+		t.Fatalf("layers = %+v, want the managed config as the base", layers)
+	}
+	merged := config.MergeLayers(layers)
+	if !slices.Equal(merged.Network.AllowedDomains, []string{"github.com", "registry.npmjs.org", "api.internal"}) {
+		t.Errorf("allowedDomains = %v, want the managed list extended by the local one", merged.Network.AllowedDomains)
+	}
+
+	plain := []config.Layer{{Source: "default", Config: config.Default()}}
+	if got, err := Layers(context.Background(), cache, plain); err != nil || len(got) != 1 {
+		t.Errorf("Layers() without policyURL = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package mcp implements a Model Context Protocol server over stdio that
+// runs tool calls through the fence sandbox, so AI agents can use fence as
+// their command execution backend.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// ProtocolVersion is the MCP revision the server implements.
+const ProtocolVersion = "2025-06-18"
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Sandbox is the part of sandbox.Manager the server needs.
+type Sandbox interface {
+	WrapCommand(ctx context.Context, command string) (string, error)
+	Violations() *policy.ViolationLog
+	Env(environ []string) []string
+}
+
+// Server answers MCP requests read from one stream and writes responses to another.
+type Server struct {
+	sandbox Sandbox
+	config  *config.Config
+	version string
+	debug   bool
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+}
+
+// NewServer creates a server that runs commands through sb under cfg.
+// version is reported to clients as the server version.
+func NewServer(sb Sandbox, cfg *config.Config, version string, debug bool) *Server {
+	return &Server{
+		sandbox:  sb,
+		config:   cfg,
+		version:  version,
+		debug:    debug,
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+// request is a JSON-RPC request or notification (no ID).
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Serve reads newline-delimited JSON-RPC messages from r and writes responses
+// to w until r is exhausted or ctx is canceled. Tool calls run concurrently;
+// Serve waits for them before returning.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.enc = json.NewEncoder(w)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	lines := make(chan []byte)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-scanErr:
+					return err
+				default:
+					return nil
+				}
+			}
+			if len(line) == 0 {
+				continue
+			}
+
+			var req request
+			if err := json.Unmarshal(line, &req); err != nil {
+				s.reply(nil, nil, &rpcError{Code: codeParseError, Message: err.Error()})
+				continue
+			}
+			if req.Method == "tools/call" && req.ID != nil {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.handle(ctx, req)
+				}()
+				continue
+			}
+			s.handle(ctx, req)
+		}
+	}
+}
+
+// handle dispatches one message and writes its response, if any.
+func (s *Server) handle(ctx context.Context, req request) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if req.ID != nil {
+			s.reply(req.ID, nil, &rpcError{Code: codeInvalidRequest, Message: "invalid JSON-RPC 2.0 request"})
+		}
+		return
+	}
+
+	// Notifications get no response
+	if req.ID == nil {
+		if req.Method == "notifications/cancelled" {
+			s.cancelRequest(req.Params)
+		}
+		return
+	}
+
+	var result any
+	var rerr *rpcError
+	switch req.Method {
+	case "initialize":
+		result = s.initialize()
+	case "ping":
+		result = struct{}{}
+	case "tools/list":
+		result = map[string]any{"tools": tools}
+	case "tools/call":
+		result, rerr = s.callTool(ctx, req)
+	default:
+		rerr = &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+	s.reply(req.ID, result, rerr)
+}
+
+func (s *Server) initialize() any {
+	return map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities": map[string]any{
+			"tools": map[string]any{},
+		},
+		"serverInfo": map[string]any{
+			"name":    "fence",
+			"version": s.version,
+		},
+		"instructions": "Commands run through fence in a sandbox with network and filesystem restrictions. " +
+			"Use read_policy to see what is allowed and list_violations to see what was blocked.",
+	}
+}
+
+// callTool runs a tools/call request. Tool failures are reported in the
+// result with isError set, as MCP requires; only malformed calls are RPC errors.
+func (s *Server) callTool(ctx context.Context, req request) (any, *rpcError) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	key := string(req.ID)
+	s.mu.Lock()
+	s.inflight[key] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+	}()
+
+	var (
+		result *toolResult
+		err    error
+	)
+	switch params.Name {
+	case "run_command":
+		result, err = s.runCommand(ctx, params.Arguments)
+	case "read_policy":
+		result, err = s.readPolicy()
+	case "list_violations":
+		result, err = s.listViolations()
+	default:
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool: %s", params.Name)}
+	}
+
+	var argErr *argumentError
+	if errors.As(err, &argErr) {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	if err != nil {
+		return errorResult(err.Error()), nil
+	}
+	return result, nil
+}
+
+// cancelRequest cancels an in-flight tool call named by a notifications/cancelled message.
+func (s *Server) cancelRequest(raw json.RawMessage) {
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if json.Unmarshal(raw, &params) != nil {
+		return
+	}
+	s.mu.Lock()
+	cancel := s.inflight[string(params.RequestID)]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result any, rerr *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp := response{JSONRPC: "2.0", ID: id, Result: result, Error: rerr}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.enc.Encode(resp); err != nil && s.debug {
+		logging.Debugf("mcp", "Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// fakeSandbox runs commands unwrapped and refuses anything starting with blocked.
+type fakeSandbox struct {
+	blocked    string
+	violations *policy.ViolationLog
+}
+
+func (f *fakeSandbox) WrapCommand(_ context.Context, command string) (string, error) {
+	if f.blocked != "" && strings.HasPrefix(command, f.blocked) {
+		d := policy.Decision{Rule: policy.RuleRef("command.deny", f.blocked), Reason: "matches a deny rule"}
+		f.violations.Record(policy.Event{Source: policy.SourceCommand, Kind: policy.KindCommand, Target: command, Decision: d})
+		return "", &sandbox.PolicyViolationError{Rule: d.Rule}
+	}
+	return command, nil
+}
+
+func (f *fakeSandbox) Violations() *policy.ViolationLog {
+	return f.violations
+}
+
+func (f *fakeSandbox) Env(environ []string) []string {
+	return environ
+}
+
+type testResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// serve sends the given messages to a new server and returns its responses by id.
+func serve(t *testing.T, messages ...string) map[int]testResponse {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	sb := &fakeSandbox{blocked: "git push", violations: policy.NewViolationLog(false)}
+
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(messages, "\n") + "\n")
+	if err := NewServer(sb, cfg, "test", false).Serve(context.Background(), in, &out); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	responses := make(map[int]testResponse)
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp testResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		responses[resp.ID] = resp
+	}
+	return responses
+}
+
+func callTool(id int, name, args string) string {
+	return `{"jsonrpc":"2.0","id":` + jsonInt(id) + `,"method":"tools/call","params":{"name":"` + name + `","arguments":` + args + `}}`
+}
+
+func jsonInt(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+func TestServerProtocol(t *testing.T) {
+	responses := serve(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"ping"}`,
+		callTool(5, "format_disk", `{}`),
+	)
+
+	if len(responses) != 5 {
+		t.Fatalf("got %d responses, want 5 (notifications get none): %+v", len(responses), responses)
+	}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	if err := json.Unmarshal(responses[1].Result, &init); err != nil {
+		t.Fatal(err)
+	}
+	if init.ProtocolVersion != ProtocolVersion || init.ServerInfo.Name != "fence" {
+		t.Errorf("initialize result = %s", responses[1].Result)
+	}
+
+	var list struct {
+		Tools []tool `json:"tools"`
+	}
+	if err := json.Unmarshal(responses[2].Result, &list); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tl := range list.Tools {
+		names = append(names, tl.Name)
+	}
+	if got := strings.Join(names, ","); got != "run_command,read_policy,list_violations" {
+		t.Errorf("tools/list names = %s", got)
+	}
+
+	if e := responses[3].Error; e == nil || e.Code != codeMethodNotFound {
+		t.Errorf("resources/list error = %+v, want method not found", e)
+	}
+	if responses[4].Error != nil || string(responses[4].Result) != "{}" {
+		t.Errorf("ping = %+v", responses[4])
+	}
+	if e := responses[5].Error; e == nil || e.Code != codeInvalidParams {
+		t.Errorf("unknown tool error = %+v, want invalid params", e)
+	}
+}
+
+func TestServerParseError(t *testing.T) {
+	var out bytes.Buffer
+	sb := &fakeSandbox{violations: policy.NewViolationLog(false)}
+	if err := NewServer(sb, config.Default(), "test", false).Serve(context.Background(), strings.NewReader("{not json\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		ID    any       `json:"id"`
+		Error *rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != nil || resp.Error == nil || resp.Error.Code != codeParseError {
+		t.Errorf("response = %s", out.String())
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       string
+		wantRPCErr bool
+		wantIsErr  bool
+		wantText   string
+	}{
+		{name: "success", args: `{"command":"echo hello"}`, wantText: "hello"},
+		{name: "exit code", args: `{"command":"echo oops >&2; exit 3"}`, wantIsErr: true, wantText: "exit code: 3"},
+		{name: "blocked", args: `{"command":"git push origin main"}`, wantIsErr: true, wantText: "Blocked by fence policy"},
+		{name: "timeout", args: `{"command":"exec sleep 5","timeout_seconds":1}`, wantIsErr: true, wantText: "timed out"},
+		{name: "missing command", args: `{}`, wantRPCErr: true},
+		{name: "bad arguments", args: `{"command":42}`, wantRPCErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := serve(t, callTool(1, "run_command", tt.args))[1]
+			if tt.wantRPCErr {
+				if resp.Error == nil || resp.Error.Code != codeInvalidParams {
+					t.Errorf("error = %+v, want invalid params", resp.Error)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatalf("unexpected error: %+v", resp.Error)
+			}
+			var result toolResult
+			if err := json.Unmarshal(resp.Result, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.IsError != tt.wantIsErr {
+				t.Errorf("isError = %v, want %v", result.IsError, tt.wantIsErr)
+			}
+			if len(result.Content) != 1 || !strings.Contains(result.Content[0].Text, tt.wantText) {
+				t.Errorf("content = %+v, want text containing %q", result.Content, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestReadPolicyAndListViolations(t *testing.T) {
+	responses := serve(t,
+		callTool(1, "read_policy", `{}`),
+		callTool(2, "run_command", `{"command":"git push --force"}`),
+	)
+	var result toolResult
+	if err := json.Unmarshal(responses[1].Result, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Content[0].Text, `"github.com"`) {
+		t.Errorf("read_policy = %s, want the allowed domains", result.Content[0].Text)
+	}
+
+	// Tool calls run concurrently, so list violations in a second session
+	// sharing the sandbox once the blocked command has been recorded
+	sb := &fakeSandbox{blocked: "git push", violations: policy.NewViolationLog(false)}
+	if _, err := sb.WrapCommand(context.Background(), "git push --force"); err == nil {
+		t.Fatal("expected the fake sandbox to block git push")
+	}
+	var out bytes.Buffer
+	in := strings.NewReader(callTool(1, "list_violations", `{}`) + "\n")
+	if err := NewServer(sb, config.Default(), "test", false).Serve(context.Background(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+	var resp testResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		StructuredContent struct {
+			Violations []policy.Violation `json:"violations"`
+		} `json:"structuredContent"`
+	}
+	if err := json.Unmarshal(resp.Result, &list); err != nil {
+		t.Fatal(err)
+	}
+	if v := list.StructuredContent.Violations; len(v) != 1 || v[0].Target != "git push --force" {
+		t.Errorf("list_violations = %s", resp.Result)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := limitedBuffer{limit: 4}
+	_, _ = b.Write([]byte("ab"))
+	_, _ = b.Write([]byte("cdef"))
+	if got := b.String(); !strings.HasPrefix(got, "abcd\n[output truncated") {
+		t.Errorf("String() = %q", got)
+	}
+}
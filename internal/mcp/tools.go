@@ -0,0 +1,215 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// Limits for run_command.
+const (
+	defaultTimeout = 2 * time.Minute
+	maxTimeout     = 10 * time.Minute
+	maxOutputBytes = 100 * 1024 // Per stream; the rest is truncated
+)
+
+// tool describes an MCP tool for tools/list.
+type tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+var tools = []tool{
+	{
+		Name: "run_command",
+		Description: "Run a shell command in the fence sandbox and return its exit code, stdout, and stderr. " +
+			"Network access is limited to allowed domains and writes to allowed paths; blocked commands are refused.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"command": map[string]any{
+					"type":        "string",
+					"description": "Shell command to run, e.g. \"npm test\"",
+				},
+				"cwd": map[string]any{
+					"type":        "string",
+					"description": "Working directory (default: the server's working directory)",
+				},
+				"timeout_seconds": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("Kill the command after this many seconds (default %d, max %d)", int(defaultTimeout.Seconds()), int(maxTimeout.Seconds())),
+				},
+			},
+			"required": []string{"command"},
+		},
+	},
+	{
+		Name:        "read_policy",
+		Description: "Return the effective fence config (allowed domains, filesystem rules, denied commands) as JSON.",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+	},
+	{
+		Name:        "list_violations",
+		Description: "List the operations fence has blocked since the server started, with the rule responsible and a count.",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+	},
+}
+
+// toolResult is the result of a tools/call request.
+type toolResult struct {
+	Content           []content `json:"content"`
+	StructuredContent any       `json:"structuredContent,omitempty"`
+	IsError           bool      `json:"isError,omitempty"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func textResult(text string) *toolResult {
+	return &toolResult{Content: []content{{Type: "text", Text: text}}}
+}
+
+func errorResult(text string) *toolResult {
+	r := textResult(text)
+	r.IsError = true
+	return r
+}
+
+// argumentError reports malformed tool arguments, returned to the client as
+// an invalid-params error rather than a failed tool call.
+type argumentError struct {
+	msg string
+}
+
+func (e *argumentError) Error() string { return e.msg }
+
+// commandOutput is the structured result of run_command.
+type commandOutput struct {
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	TimedOut bool   `json:"timedOut,omitempty"`
+}
+
+func (s *Server) runCommand(ctx context.Context, raw json.RawMessage) (*toolResult, error) {
+	var args struct {
+		Command        string `json:"command"`
+		Cwd            string `json:"cwd"`
+		TimeoutSeconds int    `json:"timeout_seconds"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, &argumentError{msg: fmt.Sprintf("invalid arguments: %v", err)}
+	}
+	if strings.TrimSpace(args.Command) == "" {
+		return nil, &argumentError{msg: "command is required"}
+	}
+
+	timeout := defaultTimeout
+	if args.TimeoutSeconds > 0 {
+		timeout = min(time.Duration(args.TimeoutSeconds)*time.Second, maxTimeout)
+	}
+
+	wrapped, err := s.sandbox.WrapCommand(ctx, args.Command)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrPolicyViolation) {
+			return errorResult(fmt.Sprintf("Blocked by fence policy: %v", err)), nil
+		}
+		return nil, fmt.Errorf("failed to sandbox command: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr limitedBuffer
+	stdout.limit, stderr.limit = maxOutputBytes, maxOutputBytes
+	cmd := exec.CommandContext(ctx, "sh", "-c", wrapped) //nolint:gosec // wrapped is the sandboxed command
+	cmd.Env = s.sandbox.Env(os.Environ())
+	cmd.Dir = args.Cwd
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	out := commandOutput{}
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to run command: %w", err)
+		}
+		out.ExitCode = exitErr.ExitCode()
+	}
+	out.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	out.Stdout = stdout.String()
+	out.Stderr = stderr.String()
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "exit code: %d\n", out.ExitCode)
+	if out.TimedOut {
+		fmt.Fprintf(&text, "timed out after %s\n", timeout)
+	}
+	if out.Stdout != "" {
+		fmt.Fprintf(&text, "\nstdout:\n%s", out.Stdout)
+	}
+	if out.Stderr != "" {
+		fmt.Fprintf(&text, "\nstderr:\n%s", out.Stderr)
+	}
+
+	result := textResult(text.String())
+	result.StructuredContent = out
+	result.IsError = out.ExitCode != 0 || out.TimedOut
+	return result, nil
+}
+
+func (s *Server) readPolicy() (*toolResult, error) {
+	data, err := json.MarshalIndent(s.config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return textResult(string(data)), nil
+}
+
+func (s *Server) listViolations() (*toolResult, error) {
+	violations := s.sandbox.Violations()
+	var buf bytes.Buffer
+	if err := violations.WriteJSON(&buf); err != nil {
+		return nil, err
+	}
+	result := textResult(buf.String())
+	result.StructuredContent = map[string]any{"violations": violations.Violations()}
+	return result, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and notes truncation.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + fmt.Sprintf("\n[output truncated at %d bytes]\n", b.limit)
+	}
+	return b.buf.String()
+}
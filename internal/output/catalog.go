@@ -0,0 +1,31 @@
+package output
+
+import (
+	"embed"
+	"encoding/json"
+)
+
+// The catalogs in locales/ are named by language code. Each maps an English
+// message, as passed to Sprintf, to its translation; a translation can
+// reorder the arguments with explicit indexes such as %[2]s. Messages
+// missing from a catalog stay in English.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalogFor returns the catalog for the language code lang, or nil if
+// there is none.
+func catalogFor(lang string) map[string]string {
+	if lang == "" || lang == "en" {
+		return nil
+	}
+	data, err := locales.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		return nil
+	}
+	var catalog map[string]string
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil
+	}
+	return catalog
+}
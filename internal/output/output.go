@@ -0,0 +1,187 @@
+// Package output renders the status markers and messages fence writes for
+// people: colored unless NO_COLOR is set or stderr is not a terminal, with
+// ASCII in place of Unicode icons when the locale cannot show them, and
+// translated where a message catalog has the message.
+package output
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Color modes for SetColorMode.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// Icon is a status marker.
+type Icon int
+
+// Icons.
+const (
+	Allowed     Icon = iota // ✓
+	Blocked                 // ✗
+	Warning                 // ⚠
+	Failed                  // !
+	Unavailable             // ○
+)
+
+// icons are each Icon's Unicode and ASCII forms and color.
+var icons = map[Icon]struct{ unicode, ascii, color string }{
+	Allowed:     {"✓", "+", "32"}, // Green
+	Blocked:     {"✗", "x", "31"}, // Red
+	Warning:     {"⚠", "!", "33"}, // Yellow
+	Failed:      {"!", "!", "31"},
+	Unavailable: {"○", "-", "90"}, // Gray
+}
+
+// Style is how output is rendered.
+type Style struct {
+	Color   bool
+	Unicode bool
+	// Catalog maps English messages to their translations; nil keeps English.
+	Catalog map[string]string
+}
+
+var current atomic.Pointer[Style]
+
+func init() {
+	current.Store(Detect(os.Getenv, isTerminal(os.Stderr)))
+}
+
+// Current returns the style in use.
+func Current() Style {
+	return *current.Load()
+}
+
+// SetStyle replaces the style in use.
+func SetStyle(s Style) {
+	current.Store(&s)
+}
+
+// SetColorMode overrides the color detection: ColorAlways, ColorNever, or
+// ColorAuto, which colors output if out is a terminal, as Detect describes.
+func SetColorMode(mode string, out *os.File) error {
+	s := Current()
+	switch mode {
+	case ColorAuto:
+		s.Color = detectColor(os.Getenv, isTerminal(out))
+	case ColorAlways:
+		s.Color = true
+	case ColorNever:
+		s.Color = false
+	default:
+		return fmt.Errorf("invalid color mode %q (valid: %s, %s, %s)", mode, ColorAuto, ColorAlways, ColorNever)
+	}
+	SetStyle(s)
+	return nil
+}
+
+// Detect returns the style for an environment, where terminal says whether
+// the output goes to a terminal:
+//
+//   - Color: FORCE_COLOR or CLICOLOR_FORCE (other than "0") turns it on,
+//     NO_COLOR or TERM=dumb off; otherwise it is on for a terminal.
+//   - Icons: FENCE_ASCII (other than "0") selects ASCII. Otherwise the first
+//     of LC_ALL, LC_CTYPE, and LANG that is set decides: Unicode if its
+//     charset is UTF-8, ASCII otherwise (such as in the C locale). With none
+//     set, Unicode.
+//   - Messages: the catalog for FENCE_LANG, or else the first of LC_ALL,
+//     LC_MESSAGES, and LANG that is set, if fence has one.
+func Detect(getenv func(string) string, terminal bool) *Style {
+	return &Style{
+		Color:   detectColor(getenv, terminal),
+		Unicode: detectUnicode(getenv),
+		Catalog: catalogFor(detectLanguage(getenv)),
+	}
+}
+
+func detectColor(getenv func(string) string, terminal bool) bool {
+	for _, name := range []string{"FORCE_COLOR", "CLICOLOR_FORCE"} {
+		if v := getenv(name); v != "" && v != "0" {
+			return true
+		}
+	}
+	if getenv("NO_COLOR") != "" || getenv("TERM") == "dumb" {
+		return false
+	}
+	return terminal
+}
+
+func detectUnicode(getenv func(string) string) bool {
+	if v := getenv("FENCE_ASCII"); v != "" && v != "0" {
+		return false
+	}
+	locale := firstSet(getenv, "LC_ALL", "LC_CTYPE", "LANG")
+	if locale == "" {
+		return true
+	}
+	_, charset, _ := strings.Cut(locale, ".")
+	charset, _, _ = strings.Cut(charset, "@")
+	charset = strings.ToLower(strings.ReplaceAll(charset, "-", ""))
+	return charset == "utf8"
+}
+
+// detectLanguage returns the language code of the messages locale, such as
+// "de" for de_DE.UTF-8.
+func detectLanguage(getenv func(string) string) string {
+	locale := getenv("FENCE_LANG")
+	if locale == "" {
+		locale = firstSet(getenv, "LC_ALL", "LC_MESSAGES", "LANG")
+	}
+	lang, _, _ := strings.Cut(locale, "_")
+	lang, _, _ = strings.Cut(lang, ".")
+	return strings.ToLower(lang)
+}
+
+func firstSet(getenv func(string) string, names ...string) string {
+	for _, name := range names {
+		if v := getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Render returns icon in style s.
+func (s Style) Render(icon Icon) string {
+	i := icons[icon]
+	text := i.ascii
+	if s.Unicode {
+		text = i.unicode
+	}
+	if s.Color {
+		return "\x1b[" + i.color + "m" + text + "\x1b[0m"
+	}
+	return text
+}
+
+// Sprintf formats the translation of format in s's catalog, or format
+// itself if there is none.
+func (s Style) Sprintf(format string, args ...any) string {
+	if t, ok := s.Catalog[format]; ok {
+		format = t
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Render returns icon in the current style.
+func Render(icon Icon) string {
+	return Current().Render(icon)
+}
+
+// Sprintf formats a message in the current style's language. format is the
+// English message, which is also the key in the catalogs.
+func Sprintf(format string, args ...any) string {
+	return Current().Sprintf(format, args...)
+}
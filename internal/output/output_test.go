@@ -0,0 +1,119 @@
+package output
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func envFunc(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		terminal bool
+		color    bool
+		unicode  bool
+		lang     string // Expected catalog, "" for English
+	}{
+		{"terminal", map[string]string{"LANG": "en_US.UTF-8"}, true, true, true, ""},
+		{"pipe", map[string]string{"LANG": "en_US.UTF-8"}, false, false, true, ""},
+		{"no locale", nil, false, false, true, ""},
+		{"NO_COLOR", map[string]string{"NO_COLOR": "1"}, true, false, true, ""},
+		{"dumb terminal", map[string]string{"TERM": "dumb"}, true, false, true, ""},
+		{"FORCE_COLOR", map[string]string{"FORCE_COLOR": "1", "NO_COLOR": "1"}, false, true, true, ""},
+		{"FORCE_COLOR=0", map[string]string{"FORCE_COLOR": "0"}, false, false, true, ""},
+		{"CLICOLOR_FORCE", map[string]string{"CLICOLOR_FORCE": "1"}, false, true, true, ""},
+		{"C locale", map[string]string{"LANG": "C"}, false, false, false, ""},
+		{"latin1", map[string]string{"LANG": "de_DE.ISO-8859-1"}, false, false, false, "de"},
+		{"utf8 spelling", map[string]string{"LANG": "es_ES.utf8@euro"}, false, false, true, "es"},
+		{"LC_ALL wins", map[string]string{"LC_ALL": "C", "LANG": "de_DE.UTF-8"}, false, false, false, ""},
+		{"LC_MESSAGES", map[string]string{"LC_MESSAGES": "de_AT.UTF-8", "LANG": "en_US.UTF-8"}, false, false, true, "de"},
+		{"FENCE_LANG", map[string]string{"FENCE_LANG": "es", "LANG": "de_DE.UTF-8"}, false, false, true, "es"},
+		{"FENCE_ASCII", map[string]string{"FENCE_ASCII": "1", "LANG": "en_US.UTF-8"}, false, false, false, ""},
+		{"no catalog", map[string]string{"LANG": "fr_FR.UTF-8"}, false, false, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Detect(envFunc(tt.env), tt.terminal)
+			if s.Color != tt.color || s.Unicode != tt.unicode {
+				t.Errorf("Detect() color, unicode = %v, %v; want %v, %v", s.Color, s.Unicode, tt.color, tt.unicode)
+			}
+			if got := s.Sprintf("BLOCKED"); (got != "BLOCKED") != (tt.lang != "") {
+				t.Errorf("Detect() translates BLOCKED to %q, want catalog %q", got, tt.lang)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		style Style
+		icon  Icon
+		want  string
+	}{
+		{Style{Unicode: true}, Allowed, "✓"},
+		{Style{Unicode: true}, Blocked, "✗"},
+		{Style{}, Allowed, "+"},
+		{Style{}, Blocked, "x"},
+		{Style{}, Warning, "!"},
+		{Style{}, Unavailable, "-"},
+		{Style{Unicode: true, Color: true}, Blocked, "\x1b[31m✗\x1b[0m"},
+		{Style{Color: true}, Allowed, "\x1b[32m+\x1b[0m"},
+	}
+	for _, tt := range tests {
+		if got := tt.style.Render(tt.icon); got != tt.want {
+			t.Errorf("%+v.Render(%d) = %q, want %q", tt.style, tt.icon, got, tt.want)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	s := Style{Catalog: catalogFor("de")}
+	if got, want := s.Sprintf("Repeated %d times in the last %s: %s", 3, "10s", "line"), "In den letzten 10s 3-mal wiederholt: line"; got != want {
+		t.Errorf("Sprintf() = %q, want %q", got, want)
+	}
+	if got, want := s.Sprintf("not in the catalog: %d", 1), "not in the catalog: 1"; got != want {
+		t.Errorf("Sprintf() = %q, want %q", got, want)
+	}
+	if got, want := (Style{}).Sprintf("%d operation(s) blocked:", 2), "2 operation(s) blocked:"; got != want {
+		t.Errorf("Sprintf() = %q, want %q", got, want)
+	}
+}
+
+var verbPattern = regexp.MustCompile(`%[ds]`)
+
+// Every translation must take the English message's arguments.
+func TestCatalogs(t *testing.T) {
+	files, err := fs.Glob(locales, "locales/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no catalogs: %v", err)
+	}
+	for _, file := range files {
+		lang := strings.TrimSuffix(strings.TrimPrefix(file, "locales/"), ".json")
+		catalog := catalogFor(lang)
+		if len(catalog) == 0 {
+			t.Errorf("%s: empty or invalid catalog", file)
+			continue
+		}
+		for msg, translation := range catalog {
+			var args []any
+			for _, verb := range verbPattern.FindAllString(msg, -1) {
+				if verb == "%d" {
+					args = append(args, len(args))
+				} else {
+					args = append(args, fmt.Sprintf("arg%d", len(args)))
+				}
+			}
+			if got := fmt.Sprintf(translation, args...); strings.Contains(got, "%!") {
+				t.Errorf("%s: %q translates to %q, which does not take its arguments: %q", file, msg, translation, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// ExecutableContentTypes are the content types network.downloads.flagExecutables
+// flags: native executables and libraries, scripts, and installable packages.
+var ExecutableContentTypes = []string{
+	"application/x-executable",
+	"application/x-elf",
+	"application/x-sharedlib",
+	"application/x-mach-binary",
+	"application/x-msdownload",
+	"application/x-msdos-program",
+	"application/x-dosexec",
+	"application/vnd.microsoft.portable-executable",
+	"application/x-msi",
+	"application/x-sh",
+	"application/x-shellscript",
+	"text/x-shellscript",
+	"text/x-sh",
+	"application/x-python-code",
+	"application/java-archive",
+	"application/x-apple-diskimage",
+	"application/vnd.debian.binary-package",
+	"application/x-debian-package",
+	"application/x-rpm",
+	"application/vnd.android.package-archive",
+}
+
+// FlagDownload returns why a response with the given Content-Type header and
+// size in bytes is flagged by network.downloads, or "" if it is not. A
+// negative size means the length is not known yet and is not checked.
+func FlagDownload(cfg *config.Config, contentType string, size int64) string {
+	if cfg == nil {
+		return ""
+	}
+	dl := cfg.Network.Downloads
+
+	mediaType := ""
+	if contentType != "" {
+		if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = mt
+		}
+	}
+	if mediaType != "" {
+		if dl.FlagExecutables && slices.Contains(ExecutableContentTypes, mediaType) {
+			return "executable content type " + mediaType
+		}
+		for _, pattern := range dl.ContentTypes {
+			if matchContentType(mediaType, pattern) {
+				return "content type " + mediaType + " matches " + pattern
+			}
+		}
+	}
+	if dl.MaxSize > 0 && size > dl.MaxSize {
+		return fmt.Sprintf("size of %d bytes exceeds maxSize %d", size, dl.MaxSize)
+	}
+	return ""
+}
+
+// matchContentType reports whether mediaType matches pattern, which is a
+// media type or one with a wildcard subtype such as "application/*".
+func matchContentType(mediaType, pattern string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		typ, _, _ := strings.Cut(mediaType, "/")
+		return typ == prefix
+	}
+	return mediaType == pattern
+}
+
+// Download is a response flagged by network.downloads.
+type Download struct {
+	Time        time.Time `json:"time"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType,omitempty"`
+	Size        int64     `json:"size"`   // Bytes forwarded to the sandbox
+	Reason      string    `json:"reason"` // Why it was flagged
+	// Complete is false if the transfer was cut short; the size and any
+	// quarantined copy are then partial.
+	Complete bool `json:"complete"`
+	// QuarantinePath is the copy in network.downloads.quarantineDir, if any.
+	QuarantinePath string `json:"quarantinePath,omitempty"`
+}
+
+// DownloadLog collects the downloads flagged during a run. It is safe for
+// concurrent use.
+type DownloadLog struct {
+	mu        sync.Mutex
+	downloads []Download
+}
+
+// NewDownloadLog returns an empty download log.
+func NewDownloadLog() *DownloadLog {
+	return &DownloadLog{}
+}
+
+// Record notes a flagged download. d.Time defaults to now.
+func (l *DownloadLog) Record(d Download) {
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.downloads = append(l.downloads, d)
+}
+
+// Downloads returns the flagged downloads in the order they finished.
+func (l *DownloadLog) Downloads() []Download {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.downloads)
+}
+
+// WriteReport writes a human-readable summary of the flagged downloads.
+// Nothing is written if there are none.
+func (l *DownloadLog) WriteReport(w io.Writer) error {
+	downloads := l.Downloads()
+	if len(downloads) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(w, "[fence] %d download(s) flagged:\n", len(downloads)); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, d := range downloads {
+		status := d.QuarantinePath
+		if !d.Complete {
+			status = strings.TrimSpace("(incomplete) " + status)
+		}
+		fmt.Fprintf(tw, "  %s\t%d bytes\t%s\t%s\n", d.URL, d.Size, d.Reason, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestFlagDownload(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			Downloads: config.DownloadsConfig{
+				MaxSize:         1000,
+				FlagExecutables: true,
+				ContentTypes:    []string{"application/zip", "image/*"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		size        int64
+		want        string
+	}{
+		{"small text", "text/plain", 10, ""},
+		{"executable", "application/x-executable", 10, "executable content type application/x-executable"},
+		{"shell script with parameters", "text/x-shellscript; charset=utf-8", 10, "executable content type text/x-shellscript"},
+		{"listed type", "application/zip", 10, "content type application/zip matches application/zip"},
+		{"wildcard subtype", "image/png", 10, "content type image/png matches image/*"},
+		{"over maxSize", "text/plain", 2000, "size of 2000 bytes exceeds maxSize 1000"},
+		{"unknown size", "", -1, ""},
+		{"malformed content type", "text/plain;;;", 10, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FlagDownload(cfg, tt.contentType, tt.size); got != tt.want {
+				t.Errorf("FlagDownload(%q, %d) = %q, want %q", tt.contentType, tt.size, got, tt.want)
+			}
+		})
+	}
+
+	if got := FlagDownload(nil, "application/x-executable", 1<<30); got != "" {
+		t.Errorf("FlagDownload(nil config) = %q, want \"\"", got)
+	}
+}
+
+func TestDownloadLogReport(t *testing.T) {
+	log := NewDownloadLog()
+
+	var buf bytes.Buffer
+	if err := log.WriteReport(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("empty log wrote %q, %v", buf.String(), err)
+	}
+
+	log.Record(Download{URL: "http://example.com/x.sh", Size: 12, Reason: "executable content type application/x-sh", Complete: true, QuarantinePath: "/q/001-x.sh"})
+	log.Record(Download{URL: "http://example.com/big", Size: 5000, Reason: "size of 5000 bytes exceeds maxSize 1000"})
+	if err := log.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"2 download(s) flagged", "/q/001-x.sh", "(incomplete)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+}
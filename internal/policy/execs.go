@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Sources that trace the processes a sandboxed command executes.
+const (
+	SourceESLogger = "eslogger" // macOS Endpoint Security events
+	SourceDTrace   = "dtrace"   // macOS DTrace, when eslogger is unavailable
+)
+
+// MaxExecs is how many executions an ExecLog keeps; later ones are still
+// delivered to subscribers.
+const MaxExecs = 10000
+
+// Exec is a process the sandboxed command, or one of its descendants,
+// executed.
+type Exec struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"` // SourceEBPF, SourceESLogger, or SourceDTrace
+	PID    int       `json:"pid"`
+	PPID   int       `json:"ppid"`
+	// Path is the executable, if the tracer reports it.
+	Path string `json:"path,omitempty"`
+	// Argv are the arguments, with secrets redacted. With dtrace they are
+	// the process's argument string split at spaces, and may be cut short.
+	Argv []string `json:"argv"`
+}
+
+// ExecLog collects the processes executed during a run, in order, so what
+// the command ran can be reconstructed. It is safe for concurrent use.
+type ExecLog struct {
+	mu          sync.Mutex
+	execs       []Exec
+	dropped     int
+	subscribers map[int]func(Exec)
+	nextID      int
+}
+
+// NewExecLog returns an empty exec log.
+func NewExecLog() *ExecLog {
+	return &ExecLog{subscribers: make(map[int]func(Exec))}
+}
+
+// Subscribe registers fn to be called with every recorded execution. fn
+// runs synchronously on the tracer's goroutine, so it must be quick. The
+// returned function removes the subscription.
+func (l *ExecLog) Subscribe(fn func(Exec)) (unsubscribe func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.nextID
+	l.nextID++
+	l.subscribers[id] = fn
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subscribers, id)
+	}
+}
+
+// StreamNDJSON writes every execution recorded from now on to w as one line
+// of JSON. The returned function stops the stream.
+func (l *ExecLog) StreamNDJSON(w io.Writer) (stop func()) {
+	var mu sync.Mutex // Keeps lines from concurrent executions whole
+	return l.Subscribe(func(e Exec) {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(append(line, '\n'))
+	})
+}
+
+// Record notes an execution and notifies subscribers. e.Time defaults to
+// now, and secrets in e.Argv are redacted.
+func (l *ExecLog) Record(e Exec) {
+	e.Argv = RedactArgv(e.Argv)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	if len(l.execs) < MaxExecs {
+		l.execs = append(l.execs, e)
+	} else {
+		l.dropped++
+	}
+	subscribers := make([]func(Exec), 0, len(l.subscribers))
+	for _, fn := range l.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	l.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// Execs returns the recorded executions in the order they happened, and
+// how many more there were beyond MaxExecs.
+func (l *ExecLog) Execs() (execs []Exec, dropped int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.execs), l.dropped
+}
+
+// RedactArgv redacts secrets in each of a command's arguments.
+func RedactArgv(argv []string) []string {
+	out := make([]string, len(argv))
+	for i, arg := range argv {
+		out[i] = Redact(arg)
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package policy
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// RequiresHTTPRules reports whether any network.httpRules entry, or with
+// network.blockPublishing any of config.PublishingRules, matches host.
+func RequiresHTTPRules(cfg *config.Config, host string) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, rule := range cfg.Network.HTTPRules {
+		if config.MatchesDomain(host, rule.Domain) {
+			return true
+		}
+	}
+	return publishingRule(cfg, host) != nil
+}
+
+// publishingRule returns the config.PublishingRules entry matching host if
+// network.blockPublishing applies it, or nil.
+func publishingRule(cfg *config.Config, host string) *config.HTTPRule {
+	if !cfg.Network.BlocksPublishing() {
+		return nil
+	}
+	for i, rule := range config.PublishingRules {
+		if config.MatchesDomain(host, rule.Domain) {
+			return &config.PublishingRules[i]
+		}
+	}
+	return nil
+}
+
+// RestrictsUploads reports whether network.uploads restricts uploads to host.
+func RestrictsUploads(cfg *config.Config, host string) bool {
+	if cfg == nil || !cfg.Network.Uploads.Restrict {
+		return false
+	}
+	for _, domain := range cfg.Network.Uploads.AllowUpload {
+		if config.MatchesDomain(host, domain) {
+			return false
+		}
+	}
+	return true
+}
+
+// EvaluateHTTPRequest decides whether a request to host with the given
+// method, URL path, and body size meets every network.httpRules entry
+// matching host, network.uploads, and network.blockPublishing. A request
+// that breaks only rules whose action is warn is allowed with a warning. A negative size means the body length
+// is not known yet and is not checked. Hosts without rules are allowed.
+func EvaluateHTTPRequest(cfg *config.Config, host, method, path string, size int64) Decision {
+	decision := Allow("", "no network.httpRules entry matches")
+	if cfg == nil {
+		return decision
+	}
+
+	var warned *Decision
+	for _, rule := range cfg.Network.HTTPRules {
+		if !config.MatchesDomain(host, rule.Domain) {
+			continue
+		}
+		ref := RuleRef("network.httpRules", rule.Domain)
+
+		if reason := breaksHTTPRule(rule, method, path, size); reason != "" {
+			if rule.Action != config.ActionWarn {
+				return Deny(ref, reason)
+			}
+			if warned == nil {
+				d := Warn(ref, reason)
+				warned = &d
+			}
+			continue
+		}
+		decision = Allow(ref, "request meets the rule")
+	}
+
+	if rule := publishingRule(cfg, host); rule != nil {
+		if _, ok := matchRequest(rule.Deny, method, path); ok {
+			return Deny(RuleRef("network.blockPublishing", rule.Domain), fmt.Sprintf("%s %s publishes to a package registry; allow it with network.blockPublishing: false", method, path))
+		}
+	}
+
+	if slices.Contains(config.UploadMethods, method) && RestrictsUploads(cfg, host) {
+		limit := cfg.Network.Uploads.MaxSize
+		if limit == 0 {
+			return Deny("network.uploads", fmt.Sprintf("uploads to %s are blocked; allow them with network.uploads.allowUpload", host))
+		}
+		if size > limit {
+			return Deny("network.uploads", fmt.Sprintf("upload of %d bytes to %s exceeds maxSize %d; allow it with network.uploads.allowUpload", size, host, limit))
+		}
+	}
+	if warned != nil {
+		return *warned
+	}
+	return decision
+}
+
+// breaksHTTPRule returns why a request breaks rule, or "" if it does not.
+func breaksHTTPRule(rule config.HTTPRule, method, path string, size int64) string {
+	if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, method) {
+		return fmt.Sprintf("method %s is not in methods %v", method, rule.Methods)
+	}
+	if pattern, ok := matchRequest(rule.Deny, method, path); ok {
+		return fmt.Sprintf("%s %s matches deny pattern %q", method, path, pattern)
+	}
+	if _, ok := matchRequest(rule.Allow, method, path); len(rule.Allow) > 0 && !ok {
+		return fmt.Sprintf("%s %s matches no allow pattern", method, path)
+	}
+	if rule.MaxBodyBytes > 0 && size > rule.MaxBodyBytes {
+		return fmt.Sprintf("request body of %d bytes exceeds maxBodyBytes %d", size, rule.MaxBodyBytes)
+	}
+	return ""
+}
+
+// EvaluateGitPush decides whether a request for the URL path and raw query
+// is allowed under network.allowGitPush. Unless it is set, pushes over git's
+// smart HTTP protocol are denied: both the ref advertisement for
+// git-receive-pack and the push itself.
+func EvaluateGitPush(cfg *config.Config, path, rawQuery string) Decision {
+	if !IsGitPush(path, rawQuery) {
+		return Allow("", "not a git push")
+	}
+	if cfg != nil && cfg.Network.AllowGitPush {
+		return Allow("network.allowGitPush", "git pushes are allowed")
+	}
+	return Deny("network.allowGitPush", "git pushes are blocked; allow them with network.allowGitPush")
+}
+
+// IsGitPush reports whether a request for the URL path and raw query is part
+// of a git push over smart HTTP.
+func IsGitPush(path, rawQuery string) bool {
+	if strings.HasSuffix(path, "/git-receive-pack") {
+		return true
+	}
+	if !strings.HasSuffix(path, "/info/refs") {
+		return false
+	}
+	query, err := url.ParseQuery(rawQuery)
+	return err == nil && query.Get("service") == "git-receive-pack"
+}
+
+// matchRequest returns the first of patterns that a request with method and
+// path matches.
+func matchRequest(patterns []string, method, path string) (string, bool) {
+	for _, pattern := range patterns {
+		m, p := config.SplitRequestPattern(pattern)
+		if (m == "" || m == method) && matchPath(p, path) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// matchPath reports whether path matches pattern, in which * matches within
+// one segment and ** across segments.
+func matchPath(pattern, path string) bool {
+	ok, err := doublestar.Match(pattern, path)
+	return err == nil && ok
+}
+
+// MaxBodyBytes returns the smallest body size limit for a request to host
+// with the given method: the maxBodyBytes of the network.httpRules entries
+// matching host, or network.uploads.maxSize if it restricts the request. It
+// returns 0 if nothing limits the body size.
+func MaxBodyBytes(cfg *config.Config, host, method string) int64 {
+	var limit int64
+	if cfg == nil {
+		return 0
+	}
+	for _, rule := range cfg.Network.HTTPRules {
+		if rule.MaxBodyBytes > 0 && config.MatchesDomain(host, rule.Domain) && (limit == 0 || rule.MaxBodyBytes < limit) {
+			limit = rule.MaxBodyBytes
+		}
+	}
+	if upload := cfg.Network.Uploads.MaxSize; upload > 0 && slices.Contains(config.UploadMethods, method) && RestrictsUploads(cfg, host) && (limit == 0 || upload < limit) {
+		limit = upload
+	}
+	return limit
+}
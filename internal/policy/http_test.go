@@ -0,0 +1,214 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestEvaluateHTTPRequest(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			HTTPRules: []config.HTTPRule{
+				{Domain: "*.internal", MaxBodyBytes: 1024},
+				{Domain: "api.internal", Methods: []string{"GET", "HEAD"}},
+				{Domain: "api.github.com", Allow: []string{"GET /repos/**", "/user"}, Deny: []string{"POST /user/keys", "/repos/*/*/hooks"}},
+				{Domain: "uploads.github.com", Deny: []string{"POST /**"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		method      string
+		path        string
+		size        int64
+		wantAllowed bool
+		wantRule    string
+	}{
+		{"no rule", "example.com", "DELETE", "/", 1 << 30, true, ""},
+		{"allowed method", "api.internal", "GET", "/", 0, true, `network.httpRules "api.internal"`},
+		{"denied method", "api.internal", "POST", "/", 0, false, `network.httpRules "api.internal"`},
+		{"body within limit", "db.internal", "POST", "/", 1024, true, `network.httpRules "*.internal"`},
+		{"body over limit", "db.internal", "POST", "/", 1025, false, `network.httpRules "*.internal"`},
+		{"unknown size", "db.internal", "POST", "/", -1, true, `network.httpRules "*.internal"`},
+		{"all matching rules apply", "api.internal", "GET", "/", 4096, false, `network.httpRules "*.internal"`},
+		{"allowed path", "api.github.com", "GET", "/repos/o/r/pulls", 0, true, `network.httpRules "api.github.com"`},
+		{"allowed path for another method", "api.github.com", "POST", "/repos/o/r/issues", 0, false, `network.httpRules "api.github.com"`},
+		{"allowed path for any method", "api.github.com", "PATCH", "/user", 0, true, `network.httpRules "api.github.com"`},
+		{"path matching no allow pattern", "api.github.com", "GET", "/orgs/o", 0, false, `network.httpRules "api.github.com"`},
+		{"denied path", "api.github.com", "POST", "/user/keys", 0, false, `network.httpRules "api.github.com"`},
+		{"deny wins over allow", "api.github.com", "GET", "/repos/o/r/hooks", 0, false, `network.httpRules "api.github.com"`},
+		{"single segment wildcard", "api.github.com", "GET", "/repos/o/r/x/hooks", 0, true, `network.httpRules "api.github.com"`},
+		{"only denied requests", "uploads.github.com", "PUT", "/a/b", 0, true, `network.httpRules "uploads.github.com"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateHTTPRequest(cfg, tt.host, tt.method, tt.path, tt.size)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateHTTPRequest(%q, %s %s, %d).Allowed = %v, want %v (%s)", tt.host, tt.method, tt.path, tt.size, d.Allowed, tt.wantAllowed, d.Reason)
+			}
+			if d.Rule != tt.wantRule {
+				t.Errorf("EvaluateHTTPRequest(%q, %s %s, %d).Rule = %q, want %q", tt.host, tt.method, tt.path, tt.size, d.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestEvaluateHTTPRequestWarn(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			HTTPRules: []config.HTTPRule{
+				{Domain: "api.internal", Methods: []string{"GET"}, Action: config.ActionWarn},
+				{Domain: "*.internal", Deny: []string{"DELETE /**"}},
+			},
+		},
+	}
+
+	d := EvaluateHTTPRequest(cfg, "api.internal", "POST", "/", 0)
+	if !d.Allowed || !d.Warn || d.Rule != `network.httpRules "api.internal"` {
+		t.Errorf("POST = %+v, want allowed with a warning", d)
+	}
+	if d := EvaluateHTTPRequest(cfg, "api.internal", "GET", "/", 0); !d.Allowed || d.Warn {
+		t.Errorf("GET = %+v, want allowed", d)
+	}
+	// A rule that denies still does, whatever a warn rule says
+	if d := EvaluateHTTPRequest(cfg, "api.internal", "DELETE", "/x", 0); d.Allowed || d.Rule != `network.httpRules "*.internal"` {
+		t.Errorf("DELETE = %+v, want denied by *.internal", d)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			HTTPRules: []config.HTTPRule{
+				{Domain: "*.internal", MaxBodyBytes: 4096},
+				{Domain: "api.internal", MaxBodyBytes: 1024},
+				{Domain: "api.internal", Methods: []string{"GET"}},
+			},
+			Uploads: config.UploadsConfig{Restrict: true, MaxSize: 2048, AllowUpload: []string{"api.internal"}},
+		},
+	}
+
+	tests := []struct {
+		host   string
+		method string
+		want   int64
+	}{
+		{"api.internal", "POST", 1024},
+		{"db.internal", "GET", 4096},
+		{"db.internal", "PUT", 2048},
+		{"example.com", "GET", 0},
+		{"example.com", "PATCH", 2048},
+	}
+	for _, tt := range tests {
+		if got := MaxBodyBytes(cfg, tt.host, tt.method); got != tt.want {
+			t.Errorf("MaxBodyBytes(%s, %s) = %d, want %d", tt.host, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateHTTPRequestUploads(t *testing.T) {
+	blocked := &config.Config{
+		Network: config.NetworkConfig{
+			Uploads: config.UploadsConfig{Restrict: true, AllowUpload: []string{"*.github.com"}},
+		},
+	}
+	capped := &config.Config{
+		Network: config.NetworkConfig{
+			Uploads: config.UploadsConfig{Restrict: true, MaxSize: 1024},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		cfg         *config.Config
+		host        string
+		method      string
+		size        int64
+		wantAllowed bool
+	}{
+		{"download", blocked, "example.com", "GET", 0, true},
+		{"blocked upload", blocked, "example.com", "POST", 0, false},
+		{"blocked upload of unknown size", blocked, "example.com", "PUT", -1, false},
+		{"allowed upload domain", blocked, "api.github.com", "PATCH", 1 << 20, true},
+		{"upload within limit", capped, "example.com", "POST", 1024, true},
+		{"upload over limit", capped, "example.com", "POST", 1025, false},
+		{"upload of unknown size", capped, "example.com", "POST", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateHTTPRequest(tt.cfg, tt.host, tt.method, "/", tt.size)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateHTTPRequest(%q, %s, %d).Allowed = %v, want %v (%s)", tt.host, tt.method, tt.size, d.Allowed, tt.wantAllowed, d.Reason)
+			}
+			if !d.Allowed && d.Rule != "network.uploads" {
+				t.Errorf("EvaluateHTTPRequest(%q, %s, %d).Rule = %q, want network.uploads", tt.host, tt.method, tt.size, d.Rule)
+			}
+		})
+	}
+}
+
+func TestEvaluateGitPush(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		query       string
+		allowPush   bool
+		wantAllowed bool
+	}{
+		{"fetch ref advertisement", "/o/r.git/info/refs", "service=git-upload-pack", false, true},
+		{"fetch", "/o/r.git/git-upload-pack", "", false, true},
+		{"push ref advertisement", "/o/r.git/info/refs", "service=git-receive-pack", false, false},
+		{"push", "/o/r/git-receive-pack", "", false, false},
+		{"allowed push", "/o/r.git/git-receive-pack", "", true, true},
+		{"not git", "/repos/o/r", "service=git-receive-pack", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Network: config.NetworkConfig{AllowGitPush: tt.allowPush}}
+			d := EvaluateGitPush(cfg, tt.path, tt.query)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateGitPush(%q, %q).Allowed = %v, want %v (%s)", tt.path, tt.query, d.Allowed, tt.wantAllowed, d.Reason)
+			}
+		})
+	}
+}
+
+func TestEvaluateHTTPRequestPublishing(t *testing.T) {
+	allow := false
+	tests := []struct {
+		name        string
+		host        string
+		method      string
+		path        string
+		block       *bool
+		wantAllowed bool
+	}{
+		{"npm install", "registry.npmjs.org", "GET", "/left-pad", nil, true},
+		{"npm publish", "registry.npmjs.org", "PUT", "/left-pad", nil, false},
+		{"twine upload", "upload.pypi.org", "POST", "/legacy/", nil, false},
+		{"cargo publish", "crates.io", "PUT", "/api/v1/crates/new", nil, false},
+		{"cargo install", "crates.io", "GET", "/api/v1/crates/serde/1.0.0/download", nil, true},
+		{"docker push", "registry-1.docker.io", "PUT", "/v2/library/app/manifests/latest", nil, false},
+		{"docker pull", "registry-1.docker.io", "GET", "/v2/library/app/manifests/latest", nil, true},
+		{"publishing allowed", "registry.npmjs.org", "PUT", "/left-pad", &allow, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Network: config.NetworkConfig{BlockPublishing: tt.block}}
+			d := EvaluateHTTPRequest(cfg, tt.host, tt.method, tt.path, -1)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateHTTPRequest(%q, %s %s).Allowed = %v, want %v (%s)", tt.host, tt.method, tt.path, d.Allowed, tt.wantAllowed, d.Reason)
+			}
+			if !d.Allowed && !RequiresHTTPRules(cfg, tt.host) {
+				t.Errorf("RequiresHTTPRules(%q) = false for a denied host", tt.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package policy defines the allow/deny decisions fence makes and the
+// evaluation of network rules shared by the proxies and the CLI.
+package policy
+
+import (
+	"fmt"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// Decision is the outcome of evaluating a request against the config.
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule identifies the config rule that decided the outcome,
+	// e.g. `network.deniedDomains "*.evil.com"`. Empty when a default applied.
+	Rule string `json:"rule,omitempty"`
+	// Reason is a human-readable explanation of the outcome.
+	Reason string `json:"reason"`
+	// Warn is set when the operation matched a rule whose action is warn:
+	// it is allowed, but reported as a violation.
+	Warn bool `json:"warn,omitempty"`
+}
+
+// Allow returns an allowing decision.
+func Allow(rule, reason string) Decision {
+	return Decision{Allowed: true, Rule: rule, Reason: reason}
+}
+
+// Deny returns a denying decision.
+func Deny(rule, reason string) Decision {
+	return Decision{Allowed: false, Rule: rule, Reason: reason}
+}
+
+// Warn returns a decision allowing an operation that a warn rule matched.
+func Warn(rule, reason string) Decision {
+	return Decision{Allowed: true, Rule: rule, Reason: reason, Warn: true}
+}
+
+// Verdict returns "ALLOWED", "WARNED", or "DENIED".
+func (d Decision) Verdict() string {
+	if d.Warn {
+		return "WARNED"
+	}
+	if d.Allowed {
+		return "ALLOWED"
+	}
+	return "DENIED"
+}
+
+// Basis returns the rule that decided the outcome, or the reason if a default applied.
+func (d Decision) Basis() string {
+	if d.Rule != "" {
+		return d.Rule
+	}
+	return d.Reason
+}
+
+// String formats the decision as "VERDICT by rule (reason)".
+func (d Decision) String() string {
+	if d.Rule == "" {
+		return fmt.Sprintf("%s (%s)", d.Verdict(), d.Reason)
+	}
+	return fmt.Sprintf("%s by %s (%s)", d.Verdict(), d.Rule, d.Reason)
+}
+
+// RuleRef formats a reference to a config rule, e.g. `network.allowedDomains "github.com"`.
+func RuleRef(key, value string) string {
+	return fmt.Sprintf("%s %q", key, value)
+}
+
+// EvaluateDomain decides whether connections to host are allowed.
+// Denied domains are checked first, then allowed domains; anything else is
+// denied. An allowed host that matches a deniedDomains entry whose action is
+// warn is allowed with a warning.
+func EvaluateDomain(cfg *config.Config, host string) Decision {
+	if cfg == nil {
+		return Deny("", "no config, all network is denied")
+	}
+
+	for _, denied := range cfg.Network.DeniedDomains {
+		if config.MatchesDomain(host, denied) {
+			return Deny(RuleRef("network.deniedDomains", denied), "denied domains take precedence")
+		}
+	}
+
+	for _, allowed := range cfg.Network.AllowedDomains {
+		if config.MatchesDomain(host, allowed) {
+			// A warn rule only matters for hosts that would be allowed
+			for _, warned := range cfg.Network.WarnDomains {
+				if config.MatchesDomain(host, warned) {
+					return Warn(RuleRef("network.deniedDomains", warned), "the denied domain's action is warn")
+				}
+			}
+			return Allow(RuleRef("network.allowedDomains", allowed), "domain is allowlisted")
+		}
+	}
+
+	return Deny("", "no allowedDomains entry matches; network is deny-by-default")
+}
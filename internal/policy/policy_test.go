@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestEvaluateDomain(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			AllowedDomains: []string{"github.com", "*.npmjs.org"},
+			DeniedDomains:  []string{"evil.npmjs.org"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		wantAllowed bool
+		wantRule    string
+	}{
+		{"exact allow", "github.com", true, `network.allowedDomains "github.com"`},
+		{"wildcard allow", "registry.npmjs.org", true, `network.allowedDomains "*.npmjs.org"`},
+		{"deny wins over allow", "evil.npmjs.org", false, `network.deniedDomains "evil.npmjs.org"`},
+		{"default deny", "example.com", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateDomain(cfg, tt.host)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateDomain(%q).Allowed = %v, want %v", tt.host, d.Allowed, tt.wantAllowed)
+			}
+			if d.Rule != tt.wantRule {
+				t.Errorf("EvaluateDomain(%q).Rule = %q, want %q", tt.host, d.Rule, tt.wantRule)
+			}
+			if d.Reason == "" {
+				t.Error("expected a reason")
+			}
+		})
+	}
+}
+
+func TestEvaluateDomainWarn(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			AllowedDomains: []string{"*.example.com"},
+			DeniedDomains:  []string{"evil.example.com"},
+			WarnDomains:    []string{"staging.example.com", "other.org"},
+		},
+	}
+
+	d := EvaluateDomain(cfg, "staging.example.com")
+	if !d.Allowed || !d.Warn || d.Rule != `network.deniedDomains "staging.example.com"` {
+		t.Errorf("EvaluateDomain(staging) = %+v, want allowed with a warning", d)
+	}
+	if d := EvaluateDomain(cfg, "evil.example.com"); d.Allowed || d.Warn {
+		t.Errorf("EvaluateDomain(evil) = %+v, want denied", d)
+	}
+	// A warn rule does not allow what nothing else allows
+	if d := EvaluateDomain(cfg, "other.org"); d.Allowed || d.Warn {
+		t.Errorf("EvaluateDomain(other.org) = %+v, want denied by default", d)
+	}
+	if got, want := d.String(), `WARNED by network.deniedDomains "staging.example.com" (the denied domain's action is warn)`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestEvaluateDomainNilConfig(t *testing.T) {
+	if d := EvaluateDomain(nil, "example.com"); d.Allowed {
+		t.Error("nil config should deny all domains")
+	}
+}
+
+func TestDecisionString(t *testing.T) {
+	d := Deny(RuleRef("command.deny", "git push"), "matches")
+	if got, want := d.String(), `DENIED by command.deny "git push" (matches)`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got, want := Allow("", "default").String(), "ALLOWED (default)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// RunStats counts what the proxies handled and the policy blocked during a
+// run, for the line fence prints when the command exits.
+type RunStats struct {
+	// Requests is the number of connections and plain HTTP requests the
+	// proxies were asked to allow.
+	Requests int64 `json:"requests"`
+	// Blocked is the number of network operations denied (in audit mode,
+	// that would have been), and BlockedDomains the number of distinct hosts
+	// they were for.
+	Blocked        int `json:"blocked"`
+	BlockedDomains int `json:"blockedDomains"`
+	// FileViolations counts filesystem denials, which are only detected by
+	// the violation monitors.
+	FileViolations  int `json:"fileViolations"`
+	BlockedCommands int `json:"blockedCommands"`
+	// Warned is the number of operations allowed because the rule they
+	// matched only warns.
+	Warned int  `json:"warned,omitempty"`
+	Audit  bool `json:"audit,omitempty"`
+}
+
+// Stats returns the run's totals, given the number of requests the proxies
+// handled. Repeats of a violation are counted each time.
+func (l *ViolationLog) Stats(requests int64) RunStats {
+	s := RunStats{Requests: requests, Audit: l.Audit()}
+	hosts := make(map[string]bool)
+	for _, v := range l.Violations() {
+		if v.Decision.Warn {
+			s.Warned += v.Count
+			continue
+		}
+		switch v.Kind {
+		case KindNetwork:
+			s.Blocked += v.Count
+			hosts[TargetHost(v.Target)] = true
+		case KindFilesystem:
+			s.FileViolations += v.Count
+		case KindCommand:
+			s.BlockedCommands += v.Count
+		}
+	}
+	s.BlockedDomains = len(hosts)
+	return s
+}
+
+// TargetHost returns the host of a network violation target, which is
+// "host:port", optionally preceded by an HTTP method.
+func TargetHost(target string) string {
+	if i := strings.LastIndexByte(target, ' '); i >= 0 {
+		target = target[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return target
+}
+
+// WriteSummary writes the totals as a single line, e.g.
+//
+//	[fence] 214 requests (198 allowed, 16 blocked across 4 domains), 3 file violations, 0 blocked commands
+func (s RunStats) WriteSummary(w io.Writer) error {
+	prefix, blocked, commands := "[fence]", "blocked", plural(s.BlockedCommands, "blocked command")
+	allowed := s.Requests - int64(s.Blocked)
+	if s.Audit {
+		// Nothing was blocked, but filesystem rules still apply
+		prefix, blocked = "[fence:audit]", "would have been blocked"
+		commands = plural(s.BlockedCommands, "command") + " would have been blocked"
+		allowed = s.Requests
+	}
+	allowed = max(allowed, 0)
+
+	network := fmt.Sprintf("%d allowed, %d %s", allowed, s.Blocked, blocked)
+	if s.Blocked > 0 {
+		network += " across " + plural(s.BlockedDomains, "domain")
+	}
+	if s.Warned > 0 {
+		commands += ", " + plural(s.Warned, "warning")
+	}
+	_, err := fmt.Fprintf(w, "%s %s (%s), %s, %s\n",
+		prefix, plural(s.Requests, "request"), network, plural(s.FileViolations, "file violation"), commands)
+	return err
+}
+
+// plural returns n followed by noun, with an "s" unless n is 1.
+func plural[N int | int64](n N, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestViolationLogStats(t *testing.T) {
+	log := NewViolationLog(false)
+	deny := Deny("", "default deny")
+	for range 3 {
+		log.Record(Event{Kind: KindNetwork, Target: "example.com:443", Decision: deny})
+	}
+	log.Record(Event{Kind: KindNetwork, Target: "POST api.example.com:80", Decision: deny})
+	log.Record(Event{Kind: KindNetwork, Target: "[::1]:8080", Decision: deny})
+	log.Record(Event{Kind: KindFilesystem, Target: "open (cat)", Decision: deny})
+	log.Record(Event{Kind: KindCommand, Target: "git push", Decision: deny})
+	log.Record(Event{Kind: KindNetwork, Target: "staging.example.com:443", Decision: Warn("network.deniedDomains", "warn only")})
+
+	got := log.Stats(20)
+	want := RunStats{Requests: 20, Blocked: 5, BlockedDomains: 3, FileViolations: 1, BlockedCommands: 1, Warned: 1}
+	if got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunStatsWriteSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats RunStats
+		want  string
+	}{
+		{
+			name:  "blocked",
+			stats: RunStats{Requests: 214, Blocked: 16, BlockedDomains: 4, FileViolations: 3},
+			want:  "[fence] 214 requests (198 allowed, 16 blocked across 4 domains), 3 file violations, 0 blocked commands\n",
+		},
+		{
+			name:  "nothing blocked",
+			stats: RunStats{Requests: 1, BlockedCommands: 1},
+			want:  "[fence] 1 request (1 allowed, 0 blocked), 0 file violations, 1 blocked command\n",
+		},
+		{
+			name:  "audit",
+			stats: RunStats{Requests: 10, Blocked: 2, BlockedDomains: 1, BlockedCommands: 2, Audit: true},
+			want:  "[fence:audit] 10 requests (10 allowed, 2 would have been blocked across 1 domain), 0 file violations, 2 commands would have been blocked\n",
+		},
+		{
+			name:  "warnings",
+			stats: RunStats{Requests: 3, Warned: 2},
+			want:  "[fence] 3 requests (3 allowed, 0 blocked), 0 file violations, 0 blocked commands, 2 warnings\n",
+		},
+		{
+			// Blocked connections the filter never saw, e.g. with no requests counted
+			name:  "more blocked than requests",
+			stats: RunStats{Blocked: 2, BlockedDomains: 2},
+			want:  "[fence] 0 requests (0 allowed, 2 blocked across 2 domains), 0 file violations, 0 blocked commands\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			if err := tt.stats.WriteSummary(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteSummary() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
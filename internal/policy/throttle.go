@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/output"
+)
+
+// Defaults for MonitorOutput.
+const (
+	throttleInterval    = 10 * time.Second
+	throttleRuleLimit   = 5  // Distinct lines per rule per interval before sampling
+	throttleSampleEvery = 10 // Past the limit, write one in this many new lines
+)
+
+// MonitorOutput is where the proxies and violation monitors write denial
+// lines, so a retry loop cannot drown everything else.
+var MonitorOutput = NewThrottle(os.Stderr, throttleInterval, throttleRuleLimit, throttleSampleEvery)
+
+// Throttle deduplicates and rate-limits log lines. Each line has a key
+// identifying the operation (e.g. rule, target, and pid) and the rule it
+// falls under. Within an interval only the first line for a key is written
+// and repeats are counted; each rule writes at most ruleLimit distinct lines
+// and then only one in sampleEvery. When the interval ends, what was held
+// back is summarized. It is safe for concurrent use.
+type Throttle struct {
+	w           io.Writer
+	interval    time.Duration
+	ruleLimit   int
+	sampleEvery int
+	now         func() time.Time // For tests
+
+	mu        sync.Mutex
+	start     time.Time // Start of the current interval
+	keys      map[string]*throttledLine
+	lines     []*throttledLine // Written lines, in order, for summaries
+	rules     map[string]*throttledRule
+	ruleOrder []*throttledRule
+	timer     *time.Timer // Pending flush of held-back lines
+	gen       int         // Counts intervals, so a stale timer does not end a later one
+}
+
+type throttledLine struct {
+	line    string
+	rule    *throttledRule
+	sampled bool // Held back by the rule's budget
+	repeats int
+}
+
+type throttledRule struct {
+	name       string
+	distinct   int // Distinct keys seen this interval
+	suppressed int // Lines held back by the budget, including repeats
+}
+
+// NewThrottle returns a throttle writing to w.
+func NewThrottle(w io.Writer, interval time.Duration, ruleLimit, sampleEvery int) *Throttle {
+	return &Throttle{
+		w:           w,
+		interval:    interval,
+		ruleLimit:   ruleLimit,
+		sampleEvery: sampleEvery,
+		now:         time.Now,
+		keys:        make(map[string]*throttledLine),
+		rules:       make(map[string]*throttledRule),
+	}
+}
+
+// SetOutput sets where lines and summaries are written.
+func (t *Throttle) SetOutput(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w = w
+}
+
+// Print writes line, which must not end in a newline, unless it repeats key
+// or exceeds rule's budget for the current interval. Secrets in line are
+// redacted.
+func (t *Throttle) Print(rule, key, line string) {
+	line = Redact(line)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if t.start.IsZero() || now.Sub(t.start) >= t.interval {
+		t.flushLocked()
+		t.start = now
+	}
+
+	if l, ok := t.keys[key]; ok {
+		if l.sampled {
+			l.rule.suppressed++
+		} else {
+			l.repeats++
+		}
+		t.scheduleLocked(now)
+		return
+	}
+
+	r, ok := t.rules[rule]
+	if !ok {
+		r = &throttledRule{name: rule}
+		t.rules[rule] = r
+		t.ruleOrder = append(t.ruleOrder, r)
+	}
+	r.distinct++
+	l := &throttledLine{line: line, rule: r}
+	t.keys[key] = l
+	if r.distinct > t.ruleLimit && (r.distinct-t.ruleLimit)%t.sampleEvery != 0 {
+		l.sampled = true
+		r.suppressed++
+		t.scheduleLocked(now)
+		return
+	}
+	t.lines = append(t.lines, l)
+	fmt.Fprintln(t.w, line)
+}
+
+// Flush writes the summaries of lines held back so far and starts a new
+// interval.
+func (t *Throttle) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushLocked()
+	t.start = time.Time{}
+}
+
+// scheduleLocked arranges for held-back lines to be summarized when the
+// current interval ends, even if nothing else is printed.
+func (t *Throttle) scheduleLocked(now time.Time) {
+	if t.timer != nil {
+		return
+	}
+	gen := t.gen
+	t.timer = time.AfterFunc(t.start.Add(t.interval).Sub(now), func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.gen == gen {
+			t.flushLocked()
+			t.start = time.Time{}
+		}
+	})
+}
+
+func (t *Throttle) flushLocked() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	elapsed := t.now().Sub(t.start).Round(time.Second)
+	if elapsed > t.interval {
+		elapsed = t.interval
+	}
+	for _, l := range t.lines {
+		if l.repeats > 0 {
+			fmt.Fprintf(t.w, "[fence:monitor] %s\n", output.Sprintf("Repeated %d times in the last %s: %s", l.repeats, elapsed, l.line))
+		}
+	}
+	for _, r := range t.ruleOrder {
+		if r.suppressed > 0 {
+			fmt.Fprintf(t.w, "[fence:monitor] %s\n", output.Sprintf("%d similar line(s) for %s sampled out in the last %s", r.suppressed, r.name, elapsed))
+		}
+	}
+	t.gen++
+	clear(t.keys)
+	clear(t.rules)
+	t.lines = nil
+	t.ruleOrder = nil
+}
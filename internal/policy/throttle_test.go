@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe to write from a throttle's timer.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+}
+
+func TestThrottleDeduplicates(t *testing.T) {
+	var out lockedBuffer
+	th := NewThrottle(&out, time.Hour, 5, 10)
+
+	for i := 0; i < 100; i++ {
+		th.Print("example.com", "GET http://example.com/", "blocked GET http://example.com/")
+	}
+	th.Print("example.com", "GET http://example.com/other", "blocked GET http://example.com/other")
+	th.Flush()
+
+	want := []string{
+		"blocked GET http://example.com/",
+		"blocked GET http://example.com/other",
+		"[fence:monitor] Repeated 99 times in the last 0s: blocked GET http://example.com/",
+	}
+	if got := out.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestThrottleSamplesPerRule(t *testing.T) {
+	var out lockedBuffer
+	th := NewThrottle(&out, time.Hour, 2, 5)
+
+	// 2 lines within the budget, then every 5th new line: 2 + 4 of 22
+	for i := 0; i < 22; i++ {
+		th.Print("open", fmt.Sprintf("open /f%d", i), fmt.Sprintf("denied open /f%d", i))
+	}
+	th.Print("connect", "connect", "denied connect")
+	th.Flush()
+
+	got := out.Lines()
+	if len(got) != 8 {
+		t.Fatalf("wrote %d lines, want 8:\n%s", len(got), strings.Join(got, "\n"))
+	}
+	if got[6] != "denied connect" {
+		t.Errorf("other rules should not be limited, got %q", got[6])
+	}
+	if want := "[fence:monitor] 16 similar line(s) for open sampled out in the last 0s"; got[7] != want {
+		t.Errorf("summary = %q, want %q", got[7], want)
+	}
+}
+
+func TestThrottleNewInterval(t *testing.T) {
+	var out lockedBuffer
+	th := NewThrottle(&out, time.Minute, 5, 10)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	th.now = func() time.Time { return now }
+
+	th.Print("r", "k", "line")
+	th.Print("r", "k", "line")
+	now = now.Add(2 * time.Minute)
+	th.Print("r", "k", "line")
+
+	want := []string{
+		"line",
+		"[fence:monitor] Repeated 1 times in the last 1m0s: line",
+		"line",
+	}
+	if got := out.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestThrottleSummarizesWhenIntervalEnds(t *testing.T) {
+	var out lockedBuffer
+	th := NewThrottle(&out, 50*time.Millisecond, 5, 10)
+
+	th.Print("r", "k", "line")
+	th.Print("r", "k", "line")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(out.Lines()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("held-back repeat was not summarized after the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := out.Lines()[1]; !strings.HasPrefix(got, "[fence:monitor] Repeated 1 times") {
+		t.Errorf("summary = %q", got)
+	}
+}
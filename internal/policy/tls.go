@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// TLSHandshake is the outcome of a TLS negotiation as observed by the proxy.
+// A zero Version means the connection is not TLS.
+type TLSHandshake struct {
+	Version     uint16
+	CipherSuite uint16
+}
+
+// String describes the handshake, e.g. "TLS 1.3 with TLS_AES_128_GCM_SHA256".
+func (h TLSHandshake) String() string {
+	if h.Version == 0 {
+		return "plaintext"
+	}
+	return fmt.Sprintf("%s with %s", tls.VersionName(h.Version), tls.CipherSuiteName(h.CipherSuite))
+}
+
+// RequiresTLS reports whether any network.tls rule matches host.
+func RequiresTLS(cfg *config.Config, host string) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, rule := range cfg.Network.TLS {
+		if matchTLSRule(rule, host) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluateTLS decides whether a connection to host that negotiated hs meets
+// every network.tls rule matching host. Hosts without rules are allowed, and
+// handshakes falling short only of rules whose action is warn are allowed
+// with a warning.
+func EvaluateTLS(cfg *config.Config, host string, hs TLSHandshake) Decision {
+	decision := Allow("", "no network.tls rule matches")
+	if cfg == nil {
+		return decision
+	}
+
+	var warned *Decision
+	for _, rule := range cfg.Network.TLS {
+		pattern := matchTLSRule(rule, host)
+		if pattern == "" {
+			continue
+		}
+		ref := RuleRef("network.tls", pattern)
+
+		if reason := breaksTLSRule(rule, hs); reason != "" {
+			if rule.Action != config.ActionWarn {
+				return Deny(ref, reason)
+			}
+			if warned == nil {
+				d := Warn(ref, reason)
+				warned = &d
+			}
+			continue
+		}
+		decision = Allow(ref, "negotiated "+hs.String())
+	}
+	if warned != nil {
+		return *warned
+	}
+	return decision
+}
+
+// breaksTLSRule returns why a handshake falls short of rule, or "" if it
+// does not.
+func breaksTLSRule(rule config.TLSRule, hs TLSHandshake) string {
+	if hs.Version == 0 {
+		return fmt.Sprintf("connection is not TLS; minVersion %s is required", rule.MinVersion)
+	}
+	if hs.Version < config.TLSVersions[rule.MinVersion] {
+		return fmt.Sprintf("negotiated %s, below minVersion %s", tls.VersionName(hs.Version), rule.MinVersion)
+	}
+	if len(rule.Ciphers) > 0 && !slices.ContainsFunc(rule.Ciphers, func(name string) bool {
+		id, _ := config.CipherSuiteID(name)
+		return id == hs.CipherSuite
+	}) {
+		return fmt.Sprintf("negotiated cipher %s is not in ciphers", tls.CipherSuiteName(hs.CipherSuite))
+	}
+	return ""
+}
+
+// matchTLSRule returns the first of rule's domain patterns matching host, or "".
+func matchTLSRule(rule config.TLSRule, host string) string {
+	for _, pattern := range rule.Domains {
+		if config.MatchesDomain(host, pattern) {
+			return pattern
+		}
+	}
+	return ""
+}
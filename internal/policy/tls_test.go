@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestEvaluateTLS(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			TLS: []config.TLSRule{
+				{Domains: []string{"*.bank.com"}, MinVersion: "1.2"},
+				{Domains: []string{"api.bank.com"}, MinVersion: "1.3", Ciphers: []string{"TLS_AES_256_GCM_SHA384"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		hs          TLSHandshake
+		wantAllowed bool
+		wantRule    string
+	}{
+		{"no rule", "example.com", TLSHandshake{}, true, ""},
+		{"meets minVersion", "www.bank.com", TLSHandshake{tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, true, `network.tls "*.bank.com"`},
+		{"below minVersion", "www.bank.com", TLSHandshake{tls.VersionTLS11, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}, false, `network.tls "*.bank.com"`},
+		{"plaintext", "www.bank.com", TLSHandshake{}, false, `network.tls "*.bank.com"`},
+		{"all matching rules apply", "api.bank.com", TLSHandshake{tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, false, `network.tls "api.bank.com"`},
+		{"cipher not listed", "api.bank.com", TLSHandshake{tls.VersionTLS13, tls.TLS_AES_128_GCM_SHA256}, false, `network.tls "api.bank.com"`},
+		{"cipher listed", "api.bank.com", TLSHandshake{tls.VersionTLS13, tls.TLS_AES_256_GCM_SHA384}, true, `network.tls "api.bank.com"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateTLS(cfg, tt.host, tt.hs)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluateTLS(%q, %s).Allowed = %v, want %v (%s)", tt.host, tt.hs, d.Allowed, tt.wantAllowed, d.Reason)
+			}
+			if d.Rule != tt.wantRule {
+				t.Errorf("EvaluateTLS(%q, %s).Rule = %q, want %q", tt.host, tt.hs, d.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestEvaluateTLSWarn(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			TLS: []config.TLSRule{{Domains: []string{"*.bank.com"}, MinVersion: "1.3", Action: config.ActionWarn}},
+		},
+	}
+	d := EvaluateTLS(cfg, "www.bank.com", TLSHandshake{tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	if !d.Allowed || !d.Warn || d.Rule != `network.tls "*.bank.com"` {
+		t.Errorf("EvaluateTLS() = %+v, want allowed with a warning", d)
+	}
+	if d := EvaluateTLS(cfg, "www.bank.com", TLSHandshake{tls.VersionTLS13, tls.TLS_AES_128_GCM_SHA256}); !d.Allowed || d.Warn {
+		t.Errorf("EvaluateTLS() = %+v, want allowed", d)
+	}
+}
+
+func TestRequiresTLS(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			TLS: []config.TLSRule{{Domains: []string{"bank.com"}, MinVersion: "1.2"}},
+		},
+	}
+	if !RequiresTLS(cfg, "bank.com") {
+		t.Error("expected bank.com to require TLS")
+	}
+	if RequiresTLS(cfg, "example.com") {
+		t.Error("expected example.com not to require TLS")
+	}
+	if RequiresTLS(nil, "bank.com") {
+		t.Error("expected nil config not to require TLS")
+	}
+}
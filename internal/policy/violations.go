@@ -0,0 +1,236 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/output"
+)
+
+// Kinds of operations recorded in a ViolationLog.
+const (
+	KindNetwork    = "network"
+	KindCommand    = "command"
+	KindFilesystem = "filesystem"
+)
+
+// Sources that detect violations.
+const (
+	SourceProxy     = "proxy"     // HTTP or SOCKS proxy domain filter
+	SourceCommand   = "command"   // Command policy check before the sandbox starts
+	SourceLogStream = "logstream" // macOS sandbox log stream (monitor mode)
+	SourceEBPF      = "ebpf"      // Linux eBPF monitor (monitor mode)
+	SourceFanotify  = "fanotify"  // Linux fanotify monitor, without eBPF (monitor mode)
+)
+
+// Event is a single violation, delivered to subscribers as it happens.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"` // One of the Source* constants
+	Kind     string    `json:"kind"`   // KindNetwork, KindCommand, or KindFilesystem
+	Target   string    `json:"target"` // e.g. "registry.npmjs.org:443", the command line, or a path
+	Decision Decision  `json:"decision"`
+	// Process is the name of the process that was denied, when the
+	// violation monitors report it.
+	Process string `json:"process,omitempty"`
+	// Audit is true if the operation was allowed because audit mode is on.
+	Audit bool `json:"audit,omitempty"`
+}
+
+// Violation is an operation the policy denied, or in audit mode would have
+// denied, or that a warn rule matched (Decision.Warn).
+type Violation struct {
+	Kind     string   `json:"kind"`   // KindNetwork, KindCommand, or KindFilesystem
+	Target   string   `json:"target"` // e.g. "registry.npmjs.org:443", the command line, or a path
+	Decision Decision `json:"decision"`
+	Count    int      `json:"count"` // Number of times the operation was seen
+}
+
+// ViolationLog collects policy violations during a run. Repeats of the same
+// operation are counted rather than recorded again. It is safe for concurrent use.
+type ViolationLog struct {
+	mu          sync.Mutex
+	audit       bool
+	events      []*Violation
+	index       map[string]*Violation
+	subscribers map[int]func(Event)
+	nextID      int
+}
+
+// NewViolationLog returns an empty violation log. If audit is true, the
+// recorded operations were allowed and the report says they would have been blocked.
+func NewViolationLog(audit bool) *ViolationLog {
+	return &ViolationLog{
+		audit:       audit,
+		index:       make(map[string]*Violation),
+		subscribers: make(map[int]func(Event)),
+	}
+}
+
+// Audit reports whether the log is in audit mode.
+func (l *ViolationLog) Audit() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.audit
+}
+
+// SetAudit switches audit mode on or off for violations recorded from now on.
+func (l *ViolationLog) SetAudit(audit bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.audit = audit
+}
+
+// Subscribe registers fn to be called with every recorded event, including
+// repeats. fn runs synchronously on the goroutine that detected the violation
+// (e.g. a proxy connection handler), so it must be quick and must not block.
+// The returned function removes the subscription.
+func (l *ViolationLog) Subscribe(fn func(Event)) (unsubscribe func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.nextID
+	l.nextID++
+	l.subscribers[id] = fn
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subscribers, id)
+	}
+}
+
+// StreamNDJSON writes every event recorded from now on to w as one line of
+// JSON (newline-delimited JSON), so tools can consume violations while the
+// command runs. Repeats are written too. The returned function stops the stream.
+func (l *ViolationLog) StreamNDJSON(w io.Writer) (stop func()) {
+	var mu sync.Mutex // Keeps lines from concurrent events whole
+	return l.Subscribe(func(e Event) {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(append(line, '\n'))
+	})
+}
+
+// Record notes that an operation was denied, or matched a warn rule, and
+// notifies subscribers.
+// e.Time defaults to now, e.Audit is set from the log's mode, and secrets in
+// e.Target are redacted.
+func (l *ViolationLog) Record(e Event) {
+	e.Target = Redact(e.Target)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	e.Audit = l.audit
+	key := e.Kind + "\x00" + e.Target
+	if v, ok := l.index[key]; ok {
+		v.Count++
+	} else {
+		v := &Violation{Kind: e.Kind, Target: e.Target, Decision: e.Decision, Count: 1}
+		l.events = append(l.events, v)
+		l.index[key] = v
+	}
+	subscribers := make([]func(Event), 0, len(l.subscribers))
+	for _, fn := range l.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	l.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// Violations returns the recorded violations in the order they were first seen.
+func (l *ViolationLog) Violations() []Violation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]Violation, len(l.events))
+	for i, e := range l.events {
+		events[i] = *e
+	}
+	return events
+}
+
+// WriteReport writes a human-readable summary of the recorded violations,
+// followed by the operations warn rules matched. Outside audit mode, nothing
+// is written if there are none.
+func (l *ViolationLog) WriteReport(w io.Writer) error {
+	var events, warned []Violation
+	for _, v := range l.Violations() {
+		if v.Decision.Warn {
+			warned = append(warned, v)
+		} else {
+			events = append(events, v)
+		}
+	}
+	if err := l.writeViolations(w, events); err != nil {
+		return err
+	}
+	if len(warned) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "[fence:warn] %s\n", output.Sprintf("%d operation(s) allowed by warn rules:", len(warned))); err != nil {
+		return err
+	}
+	return writeViolationTable(w, warned)
+}
+
+// writeViolations writes the report's section on denied operations.
+func (l *ViolationLog) writeViolations(w io.Writer, events []Violation) error {
+	audit := l.Audit()
+	prefix, none, header := "[fence]", "No operations blocked", "%d operation(s) blocked:"
+	if audit {
+		prefix, none, header = "[fence:audit]", "No operations would have been blocked", "%d operation(s) would have been blocked:"
+	}
+
+	if len(events) == 0 {
+		if !audit {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "%s %s\n", prefix, output.Sprintf(none))
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s\n", prefix, output.Sprintf(header, len(events))); err != nil {
+		return err
+	}
+	return writeViolationTable(w, events)
+}
+
+// writeViolationTable writes one line per violation, aligned in columns.
+func writeViolationTable(w io.Writer, events []Violation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range events {
+		count := ""
+		if e.Count > 1 {
+			count = fmt.Sprintf("(x%d)", e.Count)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", e.Kind, e.Target, e.Decision.Basis(), count)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the recorded violations as a JSON document.
+func (l *ViolationLog) WriteJSON(w io.Writer) error {
+	report := struct {
+		Audit      bool        `json:"audit"`
+		Violations []Violation `json:"violations"`
+	}{
+		Audit:      l.Audit(),
+		Violations: l.Violations(),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
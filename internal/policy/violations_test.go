@@ -0,0 +1,224 @@
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestViolationLogRecord(t *testing.T) {
+	log := NewViolationLog(true)
+	deny := Deny(RuleRef("command.deny", "git push"), "matches")
+
+	log.Record(Event{Kind: KindCommand, Target: "git push", Decision: deny})
+	log.Record(Event{Kind: KindNetwork, Target: "example.com:443", Decision: Deny("", "default deny")})
+	log.Record(Event{Kind: KindCommand, Target: "git push", Decision: deny})
+
+	events := log.Violations()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].Target != "git push" || events[0].Count != 2 {
+		t.Errorf("events[0] = %+v, want git push seen twice", events[0])
+	}
+	if events[1].Kind != KindNetwork || events[1].Count != 1 {
+		t.Errorf("events[1] = %+v, want one network event", events[1])
+	}
+}
+
+func TestViolationLogRecordRedacts(t *testing.T) {
+	log := NewViolationLog(false)
+	var streamed Event
+	log.Subscribe(func(e Event) { streamed = e })
+	log.Record(Event{Kind: KindCommand, Target: "curl -H 'Authorization: Bearer abc123' https://api.example.com", Decision: Deny("", "denied")})
+
+	want := "curl -H 'Authorization: Bearer [REDACTED]' https://api.example.com"
+	if got := log.Violations()[0].Target; got != want {
+		t.Errorf("recorded target = %q, want %q", got, want)
+	}
+	if streamed.Target != want {
+		t.Errorf("streamed target = %q, want %q", streamed.Target, want)
+	}
+}
+
+func TestViolationLogConcurrent(t *testing.T) {
+	log := NewViolationLog(true)
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Record(Event{Kind: KindNetwork, Target: "example.com:443", Decision: Deny("", "default deny")})
+		}()
+	}
+	wg.Wait()
+
+	if events := log.Violations(); len(events) != 1 || events[0].Count != 50 {
+		t.Errorf("Events() = %+v, want one event seen 50 times", events)
+	}
+}
+
+func TestViolationLogWriteReport(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func() *ViolationLog
+		want []string
+	}{
+		{
+			name: "empty audit",
+			log:  func() *ViolationLog { return NewViolationLog(true) },
+			want: []string{"No operations would have been blocked"},
+		},
+		{
+			name: "events",
+			log: func() *ViolationLog {
+				l := NewViolationLog(true)
+				l.Record(Event{Kind: KindNetwork, Target: "example.com:443", Decision: Deny("", "no allowedDomains entry matches")})
+				l.Record(Event{Kind: KindNetwork, Target: "example.com:443", Decision: Deny("", "no allowedDomains entry matches")})
+				l.Record(Event{Kind: KindCommand, Target: "git push", Decision: Deny(RuleRef("command.deny", "git push"), "matches")})
+				return l
+			},
+			want: []string{
+				"2 operation(s) would have been blocked",
+				"example.com:443",
+				"no allowedDomains entry matches",
+				"(x2)",
+				`command.deny "git push"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := tt.log().WriteReport(&b); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("report missing %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestViolationLogWriteReportEnforcing(t *testing.T) {
+	log := NewViolationLog(false)
+
+	var b strings.Builder
+	if err := log.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("empty enforcing report = %q, want nothing", b.String())
+	}
+
+	log.Record(Event{Kind: KindFilesystem, Target: "/home/me/.ssh/id_rsa", Decision: Deny("", "sandbox denied file-read-data")})
+	if err := log.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"[fence] 1 operation(s) blocked:", "filesystem", "/home/me/.ssh/id_rsa"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestViolationLogWriteReportWarnings(t *testing.T) {
+	log := NewViolationLog(false)
+	log.Record(Event{Kind: KindCommand, Target: "npm publish", Decision: Warn(RuleRef("command.deny", "npm publish"), "warn only")})
+
+	var b strings.Builder
+	if err := log.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "blocked") {
+		t.Errorf("warnings reported as blocked:\n%s", b.String())
+	}
+	for _, want := range []string{"[fence:warn] 1 operation(s) allowed by warn rules:", "npm publish", `command.deny "npm publish"`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestViolationLogWriteJSON(t *testing.T) {
+	log := NewViolationLog(false)
+	log.Record(Event{Kind: KindNetwork, Target: "evil.com:443", Decision: Deny(RuleRef("network.deniedDomains", "evil.com"), "matches")})
+	log.Record(Event{Kind: KindNetwork, Target: "evil.com:443", Decision: Deny(RuleRef("network.deniedDomains", "evil.com"), "matches")})
+
+	var b strings.Builder
+	if err := log.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Audit      bool
+		Violations []Violation
+	}
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.String())
+	}
+	if got.Audit || len(got.Violations) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	v := got.Violations[0]
+	if v.Kind != KindNetwork || v.Target != "evil.com:443" || v.Count != 2 || v.Decision.Rule != `network.deniedDomains "evil.com"` {
+		t.Errorf("violation = %+v", v)
+	}
+}
+
+func TestViolationLogSubscribe(t *testing.T) {
+	log := NewViolationLog(false)
+
+	var got []Event
+	unsubscribe := log.Subscribe(func(e Event) { got = append(got, e) })
+
+	deny := Deny("", "default deny")
+	log.Record(Event{Source: SourceProxy, Kind: KindNetwork, Target: "example.com:443", Decision: deny})
+	log.SetAudit(true)
+	log.Record(Event{Source: SourceProxy, Kind: KindNetwork, Target: "example.com:443", Decision: deny})
+	unsubscribe()
+	log.Record(Event{Source: SourceCommand, Kind: KindCommand, Target: "git push", Decision: deny})
+
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2 (repeats delivered, none after unsubscribe): %+v", len(got), got)
+	}
+	if got[0].Source != SourceProxy || got[0].Time.IsZero() || got[0].Audit {
+		t.Errorf("got[0] = %+v", got[0])
+	}
+	if !got[1].Audit {
+		t.Errorf("got[1].Audit = false, want true after SetAudit")
+	}
+	if n := len(log.Violations()); n != 2 {
+		t.Errorf("Violations() has %d entries, want 2", n)
+	}
+}
+
+func TestViolationLogStreamNDJSON(t *testing.T) {
+	log := NewViolationLog(false)
+	var buf strings.Builder
+	stop := log.StreamNDJSON(&buf)
+
+	deny := Deny(RuleRef("network.deniedDomains", "evil.com"), "matches")
+	log.Record(Event{Source: SourceProxy, Kind: KindNetwork, Target: "evil.com:443", Decision: deny})
+	log.Record(Event{Source: SourceProxy, Kind: KindNetwork, Target: "evil.com:443", Decision: deny})
+	stop()
+	log.Record(Event{Source: SourceCommand, Kind: KindCommand, Target: "git push", Decision: deny})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2 (repeats written, none after stop):\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line is not a JSON event: %v\n%s", err, line)
+		}
+		if e.Source != SourceProxy || e.Target != "evil.com:443" || e.Time.IsZero() || e.Decision.Rule != deny.Rule {
+			t.Errorf("event = %+v", e)
+		}
+	}
+}
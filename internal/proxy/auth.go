@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// proxyAuthRealm is the realm of the HTTP proxy's Basic authentication.
+const proxyAuthRealm = "fence"
+
+// Credentials are the username and password clients must present to use
+// the proxies with network.proxyAuth. They are generated for each session
+// and given only to the sandboxed command, so other local processes cannot
+// use the proxies and inherit its allowlist.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// NewCredentials generates credentials for one session.
+func NewCredentials() *Credentials {
+	return &Credentials{Username: "fence", Password: rand.Text()}
+}
+
+// Userinfo returns the credentials as "username:password", the form they
+// take in proxy URLs.
+func (c *Credentials) Userinfo() string {
+	return c.Username + ":" + c.Password
+}
+
+// matches reports whether user and password are c's, in constant time.
+func (c *Credentials) matches(user, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.Username))
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(c.Password))
+	return userOK&passwordOK == 1
+}
+
+// authorizes reports whether r carries c in its Proxy-Authorization header.
+// A nil *Credentials authorizes every request.
+func (c *Credentials) authorizes(r *http.Request) bool {
+	if c == nil {
+		return true
+	}
+	// BasicAuth parses Authorization, which has the same syntax
+	user, password, ok := (&http.Request{Header: http.Header{"Authorization": r.Header.Values("Proxy-Authorization")}}).BasicAuth()
+	return ok && c.matches(user, password)
+}
+
+// socksCredentials is a socks5.CredentialStore accepting only creds.
+type socksCredentials struct {
+	creds *Credentials
+	debug bool
+}
+
+func (s socksCredentials) Valid(user, password, userAddr string) bool {
+	if s.creds.matches(user, password) {
+		return true
+	}
+	if s.debug {
+		logging.Debugf("socks", "Rejected client %s: wrong proxy credentials", userAddr)
+	}
+	return false
+}
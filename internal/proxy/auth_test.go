@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewCredentials(t *testing.T) {
+	a, b := NewCredentials(), NewCredentials()
+	if a.Password == "" || a.Password == b.Password {
+		t.Errorf("NewCredentials() passwords %q and %q, want distinct random ones", a.Password, b.Password)
+	}
+	if u, err := url.Parse("http://" + a.Userinfo() + "@localhost:1"); err != nil || u.User.Username() != a.Username {
+		t.Errorf("Userinfo() = %q does not fit in a proxy URL: %v", a.Userinfo(), err)
+	}
+}
+
+func TestHTTPProxyCredentials(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("the credentials were forwarded to the target")
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer target.Close()
+
+	creds := NewCredentials()
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetCredentials(creds)
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	tests := []struct {
+		name string
+		user *url.Userinfo
+		want int
+	}{
+		{name: "session credentials", user: url.UserPassword(creds.Username, creds.Password), want: http.StatusOK},
+		{name: "no credentials", want: http.StatusProxyAuthRequired},
+		{name: "wrong password", user: url.UserPassword(creds.Username, "guess"), want: http.StatusProxyAuthRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", port), User: tt.user}
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			resp, err := client.Get(target.URL)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusProxyAuthRequired && resp.Header.Get("Proxy-Authenticate") == "" {
+				t.Error("407 response has no Proxy-Authenticate header")
+			}
+		})
+	}
+
+	// Tunnels need the credentials too
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target.Listener.Addr(), target.Listener.Addr())
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("unauthenticated CONNECT = %v, %v; want 407", resp, err)
+	}
+}
+
+func TestSOCKSProxyCredentials(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = target.Close() }()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, "hello\n")
+			_ = conn.Close()
+		}
+	}()
+	targetPort := target.Addr().(*net.TCPAddr).Port
+
+	creds := NewCredentials()
+	p := NewSOCKSProxy(func(string, int) bool { return true }, false, false)
+	p.SetCredentials(creds)
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	tests := []struct {
+		name    string
+		user    *url.Userinfo
+		wantErr bool
+	}{
+		{name: "session credentials", user: url.UserPassword(creds.Username, creds.Password)},
+		{name: "no credentials", wantErr: true},
+		{name: "wrong password", user: url.UserPassword(creds.Username, "guess"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL := &url.URL{Scheme: "socks5", Host: fmt.Sprintf("127.0.0.1:%d", port), User: tt.user}
+			conn, err := net.Dial("tcp", proxyURL.Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+			err = socksConnect(conn, proxyURL, "127.0.0.1", targetPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("socksConnect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+				t.Errorf("read %q, %v; want the target's greeting", line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn describes a connection the proxies opened for the sandbox.
+type Conn struct {
+	Target   string    `json:"target"` // "host:port" as the sandbox asked for it
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytesIn"`  // Received from the target
+	BytesOut int64     `json:"bytesOut"` // Sent to the target
+}
+
+// ConnTracker keeps track of the connections the proxies have open, and
+// how many each host has had, for live monitoring. The proxies share one
+// tracker. A nil ConnTracker tracks nothing.
+type ConnTracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	open   map[uint64]*trackedConn
+	opened map[string]int // Connections opened per host
+}
+
+// NewConnTracker returns an empty tracker.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{open: make(map[uint64]*trackedConn), opened: make(map[string]int)}
+}
+
+// Open returns the open connections, oldest first.
+func (t *ConnTracker) Open() []Conn {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	conns := make([]Conn, 0, len(t.open))
+	for _, c := range t.open {
+		conns = append(conns, Conn{
+			Target:   c.target,
+			Started:  c.started,
+			BytesIn:  c.in.Load(),
+			BytesOut: c.out.Load(),
+		})
+	}
+	t.mu.Unlock()
+	slices.SortFunc(conns, func(a, b Conn) int { return a.Started.Compare(b.Started) })
+	return conns
+}
+
+// Opened returns the number of connections opened to each host so far.
+func (t *ConnTracker) Opened() map[string]int {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int, len(t.opened))
+	for host, n := range t.opened {
+		counts[host] = n
+	}
+	return counts
+}
+
+// track wraps a connection to addr, "host:port", so it is listed by Open
+// until it is closed.
+func (t *ConnTracker) track(conn net.Conn, addr string) net.Conn {
+	if t == nil {
+		return conn
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	c := &trackedConn{Conn: conn, tracker: t, id: t.nextID, target: addr, started: time.Now()}
+	t.open[c.id] = c
+	t.opened[host]++
+	return c
+}
+
+// trackedConn counts a connection's traffic and removes it from its
+// tracker when closed.
+type trackedConn struct {
+	net.Conn
+	tracker   *ConnTracker
+	id        uint64
+	target    string
+	started   time.Time
+	in, out   atomic.Int64
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.tracker.mu.Lock()
+		delete(c.tracker.open, c.id)
+		c.tracker.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnTracker(t *testing.T) {
+	tracker := NewConnTracker()
+
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	conn := tracker.track(client, "example.com:443")
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(server, buf)
+		_, _ = server.Write([]byte("hi"))
+	}()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	open := tracker.Open()
+	if len(open) != 1 || open[0].Target != "example.com:443" || open[0].BytesOut != 5 || open[0].BytesIn != 2 {
+		t.Fatalf("Open() = %+v, want example.com:443 with 5 bytes out and 2 in", open)
+	}
+	_ = conn.Close()
+	_ = conn.Close()
+	if open := tracker.Open(); len(open) != 0 {
+		t.Errorf("Open() after Close = %+v, want none", open)
+	}
+	if got := tracker.Opened()["example.com"]; got != 1 {
+		t.Errorf("Opened()[example.com] = %d, want 1", got)
+	}
+}
+
+func TestNilConnTracker(t *testing.T) {
+	var tracker *ConnTracker
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	if conn := tracker.track(client, "example.com:443"); conn != client {
+		t.Error("a nil tracker should not wrap connections")
+	}
+	if tracker.Open() != nil || tracker.Opened() != nil {
+		t.Error("a nil tracker should have no connections")
+	}
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+const (
+	// dnsTimeout bounds each query forwarded to the upstream resolver.
+	dnsTimeout = 5 * time.Second
+
+	// DNS response codes
+	dnsRcodeFormErr  = 1
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+
+	dnsHeaderLen = 12
+)
+
+// DNSServer is a DNS resolver with domain filtering: it answers queries for
+// names the filter denies with NXDOMAIN and forwards the others upstream.
+// It speaks DNS over TCP framing (a two-byte length before each message) on
+// a stream listener, such as a Unix socket bridged into the sandbox.
+type DNSServer struct {
+	filter   FilterFunc
+	resolver *Resolver
+	listener net.Listener
+	debug    bool
+	monitor  bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewDNSServer creates a DNS server with the given filter.
+// If monitor is true, only blocked queries are logged.
+// If debug is true, all queries are logged.
+func NewDNSServer(filter FilterFunc, debug, monitor bool) *DNSServer {
+	return &DNSServer{
+		filter:  filter,
+		debug:   debug,
+		monitor: monitor,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// SetResolver makes the server forward allowed queries to r instead of the
+// host's first nameserver. It must be called before Serve.
+func (s *DNSServer) SetResolver(r *Resolver) {
+	s.resolver = r
+}
+
+// Serve answers queries on listener until Stop.
+func (s *DNSServer) Serve(listener net.Listener) {
+	s.listener = listener
+	if s.resolver == nil {
+		s.resolver = &Resolver{servers: []string{hostNameserver()}}
+	}
+	if s.debug {
+		logging.Debugf("dns", "DNS resolver listening on %s, forwarding to %s", listener.Addr(), s.resolver)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConn(conn)
+		}
+	}()
+}
+
+// Stop stops the server, closing open connections.
+// Closing them is immediate, so ctx is accepted for symmetry with HTTPProxy.
+func (s *DNSServer) Stop(_ context.Context) error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	return err
+}
+
+func (s *DNSServer) handleConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		query, err := readDNSMessage(r)
+		if err != nil {
+			return
+		}
+		resp := s.answer(query)
+		if resp == nil {
+			return
+		}
+		if err := writeDNSMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the response to query.
+func (s *DNSServer) answer(query []byte) []byte {
+	name, ok := dnsQuestion(query)
+	if !ok {
+		return dnsReply(query, dnsRcodeFormErr)
+	}
+
+	allowed := s.filter(name, 53)
+	if s.debug || (s.monitor && !allowed) {
+		timestamp := time.Now().Format("15:04:05")
+		if allowed {
+			logging.Debugf("dns", "%s %s %s %s", timestamp, output.Render(output.Allowed), name, output.Sprintf("ALLOWED"))
+		} else {
+			policy.MonitorOutput.Print(name, "dns "+name, fmt.Sprintf("[fence:dns] %s %s %s %s", timestamp, output.Render(output.Blocked), name, output.Sprintf("BLOCKED")))
+		}
+	}
+	if !allowed {
+		return dnsReply(query, dnsRcodeNXDomain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	resp, err := s.resolver.exchange(ctx, query)
+	if err != nil {
+		if s.debug {
+			logging.Debugf("dns", "Forwarding %s to %s failed: %v", name, s.resolver, err)
+		}
+		return dnsReply(query, dnsRcodeServFail)
+	}
+	return resp
+}
+
+// hostNameserver returns the first nameserver in the host's
+// /etc/resolv.conf, or 127.0.0.1:53 as the resolver library would.
+func hostNameserver() string {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err == nil {
+		for line := range strings.Lines(string(data)) {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// readDNSMessage reads a message with DNS over TCP framing.
+func readDNSMessage(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeDNSMessage writes msg with DNS over TCP framing.
+func writeDNSMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// dnsQuestion returns the lowercase name asked about in query, which must be
+// a standard query with one question.
+func dnsQuestion(query []byte) (string, bool) {
+	if _, ok := dnsQuestionEnd(query); !ok {
+		return "", false
+	}
+	var labels []string
+	for i := dnsHeaderLen; query[i] != 0; i += 1 + int(query[i]) {
+		labels = append(labels, strings.ToLower(string(query[i+1:i+1+int(query[i])])))
+	}
+	return strings.Join(labels, "."), true
+}
+
+// dnsQuestionEnd returns the offset just past query's question section.
+func dnsQuestionEnd(query []byte) (int, bool) {
+	if len(query) < dnsHeaderLen {
+		return 0, false
+	}
+	opcode := query[2] >> 3 & 0x0f
+	if query[2]&0x80 != 0 || opcode != 0 || binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return 0, false
+	}
+	i := dnsHeaderLen
+	for {
+		if i >= len(query) {
+			return 0, false
+		}
+		n := int(query[i])
+		if n == 0 {
+			break
+		}
+		// Queries have no reason to use compression pointers
+		if n > 63 || i+1+n > len(query) {
+			return 0, false
+		}
+		i += 1 + n
+	}
+	end := i + 1 + 4 // Root label, type, and class
+	if end > len(query) {
+		return 0, false
+	}
+	return end, true
+}
+
+// dnsReply returns a response to query with rcode and no answers, echoing
+// the question if query has a valid one.
+func dnsReply(query []byte, rcode byte) []byte {
+	if len(query) < dnsHeaderLen {
+		return nil
+	}
+	resp := make([]byte, dnsHeaderLen, len(query))
+	copy(resp, query[:2])
+	resp[2] = 0x80 | query[2]&0x79 // QR, and the query's opcode and RD
+	resp[3] = 0x80 | rcode         // RA
+	if end, ok := dnsQuestionEnd(query); ok {
+		resp[5] = 1
+		resp = append(resp, query[dnsHeaderLen:end]...)
+	}
+	return resp
+}
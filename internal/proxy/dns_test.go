@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dnsQuery returns a standard A query for name with the given ID.
+func dnsQuery(id uint16, name string) []byte {
+	q := binary.BigEndian.AppendUint16(nil, id)
+	q = append(q, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0) // RD, one question
+	for _, label := range strings.Split(name, ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	return append(q, 0, 0, 1, 0, 1) // Root, type A, class IN
+}
+
+// fakeAnswer marks a query as answered by an upstream resolver.
+func fakeAnswer(query []byte) []byte {
+	resp := append([]byte(nil), query...)
+	resp[2] |= 0x80
+	return append(resp, "upstream"...)
+}
+
+func TestDNSQuestion(t *testing.T) {
+	if name, ok := dnsQuestion(dnsQuery(1, "API.Example.com")); !ok || name != "api.example.com" {
+		t.Errorf("dnsQuestion() = %q, %v; want api.example.com", name, ok)
+	}
+	truncated := dnsQuery(1, "example.com")
+	if _, ok := dnsQuestion(truncated[:len(truncated)-2]); ok {
+		t.Error("dnsQuestion() accepted a truncated question")
+	}
+	response := fakeAnswer(dnsQuery(1, "example.com"))
+	if _, ok := dnsQuestion(response); ok {
+		t.Error("dnsQuestion() accepted a response")
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = upstream.Close() }()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = upstream.WriteTo(fakeAnswer(buf[:n]), addr)
+		}
+	}()
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		resp := fakeAnswer(query)
+		resp[0], resp[1] = 0, 0
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(resp)
+	}))
+	defer doh.Close()
+
+	filter := func(host string, port int) bool { return host == "allowed.com" }
+	for _, resolver := range []*Resolver{
+		{servers: []string{upstream.LocalAddr().String()}},
+		{doh: doh.URL, client: doh.Client()},
+	} {
+		t.Run(resolver.String(), func(t *testing.T) {
+			s := NewDNSServer(filter, false, false)
+			s.SetResolver(resolver)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Serve(listener)
+			defer func() { _ = s.Stop(context.Background()) }()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+			r := bufio.NewReader(conn)
+
+			tests := []struct {
+				name      string
+				wantRcode byte
+				upstream  bool
+			}{
+				{name: "allowed.com", upstream: true},
+				{name: "denied.com", wantRcode: dnsRcodeNXDomain},
+			}
+			for i, tt := range tests {
+				id := uint16(0x1000 + i)
+				if err := writeDNSMessage(conn, dnsQuery(id, tt.name)); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := readDNSMessage(r)
+				if err != nil {
+					t.Fatalf("%s: reading the response: %v", tt.name, err)
+				}
+				if got := binary.BigEndian.Uint16(resp); got != id {
+					t.Errorf("%s: response ID = %#x, want %#x", tt.name, got, id)
+				}
+				if rcode := resp[3] & 0x0f; rcode != tt.wantRcode {
+					t.Errorf("%s: rcode = %d, want %d", tt.name, rcode, tt.wantRcode)
+				}
+				if got := string(resp[len(resp)-len("upstream"):]) == "upstream"; got != tt.upstream {
+					t.Errorf("%s: answered upstream = %v, want %v", tt.name, got, tt.upstream)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// quarantineIndex lists the files in a quarantine directory, one JSON
+// policy.Download per line.
+const quarantineIndex = "index.jsonl"
+
+// DownloadInspector applies network.downloads to the responses the HTTP
+// proxy forwards: it records flagged downloads and copies them to the
+// quarantine directory, if one is configured.
+type DownloadInspector struct {
+	cfg     *config.Config
+	log     *policy.DownloadLog
+	dir     string // Quarantine directory; empty if not quarantining
+	verbose bool
+
+	mu  sync.Mutex // Serializes file numbering and index writes
+	seq int
+}
+
+// NewDownloadInspector returns an inspector for cfg's network.downloads, or
+// nil if nothing is flagged. The quarantine directory is created if needed.
+// Flagged downloads are recorded in log; when verbose is true, they are also
+// logged to stderr.
+func NewDownloadInspector(cfg *config.Config, log *policy.DownloadLog, verbose bool) (*DownloadInspector, error) {
+	if cfg == nil || !cfg.Network.Downloads.Enabled() {
+		return nil, nil
+	}
+	i := &DownloadInspector{cfg: cfg, log: log, verbose: verbose}
+
+	if dir := cfg.Network.Downloads.QuarantineDir; dir != "" {
+		if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("network.downloads.quarantineDir: %w", err)
+			}
+			dir = filepath.Join(home, rest)
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("network.downloads.quarantineDir: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("network.downloads.quarantineDir: %w", err)
+		}
+		i.dir = dir
+	}
+	return i, nil
+}
+
+// inspect returns the body to forward for resp, the response to a request
+// for rawURL, and a function to call with the number of bytes forwarded and
+// whether the transfer completed. Responses that cannot be flagged are
+// returned unchanged.
+func (i *DownloadInspector) inspect(rawURL string, resp *http.Response) (body io.Reader, done func(n int64, complete bool)) {
+	noop := func(int64, bool) {}
+	if i == nil || resp.StatusCode < 200 || resp.StatusCode > 299 || resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return resp.Body, noop
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	// Copy the body while forwarding it if it is flagged already, or may
+	// turn out to be once its size is known
+	var file *os.File
+	if i.dir != "" {
+		flagged := policy.FlagDownload(i.cfg, contentType, resp.ContentLength) != ""
+		sizeUnknown := resp.ContentLength < 0 && i.cfg.Network.Downloads.MaxSize > 0
+		if flagged || sizeUnknown {
+			f, err := os.CreateTemp(i.dir, ".partial-*")
+			if err != nil {
+				i.logf("Cannot quarantine %s: %v", rawURL, err)
+			} else {
+				file = f
+			}
+		}
+	}
+
+	body = resp.Body
+	if file != nil {
+		body = io.TeeReader(resp.Body, file)
+	}
+	return body, func(n int64, complete bool) {
+		i.finish(rawURL, contentType, n, complete, file)
+	}
+}
+
+// finish records a forwarded response if it is flagged, keeping its
+// quarantined copy, and discards the copy otherwise.
+func (i *DownloadInspector) finish(rawURL, contentType string, n int64, complete bool, file *os.File) {
+	reason := policy.FlagDownload(i.cfg, contentType, n)
+	if file != nil {
+		_ = file.Close()
+	}
+	if reason == "" {
+		if file != nil {
+			_ = os.Remove(file.Name())
+		}
+		return
+	}
+
+	d := policy.Download{
+		URL:         rawURL,
+		ContentType: contentType,
+		Size:        n,
+		Reason:      reason,
+		Complete:    complete,
+	}
+
+	i.mu.Lock()
+	if file != nil {
+		i.seq++
+		dest := filepath.Join(i.dir, fmt.Sprintf("%03d-%s", i.seq, quarantineName(rawURL)))
+		if err := os.Rename(file.Name(), dest); err != nil {
+			i.logf("Cannot quarantine %s: %v", rawURL, err)
+			_ = os.Remove(file.Name())
+		} else {
+			d.QuarantinePath = dest
+		}
+	}
+	if i.dir != "" {
+		if err := i.appendIndex(d); err != nil {
+			i.logf("Cannot update %s: %v", filepath.Join(i.dir, quarantineIndex), err)
+		}
+	}
+	i.mu.Unlock()
+
+	i.log.Record(d)
+	if d.QuarantinePath != "" {
+		i.logf("Flagged %s (%d bytes, %s), copied to %s", rawURL, n, reason, d.QuarantinePath)
+	} else {
+		i.logf("Flagged %s (%d bytes, %s)", rawURL, n, reason)
+	}
+}
+
+// appendIndex adds d to the quarantine index. Callers hold i.mu.
+func (i *DownloadInspector) appendIndex(d policy.Download) error {
+	f, err := os.OpenFile(filepath.Join(i.dir, quarantineIndex), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec // path is from the user's config
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(d); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (i *DownloadInspector) logf(format string, args ...interface{}) {
+	if i.verbose {
+		logging.Debugf("download", format, args...)
+	}
+}
+
+// quarantineName returns a safe file name for the download at rawURL, based
+// on the last element of its path.
+func quarantineName(rawURL string) string {
+	name := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		name = path.Base(u.Path)
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(name, "._") == "" {
+		return "download"
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestHTTPProxyDownloads(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/install.sh":
+			w.Header().Set("Content-Type", "application/x-sh")
+			_, _ = io.WriteString(w, "echo installing\n")
+		case "/big.tar":
+			// Flushing first sends the body chunked, so the size is only known at the end
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, strings.Repeat("x", 100))
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = io.WriteString(w, "hello")
+		}
+	}))
+	defer upstream.Close()
+
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			Downloads: config.DownloadsConfig{MaxSize: 64, FlagExecutables: true, QuarantineDir: dir},
+		},
+	}
+	log := policy.NewDownloadLog()
+	inspector, err := NewDownloadInspector(cfg, log, false)
+	if err != nil {
+		t.Fatalf("NewDownloadInspector() error = %v", err)
+	}
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetDownloadInspector(inspector)
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, path := range []string{"/hello.txt", "/install.sh", "/big.tar"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	downloads := log.Downloads()
+	if len(downloads) != 2 {
+		t.Fatalf("flagged %d downloads, want 2: %+v", len(downloads), downloads)
+	}
+	if got := downloads[0]; got.URL != upstream.URL+"/install.sh" || got.Reason != "executable content type application/x-sh" || !got.Complete {
+		t.Errorf("downloads[0] = %+v", got)
+	}
+	if got := downloads[1]; got.Size != 100 || !strings.Contains(got.Reason, "exceeds maxSize 64") {
+		t.Errorf("downloads[1] = %+v", got)
+	}
+
+	data, err := os.ReadFile(downloads[0].QuarantinePath)
+	if err != nil || string(data) != "echo installing\n" {
+		t.Errorf("quarantined copy = %q, %v", data, err)
+	}
+	if filepath.Base(downloads[1].QuarantinePath) != "002-big.tar" {
+		t.Errorf("QuarantinePath = %q, want 002-big.tar", downloads[1].QuarantinePath)
+	}
+
+	// The unflagged download leaves nothing behind but the two copies and the index
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("quarantine directory has %d entries, want 3", len(entries))
+	}
+	f, err := os.Open(filepath.Join(dir, quarantineIndex))
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("index has %d entries, want 2", lines)
+	}
+}
+
+func TestNewDownloadInspectorDisabled(t *testing.T) {
+	i, err := NewDownloadInspector(&config.Config{}, policy.NewDownloadLog(), false)
+	if err != nil || i != nil {
+		t.Errorf("NewDownloadInspector() = %v, %v; want nil, nil", i, err)
+	}
+}
+
+func TestQuarantineName(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com/pkg/tool-1.2.tar.gz": "tool-1.2.tar.gz",
+		"http://example.com/":                    "download",
+		"http://example.com/a%20b?x=1":           "a_b",
+		"http://example.com/..":                  "download",
+	}
+	for in, want := range tests {
+		if got := quarantineName(in); got != want {
+			t.Errorf("quarantineName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
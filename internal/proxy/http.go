@@ -3,6 +3,8 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,9 +14,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
 )
 
 // FilterFunc determines if a connection to host:port should be allowed.
@@ -22,13 +28,28 @@ type FilterFunc func(host string, port int) bool
 
 // HTTPProxy is an HTTP/HTTPS proxy server with domain filtering.
 type HTTPProxy struct {
-	server   *http.Server
-	listener net.Listener
-	filter   FilterFunc
-	debug    bool
-	monitor  bool
-	mu       sync.RWMutex
-	running  bool
+	server    *http.Server
+	listener  net.Listener
+	filter    FilterFunc
+	tls       *TLSEnforcer
+	sni       *SNIVerifier
+	limits    *Limiter
+	conns     *ConnTracker
+	requests  *RequestEnforcer
+	downloads *DownloadInspector
+	upstream  *Upstream
+	resolver  *net.Resolver
+	ca        *CertAuthority
+	creds     *Credentials
+	transport *http.Transport
+	debug     bool
+	monitor   bool
+	mu        sync.RWMutex
+	running   bool
+	// ctx is canceled by Stop to tear down hijacked CONNECT tunnels,
+	// which http.Server.Shutdown does not track.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewHTTPProxy creates a new HTTP proxy with the given filter.
@@ -42,17 +63,108 @@ func NewHTTPProxy(filter FilterFunc, debug, monitor bool) *HTTPProxy {
 	}
 }
 
+// SetTLSEnforcer makes the proxy apply e's network.tls rules to the
+// connections it forwards. It must be called before Start.
+func (p *HTTPProxy) SetTLSEnforcer(e *TLSEnforcer) {
+	p.tls = e
+}
+
+// SetSNIVerifier makes the proxy cut CONNECT tunnels whose TLS ClientHello
+// names a different server than the tunnel's host. It must be called before
+// Start.
+func (p *HTTPProxy) SetSNIVerifier(v *SNIVerifier) {
+	p.sni = v
+}
+
+// SetLimiter makes the proxy apply l's connection rate and bandwidth limits.
+// It must be called before Start.
+func (p *HTTPProxy) SetLimiter(l *Limiter) {
+	p.limits = l
+}
+
+// SetConnTracker makes the proxy list the connections it opens in t. It
+// must be called before Start.
+func (p *HTTPProxy) SetConnTracker(t *ConnTracker) {
+	p.conns = t
+}
+
+// SetRequestEnforcer makes the proxy apply e's network.httpRules,
+// network.uploads, and network.allowGitPush to the plain HTTP requests it
+// forwards. It must be called before Start.
+func (p *HTTPProxy) SetRequestEnforcer(e *RequestEnforcer) {
+	p.requests = e
+}
+
+// SetDownloadInspector makes the proxy apply i's network.downloads rules to
+// the plain HTTP responses it forwards. It must be called before Start.
+func (p *HTTPProxy) SetDownloadInspector(i *DownloadInspector) {
+	p.downloads = i
+}
+
+// SetUpstream makes the proxy forward allowed connections through u instead
+// of connecting directly. It must be called before Start.
+func (p *HTTPProxy) SetUpstream(u *Upstream) {
+	p.upstream = u
+}
+
+// SetResolver makes the proxy look up the hosts it connects to directly
+// with r instead of the host's resolver. It must be called before Start.
+func (p *HTTPProxy) SetResolver(r *Resolver) {
+	p.resolver = r.NetResolver()
+}
+
+// SetCertAuthority makes the proxy terminate HTTPS tunnels to hosts with
+// network.httpRules or restricted uploads using certificates from ca, so the rules apply to the
+// requests inside. The sandbox must trust ca. It must be called before Start.
+func (p *HTTPProxy) SetCertAuthority(ca *CertAuthority) {
+	p.ca = ca
+}
+
+// SetCredentials makes the proxy refuse clients that do not send c in a
+// Proxy-Authorization header. It must be called before Start.
+func (p *HTTPProxy) SetCredentials(c *Credentials) {
+	p.creds = c
+}
+
 // Start starts the HTTP proxy on a random available port.
-func (p *HTTPProxy) Start() (int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+// The context bounds startup only; use Stop to shut the proxy down.
+func (p *HTTPProxy) Start(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
 	if err != nil {
 		return 0, fmt.Errorf("failed to listen: %w", err)
 	}
+	p.Serve(listener)
 
+	addr := listener.Addr().(*net.TCPAddr)
+	p.logDebug("HTTP proxy listening on localhost:%d", addr.Port)
+	return addr.Port, nil
+}
+
+// Serve starts the HTTP proxy on listener, such as a Unix socket, instead
+// of a port of its own. Stop closes listener.
+func (p *HTTPProxy) Serve(listener net.Listener) {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	p.listener = listener
+	// Only the upstream proxy applies, not whatever the host environment names
+	p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	p.transport.Proxy = p.upstream.proxyFunc()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: p.resolver}
+	p.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return p.limits.throttle(p.conns.track(conn, addr), addr), nil
+	}
 	p.server = &http.Server{
 		Handler:           http.HandlerFunc(p.handleRequest),
 		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return p.ctx },
 	}
 
 	p.mu.Lock()
@@ -64,35 +176,43 @@ func (p *HTTPProxy) Start() (int, error) {
 			p.logDebug("HTTP proxy server error: %v", err)
 		}
 	}()
-
-	addr := listener.Addr().(*net.TCPAddr)
-	p.logDebug("HTTP proxy listening on localhost:%d", addr.Port)
-	return addr.Port, nil
 }
 
-// Stop stops the HTTP proxy.
-func (p *HTTPProxy) Stop() error {
+// Stop stops the HTTP proxy, closing open tunnels and waiting for in-flight
+// requests until ctx is done.
+func (p *HTTPProxy) Stop(ctx context.Context) error {
 	p.mu.Lock()
 	p.running = false
 	p.mu.Unlock()
 
+	if p.cancel != nil {
+		p.cancel()
+	}
 	if p.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
 		return p.server.Shutdown(ctx)
 	}
 	return nil
 }
 
-// Port returns the port the proxy is listening on.
+// Port returns the port the proxy is listening on, or 0 if it is not
+// listening on a TCP port.
 func (p *HTTPProxy) Port() int {
 	if p.listener == nil {
 		return 0
 	}
-	return p.listener.Addr().(*net.TCPAddr).Port
+	if addr, ok := p.listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
 }
 
 func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if !p.creds.authorizes(r) {
+		p.logDebug("Rejected client %s: missing or wrong proxy credentials", r.RemoteAddr)
+		w.Header().Set("Proxy-Authenticate", `Basic realm="`+proxyAuthRealm+`"`)
+		http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
 	if r.Method == http.MethodConnect {
 		p.handleConnect(w, r)
 	} else {
@@ -122,35 +242,53 @@ func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Connection blocked by network allowlist", http.StatusForbidden)
 		return
 	}
+	if !p.limits.Allow(host, port) {
+		p.logRequest("CONNECT", fmt.Sprintf("https://%s:%d", host, port), host, 429, "BLOCKED", time.Since(start))
+		http.Error(w, "Connection blocked by network.maxConnectionsPerMinute", http.StatusTooManyRequests)
+		return
+	}
 
 	p.logRequest("CONNECT", fmt.Sprintf("https://%s:%d", host, port), host, 200, "ALLOWED", time.Since(start))
 
+	// Tunnels to hosts with request rules are terminated so the rules apply
+	if p.ca != nil && p.requests.Applies(host) {
+		clientConn, err := p.hijack(w)
+		if err != nil {
+			return
+		}
+		p.intercept(clientConn, host, port)
+		return
+	}
+
 	// Connect to target
-	targetConn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), 10*time.Second)
+	targetConn, err := p.upstream.dial(r.Context(), p.resolver, host, port)
 	if err != nil {
 		p.logDebug("CONNECT dial failed: %s:%d: %v", host, port, err)
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
 	defer func() { _ = targetConn.Close() }()
-
-	// Hijack the connection
-	hijacker, ok := w.(http.Hijacker)
-	if !ok {
-		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
-		return
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	targetConn = p.limits.throttle(p.conns.track(targetConn, target), target)
+	if p.sni.Applies(host) {
+		targetConn = p.sni.wrap(targetConn, host, port)
+	}
+	if p.tls.Applies(host) {
+		targetConn = p.tls.inspect(targetConn, host, port)
 	}
 
-	clientConn, _, err := hijacker.Hijack()
+	clientConn, err := p.hijack(w)
 	if err != nil {
-		http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
 		return
 	}
 	defer func() { _ = clientConn.Close() }()
 
-	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
-		return
-	}
+	// Close both ends when the proxy stops so the copy loops below return
+	stop := context.AfterFunc(r.Context(), func() {
+		_ = clientConn.Close()
+		_ = targetConn.Close()
+	})
+	defer stop()
 
 	// Pipe data bidirectionally
 	var wg sync.WaitGroup
@@ -163,12 +301,38 @@ func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(clientConn, targetConn)
+		if _, err := io.Copy(clientConn, targetConn); errors.Is(err, errTLSPolicy) {
+			// The client has the handshake_failure alert; end its side too
+			_ = clientConn.Close()
+		}
 	}()
 
 	wg.Wait()
 }
 
+// hijack takes over the client connection of a CONNECT request and tells the
+// client the tunnel is established. On failure, the client has been sent an
+// error response or the connection is closed.
+func (p *HTTPProxy) hijack(w http.ResponseWriter) (net.Conn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return nil, errors.New("hijacking not supported")
+	}
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		_ = clientConn.Close()
+		return nil, err
+	}
+	return clientConn, nil
+}
+
 // handleHTTP handles regular HTTP proxy requests.
 func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
@@ -193,9 +357,49 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Connection blocked by network allowlist", http.StatusForbidden)
 		return
 	}
+	if !p.limits.Allow(host, port) {
+		p.logRequest(r.Method, r.RequestURI, host, 429, "BLOCKED", time.Since(start))
+		http.Error(w, "Request blocked by network.maxConnectionsPerMinute", http.StatusTooManyRequests)
+		return
+	}
+
+	// Domains with network.tls rules may not be reached over plain HTTP
+	if targetURL.Scheme != "https" && p.tls.Applies(host) && !p.tls.Check(host, port, policy.TLSHandshake{}) {
+		p.logRequest(r.Method, r.RequestURI, host, 403, "BLOCKED", time.Since(start))
+		http.Error(w, "Connection blocked by network.tls policy", http.StatusForbidden)
+		return
+	}
+
+	if d, ok := p.requests.CheckGitPush(host, port, r.Method, targetURL); !ok {
+		p.logRequest(r.Method, r.RequestURI, host, 403, "BLOCKED", time.Since(start))
+		http.Error(w, requestBlockedMessage(d), http.StatusForbidden)
+		return
+	}
+
+	// Apply network.httpRules and network.uploads: methods and paths first,
+	// then the declared body size. Bodies of unknown length are checked as
+	// they are read.
+	body := r.Body
+	var limited *limitedBody
+	if p.requests.Applies(host) {
+		if d, ok := p.requests.Check(host, port, r.Method, targetURL.Path, -1); !ok {
+			p.logRequest(r.Method, r.RequestURI, host, 403, "BLOCKED", time.Since(start))
+			http.Error(w, requestBlockedMessage(d), http.StatusForbidden)
+			return
+		}
+		if d, ok := p.requests.Check(host, port, r.Method, targetURL.Path, r.ContentLength); !ok {
+			p.logRequest(r.Method, r.RequestURI, host, 413, "BLOCKED", time.Since(start))
+			http.Error(w, requestBlockedMessage(d), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.ContentLength < 0 {
+			limited = p.requests.limitBody(r.Body, host, port, r.Method, targetURL.Path)
+			body = limited
+		}
+	}
 
 	// Create new request and copy headers
-	proxyReq, err := http.NewRequest(r.Method, r.RequestURI, r.Body)
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, r.RequestURI, body)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -212,13 +416,24 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	proxyReq.Header.Del("Proxy-Authorization")
 
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Transport: p.transport,
+		Timeout:   30 * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
+	if targetURL.Scheme == "https" && p.tls.Applies(host) {
+		transport := p.transport.Clone()
+		transport.TLSClientConfig = &tls.Config{VerifyConnection: p.tls.verifyConnection(host, port)}
+		client.Transport = transport
+	}
 
 	resp, err := client.Do(proxyReq)
+	if err != nil && limited != nil && limited.err != nil {
+		p.logRequest(r.Method, r.RequestURI, host, 413, "BLOCKED", time.Since(start))
+		http.Error(w, "Request blocked by fence policy: request body exceeds the size limit", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		p.logRequest(r.Method, r.RequestURI, host, 502, "ERROR", time.Since(start))
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
@@ -233,15 +448,22 @@ func (p *HTTPProxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	respBody, done := p.downloads.inspect(r.RequestURI, resp)
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	n, err := io.Copy(w, respBody)
+	done(n, err == nil)
 
 	p.logRequest(r.Method, r.RequestURI, host, resp.StatusCode, "ALLOWED", time.Since(start))
 }
 
+// requestBlockedMessage explains to the client why d blocked its request.
+func requestBlockedMessage(d policy.Decision) string {
+	return fmt.Sprintf("Request blocked by fence policy %s: %s", d.Rule, d.Reason)
+}
+
 func (p *HTTPProxy) logDebug(format string, args ...interface{}) {
 	if p.debug {
-		fmt.Fprintf(os.Stderr, "[fence:http] "+format+"\n", args...)
+		logging.Debugf("http", format, args...)
 	}
 }
 
@@ -259,15 +481,22 @@ func (p *HTTPProxy) logRequest(method, url, host string, status int, action stri
 		return
 	}
 
+	url = policy.Redact(url)
 	timestamp := time.Now().Format("15:04:05")
-	statusIcon := "✓"
+	statusIcon := output.Render(output.Allowed)
 	switch action {
 	case "BLOCKED":
-		statusIcon = "✗"
+		statusIcon = output.Render(output.Blocked)
 	case "ERROR":
-		statusIcon = "!"
+		statusIcon = output.Render(output.Failed)
+	}
+	line := fmt.Sprintf("[fence:http] %s %s %-7s %d %s %s (%v)", timestamp, statusIcon, method, status, host, truncateURL(url, 60), duration.Round(time.Millisecond))
+	if isBlocked {
+		// Retry loops repeat the same denial; see policy.MonitorOutput
+		policy.MonitorOutput.Print(host, fmt.Sprintf("http %s %s %d", method, url, status), line)
+		return
 	}
-	fmt.Fprintf(os.Stderr, "[fence:http] %s %s %-7s %d %s %s (%v)\n", timestamp, statusIcon, method, status, host, truncateURL(url, 60), duration.Round(time.Millisecond))
+	fmt.Fprintln(os.Stderr, line)
 }
 
 // truncateURL shortens a URL for display.
@@ -282,38 +511,99 @@ func truncateURL(url string, maxLen int) string {
 // When debug is true, logs filter rule matches to stderr.
 func CreateDomainFilter(cfg *config.Config, debug bool) FilterFunc {
 	return func(host string, port int) bool {
-		if cfg == nil {
-			// No config = deny all
-			if debug {
-				fmt.Fprintf(os.Stderr, "[fence:filter] No config, denying: %s:%d\n", host, port)
+		d := policy.EvaluateDomain(cfg, host)
+		if debug {
+			switch {
+			case d.Rule == "":
+				logging.Debugf("filter", "%s: %s:%d (%s)", d.Verdict(), host, port, d.Reason)
+			case d.Allowed:
+				logging.Debugf("filter", "Allowed by rule: %s:%d (matched %s)", host, port, d.Rule)
+			default:
+				logging.Debugf("filter", "Denied by rule: %s:%d (matched %s)", host, port, d.Rule)
 			}
-			return false
 		}
+		return d.Allowed
+	}
+}
 
-		// Check denied domains first
-		for _, denied := range cfg.Network.DeniedDomains {
-			if config.MatchesDomain(host, denied) {
-				if debug {
-					fmt.Fprintf(os.Stderr, "[fence:filter] Denied by rule: %s:%d (matched %s)\n", host, port, denied)
-				}
-				return false
+// CreateAuditFilter creates a filter for audit mode: every connection is
+// allowed, and those the config would deny are recorded in log.
+// When verbose is true, each would-be denial is also logged to stderr.
+func CreateAuditFilter(cfg *config.Config, log *policy.ViolationLog, verbose bool) FilterFunc {
+	return func(host string, port int) bool {
+		d := policy.EvaluateDomain(cfg, host)
+		if d.Warn {
+			RecordWarning(log, host, port, d)
+		} else if !d.Allowed {
+			target := net.JoinHostPort(host, strconv.Itoa(port))
+			log.Record(policy.Event{Source: policy.SourceProxy, Kind: policy.KindNetwork, Target: target, Decision: d})
+			if verbose {
+				policy.MonitorOutput.Print(d.Basis(), "audit "+target, fmt.Sprintf("[fence:audit] Would block %s:%d (%s)", host, port, d.Basis()))
 			}
 		}
+		return true
+	}
+}
 
-		// Check allowed domains
-		for _, allowed := range cfg.Network.AllowedDomains {
-			if config.MatchesDomain(host, allowed) {
-				if debug {
-					fmt.Fprintf(os.Stderr, "[fence:filter] Allowed by rule: %s:%d (matched %s)\n", host, port, allowed)
+// RecordDenials wraps filter so that every connection it denies is recorded
+// in log, along with the config rule responsible. Connections allowed with a
+// warning by a deniedDomains entry are recorded too.
+func RecordDenials(filter FilterFunc, cfg *config.Config, log *policy.ViolationLog) FilterFunc {
+	return func(host string, port int) bool {
+		if filter(host, port) {
+			if cfg != nil && len(cfg.Network.WarnDomains) > 0 {
+				if d := policy.EvaluateDomain(cfg, host); d.Warn {
+					RecordWarning(log, host, port, d)
 				}
-				return true
 			}
+			return true
 		}
+		log.Record(policy.Event{
+			Source:   policy.SourceProxy,
+			Kind:     policy.KindNetwork,
+			Target:   net.JoinHostPort(host, strconv.Itoa(port)),
+			Decision: policy.EvaluateDomain(cfg, host),
+		})
+		return false
+	}
+}
 
-		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:filter] No matching rule, denying: %s:%d\n", host, port)
+// RecordWarning records a connection to host:port that d allows only with a
+// warning, and reports it on MonitorOutput.
+func RecordWarning(log *policy.ViolationLog, host string, port int, d policy.Decision) {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	log.Record(policy.Event{Source: policy.SourceProxy, Kind: policy.KindNetwork, Target: target, Decision: d})
+	policy.MonitorOutput.Print(d.Basis(), "warn "+target, fmt.Sprintf("[fence:warn] Allowed %s (%s)", target, d.Basis()))
+}
+
+// RecordFilterDenials wraps a filter that replaces the config's domain rules,
+// such as one supplied by a library user, so that every connection it denies
+// is recorded in log. In audit mode those connections are allowed, and when
+// verbose is true, each would-be denial is also logged to stderr.
+func RecordFilterDenials(filter FilterFunc, log *policy.ViolationLog, verbose bool) FilterFunc {
+	d := policy.Deny("", "denied by custom filter")
+	return func(host string, port int) bool {
+		if filter(host, port) {
+			return true
 		}
-		return false
+		target := net.JoinHostPort(host, strconv.Itoa(port))
+		log.Record(policy.Event{Source: policy.SourceProxy, Kind: policy.KindNetwork, Target: target, Decision: d})
+		if !log.Audit() {
+			return false
+		}
+		if verbose {
+			policy.MonitorOutput.Print(d.Basis(), "audit "+target, fmt.Sprintf("[fence:audit] Would block %s:%d (%s)", host, port, d.Basis()))
+		}
+		return true
+	}
+}
+
+// CountRequests wraps filter so that every connection or request it is asked
+// about is counted in n.
+func CountRequests(filter FilterFunc, n *atomic.Int64) FilterFunc {
+	return func(host string, port int) bool {
+		n.Add(1)
+		return filter(host, port)
 	}
 }
 
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// errRequestPolicy is returned by reads from a request body cut for exceeding
+// a network.httpRules maxBodyBytes or network.uploads maxSize limit.
+var errRequestPolicy = errors.New("request body exceeds the size limit")
+
+// RequestEnforcer applies network.httpRules, network.uploads,
+// network.allowGitPush, and network.blockPublishing to the plain HTTP
+// requests the HTTP proxy forwards, and to the HTTPS requests it intercepts.
+type RequestEnforcer struct {
+	cfg     *config.Config
+	log     *policy.ViolationLog
+	verbose bool
+}
+
+// NewRequestEnforcer returns an enforcer for cfg's network.httpRules,
+// network.uploads, network.allowGitPush, and network.blockPublishing, or nil
+// if none of them restricts requests. Violations are recorded in log; in
+// audit mode they are allowed. When verbose is true, violations are also
+// logged to stderr.
+func NewRequestEnforcer(cfg *config.Config, log *policy.ViolationLog, verbose bool) *RequestEnforcer {
+	if cfg == nil || (len(cfg.Network.HTTPRules) == 0 && !cfg.Network.Uploads.Restrict && cfg.Network.AllowGitPush && !cfg.Network.BlocksPublishing()) {
+		return nil
+	}
+	return &RequestEnforcer{cfg: cfg, log: log, verbose: verbose}
+}
+
+// Applies reports whether requests to host are subject to HTTP rules,
+// including the publishing rules, or restricted uploads.
+func (e *RequestEnforcer) Applies(host string) bool {
+	return e != nil && (policy.RequiresHTTPRules(e.cfg, host) || policy.RestrictsUploads(e.cfg, host))
+}
+
+// Check reports whether a request to host:port with the given method, URL
+// path, and body size may be forwarded, recording it if it violates the
+// rules. A negative size skips the body size check. The decision explains
+// why a request is blocked.
+func (e *RequestEnforcer) Check(host string, port int, method, path string, size int64) (policy.Decision, bool) {
+	d := policy.EvaluateHTTPRequest(e.cfg, host, method, path, size)
+	if d.Warn {
+		e.warn(d, method+" "+net.JoinHostPort(host, strconv.Itoa(port))+path)
+	}
+	if d.Allowed {
+		return d, true
+	}
+	return d, e.record(d, method+" "+net.JoinHostPort(host, strconv.Itoa(port))+path)
+}
+
+// CheckGitPush reports whether a request to host:port with the given method
+// and URL may be forwarded under network.allowGitPush, recording it if it is
+// a blocked push. Unlike the other rules, this applies to every host. The
+// decision explains why a request is blocked.
+func (e *RequestEnforcer) CheckGitPush(host string, port int, method string, u *url.URL) (policy.Decision, bool) {
+	if e == nil {
+		return policy.Allow("", "no request rules"), true
+	}
+	d := policy.EvaluateGitPush(e.cfg, u.Path, u.RawQuery)
+	if d.Allowed {
+		return d, true
+	}
+	return d, e.record(d, method+" "+net.JoinHostPort(host, strconv.Itoa(port))+u.Path)
+}
+
+// record records a request to target that d denies, and reports whether it
+// may be forwarded anyway, as in audit mode.
+func (e *RequestEnforcer) record(d policy.Decision, target string) bool {
+	e.log.Record(policy.Event{
+		Source:   policy.SourceProxy,
+		Kind:     policy.KindNetwork,
+		Target:   target,
+		Decision: d,
+	})
+	if e.log.Audit() {
+		if e.verbose {
+			policy.MonitorOutput.Print(d.Rule, "http "+target, fmt.Sprintf("[fence:audit] Would block %s (%s: %s)", target, d.Rule, d.Reason))
+		}
+		return true
+	}
+	if e.verbose {
+		policy.MonitorOutput.Print(d.Rule, "http "+target, fmt.Sprintf("[fence:http] Blocked %s (%s: %s)", target, d.Rule, d.Reason))
+	}
+	return false
+}
+
+// warn records a request to target that d allows only with a warning.
+func (e *RequestEnforcer) warn(d policy.Decision, target string) {
+	e.log.Record(policy.Event{
+		Source:   policy.SourceProxy,
+		Kind:     policy.KindNetwork,
+		Target:   target,
+		Decision: d,
+	})
+	policy.MonitorOutput.Print(d.Rule, "warn http "+target, fmt.Sprintf("[fence:warn] Allowed %s (%s: %s)", target, d.Rule, d.Reason))
+}
+
+// limitBody wraps a request body to host:port whose length is not known in
+// advance, so that reading past the smallest body size limit is checked.
+// If the request violates the rules, reads fail with errRequestPolicy.
+func (e *RequestEnforcer) limitBody(body io.ReadCloser, host string, port int, method, path string) *limitedBody {
+	return &limitedBody{
+		ReadCloser: body,
+		limit:      policy.MaxBodyBytes(e.cfg, host, method),
+		exceeded: func(size int64) bool {
+			_, ok := e.Check(host, port, method, path, size)
+			return ok
+		},
+	}
+}
+
+// limitedBody is a request body that calls exceeded once more than limit
+// bytes have been read.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64 // 0 once checked or if there is no limit
+	read     int64
+	exceeded func(size int64) bool
+	err      error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		b.limit = 0
+		if !b.exceeded(b.read) {
+			b.err = errRequestPolicy
+			return 0, b.err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestHTTPProxyRequestRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			HTTPRules: []config.HTTPRule{{Domain: "127.0.0.1", Methods: []string{"GET", "POST"}, MaxBodyBytes: 16}},
+		},
+	}
+	log := policy.NewViolationLog(false)
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetRequestEnforcer(NewRequestEnforcer(cfg, log, false))
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		name       string
+		method     string
+		body       io.Reader
+		wantStatus int
+	}{
+		{"allowed method", http.MethodGet, nil, http.StatusOK},
+		{"denied method", http.MethodDelete, nil, http.StatusForbidden},
+		{"body within limit", http.MethodPost, strings.NewReader("small"), http.StatusOK},
+		{"declared body over limit", http.MethodPost, strings.NewReader(strings.Repeat("x", 64)), http.StatusRequestEntityTooLarge},
+		// Wrapping hides the length, so the body is sent chunked
+		{"chunked body over limit", http.MethodPost, io.MultiReader(strings.NewReader(strings.Repeat("x", 64))), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, upstream.URL, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s error = %v", tt.method, upstream.URL, err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	blocked := 0
+	for _, v := range log.Violations() {
+		blocked += v.Count
+	}
+	if blocked != 3 {
+		t.Errorf("recorded %d blocked requests, want 3", blocked)
+	}
+}
+
+func TestHTTPProxyUploadGuard(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			Uploads: config.UploadsConfig{Restrict: true},
+		},
+	}
+	log := policy.NewViolationLog(false)
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetRequestEnforcer(NewRequestEnforcer(cfg, log, false))
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	resp, err = client.Post(upstream.URL, "text/plain", strings.NewReader("secrets"))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if !strings.Contains(string(body), "network.uploads.allowUpload") {
+		t.Errorf("POST response = %q, want it to explain network.uploads", body)
+	}
+}
+
+func TestHTTPProxyBlocksGitPush(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	log := policy.NewViolationLog(false)
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetRequestEnforcer(NewRequestEnforcer(&config.Config{}, log, false))
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/o/r.git/info/refs?service=git-upload-pack", http.StatusOK},
+		{http.MethodPost, "/o/r.git/git-upload-pack", http.StatusOK},
+		{http.MethodGet, "/o/r.git/info/refs?service=git-receive-pack", http.StatusForbidden},
+		{http.MethodPost, "/o/r.git/git-receive-pack", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, upstream.URL+tt.path, strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s error = %v", tt.method, tt.path, err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyInterceptsTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	log := policy.NewViolationLog(false)
+	client := startInterceptingProxy(t, upstream, log, config.HTTPRule{
+		Domain: "127.0.0.1",
+		Allow:  []string{"GET /repos/**"},
+		Deny:   []string{"POST /user/keys"},
+	})
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/repos/o/r", http.StatusOK},
+		{http.MethodGet, "/orgs/o", http.StatusForbidden},
+		{http.MethodPost, "/user/keys", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, upstream.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s error = %v", tt.method, tt.path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && string(body) != tt.method+" "+tt.path {
+				t.Errorf("body = %q, want the upstream's response", body)
+			}
+		})
+	}
+
+	if n := len(log.Violations()); n != 2 {
+		t.Errorf("recorded %d violations, want 2", n)
+	}
+}
+
+func TestHTTPProxyInterceptedRequestLimits(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	log := policy.NewViolationLog(false)
+	client := startInterceptingProxy(t, upstream, log, config.HTTPRule{
+		Domain:       "127.0.0.1",
+		Methods:      []string{"GET", "POST"},
+		MaxBodyBytes: 16,
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		body       io.Reader
+		wantStatus int
+	}{
+		{"allowed method", http.MethodGet, nil, http.StatusOK},
+		{"denied method", http.MethodPut, nil, http.StatusForbidden},
+		{"body within limit", http.MethodPost, strings.NewReader("small"), http.StatusOK},
+		{"declared body over limit", http.MethodPost, strings.NewReader(strings.Repeat("x", 64)), http.StatusRequestEntityTooLarge},
+		{"chunked body over limit", http.MethodPost, io.MultiReader(strings.NewReader(strings.Repeat("x", 64))), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, upstream.URL, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s error = %v", tt.method, upstream.URL, err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// startInterceptingProxy starts an HTTP proxy that terminates TLS to
+// upstream and applies rule to the requests inside, and returns a client
+// that sends its requests through it, trusting the proxy's CA.
+func startInterceptingProxy(t *testing.T, upstream *httptest.Server, log *policy.ViolationLog, rule config.HTTPRule) *http.Client {
+	t.Helper()
+	cfg := &config.Config{
+		Network: config.NetworkConfig{InspectTLS: true, HTTPRules: []config.HTTPRule{rule}},
+	}
+	ca, err := NewCertAuthority()
+	if err != nil {
+		t.Fatalf("NewCertAuthority() error = %v", err)
+	}
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetRequestEnforcer(NewRequestEnforcer(cfg, log, false))
+	p.SetCertAuthority(ca)
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+	// The proxy must trust the test server's certificate in turn
+	p.transport.TLSClientConfig = upstream.Client().Transport.(*http.Transport).TLSClientConfig
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertPEM())
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	transport := &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
@@ -1,11 +1,21 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
 )
 
 func TestTruncateURL(t *testing.T) {
@@ -280,7 +290,7 @@ func TestHTTPProxyStartStop(t *testing.T) {
 	filter := func(host string, port int) bool { return true }
 	proxy := NewHTTPProxy(filter, false, false)
 
-	port, err := proxy.Start()
+	port, err := proxy.Start(context.Background())
 	if err != nil {
 		t.Fatalf("Start() error = %v", err)
 	}
@@ -293,7 +303,7 @@ func TestHTTPProxyStartStop(t *testing.T) {
 		t.Errorf("Port() = %d, want %d", proxy.Port(), port)
 	}
 
-	if err := proxy.Stop(); err != nil {
+	if err := proxy.Stop(context.Background()); err != nil {
 		t.Errorf("Stop() error = %v", err)
 	}
 }
@@ -306,3 +316,149 @@ func TestHTTPProxyPortBeforeStart(t *testing.T) {
 		t.Errorf("Port() before Start() = %d, want 0", proxy.Port())
 	}
 }
+
+func TestHTTPProxyStartCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	proxy := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	if _, err := proxy.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Start() with canceled context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestHTTPProxyStopClosesTunnels(t *testing.T) {
+	// Upstream server that holds connections open
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = upstream.Close() }()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	proxy := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	port, err := proxy.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	target := upstream.Addr().String()
+	fmt.Fprintf(client, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	status, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || !strings.Contains(status, "200") {
+		t.Fatalf("CONNECT failed: %q, %v", status, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := proxy.Stop(ctx); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected tunnel to be closed by Stop, got %v", err)
+	}
+}
+
+func TestCreateAuditFilter(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			AllowedDomains: []string{"github.com"},
+			DeniedDomains:  []string{"evil.com"},
+		},
+	}
+	log := policy.NewViolationLog(true)
+	filter := CreateAuditFilter(cfg, log, false)
+
+	for _, host := range []string{"github.com", "evil.com", "example.com", "example.com"} {
+		if !filter(host, 443) {
+			t.Errorf("audit filter blocked %s", host)
+		}
+	}
+
+	events := log.Violations()
+	if len(events) != 2 {
+		t.Fatalf("got %d audit events, want 2: %+v", len(events), events)
+	}
+	if events[0].Target != "evil.com:443" || events[0].Decision.Rule != `network.deniedDomains "evil.com"` {
+		t.Errorf("events[0] = %+v", events[0])
+	}
+	if events[1].Target != "example.com:443" || events[1].Count != 2 {
+		t.Errorf("events[1] = %+v", events[1])
+	}
+}
+
+func TestRecordDenials(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			AllowedDomains: []string{"github.com"},
+		},
+	}
+	log := policy.NewViolationLog(false)
+	filter := RecordDenials(CreateDomainFilter(cfg, false), cfg, log)
+
+	if !filter("github.com", 443) {
+		t.Error("github.com should be allowed")
+	}
+	if filter("example.com", 80) {
+		t.Error("example.com should be blocked")
+	}
+
+	events := log.Violations()
+	if len(events) != 1 || events[0].Target != "example.com:80" || events[0].Kind != policy.KindNetwork {
+		t.Errorf("Violations() = %+v, want one example.com:80 entry", events)
+	}
+}
+
+func TestRecordFilterDenials(t *testing.T) {
+	allowGitHub := func(host string, port int) bool { return host == "github.com" }
+
+	log := policy.NewViolationLog(false)
+	filter := RecordFilterDenials(allowGitHub, log, false)
+	if !filter("github.com", 443) {
+		t.Error("github.com should be allowed")
+	}
+	if filter("example.com", 443) {
+		t.Error("example.com should be blocked")
+	}
+	events := log.Violations()
+	if len(events) != 1 || events[0].Target != "example.com:443" || events[0].Decision.Reason != "denied by custom filter" {
+		t.Errorf("Violations() = %+v, want one example.com:443 entry", events)
+	}
+
+	audit := policy.NewViolationLog(true)
+	filter = RecordFilterDenials(allowGitHub, audit, false)
+	if !filter("example.com", 443) {
+		t.Error("audit mode should allow example.com")
+	}
+	if events := audit.Violations(); len(events) != 1 || events[0].Target != "example.com:443" {
+		t.Errorf("audit: Violations() = %+v, want one example.com:443 entry", events)
+	}
+}
+
+func TestCountRequests(t *testing.T) {
+	var n atomic.Int64
+	filter := CountRequests(func(host string, port int) bool { return host == "github.com" }, &n)
+
+	if !filter("github.com", 443) || filter("example.com", 443) || filter("example.com", 80) {
+		t.Error("CountRequests should not change the filter's decisions")
+	}
+	if got := n.Load(); got != 3 {
+		t.Errorf("counted %d requests, want 3 (allowed and denied)", got)
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Limiter applies network.maxConnectionsPerMinute and network.maxBandwidthKbps.
+// The proxies share one limiter, so the limits cover the session as a whole.
+type Limiter struct {
+	perMinute int
+	bandwidth *tokenBucket
+	log       *policy.ViolationLog
+	verbose   bool
+
+	mu     sync.Mutex
+	recent []time.Time // Connections allowed in the last minute, oldest first
+}
+
+// NewLimiter returns a limiter for cfg's limits, or nil if there are none.
+// Connections over the rate limit and throttled connections are recorded in
+// log; in audit mode they are neither refused nor slowed. When verbose is
+// true, they are also logged to stderr.
+func NewLimiter(cfg *config.Config, log *policy.ViolationLog, verbose bool) *Limiter {
+	if cfg == nil || (cfg.Network.MaxConnectionsPerMinute <= 0 && cfg.Network.MaxBandwidthKbps <= 0) {
+		return nil
+	}
+	l := &Limiter{perMinute: cfg.Network.MaxConnectionsPerMinute, log: log, verbose: verbose}
+	if kbps := cfg.Network.MaxBandwidthKbps; kbps > 0 {
+		l.bandwidth = newTokenBucket(float64(kbps) * 1000 / 8)
+	}
+	return l
+}
+
+// Allow reports whether a connection or request to host:port is within the
+// connection rate limit, counting it if it is and recording it if not.
+func (l *Limiter) Allow(host string, port int) bool {
+	if l == nil || l.perMinute <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(l.recent) && !l.recent[i].After(cutoff) {
+		i++
+	}
+	l.recent = l.recent[i:]
+	allowed := len(l.recent) < l.perMinute
+	if allowed {
+		l.recent = append(l.recent, now)
+	}
+	l.mu.Unlock()
+	if allowed {
+		return true
+	}
+
+	d := policy.Deny("network.maxConnectionsPerMinute", fmt.Sprintf("more than %d connections in a minute", l.perMinute))
+	return l.record(host, port, d)
+}
+
+// record records that a connection to host:port exceeded a limit, and
+// reports whether it may continue anyway because the log is in audit mode.
+func (l *Limiter) record(host string, port int, d policy.Decision) bool {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	l.log.Record(policy.Event{
+		Source:   policy.SourceProxy,
+		Kind:     policy.KindNetwork,
+		Target:   target,
+		Decision: d,
+	})
+	if l.log.Audit() {
+		if l.verbose {
+			policy.MonitorOutput.Print(d.Rule, "limit "+target, fmt.Sprintf("[fence:audit] Would limit %s:%d (%s: %s)", host, port, d.Rule, d.Reason))
+		}
+		return true
+	}
+	if l.verbose {
+		policy.MonitorOutput.Print(d.Rule, "limit "+target, fmt.Sprintf("[fence:limit] Limited %s:%d (%s: %s)", host, port, d.Rule, d.Reason))
+	}
+	return false
+}
+
+// throttle wraps a connection to addr, "host:port", so its traffic counts
+// against the bandwidth limit. The first time the connection has to wait,
+// it is recorded.
+func (l *Limiter) throttle(conn net.Conn, addr string) net.Conn {
+	if l == nil || l.bandwidth == nil {
+		return conn
+	}
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	return &throttledConn{Conn: conn, limiter: l, host: host, port: port, closed: make(chan struct{})}
+}
+
+// throttledConn is a connection whose reads and writes are paced by its
+// limiter's token bucket.
+type throttledConn struct {
+	net.Conn
+	limiter    *Limiter
+	host       string
+	port       int
+	recordOnce sync.Once
+	audit      bool // The log was in audit mode when the connection was first limited
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	p = p[:min(len(p), c.limiter.bandwidth.burst)]
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if werr := c.wait(n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.limiter.bandwidth.burst)]
+		if err := c.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *throttledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// wait blocks until n bytes may pass, or the connection is closed.
+func (c *throttledConn) wait(n int) error {
+	delay := c.limiter.bandwidth.take(n)
+	if delay <= 0 {
+		return nil
+	}
+	c.recordOnce.Do(func() {
+		d := policy.Deny("network.maxBandwidthKbps", "throttled to the bandwidth limit")
+		c.audit = c.limiter.record(c.host, c.port, d)
+	})
+	if c.audit {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+// tokenBucket paces traffic to a rate in bytes per second, allowing bursts
+// of up to one second's worth.
+type tokenBucket struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(int(rate), 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// take takes n bytes' worth of tokens and returns how long to wait before
+// sending them. The bucket may go into debt, so concurrent callers queue up.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestNewLimiter(t *testing.T) {
+	if l := NewLimiter(&config.Config{}, policy.NewViolationLog(false), false); l != nil {
+		t.Errorf("NewLimiter() without limits = %v, want nil", l)
+	}
+	var l *Limiter
+	if !l.Allow("example.com", 443) {
+		t.Error("nil Limiter should allow everything")
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	cfg := &config.Config{Network: config.NetworkConfig{MaxConnectionsPerMinute: 2}}
+	for _, audit := range []bool{false, true} {
+		log := policy.NewViolationLog(audit)
+		l := NewLimiter(cfg, log, false)
+		for i := range 2 {
+			if !l.Allow("example.com", 443) {
+				t.Fatalf("audit %v: connection %d refused, want allowed within the limit", audit, i+1)
+			}
+		}
+		if got := l.Allow("example.com", 443); got != audit {
+			t.Errorf("audit %v: third connection allowed = %v, want %v", audit, got, audit)
+		}
+		if got := len(log.Violations()); got != 1 {
+			t.Errorf("audit %v: recorded %d violations, want 1", audit, got)
+		}
+	}
+}
+
+func TestLimiterAllowWindow(t *testing.T) {
+	cfg := &config.Config{Network: config.NetworkConfig{MaxConnectionsPerMinute: 1}}
+	l := NewLimiter(cfg, policy.NewViolationLog(false), false)
+	if !l.Allow("example.com", 443) {
+		t.Fatal("first connection refused")
+	}
+	// Age the connection out of the window
+	l.recent[0] = l.recent[0].Add(-time.Minute)
+	if !l.Allow("example.com", 443) {
+		t.Error("connection refused after the window passed")
+	}
+}
+
+func TestThrottledConn(t *testing.T) {
+	// 8 kbps is 1000 bytes per second, with a burst of 1000 bytes
+	cfg := &config.Config{Network: config.NetworkConfig{MaxBandwidthKbps: 8}}
+	log := policy.NewViolationLog(false)
+	l := NewLimiter(cfg, log, false)
+
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	conn := l.throttle(client, "example.com:443")
+	defer func() { _ = conn.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 1500)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("1500 bytes at 1000 bytes/s took %v, want about 500ms", elapsed)
+	}
+	if got := len(log.Violations()); got != 1 {
+		t.Errorf("recorded %d violations, want 1 for the throttled connection", got)
+	}
+}
+
+func TestHTTPProxyConnectionLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := &config.Config{Network: config.NetworkConfig{MaxConnectionsPerMinute: 1}}
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetLimiter(NewLimiter(cfg, policy.NewViolationLog(false), false))
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = p.Stop(context.Background()) }()
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}
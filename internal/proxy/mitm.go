@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// caValidity bounds how long a session CA and the certificates it issues
+// are valid. Sessions are expected to end well before.
+const caValidity = 7 * 24 * time.Hour
+
+// discardLog silences the errors of intercepted connections, such as clients
+// that reject the certificate, which the proxy's own logging already covers.
+var discardLog = log.New(io.Discard, "", 0)
+
+// CertAuthority is a per-session certificate authority the HTTP proxy uses
+// to terminate TLS for the hosts it inspects. Its key never leaves fence's
+// memory; only the certificate is given to the sandbox to trust.
+type CertAuthority struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+
+	mu    sync.Mutex
+	leafs map[string]*tls.Certificate // By host
+}
+
+// NewCertAuthority generates a certificate authority for one session.
+func NewCertAuthority() (*CertAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fence session CA", Organization: []string{"fence"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertAuthority{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		leafs:   make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// CertPEM returns the CA certificate, PEM encoded, for the sandbox to trust.
+func (ca *CertAuthority) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// certificate returns a certificate for host signed by the CA, issuing it
+// on first use.
+func (ca *CertAuthority) certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if leaf, ok := ca.leafs[host]; ok {
+		return leaf, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    ca.cert.NotBefore,
+		NotAfter:     ca.cert.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", host, err)
+	}
+	leaf := &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}
+	ca.leafs[host] = leaf
+	return leaf, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+	return serial, nil
+}
+
+// intercept terminates the client's TLS connection, tunneled by CONNECT to
+// host:port, with a certificate from the CA, and serves the HTTP requests
+// inside it as if they had been sent to the proxy for https://host:port, so
+// that they are filtered and checked against network.httpRules. It returns
+// once the client closes the connection or the proxy stops.
+func (p *HTTPProxy) intercept(clientConn net.Conn, host string, port int) {
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return p.ca.certificate(host)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+	conn := &closeNotifyConn{Conn: tlsConn, closed: make(chan struct{})}
+
+	// The client's Host header is ignored: the request goes where it CONNECTed
+	authority := net.JoinHostPort(host, strconv.Itoa(port))
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RequestURI = "https://" + authority + r.URL.RequestURI()
+			p.handleHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          discardLog,
+		BaseContext:       func(net.Listener) context.Context { return p.ctx },
+	}
+	_ = server.Serve(&singleConnListener{conn: conn})
+
+	select {
+	case <-conn.closed:
+	case <-p.ctx.Done():
+		_ = conn.Close()
+	}
+}
+
+// singleConnListener is a net.Listener that accepts conn once.
+type singleConnListener struct {
+	conn net.Conn
+	done bool
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if l.done {
+		return nil, net.ErrClosed
+	}
+	l.done = true
+	return l.conn, nil
+}
+
+func (l *singleConnListener) Close() error   { return nil }
+func (l *singleConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// closeNotifyConn is a connection that closes closed when it is closed.
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.closed) })
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// Resolver sends DNS queries to the resolvers in network.dns instead of the
+// host's, for hosts whose own DNS is broken or captive: nameservers, tried in
+// order, or a DNS-over-HTTPS endpoint.
+type Resolver struct {
+	servers []string // "host:port"
+	doh     string   // https:// DNS-over-HTTPS URL
+	client  *http.Client
+}
+
+// NewResolver returns the resolver for cfg, or nil if it sets no resolvers
+// and the host's are used. A DNS-over-HTTPS endpoint is reached through
+// upstream like other HTTPS traffic, and its host is looked up with cfg's
+// nameservers if there are any.
+func NewResolver(cfg config.DNSConfig, upstream *Upstream) (*Resolver, error) {
+	if !cfg.Configured() {
+		return nil, nil
+	}
+	r := &Resolver{doh: cfg.DoH}
+	for _, server := range cfg.Servers {
+		addr, err := config.ParseNameserver(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network.dns.servers: %w", err)
+		}
+		r.servers = append(r.servers, addr)
+	}
+	if r.doh != "" {
+		dialer := &net.Dialer{Timeout: dnsTimeout}
+		if len(r.servers) > 0 {
+			dialer.Resolver = (&Resolver{servers: r.servers}).NetResolver()
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = upstream.proxyFunc()
+		transport.DialContext = dialer.DialContext
+		r.client = &http.Client{Transport: transport, Timeout: dnsTimeout}
+	}
+	return r, nil
+}
+
+// String describes where queries go.
+func (r *Resolver) String() string {
+	if r == nil {
+		return "host resolver"
+	}
+	if r.doh != "" {
+		return r.doh
+	}
+	return strings.Join(r.servers, ", ")
+}
+
+// NetResolver returns a net.Resolver that looks names up with r, or nil, the
+// host's resolver, if r is nil.
+func (r *Resolver) NetResolver() *net.Resolver {
+	if r == nil {
+		return nil
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		// The Go resolver speaks DNS over TCP framing on connections that
+		// are not net.PacketConns, so each one is served in memory
+		Dial: func(_ context.Context, _, _ string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go r.serveConn(server)
+			return client, nil
+		},
+	}
+}
+
+func (r *Resolver) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	br := bufio.NewReader(conn)
+	for {
+		query, err := readDNSMessage(br)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+		resp, err := r.exchange(ctx, query)
+		cancel()
+		if err != nil {
+			resp = dnsReply(query, dnsRcodeServFail)
+		}
+		if err := writeDNSMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// exchange sends query to the DNS-over-HTTPS endpoint, or to each nameserver
+// in turn until one answers, and returns the response.
+func (r *Resolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if r.doh != "" {
+		return r.exchangeHTTPS(ctx, query)
+	}
+	var errs []error
+	for _, server := range r.servers {
+		resp, err := exchangeUDP(ctx, server, query)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// exchangeUDP sends query to server over UDP, retrying over TCP if the
+// response is truncated.
+func exchangeUDP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray datagrams that don't answer this query
+		if n < dnsHeaderLen || !bytes.Equal(buf[:2], query[:2]) {
+			continue
+		}
+		// Truncated: the full response needs TCP
+		if buf[2]&0x02 != 0 {
+			return exchangeTCP(ctx, server, query)
+		}
+		return buf[:n], nil
+	}
+}
+
+func exchangeTCP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := writeDNSMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readDNSMessage(bufio.NewReader(conn))
+}
+
+// exchangeHTTPS sends query to the DNS-over-HTTPS endpoint (RFC 8484).
+func (r *Resolver) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.doh, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS resolver returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < dnsHeaderLen {
+		return nil, errors.New("DNS-over-HTTPS response too short")
+	}
+	// Resolvers may answer with ID 0, so restore the query's
+	copy(body[:2], query[:2])
+	return body, nil
+}
+
+// lookupIP returns an address for name, preferring IPv4 as
+// net.ResolveIPAddr does, using resolver or the host's if it is nil.
+func lookupIP(ctx context.Context, resolver *net.Resolver, name string) (net.IP, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	return addrs[0].IP, nil
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// answerA answers A queries for any name with ip, and others with no answers.
+func answerA(query []byte, ip net.IP) []byte {
+	end, ok := dnsQuestionEnd(query)
+	if !ok {
+		return dnsReply(query, dnsRcodeFormErr)
+	}
+	resp := dnsReply(query, 0)
+	if binary.BigEndian.Uint16(query[end-4:]) != 1 {
+		return resp
+	}
+	resp[7] = 1                                  // One answer
+	resp = append(resp, 0xc0, dnsHeaderLen)      // Name: pointer to the question
+	resp = append(resp, 0, 1, 0, 1, 0, 0, 0, 60) // Type A, class IN, TTL
+	resp = append(resp, 0, 4)
+	return append(resp, ip.To4()...)
+}
+
+// nameserver starts a UDP nameserver that answers every A query with ip.
+func nameserver(t *testing.T, ip net.IP) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(answerA(buf[:n], ip), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNewResolver(t *testing.T) {
+	if r, err := NewResolver(config.DNSConfig{Search: []string{"corp.example"}}, nil); err != nil || r != nil {
+		t.Errorf("NewResolver() without servers = %v, %v; want nil, the host's resolver", r, err)
+	}
+	r, err := NewResolver(config.DNSConfig{Servers: []string{"1.1.1.1", "dns.corp:5353"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.String(), "1.1.1.1:53, dns.corp:5353"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if _, err := NewResolver(config.DNSConfig{Servers: []string{"1.1.1.1:0"}}, nil); err == nil {
+		t.Error("NewResolver() accepted an invalid nameserver")
+	}
+}
+
+func TestResolverFailover(t *testing.T) {
+	// Nothing answers on a closed port, so the query falls through to the next server
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.LocalAddr().String()
+	_ = dead.Close()
+
+	r := &Resolver{servers: []string{deadAddr, nameserver(t, net.IPv4(192, 0, 2, 7))}}
+	ip, err := lookupIP(context.Background(), r.NetResolver(), "fence.test.")
+	if err != nil {
+		t.Fatalf("lookupIP() error = %v", err)
+	}
+	if !ip.Equal(net.IPv4(192, 0, 2, 7)) {
+		t.Errorf("lookupIP() = %v, want 192.0.2.7", ip)
+	}
+}
+
+func TestResolverServFail(t *testing.T) {
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.LocalAddr().String()
+	_ = dead.Close()
+
+	r := &Resolver{servers: []string{deadAddr}}
+	if _, err := lookupIP(context.Background(), r.NetResolver(), "fence.test."); err == nil {
+		t.Error("lookupIP() succeeded without a reachable nameserver")
+	}
+}
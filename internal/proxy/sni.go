@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// TLS ClientHello constants (RFC 8446, RFC 6066).
+const (
+	handshakeTypeClientHello = 0x01
+	extServerName            = 0x0000
+	serverNameTypeHostName   = 0x00
+)
+
+// errSNIMismatch is returned by writes to a tunnel cut because the client's
+// ClientHello named a different server than the one it connected to.
+var errSNIMismatch = errors.New("connection blocked: TLS server name does not match the tunnel's host")
+
+// SNIVerifier checks that the server name in the TLS ClientHello sent through
+// a tunnel matches the host the tunnel was opened to. Without the check, a
+// client allowed to reach one domain on a CDN could reach any other domain
+// served by the same CDN by naming it in the SNI (domain fronting).
+type SNIVerifier struct {
+	log     *policy.ViolationLog
+	verbose bool
+}
+
+// NewSNIVerifier returns a verifier that records mismatches in log; in audit
+// mode they are allowed. When verbose is true, mismatches are also logged to
+// stderr.
+func NewSNIVerifier(log *policy.ViolationLog, verbose bool) *SNIVerifier {
+	return &SNIVerifier{log: log, verbose: verbose}
+}
+
+// Applies reports whether tunnels to host are checked. Tunnels opened to an
+// IP address are not: the rules allowed everything served there.
+func (v *SNIVerifier) Applies(host string) bool {
+	return v != nil && host != "" && net.ParseIP(host) == nil
+}
+
+// Check reports whether a tunnel to host:port whose ClientHello named sni may
+// continue, recording it if it may not. A ClientHello without a server name
+// matches any host.
+func (v *SNIVerifier) Check(host string, port int, sni string) bool {
+	if sni == "" || strings.EqualFold(strings.TrimSuffix(sni, "."), strings.TrimSuffix(host, ".")) {
+		return true
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	d := policy.Deny("", fmt.Sprintf("TLS server name %q does not match %s", sni, host))
+	v.log.Record(policy.Event{
+		Source:   policy.SourceProxy,
+		Kind:     policy.KindNetwork,
+		Target:   target,
+		Decision: d,
+	})
+	if v.log.Audit() {
+		if v.verbose {
+			policy.MonitorOutput.Print(host, "sni "+target, fmt.Sprintf("[fence:audit] Would block %s:%d (%s)", host, port, d.Reason))
+		}
+		return true
+	}
+	if v.verbose {
+		policy.MonitorOutput.Print(host, "sni "+target, fmt.Sprintf("[fence:sni] Blocked %s:%d (%s)", host, port, d.Reason))
+	}
+	return false
+}
+
+// wrap wraps a connection to host:port so the client's first writes are held
+// until its ClientHello is complete and checked. If the server names differ,
+// the connection is closed, writes fail with errSNIMismatch, and reads return
+// a handshake_failure alert for the client.
+func (v *SNIVerifier) wrap(conn net.Conn, host string, port int) net.Conn {
+	return &sniConn{Conn: conn, check: func(sni string) bool {
+		return v.Check(host, port, sni)
+	}}
+}
+
+// sniConn is a server connection whose client ClientHello is checked by check.
+type sniConn struct {
+	net.Conn
+	check   func(sni string) bool
+	checked bool
+	held    []byte // Client bytes not yet written to Conn
+	cut     atomic.Bool
+	alerted bool
+}
+
+func (c *sniConn) Write(p []byte) (int, error) {
+	if c.checked {
+		if c.cut.Load() {
+			return 0, errSNIMismatch
+		}
+		return c.Conn.Write(p)
+	}
+	c.held = append(c.held, p...)
+	sni, more := clientHelloSNI(c.held)
+	if more {
+		return len(p), nil
+	}
+	c.checked = true
+	if !c.check(sni) {
+		c.cut.Store(true)
+		_ = c.Conn.Close()
+		return 0, errSNIMismatch
+	}
+	held := c.held
+	c.held = nil
+	if _, err := c.Conn.Write(held); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *sniConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil && c.cut.Load() {
+		if !c.alerted {
+			c.alerted = true
+			return copy(p, handshakeFailureAlert), nil
+		}
+		return 0, errSNIMismatch
+	}
+	return n, err
+}
+
+// clientHelloSNI returns the server name in the ClientHello at the start of
+// data, the first bytes a client sent, or "" if it has none. It returns more
+// if data ends before the ClientHello does. Data that is not a TLS handshake
+// has no server name.
+func clientHelloSNI(data []byte) (sni string, more bool) {
+	if len(data) > 0 && data[0] != recordTypeHandshake {
+		return "", false
+	}
+	var handshake []byte
+	for {
+		if len(data) < 5 {
+			return "", true
+		}
+		if data[0] != recordTypeHandshake || data[1] != 0x03 {
+			return "", false
+		}
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(handshake)+length > maxHandshakeBytes {
+			return "", false
+		}
+		if len(data) < 5+length {
+			return "", true
+		}
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+
+		// The ClientHello is the first handshake message; wait until it is complete
+		if len(handshake) < 4 {
+			continue
+		}
+		if handshake[0] != handshakeTypeClientHello {
+			return "", false
+		}
+		msgLen := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) < 4+msgLen {
+			continue
+		}
+		return parseClientHelloSNI(handshake[4 : 4+msgLen]), false
+	}
+}
+
+// parseClientHelloSNI extracts the host name from a ClientHello body's
+// server_name extension, or returns "" if there is none or the body is
+// malformed.
+func parseClientHelloSNI(body []byte) string {
+	// legacy_version(2) random(32)
+	if len(body) < 34 {
+		return ""
+	}
+	rest := body[34:]
+	// session_id<0..32> cipher_suites<2..2^16-2> compression_methods<1..2^8-1>
+	for _, lenBytes := range []int{1, 2, 1} {
+		if len(rest) < lenBytes {
+			return ""
+		}
+		n := int(rest[0])
+		if lenBytes == 2 {
+			n = int(binary.BigEndian.Uint16(rest))
+		}
+		if len(rest) < lenBytes+n {
+			return ""
+		}
+		rest = rest[lenBytes+n:]
+	}
+
+	if len(rest) < 2 {
+		return "" // No extensions
+	}
+	extLen := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+extLen {
+		return ""
+	}
+	exts := rest[2 : 2+extLen]
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts[0:2])
+		n := int(binary.BigEndian.Uint16(exts[2:4]))
+		if len(exts) < 4+n {
+			return ""
+		}
+		ext := exts[4 : 4+n]
+		exts = exts[4+n:]
+		if typ != extServerName {
+			continue
+		}
+		// server_name_list<1..2^16-1>, of name_type(1) and HostName<1..2^16-1>
+		if len(ext) < 2 {
+			return ""
+		}
+		list := ext[2:]
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(binary.BigEndian.Uint16(list[1:3]))
+			if len(list) < 3+nameLen {
+				return ""
+			}
+			if nameType == serverNameTypeHostName {
+				return string(list[3 : 3+nameLen])
+			}
+			list = list[3+nameLen:]
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// clientHello returns the first bytes a TLS client sends to serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: serverName}).Handshake() //nolint:gosec // never completes
+		_ = client.Close()
+	}()
+
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatalf("reading the ClientHello: %v", err)
+		}
+		data = append(data, buf[:n]...)
+		if _, more := clientHelloSNI(data); !more {
+			return data
+		}
+	}
+}
+
+func TestClientHelloSNI(t *testing.T) {
+	hello := clientHello(t, "api.example.com")
+	if sni, more := clientHelloSNI(hello); sni != "api.example.com" || more {
+		t.Errorf("clientHelloSNI() = %q, %v; want api.example.com", sni, more)
+	}
+	if _, more := clientHelloSNI(hello[:len(hello)-1]); !more {
+		t.Error("clientHelloSNI() of a partial ClientHello should ask for more")
+	}
+	if sni, more := clientHelloSNI([]byte("SSH-2.0-OpenSSH_9.6\r\n")); sni != "" || more {
+		t.Errorf("clientHelloSNI() of a non-TLS stream = %q, %v; want no name", sni, more)
+	}
+}
+
+func TestSNIVerifierCheck(t *testing.T) {
+	v := NewSNIVerifier(policy.NewViolationLog(false), false)
+	tests := []struct {
+		host, sni string
+		want      bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com.", true},
+		{"api.example.com", "", true},
+		{"api.example.com", "evil.example.net", false},
+	}
+	for _, tt := range tests {
+		if got := v.Check(tt.host, 443, tt.sni); got != tt.want {
+			t.Errorf("Check(%q, %q) = %v, want %v", tt.host, tt.sni, got, tt.want)
+		}
+	}
+	if v.Applies("192.0.2.1") {
+		t.Error("Applies() to an IP address, want only names")
+	}
+}
+
+func TestHTTPProxySNIMismatch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		name           string
+		serverName     string
+		audit          bool
+		wantOK         bool
+		wantViolations int
+	}{
+		{"matching server name", "localhost", false, true, 0},
+		{"fronted server name", "evil.example.net", false, false, 1},
+		{"fronted server name in audit mode", "evil.example.net", true, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := policy.NewViolationLog(tt.audit)
+			p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+			p.SetSNIVerifier(NewSNIVerifier(log, false))
+			proxyPort, err := p.Start(context.Background())
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			defer func() { _ = p.Stop(context.Background()) }()
+
+			proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(proxyPort)}
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true}, //nolint:gosec // test server certificate
+			}}
+			resp, err := client.Get("https://localhost:" + port)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("GET error = %v, wantOK %v", err, tt.wantOK)
+			}
+			if got := len(log.Violations()); got != tt.wantViolations {
+				t.Errorf("recorded %d violations, want %d", got, tt.wantViolations)
+			}
+		})
+	}
+}
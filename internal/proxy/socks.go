@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"os"
+	"strconv"
 	"time"
 
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
 	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
 )
 
 // SOCKSProxy is a SOCKS5 proxy server with domain filtering.
@@ -15,6 +19,15 @@ type SOCKSProxy struct {
 	server   *socks5.Server
 	listener net.Listener
 	filter   FilterFunc
+	tls      *TLSEnforcer
+	sni      *SNIVerifier
+	limits   *Limiter
+	conns    *ConnTracker
+	upstream *Upstream
+	resolver *net.Resolver
+	creds    *Credentials
+	relay    *udpRelay
+	udp      bool
 	debug    bool
 	monitor  bool
 	port     int
@@ -31,76 +44,207 @@ func NewSOCKSProxy(filter FilterFunc, debug, monitor bool) *SOCKSProxy {
 	}
 }
 
+// SetTLSEnforcer makes the proxy apply e's network.tls rules to the
+// connections it forwards. It must be called before Start.
+func (p *SOCKSProxy) SetTLSEnforcer(e *TLSEnforcer) {
+	p.tls = e
+}
+
+// SetSNIVerifier makes the proxy cut connections to domains whose TLS
+// ClientHello names a different server than the requested domain. It must be
+// called before Start.
+func (p *SOCKSProxy) SetSNIVerifier(v *SNIVerifier) {
+	p.sni = v
+}
+
+// SetLimiter makes the proxy apply l's connection rate and bandwidth limits.
+// It must be called before Start.
+func (p *SOCKSProxy) SetLimiter(l *Limiter) {
+	p.limits = l
+}
+
+// SetConnTracker makes the proxy list the connections it opens in t. It
+// must be called before Start.
+func (p *SOCKSProxy) SetConnTracker(t *ConnTracker) {
+	p.conns = t
+}
+
+// SetUpstream makes the proxy forward allowed connections through u instead
+// of connecting directly. It must be called before Start.
+func (p *SOCKSProxy) SetUpstream(u *Upstream) {
+	p.upstream = u
+}
+
+// SetResolver makes the proxy look up the hosts it connects to directly
+// with r instead of the host's resolver. It must be called before Start.
+func (p *SOCKSProxy) SetResolver(r *Resolver) {
+	p.resolver = r.NetResolver()
+}
+
+// SetCredentials makes the proxy require SOCKS5 username and password
+// authentication with c. It must be called before Start.
+func (p *SOCKSProxy) SetCredentials(c *Credentials) {
+	p.creds = c
+}
+
+// SetUDPRelay makes the proxy relay UDP ASSOCIATE sessions, filtering each
+// datagram's destination like a CONNECT. Without it, UDP ASSOCIATE is
+// answered with "command not supported". It must be called before Start.
+func (p *SOCKSProxy) SetUDPRelay(enabled bool) {
+	p.udp = enabled
+}
+
 // fenceRuleSet implements socks5.RuleSet for domain filtering.
 type fenceRuleSet struct {
 	filter  FilterFunc
+	limits  *Limiter
 	debug   bool
 	monitor bool
 }
 
 func (r *fenceRuleSet) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	// The relay filters each datagram's destination instead
+	if req.Command == statute.CommandAssociate {
+		return ctx, true
+	}
+
 	host := req.DestAddr.FQDN
 	if host == "" {
 		host = req.DestAddr.IP.String()
 	}
 	port := req.DestAddr.Port
 
-	allowed := r.filter(host, port)
+	allowed := r.filter(host, port) && r.limits.Allow(host, port)
+	logSOCKS("CONNECT", host, port, allowed, r.debug, r.monitor)
+	return ctx, allowed
+}
 
-	shouldLog := r.debug || (r.monitor && !allowed)
-	if shouldLog {
-		timestamp := time.Now().Format("15:04:05")
-		if allowed {
-			fmt.Fprintf(os.Stderr, "[fence:socks] %s ✓ CONNECT %s:%d ALLOWED\n", timestamp, host, port)
-		} else {
-			fmt.Fprintf(os.Stderr, "[fence:socks] %s ✗ CONNECT %s:%d BLOCKED\n", timestamp, host, port)
-		}
+// logSOCKS logs a filtering decision for a SOCKS command.
+func logSOCKS(command, host string, port int, allowed, debug, monitor bool) {
+	if !debug && (!monitor || allowed) {
+		return
+	}
+	timestamp := time.Now().Format("15:04:05")
+	if allowed {
+		logging.Debugf("socks", "%s %s %s %s:%d %s", timestamp, output.Render(output.Allowed), command, host, port, output.Sprintf("ALLOWED"))
+	} else {
+		target := net.JoinHostPort(host, strconv.Itoa(port))
+		policy.MonitorOutput.Print(host, "socks "+target, fmt.Sprintf("[fence:socks] %s %s %s %s:%d %s", timestamp, output.Render(output.Blocked), command, host, port, output.Sprintf("BLOCKED")))
 	}
-	return ctx, allowed
 }
 
 // Start starts the SOCKS5 proxy on a random available port.
-func (p *SOCKSProxy) Start() (int, error) {
+// The context bounds startup only; use Stop to shut the proxy down.
+func (p *SOCKSProxy) Start(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// Create listener first to get a random port
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
 	if err != nil {
 		return 0, fmt.Errorf("failed to listen: %w", err)
 	}
-	p.listener = listener
 	p.port = listener.Addr().(*net.TCPAddr).Port
+	if p.udp {
+		p.relay, err = newUDPRelay(ctx, p.filter, p.resolver, p.debug, p.monitor)
+		if err != nil {
+			_ = listener.Close()
+			return 0, err
+		}
+	}
+	p.Serve(listener)
+
+	if p.debug {
+		logging.Debugf("socks", "SOCKS5 proxy listening on localhost:%d", p.port)
+	}
+	return p.port, nil
+}
 
-	server := socks5.NewServer(
+// Serve starts the SOCKS5 proxy on listener, such as a Unix socket, instead
+// of a port of its own. Stop closes listener.
+func (p *SOCKSProxy) Serve(listener net.Listener) {
+	p.listener = listener
+	opts := []socks5.Option{
 		socks5.WithRule(&fenceRuleSet{
 			filter:  p.filter,
+			limits:  p.limits,
 			debug:   p.debug,
 			monitor: p.monitor,
 		}),
-	)
-	p.server = server
+		socks5.WithDialAndRequest(p.dial),
+		socks5.WithResolver(p.upstream.resolver(p.resolver)),
+		socks5.WithAssociateHandle(p.handleAssociate),
+	}
+	if p.creds != nil {
+		opts = append(opts, socks5.WithCredential(socksCredentials{creds: p.creds, debug: p.debug}))
+	}
+	p.server = socks5.NewServer(opts...)
 
 	go func() {
 		if err := p.server.Serve(p.listener); err != nil {
 			if p.debug {
-				fmt.Fprintf(os.Stderr, "[fence:socks] Server error: %v\n", err)
+				logging.Debugf("socks", "Server error: %v", err)
 			}
 		}
 	}()
+}
 
-	if p.debug {
-		fmt.Fprintf(os.Stderr, "[fence:socks] SOCKS5 proxy listening on localhost:%d\n", p.port)
+// dial connects to the request's destination, through the upstream proxy if
+// there is one, checking the client's TLS server name against the destination
+// domain and inspecting the server's TLS handshake when the domain has
+// network.tls rules.
+func (p *SOCKSProxy) dial(ctx context.Context, network, addr string, req *socks5.Request) (net.Conn, error) {
+	host := req.DestAddr.FQDN
+	if host == "" {
+		host = req.DestAddr.IP.String()
 	}
-	return p.port, nil
+	var conn net.Conn
+	var err error
+	if p.upstream.For(host) != nil {
+		conn, err = p.upstream.dial(ctx, p.resolver, host, req.DestAddr.Port)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(req.DestAddr.Port))
+	conn = p.limits.throttle(p.conns.track(conn, target), target)
+	if host := req.DestAddr.FQDN; p.sni.Applies(host) {
+		conn = p.sni.wrap(conn, host, req.DestAddr.Port)
+	}
+	if host := req.DestAddr.FQDN; p.tls.Applies(host) {
+		conn = p.tls.inspect(conn, host, req.DestAddr.Port)
+	}
+	return conn, nil
 }
 
-// Stop stops the SOCKS5 proxy.
-func (p *SOCKSProxy) Stop() error {
+// Stop stops the SOCKS5 proxy from accepting new connections.
+// Closing the listener is immediate, so ctx is accepted for symmetry with HTTPProxy.
+func (p *SOCKSProxy) Stop(_ context.Context) error {
+	if p.relay != nil {
+		_ = p.relay.Close()
+	}
 	if p.listener != nil {
 		return p.listener.Close()
 	}
 	return nil
 }
 
-// Port returns the port the proxy is listening on.
+// Port returns the port the proxy is listening on, or 0 if it is not
+// listening on a TCP port.
 func (p *SOCKSProxy) Port() int {
 	return p.port
 }
+
+// UDPPort returns the port the proxy relays UDP ASSOCIATE datagrams on, or 0
+// if the UDP relay is disabled.
+func (p *SOCKSProxy) UDPPort() int {
+	if p.relay == nil {
+		return 0
+	}
+	return p.relay.Port()
+}
@@ -1,9 +1,15 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
+	"io"
 	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/things-go/go-socks5"
 	"github.com/things-go/go-socks5/statute"
@@ -102,7 +108,7 @@ func TestSOCKSProxyStartStop(t *testing.T) {
 	filter := func(host string, port int) bool { return true }
 	proxy := NewSOCKSProxy(filter, false, false)
 
-	port, err := proxy.Start()
+	port, err := proxy.Start(context.Background())
 	if err != nil {
 		t.Fatalf("Start() error = %v", err)
 	}
@@ -115,7 +121,7 @@ func TestSOCKSProxyStartStop(t *testing.T) {
 		t.Errorf("Port() = %d, want %d", proxy.Port(), port)
 	}
 
-	if err := proxy.Stop(); err != nil {
+	if err := proxy.Stop(context.Background()); err != nil {
 		t.Errorf("Stop() error = %v", err)
 	}
 }
@@ -128,3 +134,161 @@ func TestSOCKSProxyPortBeforeStart(t *testing.T) {
 		t.Errorf("Port() before Start() = %d, want 0", proxy.Port())
 	}
 }
+
+func TestSOCKSProxyServeUnixSocket(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = target.Close() }()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "hello\n")
+		_ = conn.Close()
+	}()
+
+	socket := filepath.Join(t.TempDir(), "socks.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewSOCKSProxy(func(string, int) bool { return true }, false, false)
+	proxy.Serve(ln)
+	defer func() { _ = proxy.Stop(context.Background()) }()
+	if proxy.Port() != 0 {
+		t.Errorf("Port() = %d, want 0 on a Unix socket", proxy.Port())
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := socksConnect(conn, &url.URL{Scheme: "socks5", Host: "proxy"}, "127.0.0.1", target.Addr().(*net.TCPAddr).Port); err != nil {
+		t.Fatalf("CONNECT through the socket error = %v", err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("read %q, %v; want the target's greeting", line, err)
+	}
+}
+
+func TestSOCKSProxyUDPAssociate(t *testing.T) {
+	allowed, denied := udpEcho(t), udpEcho(t)
+	filter := func(host string, port int) bool { return port == allowed.Port }
+
+	t.Run("relays allowed destinations", func(t *testing.T) {
+		p := NewSOCKSProxy(filter, false, false)
+		p.SetUDPRelay(true)
+		port, err := p.Start(context.Background())
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		defer func() { _ = p.Stop(context.Background()) }()
+
+		ctrl, rep, relay := socksAssociate(t, port)
+		defer func() { _ = ctrl.Close() }()
+		if rep != statute.RepSuccess {
+			t.Fatalf("UDP ASSOCIATE reply = %d, want success", rep)
+		}
+		if relay.Port != p.UDPPort() {
+			t.Errorf("relay port = %d, want UDPPort() = %d", relay.Port, p.UDPPort())
+		}
+
+		client, err := net.DialUDP("udp", nil, relay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+
+		for _, tt := range []struct {
+			dest *net.UDPAddr
+			want bool
+		}{{allowed, true}, {denied, false}} {
+			d, err := statute.NewDatagram(tt.dest.String(), []byte("ping"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Write(d.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			_ = client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			buf := make([]byte, 1024)
+			n, err := client.Read(buf)
+			if !tt.want {
+				if err == nil {
+					t.Errorf("datagram to denied %s was relayed", tt.dest)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("no reply from allowed %s: %v", tt.dest, err)
+			}
+			reply, err := statute.ParseDatagram(buf[:n])
+			if err != nil || string(reply.Data) != "ping" || reply.DstAddr.Port != tt.dest.Port {
+				t.Errorf("reply = %+v, %v; want ping from %s", reply, err, tt.dest)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		p := NewSOCKSProxy(filter, false, false)
+		port, err := p.Start(context.Background())
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		defer func() { _ = p.Stop(context.Background()) }()
+
+		ctrl, rep, _ := socksAssociate(t, port)
+		defer func() { _ = ctrl.Close() }()
+		if rep != statute.RepCommandNotSupported {
+			t.Errorf("UDP ASSOCIATE reply = %d, want command not supported", rep)
+		}
+		if p.UDPPort() != 0 {
+			t.Errorf("UDPPort() = %d, want 0", p.UDPPort())
+		}
+	})
+}
+
+// udpEcho starts a UDP server that echoes datagrams back to their sender.
+func udpEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// socksAssociate sends a UDP ASSOCIATE request to the SOCKS proxy on port,
+// returning the control connection, the reply code, and the relay address.
+func socksAssociate(t *testing.T, port int) (net.Conn, uint8, *net.UDPAddr) {
+	t.Helper()
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No authentication, then UDP ASSOCIATE from an unknown address
+	if _, err := conn.Write([]byte{5, 1, 0, 5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("reading the proxy's replies: %v", err)
+	}
+	relay := &net.UDPAddr{IP: net.IP(buf[6:10]), Port: int(buf[10])<<8 | int(buf[11])}
+	return conn, buf[3], relay
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
+)
+
+const (
+	// udpIdleTimeout is how long a relayed UDP flow may go without a reply
+	// before its socket is closed.
+	udpIdleTimeout = 2 * time.Minute
+
+	// udpDialTimeout bounds the DNS lookup of a datagram's destination.
+	udpDialTimeout = 10 * time.Second
+)
+
+// udpRelay relays the datagrams of SOCKS5 UDP ASSOCIATE sessions. All
+// sessions share one local socket, so sandbox profiles only need to allow a
+// single port.
+type udpRelay struct {
+	conn     *net.UDPConn
+	filter   FilterFunc
+	resolver *net.Resolver
+	debug    bool
+	monitor  bool
+
+	mu      sync.Mutex
+	pending []*udpAssociation          // Not yet bound to a client address
+	clients map[string]*udpAssociation // By client address
+}
+
+// udpAssociation is one UDP ASSOCIATE session. It lives as long as the
+// client's control connection and is bound to the first client address that
+// sends a datagram matching the address announced in the request.
+type udpAssociation struct {
+	expect *net.UDPAddr
+	client *net.UDPAddr
+	flows  map[string]*udpFlow // By destination; nil flows were denied
+	closed bool
+}
+
+// udpFlow is the socket datagrams to one destination are relayed through.
+type udpFlow struct {
+	key    string
+	conn   net.Conn
+	header []byte // SOCKS5 UDP header prepended to replies
+}
+
+func newUDPRelay(ctx context.Context, filter FilterFunc, resolver *net.Resolver, debug, monitor bool) (*udpRelay, error) {
+	var lc net.ListenConfig
+	pc, err := lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for UDP: %w", err)
+	}
+	r := &udpRelay{
+		conn:     pc.(*net.UDPConn),
+		filter:   filter,
+		resolver: resolver,
+		debug:    debug,
+		monitor:  monitor,
+		clients:  make(map[string]*udpAssociation),
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Port returns the UDP port the relay receives client datagrams on.
+func (r *udpRelay) Port() int {
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close stops relaying. Associations end when their control connections do.
+func (r *udpRelay) Close() error {
+	return r.conn.Close()
+}
+
+func (r *udpRelay) associate(expect *net.UDPAddr) *udpAssociation {
+	a := &udpAssociation{expect: expect, flows: make(map[string]*udpFlow)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, a)
+	return a
+}
+
+func (r *udpRelay) release(a *udpAssociation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.pending {
+		if p == a {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			break
+		}
+	}
+	if a.client != nil {
+		delete(r.clients, a.client.String())
+	}
+	for _, f := range a.flows {
+		if f != nil {
+			_ = f.conn.Close()
+		}
+	}
+	a.flows = nil
+	a.closed = true
+}
+
+// lookup returns the association datagrams from addr belong to, binding the
+// oldest matching pending association if addr is new. Datagrams from
+// addresses without a control connection are dropped.
+func (r *udpRelay) lookup(addr *net.UDPAddr) *udpAssociation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.clients[addr.String()]; ok {
+		return a
+	}
+	for i, a := range r.pending {
+		if a.accepts(addr) {
+			a.client = addr
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			r.clients[addr.String()] = a
+			return a
+		}
+	}
+	return nil
+}
+
+// accepts reports whether addr matches the address the client announced.
+// RFC 1928 lets clients leave the address or port zero when they don't know it.
+func (a *udpAssociation) accepts(addr *net.UDPAddr) bool {
+	if a.expect == nil {
+		return true
+	}
+	if len(a.expect.IP) > 0 && !a.expect.IP.IsUnspecified() && !a.expect.IP.Equal(addr.IP) {
+		return false
+	}
+	return a.expect.Port == 0 || a.expect.Port == addr.Port
+}
+
+func (r *udpRelay) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		a := r.lookup(addr)
+		if a == nil {
+			continue
+		}
+		d, err := statute.ParseDatagram(buf[:n])
+		// Fragmentation is optional in RFC 1928 and not supported
+		if err != nil || d.Frag != 0 {
+			continue
+		}
+		if f := r.flow(a, d); f != nil {
+			_, _ = f.conn.Write(d.Data)
+		}
+	}
+}
+
+// flow returns the flow for d's destination, checking the filter and
+// dialing it on first use. It returns nil if the destination is denied.
+func (r *udpRelay) flow(a *udpAssociation, d statute.Datagram) *udpFlow {
+	host := d.DstAddr.FQDN
+	if host == "" {
+		host = d.DstAddr.IP.String()
+	}
+	port := d.DstAddr.Port
+	key := net.JoinHostPort(host, strconv.Itoa(port))
+
+	r.mu.Lock()
+	f, seen := a.flows[key]
+	r.mu.Unlock()
+	if seen {
+		return f
+	}
+
+	allowed := r.filter(host, port)
+	logSOCKS("UDP", host, port, allowed, r.debug, r.monitor)
+	if allowed {
+		dialer := net.Dialer{Timeout: udpDialTimeout, Resolver: r.resolver}
+		conn, err := dialer.Dial("udp", key)
+		if err != nil {
+			if r.debug {
+				logging.Debugf("socks", "UDP %s: %v", key, err)
+			}
+			return nil
+		}
+		d.Data = nil
+		f = &udpFlow{key: key, conn: conn, header: d.Header()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.closed {
+		if f != nil {
+			_ = f.conn.Close()
+		}
+		return nil
+	}
+	a.flows[key] = f
+	if f != nil {
+		go r.reply(a, f)
+	}
+	return f
+}
+
+// reply relays f's replies to the client until the flow goes idle or the
+// association ends.
+func (r *udpRelay) reply(a *udpAssociation, f *udpFlow) {
+	defer func() {
+		_ = f.conn.Close()
+		r.mu.Lock()
+		if !a.closed && a.flows[f.key] == f {
+			delete(a.flows, f.key)
+		}
+		r.mu.Unlock()
+	}()
+
+	buf := make([]byte, 64*1024)
+	n := copy(buf, f.header)
+	for {
+		_ = f.conn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		m, err := f.conn.Read(buf[n:])
+		if err != nil {
+			return
+		}
+		if _, err := r.conn.WriteToUDP(buf[:n+m], a.client); err != nil {
+			return
+		}
+	}
+}
+
+// handleAssociate answers UDP ASSOCIATE requests with the relay's address,
+// or with "command not supported" if the relay is disabled.
+func (p *SOCKSProxy) handleAssociate(_ context.Context, w io.Writer, req *socks5.Request) error {
+	if p.relay == nil {
+		if err := socks5.SendReply(w, statute.RepCommandNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return errors.New("UDP relay is disabled")
+	}
+
+	var expect *net.UDPAddr
+	if req.DestAddr != nil {
+		expect = &net.UDPAddr{IP: req.DestAddr.IP, Port: req.DestAddr.Port}
+	}
+	a := p.relay.associate(expect)
+	defer p.relay.release(a)
+
+	if err := socks5.SendReply(w, statute.RepSuccess, p.relay.conn.LocalAddr()); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+	// The association ends when the client closes the control connection
+	_, _ = io.Copy(io.Discard, req.Reader)
+	return nil
+}
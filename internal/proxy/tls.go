@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// TLS record and handshake constants (RFC 8446).
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeServerHello = 0x02
+	extSupportedVersions     = 0x002b
+	maxHandshakeBytes        = 64 * 1024
+)
+
+// errTLSPolicy is returned by reads from a tunnel cut for violating network.tls.
+var errTLSPolicy = errors.New("connection blocked by network.tls policy")
+
+// handshakeFailureAlert is a fatal TLS handshake_failure alert, sent to the
+// client when a tunnel is cut so it reports a TLS error rather than a reset.
+var handshakeFailureAlert = []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 0x28}
+
+// TLSEnforcer applies network.tls rules to proxied connections by reading the
+// server's ServerHello, which is sent in the clear in every TLS version.
+type TLSEnforcer struct {
+	cfg     *config.Config
+	log     *policy.ViolationLog
+	verbose bool
+}
+
+// NewTLSEnforcer returns an enforcer for cfg's network.tls rules, or nil if
+// there are none. Violations are recorded in log; in audit mode they are
+// allowed. When verbose is true, violations are also logged to stderr.
+func NewTLSEnforcer(cfg *config.Config, log *policy.ViolationLog, verbose bool) *TLSEnforcer {
+	if cfg == nil || len(cfg.Network.TLS) == 0 {
+		return nil
+	}
+	return &TLSEnforcer{cfg: cfg, log: log, verbose: verbose}
+}
+
+// Applies reports whether connections to host are subject to TLS rules.
+func (e *TLSEnforcer) Applies(host string) bool {
+	return e != nil && policy.RequiresTLS(e.cfg, host)
+}
+
+// Check reports whether a connection to host:port that negotiated hs may
+// continue, recording it if it violates the rules.
+func (e *TLSEnforcer) Check(host string, port int, hs policy.TLSHandshake) bool {
+	d := policy.EvaluateTLS(e.cfg, host, hs)
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	if d.Warn {
+		e.log.Record(policy.Event{Source: policy.SourceProxy, Kind: policy.KindNetwork, Target: target, Decision: d})
+		policy.MonitorOutput.Print(d.Rule, "warn tls "+target, fmt.Sprintf("[fence:warn] Allowed %s:%d (%s: %s)", host, port, d.Rule, d.Reason))
+	}
+	if d.Allowed {
+		return true
+	}
+
+	e.log.Record(policy.Event{
+		Source:   policy.SourceProxy,
+		Kind:     policy.KindNetwork,
+		Target:   target,
+		Decision: d,
+	})
+	if e.log.Audit() {
+		if e.verbose {
+			policy.MonitorOutput.Print(d.Rule, "tls "+target, fmt.Sprintf("[fence:audit] Would block %s:%d (%s: %s)", host, port, d.Rule, d.Reason))
+		}
+		return true
+	}
+	if e.verbose {
+		policy.MonitorOutput.Print(d.Rule, "tls "+target, fmt.Sprintf("[fence:tls] Blocked %s:%d (%s: %s)", host, port, d.Rule, d.Reason))
+	}
+	return false
+}
+
+// inspect wraps a connection to host:port so the first read checks the
+// server's handshake against the rules. If the connection violates them,
+// reads return a handshake_failure alert for the client and then fail with
+// errTLSPolicy.
+func (e *TLSEnforcer) inspect(conn net.Conn, host string, port int) net.Conn {
+	return &inspectConn{Conn: conn, check: func(hs policy.TLSHandshake) bool {
+		return e.Check(host, port, hs)
+	}}
+}
+
+// verifyConnection returns a tls.Config.VerifyConnection callback that checks
+// connections fence itself makes to host:port against the rules.
+func (e *TLSEnforcer) verifyConnection(host string, port int) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if !e.Check(host, port, policy.TLSHandshake{Version: cs.Version, CipherSuite: cs.CipherSuite}) {
+			return errTLSPolicy
+		}
+		return nil
+	}
+}
+
+// inspectConn is a server connection whose first read is checked by check.
+type inspectConn struct {
+	net.Conn
+	check   func(policy.TLSHandshake) bool
+	checked bool
+	err     error
+	pending []byte // Bytes to return before reading from Conn again
+}
+
+func (c *inspectConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		hs, raw, err := readServerHello(c.Conn)
+		if err != nil && len(raw) == 0 {
+			return 0, err // Server closed or failed before sending anything
+		}
+		if c.check(hs) {
+			c.pending = raw
+		} else {
+			c.pending = handshakeFailureAlert
+			c.err = errTLSPolicy
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// readServerHello reads TLS records from r until it has the ServerHello and
+// returns the negotiated version and cipher suite, along with every byte read.
+// If the data is not a TLS handshake, hs is zero and err is set.
+func readServerHello(r io.Reader) (hs policy.TLSHandshake, raw []byte, err error) {
+	var buf bytes.Buffer
+	var handshake []byte
+	header := make([]byte, 5)
+
+	for {
+		if _, err := io.ReadFull(io.TeeReader(r, &buf), header); err != nil {
+			return hs, buf.Bytes(), err
+		}
+		if header[0] != recordTypeHandshake || header[1] != 0x03 {
+			return hs, buf.Bytes(), errors.New("not a TLS handshake")
+		}
+		length := int(binary.BigEndian.Uint16(header[3:5]))
+		if len(handshake)+length > maxHandshakeBytes {
+			return hs, buf.Bytes(), errors.New("TLS handshake too large")
+		}
+		fragment := make([]byte, length)
+		if _, err := io.ReadFull(io.TeeReader(r, &buf), fragment); err != nil {
+			return hs, buf.Bytes(), err
+		}
+		handshake = append(handshake, fragment...)
+
+		// The ServerHello is the first handshake message; wait until it is complete
+		if len(handshake) < 4 {
+			continue
+		}
+		if handshake[0] != handshakeTypeServerHello {
+			return hs, buf.Bytes(), errors.New("first handshake message is not a ServerHello")
+		}
+		msgLen := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) < 4+msgLen {
+			continue
+		}
+		hs, err = parseServerHello(handshake[4 : 4+msgLen])
+		return hs, buf.Bytes(), err
+	}
+}
+
+// parseServerHello extracts the negotiated version and cipher suite from a
+// ServerHello body. TLS 1.3 reports its version in the supported_versions
+// extension; earlier versions in the legacy version field.
+func parseServerHello(body []byte) (policy.TLSHandshake, error) {
+	malformed := errors.New("malformed ServerHello")
+	var hs policy.TLSHandshake
+
+	// legacy_version(2) random(32) session_id<0..32>
+	if len(body) < 35 {
+		return hs, malformed
+	}
+	hs.Version = binary.BigEndian.Uint16(body[0:2])
+	rest := body[34:]
+	sessionIDLen := int(rest[0])
+	if len(rest) < 1+sessionIDLen+3 {
+		return policy.TLSHandshake{}, malformed
+	}
+	rest = rest[1+sessionIDLen:]
+	hs.CipherSuite = binary.BigEndian.Uint16(rest[0:2])
+	rest = rest[3:] // cipher_suite(2) compression_method(1)
+
+	if len(rest) < 2 {
+		return hs, nil // No extensions (TLS 1.2 and earlier)
+	}
+	extLen := int(binary.BigEndian.Uint16(rest[0:2]))
+	rest = rest[2:]
+	if len(rest) < extLen {
+		return policy.TLSHandshake{}, malformed
+	}
+	rest = rest[:extLen]
+	for len(rest) >= 4 {
+		typ := binary.BigEndian.Uint16(rest[0:2])
+		n := int(binary.BigEndian.Uint16(rest[2:4]))
+		if len(rest) < 4+n {
+			return policy.TLSHandshake{}, malformed
+		}
+		if typ == extSupportedVersions && n == 2 {
+			hs.Version = binary.BigEndian.Uint16(rest[4:6])
+		}
+		rest = rest[4+n:]
+	}
+	return hs, nil
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// startTLSProxy starts an HTTP proxy that allows everything and enforces a
+// minVersion 1.3 rule for 127.0.0.1, returning a client that uses it.
+func startTLSProxy(t *testing.T, log *policy.ViolationLog) *http.Client {
+	t.Helper()
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			TLS: []config.TLSRule{{Domains: []string{"127.0.0.1"}, MinVersion: "1.3"}},
+		},
+	}
+
+	p := NewHTTPProxy(func(string, int) bool { return true }, false, false)
+	p.SetTLSEnforcer(NewTLSEnforcer(cfg, log, false))
+	port, err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	proxyURL := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // test server certificate
+	}}
+}
+
+func TestHTTPProxyTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxVersion uint16
+		wantOK     bool
+	}{
+		{"meets minVersion", tls.VersionTLS13, true},
+		{"below minVersion", tls.VersionTLS12, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = &tls.Config{MaxVersion: tt.maxVersion}
+			server.StartTLS()
+			defer server.Close()
+
+			log := policy.NewViolationLog(false)
+			client := startTLSProxy(t, log)
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("GET %s error = %v, wantOK %v", server.URL, err, tt.wantOK)
+			}
+			if got := len(log.Violations()); (got == 0) != tt.wantOK {
+				t.Errorf("recorded %d violations, wantOK %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyTLSPolicyRefusesPlaintext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := startTLSProxy(t, policy.NewViolationLog(false))
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", server.URL, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestHTTPProxyTLSPolicyAudit(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	log := policy.NewViolationLog(true)
+	client := startTLSProxy(t, log)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v, want allowed in audit mode", server.URL, err)
+	}
+	_ = resp.Body.Close()
+	if len(log.Violations()) != 1 {
+		t.Errorf("recorded %d violations, want 1", len(log.Violations()))
+	}
+}
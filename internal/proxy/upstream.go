@@ -0,0 +1,353 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/things-go/go-socks5"
+)
+
+// upstreamEnvVars are the host variables an upstream proxy is taken from, in
+// order of preference. Most sandboxed traffic is HTTPS, so HTTPS_PROXY wins.
+var upstreamEnvVars = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"}
+
+// socksReplies describes the SOCKS5 reply codes (RFC 1928, section 6).
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// Upstream is an HTTP or SOCKS5 proxy the fence proxies forward allowed
+// connections through, for hosts that can only reach the internet via a
+// corporate proxy.
+type Upstream struct {
+	proxy   *url.URL
+	noProxy []string // NO_PROXY entries, for a proxy taken from the environment
+	source  string   // Where the proxy was configured, for logging
+}
+
+// NewUpstream returns the upstream proxy for cfg's network.upstreamProxy, or
+// nil if connections go direct. When upstreamProxy is unset, the proxy is
+// taken from the host's HTTPS_PROXY or HTTP_PROXY, read with getenv, and the
+// hosts in NO_PROXY are reached directly.
+func NewUpstream(cfg *config.Config, getenv func(string) string) (*Upstream, error) {
+	setting := ""
+	if cfg != nil {
+		setting = cfg.Network.UpstreamProxy
+	}
+	switch setting {
+	case config.UpstreamDirect:
+		return nil, nil
+	case "":
+	default:
+		u, err := config.ParseProxyURL(setting)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network.upstreamProxy: %w", err)
+		}
+		return &Upstream{proxy: u, source: "network.upstreamProxy"}, nil
+	}
+
+	for _, name := range upstreamEnvVars {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		u, err := config.ParseProxyURL(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot chain to the host's %s: %w (set network.upstreamProxy to an http:// or socks5:// URL, or %q)", name, err, config.UpstreamDirect)
+		}
+		noProxy := getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = getenv("no_proxy")
+		}
+		return &Upstream{proxy: u, noProxy: splitNoProxy(noProxy), source: name}, nil
+	}
+	return nil, nil
+}
+
+// String describes the upstream proxy, without its password.
+func (u *Upstream) String() string {
+	if u == nil {
+		return "direct"
+	}
+	return fmt.Sprintf("%s (from %s)", u.proxy.Redacted(), u.source)
+}
+
+// For returns the proxy to reach host through, or nil to connect directly.
+// Loopback addresses are always reached directly.
+func (u *Upstream) For(host string) *url.URL {
+	if u == nil || isLoopback(host) || matchNoProxy(u.noProxy, host) {
+		return nil
+	}
+	return u.proxy
+}
+
+// dial connects to host:port, through the upstream proxy if there is one for
+// host. Direct connections look host up with resolver, or the host's
+// resolver if it is nil; the upstream proxy resolves the names it connects to.
+func (u *Upstream) dial(ctx context.Context, resolver *net.Resolver, host string, port int) (net.Conn, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	proxyURL := u.For(host)
+	if proxyURL == nil {
+		dialer.Resolver = resolver
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		defaultPort := "80"
+		if proxyURL.Scheme != "http" {
+			defaultPort = "1080"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), defaultPort)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	// Bound the CONNECT exchange; the tunnel itself has no deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	if proxyURL.Scheme != "http" {
+		if err := socksConnect(conn, proxyURL, host, port); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)) + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s refused CONNECT %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// Servers that speak first (SSH, SMTP) may have sent data along with the
+	// proxy's response
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// socksConnect asks the SOCKS5 proxy at the other end of conn to connect to
+// host:port (RFC 1928), authenticating with proxyURL's username and password
+// if it has them (RFC 1929). Names are sent unresolved, for either scheme.
+func socksConnect(conn net.Conn, proxyURL *url.URL, host string, port int) error {
+	methods := []byte{0x00} // No authentication
+	if proxyURL.User != nil {
+		methods = append(methods, 0x02) // Username and password
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxyURL.User == nil {
+			return errors.New("SOCKS proxy requires a username and password")
+		}
+		user := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("SOCKS username and password must be at most 255 bytes")
+		}
+		msg := append([]byte{0x01, byte(len(user))}, user...)
+		msg = append(append(msg, byte(len(password))), password...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS authentication failed")
+		}
+	default:
+		return errors.New("SOCKS proxy accepts none of the offered authentication methods")
+	}
+
+	req := []byte{0x05, 0x01, 0x00} // CONNECT
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long for SOCKS", host)
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, then the bound address, which is not needed
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		reason, ok := socksReplies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("reply code %d", header[1])
+		}
+		return fmt.Errorf("refused CONNECT %s: %s", net.JoinHostPort(host, strconv.Itoa(port)), reason)
+	}
+	var addrLen int
+	switch header[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		addrLen = int(reply[0])
+	default:
+		return errors.New("malformed SOCKS reply")
+	}
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// proxyFunc returns a function for http.Transport.Proxy that sends plain
+// HTTP requests through the upstream proxy.
+func (u *Upstream) proxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		return u.For(r.URL.Hostname()), nil
+	}
+}
+
+// resolver returns a SOCKS name resolver that looks names up with resolver,
+// or the host's resolver if it is nil, and leaves names reached through the
+// upstream proxy unresolved, since hosts behind a corporate proxy often
+// cannot resolve external names themselves.
+func (u *Upstream) resolver(resolver *net.Resolver) socks5.NameResolver {
+	return upstreamResolver{u, resolver}
+}
+
+type upstreamResolver struct {
+	upstream *Upstream
+	resolver *net.Resolver
+}
+
+func (r upstreamResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if r.upstream.For(name) != nil {
+		// The SOCKS proxy dials the name itself; see SOCKSProxy.dial
+		return ctx, net.IPv4zero, nil
+	}
+	ip, err := lookupIP(ctx, r.resolver, name)
+	return ctx, ip, err
+}
+
+// bufferedConn is a net.Conn whose first reads come from r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// splitNoProxy splits a NO_PROXY value into its lowercase entries.
+func splitNoProxy(value string) []string {
+	var entries []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// matchNoProxy reports whether host is listed in NO_PROXY entries. As in
+// curl and Go, "*" matches every host, a domain matches itself and its
+// subdomains (with or without a leading dot), and addresses may be given as
+// CIDR ranges. Ports in entries are ignored.
+func matchNoProxy(entries []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, e := range entries {
+		if e == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, cidr, err := net.ParseCIDR(e); err == nil {
+				if cidr.Contains(ip) {
+					return true
+				}
+				continue
+			}
+		}
+		if h, _, err := net.SplitHostPort(e); err == nil {
+			e = h
+		}
+		e = strings.TrimPrefix(e, "*")
+		e = strings.TrimPrefix(e, ".")
+		if e != "" && (host == e || strings.HasSuffix(host, "."+e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether host is localhost or a loopback address.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
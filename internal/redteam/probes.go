@@ -0,0 +1,132 @@
+package redteam
+
+// Probe is one bypass attempt. Script is run with sh inside the sandbox and
+// exits 0 if the attempt succeeded, 1 if it was stopped, and 2 if it cannot
+// run here (e.g. no suitable tool is installed). {{workspace}}, {{outside}},
+// and {{pid}} are replaced by the scratch workspace, the scratch directory
+// outside it, and fence's process ID, shell-quoted in Script.
+type Probe struct {
+	Name        string   `json:"name"`
+	Category    string   `json:"category"` // One of the Category* constants
+	Description string   `json:"description"`
+	Script      string   `json:"-"`
+	Env         []string `json:"-"` // Added to the environment before filtering
+	// Control runs the script outside the sandbox first, so a probe that
+	// cannot succeed on this host anyway (e.g. offline) is reported as
+	// inconclusive instead of blocked.
+	Control bool `json:"-"`
+	// Evidence is a file that must contain "fence" afterwards for a success
+	// to count, so a write into a private copy of the filesystem is not a
+	// bypass.
+	Evidence string `json:"-"`
+}
+
+// Probe categories.
+const (
+	CategoryNetwork    = "network"
+	CategoryFilesystem = "filesystem"
+	CategoryProcess    = "process"
+)
+
+// Probes returns the curated set of bypass attempts. Every write targets the
+// scratch directories, so a successful bypass leaves nothing behind.
+func Probes() []Probe {
+	return []Probe{
+		{
+			Name:        "direct-ip",
+			Category:    CategoryNetwork,
+			Description: "Connect to an IP address directly, ignoring the proxy",
+			Control:     true,
+			Script: `if command -v curl >/dev/null 2>&1; then
+  curl -s -o /dev/null --noproxy '*' --connect-timeout 5 http://1.1.1.1/ && exit 0
+  exit 1
+fi
+if command -v python3 >/dev/null 2>&1; then
+  python3 -c 'import socket; socket.create_connection(("1.1.1.1", 80), 5)' 2>/dev/null && exit 0
+  exit 1
+fi
+exit 2`,
+		},
+		{
+			Name:        "dns-resolver",
+			Category:    CategoryNetwork,
+			Description: "Query a public DNS resolver over UDP port 53",
+			Control:     true,
+			Script: `if command -v dig >/dev/null 2>&1; then
+  dig @8.8.8.8 +time=3 +tries=1 example.com >/dev/null 2>&1 && exit 0
+  exit 1
+fi
+if command -v python3 >/dev/null 2>&1; then
+  python3 - <<'EOF' 2>/dev/null && exit 0
+import socket
+q = b"\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07example\x03com\x00\x00\x01\x00\x01"
+s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+s.settimeout(3)
+s.sendto(q, ("8.8.8.8", 53))
+s.recvfrom(512)
+EOF
+  exit 1
+fi
+exit 2`,
+		},
+		{
+			Name:        "dns-tunnel",
+			Category:    CategoryNetwork,
+			Description: "Leak data in the name of a lookup through the system resolver",
+			Control:     true,
+			// An answer, even "no such name", means the query left the host
+			Script: `command -v python3 >/dev/null 2>&1 || exit 2
+python3 - <<'EOF' 2>/dev/null
+import os, socket, sys
+name = os.urandom(16).hex() + ".example.com"
+try:
+    socket.getaddrinfo(name, 80)
+except socket.gaierror as e:
+    sys.exit(0 if e.errno == socket.EAI_NONAME else 1)
+EOF`,
+		},
+		{
+			Name:        "write-outside",
+			Category:    CategoryFilesystem,
+			Description: "Write a file outside the writable paths",
+			Evidence:    "{{outside}}/write-outside",
+			Script:      `echo fence > {{outside}}/write-outside 2>/dev/null`,
+		},
+		{
+			Name:        "proc-root",
+			Category:    CategoryFilesystem,
+			Description: "Write outside the writable paths through /proc/self/root",
+			Evidence:    "{{outside}}/proc-root",
+			Script: `[ -d /proc/self/root ] || exit 2
+echo fence > /proc/self/root{{outside}}/proc-root 2>/dev/null`,
+		},
+		{
+			Name:        "git-hook",
+			Category:    CategoryFilesystem,
+			Description: "Plant a git pre-commit hook in the workspace",
+			Evidence:    "{{workspace}}/.git/hooks/pre-commit",
+			Script:      `printf '#!/bin/sh\necho fence\n' > {{workspace}}/.git/hooks/pre-commit 2>/dev/null`,
+		},
+		{
+			Name:        "shell-rc",
+			Category:    CategoryFilesystem,
+			Description: "Append to a shell startup file in the workspace",
+			Evidence:    "{{workspace}}/.bashrc",
+			Script:      `echo 'echo fence' >> {{workspace}}/.bashrc 2>/dev/null`,
+		},
+		{
+			Name:        "proc-environ",
+			Category:    CategoryProcess,
+			Description: "Read fence's own environment through /proc",
+			Script: `[ -d /proc ] || exit 2
+[ -n "$(tr '\0' '\n' < /proc/{{pid}}/environ 2>/dev/null | head -n 1)" ]`,
+		},
+		{
+			Name:        "preload-env",
+			Category:    CategoryProcess,
+			Description: "Pass LD_PRELOAD or DYLD_INSERT_LIBRARIES to the command",
+			Env:         []string{"LD_PRELOAD={{workspace}}/inject.so", "DYLD_INSERT_LIBRARIES={{workspace}}/inject.dylib"},
+			Script:      `[ -n "${LD_PRELOAD:-}${DYLD_INSERT_LIBRARIES:-}" ]`,
+		},
+	}
+}
@@ -0,0 +1,209 @@
+// Package redteam implements "fence redteam", which runs a curated set of
+// bypass attempts inside a sandbox built from the user's config and reports
+// which of them the current environment actually stops.
+package redteam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// DefaultTimeout bounds each run of a probe if Options leaves it unset.
+const DefaultTimeout = 15 * time.Second
+
+// Sandbox is the part of sandbox.Manager the probes need.
+type Sandbox interface {
+	WrapCommand(ctx context.Context, command string) (string, error)
+	Env(environ []string) []string
+}
+
+// Status is the outcome of a probe.
+type Status string
+
+// Probe outcomes. Only StatusBypassed means the sandbox failed.
+const (
+	StatusBlocked      Status = "blocked"
+	StatusBypassed     Status = "bypassed"
+	StatusSkipped      Status = "skipped"
+	StatusInconclusive Status = "inconclusive"
+	StatusError        Status = "error"
+)
+
+// Result is the outcome of one probe.
+type Result struct {
+	Probe
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Scratch holds the directories the probes attack: a workspace the sandbox
+// may write to, seeded with the files fence protects, and a directory
+// outside it.
+type Scratch struct {
+	Workspace string
+	Outside   string
+}
+
+// NewScratch creates the scratch directories under the system temp dir.
+func NewScratch() (*Scratch, error) {
+	workspace, err := os.MkdirTemp("", "fence-redteam-workspace-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Scratch{Workspace: workspace}
+	if s.Outside, err = os.MkdirTemp("", "fence-redteam-outside-"); err != nil {
+		s.Remove()
+		return nil, err
+	}
+	// Fence protects these only if they exist
+	if err := os.MkdirAll(filepath.Join(workspace, ".git", "hooks"), 0o755); err != nil {
+		s.Remove()
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(workspace, ".bashrc"), nil, 0o644); err != nil { //nolint:gosec // Scratch file
+		s.Remove()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Remove deletes the scratch directories.
+func (s *Scratch) Remove() {
+	for _, dir := range []string{s.Workspace, s.Outside} {
+		if dir != "" {
+			_ = os.RemoveAll(dir)
+		}
+	}
+}
+
+// Options configure Run.
+type Options struct {
+	Scratch *Scratch
+	Timeout time.Duration // Per run of a probe; 0 is DefaultTimeout
+	// Skip maps probe names to the reason they cannot be judged here.
+	Skip  map[string]string
+	Debug bool
+}
+
+// Run runs each probe in sb, from the scratch workspace.
+func Run(ctx context.Context, sb Sandbox, probes []Probe, opts Options) []Result {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	results := make([]Result, 0, len(probes))
+	for _, p := range probes {
+		r := runProbe(ctx, sb, p, opts)
+		if opts.Debug {
+			logging.Debugf("redteam", "%s: %s %s", p.Name, r.Status, r.Detail)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func runProbe(ctx context.Context, sb Sandbox, p Probe, opts Options) Result {
+	r := Result{Probe: p}
+	if reason, ok := opts.Skip[p.Name]; ok {
+		r.Status, r.Detail = StatusSkipped, reason
+		return r
+	}
+	script := expand(p.Script, opts.Scratch, true)
+	env := os.Environ()
+	for _, e := range p.Env {
+		env = append(env, expand(e, opts.Scratch, false))
+	}
+
+	if p.Control {
+		switch code, err := run(ctx, script, env, opts); {
+		case err != nil:
+			r.Status, r.Detail = StatusError, fmt.Sprintf("control run: %v", err)
+			return r
+		case code == 2:
+			r.Status, r.Detail = StatusSkipped, "no suitable tool is installed"
+			return r
+		case code != 0:
+			r.Status, r.Detail = StatusInconclusive, "fails outside the sandbox too"
+			return r
+		}
+	}
+
+	wrapped, err := sb.WrapCommand(ctx, script)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrPolicyViolation) {
+			r.Status, r.Detail = StatusBlocked, "command policy"
+			return r
+		}
+		r.Status, r.Detail = StatusError, err.Error()
+		return r
+	}
+	code, err := run(ctx, wrapped, sb.Env(env), opts)
+	switch {
+	case err != nil:
+		r.Status, r.Detail = StatusError, err.Error()
+	case code == 2:
+		r.Status, r.Detail = StatusSkipped, "not applicable in this sandbox"
+	case code != 0:
+		r.Status = StatusBlocked
+	case p.Evidence != "" && !hasEvidence(expand(p.Evidence, opts.Scratch, false)):
+		r.Status, r.Detail = StatusBlocked, "write did not reach the host"
+	default:
+		r.Status = StatusBypassed
+	}
+	return r
+}
+
+// run runs script with sh and returns its exit code. A run that times out
+// counts as stopped.
+func run(ctx context.Context, script string, env []string, opts Options) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", script) //nolint:gosec // Curated probe script - intentional
+	cmd.Env = env
+	cmd.Dir = opts.Scratch.Workspace
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second // Don't wait on children holding the output open
+	err := cmd.Run()
+	if opts.Debug && out.Len() > 0 {
+		logging.Debugf("redteam", "%s", out.String())
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return 1, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), nil
+	default:
+		return 0, err
+	}
+}
+
+// expand replaces the placeholders in s, shell-quoting them if quote is set.
+func expand(s string, scratch *Scratch, quote bool) string {
+	vars := []string{"{{workspace}}", scratch.Workspace, "{{outside}}", scratch.Outside, "{{pid}}", strconv.Itoa(os.Getpid())}
+	if quote {
+		for i := 1; i < len(vars); i += 2 {
+			vars[i] = sandbox.ShellQuoteSingle(vars[i])
+		}
+	}
+	return strings.NewReplacer(vars...).Replace(s)
+}
+
+// hasEvidence reports whether the file at path contains "fence".
+func hasEvidence(path string) bool {
+	data, err := os.ReadFile(path) //nolint:gosec // Scratch file
+	return err == nil && bytes.Contains(data, []byte("fence"))
+}
@@ -0,0 +1,148 @@
+package redteam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// fakeSandbox runs commands unconfined, except that it drops dangerous
+// environment variables and denies commands mentioning "forbidden".
+type fakeSandbox struct{}
+
+func (fakeSandbox) WrapCommand(_ context.Context, command string) (string, error) {
+	if strings.Contains(command, "forbidden") {
+		return "", &sandbox.PolicyViolationError{Rule: "command.deny[0]=forbidden"}
+	}
+	return command, nil
+}
+
+func (fakeSandbox) Env(environ []string) []string {
+	return sandbox.FilterDangerousEnv(environ)
+}
+
+func TestRun(t *testing.T) {
+	scratch, err := NewScratch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scratch.Remove()
+
+	probes := []Probe{
+		{Name: "succeeds", Script: "exit 0"},
+		{Name: "stopped", Script: "exit 1"},
+		{Name: "not-applicable", Script: "exit 2"},
+		{Name: "offline", Control: true, Script: "exit 1"},
+		{Name: "no-tool", Control: true, Script: "exit 2"},
+		{Name: "private-write", Script: "true", Evidence: "{{outside}}/missing"},
+		{Name: "host-write", Script: "echo fence > {{outside}}/host-write", Evidence: "{{outside}}/host-write"},
+		{Name: "preload", Env: []string{"LD_PRELOAD={{workspace}}/inject.so"}, Script: `[ -n "${LD_PRELOAD:-}" ]`},
+		{Name: "in-workspace", Script: `[ "$(pwd -P)" = "$(cd {{workspace}} && pwd -P)" ]`},
+		{Name: "policy", Script: "forbidden"},
+		{Name: "slow", Script: "sleep 10"},
+		{Name: "excluded", Script: "exit 0"},
+	}
+	want := map[string]Status{
+		"succeeds":       StatusBypassed,
+		"stopped":        StatusBlocked,
+		"not-applicable": StatusSkipped,
+		"offline":        StatusInconclusive,
+		"no-tool":        StatusSkipped,
+		"private-write":  StatusBlocked,
+		"host-write":     StatusBypassed,
+		"preload":        StatusBlocked,
+		"in-workspace":   StatusBypassed,
+		"policy":         StatusBlocked,
+		"slow":           StatusBlocked,
+		"excluded":       StatusSkipped,
+	}
+
+	results := Run(context.Background(), fakeSandbox{}, probes, Options{
+		Scratch: scratch,
+		Timeout: 500 * time.Millisecond,
+		Skip:    map[string]string{"excluded": "not judged here"},
+	})
+	if len(results) != len(probes) {
+		t.Fatalf("got %d results, want %d", len(results), len(probes))
+	}
+	for _, r := range results {
+		if r.Status != want[r.Name] {
+			t.Errorf("%s: status = %s (%s), want %s", r.Name, r.Status, r.Detail, want[r.Name])
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, p := range Probes() {
+		if seen[p.Name] {
+			t.Errorf("duplicate probe %q", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Category {
+		case CategoryNetwork, CategoryFilesystem, CategoryProcess:
+		default:
+			t.Errorf("%s: unknown category %q", p.Name, p.Category)
+		}
+		if p.Description == "" || p.Script == "" {
+			t.Errorf("%s: missing description or script", p.Name)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	scratch := &Scratch{Workspace: "/tmp/my work", Outside: "/tmp/out"}
+	if got := expand("cd {{workspace}} && ls {{outside}}", scratch, true); got != "cd '/tmp/my work' && ls /tmp/out" {
+		t.Errorf("expand(quoted) = %q", got)
+	}
+	if got := expand("LD_PRELOAD={{workspace}}/x.so", scratch, false); got != "LD_PRELOAD=/tmp/my work/x.so" {
+		t.Errorf("expand(unquoted) = %q", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := Report{
+		Platform: "linux",
+		Backend:  "bwrap",
+		Results: []Result{
+			{Probe: Probe{Name: "direct-ip", Category: CategoryNetwork}, Status: StatusBlocked},
+			{Probe: Probe{Name: "git-hook", Category: CategoryFilesystem}, Status: StatusBypassed},
+			{Probe: Probe{Name: "dns-tunnel", Category: CategoryNetwork}, Status: StatusInconclusive, Detail: "fails outside the sandbox too"},
+		},
+	}
+	if r.Count(StatusBypassed) != 1 {
+		t.Errorf("Count(bypassed) = %d, want 1", r.Count(StatusBypassed))
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Backend:  bwrap", "git-hook", "inconclusive (fails outside the sandbox too)", "1 blocked, 1 bypassed, 1 inconclusive"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("report missing %q:\n%s", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Results []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Results) != 3 || decoded.Results[1].Name != "git-hook" || decoded.Results[1].Status != "bypassed" {
+		t.Errorf("JSON results = %+v", decoded.Results)
+	}
+}
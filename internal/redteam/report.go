@@ -0,0 +1,76 @@
+package redteam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/Use-Tusk/fence/internal/output"
+)
+
+// Report is the outcome of a red-team run and the environment it ran in.
+type Report struct {
+	Platform string   `json:"platform"`
+	Backend  string   `json:"backend,omitempty"`
+	Features string   `json:"features,omitempty"` // Sandboxing features detected on the host
+	Results  []Result `json:"results"`
+}
+
+// Count returns how many probes ended with status.
+func (r Report) Count(status Status) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// statusIcons maps each status to its icon: a blocked probe is a pass.
+var statusIcons = map[Status]output.Icon{
+	StatusBlocked:      output.Allowed,
+	StatusBypassed:     output.Blocked,
+	StatusSkipped:      output.Unavailable,
+	StatusInconclusive: output.Warning,
+	StatusError:        output.Failed,
+}
+
+// WriteReport writes the pass/fail matrix.
+func (r Report) WriteReport(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Platform: %s\n", r.Platform); err != nil {
+		return err
+	}
+	if r.Backend != "" {
+		fmt.Fprintf(w, "Backend:  %s\n", r.Backend)
+	}
+	if r.Features != "" {
+		fmt.Fprintf(w, "Features: %s\n", r.Features)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  \tPROBE\tCATEGORY\tRESULT\tATTEMPT")
+	for _, res := range r.Results {
+		status := string(res.Status)
+		if res.Detail != "" {
+			status += " (" + res.Detail + ")"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", output.Render(statusIcons[res.Status]), res.Name, res.Category, status, res.Description)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d blocked, %d bypassed, %d inconclusive, %d skipped, %d error(s)\n",
+		r.Count(StatusBlocked), r.Count(StatusBypassed), r.Count(StatusInconclusive), r.Count(StatusSkipped), r.Count(StatusError))
+	return err
+}
+
+// WriteJSON writes the report as a JSON document.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Linux sandbox backends. All derive their rules from the same bwrap-style
+// mount arguments; they differ in what enforces them.
+const (
+	// BackendBwrap runs the command under bubblewrap (the default).
+	BackendBwrap = "bwrap"
+	// BackendNative sets up the namespaces and mounts in fence itself, for
+	// systems where bubblewrap cannot be installed. It needs unprivileged
+	// user namespaces and the fence CLI binary.
+	BackendNative = "native"
+	// BackendGVisor runs the command under gVisor (runsc), whose user-space
+	// kernel handles its syscalls, for stronger isolation of the host kernel
+	// than seccomp gives. It needs runsc and the fence CLI binary.
+	BackendGVisor = "gvisor"
+	// BackendAppArmor confines the command with a generated AppArmor profile
+	// instead of namespaces, for systems with neither bwrap nor Landlock. It
+	// needs root to load the profile, and gives no network, PID, or /tmp
+	// isolation. It is the default when bwrap and Landlock are unavailable.
+	BackendAppArmor = "apparmor"
+	// BackendSELinux runs the command in the domain of a generated SELinux
+	// policy module, the counterpart of BackendAppArmor for SELinux systems.
+	// Its rules apply to file types rather than paths, so some path rules
+	// cannot be enforced. It needs root to load the module.
+	BackendSELinux = "selinux"
+)
+
+// Backends lists the valid backend names.
+var Backends = []string{BackendBwrap, BackendNative, BackendGVisor, BackendAppArmor, BackendSELinux}
+
+// Hidden fence flags that run the native backend inside the sandbox:
+// NativeSandboxFlag takes bwrap-style arguments and re-executes fence with
+// NativeSandboxInitFlag in new namespaces to set up the mounts.
+const (
+	NativeSandboxFlag     = "--native-sandbox"
+	NativeSandboxInitFlag = "--native-sandbox-init"
+)
+
+// GVisorSandboxFlag is the hidden fence flag that runs the gVisor backend:
+// it takes bwrap-style arguments, writes the equivalent OCI bundle, and runs
+// it with runsc.
+const GVisorSandboxFlag = "--gvisor-sandbox"
+
+// ValidateBackend returns an error if name is not a known backend.
+// The empty string selects the default.
+func ValidateBackend(name string) error {
+	if name == "" || slices.Contains(Backends, name) {
+		return nil
+	}
+	return fmt.Errorf("unknown backend %q (valid: %v)", name, Backends)
+}
@@ -88,7 +88,7 @@ func BenchmarkManagerInitialize(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		manager := NewManager(cfg, false, false)
-		if err := manager.Initialize(); err != nil {
+		if err := manager.Initialize(context.Background()); err != nil {
 			b.Fatalf("failed to initialize: %v", err)
 		}
 		manager.Cleanup()
@@ -103,14 +103,14 @@ func BenchmarkWrapCommand(b *testing.B) {
 	cfg := benchConfig(workspace)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("failed to initialize: %v", err)
 	}
 	defer manager.Cleanup()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := manager.WrapCommand("echo hello")
+		_, err := manager.WrapCommand(context.Background(), "echo hello")
 		if err != nil {
 			b.Fatalf("wrap failed: %v", err)
 		}
@@ -131,11 +131,11 @@ func BenchmarkColdSandbox_True(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		manager := NewManager(cfg, false, false)
-		if err := manager.Initialize(); err != nil {
+		if err := manager.Initialize(context.Background()); err != nil {
 			b.Fatalf("init failed: %v", err)
 		}
 
-		wrappedCmd, err := manager.WrapCommand("true")
+		wrappedCmd, err := manager.WrapCommand(context.Background(), "true")
 		if err != nil {
 			manager.Cleanup()
 			b.Fatalf("wrap failed: %v", err)
@@ -158,12 +158,12 @@ func BenchmarkWarmSandbox_True(b *testing.B) {
 	cfg := benchConfig(workspace)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
 	defer manager.Cleanup()
 
-	wrappedCmd, err := manager.WrapCommand("true")
+	wrappedCmd, err := manager.WrapCommand(context.Background(), "true")
 	if err != nil {
 		b.Fatalf("wrap failed: %v", err)
 	}
@@ -182,12 +182,12 @@ func BenchmarkWarmSandbox_Echo(b *testing.B) {
 	cfg := benchConfig(workspace)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
 	defer manager.Cleanup()
 
-	wrappedCmd, err := manager.WrapCommand("echo hello")
+	wrappedCmd, err := manager.WrapCommand(context.Background(), "echo hello")
 	if err != nil {
 		b.Fatalf("wrap failed: %v", err)
 	}
@@ -209,12 +209,12 @@ func BenchmarkWarmSandbox_Python(b *testing.B) {
 	cfg := benchConfig(workspace)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
 	defer manager.Cleanup()
 
-	wrappedCmd, err := manager.WrapCommand("python3 -c 'pass'")
+	wrappedCmd, err := manager.WrapCommand(context.Background(), "python3 -c 'pass'")
 	if err != nil {
 		b.Fatalf("wrap failed: %v", err)
 	}
@@ -233,13 +233,13 @@ func BenchmarkWarmSandbox_FileWrite(b *testing.B) {
 	cfg := benchConfig(workspace)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
 	defer manager.Cleanup()
 
 	testFile := filepath.Join(workspace, "bench.txt")
-	wrappedCmd, err := manager.WrapCommand("echo 'benchmark data' > " + testFile)
+	wrappedCmd, err := manager.WrapCommand(context.Background(), "echo 'benchmark data' > "+testFile)
 	if err != nil {
 		b.Fatalf("wrap failed: %v", err)
 	}
@@ -266,12 +266,12 @@ func BenchmarkWarmSandbox_GitStatus(b *testing.B) {
 	cfg := benchConfig(repoDir)
 
 	manager := NewManager(cfg, false, false)
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
 	defer manager.Cleanup()
 
-	wrappedCmd, err := manager.WrapCommand("git status --porcelain")
+	wrappedCmd, err := manager.WrapCommand(context.Background(), "git status --porcelain")
 	if err != nil {
 		b.Fatalf("wrap failed: %v", err)
 	}
@@ -0,0 +1,161 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// BridgeHelperFlag is the hidden fence flag that runs the in-sandbox end of
+// the bridges: fence --bridge [--debug] --forward LISTEN=DIAL... -- command...
+const BridgeHelperFlag = "--bridge"
+
+// sandboxNameserver is the address the sandbox's /etc/resolv.conf names for
+// network.filterDNS, where the bridge helper listens on port 53.
+const sandboxNameserver = "127.0.0.1"
+
+// bridgeForward is a listener inside the sandbox and the address each of its
+// connections is forwarded to. Addresses are written "tcp:HOST:PORT",
+// "udp:HOST:PORT", or "unix:PATH", and a forward as "LISTEN=DIAL". A udp
+// listener forwards each datagram as a framed message (see
+// datagramForwarder), so it can only be a LISTEN address.
+type bridgeForward struct {
+	listenNet, listenAddr string
+	dialNet, dialAddr     string
+}
+
+func (f bridgeForward) String() string {
+	return fmt.Sprintf("%s:%s=%s:%s", f.listenNet, f.listenAddr, f.dialNet, f.dialAddr)
+}
+
+// parseBridgeForward parses a forward written as "LISTEN=DIAL".
+func parseBridgeForward(spec string) (bridgeForward, error) {
+	listen, dial, ok := strings.Cut(spec, "=")
+	if !ok {
+		return bridgeForward{}, fmt.Errorf("invalid forward %q: want LISTEN=DIAL", spec)
+	}
+	var f bridgeForward
+	var err error
+	if f.listenNet, f.listenAddr, err = parseBridgeAddr(listen); err != nil {
+		return bridgeForward{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+	}
+	if f.dialNet, f.dialAddr, err = parseBridgeAddr(dial); err != nil {
+		return bridgeForward{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+	}
+	if f.dialNet == "udp" {
+		return bridgeForward{}, fmt.Errorf("invalid forward %q: cannot dial udp", spec)
+	}
+	return f, nil
+}
+
+func parseBridgeAddr(addr string) (network, address string, err error) {
+	network, address, _ = strings.Cut(addr, ":")
+	switch {
+	case network == "tcp" || network == "udp":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("address %q: %w", addr, err)
+		}
+	case network == "unix":
+		if !filepath.IsAbs(address) {
+			return "", "", fmt.Errorf("address %q: socket path must be absolute", addr)
+		}
+	default:
+		return "", "", fmt.Errorf("address %q: must start with tcp:, udp:, or unix:", addr)
+	}
+	return network, address, nil
+}
+
+// bridgeHelperArgs returns the command line that runs command under the
+// bridge helper at fenceExePath, forwarding the sandbox's proxy ports to the
+// bridge sockets and the reverse bridge sockets to the exposed ports. With
+// dns, the sandbox's port 53 is forwarded to the bridge's DNS socket.
+func bridgeHelperArgs(fenceExePath string, bridge *LinuxBridge, reverseBridge *ReverseBridge, dns, debug bool, command ...string) []string {
+	args := []string{fenceExePath, BridgeHelperFlag}
+	if debug {
+		args = append(args, "--debug")
+	}
+	var forwards []bridgeForward
+	if bridge != nil && bridge.HTTPSocketPath != "" {
+		forwards = append(forwards, bridgeForward{"tcp", "127.0.0.1:3128", "unix", bridge.HTTPSocketPath})
+	}
+	if bridge != nil && bridge.SOCKSSocketPath != "" {
+		forwards = append(forwards, bridgeForward{"tcp", "127.0.0.1:1080", "unix", bridge.SOCKSSocketPath})
+	}
+	if dns && bridge != nil && bridge.DNSSocketPath != "" {
+		forwards = append(forwards,
+			bridgeForward{"udp", sandboxNameserver + ":53", "unix", bridge.DNSSocketPath},
+			bridgeForward{"tcp", sandboxNameserver + ":53", "unix", bridge.DNSSocketPath})
+	}
+	if reverseBridge != nil {
+		for i, port := range reverseBridge.Ports {
+			forwards = append(forwards, bridgeForward{"unix", reverseBridge.SocketPaths[i], "tcp", fmt.Sprintf("127.0.0.1:%d", port)})
+		}
+	}
+	for _, f := range forwards {
+		args = append(args, "--forward", f.String())
+	}
+	args = append(args, "--")
+	return append(args, command...)
+}
+
+// StartBridgeHelper starts the forwards given as "LISTEN=DIAL" specs. It is
+// the in-sandbox end of the bridges, run by fence --bridge before it starts
+// the sandboxed command. Every listener is up when it returns, so the command
+// can connect immediately; a listener that cannot be created is an error.
+// Ambient capabilities, which the sandbox grants to bind the DNS port, are
+// dropped once the listeners are up so the command does not inherit them.
+// The returned function stops the forwards.
+func StartBridgeHelper(specs []string, debug bool) (stop func(), err error) {
+	var forwarders []interface{ Shutdown(context.Context) error }
+	stop = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+		defer cancel()
+		for _, f := range forwarders {
+			_ = f.Shutdown(ctx)
+		}
+	}
+
+	for _, spec := range specs {
+		fwd, err := parseBridgeForward(spec)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		dialNet, dialAddr := fwd.dialNet, fwd.dialAddr
+		dial := func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, dialNet, dialAddr)
+		}
+		if fwd.listenNet == "udp" {
+			conn, err := net.ListenPacket(fwd.listenNet, fwd.listenAddr)
+			if err != nil {
+				stop()
+				return nil, fmt.Errorf("failed to listen on %s:%s: %w", fwd.listenNet, fwd.listenAddr, err)
+			}
+			forwarders = append(forwarders, newDatagramForwarder(conn, fwd.String(), dial, debug))
+		} else {
+			listener, err := net.Listen(fwd.listenNet, fwd.listenAddr)
+			if err != nil {
+				stop()
+				return nil, fmt.Errorf("failed to listen on %s:%s: %w", fwd.listenNet, fwd.listenAddr, err)
+			}
+			forwarders = append(forwarders, newForwarder(listener, fwd.String(), dial, debug))
+		}
+		if debug {
+			logging.Debugf("bridge", "Forwarding %s", fwd)
+		}
+	}
+	if len(forwarders) == 0 {
+		return nil, errors.New("no forwards specified")
+	}
+	if err := clearAmbientCaps(); err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to drop capabilities: %w", err)
+	}
+	return stop, nil
+}
@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseBridgeForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"tcp:127.0.0.1:3128=unix:/tmp/fence-http.sock", false},
+		{"unix:/tmp/fence-rev-3000.sock=tcp:127.0.0.1:3000", false},
+		{"tcp:127.0.0.1:3128", true},
+		{"tcp:3128=unix:/tmp/x.sock", true},
+		{"tcp:127.0.0.1:3128=unix:relative.sock", true},
+		{"udp:127.0.0.1:53=unix:/tmp/x.sock", false},
+		{"tcp:127.0.0.1:53=udp:127.0.0.1:53", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			f, err := parseBridgeForward(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBridgeForward(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if err == nil && f.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", f.String(), tt.spec)
+			}
+		})
+	}
+}
+
+func TestBridgeHelperArgs(t *testing.T) {
+	bridge := &LinuxBridge{HTTPSocketPath: "/tmp/h.sock", SOCKSSocketPath: "/tmp/s.sock"}
+	reverse := &ReverseBridge{Ports: []int{3000}, SocketPaths: []string{"/tmp/r.sock"}}
+
+	got := bridgeHelperArgs("/usr/bin/fence", bridge, reverse, false, false, "/bin/bash", "-c")
+	want := []string{
+		"/usr/bin/fence", BridgeHelperFlag,
+		"--forward", "tcp:127.0.0.1:3128=unix:/tmp/h.sock",
+		"--forward", "tcp:127.0.0.1:1080=unix:/tmp/s.sock",
+		"--forward", "unix:/tmp/r.sock=tcp:127.0.0.1:3000",
+		"--", "/bin/bash", "-c",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("bridgeHelperArgs() =\n  %q\nwant\n  %q", got, want)
+	}
+}
+
+func TestBridgeHelperArgsSOCKSOnly(t *testing.T) {
+	bridge := &LinuxBridge{SOCKSSocketPath: "/tmp/s.sock"}
+
+	got := bridgeHelperArgs("/usr/bin/fence", bridge, nil, false, false, "/bin/bash", "-c")
+	want := []string{
+		"/usr/bin/fence", BridgeHelperFlag,
+		"--forward", "tcp:127.0.0.1:1080=unix:/tmp/s.sock",
+		"--", "/bin/bash", "-c",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("bridgeHelperArgs() =\n  %q\nwant\n  %q", got, want)
+	}
+}
+
+func TestBridgeHelperArgsDNS(t *testing.T) {
+	bridge := &LinuxBridge{SOCKSSocketPath: "/tmp/s.sock", DNSSocketPath: "/tmp/d.sock"}
+
+	got := bridgeHelperArgs("/usr/bin/fence", bridge, nil, true, false, "/bin/bash", "-c")
+	want := []string{
+		"/usr/bin/fence", BridgeHelperFlag,
+		"--forward", "tcp:127.0.0.1:1080=unix:/tmp/s.sock",
+		"--forward", "udp:127.0.0.1:53=unix:/tmp/d.sock",
+		"--forward", "tcp:127.0.0.1:53=unix:/tmp/d.sock",
+		"--", "/bin/bash", "-c",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("bridgeHelperArgs() =\n  %q\nwant\n  %q", got, want)
+	}
+
+	// Without dns, as when the sandbox shares the host's network
+	got = bridgeHelperArgs("/usr/bin/fence", bridge, nil, false, false, "/bin/bash", "-c")
+	if slices.Contains(got, "udp:127.0.0.1:53=unix:/tmp/d.sock") {
+		t.Errorf("bridgeHelperArgs() without dns = %q, want no DNS forwards", got)
+	}
+}
+
+func TestStartBridgeHelper(t *testing.T) {
+	upstream := startEchoServer(t)
+	socketPath := filepath.Join(t.TempDir(), "in.sock")
+
+	stop, err := StartBridgeHelper([]string{"unix:" + socketPath + "=tcp:" + upstream}, false)
+	if err != nil {
+		t.Fatalf("StartBridgeHelper() error = %v", err)
+	}
+	defer stop()
+
+	// The listener is up as soon as StartBridgeHelper returns
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("dial %s: %v", socketPath, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("read %q, %v; want %q", line, err, "hello\n")
+	}
+}
+
+func TestStartBridgeHelperListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	// The port is taken, so the helper must fail rather than run without it
+	if _, err := StartBridgeHelper([]string{"tcp:" + ln.Addr().String() + "=unix:/tmp/x.sock"}, false); err == nil {
+		t.Error("expected an error for a listener that cannot be created")
+	}
+}
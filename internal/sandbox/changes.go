@@ -0,0 +1,217 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// The change report compares snapshots of the paths in
+// filesystem.allowWrite taken before and after a command. Watching the
+// writes as they happen (fanotify on Linux, fs_usage on macOS) needs root;
+// a snapshot works everywhere, at the cost of a walk of the tree at each end.
+// A file created and deleted during the run is not reported, and changes
+// made by processes outside the sandbox in the meantime are.
+
+// MaxSnapshotEntries bounds the files and directories a snapshot records,
+// so a huge tree under allowWrite cannot stall the run.
+const MaxSnapshotEntries = 200000
+
+// maxReportedChanges is how many changes WriteChangeReport lists.
+const maxReportedChanges = 50
+
+// ErrSnapshotTooLarge is returned when the paths to snapshot hold more than
+// MaxSnapshotEntries files and directories.
+var ErrSnapshotTooLarge = fmt.Errorf("more than %d files under filesystem.allowWrite to track changes", MaxSnapshotEntries)
+
+// Kinds of FileChange.
+const (
+	FileCreated  = "created"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+)
+
+// FileChange is a file or directory a command created, modified, or
+// deleted. Directories are only reported when created or deleted.
+type FileChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // FileCreated, FileModified, or FileDeleted
+	Dir  bool   `json:"dir,omitempty"`
+}
+
+// fileState is what a snapshot records about a path.
+type fileState struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// FileSnapshot records the files under a set of paths.
+type FileSnapshot struct {
+	roots   []string
+	entries map[string]fileState
+}
+
+// ChangeRoots returns the paths the change report covers for cfg: the
+// existing paths in filesystem.allowWrite, without those inside another.
+func ChangeRoots(cfg *config.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	var paths []string
+	for _, p := range cfg.Filesystem.AllowWrite {
+		if ContainsGlobChars(p) {
+			paths = append(paths, ExpandGlobPatterns([]string{p})...)
+		} else {
+			paths = append(paths, NormalizePath(p))
+		}
+	}
+
+	var roots []string
+	sort.Strings(paths)
+	for _, p := range paths {
+		if ContainsGlobChars(p) || strings.HasPrefix(p, "/dev/") {
+			continue
+		}
+		if _, err := os.Lstat(p); err != nil {
+			continue
+		}
+		// Sorted, so a root comes before the paths inside it
+		if len(roots) > 0 && isWithin(p, roots[len(roots)-1]) {
+			continue
+		}
+		roots = append(roots, p)
+	}
+	return roots
+}
+
+// SnapshotFiles records the files and directories under roots. Entries that
+// cannot be read are left out. It returns ErrSnapshotTooLarge if there are
+// more than MaxSnapshotEntries.
+func SnapshotFiles(roots []string) (*FileSnapshot, error) {
+	entries, err := snapshotEntries(roots)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSnapshot{roots: slices.Clone(roots), entries: entries}, nil
+}
+
+func snapshotEntries(roots []string) (map[string]fileState, error) {
+	entries := make(map[string]fileState)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable, or removed during the walk
+				if d != nil && d.IsDir() && path != root {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if len(entries) >= MaxSnapshotEntries {
+				return ErrSnapshotTooLarge
+			}
+			entries[path] = fileState{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Changes snapshots the same paths again and returns what changed since s
+// was taken, sorted by path.
+func (s *FileSnapshot) Changes() ([]FileChange, error) {
+	after, err := snapshotEntries(s.roots)
+	if err != nil {
+		return nil, err
+	}
+	return compareSnapshots(s.entries, after), nil
+}
+
+// compareSnapshots returns the changes from before to after.
+func compareSnapshots(before, after map[string]fileState) []FileChange {
+	var changes []FileChange
+	for path, a := range after {
+		b, existed := before[path]
+		switch {
+		case !existed:
+			changes = append(changes, FileChange{Path: path, Kind: FileCreated, Dir: a.mode.IsDir()})
+		case b.mode.Type() != a.mode.Type():
+			// Replaced by a different kind of file
+			changes = append(changes, FileChange{Path: path, Kind: FileModified, Dir: a.mode.IsDir()})
+		case a.mode.IsDir():
+			// A directory's own time changes with every file in it
+		case a.size != b.size || !a.modTime.Equal(b.modTime) || a.mode != b.mode:
+			changes = append(changes, FileChange{Path: path, Kind: FileModified})
+		}
+	}
+	for path, b := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Kind: FileDeleted, Dir: b.mode.IsDir()})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// WriteChangeReport writes a human-readable summary of changes, listing at
+// most the first 50. Nothing is written if there are none.
+func WriteChangeReport(w io.Writer, changes []FileChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Kind]++
+	}
+	if _, err := fmt.Fprintf(w, "[fence] %d file(s) changed (%d created, %d modified, %d deleted):\n",
+		len(changes), counts[FileCreated], counts[FileModified], counts[FileDeleted]); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range changes[:min(len(changes), maxReportedChanges)] {
+		path := c.Path
+		if c.Dir {
+			path += "/"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Kind, path)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if more := len(changes) - maxReportedChanges; more > 0 {
+		_, err := fmt.Fprintf(w, "  ... and %d more\n", more)
+		return err
+	}
+	return nil
+}
+
+// WriteChangesJSON writes changes as a JSON document.
+func WriteChangesJSON(w io.Writer, changes []FileChange) error {
+	if changes == nil {
+		changes = []FileChange{}
+	}
+	report := struct {
+		Changes []FileChange `json:"changes"`
+	}{changes}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
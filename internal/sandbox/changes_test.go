@@ -0,0 +1,135 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestSnapshotChanges(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("same.txt", "same")
+	write("edited.txt", "old")
+	write("touched.txt", "x")
+	write("gone/a.txt", "a")
+	write("chmod.sh", "#!/bin/sh")
+
+	snapshot, err := SnapshotFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("SnapshotFiles() error = %v", err)
+	}
+
+	write("edited.txt", "new contents")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "touched.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "chmod.sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	write("new/b.txt", "b")
+
+	changes, err := snapshot.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	want := []FileChange{
+		{Path: filepath.Join(dir, "chmod.sh"), Kind: FileModified},
+		{Path: filepath.Join(dir, "edited.txt"), Kind: FileModified},
+		{Path: filepath.Join(dir, "gone"), Kind: FileDeleted, Dir: true},
+		{Path: filepath.Join(dir, "gone/a.txt"), Kind: FileDeleted},
+		{Path: filepath.Join(dir, "new"), Kind: FileCreated, Dir: true},
+		{Path: filepath.Join(dir, "new/b.txt"), Kind: FileCreated},
+		{Path: filepath.Join(dir, "touched.txt"), Kind: FileModified},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes() = %+v, want %+v", changes, want)
+	}
+}
+
+func TestCompareSnapshotsReplacedType(t *testing.T) {
+	before := map[string]fileState{"/w/x": {mode: 0o644}}
+	after := map[string]fileState{"/w/x": {mode: os.ModeDir | 0o755}}
+	want := []FileChange{{Path: "/w/x", Kind: FileModified, Dir: true}}
+	if got := compareSnapshots(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("compareSnapshots() = %+v, want %+v", got, want)
+	}
+}
+
+func TestChangeRoots(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{sub, dir, filepath.Join(dir, "missing"), "/dev/null"}
+
+	if got, want := ChangeRoots(cfg), []string{dir}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangeRoots() = %q, want %q", got, want)
+	}
+	if got := ChangeRoots(nil); got != nil {
+		t.Errorf("ChangeRoots(nil) = %q, want nil", got)
+	}
+}
+
+func TestWriteChangeReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteChangeReport(&buf, nil); err != nil || buf.Len() != 0 {
+		t.Errorf("WriteChangeReport(nil) wrote %q, %v; want nothing", buf.String(), err)
+	}
+
+	changes := []FileChange{
+		{Path: "/w/a", Kind: FileCreated, Dir: true},
+		{Path: "/w/b", Kind: FileDeleted},
+	}
+	for i := range maxReportedChanges {
+		changes = append(changes, FileChange{Path: "/w/m" + strings.Repeat("x", i), Kind: FileModified})
+	}
+	buf.Reset()
+	if err := WriteChangeReport(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"52 file(s) changed (1 created, 50 modified, 1 deleted)", "created   /w/a/", "deleted   /w/b", "... and 2 more"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("report = %q, want it to contain %q", out, s)
+		}
+	}
+}
+
+func TestWriteChangesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteChangesJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Changes []FileChange `json:"changes"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Changes == nil || len(report.Changes) != 0 {
+		t.Errorf("changes = %v, want an empty list", report.Changes)
+	}
+}
@@ -3,9 +3,12 @@ package sandbox
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 // CommandBlockedError is returned when a command is blocked by policy.
@@ -26,26 +29,47 @@ func (e *CommandBlockedError) Error() string {
 // It parses shell command strings and checks each sub-command in pipelines/chains.
 // Returns nil if allowed, or CommandBlockedError if blocked.
 func CheckCommand(command string, cfg *config.Config) error {
+	_, err := evaluateCommand(command, cfg)
+	return err
+}
+
+// EvaluateCommand decides whether a command is allowed by the configuration
+// and reports the rule that decided it. It applies the same checks as CheckCommand.
+func EvaluateCommand(command string, cfg *config.Config) policy.Decision {
+	d, _ := evaluateCommand(command, cfg)
+	return d
+}
+
+// evaluateCommand checks each sub-command in a shell command string.
+// The first denied sub-command decides the outcome; otherwise the first
+// warning is returned, or else the decision for the last sub-command that
+// matched an explicit rule.
+func evaluateCommand(command string, cfg *config.Config) (policy.Decision, error) {
 	if cfg == nil {
 		cfg = config.Default()
 	}
 
-	subCommands := parseShellCommand(command)
+	decision := policy.Allow("", "no command rule matches; commands are allowed by default")
 
-	for _, subCmd := range subCommands {
-		if err := checkSingleCommand(subCmd, cfg); err != nil {
-			return err
+	for _, subCmd := range parseShellCommand(command) {
+		d, err := evaluateSingleCommand(subCmd, cfg)
+		if err != nil {
+			return d, err
+		}
+		if d.Rule != "" && !decision.Warn {
+			decision = d
 		}
 	}
 
-	return nil
+	return decision, nil
 }
 
-// checkSingleCommand checks a single command (not a chain) against the policy.
-func checkSingleCommand(command string, cfg *config.Config) error {
+// evaluateSingleCommand checks a single command (not a chain) against the policy.
+// Returns the decision, and the error CheckCommand reports if the command is blocked.
+func evaluateSingleCommand(command string, cfg *config.Config) (policy.Decision, error) {
 	command = strings.TrimSpace(command)
 	if command == "" {
-		return nil
+		return policy.Allow("", "empty command"), nil
 	}
 
 	// Normalize the command for matching
@@ -53,214 +77,336 @@ func checkSingleCommand(command string, cfg *config.Config) error {
 
 	// Check if explicitly allowed (takes precedence over deny)
 	for _, allow := range cfg.Command.Allow {
-		if matchesPrefix(normalized, allow) {
-			return nil
+		if matchesRule(normalized, allow) {
+			return policy.Allow(policy.RuleRef("command.allow", allow), fmt.Sprintf("%q is explicitly allowed", command)), nil
 		}
 	}
 
 	// Check user-defined deny list
 	for _, deny := range cfg.Command.Deny {
-		if matchesPrefix(normalized, deny) {
-			return &CommandBlockedError{
-				Command:       command,
-				BlockedPrefix: deny,
-				IsDefault:     false,
+		if matchesRule(normalized, deny) {
+			return policy.Deny(policy.RuleRef("command.deny", deny), fmt.Sprintf("%q matches a denied prefix", command)),
+				&CommandBlockedError{
+					Command:       command,
+					BlockedPrefix: deny,
+					IsDefault:     false,
+				}
+		}
+	}
+
+	// Warn rules allow the command, unless a rule below denies it
+	var warned *policy.Decision
+	for _, rule := range cfg.Command.Warn {
+		if matchesRule(normalized, rule) {
+			d := policy.Warn(policy.RuleRef("command.deny", rule), fmt.Sprintf("%q matches a denied prefix whose action is warn", command))
+			warned = &d
+			break
+		}
+	}
+
+	// Check user-defined flag rules
+	for _, rule := range cfg.Command.DenyFlags {
+		if flag, ok := matchesFlagRule(command, rule); ok {
+			if rule.Action == config.ActionWarn {
+				if warned == nil {
+					d := policy.Warn(policy.RuleRef("command.denyFlags", rule.String()), fmt.Sprintf("%q uses denied flag %s, whose action is warn", command, flag))
+					warned = &d
+				}
+				continue
 			}
+			return policy.Deny(policy.RuleRef("command.denyFlags", rule.String()), fmt.Sprintf("%q uses denied flag %s", command, flag)),
+				&CommandBlockedError{
+					Command:       command,
+					BlockedPrefix: rule.Command + " " + flag,
+					IsDefault:     false,
+				}
 		}
 	}
 
 	// Check default deny list (if enabled)
 	if cfg.Command.UseDefaultDeniedCommands() {
 		for _, deny := range config.DefaultDeniedCommands {
-			if matchesPrefix(normalized, deny) {
-				return &CommandBlockedError{
-					Command:       command,
-					BlockedPrefix: deny,
-					IsDefault:     true,
-				}
+			if matchesRule(normalized, deny) {
+				return policy.Deny(policy.RuleRef("default command deny", deny), fmt.Sprintf("%q matches a built-in denied prefix", command)),
+					&CommandBlockedError{
+						Command:       command,
+						BlockedPrefix: deny,
+						IsDefault:     true,
+					}
+			}
+		}
+	}
+
+	// Check default flag rules (if enabled)
+	if cfg.Command.UseDefaultDeniedCommands() {
+		for _, rule := range config.DefaultDeniedCommandFlags {
+			if flag, ok := matchesFlagRule(command, rule); ok {
+				return policy.Deny(policy.RuleRef("default command deny", rule.String()), fmt.Sprintf("%q uses built-in denied flag %s", command, flag)),
+					&CommandBlockedError{
+						Command:       command,
+						BlockedPrefix: rule.Command + " " + flag,
+						IsDefault:     true,
+					}
 			}
 		}
 	}
 
 	// Check SSH-specific policies if this is an SSH command
-	if err := CheckSSHCommand(command, cfg); err != nil {
-		return err
+	sshDecision, err := evaluateSSHCommand(command, cfg)
+	if err != nil {
+		return sshDecision, err
+	}
+	if warned == nil && sshDecision.Warn {
+		warned = &sshDecision
 	}
 
-	return nil
+	if warned != nil {
+		return *warned, nil
+	}
+	return policy.Allow("", "no command rule matches; commands are allowed by default"), nil
 }
 
-// parseShellCommand splits a shell command string into individual commands.
-// Handles: pipes (|), logical operators (&&, ||), semicolons (;), and subshells.
+// parseShellCommand splits a shell command string into the simple commands
+// it runs: the parts of pipelines, lists (&&, ||, ;, &), subshells, and
+// compound commands, and commands in $(...), backticks, and <(...). Commands
+// run by a wrapper (env, nohup, xargs, ...) or a shell's -c are returned
+// after the wrapper's own command line, so both are checked. Each command is
+// returned as its words, shell-quoted, with assignments and redirections
+// dropped. A command that does not parse is returned whole.
 func parseShellCommand(command string) []string {
-	var commands []string
-	var current strings.Builder
-	var inSingleQuote, inDoubleQuote bool
-	var parenDepth int
-
-	runes := []rune(command)
-	for i := 0; i < len(runes); i++ {
-		c := runes[i]
-
-		// Handle quotes
-		if c == '\'' && !inDoubleQuote {
-			inSingleQuote = !inSingleQuote
-			current.WriteRune(c)
-			continue
-		}
-		if c == '"' && !inSingleQuote {
-			inDoubleQuote = !inDoubleQuote
-			current.WriteRune(c)
-			continue
+	file, err := parseShell(command)
+	if err != nil {
+		if s := strings.TrimSpace(command); s != "" {
+			return []string{s}
 		}
+		return nil
+	}
 
-		// Skip splitting inside quotes
-		if inSingleQuote || inDoubleQuote {
-			current.WriteRune(c)
-			continue
+	var commands []string
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if call, ok := node.(*syntax.CallExpr); ok && len(call.Args) > 0 {
+			commands = append(commands, expandCommand(shellWords(call.Args))...)
 		}
+		return true
+	})
+	return commands
+}
 
-		// Handle parentheses (subshells)
-		if c == '(' {
-			parenDepth++
-			current.WriteRune(c)
-			continue
-		}
-		if c == ')' {
-			parenDepth--
-			current.WriteRune(c)
-			continue
-		}
+// parseShell parses command as a bash script.
+func parseShell(command string) (*syntax.File, error) {
+	return syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+}
 
-		// Skip splitting inside subshells
-		if parenDepth > 0 {
-			current.WriteRune(c)
-			continue
-		}
+// expandCommand returns the simple command words, followed by the commands
+// it runs through a wrapper or a shell's -c.
+func expandCommand(words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	commands := []string{ShellQuote(words)}
+	inner, script := unwrapCommand(words)
+	if len(inner) > 0 {
+		commands = append(commands, expandCommand(inner)...)
+	}
+	if script != "" {
+		commands = append(commands, parseShellCommand(script)...)
+	}
+	return commands
+}
 
-		// Handle shell operators
-		switch c {
-		case '|':
-			// Check for || (or just |)
-			if i+1 < len(runes) && runes[i+1] == '|' {
-				// ||
-				if s := strings.TrimSpace(current.String()); s != "" {
-					commands = append(commands, s)
-				}
-				current.Reset()
-				i++ // Skip second |
-			} else {
-				// Just a pipe
-				if s := strings.TrimSpace(current.String()); s != "" {
-					commands = append(commands, s)
-				}
-				current.Reset()
+// shellInterpreters are the shells whose -c argument is a script to check.
+var shellInterpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "ksh": true, "dash": true, "fish": true,
+}
+
+// shellValueOpts are the long shell options that take a value.
+var shellValueOpts = []string{"--rcfile", "--init-file"}
+
+// commandWrapper describes a program that runs its arguments as a command.
+type commandWrapper struct {
+	// valueOpts are the options that take the next word as their value.
+	valueOpts []string
+	// operands is how many words precede the command, after the options.
+	operands int
+}
+
+// commandWrappers are the programs whose argument command is checked too,
+// e.g. the git push in "nohup git push" or "xargs git push".
+var commandWrappers = map[string]commandWrapper{
+	"env":     {valueOpts: []string{"-u", "--unset", "-C", "--chdir", "-S", "--split-string"}},
+	"nohup":   {},
+	"nice":    {valueOpts: []string{"-n", "--adjustment"}},
+	"time":    {valueOpts: []string{"-f", "--format", "-o", "--output"}},
+	"timeout": {valueOpts: []string{"-s", "--signal", "-k", "--kill-after"}, operands: 1},
+	"xargs": {valueOpts: []string{
+		"-a", "--arg-file", "-d", "--delimiter", "-E", "-I", "-L", "--max-lines",
+		"-n", "--max-args", "-P", "--max-procs", "-s", "--max-chars", "--process-slot-var",
+	}},
+	"command": {},
+	"exec":    {valueOpts: []string{"-a"}},
+	"stdbuf":  {valueOpts: []string{"-i", "--input", "-o", "--output", "-e", "--error"}},
+	"setsid":  {},
+	"sudo": {valueOpts: []string{
+		"-u", "--user", "-g", "--group", "-C", "--close-from", "-D", "--chdir",
+		"-h", "--host", "-p", "--prompt", "-R", "--chroot", "-r", "--role", "-t", "--type", "-U", "--other-user",
+	}},
+	"doas": {valueOpts: []string{"-u", "-C"}},
+}
+
+// unwrapCommand returns the command a wrapper or shell in words runs: the
+// inner command's words, or a script to parse for sh -c and env -S.
+func unwrapCommand(words []string) (inner []string, script string) {
+	name := filepath.Base(words[0])
+	args := words[1:]
+
+	if shellInterpreters[name] {
+		// -c, or combined flags containing c such as -lc, makes the first
+		// operand the script, wherever it comes among the options
+		hasScript := false
+		for len(args) > 0 {
+			arg := args[0]
+			if arg == "--" {
+				args = args[1:]
+				break
 			}
-		case '&':
-			// Check for &&
-			if i+1 < len(runes) && runes[i+1] == '&' {
-				if s := strings.TrimSpace(current.String()); s != "" {
-					commands = append(commands, s)
+			if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+				break
+			}
+			args = args[1:]
+			if strings.HasPrefix(arg, "--") {
+				if slices.Contains(shellValueOpts, arg) && len(args) > 0 {
+					args = args[1:]
 				}
-				current.Reset()
-				i++ // Skip second &
-			} else {
-				// Background operator - keep in current command
-				current.WriteRune(c)
+				continue
 			}
-		case ';':
-			if s := strings.TrimSpace(current.String()); s != "" {
-				commands = append(commands, s)
+			if arg[0] == '-' && strings.Contains(arg, "c") {
+				hasScript = true
 			}
-			current.Reset()
-		default:
-			current.WriteRune(c)
+			// -o pipefail, +o errexit, -O extglob: the value is not an operand
+			if strings.ContainsAny(arg, "oO") && len(args) > 0 {
+				args = args[1:]
+			}
+		}
+		if !hasScript || len(args) == 0 {
+			return nil, ""
 		}
+		return nil, args[0]
 	}
 
-	// Add remaining command
-	if s := strings.TrimSpace(current.String()); s != "" {
-		commands = append(commands, s)
+	wrapper, ok := commandWrappers[name]
+	if !ok {
+		return nil, ""
 	}
-
-	// Handle nested shell invocations like "bash -c 'git push'"
-	var expanded []string
-	for _, cmd := range commands {
-		expanded = append(expanded, expandShellInvocation(cmd)...)
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			args = args[1:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		args = args[1:]
+		switch {
+		case name == "command" && (arg == "-v" || arg == "-V"):
+			// Looks the command up without running it
+			return nil, ""
+		case name == "env" && (arg == "-S" || arg == "--split-string"):
+			// env -S splits its argument into the command's words
+			return nil, strings.Join(args, " ")
+		case name == "env" && strings.HasPrefix(arg, "-S"):
+			return nil, strings.Join(append([]string{arg[len("-S"):]}, args...), " ")
+		case name == "env" && strings.HasPrefix(arg, "--split-string="):
+			return nil, strings.Join(append([]string{arg[len("--split-string="):]}, args...), " ")
+		case slices.Contains(wrapper.valueOpts, arg) && len(args) > 0:
+			args = args[1:]
+		}
 	}
-
-	return expanded
-}
-
-// expandShellInvocation detects patterns like "bash -c 'cmd'" or "sh -c 'cmd'"
-// and extracts the inner command for checking.
-func expandShellInvocation(command string) []string {
-	command = strings.TrimSpace(command)
-	if command == "" {
-		return nil
+	if name == "env" {
+		for len(args) > 0 && isEnvAssignment(args[0]) {
+			args = args[1:]
+		}
 	}
-
-	tokens := tokenizeCommand(command)
-	if len(tokens) < 3 {
-		return []string{command}
+	if len(args) < wrapper.operands {
+		return nil, ""
 	}
+	return args[wrapper.operands:], ""
+}
 
-	// Check for shell -c pattern
-	shell := filepath.Base(tokens[0])
-	isShell := shell == "sh" || shell == "bash" || shell == "zsh" ||
-		shell == "ksh" || shell == "dash" || shell == "fish"
-
-	if !isShell {
-		return []string{command}
+// shellWords returns the words of a command as the shell would pass them,
+// with quotes removed. Parameter expansions and substitutions are kept as
+// written, since their values are unknown until the command runs.
+func shellWords(words []*syntax.Word) []string {
+	out := make([]string, 0, len(words))
+	for _, w := range words {
+		out = append(out, shellWord(w.Parts, false))
 	}
+	return out
+}
 
-	// Look for -c flag (could be combined with other flags like -lc, -ic, etc.)
-	for i := 1; i < len(tokens)-1; i++ {
-		flag := tokens[i]
-		// Check for -c, -lc, -ic, -ilc, etc. (any flag containing 'c')
-		if strings.HasPrefix(flag, "-") && strings.Contains(flag, "c") {
-			// Next token is the command string
-			innerCmd := tokens[i+1]
-			// Recursively parse the inner command
-			innerCommands := parseShellCommand(innerCmd)
-			// Return both the outer command and inner commands
-			// (we check both for safety)
-			result := []string{command}
-			result = append(result, innerCommands...)
-			return result
+func shellWord(parts []syntax.WordPart, inDoubleQuotes bool) string {
+	var b strings.Builder
+	for _, part := range parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			b.WriteString(unescapeShell(p.Value, inDoubleQuotes))
+		case *syntax.SglQuoted:
+			b.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			b.WriteString(shellWord(p.Parts, true))
+		default:
+			_ = syntax.NewPrinter().Print(&b, part)
 		}
 	}
-
-	return []string{command}
+	return b.String()
 }
 
-// tokenizeCommand splits a command string into tokens, respecting quotes.
-func tokenizeCommand(command string) []string {
-	var tokens []string
-	var current strings.Builder
-	var inSingleQuote, inDoubleQuote bool
-
-	for _, c := range command {
+// unescapeShell removes the backslashes the shell would from a literal.
+// Inside double quotes only \$, \`, \", \\, and line continuations are
+// escapes.
+func unescapeShell(s string, inDoubleQuotes bool) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
 		switch {
-		case c == '\'' && !inDoubleQuote:
-			inSingleQuote = !inSingleQuote
-		case c == '"' && !inSingleQuote:
-			inDoubleQuote = !inDoubleQuote
-		case (c == ' ' || c == '\t') && !inSingleQuote && !inDoubleQuote:
-			if current.Len() > 0 {
-				tokens = append(tokens, current.String())
-				current.Reset()
-			}
+		case next == '\n':
+			i++
+		case !inDoubleQuotes || strings.IndexByte("$`\"\\", next) >= 0:
+			b.WriteByte(next)
+			i++
 		default:
-			current.WriteRune(c)
+			b.WriteByte(s[i])
 		}
 	}
+	return b.String()
+}
 
-	if current.Len() > 0 {
-		tokens = append(tokens, current.String())
+// tokenizeCommand splits a single command into its words, respecting
+// quotes. Leading NAME=value assignments are kept as words.
+func tokenizeCommand(command string) []string {
+	file, err := parseShell(command)
+	if err != nil || len(file.Stmts) != 1 {
+		return strings.Fields(command)
 	}
-
-	return tokens
+	call, ok := file.Stmts[0].Cmd.(*syntax.CallExpr)
+	if !ok {
+		return strings.Fields(command)
+	}
+	var tokens []string
+	for _, a := range call.Assigns {
+		if a.Value == nil || a.Append || a.Index != nil || a.Array != nil {
+			return strings.Fields(command)
+		}
+		tokens = append(tokens, a.Name.Value+"="+shellWord(a.Value.Parts, false))
+	}
+	return append(tokens, shellWords(call.Args)...)
 }
 
 // normalizeCommand normalizes a command for matching.
@@ -282,6 +428,86 @@ func normalizeCommand(command string) string {
 	return strings.Join(tokens, " ")
 }
 
+// matchesRule checks if a normalized command matches a command rule: a
+// prefix, or a re: or glob pattern.
+func matchesRule(command, rule string) bool {
+	if config.IsCommandPattern(rule) {
+		re, err := config.CommandRuleRegexp(rule)
+		return err == nil && re.MatchString(command)
+	}
+	return matchesPrefix(command, rule)
+}
+
+// shortValueFlags lists, for the programs in the default flag rules, the short
+// flags that take a value. In a group of short flags, the rest of the argument
+// after one of these is its value, not more flags.
+var shortValueFlags = map[string]string{
+	"curl": "AbcCdDeEFHKmoPQrtTuUwxXyYz",
+	"wget": "aABDeiIloOPQRtTUwX",
+	"ssh":  "BbcDEeFIiJLlmOoPpQRSWw",
+	"nc":   "eGgIiMmOoPpsTVwXx",
+	"ncat": "cdeGgimopswx",
+}
+
+// matchesFlagRule checks if a single command runs rule's program with one of
+// its flags, and returns the flag used.
+func matchesFlagRule(command string, rule config.CommandFlagRule) (string, bool) {
+	args := commandArgs(command)
+	prefix := strings.Fields(rule.Command)
+	if len(prefix) == 0 || len(args) <= len(prefix) {
+		return "", false
+	}
+	prefix[0] = filepath.Base(prefix[0])
+	if !slices.Equal(args[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	valueFlags := shortValueFlags[prefix[0]]
+	for _, arg := range args[len(prefix):] {
+		if arg == "--" {
+			break
+		}
+		for _, flag := range rule.Flags {
+			if matchesFlag(arg, flag, valueFlags) {
+				return flag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchesFlag checks if a command-line argument is flag: a long flag alone
+// or with "=value", or a short flag alone, with its value attached, or in a
+// group of short flags. A group is scanned up to its first letter in
+// valueFlags, which takes the rest of the argument as its value; for programs
+// without a list, up to the first character that is not a letter.
+func matchesFlag(arg, flag, valueFlags string) bool {
+	if arg == flag {
+		return true
+	}
+	if strings.HasPrefix(flag, "--") {
+		return strings.HasPrefix(arg, flag+"=")
+	}
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return false
+	}
+	if strings.HasPrefix(arg, flag) {
+		return true
+	}
+	if len(flag) != 2 {
+		return false
+	}
+	for _, c := range arg[1:] {
+		if c == rune(flag[1]) {
+			return true
+		}
+		if ((c < 'a' || c > 'z') && (c < 'A' || c > 'Z')) || strings.ContainsRune(valueFlags, c) {
+			return false
+		}
+	}
+	return false
+}
+
 // matchesPrefix checks if a command matches a blocked prefix.
 // The prefix matches if the command starts with the prefix followed by
 // end of string, a space, or other argument.
@@ -321,29 +547,38 @@ func (e *SSHBlockedError) Error() string {
 // CheckSSHCommand checks if an SSH command is allowed by the configuration.
 // Returns nil if allowed, or SSHBlockedError if blocked.
 func CheckSSHCommand(command string, cfg *config.Config) error {
+	_, err := evaluateSSHCommand(command, cfg)
+	return err
+}
+
+// evaluateSSHCommand checks an SSH command against the ssh.* policy.
+// Returns the decision, and the error CheckSSHCommand reports if the command is blocked.
+func evaluateSSHCommand(command string, cfg *config.Config) (policy.Decision, error) {
 	if cfg == nil {
 		cfg = config.Default()
 	}
 
+	allowed := policy.Allow("", "no ssh rule matches")
+
 	// Check if SSH config is active (has any hosts configured)
 	// If no SSH policy is configured, allow by default
-	if len(cfg.SSH.AllowedHosts) == 0 && len(cfg.SSH.DeniedHosts) == 0 {
-		return nil
+	if len(cfg.SSH.AllowedHosts) == 0 && len(cfg.SSH.DeniedHosts) == 0 && len(cfg.SSH.WarnHosts) == 0 {
+		return allowed, nil
 	}
 
 	host, remoteCmd, isSSH := parseSSHCommand(command)
 	if !isSSH {
-		return nil
+		return allowed, nil
 	}
 
 	// Check host policy (denied then allowed)
 	for _, pattern := range cfg.SSH.DeniedHosts {
 		if config.MatchesHost(host, pattern) {
-			return &SSHBlockedError{
+			return sshDeny(&SSHBlockedError{
 				Host:          host,
 				RemoteCommand: remoteCmd,
 				Reason:        fmt.Sprintf("host matches denied pattern %q", pattern),
-			}
+			})
 		}
 	}
 
@@ -356,63 +591,93 @@ func CheckSSHCommand(command string, cfg *config.Config) error {
 	}
 
 	if len(cfg.SSH.AllowedHosts) > 0 && !hostAllowed {
-		return &SSHBlockedError{
+		return sshDeny(&SSHBlockedError{
 			Host:          host,
 			RemoteCommand: remoteCmd,
 			Reason:        "host not in allowedHosts",
+		})
+	}
+
+	// Warn rules report a host that is otherwise allowed
+	var warned *policy.Decision
+	for _, pattern := range cfg.SSH.WarnHosts {
+		if config.MatchesHost(host, pattern) {
+			d := policy.Warn(policy.RuleRef("ssh.deniedHosts", pattern), fmt.Sprintf("host %q matches a denied pattern whose action is warn", host))
+			warned = &d
+			break
 		}
 	}
 
-	// If no remote command (interactive session), allow if host is allowed
-	if remoteCmd == "" {
-		return nil
+	// An interactive session (no remote command) is allowed if the host is
+	if remoteCmd != "" {
+		d, err := evaluateSSHRemoteCommand(remoteCmd, cfg)
+		if err != nil {
+			return d, err
+		}
+		if warned == nil && d.Warn {
+			warned = &d
+		}
+	}
+
+	if warned != nil {
+		return *warned, nil
 	}
+	return allowed, nil
+}
 
-	return checkSSHRemoteCommand(remoteCmd, cfg)
+// sshDeny returns the decision and error for an SSH command blocked by err.
+func sshDeny(err *SSHBlockedError) (policy.Decision, error) {
+	return policy.Deny("ssh", err.Reason), err
 }
 
-// checkSSHRemoteCommand checks if a remote command is allowed by SSH policy.
+// evaluateSSHRemoteCommand checks if a remote command is allowed by SSH policy.
 // It parses the remote command into subcommands (handling &&, ||, ;, |) and validates each.
-func checkSSHRemoteCommand(remoteCmd string, cfg *config.Config) error {
+// The first denied subcommand decides the outcome; otherwise the first warning does.
+func evaluateSSHRemoteCommand(remoteCmd string, cfg *config.Config) (policy.Decision, error) {
 	// Parse into subcommands just like local commands to prevent bypass via chaining
 	// e.g., "git status && rm -rf /" should check both "git status" and "rm -rf /"
 	subCommands := parseShellCommand(remoteCmd)
 
+	decision := policy.Allow("", "no ssh rule matches")
 	for _, subCmd := range subCommands {
-		if err := checkSSHSingleCommand(subCmd, remoteCmd, cfg); err != nil {
-			return err
+		d, err := evaluateSSHSingleCommand(subCmd, remoteCmd, cfg)
+		if err != nil {
+			return d, err
+		}
+		if d.Warn && !decision.Warn {
+			decision = d
 		}
 	}
 
-	return nil
+	return decision, nil
 }
 
-// checkSSHSingleCommand checks a single SSH remote command against policy.
-func checkSSHSingleCommand(subCmd, fullRemoteCmd string, cfg *config.Config) error {
+// evaluateSSHSingleCommand checks a single SSH remote command against policy.
+func evaluateSSHSingleCommand(subCmd, fullRemoteCmd string, cfg *config.Config) (policy.Decision, error) {
 	normalized := normalizeCommand(subCmd)
 	if normalized == "" {
-		return nil
+		return policy.Allow("", "empty command"), nil
 	}
 
 	// Check inherited global deny list first (if enabled)
 	// User-defined global then default deny list
 	if cfg.SSH.InheritDeny {
 		for _, deny := range cfg.Command.Deny {
-			if matchesPrefix(normalized, deny) {
-				return &SSHBlockedError{
+			if matchesRule(normalized, deny) {
+				return sshDeny(&SSHBlockedError{
 					RemoteCommand: fullRemoteCmd,
 					Reason:        fmt.Sprintf("command %q matches inherited global deny %q", subCmd, deny),
-				}
+				})
 			}
 		}
 
 		if cfg.Command.UseDefaultDeniedCommands() {
 			for _, deny := range config.DefaultDeniedCommands {
-				if matchesPrefix(normalized, deny) {
-					return &SSHBlockedError{
+				if matchesRule(normalized, deny) {
+					return sshDeny(&SSHBlockedError{
 						RemoteCommand: fullRemoteCmd,
 						Reason:        fmt.Sprintf("command %q matches inherited default deny %q", subCmd, deny),
-					}
+					})
 				}
 			}
 		}
@@ -420,38 +685,47 @@ func checkSSHSingleCommand(subCmd, fullRemoteCmd string, cfg *config.Config) err
 
 	// Check SSH-specific denied commands
 	for _, deny := range cfg.SSH.DeniedCommands {
-		if matchesPrefix(normalized, deny) {
-			return &SSHBlockedError{
+		if matchesRule(normalized, deny) {
+			return sshDeny(&SSHBlockedError{
 				RemoteCommand: fullRemoteCmd,
 				Reason:        fmt.Sprintf("command %q matches ssh.deniedCommands %q", subCmd, deny),
-			}
+			})
+		}
+	}
+
+	// Warn rules report a command that is otherwise allowed
+	allowed := policy.Allow("", fmt.Sprintf("%q is allowed over ssh", subCmd))
+	for _, rule := range cfg.SSH.WarnCommands {
+		if matchesRule(normalized, rule) {
+			allowed = policy.Warn(policy.RuleRef("ssh.deniedCommands", rule), fmt.Sprintf("command %q matches ssh.deniedCommands %q, whose action is warn", subCmd, rule))
+			break
 		}
 	}
 
 	// If allowAllCommands is true, we're in denylist mode - allow anything not denied
 	if cfg.SSH.AllowAllCommands {
-		return nil
+		return allowed, nil
 	}
 
 	// Allowlist mode: check if command is in allowedCommands
 	if len(cfg.SSH.AllowedCommands) > 0 {
 		for _, allow := range cfg.SSH.AllowedCommands {
-			if matchesPrefix(normalized, allow) {
-				return nil
+			if matchesRule(normalized, allow) {
+				return allowed, nil
 			}
 		}
 		// Not in allowlist
-		return &SSHBlockedError{
+		return sshDeny(&SSHBlockedError{
 			RemoteCommand: fullRemoteCmd,
 			Reason:        fmt.Sprintf("command %q not in ssh.allowedCommands", subCmd),
-		}
+		})
 	}
 
 	// No allowedCommands configured and not in denylist mode = deny all remote commands
-	return &SSHBlockedError{
+	return sshDeny(&SSHBlockedError{
 		RemoteCommand: fullRemoteCmd,
 		Reason:        "no ssh.allowedCommands configured (allowlist mode requires explicit commands)",
-	}
+	})
 }
 
 // parseSSHCommand parses an SSH command and extracts the host and remote command.
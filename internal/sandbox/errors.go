@@ -0,0 +1,122 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Sentinel errors for programmatic handling. Test for them with errors.Is;
+// the typed errors below match their corresponding sentinel.
+var (
+	// ErrSandboxUnsupported is returned when the current platform cannot be sandboxed.
+	ErrSandboxUnsupported = errors.New("sandbox is not supported on this platform")
+
+	// ErrMissingDependency matches any *MissingDependencyError.
+	ErrMissingDependency = errors.New("missing required dependency")
+
+	// ErrPolicyViolation matches any *PolicyViolationError.
+	ErrPolicyViolation = errors.New("blocked by sandbox policy")
+
+	// ErrInitTimeout is returned when sandbox infrastructure (bridges, listeners)
+	// does not become ready in time.
+	ErrInitTimeout = errors.New("timed out initializing sandbox")
+)
+
+// MissingDependencyError is returned when a binary the sandbox relies on
+// (bwrap, socat, sandbox-exec, a shell) cannot be found.
+type MissingDependencyError struct {
+	Binary string
+	Err    error
+}
+
+func (e *MissingDependencyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s is required but not found: %v", e.Binary, e.Err)
+	}
+	return fmt.Sprintf("%s is required but not found", e.Binary)
+}
+
+func (e *MissingDependencyError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrMissingDependency.
+func (e *MissingDependencyError) Is(target error) bool {
+	return target == ErrMissingDependency
+}
+
+// PolicyViolationError is returned when sandbox policy refuses an operation.
+// Err holds the more specific cause (e.g. *CommandBlockedError, *SSHBlockedError).
+type PolicyViolationError struct {
+	Rule string // The rule that denied the operation, e.g. `command.deny "git push"`
+	Err  error
+}
+
+func (e *PolicyViolationError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("blocked by sandbox policy: %s", e.Rule)
+}
+
+func (e *PolicyViolationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrPolicyViolation.
+func (e *PolicyViolationError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// ShutdownError is returned by Manager.Shutdown when parts of the sandbox
+// could not be stopped. Teardown continues past a failed step, so Failures
+// lists every step that failed, in teardown order.
+type ShutdownError struct {
+	Failures []TeardownFailure
+}
+
+// TeardownFailure is a teardown step that did not complete.
+type TeardownFailure struct {
+	Step string // e.g. "sandboxed process 1234", "HTTP proxy"
+	Err  error
+}
+
+func (e *ShutdownError) Error() string {
+	parts := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		parts[i] = fmt.Sprintf("%s: %v", f.Step, f.Err)
+	}
+	return "failed to stop " + strings.Join(parts, "; ")
+}
+
+// Unwrap returns the step errors, so errors.Is can match e.g. context.DeadlineExceeded.
+func (e *ShutdownError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// newPolicyViolation wraps a command policy error in a PolicyViolationError.
+// Errors that are not policy errors are returned unchanged.
+func newPolicyViolation(err error) error {
+	var cmdErr *CommandBlockedError
+	if errors.As(err, &cmdErr) {
+		rule := policy.RuleRef("command.deny", cmdErr.BlockedPrefix)
+		if cmdErr.IsDefault {
+			rule = policy.RuleRef("default command deny", cmdErr.BlockedPrefix)
+		}
+		return &PolicyViolationError{Rule: rule, Err: err}
+	}
+
+	var sshErr *SSHBlockedError
+	if errors.As(err, &sshErr) {
+		return &PolicyViolationError{Rule: "ssh: " + sshErr.Reason, Err: err}
+	}
+
+	return err
+}
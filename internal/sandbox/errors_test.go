@@ -0,0 +1,88 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestNewPolicyViolation(t *testing.T) {
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"git push"}
+
+	tests := []struct {
+		name     string
+		command  string
+		wantRule string
+	}{
+		{"user deny", "git push origin main", `command.deny "git push"`},
+		{"default deny", "shutdown -h now", `default command deny "shutdown"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newPolicyViolation(CheckCommand(tt.command, cfg))
+			if !errors.Is(err, ErrPolicyViolation) {
+				t.Fatalf("expected ErrPolicyViolation, got %v", err)
+			}
+
+			var violation *PolicyViolationError
+			if !errors.As(err, &violation) {
+				t.Fatalf("expected *PolicyViolationError, got %T", err)
+			}
+			if violation.Rule != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", violation.Rule, tt.wantRule)
+			}
+
+			var blocked *CommandBlockedError
+			if !errors.As(err, &blocked) {
+				t.Error("expected PolicyViolationError to wrap *CommandBlockedError")
+			}
+		})
+	}
+}
+
+func TestNewPolicyViolationSSH(t *testing.T) {
+	cfg := config.Default()
+	cfg.SSH.AllowedHosts = []string{"*.example.com"}
+
+	err := newPolicyViolation(CheckCommand("ssh evil.com", cfg))
+
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected *PolicyViolationError, got %T", err)
+	}
+	var sshErr *SSHBlockedError
+	if !errors.As(err, &sshErr) {
+		t.Error("expected PolicyViolationError to wrap *SSHBlockedError")
+	}
+}
+
+func TestNewPolicyViolationPassthrough(t *testing.T) {
+	if err := newPolicyViolation(nil); err != nil {
+		t.Errorf("newPolicyViolation(nil) = %v, want nil", err)
+	}
+
+	other := errors.New("other")
+	if err := newPolicyViolation(other); err != other {
+		t.Errorf("non-policy errors should pass through unchanged, got %v", err)
+	}
+}
+
+func TestMissingDependencyError(t *testing.T) {
+	err := fmt.Errorf("failed to initialize Linux bridge: %w", &MissingDependencyError{Binary: "socat"})
+
+	if !errors.Is(err, ErrMissingDependency) {
+		t.Error("expected errors.Is(err, ErrMissingDependency)")
+	}
+	if errors.Is(err, ErrPolicyViolation) {
+		t.Error("missing dependency should not match ErrPolicyViolation")
+	}
+
+	var missing *MissingDependencyError
+	if !errors.As(err, &missing) || missing.Binary != "socat" {
+		t.Errorf("expected MissingDependencyError{Binary: socat}, got %v", err)
+	}
+}
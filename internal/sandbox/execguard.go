@@ -0,0 +1,35 @@
+package sandbox
+
+import (
+	"path/filepath"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// ExecGuardFlag is the hidden fence flag that installs the exec guard's
+// seccomp filter and then executes the command:
+// fence --exec-guard -- PATH ARGV...
+// The filter's listener is handed to the supervisor on fd 3.
+const ExecGuardFlag = "--exec-guard"
+
+// execCommandLine returns the command line an execve of path with argv is
+// checked as. The program is named by path rather than argv[0], which the
+// caller chooses freely.
+func execCommandLine(path string, argv []string) string {
+	name := path
+	if name == "" && len(argv) > 0 {
+		name = argv[0]
+	}
+	words := []string{filepath.Base(name)}
+	if len(argv) > 1 {
+		words = append(words, argv[1:]...)
+	}
+	return ShellQuote(words)
+}
+
+// checkExec checks an execve against cfg's command policy, as CheckCommand
+// does for the command fence was given. It returns the command line checked.
+func checkExec(cfg *config.Config, path string, argv []string) (string, error) {
+	command := execCommandLine(path, argv)
+	return command, CheckCommand(command, cfg)
+}
@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestCheckExec(t *testing.T) {
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"rm -rf", "git push"}
+
+	tests := []struct {
+		name    string
+		path    string
+		argv    []string
+		want    string
+		blocked bool
+	}{
+		{"allowed", "/usr/bin/ls", []string{"ls", "-la"}, "ls -la", false},
+		{"denied", "/usr/bin/rm", []string{"rm", "-rf", "/tmp/x"}, "rm -rf /tmp/x", true},
+		{"argv[0] is ignored", "/usr/bin/rm", []string{"ls", "-rf", "/"}, "rm -rf /", true},
+		{"quoted arguments", "/usr/bin/git", []string{"git", "commit", "-m", "git push"}, "git commit -m 'git push'", false},
+		{"shell script", "/bin/sh", []string{"sh", "-c", "git push origin"}, "sh -c 'git push origin'", true},
+		{"no path", "", []string{"git", "push"}, "git push", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkExec(cfg, tt.path, tt.argv)
+			if got != tt.want {
+				t.Errorf("checked %q, want %q", got, tt.want)
+			}
+			if (err != nil) != tt.blocked {
+				t.Errorf("checkExec() error = %v, want blocked %v", err, tt.blocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// execTraceReadyTimeout bounds how long StartExecTrace waits for the
+// tracer to attach.
+const execTraceReadyTimeout = 10 * time.Second
+
+// ExecTracer records the processes a sandboxed command executes in an
+// ExecLog, with their arguments and parent PIDs. It traces every
+// descendant of fence from the moment it starts, so the command's own exec
+// is seen too; SetRoot then narrows the log to the command's process tree,
+// leaving out fence's helpers such as the bridges.
+type ExecTracer struct {
+	log    *policy.ExecLog
+	source string
+	debug  bool
+	cmd    *exec.Cmd
+	script string // Temporary script file, removed by Stop
+	stop   sync.Once
+
+	mu      sync.Mutex
+	root    int
+	parents map[int]int   // Parent of each traced process, from fork events
+	pending []policy.Exec // Executions seen before SetRoot
+}
+
+func newExecTracer(log *policy.ExecLog, source string, debug bool) *ExecTracer {
+	self := os.Getpid()
+	return &ExecTracer{log: log, source: source, debug: debug, parents: map[int]int{self: 0}}
+}
+
+// SetRoot makes pid, the sandboxed command, the root of the traced tree.
+// Executions by its descendants are logged, including those seen before
+// SetRoot was called.
+func (t *ExecTracer) SetRoot(pid int) {
+	t.mu.Lock()
+	t.root = pid
+	var execs []policy.Exec
+	for _, e := range t.pending {
+		if t.descends(e.PID) {
+			execs = append(execs, e)
+		}
+	}
+	t.pending = nil
+	t.mu.Unlock()
+
+	for _, e := range execs {
+		t.log.Record(e)
+	}
+}
+
+// fork notes that parent started child.
+func (t *ExecTracer) fork(parent, child int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.parents[parent]; ok {
+		t.parents[child] = parent
+	}
+}
+
+// exec logs an execution if it is in the traced tree.
+func (t *ExecTracer) exec(e policy.Exec) {
+	e.Source = t.source
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	t.mu.Lock()
+	if _, ok := t.parents[e.PID]; !ok {
+		// The fork was missed, e.g. it happened before the tracer attached
+		if _, ok := t.parents[e.PPID]; !ok {
+			t.mu.Unlock()
+			return
+		}
+		t.parents[e.PID] = e.PPID
+	}
+	if t.root == 0 {
+		t.pending = append(t.pending, e)
+		t.mu.Unlock()
+		return
+	}
+	in := t.descends(e.PID)
+	t.mu.Unlock()
+
+	if in {
+		if t.debug {
+			logging.Debugf("exec", "pid=%d ppid=%d %s", e.PID, e.PPID, strings.Join(e.Argv, " "))
+		}
+		t.log.Record(e)
+	}
+}
+
+// descends reports whether pid is the root or one of its descendants.
+// t.mu must be held.
+func (t *ExecTracer) descends(pid int) bool {
+	for range len(t.parents) + 1 {
+		if pid == t.root {
+			return true
+		}
+		parent, ok := t.parents[pid]
+		if !ok || parent == 0 {
+			return false
+		}
+		pid = parent
+	}
+	return false
+}
+
+// Stop stops tracing, after giving the tracer a moment to report the last
+// executions. It is safe to call more than once.
+func (t *ExecTracer) Stop() {
+	t.stop.Do(func() {
+		if t.cmd != nil && t.cmd.Process != nil {
+			time.Sleep(200 * time.Millisecond)
+			_ = t.cmd.Process.Kill()
+			_ = t.cmd.Wait()
+		}
+		if t.script != "" {
+			_ = os.Remove(t.script)
+		}
+	})
+}
+
+// startExecTracer starts the platform's exec tracer: bpftrace on Linux, or
+// eslogger (Endpoint Security) on macOS with dtrace as a fallback. All of
+// them need root (on Linux, CAP_BPF and CAP_PERFMON do).
+func startExecTracer(ctx context.Context, log *policy.ExecLog, debug bool) (*ExecTracer, error) {
+	switch plat := platform.Detect(); plat {
+	case platform.Linux:
+		if !DetectLinuxFeatures().HasEBPF {
+			return nil, errors.New("exec tracing needs eBPF (root or CAP_BPF)")
+		}
+		path, err := exec.LookPath("bpftrace")
+		if err != nil {
+			return nil, errors.New("exec tracing needs bpftrace")
+		}
+		t := newExecTracer(log, policy.SourceEBPF, debug)
+		return t, t.startScript(ctx, path, bpftraceExecScript(os.Getpid()), parseBpftraceExec)
+	case platform.MacOS:
+		if os.Geteuid() != 0 {
+			return nil, errors.New("exec tracing needs root on macOS")
+		}
+		if path, err := exec.LookPath("eslogger"); err == nil {
+			t := newExecTracer(log, policy.SourceESLogger, debug)
+			return t, t.startESLogger(ctx, path)
+		}
+		path, err := exec.LookPath("dtrace")
+		if err != nil {
+			return nil, errors.New("exec tracing needs eslogger (macOS 13 or later) or dtrace")
+		}
+		t := newExecTracer(log, policy.SourceDTrace, debug)
+		return t, t.startScript(ctx, path, dtraceExecScript(os.Getpid()), parseDTraceExec)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSandboxUnsupported, plat)
+	}
+}
+
+// bpftraceExecScript traces the descendants of pid in the kernel, printing
+// a FORK line for each process started and, for each exec, an EXEC line
+// followed by the arguments joined with tabs. pid is the current tgid and
+// args->child_pid the new task, so forks from any thread count.
+func bpftraceExecScript(pid int) string {
+	return fmt.Sprintf(`
+BEGIN { @tree[%d] = 1; printf("READY\n"); }
+tracepoint:sched:sched_process_fork /@tree[pid]/ {
+    @tree[args->child_pid] = 1;
+    printf("FORK %%d %%d\n", pid, args->child_pid);
+}
+tracepoint:sched:sched_process_exit /@tree[tid]/ { delete(@tree[tid]); }
+tracepoint:syscalls:sys_enter_execve, tracepoint:syscalls:sys_enter_execveat /@tree[pid]/ {
+    printf("EXEC %%d %%d %%s\n", pid, curtask->real_parent->tgid, str(args->filename));
+    join(args->argv, "\t");
+}
+END { clear(@tree); }
+`, pid)
+}
+
+// dtraceExecScript traces the descendants of pid. dtrace only has the
+// process's argument string, which is cut at 80 characters.
+func dtraceExecScript(pid int) string {
+	return fmt.Sprintf(`
+BEGIN { printf("READY\n"); }
+proc:::create /progenyof(%d)/ { printf("FORK %%d %%d\n", pid, args[0]->pr_pid); }
+proc:::exec-success /progenyof(%d)/ { printf("EXEC %%d %%d %%s\n", pid, ppid, curpsinfo->pr_psargs); }
+`, pid, pid)
+}
+
+var (
+	traceForkPattern = regexp.MustCompile(`^FORK (\d+) (\d+)$`)
+	traceExecPattern = regexp.MustCompile(`^EXEC (\d+) (\d+) (.*)$`)
+)
+
+// traceParser reads a tracer's output, reporting forks and executions to t.
+type traceParser func(t *ExecTracer, lines *bufio.Scanner)
+
+// startScript runs a bpftrace or dtrace script and parses its output with
+// parse, returning once the script is attached.
+func (t *ExecTracer) startScript(ctx context.Context, tool, script string, parse traceParser) error {
+	f, err := os.CreateTemp("", "fence-exec-*.trace")
+	if err != nil {
+		return fmt.Errorf("failed to create trace script: %w", err)
+	}
+	t.script = f.Name()
+	_, err = f.WriteString(script)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(t.script)
+		return fmt.Errorf("failed to write trace script: %w", err)
+	}
+
+	args := []string{t.script}
+	if t.source == policy.SourceDTrace {
+		args = []string{"-q", "-s", t.script}
+	}
+	t.cmd = exec.CommandContext(ctx, tool, args...) //nolint:gosec // tool is from LookPath
+	return t.run(func(lines *bufio.Scanner) { parse(t, lines) }, func(line string) bool { return line == "READY" })
+}
+
+// startESLogger streams exec and fork events from eslogger. eslogger has
+// no ready signal, so it is given a moment to connect.
+func (t *ExecTracer) startESLogger(ctx context.Context, path string) error {
+	t.cmd = exec.CommandContext(ctx, path, "exec", "fork") //nolint:gosec // path is from LookPath
+	if err := t.run(func(lines *bufio.Scanner) {
+		for lines.Scan() {
+			t.esEvent(lines.Bytes())
+		}
+	}, nil); err != nil {
+		return err
+	}
+	time.Sleep(500 * time.Millisecond)
+	return nil
+}
+
+// run starts t.cmd and parses its output in the background. If ready is
+// set, run waits until it matches a line of output.
+func (t *ExecTracer) run(parse func(*bufio.Scanner), ready func(string) bool) error {
+	stdout, err := t.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	t.cmd.Stderr = &stderr
+	if err := t.cmd.Start(); err != nil {
+		t.Stop()
+		return fmt.Errorf("failed to start exec tracer: %w", err)
+	}
+
+	lines := bufio.NewScanner(stdout)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	if ready != nil {
+		readyCh := make(chan bool, 1)
+		go func() {
+			for lines.Scan() {
+				if ready(lines.Text()) {
+					readyCh <- true
+					return
+				}
+			}
+			readyCh <- false
+		}()
+		select {
+		case ok := <-readyCh:
+			if !ok {
+				t.Stop()
+				return fmt.Errorf("exec tracer exited: %s", strings.TrimSpace(stderr.String()))
+			}
+		case <-time.After(execTraceReadyTimeout):
+			t.Stop()
+			return errors.New("exec tracer did not start in time")
+		}
+	}
+	go func() {
+		parse(lines)
+		_, _ = io.Copy(io.Discard, stdout)
+	}()
+	return nil
+}
+
+// parseBpftraceExec parses bpftraceExecScript's output. The arguments of
+// an exec follow its EXEC line.
+func parseBpftraceExec(t *ExecTracer, lines *bufio.Scanner) {
+	var pending *policy.Exec
+	for lines.Scan() {
+		line := lines.Text()
+		if m := traceForkPattern.FindStringSubmatch(line); m != nil {
+			parent, _ := strconv.Atoi(m[1])
+			child, _ := strconv.Atoi(m[2])
+			t.fork(parent, child)
+			continue
+		}
+		if m := traceExecPattern.FindStringSubmatch(line); m != nil {
+			if pending != nil {
+				t.exec(*pending)
+			}
+			pid, _ := strconv.Atoi(m[1])
+			ppid, _ := strconv.Atoi(m[2])
+			pending = &policy.Exec{Time: time.Now(), PID: pid, PPID: ppid, Path: m[3]}
+			continue
+		}
+		if pending != nil {
+			pending.Argv = strings.Split(line, "\t")
+			t.exec(*pending)
+			pending = nil
+		}
+	}
+	if pending != nil {
+		t.exec(*pending)
+	}
+}
+
+// parseDTraceExec parses dtraceExecScript's output.
+func parseDTraceExec(t *ExecTracer, lines *bufio.Scanner) {
+	for lines.Scan() {
+		line := lines.Text()
+		if m := traceForkPattern.FindStringSubmatch(line); m != nil {
+			parent, _ := strconv.Atoi(m[1])
+			child, _ := strconv.Atoi(m[2])
+			t.fork(parent, child)
+		} else if m := traceExecPattern.FindStringSubmatch(line); m != nil {
+			pid, _ := strconv.Atoi(m[1])
+			ppid, _ := strconv.Atoi(m[2])
+			t.exec(policy.Exec{PID: pid, PPID: ppid, Argv: strings.Fields(m[3])})
+		}
+	}
+}
+
+// esProcess is the part of an Endpoint Security process eslogger writes
+// that the tracer uses.
+type esProcess struct {
+	AuditToken struct {
+		PID int `json:"pid"`
+	} `json:"audit_token"`
+	PPID       int `json:"ppid"`
+	Executable struct {
+		Path string `json:"path"`
+	} `json:"executable"`
+}
+
+// esEvent handles one line of eslogger output: an exec or fork event.
+func (t *ExecTracer) esEvent(line []byte) {
+	var msg struct {
+		Process esProcess `json:"process"`
+		Event   struct {
+			Exec *struct {
+				Target esProcess `json:"target"`
+				Args   []string  `json:"args"`
+			} `json:"exec"`
+			Fork *struct {
+				Child esProcess `json:"child"`
+			} `json:"fork"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	switch {
+	case msg.Event.Fork != nil:
+		t.fork(msg.Process.AuditToken.PID, msg.Event.Fork.Child.AuditToken.PID)
+	case msg.Event.Exec != nil:
+		target := msg.Event.Exec.Target
+		t.exec(policy.Exec{
+			PID:  target.AuditToken.PID,
+			PPID: target.PPID,
+			Path: target.Executable.Path,
+			Argv: msg.Event.Exec.Args,
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package sandbox
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// argvs returns the argv of each recorded exec.
+func argvs(log *policy.ExecLog) [][]string {
+	execs, _ := log.Execs()
+	var got [][]string
+	for _, e := range execs {
+		got = append(got, e.Argv)
+	}
+	return got
+}
+
+func TestExecTracerTree(t *testing.T) {
+	log := policy.NewExecLog()
+	tr := newExecTracer(log, policy.SourceEBPF, false)
+	self := os.Getpid()
+
+	tr.fork(self, 100) // The command
+	tr.fork(self, 200) // A bridge
+	tr.exec(policy.Exec{PID: 100, PPID: self, Argv: []string{"sh", "-c", "make"}})
+	tr.exec(policy.Exec{PID: 200, PPID: self, Argv: []string{"socat"}})
+	if got := argvs(log); got != nil {
+		t.Fatalf("execs recorded before SetRoot: %q", got)
+	}
+
+	tr.SetRoot(100)
+	tr.fork(100, 101)
+	tr.exec(policy.Exec{PID: 101, PPID: 100, Argv: []string{"make"}})
+	tr.exec(policy.Exec{PID: 102, PPID: 101, Argv: []string{"cc"}}) // Fork missed
+	tr.exec(policy.Exec{PID: 201, PPID: 200, Argv: []string{"nc"}})
+	tr.exec(policy.Exec{PID: 300, PPID: 1, Argv: []string{"cron"}})
+
+	want := [][]string{{"sh", "-c", "make"}, {"make"}, {"cc"}}
+	if got := argvs(log); !reflect.DeepEqual(got, want) {
+		t.Errorf("execs = %q, want %q", got, want)
+	}
+	execs, _ := log.Execs()
+	if execs[0].Source != policy.SourceEBPF {
+		t.Errorf("Source = %q, want %q", execs[0].Source, policy.SourceEBPF)
+	}
+}
+
+func TestParseBpftraceExec(t *testing.T) {
+	log := policy.NewExecLog()
+	tr := newExecTracer(log, policy.SourceEBPF, false)
+	tr.SetRoot(os.Getpid())
+	out := strings.Join([]string{
+		"FORK 1 50", // Not ours
+		"EXEC 50 1 /bin/false",
+		"false",
+		"FORK " + itoa(os.Getpid()) + " 100",
+		"EXEC 100 " + itoa(os.Getpid()) + " /usr/bin/git",
+		"git\tcommit\t-m\tfix a bug",
+	}, "\n")
+	parseBpftraceExec(tr, bufio.NewScanner(strings.NewReader(out)))
+
+	execs, _ := log.Execs()
+	if len(execs) != 1 {
+		t.Fatalf("got %d execs, want 1: %+v", len(execs), execs)
+	}
+	want := []string{"git", "commit", "-m", "fix a bug"}
+	if e := execs[0]; e.PID != 100 || e.Path != "/usr/bin/git" || !reflect.DeepEqual(e.Argv, want) {
+		t.Errorf("exec = %+v, want pid 100 running %q", e, want)
+	}
+}
+
+func TestParseDTraceExec(t *testing.T) {
+	log := policy.NewExecLog()
+	tr := newExecTracer(log, policy.SourceDTrace, false)
+	tr.SetRoot(os.Getpid())
+	out := "FORK " + itoa(os.Getpid()) + " 100\nEXEC 100 " + itoa(os.Getpid()) + " npm run build\n"
+	parseDTraceExec(tr, bufio.NewScanner(strings.NewReader(out)))
+
+	if got, want := argvs(log), [][]string{{"npm", "run", "build"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("execs = %q, want %q", got, want)
+	}
+}
+
+func TestESEvent(t *testing.T) {
+	log := policy.NewExecLog()
+	tr := newExecTracer(log, policy.SourceESLogger, false)
+	tr.SetRoot(os.Getpid())
+	self := itoa(os.Getpid())
+	tr.esEvent([]byte(`{"process":{"audit_token":{"pid":` + self + `}},"event":{"fork":{"child":{"audit_token":{"pid":100}}}}}`))
+	tr.esEvent([]byte(`{"process":{"audit_token":{"pid":100}},"event":{"exec":{"target":{"audit_token":{"pid":100},"ppid":` + self + `,"executable":{"path":"/usr/bin/curl"}},"args":["curl","-s","https://example.com"]}}}`))
+	tr.esEvent([]byte(`not json`))
+
+	execs, _ := log.Execs()
+	if len(execs) != 1 {
+		t.Fatalf("got %d execs, want 1: %+v", len(execs), execs)
+	}
+	if e := execs[0]; e.PID != 100 || e.Path != "/usr/bin/curl" || len(e.Argv) != 3 {
+		t.Errorf("exec = %+v", e)
+	}
+}
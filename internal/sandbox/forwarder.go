@@ -0,0 +1,268 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// Reverse bridges dial a socket the sandbox creates once its listener is up,
+// so early connections retry for a while before giving up.
+const (
+	unixDialAttempts = 50
+	unixDialInterval = 100 * time.Millisecond
+)
+
+// forwarder accepts connections on a listener and pipes each one to a new
+// connection opened by dial. It replaces a `socat ...,fork` process: every
+// connection is handled by goroutines in the fence process, so nothing is
+// left running if fence exits.
+type forwarder struct {
+	listener net.Listener
+	dial     func(ctx context.Context) (net.Conn, error)
+	name     string
+	debug    bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// newForwarder starts forwarding connections accepted on listener.
+// name identifies the forwarder in debug output.
+func newForwarder(listener net.Listener, name string, dial func(ctx context.Context) (net.Conn, error), debug bool) *forwarder {
+	f := &forwarder{
+		listener: listener,
+		dial:     dial,
+		name:     name,
+		debug:    debug,
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+func (f *forwarder) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if f.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.logDebug("accept failed: %v", err)
+			}
+			return
+		}
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+// handle pipes conn to a newly dialed upstream connection until both
+// directions are done or the forwarder shuts down.
+func (f *forwarder) handle(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() { _ = conn.Close() }()
+
+	upstream, err := f.dial(f.ctx)
+	if err != nil {
+		f.logDebug("dial failed: %v", err)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+
+	stop := context.AfterFunc(f.ctx, func() {
+		_ = conn.Close()
+		_ = upstream.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn, upstream)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst, then half-closes dst so the peer sees EOF while
+// the other direction keeps flowing.
+func pipe(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// Shutdown stops accepting connections, closes open ones, and waits for
+// their goroutines to finish until ctx is done.
+func (f *forwarder) Shutdown(ctx context.Context) error {
+	f.cancel()
+	_ = f.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s forwarder: %w", f.name, ctx.Err())
+	}
+}
+
+func (f *forwarder) logDebug(format string, args ...interface{}) {
+	if f.debug {
+		logging.Debugf("bridge", "%s: "+format, append([]interface{}{f.name}, args...)...)
+	}
+}
+
+// datagramTimeout bounds the exchange for one datagram forwarded by a
+// datagramForwarder.
+const datagramTimeout = 10 * time.Second
+
+// datagramForwarder forwards each datagram received on conn over a new
+// connection opened by dial, framed with a two-byte length as in DNS over
+// TCP, and sends the framed reply back to the datagram's sender.
+type datagramForwarder struct {
+	conn   net.PacketConn
+	dial   func(ctx context.Context) (net.Conn, error)
+	name   string
+	debug  bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newDatagramForwarder starts forwarding datagrams received on conn.
+// name identifies the forwarder in debug output.
+func newDatagramForwarder(conn net.PacketConn, name string, dial func(ctx context.Context) (net.Conn, error), debug bool) *datagramForwarder {
+	f := &datagramForwarder{
+		conn:  conn,
+		dial:  dial,
+		name:  name,
+		debug: debug,
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+func (f *datagramForwarder) serve() {
+	defer f.wg.Done()
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			if f.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.logDebug("read failed: %v", err)
+			}
+			return
+		}
+		msg := append(binary.BigEndian.AppendUint16(nil, uint16(n)), buf[:n]...)
+		f.wg.Add(1)
+		go f.handle(msg, addr)
+	}
+}
+
+// handle sends the framed datagram msg upstream and relays the reply to addr.
+func (f *datagramForwarder) handle(msg []byte, addr net.Addr) {
+	defer f.wg.Done()
+	ctx, cancel := context.WithTimeout(f.ctx, datagramTimeout)
+	defer cancel()
+
+	upstream, err := f.dial(ctx)
+	if err != nil {
+		f.logDebug("dial failed: %v", err)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = upstream.Close() })
+	defer stop()
+
+	if _, err := upstream.Write(msg); err != nil {
+		f.logDebug("write failed: %v", err)
+		return
+	}
+	var size uint16
+	if err := binary.Read(upstream, binary.BigEndian, &size); err != nil {
+		f.logDebug("read failed: %v", err)
+		return
+	}
+	reply := make([]byte, size)
+	if _, err := io.ReadFull(upstream, reply); err != nil {
+		f.logDebug("read failed: %v", err)
+		return
+	}
+	_, _ = f.conn.WriteTo(reply, addr)
+}
+
+// Shutdown stops receiving datagrams and waits for the ones in flight until
+// ctx is done.
+func (f *datagramForwarder) Shutdown(ctx context.Context) error {
+	f.cancel()
+	_ = f.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s forwarder: %w", f.name, ctx.Err())
+	}
+}
+
+func (f *datagramForwarder) logDebug(format string, args ...interface{}) {
+	if f.debug {
+		logging.Debugf("bridge", "%s: "+format, append([]interface{}{f.name}, args...)...)
+	}
+}
+
+// dialTCP returns a dial function for a forwarder that connects to addr.
+func dialTCP(addr string) func(ctx context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// dialUnixRetry returns a dial function for a forwarder that connects to the
+// Unix socket at path, retrying while the socket does not exist yet.
+func dialUnixRetry(path string) func(ctx context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		for attempt := 1; ; attempt++ {
+			conn, err := d.DialContext(ctx, "unix", path)
+			if err == nil || attempt >= unixDialAttempts {
+				return conn, err
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(unixDialInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts a TCP server that echoes each line back.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestForwarderUnixToTCP(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "fwd.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newForwarder(ln, "test", dialTCP(startEchoServer(t)), false)
+	defer func() { _ = f.Shutdown(context.Background()) }()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("dial %s: %v", socketPath, err)
+		}
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "hello\n" {
+			t.Errorf("read %q, %v; want %q", line, err, "hello\n")
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestForwarderShutdownClosesConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newForwarder(ln, "test", dialTCP(startEchoServer(t)), false)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatalf("forwarding failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := f.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed by Shutdown, got %v", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected listener to be closed by Shutdown")
+	}
+}
+
+func TestDatagramForwarder(t *testing.T) {
+	// The echo server sends each framed message back as the framed reply
+	upstream := startEchoServer(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newDatagramForwarder(conn, "test", dialTCP(upstream), false)
+	defer func() { _ = f.Shutdown(context.Background()) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if _, err := client.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	if err != nil || string(buf[:n]) != "query" {
+		t.Errorf("read %q, %v; want the datagram echoed back unframed", buf[:n], err)
+	}
+}
+
+func TestDialUnixRetryWaitsForSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "late.sock")
+	go func() {
+		time.Sleep(3 * unixDialInterval)
+		ln, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return
+		}
+		defer func() { _ = ln.Close() }()
+		conn, err := ln.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := dialUnixRetry(socketPath)(context.Background())
+	if err != nil {
+		t.Fatalf("dialUnixRetry() error = %v", err)
+	}
+	_ = conn.Close()
+}
@@ -3,6 +3,9 @@
 package sandbox
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -231,6 +234,46 @@ func TestMacOS_ProxyAllowsAllowedDomains(t *testing.T) {
 	assertContains(t, result.Stdout, "httpbin")
 }
 
+// TestMacOS_ExposedPortsOnly verifies that only the ports exposed with -p can
+// be listened on, as with the reverse bridge on Linux.
+func TestMacOS_ExposedPortsOnly(t *testing.T) {
+	skipIfAlreadySandboxed(t)
+	skipIfCommandNotFound(t, "python3")
+
+	var ports []int
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+		_ = l.Close()
+	}
+	exposed, other := ports[0], ports[1]
+
+	workspace := createTempWorkspace(t)
+	manager := NewManager(testConfigWithWorkspace(workspace), false, false)
+	defer manager.Cleanup()
+	manager.SetExposedPorts([]int{exposed})
+	if err := manager.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	listen := func(port int) *SandboxTestResult {
+		command := fmt.Sprintf(`python3 -c 'import socket; s = socket.socket(); s.bind(("0.0.0.0", %d)); s.listen(); print("LISTENING")'`, port)
+		wrapped, err := manager.WrapCommand(context.Background(), command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeShellCommandWithTimeout(t, wrapped, workspace, 10*time.Second)
+	}
+
+	result := listen(exposed)
+	assertAllowed(t, result)
+	assertContains(t, result.Stdout, "LISTENING")
+	assertBlocked(t, listen(other))
+}
+
 // ============================================================================
 // Python Compatibility Tests
 // ============================================================================
@@ -161,11 +161,11 @@ func runUnderSandbox(t *testing.T, cfg *config.Config, command string, workDir s
 	manager := NewManager(cfg, false, false)
 	defer manager.Cleanup()
 
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		return &SandboxTestResult{Error: err}
 	}
 
-	wrappedCmd, err := manager.WrapCommand(command)
+	wrappedCmd, err := manager.WrapCommand(context.Background(), command)
 	if err != nil {
 		// Command was blocked before execution
 		return &SandboxTestResult{
@@ -194,11 +194,11 @@ func runUnderSandboxWithTimeout(t *testing.T, cfg *config.Config, command string
 	manager := NewManager(cfg, false, false)
 	defer manager.Cleanup()
 
-	if err := manager.Initialize(); err != nil {
+	if err := manager.Initialize(context.Background()); err != nil {
 		return &SandboxTestResult{Error: err}
 	}
 
-	wrappedCmd, err := manager.WrapCommand(command)
+	wrappedCmd, err := manager.WrapCommand(context.Background(), command)
 	if err != nil {
 		return &SandboxTestResult{
 			ExitCode: 1,
@@ -3,37 +3,52 @@
 package sandbox
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"golang.org/x/sys/unix"
 )
 
-// LinuxBridge holds the socat bridge processes for Linux sandboxing (outbound).
+// LinuxBridge forwards the sandbox's proxy sockets to the host proxies (outbound).
 type LinuxBridge struct {
-	HTTPSocketPath  string
-	SOCKSSocketPath string
-	httpProcess     *exec.Cmd
-	socksProcess    *exec.Cmd
+	HTTPSocketPath  string // Empty if there is no HTTP proxy
+	SOCKSSocketPath string // Empty if there is no SOCKS proxy
+	DNSSocketPath   string // Empty unless network.filterDNS is set
+	ResolvConfPath  string // The sandbox's /etc/resolv.conf, with DNSSocketPath
+	http            *forwarder
+	socks           *forwarder
+	id              string // Random part of the socket names
 	debug           bool
 }
 
-// ReverseBridge holds the socat bridge processes for inbound connections.
+// ReverseBridge forwards host ports into the sandbox (inbound).
 type ReverseBridge struct {
 	Ports       []int
 	SocketPaths []string // Unix socket paths for each port
-	processes   []*exec.Cmd
+	forwarders  []*forwarder
 	debug       bool
 }
 
+// NobodyID is the uid and gid the sandboxed command runs as when
+// security.mapToNobody is enabled.
+const NobodyID = 65534
+
 // LinuxSandboxOptions contains options for the Linux sandbox.
 type LinuxSandboxOptions struct {
 	// Enable Landlock filesystem restrictions (requires kernel 5.13+)
@@ -46,104 +61,236 @@ type LinuxSandboxOptions struct {
 	Monitor bool
 	// Debug mode
 	Debug bool
+	// Filtered D-Bus sockets to expose in place of the host buses (nil blocks D-Bus)
+	DBusProxy *DBusProxy
+	// Log that monitors record detected violations in (optional)
+	Violations *policy.ViolationLog
+	// ShareNetwork keeps the host's network namespace, leaving the network
+	// unrestricted.
+	ShareNetwork bool
+	// Terminal runs the command without --new-session, keeping its
+	// controlling terminal, which must then be a PTY created for it.
+	Terminal bool
+	// Overlay mounts a copy-on-write overlay over a directory (optional).
+	// Only the bwrap and native backends support it.
+	Overlay *WorkspaceOverlay
+	// CABundle is the CA bundle written for network.inspectTLS, bound into
+	// the sandbox read-only at its own path and over the system bundle
+	// (optional).
+	CABundle string
+	// Audit lets commands that command.enforceExec would block run, logging
+	// them instead.
+	Audit bool
+	// ProxyAuth puts the credentials from the FENCE_PROXY_AUTH environment
+	// variable in the proxy URLs, for network.proxyAuth.
+	ProxyAuth bool
+	// Backend sets up the sandbox: BackendBwrap, BackendNative,
+	// BackendGVisor, BackendAppArmor, or BackendSELinux. The default is resolved by
+	// LinuxFeatures.ResolveBackend.
+	Backend string
+	// appArmor tracks the AppArmor profiles loaded for the Manager, which
+	// removes them at Shutdown; without it they stay loaded.
+	appArmor *appArmorProfiles
+	// selinux tracks the SELinux modules loaded for the Manager, likewise.
+	selinux *selinuxModules
+	// cgroup enforces resources limits on the command (optional).
+	cgroup *runCgroup
+	// readMasks masks denyRead paths with files of their own, for the
+	// fanotify monitor, where eBPF is not available (optional).
+	readMasks *readMasks
 }
 
 // NewLinuxBridge creates Unix socket bridges to the proxy servers.
 // This allows sandboxed processes to communicate with the host's proxy (outbound).
-func NewLinuxBridge(httpProxyPort, socksProxyPort int, debug bool) (*LinuxBridge, error) {
-	if _, err := exec.LookPath("socat"); err != nil {
-		return nil, fmt.Errorf("socat is required on Linux but not found: %w", err)
+// The bridges forward connections in-process and run until Cleanup. A port of
+// 0 leaves that proxy's bridge out.
+func NewLinuxBridge(ctx context.Context, httpProxyPort, socksProxyPort int, debug bool) (*LinuxBridge, error) {
+	bridge, httpListener, socksListener, err := newBridgeSockets(ctx, httpProxyPort > 0, socksProxyPort > 0, debug)
+	if err != nil {
+		return nil, err
 	}
 
-	id := make([]byte, 8)
-	if _, err := rand.Read(id); err != nil {
-		return nil, fmt.Errorf("failed to generate socket ID: %w", err)
+	// Unix socket -> TCP proxy
+	if httpListener != nil {
+		bridge.http = newForwarder(httpListener, "http", dialTCP(fmt.Sprintf("localhost:%d", httpProxyPort)), debug)
+	}
+	if socksListener != nil {
+		bridge.socks = newForwarder(socksListener, "socks", dialTCP(fmt.Sprintf("localhost:%d", socksProxyPort)), debug)
 	}
-	socketID := hex.EncodeToString(id)
-
-	tmpDir := os.TempDir()
-	httpSocketPath := filepath.Join(tmpDir, fmt.Sprintf("fence-http-%s.sock", socketID))
-	socksSocketPath := filepath.Join(tmpDir, fmt.Sprintf("fence-socks-%s.sock", socketID))
 
-	bridge := &LinuxBridge{
-		HTTPSocketPath:  httpSocketPath,
-		SOCKSSocketPath: socksSocketPath,
-		debug:           debug,
+	if debug {
+		logging.Debugf("linux", "Bridges ready (HTTP: %s, SOCKS: %s)", bridge.HTTPSocketPath, bridge.SOCKSSocketPath)
 	}
+	return bridge, nil
+}
 
-	// Start HTTP bridge: Unix socket -> TCP proxy
-	httpArgs := []string{
-		fmt.Sprintf("UNIX-LISTEN:%s,fork,reuseaddr", httpSocketPath),
-		fmt.Sprintf("TCP:localhost:%d", httpProxyPort),
+// NewLinuxSocketBridge creates the bridge sockets for network.unixSocketProxies
+// and returns them for the proxies to serve on directly, so that the proxies
+// have no TCP port on the host. A listener is nil unless asked for with http
+// or socks. The proxies close the listeners when they stop; Shutdown
+// removes the socket files.
+func NewLinuxSocketBridge(ctx context.Context, http, socks, debug bool) (bridge *LinuxBridge, httpListener, socksListener net.Listener, err error) {
+	bridge, httpListener, socksListener, err = newBridgeSockets(ctx, http, socks, debug)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	bridge.httpProcess = exec.Command("socat", httpArgs...) //nolint:gosec // args constructed from trusted input
 	if debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Starting HTTP bridge: socat %s\n", strings.Join(httpArgs, " "))
-	}
-	if err := bridge.httpProcess.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start HTTP bridge: %w", err)
+		logging.Debugf("linux", "Proxy sockets ready (HTTP: %s, SOCKS: %s)", bridge.HTTPSocketPath, bridge.SOCKSSocketPath)
 	}
+	return bridge, httpListener, socksListener, nil
+}
 
-	// Start SOCKS bridge: Unix socket -> TCP proxy
-	socksArgs := []string{
-		fmt.Sprintf("UNIX-LISTEN:%s,fork,reuseaddr", socksSocketPath),
-		fmt.Sprintf("TCP:localhost:%d", socksProxyPort),
+// newBridgeSockets creates a bridge with the HTTP and SOCKS sockets asked
+// for, listening on them. The sockets are only accessible to the current
+// user.
+func newBridgeSockets(ctx context.Context, http, socks, debug bool) (*LinuxBridge, net.Listener, net.Listener, error) {
+	// Without the fence binary to run the bridge helper, the listeners inside
+	// the sandbox are socat
+	if _, canReexec := fenceHelperPath(); !canReexec {
+		if _, err := exec.LookPath("socat"); err != nil {
+			return nil, nil, nil, &MissingDependencyError{Binary: "socat", Err: err}
+		}
 	}
-	bridge.socksProcess = exec.Command("socat", socksArgs...) //nolint:gosec // args constructed from trusted input
-	if debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Starting SOCKS bridge: socat %s\n", strings.Join(socksArgs, " "))
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
 	}
-	if err := bridge.socksProcess.Start(); err != nil {
-		bridge.Cleanup()
-		return nil, fmt.Errorf("failed to start SOCKS bridge: %w", err)
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate socket ID: %w", err)
 	}
 
-	// Wait for sockets to be created, up to 5 seconds
-	for range 50 {
-		httpExists := fileExists(httpSocketPath)
-		socksExists := fileExists(socksSocketPath)
-		if httpExists && socksExists {
-			if debug {
-				fmt.Fprintf(os.Stderr, "[fence:linux] Bridges ready (HTTP: %s, SOCKS: %s)\n", httpSocketPath, socksSocketPath)
+	bridge := &LinuxBridge{id: hex.EncodeToString(id), debug: debug}
+
+	var httpListener, socksListener net.Listener
+	var err error
+	if http {
+		httpListener, bridge.HTTPSocketPath, err = bridge.listen(ctx, "http")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to start HTTP bridge: %w", err)
+		}
+	}
+	if socks {
+		socksListener, bridge.SOCKSSocketPath, err = bridge.listen(ctx, "socks")
+		if err != nil {
+			if httpListener != nil {
+				_ = httpListener.Close()
 			}
-			return bridge, nil
+			bridge.Cleanup()
+			return nil, nil, nil, fmt.Errorf("failed to start SOCKS bridge: %w", err)
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
+	return bridge, httpListener, socksListener, nil
+}
 
-	bridge.Cleanup()
-	return nil, fmt.Errorf("timeout waiting for bridge sockets to be created")
+// listen creates the bridge socket for name, accessible only to the current
+// user.
+func (b *LinuxBridge) listen(ctx context.Context, name string) (net.Listener, string, error) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("fence-%s-%s.sock", name, b.id))
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, "", err
+	}
+	return ln, path, nil
 }
 
-// Cleanup stops the bridge processes and removes socket files.
+// listenDNS creates the socket the sandbox's DNS queries are forwarded to,
+// for network.filterDNS, and the resolv.conf that points the sandbox at it
+// with the search domains in search. The DNS server closes the listener when
+// it stops; Shutdown removes the files.
+func (b *LinuxBridge) listenDNS(ctx context.Context, search []string) (net.Listener, error) {
+	ln, path, err := b.listen(ctx, "dns")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start DNS bridge: %w", err)
+	}
+	b.DNSSocketPath = path
+	b.ResolvConfPath = filepath.Join(os.TempDir(), fmt.Sprintf("fence-resolv-%s.conf", b.id))
+	resolvConf := "nameserver " + sandboxNameserver + "\n"
+	if len(search) > 0 {
+		resolvConf += "search " + strings.Join(search, " ") + "\n"
+	}
+	if err := os.WriteFile(b.ResolvConfPath, []byte(resolvConf), 0o644); err != nil { //nolint:gosec // read by the sandboxed command
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to write resolv.conf: %w", err)
+	}
+	return ln, nil
+}
+
+// clearAmbientCaps drops the ambient capabilities the bridge helper is
+// given to bind the sandbox's DNS port, so that the command it starts does
+// not inherit them.
+func clearAmbientCaps() error {
+	return unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+}
+
+// Cleanup stops the bridges and removes socket files.
 func (b *LinuxBridge) Cleanup() {
-	if b.httpProcess != nil && b.httpProcess.Process != nil {
-		_ = b.httpProcess.Process.Kill()
-		_ = b.httpProcess.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	defer cancel()
+	_ = b.Shutdown(ctx)
+}
+
+// sessionResources returns the bridge's sockets for the session state file.
+// The bridges run in the fence process, so there are no helpers to record.
+func (b *LinuxBridge) sessionResources() (helpers []helperProcess, files []string) {
+	files = b.socketPaths()
+	if b.ResolvConfPath != "" {
+		files = append(files, b.ResolvConfPath)
+	}
+	return nil, files
+}
+
+// socketPaths returns the paths of the bridge sockets there are.
+func (b *LinuxBridge) socketPaths() []string {
+	var paths []string
+	for _, p := range []string{b.HTTPSocketPath, b.SOCKSSocketPath, b.DNSSocketPath} {
+		if p != "" {
+			paths = append(paths, p)
+		}
 	}
-	if b.socksProcess != nil && b.socksProcess.Process != nil {
-		_ = b.socksProcess.Process.Kill()
-		_ = b.socksProcess.Wait()
+	return paths
+}
+
+// Shutdown stops the bridges, closing open connections and waiting for them
+// until ctx is done, and removes the socket files.
+func (b *LinuxBridge) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, f := range []*forwarder{b.http, b.socks} {
+		if f != nil {
+			errs = append(errs, f.Shutdown(ctx))
+		}
 	}
 
 	// Clean up socket files
-	_ = os.Remove(b.HTTPSocketPath)
-	_ = os.Remove(b.SOCKSSocketPath)
+	for _, p := range b.socketPaths() {
+		_ = os.Remove(p)
+	}
+	if b.ResolvConfPath != "" {
+		_ = os.Remove(b.ResolvConfPath)
+	}
 
 	if b.debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Bridges cleaned up\n")
+		logging.Debugf("linux", "Bridges cleaned up")
 	}
+	return errors.Join(errs...)
 }
 
 // NewReverseBridge creates Unix socket bridges for inbound connections.
 // Host listens on ports, forwards to Unix sockets that go into the sandbox.
-func NewReverseBridge(ports []int, debug bool) (*ReverseBridge, error) {
+// Setup stops early if ctx is canceled; the bridges run until Cleanup.
+func NewReverseBridge(ctx context.Context, ports []int, debug bool) (*ReverseBridge, error) {
 	if len(ports) == 0 {
 		return nil, nil
 	}
-
-	if _, err := exec.LookPath("socat"); err != nil {
-		return nil, fmt.Errorf("socat is required on Linux but not found: %w", err)
+	if _, canReexec := fenceHelperPath(); !canReexec {
+		if _, err := exec.LookPath("socat"); err != nil {
+			return nil, &MissingDependencyError{Binary: "socat", Err: err}
+		}
 	}
 
 	id := make([]byte, 8)
@@ -158,42 +305,56 @@ func NewReverseBridge(ports []int, debug bool) (*ReverseBridge, error) {
 		debug: debug,
 	}
 
+	var lc net.ListenConfig
 	for _, port := range ports {
+		if err := ctx.Err(); err != nil {
+			bridge.Cleanup()
+			return nil, err
+		}
+
 		socketPath := filepath.Join(tmpDir, fmt.Sprintf("fence-rev-%d-%s.sock", port, socketID))
 		bridge.SocketPaths = append(bridge.SocketPaths, socketPath)
 
-		// Start reverse bridge: TCP listen on host port -> Unix socket
-		// The sandbox will create the Unix socket with UNIX-LISTEN
-		// We use retry to wait for the socket to be created by the sandbox
-		args := []string{
-			fmt.Sprintf("TCP-LISTEN:%d,fork,reuseaddr", port),
-			fmt.Sprintf("UNIX-CONNECT:%s,retry=50,interval=0.1", socketPath),
-		}
-		proc := exec.Command("socat", args...) //nolint:gosec // args constructed from trusted input
-		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:linux] Starting reverse bridge for port %d: socat %s\n", port, strings.Join(args, " "))
-		}
-		if err := proc.Start(); err != nil {
+		// TCP listen on host port -> Unix socket. The sandbox creates the
+		// socket once its listener is up, so connections retry until it exists.
+		listener, err := lc.Listen(ctx, "tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
 			bridge.Cleanup()
 			return nil, fmt.Errorf("failed to start reverse bridge for port %d: %w", port, err)
 		}
-		bridge.processes = append(bridge.processes, proc)
+		if debug {
+			logging.Debugf("linux", "Starting reverse bridge for port %d -> %s", port, socketPath)
+		}
+		name := fmt.Sprintf("reverse:%d", port)
+		bridge.forwarders = append(bridge.forwarders, newForwarder(listener, name, dialUnixRetry(socketPath), debug))
 	}
 
 	if debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Reverse bridges ready for ports: %v\n", ports)
+		logging.Debugf("linux", "Reverse bridges ready for ports: %v", ports)
 	}
 
 	return bridge, nil
 }
 
-// Cleanup stops the reverse bridge processes and removes socket files.
+// Cleanup stops the reverse bridges and removes socket files.
 func (b *ReverseBridge) Cleanup() {
-	for _, proc := range b.processes {
-		if proc != nil && proc.Process != nil {
-			_ = proc.Process.Kill()
-			_ = proc.Wait()
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	defer cancel()
+	_ = b.Shutdown(ctx)
+}
+
+// sessionResources returns the bridge's sockets for the session state file.
+// The bridges run in the fence process, so there are no helpers to record.
+func (b *ReverseBridge) sessionResources() (helpers []helperProcess, files []string) {
+	return nil, b.SocketPaths
+}
+
+// Shutdown stops the reverse bridges, closing open connections and waiting
+// for them until ctx is done, and removes the socket files.
+func (b *ReverseBridge) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, f := range b.forwarders {
+		errs = append(errs, f.Shutdown(ctx))
 	}
 
 	// Clean up socket files
@@ -202,8 +363,25 @@ func (b *ReverseBridge) Cleanup() {
 	}
 
 	if b.debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Reverse bridges cleaned up\n")
+		logging.Debugf("linux", "Reverse bridges cleaned up")
 	}
+	return errors.Join(errs...)
+}
+
+// fenceHelperPath returns the path of the running executable and whether it
+// can be re-executed inside the sandbox for the fence helper modes
+// (--landlock-apply, --bridge). It cannot when fence is used as a library,
+// since only the fence CLI understands those flags, or when the executable
+// is in /tmp, which the sandbox hides (test binaries are built there).
+func fenceHelperPath() (string, bool) {
+	exe, err := os.Executable()
+	if err != nil || exe == "" {
+		return "", false
+	}
+	if strings.HasPrefix(exe, "/tmp/") || !strings.Contains(filepath.Base(exe), "fence") {
+		return exe, false
+	}
+	return exe, true
 }
 
 func fileExists(path string) bool {
@@ -287,23 +465,191 @@ func WrapCommandLinux(cfg *config.Config, command string, bridge *LinuxBridge, r
 
 // WrapCommandLinuxWithOptions wraps a command with configurable sandbox options.
 func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, opts LinuxSandboxOptions) (string, error) {
-	if _, err := exec.LookPath("bwrap"); err != nil {
-		return "", fmt.Errorf("bubblewrap (bwrap) is required on Linux but not found: %w", err)
+	sb, err := buildLinuxSandbox(cfg, command, bridge, reverseBridge, opts, false)
+	if err != nil {
+		return "", err
+	}
+
+	// Build the final command
+	bwrapCmd := ShellQuote(sb.bwrapArgs)
+
+	// Move the shell into the run cgroup first, so bwrap and everything the
+	// command starts is limited
+	if opts.cgroup != nil {
+		bwrapCmd = opts.cgroup.enter() + "; " + bwrapCmd
+	}
+
+	// If seccomp filter is enabled, wrap with fd redirection
+	// bwrap --seccomp expects the filter on the specified fd
+	if sb.seccompFilterPath != "" {
+		// Open filter file on fd 3, then run bwrap
+		// The filter file will be cleaned up after the sandbox exits
+		return fmt.Sprintf("exec 3<%s; %s", ShellQuoteSingle(sb.seccompFilterPath), bwrapCmd), nil
+	}
+
+	return bwrapCmd, nil
+}
+
+// LinuxSpec returns the enforcement artifacts WrapCommandLinuxWithOptions would
+// generate for command. No seccomp filter file is written and bwrap need not
+// be installed.
+func LinuxSpec(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, opts LinuxSandboxOptions) (*Spec, error) {
+	sb, err := buildLinuxSandbox(cfg, command, bridge, reverseBridge, opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{
+		Platform:        platform.Linux,
+		Command:         command,
+		BwrapArgs:       sb.bwrapArgs,
+		AppArmorProfile: sb.appArmorProfile,
+		SELinuxModule:   sb.selinuxModule,
+	}
+	if sb.lsm != nil {
+		spec.LSM = sb.lsm.name
+		if sb.lsm.profile != "" {
+			spec.AppArmorProfile = sb.lsm.profile
+		}
+		spec.SELinuxLevel = sb.lsm.level
+	}
+	if opts.cgroup != nil {
+		spec.Cgroup = opts.cgroup.path
+		spec.CgroupLimits = opts.cgroup.limits()
+	}
+	if sb.seccomp != nil {
+		spec.SeccompSyscalls = sb.seccomp.syscalls
+		spec.SeccompAllowlist = sb.seccomp.allowlist
+		spec.SeccompAction = sb.seccomp.action
+	}
+	if sb.landlock {
+		// The --landlock-apply wrapper runs in the same working directory
+		cwd, _ := os.Getwd()
+		spec.LandlockRules = LandlockRules(cfg, cwd, nil)
+		spec.LandlockNet = sb.landlockNet
+	}
+	return spec, nil
+}
+
+// linuxSandbox is the bwrap invocation built for a command.
+type linuxSandbox struct {
+	bwrapArgs []string
+	// seccompFilterPath is the BPF filter to open on fd 3; empty in dry runs.
+	seccompFilterPath string
+	// seccomp is the seccomp filter's policy, or nil if it is not applied.
+	seccomp *seccompPolicy
+	// landlock is set when the command runs under the --landlock-apply wrapper.
+	landlock bool
+	// landlockNet is the wrapper's network rules, if it restricts the network.
+	landlockNet *LandlockNetRules
+	// appArmorProfile is the profile the command runs under, with the
+	// apparmor backend.
+	appArmorProfile string
+	// selinuxModule is the policy module the command runs under, with the
+	// selinux backend.
+	selinuxModule string
+	// lsm is the security.lsm layer inside the sandbox, if any.
+	lsm *lsmLayer
+}
+
+// buildLinuxSandbox builds the bwrap arguments for command. In a dry run the
+// seccomp filter is not generated and a missing bwrap is not an error.
+func buildLinuxSandbox(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, opts LinuxSandboxOptions, dryRun bool) (*linuxSandbox, error) {
+	features := DetectLinuxFeatures()
+	backend := features.ResolveBackend(opts.Backend)
+	native := backend == BackendNative
+	gvisor := backend == BackendGVisor
+	apparmor := backend == BackendAppArmor
+	selinux := backend == BackendSELinux
+	if _, err := exec.LookPath("bwrap"); err != nil && !dryRun && backend == BackendBwrap {
+		return nil, &MissingDependencyError{Binary: "bwrap", Err: err}
+	}
+	if _, err := exec.LookPath("runsc"); err != nil && !dryRun && gvisor {
+		return nil, &MissingDependencyError{Binary: "runsc", Err: err}
+	}
+	if apparmor && !dryRun && !features.CanUseAppArmor() {
+		if !features.HasAppArmor {
+			return nil, errors.New("the apparmor backend requires AppArmor to be enabled, with apparmor_parser and aa-exec installed")
+		}
+		return nil, errors.New("the apparmor backend requires root to load its profile")
+	}
+	if selinux && !dryRun && !features.CanUseSELinux() {
+		if !features.HasSELinux {
+			return nil, errors.New("the selinux backend requires SELinux to be enforcing, with semodule and runcon installed")
+		}
+		return nil, errors.New("the selinux backend requires root to load its policy module")
+	}
+	if opts.Overlay != nil && backend != BackendBwrap && !native {
+		return nil, fmt.Errorf("copy-on-write mode is not supported with the %s backend", backend)
+	}
+	privateHomeOn := cfg != nil && cfg.Filesystem.PrivateHome
+	if privateHomeOn && backend != BackendBwrap && !native {
+		return nil, fmt.Errorf("filesystem.privateHome is not supported with the %s backend", backend)
+	}
+	// Binding the DNS port inside the sandbox takes --cap-add, which only bwrap has
+	dnsBridge := bridge != nil && bridge.DNSSocketPath != ""
+	if dnsBridge && backend != BackendBwrap {
+		return nil, fmt.Errorf("network.filterDNS is not supported with the %s backend", backend)
+	}
+
+	// The LSM layer is redundant with the apparmor and selinux backends, which
+	// already run the command under their LSM
+	lsmSetting := ""
+	if cfg != nil {
+		lsmSetting = cfg.Security.LSM
+	}
+	lsm, err := features.ResolveLSM(lsmSetting)
+	if err != nil {
+		if !dryRun {
+			return nil, err
+		}
+		lsm = lsmSetting
+	}
+	switch {
+	case apparmor || selinux:
+		lsm = ""
+	case gvisor && lsm != "":
+		if lsmSetting != config.LSMAuto {
+			return nil, errors.New("security.lsm is not supported with the gvisor backend")
+		}
+		lsm = ""
+	case privateHomeOn && lsm != "":
+		// The LSM rules would hide the tmpfs home along with the real one
+		if lsmSetting != config.LSMAuto {
+			return nil, errors.New("security.lsm is not supported with filesystem.privateHome")
+		}
+		lsm = ""
 	}
 
 	shell := "bash"
 	shellPath, err := exec.LookPath(shell)
 	if err != nil {
-		return "", fmt.Errorf("shell %q not found: %w", shell, err)
+		return nil, &MissingDependencyError{Binary: shell, Err: err}
 	}
 
 	cwd, _ := os.Getwd()
-	features := DetectLinuxFeatures()
 
 	if opts.Debug {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Available features: %s\n", features.Summary())
+		logging.Debugf("linux", "Available features: %s", features.Summary())
 	}
 
+	// The native backend is the fence binary interpreting the same arguments
+	// bwrap would get, in namespaces it creates itself. The gVisor backend is
+	// the fence binary translating them for runsc.
+	fenceExePath, canReexec := fenceHelperPath()
+	if gvisor && !dryRun && !canReexec {
+		return nil, errors.New("the gVisor backend requires the fence CLI (not available when fence is used as a library)")
+	}
+	if native && !dryRun {
+		if !canReexec {
+			return nil, errors.New("the native backend requires the fence CLI (not available when fence is used as a library)")
+		}
+		if !features.CanUnshareUser {
+			return nil, errors.New("the native backend requires unprivileged user namespaces (see kernel.unprivileged_userns_clone and user.max_user_namespaces)")
+		}
+	}
+	canUnshareNet := features.CanUnshareNetWith(backend) && !opts.ShareNetwork
+
 	// Check if allowedDomains contains "*" (wildcard = allow all direct network)
 	// In this mode, we skip network namespace isolation so apps that don't
 	// respect HTTP_PROXY can make direct connections.
@@ -313,42 +659,106 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 	}
 
 	if opts.Debug && hasWildcardAllow {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Wildcard allowedDomains detected - allowing direct network connections\n")
-		fmt.Fprintf(os.Stderr, "[fence:linux] Note: deniedDomains only enforced for apps that respect HTTP_PROXY\n")
+		logging.Debugf("linux", "Wildcard allowedDomains detected - allowing direct network connections")
+		logging.Debugf("linux", "Note: deniedDomains only enforced for apps that respect HTTP_PROXY")
+	}
+
+	// The DNS resolver listens in the sandbox's own network namespace; with
+	// the host's, the host's resolver is reachable anyway
+	filterDNS := dnsBridge && canUnshareNet && !hasWildcardAllow
+	if dnsBridge && !filterDNS && opts.Debug {
+		logging.Debugf("linux", "Skipping DNS resolver (no network namespace)")
+	}
+	if filterDNS && !canReexec && !dryRun {
+		return nil, errors.New("network.filterDNS requires the fence CLI (not available when fence is used as a library)")
 	}
 
 	// Build bwrap args with filesystem restrictions
-	bwrapArgs := []string{
-		"bwrap",
-		"--new-session",
-		"--die-with-parent",
+	bwrapArgs := []string{"bwrap"}
+	if !opts.Terminal {
+		// Keep the command from injecting input into the user's terminal
+		bwrapArgs = append(bwrapArgs, "--new-session")
 	}
+	bwrapArgs = append(bwrapArgs, "--die-with-parent")
 
 	// Only use --unshare-net if:
 	// 1. The environment supports it (has CAP_NET_ADMIN)
 	// 2. We're NOT in wildcard mode (need direct network access)
 	// Containerized environments (Docker, CI) often lack CAP_NET_ADMIN
-	if features.CanUnshareNet && !hasWildcardAllow {
+	if canUnshareNet && !hasWildcardAllow {
 		bwrapArgs = append(bwrapArgs, "--unshare-net") // Network namespace isolation
-	} else if opts.Debug && !features.CanUnshareNet {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Skipping --unshare-net (network namespace unavailable in this environment)\n")
+	} else if opts.Debug && opts.ShareNetwork {
+		logging.Debugf("linux", "Skipping --unshare-net (network not sandboxed)")
+	} else if opts.Debug && !canUnshareNet {
+		logging.Debugf("linux", "Skipping --unshare-net (network namespace unavailable in this environment)")
 	}
 
 	bwrapArgs = append(bwrapArgs, "--unshare-pid") // PID namespace isolation
 
+	// Run as nobody inside a user namespace so the command can't present the
+	// invoking user's identity (e.g. to the kernel keyring or D-Bus)
+	if cfg != nil && cfg.Security.MapToNobody {
+		id := strconv.Itoa(NobodyID)
+		bwrapArgs = append(bwrapArgs, "--unshare-user", "--uid", id, "--gid", id)
+		if opts.Debug {
+			logging.Debugf("linux", "Mapping sandbox user to uid/gid %d", NobodyID)
+		}
+	}
+
 	// Generate seccomp filter if available and requested
 	var seccompFilterPath string
-	if opts.UseSeccomp && features.HasSeccomp {
-		filter := NewSeccompFilter(opts.Debug)
+	useSeccomp := false
+	var seccompCfg config.SeccompConfig
+	if cfg != nil {
+		seccompCfg = cfg.Seccomp
+	}
+	seccomp := newSeccompPolicy(seccompCfg)
+	// With notify and ask, the exec guard in the Landlock wrapper reports
+	// the blocked syscalls; without the wrapper, they fail unreported
+	seccompNotify := false
+	if seccomp.notifies() && !canReexec {
+		if opts.UseSeccomp && !dryRun {
+			logging.Warnf("linux", "seccomp.action %q is not available without the fence CLI to run the supervisor; blocked syscalls fail with EPERM", seccomp.action)
+		}
+		seccomp.action = config.SeccompActionErrno
+	}
+	if opts.UseSeccomp && gvisor {
+		// gVisor's kernel handles the syscalls instead of the host's
+		if opts.Debug {
+			logging.Debugf("linux", "Skipping seccomp filter (syscalls are handled by gVisor)")
+		}
+	} else if opts.UseSeccomp && (apparmor || selinux) {
+		// aa-exec and runcon have no way to install the filter
+		if opts.Debug {
+			logging.Debugf("linux", "Skipping seccomp filter (not supported by the %s backend)", backend)
+		}
+	} else if opts.UseSeccomp && features.HasSeccomp && dryRun {
+		useSeccomp = true
+		seccompNotify = seccomp.notifies()
+		bwrapArgs = append(bwrapArgs, "--seccomp", "3")
+	} else if opts.UseSeccomp && features.HasSeccomp {
+		filter := NewSeccompFilter(seccompCfg, opts.Debug)
+		if seccomp.notifies() {
+			// The exec guard's filter sends the policy's syscalls to its
+			// supervisor. This one only checks the architecture, since its
+			// EPERM would win over the notifications.
+			filter.policy = seccompPolicy{action: config.SeccompActionErrno}
+		}
 		filterPath, err := filter.GenerateBPFFilter()
 		if err != nil {
 			if opts.Debug {
-				fmt.Fprintf(os.Stderr, "[fence:linux] Seccomp filter generation failed: %v\n", err)
+				logging.Debugf("linux", "Seccomp filter generation failed: %v", err)
 			}
 		} else {
 			seccompFilterPath = filterPath
+			useSeccomp = true
+			seccompNotify = seccomp.notifies()
 			if opts.Debug {
-				fmt.Fprintf(os.Stderr, "[fence:linux] Seccomp filter enabled (blocking %d dangerous syscalls)\n", len(DangerousSyscalls))
+				if seccomp.allowlist {
+					logging.Debugf("linux", "Seccomp filter enabled (allowing %d syscalls, action %s)", len(seccomp.syscalls), seccomp.action)
+				} else {
+					logging.Debugf("linux", "Seccomp filter enabled (blocking %d syscalls, action %s)", len(seccomp.syscalls), seccomp.action)
+				}
 			}
 			// Add seccomp filter via fd 3 (will be set up via shell redirection)
 			bwrapArgs = append(bwrapArgs, "--seccomp", "3")
@@ -394,6 +804,19 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 		}
 	}
 
+	// Replace the home directory with an empty one. The writable paths in it
+	// are bound over it below, and deny rules for the rest are not needed.
+	var home *privateHome
+	if privateHomeOn {
+		if home, err = newPrivateHome(cwd, fenceExePath); err != nil {
+			return nil, err
+		}
+		bwrapArgs = append(bwrapArgs, home.mountArgs()...)
+		for p := range writablePaths {
+			home.show(p)
+		}
+	}
+
 	// Make writable paths actually writable (override read-only root)
 	for p := range writablePaths {
 		if fileExists(p) {
@@ -401,32 +824,59 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 		}
 	}
 
+	// Send writes to the workspace to the overlay's upper directory, over
+	// the binds above. Paths in it that are denied below are mounted over the
+	// overlay, so they stay denied.
+	if o := opts.Overlay; o != nil {
+		bwrapArgs = append(bwrapArgs, "--overlay-src", o.Dir, "--overlay", o.Upper, o.Work, o.Dir)
+	}
+
 	// Handle denyRead paths - hide them
 	// For directories: use --tmpfs to replace with empty tmpfs
 	// For files: use --ro-bind /dev/null to mask with empty file
+	// The fanotify monitor needs masks of their own, which it watches instead
 	// Skip symlinks: they may point outside the sandbox and cause mount errors
-	if cfg != nil && cfg.Filesystem.DenyRead != nil {
-		expandedDenyRead := ExpandGlobPatterns(cfg.Filesystem.DenyRead)
-		for _, p := range expandedDenyRead {
-			if canMountOver(p) {
-				if isDirectory(p) {
-					bwrapArgs = append(bwrapArgs, "--tmpfs", p)
+	masks := opts.readMasks
+	if dryRun || features.HasEBPF || (backend != BackendBwrap && !native) {
+		masks = nil
+	}
+	hide := func(p string) {
+		dir := isDirectory(p)
+		if masks != nil {
+			mask, err := masks.mask(p, dir)
+			if err == nil {
+				if dir {
+					bwrapArgs = append(bwrapArgs, "--bind", mask, p)
 				} else {
-					// Mask file with /dev/null (appears as empty, unreadable)
-					bwrapArgs = append(bwrapArgs, "--ro-bind", "/dev/null", p)
+					bwrapArgs = append(bwrapArgs, "--ro-bind", mask, p)
 				}
+				return
+			}
+			if opts.Debug {
+				logging.Debugf("linux", "Failed to create a mask for %s, reads of it are not monitored: %v", p, err)
+			}
+		}
+		if dir {
+			bwrapArgs = append(bwrapArgs, "--tmpfs", p)
+		} else {
+			// Mask file with /dev/null (appears as empty, unreadable)
+			bwrapArgs = append(bwrapArgs, "--ro-bind", "/dev/null", p)
+		}
+	}
+	if cfg != nil {
+		denyRead := cfg.Filesystem.DenyReadPaths()
+		expandedDenyRead := ExpandGlobPatterns(denyRead)
+		for _, p := range expandedDenyRead {
+			if canMountOver(p) && !home.hides(p) {
+				hide(p)
 			}
 		}
 
 		// Add non-glob paths
-		for _, p := range cfg.Filesystem.DenyRead {
+		for _, p := range denyRead {
 			normalized := NormalizePath(p)
-			if !ContainsGlobChars(normalized) && canMountOver(normalized) {
-				if isDirectory(normalized) {
-					bwrapArgs = append(bwrapArgs, "--tmpfs", normalized)
-				} else {
-					bwrapArgs = append(bwrapArgs, "--ro-bind", "/dev/null", normalized)
-				}
+			if !ContainsGlobChars(normalized) && canMountOver(normalized) && !home.hides(normalized) {
+				hide(normalized)
 			}
 		}
 	}
@@ -444,7 +894,7 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 	// Deduplicate
 	seen := make(map[string]bool)
 	for _, p := range mandatoryDeny {
-		if !seen[p] && fileExists(p) {
+		if !seen[p] && fileExists(p) && !home.hides(p) {
 			seen[p] = true
 			bwrapArgs = append(bwrapArgs, "--ro-bind", p, p)
 		}
@@ -454,7 +904,7 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 	if cfg != nil && cfg.Filesystem.DenyWrite != nil {
 		expandedDenyWrite := ExpandGlobPatterns(cfg.Filesystem.DenyWrite)
 		for _, p := range expandedDenyWrite {
-			if fileExists(p) && !seen[p] {
+			if fileExists(p) && !seen[p] && !home.hides(p) {
 				seen[p] = true
 				bwrapArgs = append(bwrapArgs, "--ro-bind", p, p)
 			}
@@ -462,19 +912,40 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 		// Add non-glob paths
 		for _, p := range cfg.Filesystem.DenyWrite {
 			normalized := NormalizePath(p)
-			if !ContainsGlobChars(normalized) && fileExists(normalized) && !seen[normalized] {
+			if !ContainsGlobChars(normalized) && fileExists(normalized) && !seen[normalized] && !home.hides(normalized) {
 				seen[normalized] = true
 				bwrapArgs = append(bwrapArgs, "--ro-bind", normalized, normalized)
 			}
 		}
 	}
 
+	// Hide the host D-Bus sockets, or replace them with the filtered proxy
+	dbusArgs, dbusEnv := dbusMountArgs(opts.DBusProxy, canUnshareNet, opts.Debug)
+	bwrapArgs = append(bwrapArgs, dbusArgs...)
+
+	// Hide microphones and cameras unless devices.allowAudio/allowCamera is set
+	bwrapArgs = append(bwrapArgs, deviceMountArgs(cfg)...)
+
+	// Hide the X11/Wayland display unless gui.allowDisplay is set
+	displayArgs, displayUnset := displayMountArgs(cfg, canUnshareNet, opts.Debug)
+	bwrapArgs = append(bwrapArgs, displayArgs...)
+
 	// Bind the outbound Unix sockets into the sandbox (need to be writable)
 	if bridge != nil {
-		bwrapArgs = append(bwrapArgs,
-			"--bind", bridge.HTTPSocketPath, bridge.HTTPSocketPath,
-			"--bind", bridge.SOCKSSocketPath, bridge.SOCKSSocketPath,
-		)
+		for _, p := range bridge.socketPaths() {
+			bwrapArgs = append(bwrapArgs, "--bind", p, p)
+		}
+	}
+
+	// The CA bundle is in the host's /tmp, which the sandbox replaces, and
+	// also stands in for the system bundle
+	bwrapArgs = append(bwrapArgs, caBundleMountArgs(opts.CABundle)...)
+
+	// Point the resolver at the bridge helper's DNS listener, which needs
+	// to bind port 53. The helper drops the capability before starting the
+	// command.
+	if filterDNS {
+		bwrapArgs = append(bwrapArgs, "--ro-bind", bridge.ResolvConfPath, "/etc/resolv.conf", "--cap-add", "CAP_NET_BIND_SERVICE")
 	}
 
 	// Bind reverse socket directory if needed (sockets created inside sandbox)
@@ -484,55 +955,99 @@ func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *Lin
 		bwrapArgs = append(bwrapArgs, "--bind", tmpDir, tmpDir)
 	}
 
-	// Get fence executable path for Landlock wrapper
-	fenceExePath, _ := os.Executable()
-	// Skip Landlock wrapper if executable is in /tmp (test binaries are built there)
-	// The wrapper won't work because --tmpfs /tmp hides the test binary
-	executableInTmp := strings.HasPrefix(fenceExePath, "/tmp/")
-	// Skip Landlock wrapper if fence is being used as a library (executable is not fence)
-	// The wrapper re-executes the binary with --landlock-apply, which only fence understands
-	executableIsFence := strings.Contains(filepath.Base(fenceExePath), "fence")
-	useLandlockWrapper := opts.UseLandlock && features.CanUseLandlock() && fenceExePath != "" && !executableInTmp && executableIsFence
-
-	if opts.Debug && executableInTmp {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Skipping Landlock wrapper (executable in /tmp, likely a test)\n")
+	// The Landlock wrapper and the bridge helper re-execute the fence binary.
+	// gVisor does not implement Landlock; its mounts enforce the same rules.
+	// The wrapper also runs the exec guard for command.enforceExec, and to
+	// report the syscalls seccomp blocks.
+	enforceExec := cfg != nil && cfg.Command.UseExecEnforcement()
+	useExecGuard := (enforceExec || seccompNotify) && canReexec && !gvisor
+	useLandlockWrapper := (opts.UseLandlock && features.CanUseLandlock() || useExecGuard) && canReexec && !gvisor
+	// In the sandbox's own network namespace, Landlock also limits the
+	// loopback ports the command may bind and connect to
+	var landlockNet *LandlockNetRules
+	if useLandlockWrapper && opts.UseLandlock && canUnshareNet && !hasWildcardAllow {
+		var exposedPorts []int
+		if reverseBridge != nil {
+			exposedPorts = reverseBridge.Ports
+		}
+		landlockNet = landlockNetRules(cfg, bridge != nil, filterDNS, exposedPorts)
 	}
-	if opts.Debug && !executableIsFence {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Skipping Landlock wrapper (running as library, not fence CLI)\n")
+	if enforceExec && !useExecGuard && !dryRun {
+		reason := "without the fence CLI to run the exec guard"
+		if gvisor {
+			reason = "with the gvisor backend"
+		}
+		logging.Warnf("linux", "command.enforceExec is not enforced %s; commands are only checked before they start", reason)
 	}
 
-	bwrapArgs = append(bwrapArgs, "--", shellPath, "-c")
+	if opts.Debug && !canReexec {
+		if strings.HasPrefix(fenceExePath, "/tmp/") {
+			logging.Debugf("linux", "Skipping Landlock wrapper and bridge helper (executable in /tmp, likely a test)")
+		} else {
+			logging.Debugf("linux", "Skipping Landlock wrapper and bridge helper (running as library, not fence CLI)")
+		}
+	}
 
-	// Build the inner command that sets up socat listeners and runs the user command
+	// The fence --bridge helper listens on the sandbox's ends of the bridges
+	// and starts the shell once its listeners are up. Without it, socat
+	// listeners are started as background jobs of the shell.
+	hasBridges := bridge != nil || (reverseBridge != nil && len(reverseBridge.Ports) > 0)
+	useBridgeHelper := hasBridges && canReexec
+	useSocat := hasBridges && !canReexec
+
+	commandStart := len(bwrapArgs) + 1
+	bwrapArgs = append(bwrapArgs, "--")
+	if useBridgeHelper {
+		bwrapArgs = append(bwrapArgs, bridgeHelperArgs(fenceExePath, bridge, reverseBridge, filterDNS, opts.Debug, shellPath, "-c")...)
+	} else {
+		bwrapArgs = append(bwrapArgs, shellPath, "-c")
+	}
+
+	// Build the inner command that sets up the proxy environment and runs the user command
 	var innerScript strings.Builder
 
-	if bridge != nil {
-		// Set up outbound socat listeners inside the sandbox
+	// Set up outbound socat listeners inside the sandbox
+	if bridge != nil && useSocat && bridge.HTTPSocketPath != "" {
 		innerScript.WriteString(fmt.Sprintf(`
 # Start HTTP proxy listener (port 3128 -> Unix socket -> host HTTP proxy)
 socat TCP-LISTEN:3128,fork,reuseaddr UNIX-CONNECT:%s >/dev/null 2>&1 &
 HTTP_PID=$!
-
+`, bridge.HTTPSocketPath))
+	}
+	if bridge != nil && useSocat && bridge.SOCKSSocketPath != "" {
+		innerScript.WriteString(fmt.Sprintf(`
 # Start SOCKS proxy listener (port 1080 -> Unix socket -> host SOCKS proxy)
 socat TCP-LISTEN:1080,fork,reuseaddr UNIX-CONNECT:%s >/dev/null 2>&1 &
 SOCKS_PID=$!
+`, bridge.SOCKSSocketPath))
+	}
 
-# Set proxy environment variables
-export HTTP_PROXY=http://127.0.0.1:3128
-export HTTPS_PROXY=http://127.0.0.1:3128
-export http_proxy=http://127.0.0.1:3128
-export https_proxy=http://127.0.0.1:3128
-export ALL_PROXY=socks5h://127.0.0.1:1080
-export all_proxy=socks5h://127.0.0.1:1080
-export NO_PROXY=localhost,127.0.0.1
+	if bridge != nil {
+		innerScript.WriteString("\n# Set proxy environment variables\n")
+		userinfo := ""
+		if opts.ProxyAuth {
+			userinfo = proxyAuthRef + "@"
+		}
+		if bridge.HTTPSocketPath != "" {
+			innerScript.WriteString(strings.ReplaceAll(`export HTTP_PROXY="http://USERINFO127.0.0.1:3128"
+export HTTPS_PROXY="http://USERINFO127.0.0.1:3128"
+export http_proxy="http://USERINFO127.0.0.1:3128"
+export https_proxy="http://USERINFO127.0.0.1:3128"
+`, "USERINFO", userinfo))
+		}
+		if bridge.SOCKSSocketPath != "" {
+			innerScript.WriteString(strings.ReplaceAll(`export ALL_PROXY="socks5h://USERINFO127.0.0.1:1080"
+export all_proxy="socks5h://USERINFO127.0.0.1:1080"
+`, "USERINFO", userinfo))
+		}
+		innerScript.WriteString(`export NO_PROXY=localhost,127.0.0.1
 export no_proxy=localhost,127.0.0.1
-export FENCE_SANDBOX=1
-
-`, bridge.HTTPSocketPath, bridge.SOCKSSocketPath))
+`)
 	}
+	innerScript.WriteString("export FENCE_SANDBOX=1\n\n")
 
 	// Set up reverse (inbound) socat listeners inside the sandbox
-	if reverseBridge != nil && len(reverseBridge.Ports) > 0 {
+	if reverseBridge != nil && len(reverseBridge.Ports) > 0 && useSocat {
 		innerScript.WriteString("\n# Start reverse bridge listeners for inbound connections\n")
 		for i, port := range reverseBridge.Ports {
 			socketPath := reverseBridge.SocketPaths[i]
@@ -546,8 +1061,16 @@ export FENCE_SANDBOX=1
 		innerScript.WriteString("\n")
 	}
 
-	// Add cleanup function
-	innerScript.WriteString(`
+	if len(displayUnset) > 0 {
+		innerScript.WriteString(fmt.Sprintf("unset %s\n", strings.Join(displayUnset, " ")))
+	}
+	for _, kv := range dbusEnv {
+		name, value, _ := strings.Cut(kv, "=")
+		innerScript.WriteString(fmt.Sprintf("export %s=%s\n", name, ShellQuoteSingle(value)))
+	}
+
+	if useSocat {
+		innerScript.WriteString(`
 # Cleanup function
 cleanup() {
     jobs -p | xargs -r kill 2>/dev/null
@@ -556,10 +1079,17 @@ trap cleanup EXIT
 
 # Small delay to ensure socat listeners are ready
 sleep 0.1
-
-# Run the user command
 `)
+	}
 
+	var layer *lsmLayer
+	if lsm != "" {
+		if layer, err = newLSMLayer(lsm, bwrapArgs[1:], opts.appArmor, dryRun); err != nil {
+			return nil, err
+		}
+	}
+
+	innerScript.WriteString("\n# Run the user command\n")
 	// Use Landlock wrapper if available
 	if useLandlockWrapper {
 		// Pass config via environment variable (serialized as JSON)
@@ -573,14 +1103,33 @@ sleep 0.1
 
 		// Build wrapper command with proper quoting
 		// Use bash -c to preserve shell semantics (e.g., "echo hi && ls")
-		wrapperArgs := []string{fenceExePath, "--landlock-apply"}
+		var wrapperArgs []string
+		if layer != nil {
+			wrapperArgs = append(wrapperArgs, layer.exec...)
+		}
+		wrapperArgs = append(wrapperArgs, fenceExePath, "--landlock-apply")
 		if opts.Debug {
 			wrapperArgs = append(wrapperArgs, "--debug")
 		}
+		if useExecGuard && opts.Audit {
+			wrapperArgs = append(wrapperArgs, "--audit")
+		}
+		if seccompNotify {
+			wrapperArgs = append(wrapperArgs, "--seccomp-notify")
+		}
+		if landlockNet != nil && landlockNet.BindPorts != nil {
+			wrapperArgs = append(wrapperArgs, "--bind-ports", joinPorts(landlockNet.BindPorts))
+		}
+		if landlockNet != nil && landlockNet.ConnectPorts != nil {
+			wrapperArgs = append(wrapperArgs, "--connect-ports", joinPorts(landlockNet.ConnectPorts))
+		}
 		wrapperArgs = append(wrapperArgs, "--", "bash", "-c", command)
 
 		// Use exec to replace bash with the wrapper (which will exec the command)
 		innerScript.WriteString(fmt.Sprintf("exec %s\n", ShellQuote(wrapperArgs)))
+	} else if layer != nil {
+		innerScript.WriteString(ShellQuote(append(slices.Clone(layer.exec), "bash", "-c", command)))
+		innerScript.WriteString("\n")
 	} else {
 		innerScript.WriteString(command)
 		innerScript.WriteString("\n")
@@ -588,73 +1137,146 @@ sleep 0.1
 
 	bwrapArgs = append(bwrapArgs, innerScript.String())
 
+	var appArmorProfile, selinuxModule string
+	switch {
+	case native:
+		bwrapArgs = append([]string{fenceExePath, NativeSandboxFlag}, bwrapArgs[1:]...)
+	case gvisor:
+		bwrapArgs = append([]string{fenceExePath, GVisorSandboxFlag}, bwrapArgs[1:]...)
+	case apparmor:
+		// The profile grants what the mounts would
+		backendLayer, err := newLSMLayer(config.LSMAppArmor, bwrapArgs[1:], opts.appArmor, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		appArmorProfile = backendLayer.profile
+		bwrapArgs = append(backendLayer.exec, bwrapArgs[commandStart:]...)
+	case selinux:
+		// The module grants what the mounts would, as far as file types can
+		spec, err := parseNativeArgs(bwrapArgs[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SELinux policy module: %w", err)
+		}
+		rules, err := pathRules(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SELinux policy module: %w", err)
+		}
+		policy := newSELinuxPolicy(rules, selinuxFileType)
+		if opts.Debug {
+			for _, u := range policy.unenforced {
+				logging.Debugf("linux", "SELinux cannot enforce %s", u)
+			}
+		}
+		name := "fence"
+		if dryRun {
+			selinuxModule = formatSELinuxModule(name, policy)
+		} else if name, selinuxModule, err = opts.selinux.load(policy); err != nil {
+			return nil, fmt.Errorf("failed to load SELinux policy module: %w", err)
+		}
+		bwrapArgs = append([]string{"runcon", "-t", name + "_t", "--"}, bwrapArgs[commandStart:]...)
+	}
+
 	if opts.Debug {
 		var featureList []string
-		if features.CanUnshareNet {
-			featureList = append(featureList, "bwrap(network,pid,fs)")
+		if apparmor || selinux {
+			featureList = append(featureList, backend+"(fs)")
+		} else if canUnshareNet {
+			featureList = append(featureList, backend+"(network,pid,fs)")
 		} else {
-			featureList = append(featureList, "bwrap(pid,fs)")
+			featureList = append(featureList, backend+"(pid,fs)")
 		}
-		if features.HasSeccomp && opts.UseSeccomp && seccompFilterPath != "" {
+		if useSeccomp {
 			featureList = append(featureList, "seccomp")
 		}
-		if useLandlockWrapper {
+		if layer != nil {
+			featureList = append(featureList, layer.String()+"(layer)")
+		}
+		if useLandlockWrapper && features.CanUseLandlock() {
 			featureList = append(featureList, fmt.Sprintf("landlock-v%d(wrapper)", features.LandlockABI))
+			if landlockNet != nil && features.LandlockABI >= 4 {
+				featureList = append(featureList, "landlock-net")
+			}
 		} else if features.CanUseLandlock() && opts.UseLandlock {
 			featureList = append(featureList, fmt.Sprintf("landlock-v%d(unavailable)", features.LandlockABI))
 		}
+		if useExecGuard && enforceExec {
+			featureList = append(featureList, "exec-guard")
+		}
+		if seccompNotify {
+			featureList = append(featureList, "seccomp-notify")
+		}
 		if reverseBridge != nil && len(reverseBridge.Ports) > 0 {
 			featureList = append(featureList, fmt.Sprintf("inbound:%v", reverseBridge.Ports))
 		}
-		fmt.Fprintf(os.Stderr, "[fence:linux] Sandbox: %s\n", strings.Join(featureList, ", "))
+		if useSocat {
+			featureList = append(featureList, "bridges(socat)")
+		}
+		logging.Debugf("linux", "Sandbox: %s", strings.Join(featureList, ", "))
 	}
 
-	// Build the final command
-	bwrapCmd := ShellQuote(bwrapArgs)
-
-	// If seccomp filter is enabled, wrap with fd redirection
-	// bwrap --seccomp expects the filter on the specified fd
-	if seccompFilterPath != "" {
-		// Open filter file on fd 3, then run bwrap
-		// The filter file will be cleaned up after the sandbox exits
-		return fmt.Sprintf("exec 3<%s; %s", ShellQuoteSingle(seccompFilterPath), bwrapCmd), nil
+	sb := &linuxSandbox{
+		bwrapArgs:         bwrapArgs,
+		seccompFilterPath: seccompFilterPath,
+		landlock:          useLandlockWrapper,
+		landlockNet:       landlockNet,
+		appArmorProfile:   appArmorProfile,
+		selinuxModule:     selinuxModule,
+		lsm:               layer,
 	}
-
-	return bwrapCmd, nil
+	if useSeccomp {
+		sb.seccomp = &seccomp
+	}
+	return sb, nil
 }
 
 // StartLinuxMonitor starts violation monitoring for a Linux sandbox.
-// Returns monitors that should be stopped when the sandbox exits.
-func StartLinuxMonitor(pid int, opts LinuxSandboxOptions) (*LinuxMonitors, error) {
+// Returns monitors that should be stopped when the sandbox exits; they also
+// stop when ctx is canceled.
+func StartLinuxMonitor(ctx context.Context, pid int, opts LinuxSandboxOptions) (*LinuxMonitors, error) {
 	monitors := &LinuxMonitors{}
 	features := DetectLinuxFeatures()
 
 	// Note: SeccompMonitor is disabled because our seccomp filter uses SECCOMP_RET_ERRNO
-	// which silently returns EPERM without logging to dmesg/audit.
-	// To enable seccomp logging, the filter would need to use SECCOMP_RET_LOG (allows syscall)
-	// or SECCOMP_RET_KILL (logs but kills process) or SECCOMP_RET_USER_NOTIF (complex).
-	// For now, we rely on the eBPF monitor to detect syscall failures.
+	// by default, which silently returns EPERM without logging to dmesg/audit.
+	// With seccomp.action notify or ask, the filter uses SECCOMP_RET_USER_NOTIF
+	// instead and the exec guard's supervisor reports each blocked syscall.
+	// Otherwise, we rely on the eBPF monitor to detect syscall failures.
 	if opts.Debug && opts.Monitor && features.SeccompLogLevel >= 1 {
-		fmt.Fprintf(os.Stderr, "[fence:linux] Note: seccomp violations are blocked but not logged (SECCOMP_RET_ERRNO is silent)\n")
+		logging.Debugf("linux", "Note: seccomp violations are blocked but not logged unless seccomp.action is notify or ask (SECCOMP_RET_ERRNO is silent)")
 	}
 
 	// Start eBPF monitor if available and requested
 	// This monitors syscalls that return EACCES/EPERM for sandbox descendants
+	// Without eBPF, the fanotify monitor reports reads of denyRead paths
 	if opts.Monitor && opts.UseEBPF && features.HasEBPF {
 		ebpfMon := NewEBPFMonitor(pid, opts.Debug)
-		if err := ebpfMon.Start(); err != nil {
+		ebpfMon.violations = opts.Violations
+		if err := ebpfMon.Start(ctx); err != nil {
 			if opts.Debug {
-				fmt.Fprintf(os.Stderr, "[fence:linux] Failed to start eBPF monitor: %v\n", err)
+				logging.Debugf("linux", "Failed to start eBPF monitor: %v", err)
 			}
 		} else {
 			monitors.EBPFMonitor = ebpfMon
 			if opts.Debug {
-				fmt.Fprintf(os.Stderr, "[fence:linux] eBPF monitor started for PID %d\n", pid)
+				logging.Debugf("linux", "eBPF monitor started for PID %d", pid)
+			}
+		}
+	} else if opts.Monitor && opts.readMasks != nil && features.HasFanotify {
+		fanotifyMon := newFanotifyMonitor(opts.readMasks, opts.Debug)
+		fanotifyMon.violations = opts.Violations
+		if err := fanotifyMon.Start(ctx); err != nil {
+			if opts.Debug {
+				logging.Debugf("linux", "Failed to start fanotify monitor: %v", err)
+			}
+		} else {
+			monitors.FanotifyMonitor = fanotifyMon
+			if opts.Debug {
+				logging.Debugf("linux", "eBPF monitoring not available (need CAP_BPF or root); fanotify monitor reports reads of denyRead paths")
 			}
 		}
 	} else if opts.Monitor && opts.Debug {
 		if !features.HasEBPF {
-			fmt.Fprintf(os.Stderr, "[fence:linux] eBPF monitoring not available (need CAP_BPF or root)\n")
+			logging.Debugf("linux", "eBPF monitoring not available (need CAP_BPF or root)")
 		}
 	}
 
@@ -663,7 +1285,8 @@ func StartLinuxMonitor(pid int, opts LinuxSandboxOptions) (*LinuxMonitors, error
 
 // LinuxMonitors holds all active monitors for a Linux sandbox.
 type LinuxMonitors struct {
-	EBPFMonitor *EBPFMonitor
+	EBPFMonitor     *EBPFMonitor
+	FanotifyMonitor *FanotifyMonitor
 }
 
 // Stop stops all monitors.
@@ -671,6 +1294,9 @@ func (m *LinuxMonitors) Stop() {
 	if m.EBPFMonitor != nil {
 		m.EBPFMonitor.Stop()
 	}
+	if m.FanotifyMonitor != nil {
+		m.FanotifyMonitor.Stop()
+	}
 }
 
 // PrintLinuxFeatures prints available Linux sandbox features.
@@ -679,49 +1305,61 @@ func PrintLinuxFeatures() {
 	fmt.Printf("Linux Sandbox Features:\n")
 	fmt.Printf("  Kernel: %d.%d\n", features.KernelMajor, features.KernelMinor)
 	fmt.Printf("  Bubblewrap (bwrap): %v\n", features.HasBwrap)
-	fmt.Printf("  Socat: %v\n", features.HasSocat)
+	fmt.Printf("  Socat: %v (only needed when fence is used as a library)\n", features.HasSocat)
 	fmt.Printf("  Network namespace (--unshare-net): %v\n", features.CanUnshareNet)
+	fmt.Printf("  Unprivileged user namespaces (--backend native): %v\n", features.CanUnshareUser)
+	fmt.Printf("  AppArmor (--backend apparmor): %v (can load profiles: %v)\n", features.HasAppArmor, features.CanUseAppArmor())
+	fmt.Printf("  SELinux (--backend selinux): %v (can load modules: %v)\n", features.HasSELinux, features.CanUseSELinux())
+	lsms, layer := "none", "none"
+	if len(features.LSMs) > 0 {
+		lsms = strings.Join(features.LSMs, ", ")
+	}
+	if lsm, _ := features.ResolveLSM(config.LSMAuto); lsm != "" {
+		layer = lsm
+	}
+	fmt.Printf("  Active LSMs: %s\n", lsms)
+	fmt.Printf("  LSM layer (security.lsm auto): %s\n", layer)
 	fmt.Printf("  Seccomp: %v (log level: %d)\n", features.HasSeccomp, features.SeccompLogLevel)
 	fmt.Printf("  Landlock: %v (ABI v%d)\n", features.HasLandlock, features.LandlockABI)
 	fmt.Printf("  eBPF: %v (CAP_BPF: %v, root: %v)\n", features.HasEBPF, features.HasCapBPF, features.HasCapRoot)
+	fmt.Printf("  fanotify: %v\n", features.HasFanotify)
 
 	fmt.Printf("\nFeature Status:\n")
 	if features.MinimumViable() {
-		fmt.Printf("  ✓ Minimum requirements met (bwrap + socat)\n")
+		fmt.Printf("  %s Minimum requirements met (bwrap)\n", output.Render(output.Allowed))
 	} else {
-		fmt.Printf("  ✗ Missing requirements: ")
+		fmt.Printf("  %s Missing requirements: ", output.Render(output.Blocked))
 		if !features.HasBwrap {
 			fmt.Printf("bwrap ")
 		}
-		if !features.HasSocat {
-			fmt.Printf("socat ")
-		}
 		fmt.Println()
 	}
 
 	if features.CanUnshareNet {
-		fmt.Printf("  ✓ Network namespace isolation available\n")
+		fmt.Printf("  %s Network namespace isolation available\n", output.Render(output.Allowed))
 	} else if features.HasBwrap {
-		fmt.Printf("  ⚠ Network namespace unavailable (containerized environment?)\n")
+		fmt.Printf("  %s Network namespace unavailable (containerized environment?)\n", output.Render(output.Warning))
 		fmt.Printf("    Sandbox will still work but with reduced network isolation.\n")
 		fmt.Printf("    This is common in Docker, GitHub Actions, and other CI systems.\n")
 	}
 
 	if features.CanUseLandlock() {
-		fmt.Printf("  ✓ Landlock available for enhanced filesystem control\n")
+		fmt.Printf("  %s Landlock available for enhanced filesystem control\n", output.Render(output.Allowed))
 	} else {
-		fmt.Printf("  ○ Landlock not available (kernel 5.13+ required)\n")
+		fmt.Printf("  %s Landlock not available (kernel 5.13+ required)\n", output.Render(output.Unavailable))
 	}
 
 	if features.CanMonitorViolations() {
-		fmt.Printf("  ✓ Violation monitoring available\n")
+		fmt.Printf("  %s Violation monitoring available\n", output.Render(output.Allowed))
 	} else {
-		fmt.Printf("  ○ Violation monitoring limited (kernel 4.14+ for seccomp logging)\n")
+		fmt.Printf("  %s Violation monitoring limited (kernel 4.14+ for seccomp logging)\n", output.Render(output.Unavailable))
 	}
 
 	if features.HasEBPF {
-		fmt.Printf("  ✓ eBPF monitoring available (enhanced visibility)\n")
+		fmt.Printf("  %s eBPF monitoring available (enhanced visibility)\n", output.Render(output.Allowed))
+	} else if features.HasFanotify {
+		fmt.Printf("  %s eBPF monitoring not available (needs CAP_BPF or root); fanotify reports reads of denyRead paths\n", output.Render(output.Warning))
 	} else {
-		fmt.Printf("  ○ eBPF monitoring not available (needs CAP_BPF or root)\n")
+		fmt.Printf("  %s eBPF monitoring not available (needs CAP_BPF or root)\n", output.Render(output.Unavailable))
 	}
 }
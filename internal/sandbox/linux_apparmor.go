@@ -0,0 +1,193 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// The apparmor backend runs the command under aa-exec with a profile derived
+// from the bwrap-style mount arguments (see pathRules), for systems where
+// neither bwrap nor Landlock is available. AppArmor deny rules take
+// precedence over allow rules whatever their order, which matches bwrap,
+// where the read-only and masking mounts come last.
+
+// AppArmor access modes for the rules generated from mounts.
+const (
+	appArmorWritable = "rwlk"
+	appArmorReadOnly = "wl"     // Denied
+	appArmorHidden   = "rwlkmx" // Denied
+)
+
+// appArmorRule is a path rule in a generated profile.
+type appArmorRule struct {
+	path string
+	deny bool
+	mode string
+}
+
+func (r appArmorRule) String() string {
+	// {,/**} matches the path itself and everything beneath it
+	rule := fmt.Sprintf("\"%s{,/**}\" %s,", appArmorEscape(r.path), r.mode)
+	if r.deny {
+		rule = "deny " + rule
+	}
+	return rule
+}
+
+// appArmorRules returns the profile rules equivalent to the mounts in spec.
+func appArmorRules(spec *nativeSpec) ([]appArmorRule, error) {
+	paths, err := pathRules(spec)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]appArmorRule, 0, len(paths))
+	for _, p := range paths {
+		switch p.access {
+		case pathWritable:
+			rules = append(rules, appArmorRule{path: p.path, mode: appArmorWritable})
+		case pathReadOnly:
+			rules = append(rules, appArmorRule{path: p.path, deny: true, mode: appArmorReadOnly})
+		case pathHidden:
+			rules = append(rules, appArmorRule{path: p.path, deny: true, mode: appArmorHidden})
+		}
+	}
+	return rules, nil
+}
+
+// appArmorEscape escapes the characters AppArmor treats as patterns in path,
+// and those that end a quoted string.
+func appArmorEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`\"*?[]{}^`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatAppArmorProfile returns the profile name with the given path rules.
+func formatAppArmorProfile(name string, rules []appArmorRule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by fence\n#include <tunables/global>\n\n")
+	fmt.Fprintf(&b, "profile %s flags=(attach_disconnected,mediate_deleted) {\n", name)
+	b.WriteString("  #include <abstractions/base>\n\n")
+	b.WriteString("  # Read and run anything; the rules below grant writes and hide paths\n")
+	b.WriteString("  /** rmix,\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+	fmt.Fprintf(&b, `
+  # Network filtering relies on the proxy environment variables
+  network,
+  unix,
+
+  # Signal and trace only processes in the sandbox
+  signal (receive),
+  signal (send) peer=%[1]s,
+  ptrace (read, trace) peer=%[1]s,
+
+  # Capabilities a command run as root commonly needs; no mounts or other
+  # administration
+  capability chown,
+  capability dac_override,
+  capability dac_read_search,
+  capability fowner,
+  capability fsetid,
+  capability kill,
+  capability setgid,
+  capability setuid,
+  capability net_bind_service,
+}
+`, name)
+	return b.String()
+}
+
+// appArmorProfiles loads profiles for a Manager and removes them at Shutdown.
+// Profile names include a random prefix, so removing them does not affect
+// sandboxes run by other Managers or fence processes.
+type appArmorProfiles struct {
+	prefix string
+
+	mu     sync.Mutex
+	loaded []loadedAppArmorProfile
+}
+
+type loadedAppArmorProfile struct {
+	name    string
+	rules   []appArmorRule
+	profile string
+}
+
+func newAppArmorProfiles() *appArmorProfiles {
+	return &appArmorProfiles{prefix: randomAppArmorPrefix()}
+}
+
+func randomAppArmorPrefix() string {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	return "fence-" + hex.EncodeToString(id)
+}
+
+// load loads a profile with rules, unless p already has, and returns its
+// name and text. A nil p loads a new profile that is never removed.
+func (p *appArmorProfiles) load(rules []appArmorRule) (name, profile string, err error) {
+	if p == nil {
+		name = randomAppArmorPrefix()
+		profile = formatAppArmorProfile(name, rules)
+		return name, profile, runAppArmorParser(context.Background(), "--replace", profile)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, l := range p.loaded {
+		if slices.Equal(l.rules, rules) {
+			return l.name, l.profile, nil
+		}
+	}
+	name = fmt.Sprintf("%s-%d", p.prefix, len(p.loaded)+1)
+	profile = formatAppArmorProfile(name, rules)
+	if err := runAppArmorParser(context.Background(), "--replace", profile); err != nil {
+		return "", "", err
+	}
+	p.loaded = append(p.loaded, loadedAppArmorProfile{name: name, rules: rules, profile: profile})
+	return name, profile, nil
+}
+
+// Shutdown removes the loaded profiles. Commands still running under them
+// must have been stopped first.
+func (p *appArmorProfiles) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	loaded := p.loaded
+	p.loaded = nil
+	p.mu.Unlock()
+
+	var errs []error
+	for _, l := range loaded {
+		errs = append(errs, runAppArmorParser(ctx, "--remove", l.profile))
+	}
+	return errors.Join(errs...)
+}
+
+// runAppArmorParser runs apparmor_parser with op on profile.
+func runAppArmorParser(ctx context.Context, op, profile string) error {
+	cmd := exec.CommandContext(ctx, "apparmor_parser", op)
+	cmd.Stdin = strings.NewReader(profile)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("apparmor_parser %s: %w: %s", op, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
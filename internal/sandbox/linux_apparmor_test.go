@@ -0,0 +1,131 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestAppArmorRules(t *testing.T) {
+	spec, err := parseNativeArgs([]string{
+		"--unshare-net", "--ro-bind", "/", "/", "--dev-bind", "/dev", "/dev", "--proc", "/proc",
+		"--tmpfs", "/tmp", "--bind", "/home/u/project", "/home/u/project",
+		"--tmpfs", "/home/u/.ssh", "--ro-bind", "/dev/null", "/home/u/.netrc",
+		"--ro-bind", "/home/u/project/.git/hooks", "/home/u/project/.git/hooks",
+		"--bind", "/tmp/proxy.sock", "/run/dbus/system_bus_socket",
+		"--", "/bin/bash", "-c", "true",
+	})
+	if err != nil {
+		t.Fatalf("parseNativeArgs() error = %v", err)
+	}
+
+	rules, err := appArmorRules(spec)
+	if err != nil {
+		t.Fatalf("appArmorRules() error = %v", err)
+	}
+	want := []appArmorRule{
+		{path: "/dev", mode: appArmorWritable},
+		{path: "/tmp", mode: appArmorWritable},
+		{path: "/home/u/project", mode: appArmorWritable},
+		{path: "/home/u/.ssh", deny: true, mode: appArmorHidden},
+		{path: "/home/u/.netrc", deny: true, mode: appArmorHidden},
+		{path: "/home/u/project/.git/hooks", deny: true, mode: appArmorReadOnly},
+		{path: "/run/dbus/system_bus_socket", deny: true, mode: appArmorHidden},
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("appArmorRules() =\n%+v\nwant\n%+v", rules, want)
+	}
+}
+
+func TestAppArmorRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no root", []string{"--tmpfs", "/tmp", "--", "true"}},
+		{"writable root", []string{"--bind", "/", "/", "--", "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseNativeArgs(tt.args)
+			if err != nil {
+				t.Fatalf("parseNativeArgs() error = %v", err)
+			}
+			if _, err := appArmorRules(spec); err == nil {
+				t.Errorf("appArmorRules(%q) should fail", tt.args)
+			}
+		})
+	}
+}
+
+func TestAppArmorRuleString(t *testing.T) {
+	tests := []struct {
+		rule appArmorRule
+		want string
+	}{
+		{appArmorRule{path: "/tmp", mode: appArmorWritable}, `"/tmp{,/**}" rwlk,`},
+		{appArmorRule{path: "/home/u/.ssh", deny: true, mode: appArmorHidden}, `deny "/home/u/.ssh{,/**}" rwlkmx,`},
+		{appArmorRule{path: `/srv/a "b" [c]*`, mode: appArmorWritable}, `"/srv/a \"b\" \[c\]\*{,/**}" rwlk,`},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestResolveBackend(t *testing.T) {
+	apparmor := LinuxFeatures{HasAppArmor: true, HasCapRoot: true}
+	tests := []struct {
+		name     string
+		features LinuxFeatures
+		backend  string
+		want     string
+	}{
+		{"default", LinuxFeatures{HasBwrap: true}, "", BackendBwrap},
+		{"explicit", apparmor, BackendNative, BackendNative},
+		{"fallback to apparmor", apparmor, "", BackendAppArmor},
+		{"bwrap available", LinuxFeatures{HasBwrap: true, HasAppArmor: true, HasCapRoot: true}, "", BackendBwrap},
+		{"landlock available", LinuxFeatures{HasLandlock: true, LandlockABI: 3, HasAppArmor: true, HasCapRoot: true}, "", BackendBwrap},
+		{"apparmor needs root", LinuxFeatures{HasAppArmor: true}, "", BackendBwrap},
+		{"fallback to selinux", LinuxFeatures{HasSELinux: true, HasCapRoot: true}, "", BackendSELinux},
+		{"apparmor before selinux", LinuxFeatures{HasAppArmor: true, HasSELinux: true, HasCapRoot: true}, "", BackendAppArmor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.features.ResolveBackend(tt.backend); got != tt.want {
+				t.Errorf("ResolveBackend(%q) = %q, want %q", tt.backend, got, tt.want)
+			}
+		})
+	}
+}
+
+// The apparmor backend runs the same command the bwrap backend would, under
+// a profile made from its mounts.
+func TestAppArmorBackendSpec(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"/tmp"}
+	cfg.Filesystem.DenyRead = []string{"/etc/shadow"}
+
+	spec, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{UseSeccomp: true, Backend: BackendAppArmor})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if want := []string{"aa-exec", "-p", "fence", "--"}; !slices.Equal(spec.BwrapArgs[:4], want) {
+		t.Errorf("args start with %q, want %q", spec.BwrapArgs[:4], want)
+	}
+	if spec.SeccompSyscalls != nil {
+		t.Error("the apparmor backend cannot install a seccomp filter")
+	}
+	for _, want := range []string{"profile fence ", `deny "/etc/shadow{,/**}" rwlkmx,`, `"/tmp{,/**}" rwlk,`} {
+		if !strings.Contains(spec.AppArmorProfile, want) {
+			t.Errorf("profile does not contain %q:\n%s", want, spec.AppArmorProfile)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// Resource limits are enforced by a cgroup v2 created for each Manager. The
+// wrapped command moves its shell into the cgroup before starting the
+// sandbox, so everything the command starts is limited and accounted
+// together. The cgroup is created beneath fence's own cgroup, which must be
+// delegated to the user (root can use any cgroup):
+//
+//	<fence's cgroup>/fence-<id>        the sandboxed command
+//	<fence's cgroup>/fence-<id>-host   fence itself, if it had to move
+//
+// Controllers can only be enabled for a cgroup's children while it holds no
+// processes. If fence is the only process in its cgroup, as when started by
+// systemd-run --scope, it moves itself to a leaf to make room; otherwise the
+// controllers must already be enabled.
+//
+// filesystem.maxWriteBytes is enforced by the io controller's accounting:
+// the cgroup's io.stat is polled, and once the bytes it has written to block
+// devices reach the quota, everything in it is killed. Writes to tmpfs use
+// memory instead and count against resources.memory.
+
+// cgroupMountPoint is where the cgroup v2 hierarchy is mounted.
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+// cgroupCPUPeriod is the cpu.max period in microseconds.
+const cgroupCPUPeriod = 100000
+
+// cgroupWritePoll is how often io.stat is checked against the write quota.
+const cgroupWritePoll = 100 * time.Millisecond
+
+// cgroupDelegationHint explains how to get a cgroup fence can use.
+const cgroupDelegationHint = "run fence as root, or in a delegated scope: systemd-run --user --scope -p Delegate=yes fence ..."
+
+// cgroupSetting is a value written to a cgroup interface file.
+type cgroupSetting struct {
+	file  string
+	value string
+}
+
+func (s cgroupSetting) String() string {
+	return s.file + " " + s.value
+}
+
+// cgroupSettings returns the interface file values that enforce r.
+func cgroupSettings(r config.ResourcesConfig) []cgroupSetting {
+	var settings []cgroupSetting
+	if r.Memory > 0 {
+		settings = append(settings,
+			cgroupSetting{"memory.max", strconv.FormatInt(r.Memory, 10)},
+			// Without this the limit only moves the excess to swap
+			cgroupSetting{"memory.swap.max", "0"},
+		)
+	}
+	if r.CPUs > 0 {
+		quota := max(int64(r.CPUs*cgroupCPUPeriod), 1000)
+		settings = append(settings, cgroupSetting{"cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)})
+	}
+	if r.PidsMax > 0 {
+		settings = append(settings, cgroupSetting{"pids.max", strconv.Itoa(r.PidsMax)})
+	}
+	return settings
+}
+
+// cgroupControllers returns the controllers settings need, in order.
+func cgroupControllers(settings []cgroupSetting) []string {
+	var controllers []string
+	for _, s := range settings {
+		c, _, _ := strings.Cut(s.file, ".")
+		if !slices.Contains(controllers, c) {
+			controllers = append(controllers, c)
+		}
+	}
+	return controllers
+}
+
+// runCgroup is the cgroup the commands of a Manager run in.
+type runCgroup struct {
+	path       string // The cgroup the command runs in
+	parent     string // fence's own cgroup
+	host       string // The leaf fence moved itself to, or ""
+	enabled    []string
+	pid        int
+	settings   []cgroupSetting
+	writeQuota int64         // filesystem.maxWriteBytes; 0 is unlimited
+	egress     *egressFilter // Limits direct connections without a network namespace
+
+	stopWatch    func()
+	overQuota    atomic.Bool
+	watchStopped chan struct{}
+}
+
+// newRunCgroup creates a cgroup that enforces the resources limits and write
+// quota of cfg beneath the current process's cgroup.
+func newRunCgroup(cfg *config.Config) (*runCgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroup v2 is not mounted at %s", cgroupMountPoint)
+	}
+	self, err := ownCgroup("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	c, err := createRunCgroup(filepath.Join(cgroupMountPoint, self), os.Getpid(), cgroupSettings(cfg.Resources), cfg.Filesystem.MaxWriteBytes)
+	if err != nil {
+		return nil, err
+	}
+	if c.writeQuota > 0 {
+		c.watchWrites(cgroupWritePoll)
+	}
+	return c, nil
+}
+
+// ownCgroup returns the cgroup v2 path of a process from its
+// /proc/<pid>/cgroup file.
+func ownCgroup(procFile string) (string, error) {
+	data, err := os.ReadFile(procFile) //nolint:gosec // a /proc path
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("process is not in a cgroup v2 hierarchy")
+}
+
+// createRunCgroup creates a cgroup with settings beneath parent, the cgroup
+// of the process pid, with io accounting for a write quota if one is set.
+func createRunCgroup(parent string, pid int, settings []cgroupSetting, writeQuota int64) (*runCgroup, error) {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	c := &runCgroup{
+		path:       filepath.Join(parent, "fence-"+hex.EncodeToString(id)),
+		parent:     parent,
+		pid:        pid,
+		settings:   settings,
+		writeQuota: writeQuota,
+	}
+
+	controllers := cgroupControllers(settings)
+	if writeQuota > 0 {
+		controllers = append(controllers, "io")
+	}
+	available := readCgroupList(filepath.Join(parent, "cgroup.controllers"))
+	for _, name := range controllers {
+		if !slices.Contains(available, name) {
+			return nil, fmt.Errorf("the %s controller is not available in cgroup %s; %s", name, parent, cgroupDelegationHint)
+		}
+	}
+	if err := c.enableControllers(controllers); err != nil {
+		return nil, err
+	}
+
+	if err := os.Mkdir(c.path, 0o755); err != nil {
+		_ = c.restore()
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	for _, s := range settings {
+		err := writeCgroupFile(filepath.Join(c.path, s.file), s.value)
+		if errors.Is(err, os.ErrNotExist) && s.file == "memory.swap.max" {
+			continue // Kernel without swap accounting
+		}
+		if err != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+			_ = c.Shutdown(ctx)
+			cancel()
+			return nil, fmt.Errorf("failed to set %s: %w", s.file, err)
+		}
+	}
+	return c, nil
+}
+
+// enableControllers enables controllers for the children of c.parent,
+// moving the current process to a leaf if it is the only one in the way.
+func (c *runCgroup) enableControllers(controllers []string) error {
+	enabled := readCgroupList(filepath.Join(c.parent, "cgroup.subtree_control"))
+	var missing []string
+	for _, name := range controllers {
+		if !slices.Contains(enabled, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	err := c.writeSubtreeControl("+", missing)
+	if errors.Is(err, syscall.EBUSY) && slices.Equal(readCgroupList(filepath.Join(c.parent, "cgroup.procs")), []string{strconv.Itoa(c.pid)}) {
+		c.host = c.path + "-host"
+		if err = os.Mkdir(c.host, 0o755); err == nil {
+			if err = writeCgroupFile(filepath.Join(c.host, "cgroup.procs"), strconv.Itoa(c.pid)); err == nil {
+				err = c.writeSubtreeControl("+", missing)
+			}
+		}
+		if err != nil {
+			_ = c.restore()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot enable the %s controllers in cgroup %s: %w; %s", strings.Join(missing, ", "), c.parent, err, cgroupDelegationHint)
+	}
+	if c.host != "" {
+		// Undone at Shutdown so fence can move back
+		c.enabled = missing
+	}
+	return nil
+}
+
+func (c *runCgroup) writeSubtreeControl(op string, controllers []string) error {
+	fields := make([]string, len(controllers))
+	for i, name := range controllers {
+		fields[i] = op + name
+	}
+	return writeCgroupFile(filepath.Join(c.parent, "cgroup.subtree_control"), strings.Join(fields, " "))
+}
+
+// restore moves the current process back to its own cgroup if it had to
+// move, disabling the controllers enabled to make that necessary.
+func (c *runCgroup) restore() error {
+	if c.host == "" {
+		return nil
+	}
+	var errs []error
+	if len(c.enabled) > 0 {
+		errs = append(errs, c.writeSubtreeControl("-", c.enabled))
+		c.enabled = nil
+	}
+	if err := writeCgroupFile(filepath.Join(c.parent, "cgroup.procs"), strconv.Itoa(c.pid)); err != nil {
+		errs = append(errs, err)
+	} else if err := os.Remove(c.host); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// enter returns the shell command that moves the shell running it into c.
+func (c *runCgroup) enter() string {
+	return fmt.Sprintf("echo $$ > %s || exit 1", ShellQuoteSingle(filepath.Join(c.path, "cgroup.procs")))
+}
+
+// limits returns the settings c enforces, as "file value" lines.
+func (c *runCgroup) limits() []string {
+	var lines []string
+	for _, s := range c.settings {
+		lines = append(lines, s.String())
+	}
+	if c.writeQuota > 0 {
+		lines = append(lines, fmt.Sprintf("io.stat wbytes %d (kills the command when reached)", c.writeQuota))
+	}
+	if c.egress != nil {
+		lines = append(lines, c.egress.String())
+	}
+	return lines
+}
+
+// watchWrites polls the bytes written by the cgroup every interval until
+// Shutdown, killing everything in it once they reach the write quota. Once
+// over the quota, commands started later are killed as well.
+func (c *runCgroup) watchWrites(interval time.Duration) {
+	stop := make(chan struct{})
+	c.stopWatch = sync.OnceFunc(func() { close(stop) })
+	c.watchStopped = make(chan struct{})
+	go func() {
+		defer close(c.watchStopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if cgroupWrittenBytes(filepath.Join(c.path, "io.stat")) >= c.writeQuota {
+				c.overQuota.Store(true)
+				killCgroup(c.path)
+			}
+		}
+	}()
+}
+
+// cgroupWrittenBytes returns the bytes written to all devices according to
+// an io.stat file, whose lines look like
+//
+//	8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func cgroupWrittenBytes(path string) int64 {
+	var total int64
+	for _, field := range readCgroupList(path) {
+		if v, ok := strings.CutPrefix(field, "wbytes="); ok {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			total += n
+		}
+	}
+	return total
+}
+
+// events returns how often the limits intervened so far.
+func (c *runCgroup) events() LimitEvents {
+	if c == nil {
+		return LimitEvents{}
+	}
+	return LimitEvents{
+		OOMKills:           cgroupEventCount(filepath.Join(c.path, "memory.events"), "oom_kill"),
+		PidsLimited:        cgroupEventCount(filepath.Join(c.path, "pids.events"), "max"),
+		WriteQuotaExceeded: c.overQuota.Load(),
+	}
+}
+
+// sessionResources returns the cgroups to remove if fence dies, innermost
+// first.
+func (c *runCgroup) sessionResources() []string {
+	if c == nil {
+		return nil
+	}
+	if c.host != "" {
+		return []string{c.path, c.host}
+	}
+	return []string{c.path}
+}
+
+// Shutdown kills whatever is still running in the cgroup, removes it, and
+// moves the current process back to its own cgroup.
+func (c *runCgroup) Shutdown(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	if c.stopWatch != nil {
+		c.stopWatch()
+		<-c.watchStopped
+	}
+	err := removeCgroup(ctx, c.path)
+	// Detach the egress filter only once nothing it limits is left running
+	if c.egress != nil {
+		c.egress.Close()
+		c.egress = nil
+	}
+	return errors.Join(err, c.restore())
+}
+
+// killCgroup kills the processes in the cgroup at path.
+func killCgroup(path string) {
+	// cgroup.kill needs Linux 5.14; before that, kill each process. Those
+	// forked meanwhile survive until the next call
+	if writeCgroupFile(filepath.Join(path, "cgroup.kill"), "1") == nil {
+		return
+	}
+	for _, pid := range readCgroupList(filepath.Join(path, "cgroup.procs")) {
+		if n, err := strconv.Atoi(pid); err == nil {
+			_ = syscall.Kill(n, syscall.SIGKILL)
+		}
+	}
+}
+
+// removeCgroup kills the processes in the cgroup at path and removes it,
+// waiting until ctx is done for them to exit.
+func removeCgroup(ctx context.Context, path string) error {
+	for {
+		killCgroup(path)
+		err := os.Remove(path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cgroup %s still has processes: %w", path, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// releaseCgroup removes a cgroup left behind by a crashed session and
+// reports whether it existed.
+func releaseCgroup(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return removeCgroup(ctx, path) == nil
+}
+
+// readCgroupList returns the whitespace-separated fields of a cgroup
+// interface file, or nil if it cannot be read.
+func readCgroupList(path string) []string {
+	data, err := os.ReadFile(path) //nolint:gosec // a cgroup interface file
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// cgroupEventCount returns the counter key from a cgroup events file.
+func cgroupEventCount(path, key string) int64 {
+	f, err := os.Open(path) //nolint:gosec // a cgroup interface file
+	if err != nil {
+		return 0
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), " "); ok && k == key {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// writeCgroupFile writes value to a cgroup interface file, which must exist.
+func writeCgroupFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0) //nolint:gosec // a cgroup interface file
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
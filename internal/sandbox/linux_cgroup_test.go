@@ -0,0 +1,222 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestCgroupSettings(t *testing.T) {
+	tests := []struct {
+		name            string
+		resources       config.ResourcesConfig
+		wantSettings    []string
+		wantControllers []string
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:            "all limits",
+			resources:       config.ResourcesConfig{Memory: 512 << 20, CPUs: 1.5, PidsMax: 256},
+			wantSettings:    []string{"memory.max 536870912", "memory.swap.max 0", "cpu.max 150000 100000", "pids.max 256"},
+			wantControllers: []string{"memory", "cpu", "pids"},
+		},
+		{
+			name:            "tiny cpu share is raised to the kernel minimum",
+			resources:       config.ResourcesConfig{CPUs: 0.001},
+			wantSettings:    []string{"cpu.max 1000 100000"},
+			wantControllers: []string{"cpu"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := cgroupSettings(tt.resources)
+			c := &runCgroup{settings: settings}
+			if got := c.limits(); !slices.Equal(got, tt.wantSettings) {
+				t.Errorf("limits = %q, want %q", got, tt.wantSettings)
+			}
+			if got := cgroupControllers(settings); !slices.Equal(got, tt.wantControllers) {
+				t.Errorf("controllers = %q, want %q", got, tt.wantControllers)
+			}
+		})
+	}
+}
+
+func TestOwnCgroup(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	got, err := ownCgroup(write("unified", "0::/user.slice/user-1000.slice/user@1000.service/app.slice/fence.scope\n"))
+	if err != nil || got != "/user.slice/user-1000.slice/user@1000.service/app.slice/fence.scope" {
+		t.Errorf("ownCgroup = %q, %v", got, err)
+	}
+	got, err = ownCgroup(write("hybrid", "4:memory:/user.slice\n1:name=systemd:/user.slice\n0::/user.slice\n"))
+	if err != nil || got != "/user.slice" {
+		t.Errorf("ownCgroup (hybrid) = %q, %v", got, err)
+	}
+	if _, err := ownCgroup(write("v1", "4:memory:/user.slice\n1:name=systemd:/user.slice\n")); err == nil {
+		t.Error("ownCgroup without a cgroup v2 entry should fail")
+	}
+}
+
+func TestCreateRunCgroupMissingController(t *testing.T) {
+	parent := t.TempDir()
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpu pids\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := createRunCgroup(parent, os.Getpid(), cgroupSettings(config.ResourcesConfig{Memory: 1 << 30, PidsMax: 64}), 0)
+	if err == nil || !strings.Contains(err.Error(), "memory controller is not available") {
+		t.Errorf("err = %v, want the memory controller to be reported missing", err)
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Errorf("parent has %d entries, want no cgroup created", len(entries))
+	}
+}
+
+func TestRunCgroupEvents(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\noom_group_kill 0\n",
+		"pids.events":   "max 37\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &runCgroup{path: dir}
+	if got, want := c.events(), (LimitEvents{OOMKills: 1, PidsLimited: 37}); got != want {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+	if got := (*runCgroup)(nil).events(); got != (LimitEvents{}) {
+		t.Errorf("events without a cgroup = %+v, want none", got)
+	}
+	if got := (&runCgroup{path: filepath.Join(dir, "missing")}).events(); got != (LimitEvents{}) {
+		t.Errorf("events of a removed cgroup = %+v, want none", got)
+	}
+}
+
+func TestRunCgroupEnter(t *testing.T) {
+	c := &runCgroup{path: "/sys/fs/cgroup/user.slice/fence-0a1b2c"}
+	if got, want := c.enter(), "echo $$ > /sys/fs/cgroup/user.slice/fence-0a1b2c/cgroup.procs || exit 1"; got != want {
+		t.Errorf("enter = %q, want %q", got, want)
+	}
+}
+
+func TestRunCgroupSessionResources(t *testing.T) {
+	if got := (*runCgroup)(nil).sessionResources(); got != nil {
+		t.Errorf("sessionResources without a cgroup = %q, want nil", got)
+	}
+	c := &runCgroup{path: "/cg/fence-1", host: "/cg/fence-1-host"}
+	if got, want := c.sessionResources(), []string{"/cg/fence-1", "/cg/fence-1-host"}; !slices.Equal(got, want) {
+		t.Errorf("sessionResources = %q, want %q", got, want)
+	}
+}
+
+func TestLinuxSpecCgroup(t *testing.T) {
+	c := &runCgroup{
+		path:     "/sys/fs/cgroup/user.slice/fence-0a1b2c",
+		settings: cgroupSettings(config.ResourcesConfig{PidsMax: 64}),
+	}
+	spec, err := LinuxSpec(config.Default(), "echo hello", nil, nil, LinuxSandboxOptions{cgroup: c})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if spec.Cgroup != c.path {
+		t.Errorf("Cgroup = %q, want %q", spec.Cgroup, c.path)
+	}
+	if want := []string{"pids.max 64"}; !slices.Equal(spec.CgroupLimits, want) {
+		t.Errorf("CgroupLimits = %q, want %q", spec.CgroupLimits, want)
+	}
+
+	spec, err = LinuxSpec(config.Default(), "echo hello", nil, nil, LinuxSandboxOptions{})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if spec.Cgroup != "" || spec.CgroupLimits != nil {
+		t.Errorf("Cgroup = %q, %q; want none without limits", spec.Cgroup, spec.CgroupLimits)
+	}
+}
+
+func TestCreateRunCgroupWriteQuotaNeedsIO(t *testing.T) {
+	parent := t.TempDir()
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpu memory pids\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := createRunCgroup(parent, os.Getpid(), nil, 1<<30)
+	if err == nil || !strings.Contains(err.Error(), "io controller is not available") {
+		t.Errorf("err = %v, want the io controller to be reported missing", err)
+	}
+}
+
+func TestCgroupWrittenBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "io.stat")
+	stat := "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0\n" +
+		"253:1 rbytes=0 wbytes=4096 rios=0 wios=1 dbytes=0 dios=0\n"
+	if err := os.WriteFile(path, []byte(stat), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cgroupWrittenBytes(path), int64(314773504+4096); got != want {
+		t.Errorf("cgroupWrittenBytes = %d, want %d", got, want)
+	}
+	if got := cgroupWrittenBytes(filepath.Join(t.TempDir(), "io.stat")); got != 0 {
+		t.Errorf("cgroupWrittenBytes without io.stat = %d, want 0", got)
+	}
+}
+
+func TestRunCgroupWatchWrites(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("io.stat", "8:0 rbytes=0 wbytes=4096 rios=0 wios=1 dbytes=0 dios=0\n")
+	write("cgroup.kill", "")
+
+	c := &runCgroup{path: dir, writeQuota: 1 << 20}
+	c.watchWrites(time.Millisecond)
+	defer func() {
+		c.stopWatch()
+		<-c.watchStopped
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if c.events().WriteQuotaExceeded {
+		t.Fatal("WriteQuotaExceeded before reaching the quota")
+	}
+
+	write("io.stat", "8:0 rbytes=0 wbytes=1048576 rios=0 wios=256 dbytes=0 dios=0\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.events().WriteQuotaExceeded {
+		if time.Now().After(deadline) {
+			t.Fatal("WriteQuotaExceeded not set after reaching the quota")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "cgroup.kill")); string(data) != "1" {
+		t.Errorf("cgroup.kill = %q, want the cgroup killed", data)
+	}
+	if want := []string{"io.stat wbytes 1048576 (kills the command when reached)"}; !slices.Equal(c.limits(), want) {
+		t.Errorf("limits = %q, want %q", c.limits(), want)
+	}
+}
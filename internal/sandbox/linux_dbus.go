@@ -0,0 +1,283 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// Fallback D-Bus system socket locations, used when DBUS_SYSTEM_BUS_ADDRESS is unset.
+var defaultSystemBusPaths = []string{
+	"/run/dbus/system_bus_socket",
+	"/var/run/dbus/system_bus_socket",
+}
+
+// sandboxSessionBusPath is where a filtered session bus is exposed inside the
+// sandbox when the host bus is an abstract socket with no path to replace.
+const sandboxSessionBusPath = "/tmp/fence-dbus-session"
+
+// DBusProxy runs xdg-dbus-proxy to give the sandbox filtered access to D-Bus,
+// limited to the names allowed in config.
+type DBusProxy struct {
+	SessionSocketPath string // Filtered session bus socket on the host; empty if not proxied
+	SystemSocketPath  string // Filtered system bus socket on the host; empty if not proxied
+	process           *exec.Cmd
+	debug             bool
+}
+
+// dbusBus is a message bus as seen from the host.
+type dbusBus struct {
+	address string // D-Bus address, e.g. unix:path=/run/user/1000/bus
+	paths   []string
+}
+
+// sessionBus returns the host session bus from DBUS_SESSION_BUS_ADDRESS,
+// falling back to $XDG_RUNTIME_DIR/bus.
+func sessionBus() dbusBus {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			address = "unix:path=" + filepath.Join(dir, "bus")
+		}
+	}
+	var paths []string
+	if p := dbusSocketPath(address); p != "" {
+		paths = append(paths, p)
+	}
+	return dbusBus{address: address, paths: paths}
+}
+
+// systemBus returns the host system bus from DBUS_SYSTEM_BUS_ADDRESS,
+// falling back to the well-known socket locations.
+func systemBus() dbusBus {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if p := dbusSocketPath(address); p != "" {
+		return dbusBus{address: address, paths: []string{p}}
+	}
+	return dbusBus{address: "unix:path=" + defaultSystemBusPaths[0], paths: defaultSystemBusPaths}
+}
+
+// dbusSocketPath returns the socket path of the first unix:path= entry in a
+// D-Bus address, or "" if there is none (e.g. an abstract socket).
+func dbusSocketPath(address string) string {
+	for _, entry := range strings.Split(address, ";") {
+		transport, params, ok := strings.Cut(entry, ":")
+		if !ok || transport != "unix" {
+			continue
+		}
+		for _, kv := range strings.Split(params, ",") {
+			if k, v, ok := strings.Cut(kv, "="); ok && k == "path" {
+				if unescaped, err := url.PathUnescape(v); err == nil {
+					return unescaped
+				}
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// dbusTalkNames returns the allowed names that are not denied by config or by
+// default.
+func dbusTalkNames(allow, deny []string) []string {
+	var names []string
+	for _, name := range allow {
+		if slices.Contains(deny, name) || slices.Contains(config.DefaultDeniedDBusNames, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// NewDBusProxy starts xdg-dbus-proxy for each bus that has allowed names.
+// Returns nil if no bus needs filtering. xdg-dbus-proxy is optional: if it is
+// not installed, a warning is printed and nil is returned, so D-Bus stays blocked.
+func NewDBusProxy(ctx context.Context, cfg config.DBusConfig, debug bool) (*DBusProxy, error) {
+	sessionNames := dbusTalkNames(cfg.Allow, cfg.Deny)
+	systemNames := dbusTalkNames(cfg.SystemAllow, cfg.Deny)
+	if len(sessionNames) == 0 && len(systemNames) == 0 {
+		return nil, nil
+	}
+
+	proxyPath, err := exec.LookPath("xdg-dbus-proxy")
+	if err != nil {
+		logging.Warnf("linux", "D-Bus names are allowed but xdg-dbus-proxy is not installed; D-Bus stays blocked")
+		return nil, nil
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate socket ID: %w", err)
+	}
+	socketID := hex.EncodeToString(id)
+
+	proxy := &DBusProxy{debug: debug}
+	var args []string
+	if bus := sessionBus(); len(sessionNames) > 0 && bus.address != "" {
+		proxy.SessionSocketPath = filepath.Join(os.TempDir(), fmt.Sprintf("fence-dbus-session-%s.sock", socketID))
+		args = append(args, bus.address, proxy.SessionSocketPath, "--filter")
+		for _, name := range sessionNames {
+			args = append(args, "--talk="+name)
+		}
+	} else if len(sessionNames) > 0 && debug {
+		logging.Debugf("linux", "No session bus found, not proxying D-Bus session names")
+	}
+	if len(systemNames) > 0 {
+		proxy.SystemSocketPath = filepath.Join(os.TempDir(), fmt.Sprintf("fence-dbus-system-%s.sock", socketID))
+		args = append(args, systemBus().address, proxy.SystemSocketPath, "--filter")
+		for _, name := range systemNames {
+			args = append(args, "--talk="+name)
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	proxy.process = exec.Command(proxyPath, args...) //nolint:gosec // args constructed from validated config
+	if debug {
+		logging.Debugf("linux", "Starting D-Bus proxy: xdg-dbus-proxy %s", strings.Join(args, " "))
+	}
+	if err := proxy.process.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start xdg-dbus-proxy: %w", err)
+	}
+
+	var sockets []string
+	for _, p := range []string{proxy.SessionSocketPath, proxy.SystemSocketPath} {
+		if p != "" {
+			sockets = append(sockets, p)
+		}
+	}
+	if err := waitForSockets(ctx, 5*time.Second, sockets...); err != nil {
+		proxy.Cleanup()
+		return nil, fmt.Errorf("D-Bus proxy: %w", err)
+	}
+
+	if debug {
+		logging.Debugf("linux", "D-Bus proxy ready (session: %v, system: %v)", sessionNames, systemNames)
+	}
+	return proxy, nil
+}
+
+// Cleanup stops xdg-dbus-proxy and removes its sockets.
+func (p *DBusProxy) Cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), processStepTimeout)
+	defer cancel()
+	_ = p.Shutdown(ctx)
+}
+
+// sessionResources returns the proxy process and sockets for the session state file.
+func (p *DBusProxy) sessionResources() (helpers []helperProcess, files []string) {
+	for _, socket := range []string{p.SessionSocketPath, p.SystemSocketPath} {
+		if socket != "" {
+			files = append(files, socket)
+		}
+	}
+	if p.process != nil && p.process.Process != nil && len(files) > 0 {
+		helpers = append(helpers, helperProcess{PID: p.process.Process.Pid, Name: "xdg-dbus-proxy", Match: files[0]})
+	}
+	return helpers, files
+}
+
+// Shutdown stops xdg-dbus-proxy with SIGTERM, escalating to SIGKILL when ctx
+// is done, and removes its sockets.
+func (p *DBusProxy) Shutdown(ctx context.Context) error {
+	err := stopCmd(ctx, p.process)
+	if p.SessionSocketPath != "" {
+		_ = os.Remove(p.SessionSocketPath)
+	}
+	if p.SystemSocketPath != "" {
+		_ = os.Remove(p.SystemSocketPath)
+	}
+
+	if p.debug {
+		logging.Debugf("linux", "D-Bus proxy cleaned up")
+	}
+	return err
+}
+
+// dbusMountArgs returns bwrap arguments that hide the host D-Bus sockets from
+// the sandbox, binding the filtered proxy sockets in their place when proxy is
+// set, and any variables the inner script must export to reach them.
+func dbusMountArgs(proxy *DBusProxy, canUnshareNet, debug bool) (args, env []string) {
+	var sessionProxy, systemProxy string
+	if proxy != nil {
+		sessionProxy, systemProxy = proxy.SessionSocketPath, proxy.SystemSocketPath
+	}
+
+	bus := sessionBus()
+	args = append(args, replaceBusSockets(bus.paths, sessionProxy)...)
+	switch {
+	case len(bus.paths) == 0 && sessionProxy != "":
+		// Abstract socket: nothing to replace, so point the sandbox at the proxy
+		args = append(args, "--bind", sessionProxy, sandboxSessionBusPath)
+		env = append(env, "DBUS_SESSION_BUS_ADDRESS=unix:path="+sandboxSessionBusPath)
+	case len(bus.paths) == 0 && bus.address != "" && !canUnshareNet && debug:
+		logging.Warnf("linux", "abstract D-Bus session socket is reachable without a network namespace")
+	}
+
+	args = append(args, replaceBusSockets(systemBus().paths, systemProxy)...)
+
+	return args, env
+}
+
+// replaceBusSockets masks each existing bus socket with /dev/null, or binds
+// the proxy socket over it if one is given.
+func replaceBusSockets(paths []string, proxySocket string) []string {
+	var args []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		if proxySocket != "" {
+			args = append(args, "--bind", proxySocket, resolved)
+		} else {
+			args = append(args, "--ro-bind", "/dev/null", resolved)
+		}
+	}
+	return args
+}
+
+// waitForSockets waits until all paths exist, up to timeout or until ctx is done.
+func waitForSockets(ctx context.Context, timeout time.Duration, paths ...string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		ready := true
+		for _, p := range paths {
+			if !fileExists(p) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-waitCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: sockets were not created", ErrInitTimeout)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDBusSocketPath(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"unix:path=/run/user/1000/bus", "/run/user/1000/bus"},
+		{"unix:path=/tmp/dbus%20x,guid=abc", "/tmp/dbus x"},
+		{"unix:abstract=/tmp/dbus-XYZ,guid=abc", ""},
+		{"tcp:host=localhost,port=1234;unix:path=/run/bus", "/run/bus"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := dbusSocketPath(tt.address); got != tt.want {
+				t.Errorf("dbusSocketPath(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBusTalkNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		wantNames []string
+	}{
+		{"empty", nil, nil, nil},
+		{"allowed", []string{"org.freedesktop.Notifications"}, nil, []string{"org.freedesktop.Notifications"}},
+		{"denied by config", []string{"org.freedesktop.Notifications", "org.example.App"}, []string{"org.example.App"}, []string{"org.freedesktop.Notifications"}},
+		{"denied by default", []string{"org.freedesktop.secrets"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if names := dbusTalkNames(tt.allow, tt.deny); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("dbusTalkNames() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestDBusMountArgs(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "bus")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+socket)
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "missing"))
+
+	tests := []struct {
+		name  string
+		proxy *DBusProxy
+		want  []string
+	}{
+		{
+			name: "blocked by default",
+			want: []string{"--ro-bind", "/dev/null", socket},
+		},
+		{
+			name:  "proxied",
+			proxy: &DBusProxy{SessionSocketPath: "/tmp/fence-dbus-session-test.sock"},
+			want:  []string{"--bind", "/tmp/fence-dbus-session-test.sock", socket},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, env := dbusMountArgs(tt.proxy, true, false)
+			if !slices.Equal(args, tt.want) {
+				t.Errorf("args = %v, want %v", args, tt.want)
+			}
+			if len(env) != 0 {
+				t.Errorf("env = %v, want none", env)
+			}
+		})
+	}
+}
+
+func TestDBusMountArgsAbstractSocket(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:abstract=/tmp/dbus-test")
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "missing"))
+
+	proxy := &DBusProxy{SessionSocketPath: "/tmp/fence-dbus-session-test.sock"}
+	args, env := dbusMountArgs(proxy, true, false)
+
+	wantArgs := []string{"--bind", proxy.SessionSocketPath, sandboxSessionBusPath}
+	if !slices.Equal(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+	wantEnv := []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + sandboxSessionBusPath}
+	if !slices.Equal(env, wantEnv) {
+		t.Errorf("env = %v, want %v", env, wantEnv)
+	}
+}
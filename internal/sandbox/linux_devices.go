@@ -0,0 +1,73 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// Media device directories, hidden with an empty tmpfs unless allowed.
+var (
+	audioDeviceDirs  = []string{"/dev/snd"}
+	cameraDeviceDirs = []string{"/dev/v4l"}
+)
+
+// Media device node patterns, masked with /dev/null unless allowed.
+var (
+	audioDeviceGlobs  = []string{"/dev/dsp*", "/dev/audio*", "/dev/mixer*"}
+	cameraDeviceGlobs = []string{"/dev/video*", "/dev/media*"}
+)
+
+// audioServerSockets returns the PulseAudio and PipeWire sockets in
+// $XDG_RUNTIME_DIR. PipeWire also serves cameras to clients that use it.
+func audioServerSockets() []string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return nil
+	}
+	return []string{
+		filepath.Join(dir, "pulse", "native"),
+		filepath.Join(dir, "pipewire-0"),
+		filepath.Join(dir, "pipewire-0-manager"),
+	}
+}
+
+// deviceMountArgs returns bwrap arguments that hide microphone and camera
+// devices from the sandbox, unless devices.allowAudio or devices.allowCamera is set.
+func deviceMountArgs(cfg *config.Config) []string {
+	var devices config.DevicesConfig
+	if cfg != nil {
+		devices = cfg.Devices
+	}
+
+	var dirs, globs, files []string
+	if !devices.AllowAudio {
+		dirs = append(dirs, audioDeviceDirs...)
+		globs = append(globs, audioDeviceGlobs...)
+		files = append(files, audioServerSockets()...)
+	}
+	if !devices.AllowCamera {
+		dirs = append(dirs, cameraDeviceDirs...)
+		globs = append(globs, cameraDeviceGlobs...)
+	}
+
+	var args []string
+	for _, d := range dirs {
+		if fileExists(d) {
+			args = append(args, "--tmpfs", d)
+		}
+	}
+	for _, g := range globs {
+		matches, _ := filepath.Glob(g)
+		files = append(files, matches...)
+	}
+	for _, f := range files {
+		if resolved, err := filepath.EvalSymlinks(f); err == nil {
+			args = append(args, "--ro-bind", "/dev/null", resolved)
+		}
+	}
+	return args
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestDeviceMountArgs(t *testing.T) {
+	runtimeDir := t.TempDir()
+	pulse := filepath.Join(runtimeDir, "pulse", "native")
+	pipewire := filepath.Join(runtimeDir, "pipewire-0")
+	if err := os.MkdirAll(filepath.Dir(pulse), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{pulse, pipewire} {
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	tests := []struct {
+		name       string
+		devices    config.DevicesConfig
+		wantMasked bool
+	}{
+		{"denied by default", config.DevicesConfig{}, true},
+		{"camera only", config.DevicesConfig{AllowCamera: true}, true},
+		{"audio allowed", config.DevicesConfig{AllowAudio: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := deviceMountArgs(&config.Config{Devices: tt.devices})
+			for _, socket := range []string{pulse, pipewire} {
+				if got := slices.Contains(args, socket); got != tt.wantMasked {
+					t.Errorf("%s masked = %v, want %v (args %v)", socket, got, tt.wantMasked, args)
+				}
+			}
+		})
+	}
+
+	if args := deviceMountArgs(&config.Config{Devices: config.DevicesConfig{AllowAudio: true, AllowCamera: true}}); len(args) != 0 {
+		t.Errorf("all allowed: args = %v, want none", args)
+	}
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// x11SocketDir holds the X11 server sockets. It is hidden by the sandbox's
+// private /tmp unless the display is allowed.
+const x11SocketDir = "/tmp/.X11-unix"
+
+// displayEnvVars are unset inside the sandbox when the display is hidden.
+var displayEnvVars = []string{"DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY"}
+
+// waylandSocketPath returns the host Wayland socket path, or "" if unknown.
+func waylandSocketPath() string {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		name = "wayland-0"
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// xauthorityPath returns the host Xauthority file path, or "" if unknown.
+func xauthorityPath() string {
+	if p := os.Getenv("XAUTHORITY"); p != "" {
+		return p
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".Xauthority")
+	}
+	return ""
+}
+
+// displayMountArgs returns bwrap arguments that hide the X11 and Wayland
+// sockets and Xauthority from the sandbox, or with gui.allowDisplay expose
+// them read-only, and the environment variables the inner script must unset.
+func displayMountArgs(cfg *config.Config, canUnshareNet, debug bool) (args, unset []string) {
+	allow := cfg != nil && cfg.GUI.AllowDisplay
+
+	var files []string
+	for _, p := range []string{waylandSocketPath(), xauthorityPath()} {
+		if p == "" {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			files = append(files, resolved)
+		}
+	}
+
+	if !allow {
+		// X11 sockets are already hidden by the private /tmp, and abstract
+		// X11 sockets by the network namespace.
+		for _, p := range files {
+			args = append(args, "--ro-bind", "/dev/null", p)
+		}
+		if debug && !canUnshareNet && os.Getenv("DISPLAY") != "" {
+			logging.Warnf("linux", "abstract X11 sockets are reachable without a network namespace")
+		}
+		return args, displayEnvVars
+	}
+
+	if fileExists(x11SocketDir) {
+		args = append(args, "--ro-bind", x11SocketDir, x11SocketDir)
+	}
+	// Anything outside /tmp is already visible through the read-only root
+	for _, p := range files {
+		if strings.HasPrefix(p, "/tmp/") {
+			args = append(args, "--ro-bind", p, p)
+		}
+	}
+	if debug && canUnshareNet && isTCPDisplay(os.Getenv("DISPLAY")) {
+		logging.Warnf("linux", "DISPLAY %q uses TCP, which the network namespace blocks", os.Getenv("DISPLAY"))
+	}
+	return args, nil
+}
+
+// isTCPDisplay reports whether an X11 DISPLAY value names a remote host
+// (e.g. "localhost:10.0" from SSH forwarding) rather than a local socket.
+func isTCPDisplay(display string) bool {
+	host, _, ok := strings.Cut(display, ":")
+	return ok && host != "" && host != "unix" && !strings.HasPrefix(host, "/")
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestDisplayMountArgs(t *testing.T) {
+	runtimeDir := t.TempDir()
+	wayland := filepath.Join(runtimeDir, "wayland-1")
+	xauth := filepath.Join(runtimeDir, "Xauthority")
+	for _, p := range []string{wayland, xauth} {
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv("WAYLAND_DISPLAY", "wayland-1")
+	t.Setenv("XAUTHORITY", xauth)
+
+	args, unset := displayMountArgs(config.Default(), true, false)
+	wantArgs := []string{"--ro-bind", "/dev/null", wayland, "--ro-bind", "/dev/null", xauth}
+	if !slices.Equal(args, wantArgs) {
+		t.Errorf("hidden: args = %v, want %v", args, wantArgs)
+	}
+	if !slices.Equal(unset, displayEnvVars) {
+		t.Errorf("hidden: unset = %v, want %v", unset, displayEnvVars)
+	}
+
+	cfg := config.Default()
+	cfg.GUI.AllowDisplay = true
+	args, unset = displayMountArgs(cfg, true, false)
+	if slices.Contains(args, "/dev/null") {
+		t.Errorf("allowed: args = %v, should not mask anything", args)
+	}
+	if len(unset) != 0 {
+		t.Errorf("allowed: unset = %v, want none", unset)
+	}
+}
+
+func TestIsTCPDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		want    bool
+	}{
+		{":0", false},
+		{":1.0", false},
+		{"unix:0", false},
+		{"/private/tmp/com.apple.launchd.abc/org.xquartz:0", false},
+		{"localhost:10.0", true},
+		{"192.168.1.5:0", true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.display, func(t *testing.T) {
+			if got := isTCPDisplay(tt.display); got != tt.want {
+				t.Errorf("isTCPDisplay(%q) = %v, want %v", tt.display, got, tt.want)
+			}
+		})
+	}
+}
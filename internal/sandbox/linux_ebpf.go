@@ -3,27 +3,40 @@
 package sandbox
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
 )
 
-// EBPFMonitor monitors sandbox violations using eBPF tracing.
-// This requires CAP_BPF or root privileges.
+// EBPFMonitor monitors sandbox violations with eBPF programs on the
+// syscall tracepoints, which report the failures of the sandbox's processes
+// with the paths and addresses involved. This requires CAP_BPF and
+// CAP_PERFMON, or root, and tracefs.
 type EBPFMonitor struct {
 	pid        int
 	debug      bool
 	cancel     context.CancelFunc
 	running    bool
-	cmd        *exec.Cmd
-	scriptPath string // Path to bpftrace script (for cleanup)
+	collection *ebpf.Collection
+	links      []link.Link
+	done       chan struct{} // Closed when the events are read
+	violations *policy.ViolationLog
 }
 
 // NewEBPFMonitor creates a new eBPF-based violation monitor.
@@ -35,30 +48,69 @@ func NewEBPFMonitor(pid int, debug bool) *EBPFMonitor {
 }
 
 // Start begins eBPF-based monitoring of filesystem and network violations.
-func (m *EBPFMonitor) Start() error {
+// Monitoring stops when ctx is canceled or Stop is called.
+func (m *EBPFMonitor) Start(ctx context.Context) error {
 	features := DetectLinuxFeatures()
 	if !features.HasEBPF {
 		if m.debug {
-			fmt.Fprintf(os.Stderr, "[fence:ebpf] eBPF monitoring not available (need CAP_BPF or root)\n")
+			logging.Debugf("ebpf", "eBPF monitoring not available (need CAP_BPF or root)")
 		}
 		return nil
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	m.cancel = cancel
-	m.running = true
+	tracefs, err := tracefsDir()
+	if err != nil {
+		return err
+	}
+	spec, attachments, err := violationMonitorSpec(tracefs)
+	if err != nil {
+		return fmt.Errorf("failed to build eBPF programs: %w", err)
+	}
+	// Kernels before 5.11 charge eBPF maps to RLIMIT_MEMLOCK
+	if err := rlimit.RemoveMemlock(); err != nil && m.debug {
+		logging.Debugf("ebpf", "Failed to raise RLIMIT_MEMLOCK: %v", err)
+	}
+	m.collection, err = ebpf.NewCollection(spec)
+	if err != nil {
+		return fmt.Errorf("failed to load eBPF programs: %w", err)
+	}
 
-	// Try multiple eBPF tracing approaches
-	if err := m.tryBpftrace(ctx); err != nil {
-		if m.debug {
-			fmt.Fprintf(os.Stderr, "[fence:ebpf] bpftrace not available: %v\n", err)
+	// Threads forked once the tracking programs are attached are tracked as
+	// they start, and those forked before are found in /proc
+	seeded := false
+	for _, a := range attachments {
+		if a.group == "syscalls" && !seeded {
+			for _, tid := range sandboxThreads(m.pid) {
+				if err := m.collection.Maps["tracked"].Put(tid, uint32(1)); err != nil {
+					m.close()
+					return fmt.Errorf("failed to track PID %d: %w", tid, err)
+				}
+			}
+			seeded = true
 		}
-		// Fall back to other methods
-		go m.traceWithPerfEvents()
+		l, err := link.Tracepoint(a.group, a.name, m.collection.Programs[a.name], nil)
+		if err != nil {
+			m.close()
+			return fmt.Errorf("failed to attach to tracepoint %s:%s: %w", a.group, a.name, err)
+		}
+		m.links = append(m.links, l)
+	}
+
+	reader, err := ringbuf.NewReader(m.collection.Maps["events"])
+	if err != nil {
+		m.close()
+		return fmt.Errorf("failed to read eBPF events: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.running = true
+	m.done = make(chan struct{})
+	context.AfterFunc(ctx, func() { _ = reader.Close() })
+	go m.read(reader)
+
 	if m.debug {
-		fmt.Fprintf(os.Stderr, "[fence:ebpf] Started eBPF monitoring for PID %d\n", m.pid)
+		logging.Debugf("ebpf", "Started eBPF monitoring for PID %d", m.pid)
 	}
 
 	return nil
@@ -73,188 +125,157 @@ func (m *EBPFMonitor) Stop() {
 	// Give a moment for pending events
 	time.Sleep(200 * time.Millisecond)
 
-	if m.cancel != nil {
-		m.cancel()
-	}
-
-	if m.cmd != nil && m.cmd.Process != nil {
-		_ = m.cmd.Process.Kill()
-		_ = m.cmd.Wait()
-	}
-
-	// Clean up the script file
-	if m.scriptPath != "" {
-		_ = os.Remove(m.scriptPath)
-	}
+	m.cancel()
+	<-m.done
+	m.close()
+	policy.MonitorOutput.Flush()
 
 	m.running = false
 }
 
-// tryBpftrace attempts to use bpftrace for monitoring.
-func (m *EBPFMonitor) tryBpftrace(ctx context.Context) error {
-	bpftracePath, err := exec.LookPath("bpftrace")
-	if err != nil {
-		return fmt.Errorf("bpftrace not found: %w", err)
-	}
-
-	// Create a bpftrace script that monitors file operations and network syscalls
-	script := m.generateBpftraceScript()
-
-	// Write script to temp file
-	tmpFile, err := os.CreateTemp("", "fence-ebpf-*.bt")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	scriptPath := tmpFile.Name()
-	m.scriptPath = scriptPath // Store for cleanup later
-
-	if _, err := tmpFile.WriteString(script); err != nil {
-		_ = tmpFile.Close()
-		_ = os.Remove(scriptPath)
-		return fmt.Errorf("failed to write script: %w", err)
-	}
-	_ = tmpFile.Close()
-
-	m.cmd = exec.CommandContext(ctx, bpftracePath, tmpFile.Name()) //nolint:gosec // bpftracePath from LookPath
-	stdout, err := m.cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create pipe: %w", err)
+// close detaches and unloads the programs.
+func (m *EBPFMonitor) close() {
+	for _, l := range m.links {
+		_ = l.Close()
 	}
-
-	stderr, err := m.cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	if err := m.cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start bpftrace: %w", err)
+	m.links = nil
+	if m.collection != nil {
+		m.collection.Close()
+		m.collection = nil
 	}
+}
 
-	// Parse bpftrace output in background
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if m.debug {
-				fmt.Fprintf(os.Stderr, "[fence:ebpf:trace] %s\n", line)
-			}
-			if violation := m.parseBpftraceOutput(line); violation != "" {
-				fmt.Fprintf(os.Stderr, "%s\n", violation)
+// read reports the events in reader until it is closed.
+func (m *EBPFMonitor) read(reader *ringbuf.Reader) {
+	defer close(m.done)
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if !errors.Is(err, ringbuf.ErrClosed) && m.debug {
+				logging.Debugf("ebpf", "Failed to read events: %v", err)
 			}
+			return
 		}
-	}()
-
-	// Also show stderr in debug mode
-	if m.debug {
-		go func() {
-			scanner := bufio.NewScanner(stderr)
-			for scanner.Scan() {
-				line := scanner.Text()
-				fmt.Fprintf(os.Stderr, "[fence:ebpf:err] %s\n", line)
+		var raw ebpfEvent
+		if err := binary.Read(bytes.NewReader(record.RawSample), binary.NativeEndian, &raw); err != nil {
+			if m.debug {
+				logging.Debugf("ebpf", "Failed to decode event: %v", err)
 			}
-		}()
+			continue
+		}
+		if v := raw.violation(time.Now()); v != nil {
+			m.report(v)
+		}
 	}
-
-	return nil
-}
-
-// generateBpftraceScript generates a bpftrace script for monitoring.
-// The script filters events to only show processes that are descendants of the sandbox.
-func (m *EBPFMonitor) generateBpftraceScript() string {
-	// This script traces syscalls that return EACCES or EPERM
-	// It tracks the sandbox PID and its descendants using a map
-	//
-	// Note: bpftrace can't directly check process ancestry, so we track
-	// child PIDs via fork/clone and check against the tracked set.
-
-	// Filter by PID range: only show events from processes spawned after the sandbox started
-	// This isn't perfect but filters out pre-existing system processes
-	// PID tracking via fork doesn't work because bpftrace attaches after the command starts
-	script := fmt.Sprintf(`
-BEGIN
-{
-    printf("fence:ebpf monitoring started for sandbox PID %%d (filtering pid >= %%d)\n", %d, %d);
 }
 
-// Monitor filesystem errors (EPERM=-1, EACCES=-13, EROFS=-30)
-// Filter: pid >= SANDBOX_PID to exclude pre-existing processes
-tracepoint:syscalls:sys_exit_openat
-/(args->ret == -13 || args->ret == -1 || args->ret == -30) && pid >= %d/
-{
-    printf("DENIED:open pid=%%d comm=%%s ret=%%d\n", pid, comm, args->ret);
-}
-
-tracepoint:syscalls:sys_exit_unlinkat
-/(args->ret == -13 || args->ret == -1 || args->ret == -30) && pid >= %d/
-{
-    printf("DENIED:unlink pid=%%d comm=%%s ret=%%d\n", pid, comm, args->ret);
-}
+// report prints a violation and adds it to the violation log, if one is set.
+func (m *EBPFMonitor) report(v *ViolationEvent) {
+	if m.debug {
+		logging.Debugf("ebpf:trace", "%s pid=%d comm=%s errno=%d %s", v.Operation, v.PID, v.Comm, v.Errno, v.target())
+	}
+	// Repeats differ only in the timestamp and process
+	key := fmt.Sprintf("ebpf %s %s %s %d", v.Operation, v.target(), v.Comm, v.Errno)
+	policy.MonitorOutput.Print(v.Operation, key, v.FormatViolation())
 
-tracepoint:syscalls:sys_exit_mkdirat
-/(args->ret == -13 || args->ret == -1 || args->ret == -30) && pid >= %d/
-{
-    printf("DENIED:mkdir pid=%%d comm=%%s ret=%%d\n", pid, comm, args->ret);
+	if m.violations == nil {
+		return
+	}
+	kind := policy.KindFilesystem
+	if v.Type == "network" {
+		kind = policy.KindNetwork
+	}
+	target := v.target()
+	if target == "" {
+		target = fmt.Sprintf("%s (%s)", v.Operation, v.Comm)
+	}
+	m.violations.Record(policy.Event{
+		Source:   policy.SourceEBPF,
+		Kind:     kind,
+		Target:   target,
+		Decision: policy.Deny("", "sandbox returned "+getErrnoName(-v.Errno)),
+		Process:  v.Comm,
+	})
 }
 
-tracepoint:syscalls:sys_exit_connect
-/(args->ret == -13 || args->ret == -1 || args->ret == -111) && pid >= %d/
-{
-    printf("DENIED:connect pid=%%d comm=%%s ret=%%d\n", pid, comm, args->ret);
-}
-`, m.pid, m.pid, m.pid, m.pid, m.pid, m.pid)
-	return script
+// violation returns the violation an event reports, or nil for an
+// unknown operation.
+func (e *ebpfEvent) violation(now time.Time) *ViolationEvent {
+	var sc *ebpfSyscall
+	for i := range ebpfSyscalls {
+		if ebpfSyscalls[i].op == e.Op {
+			sc = &ebpfSyscalls[i]
+		}
+	}
+	if sc == nil {
+		return nil
+	}
+	v := &ViolationEvent{
+		Timestamp: now,
+		Type:      "file",
+		Operation: sc.name,
+		PID:       int(e.TGID),
+		Comm:      unix.ByteSliceToString(e.Comm[:]),
+		Errno:     int(-e.Ret),
+	}
+	if sc.lengthArg != "" {
+		v.Type = "network"
+		v.Addr = formatSockaddr(e.Data[:min(int(e.DataLen), len(e.Data))])
+	} else {
+		v.Path = unix.ByteSliceToString(e.Data[:])
+	}
+	return v
 }
 
-// parseBpftraceOutput parses bpftrace output and formats violations.
-func (m *EBPFMonitor) parseBpftraceOutput(line string) string {
-	if !strings.HasPrefix(line, "DENIED:") {
+// formatSockaddr formats a socket address as host:port, or as the path of
+// a Unix socket, with an @ for an abstract one.
+func formatSockaddr(sa []byte) string {
+	if len(sa) < 2 {
 		return ""
 	}
-
-	// Parse: DENIED:syscall pid=X comm=Y ret=Z
-	pattern := regexp.MustCompile(`DENIED:(\w+) pid=(\d+) comm=(\S+) ret=(-?\d+)`)
-	matches := pattern.FindStringSubmatch(line)
-	if matches == nil {
-		return ""
+	family := binary.NativeEndian.Uint16(sa)
+	switch {
+	case family == unix.AF_INET && len(sa) >= 8:
+		addr := netip.AddrFrom4([4]byte(sa[4:8]))
+		return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(sa[2:])).String()
+	case family == unix.AF_INET6 && len(sa) >= 24:
+		addr := netip.AddrFrom16([16]byte(sa[8:24])).Unmap()
+		return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(sa[2:])).String()
+	case family == unix.AF_UNIX:
+		path := sa[2:]
+		if len(path) > 0 && path[0] == 0 {
+			return "@" + string(bytes.TrimRight(path[1:], "\x00"))
+		}
+		return unix.ByteSliceToString(path)
 	}
-
-	syscall := matches[1]
-	pid, _ := strconv.Atoi(matches[2])
-	comm := matches[3]
-	ret, _ := strconv.Atoi(matches[4])
-
-	// Format the violation
-	errorName := getErrnoName(ret)
-	timestamp := time.Now().Format("15:04:05")
-
-	return fmt.Sprintf("[fence:ebpf] %s ✗ %s: %s (%s, pid=%d)",
-		timestamp, syscall, errorName, comm, pid)
+	return fmt.Sprintf("address family %d", family)
 }
 
-// traceWithPerfEvents uses perf events for tracing (fallback when bpftrace unavailable).
-func (m *EBPFMonitor) traceWithPerfEvents() {
-	// This is a fallback that uses the audit subsystem or trace-cmd
-	// For now, we'll just monitor the trace pipe if available
-
-	tracePipe := "/sys/kernel/debug/tracing/trace_pipe"
-	if _, err := os.Stat(tracePipe); err != nil {
-		if m.debug {
-			fmt.Fprintf(os.Stderr, "[fence:ebpf] trace_pipe not available\n")
+// sandboxThreads returns the threads of pid and of its descendants.
+func sandboxThreads(pid int) []uint32 {
+	var tids []uint32
+	pids := []int{pid}
+	for len(pids) > 0 {
+		pid, pids = pids[0], pids[1:]
+		tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+		if err != nil {
+			continue
 		}
-		return
-	}
-
-	f, err := os.Open(tracePipe)
-	if err != nil {
-		if m.debug {
-			fmt.Fprintf(os.Stderr, "[fence:ebpf] Failed to open trace_pipe: %v\n", err)
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil {
+				continue
+			}
+			tids = append(tids, uint32(tid))
+			children, _ := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/children", pid, tid))
+			for _, child := range strings.Fields(string(children)) {
+				if n, err := strconv.Atoi(child); err == nil {
+					pids = append(pids, n)
+				}
+			}
 		}
-		return
 	}
-	defer func() { _ = f.Close() }()
-
-	// We'd need to set up tracepoints first, which requires additional setup
-	// For now, this is a placeholder for the full implementation
+	return tids
 }
 
 // getErrnoName returns a human-readable description of an errno value.
@@ -268,6 +289,7 @@ func getErrnoName(errno int) string {
 		-21:  "Is a directory",
 		-30:  "Read-only file system",
 		-22:  "Invalid argument",
+		-101: "Network is unreachable",
 		-111: "Connection refused",
 	}
 
@@ -288,24 +310,13 @@ func RequiredCapabilities() []string {
 	return []string{"CAP_BPF", "CAP_PERFMON"}
 }
 
-// CheckBpftraceAvailable checks if bpftrace is installed and usable.
-func CheckBpftraceAvailable() bool {
-	path, err := exec.LookPath("bpftrace")
-	if err != nil {
-		return false
-	}
-
-	// Verify it can run (needs permissions)
-	cmd := exec.Command(path, "--version") //nolint:gosec // path from LookPath
-	return cmd.Run() == nil
-}
-
 // ViolationEvent represents a sandbox violation detected by eBPF.
 type ViolationEvent struct {
 	Timestamp time.Time
 	Type      string // "file", "network", "syscall"
 	Operation string // "open", "write", "connect", etc.
 	Path      string
+	Addr      string // Address connected to, as host:port or a socket path
 	PID       int
 	Comm      string // Process name
 	Errno     int
@@ -316,20 +327,24 @@ func (v *ViolationEvent) FormatViolation() string {
 	timestamp := v.Timestamp.Format("15:04:05")
 	errName := getErrnoName(-v.Errno)
 
+	if target := v.target(); target != "" {
+		return fmt.Sprintf("[fence:ebpf] %s %s %s: %s (%s, %s:%d)",
+			timestamp, output.Render(output.Blocked), v.Operation, target, errName, v.Comm, v.PID)
+	}
+	return fmt.Sprintf("[fence:ebpf] %s %s %s: %s (%s:%d)",
+		timestamp, output.Render(output.Blocked), v.Operation, errName, v.Comm, v.PID)
+}
+
+// target returns the path or address of the violation.
+func (v *ViolationEvent) target() string {
 	if v.Path != "" {
-		return fmt.Sprintf("[fence:ebpf] %s ✗ %s: %s (%s, %s:%d)",
-			timestamp, v.Operation, v.Path, errName, v.Comm, v.PID)
+		return v.Path
 	}
-	return fmt.Sprintf("[fence:ebpf] %s ✗ %s: %s (%s:%d)",
-		timestamp, v.Operation, errName, v.Comm, v.PID)
+	return v.Addr
 }
 
 // EnsureTracingSetup ensures the kernel tracing infrastructure is available.
 func EnsureTracingSetup() error {
-	// Check if debugfs is mounted
-	debugfs := "/sys/kernel/debug"
-	if _, err := os.Stat(filepath.Join(debugfs, "tracing")); os.IsNotExist(err) {
-		return fmt.Errorf("debugfs tracing not available at %s/tracing", debugfs)
-	}
-	return nil
+	_, err := tracefsDir()
+	return err
 }
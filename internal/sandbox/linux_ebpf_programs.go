@@ -0,0 +1,443 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"golang.org/x/sys/unix"
+)
+
+// The violation monitor's programs are assembled here rather than compiled
+// from C, so building fence needs no clang. Like CO-RE programs, they are
+// relocated when loaded: the offsets of the tracepoint fields they read are
+// taken from the running kernel's tracepoint formats, which differ between
+// kernel versions (sched_process_fork's, for one, changed in 6.x).
+//
+// The programs keep the threads of the sandbox in the tracked map: the
+// sandbox process is added from user space, and each thread a tracked thread
+// forks, or that a tracked thread becomes by exec, is added as it is
+// created. A tracked thread's path and address arguments are saved on entry
+// to the monitored syscalls, and on exit a failure with one of the errnos a
+// sandbox returns is sent to the events ring buffer with them.
+
+// ebpfOp identifies a monitored syscall in events.
+type ebpfOp uint32
+
+const (
+	ebpfOpOpen ebpfOp = iota + 1
+	ebpfOpUnlink
+	ebpfOpMkdir
+	ebpfOpConnect
+)
+
+// ebpfSyscall is a syscall the violation monitor reports failures of.
+type ebpfSyscall struct {
+	op        ebpfOp
+	name      string // Operation name in violations
+	syscall   string // Name of the syscalls tracepoints
+	arg       string // Field with the path or address
+	lengthArg string // Field with the address length, for addresses
+	errnos    []unix.Errno
+}
+
+// ebpfFileErrnos are the errnos filesystem restrictions return.
+var ebpfFileErrnos = []unix.Errno{unix.EPERM, unix.EACCES, unix.EROFS}
+
+// ebpfSyscalls are the monitored syscalls.
+var ebpfSyscalls = []ebpfSyscall{
+	{op: ebpfOpOpen, name: "open", syscall: "openat", arg: "filename", errnos: ebpfFileErrnos},
+	{op: ebpfOpUnlink, name: "unlink", syscall: "unlinkat", arg: "pathname", errnos: ebpfFileErrnos},
+	{op: ebpfOpMkdir, name: "mkdir", syscall: "mkdirat", arg: "pathname", errnos: ebpfFileErrnos},
+	{
+		op: ebpfOpConnect, name: "connect", syscall: "connect", arg: "uservaddr", lengthArg: "addrlen",
+		errnos: []unix.Errno{unix.EPERM, unix.EACCES, unix.ECONNREFUSED, unix.ENETUNREACH},
+	},
+}
+
+// Layout of the events the programs send, which ebpfEvent matches.
+const (
+	ebpfEventTID     = 0
+	ebpfEventTGID    = 4
+	ebpfEventOp      = 8
+	ebpfEventRet     = 12
+	ebpfEventComm    = 16
+	ebpfEventDataLen = 32
+	ebpfEventData    = 40
+	ebpfEventSize    = ebpfEventData + ebpfEventDataSize
+
+	ebpfEventCommSize = 16
+	ebpfEventDataSize = 256
+)
+
+// ebpfEvent is a syscall failure, as the programs send it.
+type ebpfEvent struct {
+	TID     uint32
+	TGID    uint32
+	Op      ebpfOp
+	Ret     int32 // Negated errno
+	Comm    [ebpfEventCommSize]byte
+	DataLen uint32 // Length of the address in Data; paths end in a NUL
+	_       uint32
+	Data    [ebpfEventDataSize]byte
+}
+
+// ebpfMaxThreads bounds the threads tracked, and the syscalls in flight.
+const ebpfMaxThreads = 16384
+
+// ebpfAttachment is a tracepoint a program runs on. The program has the
+// tracepoint's name in the collection.
+type ebpfAttachment struct {
+	group, name string
+}
+
+// tracepointField is a field of a tracepoint's records.
+type tracepointField struct {
+	offset int16
+	size   int
+}
+
+// tracefsDirs are where tracefs is usually mounted.
+var tracefsDirs = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// tracefsDir returns the directory tracefs is mounted at.
+func tracefsDir() (string, error) {
+	for _, dir := range tracefsDirs {
+		if _, err := os.Stat(filepath.Join(dir, "events")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("tracefs is not mounted at %s", strings.Join(tracefsDirs, " or "))
+}
+
+var (
+	tracepointFieldPattern = regexp.MustCompile(`^\s*field:([^;]*);\s*offset:(\d+);\s*size:(\d+);`)
+	arraySizePattern       = regexp.MustCompile(`\[[^]]*\]`)
+)
+
+// tracepointFields returns the fields of a tracepoint's records, by name,
+// from the format tracefs describes them in.
+func tracepointFields(tracefs, group, name string) (map[string]tracepointField, error) {
+	f, err := os.Open(filepath.Join(tracefs, "events", group, name, "format"))
+	if err != nil {
+		return nil, fmt.Errorf("tracepoint %s:%s: %w", group, name, err)
+	}
+	defer func() { _ = f.Close() }()
+	return parseTracepointFormat(bufio.NewScanner(f))
+}
+
+// parseTracepointFormat parses the field lines of a tracepoint format.
+func parseTracepointFormat(scanner *bufio.Scanner) (map[string]tracepointField, error) {
+	fields := make(map[string]tracepointField)
+	for scanner.Scan() {
+		m := tracepointFieldPattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		// The name is the declaration's last word, without array sizes
+		words := strings.Fields(arraySizePattern.ReplaceAllString(m[1], ""))
+		if len(words) == 0 {
+			continue
+		}
+		offset, _ := strconv.Atoi(m[2])
+		size, _ := strconv.Atoi(m[3])
+		fields[strings.TrimLeft(words[len(words)-1], "*")] = tracepointField{offset: int16(offset), size: size}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no fields in tracepoint format")
+	}
+	return fields, nil
+}
+
+// tracepointFormats reads the fields of tracepoints from tracefs, once.
+type tracepointFormats struct {
+	tracefs string
+	formats map[string]map[string]tracepointField
+}
+
+// field returns the field of the tracepoint group:name, which must be an
+// integer or a pointer.
+func (t *tracepointFormats) field(group, name, field string) (tracepointField, error) {
+	key := group + ":" + name
+	fields, ok := t.formats[key]
+	if !ok {
+		var err error
+		if fields, err = tracepointFields(t.tracefs, group, name); err != nil {
+			return tracepointField{}, err
+		}
+		t.formats[key] = fields
+	}
+	f, ok := fields[field]
+	if !ok {
+		return tracepointField{}, fmt.Errorf("tracepoint %s has no field %s", key, field)
+	}
+	switch f.size {
+	case 1, 2, 4, 8:
+		return f, nil
+	}
+	return tracepointField{}, fmt.Errorf("tracepoint %s field %s has size %d", key, field, f.size)
+}
+
+// load returns the instruction loading the field f of the record at src.
+func (f tracepointField) load(dst, src asm.Register) asm.Instruction {
+	size := map[int]asm.Size{1: asm.Byte, 2: asm.Half, 4: asm.Word, 8: asm.DWord}[f.size]
+	return asm.LoadMem(dst, src, f.offset, size)
+}
+
+// programNames shortens tracepoint names to fit the kernel's program names.
+var programNames = strings.NewReplacer("sched_process_", "", "sys_", "")
+
+// violationMonitorSpec returns the violation monitor's maps and programs,
+// relocated for the tracepoint formats in tracefs, and the tracepoints to
+// attach the programs to: first those that track the sandbox's threads,
+// then the syscalls'.
+func violationMonitorSpec(tracefs string) (*ebpf.CollectionSpec, []ebpfAttachment, error) {
+	spec := &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			"tracked": {Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: ebpfMaxThreads},
+			"pending": {Type: ebpf.Hash, KeySize: 4, ValueSize: 16, MaxEntries: ebpfMaxThreads},
+			"scratch": {Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: ebpfEventSize, MaxEntries: 1},
+			"events":  {Type: ebpf.RingBuf, MaxEntries: 256 * 1024},
+		},
+		Programs: make(map[string]*ebpf.ProgramSpec),
+	}
+	formats := &tracepointFormats{tracefs: tracefs, formats: make(map[string]map[string]tracepointField)}
+	var attachments []ebpfAttachment
+	add := func(group, name string, insns asm.Instructions) {
+		spec.Programs[name] = &ebpf.ProgramSpec{
+			Name:         programNames.Replace(name),
+			Type:         ebpf.TracePoint,
+			License:      "Dual MIT/GPL",
+			Instructions: insns,
+		}
+		attachments = append(attachments, ebpfAttachment{group: group, name: name})
+	}
+
+	for _, tp := range []struct{ name, from, to string }{
+		{"sched_process_fork", "parent_pid", "child_pid"},
+		{"sched_process_exec", "old_pid", "pid"},
+	} {
+		from, err := formats.field("sched", tp.name, tp.from)
+		if err != nil {
+			return nil, nil, err
+		}
+		to, err := formats.field("sched", tp.name, tp.to)
+		if err != nil {
+			return nil, nil, err
+		}
+		add("sched", tp.name, inheritProgram(from, to))
+	}
+	pid, err := formats.field("sched", "sched_process_exit", "pid")
+	if err != nil {
+		return nil, nil, err
+	}
+	add("sched", "sched_process_exit", untrackProgram(pid))
+
+	for _, sc := range ebpfSyscalls {
+		arg, err := formats.field("syscalls", "sys_enter_"+sc.syscall, sc.arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		var length *tracepointField
+		if sc.lengthArg != "" {
+			f, err := formats.field("syscalls", "sys_enter_"+sc.syscall, sc.lengthArg)
+			if err != nil {
+				return nil, nil, err
+			}
+			length = &f
+		}
+		ret, err := formats.field("syscalls", "sys_exit_"+sc.syscall, "ret")
+		if err != nil {
+			return nil, nil, err
+		}
+		if ret.size != 8 {
+			return nil, nil, fmt.Errorf("tracepoint syscalls:sys_exit_%s field ret has size %d", sc.syscall, ret.size)
+		}
+		add("syscalls", "sys_enter_"+sc.syscall, syscallEnterProgram(arg, length))
+		add("syscalls", "sys_exit_"+sc.syscall, syscallExitProgram(sc, ret, length != nil))
+	}
+	return spec, attachments, nil
+}
+
+// mapPtr returns the instruction loading a pointer to the map name.
+func mapPtr(dst asm.Register, name string) asm.Instruction {
+	return asm.LoadMapPtr(dst, 0).WithReference(name)
+}
+
+// stackPtr returns the instructions pointing dst at offset in the stack.
+func stackPtr(dst asm.Register, offset int32) asm.Instructions {
+	return asm.Instructions{asm.Mov.Reg(dst, asm.RFP), asm.Add.Imm(dst, offset)}
+}
+
+// returnZero ends a program, as the exit label.
+func returnZero() asm.Instructions {
+	return asm.Instructions{asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"), asm.Return()}
+}
+
+// inheritProgram tracks the thread in the field to when the thread in the
+// field from is tracked.
+func inheritProgram(from, to tracepointField) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		from.load(asm.R1, asm.R6),
+		asm.StoreMem(asm.RFP, -4, asm.R1, asm.Word),
+		mapPtr(asm.R1, "tracked"),
+	}
+	insns = append(insns, stackPtr(asm.R2, -4)...)
+	insns = append(insns,
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		to.load(asm.R1, asm.R6),
+		asm.StoreMem(asm.RFP, -8, asm.R1, asm.Word),
+		asm.StoreImm(asm.RFP, -12, 1, asm.Word),
+		mapPtr(asm.R1, "tracked"),
+	)
+	insns = append(insns, stackPtr(asm.R2, -8)...)
+	insns = append(insns, stackPtr(asm.R3, -12)...)
+	insns = append(insns,
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateAny)),
+		asm.FnMapUpdateElem.Call(),
+	)
+	return append(insns, returnZero()...)
+}
+
+// untrackProgram forgets the thread in the field pid, which exited, so
+// that a thread reusing its id is not taken for the sandbox's.
+func untrackProgram(pid tracepointField) asm.Instructions {
+	insns := asm.Instructions{
+		pid.load(asm.R1, asm.R1),
+		asm.StoreMem(asm.RFP, -4, asm.R1, asm.Word),
+	}
+	for _, name := range []string{"tracked", "pending"} {
+		insns = append(insns, mapPtr(asm.R1, name))
+		insns = append(insns, stackPtr(asm.R2, -4)...)
+		insns = append(insns, asm.FnMapDeleteElem.Call())
+	}
+	return append(insns, returnZero()...)
+}
+
+// syscallEnterProgram saves the pointer in the field arg, and the length in
+// the field length if there is one, for a tracked thread's syscall.
+func syscallEnterProgram(arg tracepointField, length *tracepointField) asm.Instructions {
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.StoreMem(asm.RFP, -4, asm.R0, asm.Word),
+		mapPtr(asm.R1, "tracked"),
+	}
+	insns = append(insns, stackPtr(asm.R2, -4)...)
+	insns = append(insns,
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		arg.load(asm.R1, asm.R6),
+		asm.StoreMem(asm.RFP, -24, asm.R1, asm.DWord),
+	)
+	if length != nil {
+		insns = append(insns, length.load(asm.R1, asm.R6))
+	} else {
+		insns = append(insns, asm.Mov.Imm(asm.R1, 0))
+	}
+	insns = append(insns,
+		asm.StoreMem(asm.RFP, -16, asm.R1, asm.DWord),
+		mapPtr(asm.R1, "pending"),
+	)
+	insns = append(insns, stackPtr(asm.R2, -4)...)
+	insns = append(insns, stackPtr(asm.R3, -24)...)
+	insns = append(insns,
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateAny)),
+		asm.FnMapUpdateElem.Call(),
+	)
+	return append(insns, returnZero()...)
+}
+
+// syscallExitProgram sends an event when a syscall whose arguments were
+// saved fails with one of sc's errnos. The event has the path the saved
+// pointer points to, or the address, of the saved length, if hasLength.
+func syscallExitProgram(sc ebpfSyscall, ret tracepointField, hasLength bool) asm.Instructions {
+	// R6: the context, then the return value, then the event
+	// R7: the thread and process id; R8, R9: the saved arguments
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.Mov.Reg(asm.R7, asm.R0),
+		asm.StoreMem(asm.RFP, -4, asm.R7, asm.Word),
+		mapPtr(asm.R1, "pending"),
+	}
+	insns = append(insns, stackPtr(asm.R2, -4)...)
+	insns = append(insns,
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.LoadMem(asm.R8, asm.R0, 0, asm.DWord),
+		asm.LoadMem(asm.R9, asm.R0, 8, asm.DWord),
+		mapPtr(asm.R1, "pending"),
+	)
+	insns = append(insns, stackPtr(asm.R2, -4)...)
+	insns = append(insns,
+		asm.FnMapDeleteElem.Call(),
+		ret.load(asm.R6, asm.R6),
+	)
+	for _, errno := range sc.errnos {
+		insns = append(insns, asm.JEq.Imm(asm.R6, -int32(errno), "emit"))
+	}
+	insns = append(insns,
+		asm.Ja.Label("exit"),
+		asm.StoreImm(asm.RFP, -8, 0, asm.Word).WithSymbol("emit"),
+		mapPtr(asm.R1, "scratch"),
+	)
+	insns = append(insns, stackPtr(asm.R2, -8)...)
+	insns = append(insns,
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.StoreMem(asm.R0, ebpfEventRet, asm.R6, asm.Word),
+		asm.Mov.Reg(asm.R6, asm.R0),
+		asm.StoreMem(asm.R6, ebpfEventTID, asm.R7, asm.Word),
+		asm.RSh.Imm(asm.R7, 32),
+		asm.StoreMem(asm.R6, ebpfEventTGID, asm.R7, asm.Word),
+		asm.StoreImm(asm.R6, ebpfEventOp, int64(sc.op), asm.Word),
+		asm.Mov.Reg(asm.R1, asm.R6),
+		asm.Add.Imm(asm.R1, ebpfEventComm),
+		asm.Mov.Imm(asm.R2, ebpfEventCommSize),
+		asm.FnGetCurrentComm.Call(),
+	)
+	if hasLength {
+		insns = append(insns,
+			asm.Mov.Reg(asm.R2, asm.R9),
+			asm.JLE.Imm(asm.R2, ebpfEventDataSize, "read"),
+			asm.Mov.Imm(asm.R2, ebpfEventDataSize),
+			asm.StoreMem(asm.R6, ebpfEventDataLen, asm.R2, asm.Word).WithSymbol("read"),
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Add.Imm(asm.R1, ebpfEventData),
+			asm.Mov.Reg(asm.R3, asm.R8),
+			asm.FnProbeReadUser.Call(),
+		)
+	} else {
+		insns = append(insns,
+			asm.StoreImm(asm.R6, ebpfEventDataLen, 0, asm.Word),
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Add.Imm(asm.R1, ebpfEventData),
+			asm.Mov.Imm(asm.R2, ebpfEventDataSize),
+			asm.Mov.Reg(asm.R3, asm.R8),
+			asm.FnProbeReadUserStr.Call(),
+		)
+	}
+	insns = append(insns,
+		mapPtr(asm.R1, "events"),
+		asm.Mov.Reg(asm.R2, asm.R6),
+		asm.Mov.Imm(asm.R3, ebpfEventSize),
+		asm.Mov.Imm(asm.R4, 0),
+		asm.FnRingbufOutput.Call(),
+	)
+	return append(insns, returnZero()...)
+}
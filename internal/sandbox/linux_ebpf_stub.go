@@ -2,7 +2,10 @@
 
 package sandbox
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // EBPFMonitor is a stub for non-Linux platforms.
 type EBPFMonitor struct{}
@@ -13,7 +16,7 @@ func NewEBPFMonitor(pid int, debug bool) *EBPFMonitor {
 }
 
 // Start is a no-op on non-Linux platforms.
-func (m *EBPFMonitor) Start() error { return nil }
+func (m *EBPFMonitor) Start(_ context.Context) error { return nil }
 
 // Stop is a no-op on non-Linux platforms.
 func (m *EBPFMonitor) Stop() {}
@@ -24,15 +27,13 @@ func IsEBPFAvailable() bool { return false }
 // RequiredCapabilities returns empty on non-Linux platforms.
 func RequiredCapabilities() []string { return nil }
 
-// CheckBpftraceAvailable returns false on non-Linux platforms.
-func CheckBpftraceAvailable() bool { return false }
-
 // ViolationEvent is a stub for non-Linux platforms.
 type ViolationEvent struct {
 	Timestamp time.Time
 	Type      string
 	Operation string
 	Path      string
+	Addr      string
 	PID       int
 	Comm      string
 	Errno     int
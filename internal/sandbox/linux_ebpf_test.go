@@ -0,0 +1,141 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseTracepointFormat(t *testing.T) {
+	format := `name: sched_process_fork
+ID: 366
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:__data_loc char[] parent_comm;	offset:8;	size:4;	signed:0;
+	field:pid_t parent_pid;	offset:12;	size:4;	signed:1;
+	field:char child_comm[16];	offset:16;	size:16;	signed:0;
+	field:const char * filename;	offset:32;	size:8;	signed:0;
+
+print fmt: "comm=%s pid=%d", __get_str(parent_comm), REC->parent_pid
+`
+	fields, err := parseTracepointFormat(bufio.NewScanner(strings.NewReader(format)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]tracepointField{
+		"common_type": {0, 2},
+		"common_pid":  {4, 4},
+		"parent_comm": {8, 4},
+		"parent_pid":  {12, 4},
+		"child_comm":  {16, 16},
+		"filename":    {32, 8},
+	}
+	for name, f := range want {
+		if fields[name] != f {
+			t.Errorf("field %s = %+v, want %+v", name, fields[name], f)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("got %d fields, want %d: %v", len(fields), len(want), fields)
+	}
+
+	if _, err := parseTracepointFormat(bufio.NewScanner(strings.NewReader("name: nope\n"))); err == nil {
+		t.Error("parseTracepointFormat() of a format without fields should fail")
+	}
+}
+
+func TestFormatSockaddr(t *testing.T) {
+	family := func(f uint16, rest ...byte) []byte {
+		return append(binary.NativeEndian.AppendUint16(nil, f), rest...)
+	}
+	v6 := make([]byte, 24)
+	copy(v6, family(unix.AF_INET6, 0x01, 0xbb))
+	v6[23] = 1
+	tests := []struct {
+		sa   []byte
+		want string
+	}{
+		{family(unix.AF_INET, 0x01, 0xbb, 93, 184, 216, 34, 0, 0), "93.184.216.34:443"},
+		{v6, "[::1]:443"},
+		{family(unix.AF_UNIX, []byte("/run/docker.sock\x00junk")...), "/run/docker.sock"},
+		{family(unix.AF_UNIX, []byte("\x00abstract")...), "@abstract"},
+		{family(unix.AF_INET, 0x01), "address family 2"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := formatSockaddr(tt.sa); got != tt.want {
+			t.Errorf("formatSockaddr(%v) = %q, want %q", tt.sa, got, tt.want)
+		}
+	}
+}
+
+func TestEBPFEventViolation(t *testing.T) {
+	now := time.Now()
+	e := ebpfEvent{TID: 12, TGID: 10, Op: ebpfOpOpen, Ret: -int32(unix.EACCES)}
+	copy(e.Comm[:], "cat")
+	copy(e.Data[:], "/etc/shadow\x00")
+	v := e.violation(now)
+	if v == nil || v.Type != "file" || v.Operation != "open" || v.Path != "/etc/shadow" ||
+		v.PID != 10 || v.Comm != "cat" || v.Errno != int(unix.EACCES) {
+		t.Errorf("violation() = %+v", v)
+	}
+
+	e = ebpfEvent{TGID: 10, Op: ebpfOpConnect, Ret: -int32(unix.ENETUNREACH), DataLen: 16}
+	copy(e.Data[:], binary.NativeEndian.AppendUint16(nil, unix.AF_INET))
+	copy(e.Data[2:], []byte{0, 80, 10, 0, 0, 1})
+	if v := e.violation(now); v == nil || v.Type != "network" || v.Addr != "10.0.0.1:80" || v.Path != "" {
+		t.Errorf("violation() = %+v", v)
+	}
+
+	if v := (&ebpfEvent{Op: 99}).violation(now); v != nil {
+		t.Errorf("violation() of an unknown operation = %+v, want nil", v)
+	}
+}
+
+func TestEBPFMonitor(t *testing.T) {
+	if !DetectLinuxFeatures().HasEBPF {
+		t.Skip("needs CAP_BPF or root")
+	}
+	if err := EnsureTracingSetup(); err != nil {
+		t.Skip(err)
+	}
+
+	// Even root may not remove files from /proc. The monitor starts while
+	// the shell waits, and has to follow it to rm.
+	const denied = "/proc/version"
+	cmd := exec.Command("sh", "-c", "read _; rm -f "+denied+" 2>/dev/null; exit 0")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewEBPFMonitor(cmd.Process.Pid, false)
+	m.violations = policy.NewViolationLog(false)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_ = stdin.Close()
+	_ = cmd.Wait()
+	m.Stop()
+
+	for _, v := range m.violations.Violations() {
+		if v.Kind == policy.KindFilesystem && v.Target == denied {
+			return
+		}
+	}
+	t.Errorf("violations = %+v, want the unlink of %s", m.violations.Violations(), denied)
+}
@@ -0,0 +1,229 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+)
+
+// Without a network namespace of its own, the sandbox shares the host's
+// network, and nothing makes the command's traffic go through the proxies.
+// Where eBPF is available, the run cgroup then gets cgroup/connect4 and
+// connect6 programs, which fail the command's connections with EPERM unless
+// they go to the proxy bridges on the loopback, as the network namespace
+// would, and sendmsg4 and sendmsg6 programs that do the same for datagrams
+// sent without connecting. The allowed destinations are in LPM tries keyed by
+// the address followed by the port, so an entry either allows one port of one
+// address or, with a prefix no longer than the address, any port of a range.
+
+// Offsets in struct bpf_sock_addr, the context of the programs.
+const (
+	sockAddrUserIP4  = 4
+	sockAddrUserIP6  = 8
+	sockAddrUserPort = 24
+)
+
+// egressDestination is a destination the command may send to directly:
+// port of the addresses in prefix, or any port if it is 0.
+type egressDestination struct {
+	prefix netip.Prefix
+	port   uint16
+}
+
+func (d egressDestination) String() string {
+	if d.port == 0 {
+		return d.prefix.String()
+	}
+	return netip.AddrPortFrom(d.prefix.Addr(), d.port).String()
+}
+
+// egressDestinations returns the destinations the command may send to
+// directly under cfg: the proxy bridge's ports on the loopback, if proxy is
+// set, or the whole loopback if local outbound is allowed.
+func egressDestinations(cfg *config.Config, proxy bool) []egressDestination {
+	rules := landlockNetRules(cfg, proxy, false, nil)
+	if rules == nil || rules.ConnectPorts == nil {
+		return []egressDestination{
+			{prefix: netip.MustParsePrefix("127.0.0.0/8")},
+			{prefix: netip.MustParsePrefix("::1/128")},
+		}
+	}
+	var dests []egressDestination
+	for _, addr := range []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.IPv6Loopback()} {
+		for _, port := range rules.ConnectPorts {
+			dests = append(dests, egressDestination{prefix: netip.PrefixFrom(addr, addr.BitLen()), port: uint16(port)})
+		}
+	}
+	return dests
+}
+
+// egressKeys returns the keys of the LPM trie entries that allow d: one in
+// the IPv4 trie and one for the IPv4-mapped address in the IPv6 trie, or one
+// in the IPv6 trie.
+func egressKeys(d egressDestination) (v4, v6 []byte) {
+	key := func(addr []byte, bits int) []byte {
+		if d.port != 0 {
+			bits = 8*len(addr) + 16
+		}
+		k := binary.NativeEndian.AppendUint32(nil, uint32(bits))
+		k = append(k, addr...)
+		return binary.BigEndian.AppendUint16(k, d.port)
+	}
+	addr := d.prefix.Masked().Addr()
+	if addr.Is4() {
+		a4, a16 := addr.As4(), addr.As16() // As16 maps IPv4 addresses
+		return key(a4[:], d.prefix.Bits()), key(a16[:], 96+d.prefix.Bits())
+	}
+	a16 := addr.As16()
+	return nil, key(a16[:], d.prefix.Bits())
+}
+
+// egressPrograms are the cgroup hooks the destinations are checked at.
+var egressPrograms = []struct {
+	name   string
+	attach ebpf.AttachType
+	v6     bool
+}{
+	{"connect4", ebpf.AttachCGroupInet4Connect, false},
+	{"connect6", ebpf.AttachCGroupInet6Connect, true},
+	{"sendmsg4", ebpf.AttachCGroupUDP4Sendmsg, false},
+	{"sendmsg6", ebpf.AttachCGroupUDP6Sendmsg, true},
+}
+
+// egressSpec returns the programs and tries that limit the destinations.
+func egressSpec() *ebpf.CollectionSpec {
+	trie := func(addrLen uint32) *ebpf.MapSpec {
+		return &ebpf.MapSpec{
+			Type:       ebpf.LPMTrie,
+			KeySize:    4 + addrLen + 2,
+			ValueSize:  4,
+			MaxEntries: 64,
+			Flags:      1, // BPF_F_NO_PREALLOC, which LPM tries require
+		}
+	}
+	spec := &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			"allowed4": trie(4),
+			"allowed6": trie(16),
+		},
+		Programs: make(map[string]*ebpf.ProgramSpec),
+	}
+	for _, p := range egressPrograms {
+		spec.Programs[p.name] = &ebpf.ProgramSpec{
+			Name:         p.name,
+			Type:         ebpf.CGroupSockAddr,
+			AttachType:   p.attach,
+			License:      "Dual MIT/GPL",
+			Instructions: egressProgram(p.v6),
+		}
+	}
+	return spec
+}
+
+// egressProgram allows a destination found in the IPv4 trie, or the IPv6
+// trie if v6, and fails the others with EPERM.
+func egressProgram(v6 bool) asm.Instructions {
+	// The key, on the stack: the prefix length, the address, and the port
+	addrLen, trie := int16(4), "allowed4"
+	if v6 {
+		addrLen, trie = 16, "allowed6"
+	}
+	key := -(4 + addrLen + 2 + 2) // Rounded up to keep the prefix length aligned
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.StoreImm(asm.RFP, key, int64(8*addrLen+16), asm.Word),
+	}
+	if v6 {
+		for i := int16(0); i < 4; i++ {
+			insns = append(insns,
+				asm.LoadMem(asm.R1, asm.R6, sockAddrUserIP6+4*i, asm.Word),
+				asm.StoreMem(asm.RFP, key+4+4*i, asm.R1, asm.Word),
+			)
+		}
+	} else {
+		insns = append(insns,
+			asm.LoadMem(asm.R1, asm.R6, sockAddrUserIP4, asm.Word),
+			asm.StoreMem(asm.RFP, key+4, asm.R1, asm.Word),
+		)
+	}
+	insns = append(insns,
+		// Both are in network byte order, which the stores keep
+		asm.LoadMem(asm.R1, asm.R6, sockAddrUserPort, asm.Word),
+		asm.StoreMem(asm.RFP, key+4+addrLen, asm.R1, asm.Half),
+		mapPtr(asm.R1, trie),
+	)
+	insns = append(insns, stackPtr(asm.R2, int32(key))...)
+	return append(insns,
+		asm.FnMapLookupElem.Call(),
+		asm.JNE.Imm(asm.R0, 0, "allow"),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+		asm.Mov.Imm(asm.R0, 1).WithSymbol("allow"),
+		asm.Return(),
+	)
+}
+
+// egressFilter is the programs limiting the destinations of a run cgroup.
+type egressFilter struct {
+	collection   *ebpf.Collection
+	links        []link.Link
+	destinations []egressDestination
+}
+
+// restrictEgress attaches programs to c that limit the command's direct
+// connections to the destinations egressDestinations allows under cfg.
+func (c *runCgroup) restrictEgress(cfg *config.Config, proxy bool) error {
+	collection, err := ebpf.NewCollection(egressSpec())
+	if err != nil {
+		return fmt.Errorf("failed to load cgroup eBPF programs: %w", err)
+	}
+	f := &egressFilter{collection: collection, destinations: egressDestinations(cfg, proxy)}
+	for _, d := range f.destinations {
+		v4, v6 := egressKeys(d)
+		if v4 != nil {
+			if err := collection.Maps["allowed4"].Put(v4, uint32(1)); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to allow %s: %w", d, err)
+			}
+		}
+		if err := collection.Maps["allowed6"].Put(v6, uint32(1)); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to allow %s: %w", d, err)
+		}
+	}
+	for _, p := range egressPrograms {
+		l, err := link.AttachCgroup(link.CgroupOptions{Path: c.path, Attach: p.attach, Program: collection.Programs[p.name]})
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to attach cgroup/%s: %w", p.name, err)
+		}
+		f.links = append(f.links, l)
+	}
+	c.egress = f
+	return nil
+}
+
+// String describes the destinations allowed, for the cgroup's limits.
+func (f *egressFilter) String() string {
+	dests := make([]string, len(f.destinations))
+	for i, d := range f.destinations {
+		dests[i] = d.String()
+	}
+	return "cgroup/connect4,connect6,sendmsg4,sendmsg6 allow " + strings.Join(dests, " ")
+}
+
+// Close detaches and unloads the programs.
+func (f *egressFilter) Close() {
+	for _, l := range f.links {
+		_ = l.Close()
+	}
+	f.collection.Close()
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestEgressDestinations(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name    string
+		network config.NetworkConfig
+		proxy   bool
+		want    []string
+	}{
+		{
+			name:  "proxies only",
+			proxy: true,
+			want:  []string{"127.0.0.1:3128", "127.0.0.1:1080", "[::1]:3128", "[::1]:1080"},
+		},
+		{
+			name: "no proxies",
+		},
+		{
+			name:    "local outbound allowed",
+			network: config.NetworkConfig{AllowLocalOutbound: &yes},
+			proxy:   true,
+			want:    []string{"127.0.0.0/8", "::1/128"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range egressDestinations(&config.Config{Network: tt.network}, tt.proxy) {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("egressDestinations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEgressKeys(t *testing.T) {
+	dests := egressDestinations(&config.Config{}, true)
+	v4, v6 := egressKeys(dests[0])
+	if want := []byte{48, 0, 0, 0, 127, 0, 0, 1, 0x0c, 0x38}; !bytes.Equal(v4[4:], want[4:]) || len(v4) != len(want) {
+		t.Errorf("IPv4 key = %v, want %v", v4, want)
+	}
+	mapped := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 127, 0, 0, 1, 0x0c, 0x38}
+	if !bytes.Equal(v6[4:], mapped) {
+		t.Errorf("IPv6 key of 127.0.0.1 = %v, want the mapped address %v", v6, mapped)
+	}
+
+	loopback := egressDestinations(&config.Config{Network: config.NetworkConfig{AllowLocalBinding: true}}, true)[0]
+	v4, v6 = egressKeys(loopback)
+	if len(v4) != 10 || len(v6) != 22 {
+		t.Errorf("key lengths = %d, %d, want the tries' 10 and 22", len(v4), len(v6))
+	}
+}
+
+func TestRestrictEgress(t *testing.T) {
+	if !DetectLinuxFeatures().HasEBPF {
+		t.Skip("needs CAP_BPF or root")
+	}
+	yes := true
+	cfg := &config.Config{Network: config.NetworkConfig{AllowLocalOutbound: &yes}}
+	c, err := newRunCgroup(cfg)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func() { _ = c.Shutdown(context.Background()) }()
+	if err := c.restrictEgress(cfg, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connecting a UDP socket sends nothing, so neither needs a listener
+	script := `echo $$ > "$1" || exit 1
+exec 3<>/dev/udp/127.0.0.1/9 && echo loopback allowed
+exec 4<>/dev/udp/192.0.2.1/9 && echo other allowed`
+	out, _ := exec.Command("bash", "-c", script, "bash", filepath.Join(c.path, "cgroup.procs")).CombinedOutput()
+	if !strings.Contains(string(out), "loopback allowed") {
+		t.Errorf("connecting to the loopback failed:\n%s", out)
+	}
+	if strings.Contains(string(out), "other allowed") || !strings.Contains(string(out), "Operation not permitted") {
+		t.Errorf("connecting to 192.0.2.1 was not refused:\n%s", out)
+	}
+}
@@ -0,0 +1,496 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// The exec guard enforces the command policy on every execve in the
+// sandbox, not only on the command string fence was given: a seccomp filter
+// hands each execve and execveat to a supervisor (SECCOMP_RET_USER_NOTIF),
+// which reads the program and arguments from the caller's memory and
+// either lets the call continue or fails it with EPERM.
+//
+// The supervisor checks the arguments before the kernel reads them, so a
+// multithreaded program could change them in between. The guard catches
+// denied commands run from scripts and programs, but is not a boundary
+// against code written to get around it.
+
+// execSyscalls are the exec syscalls of an architecture.
+type execSyscalls struct {
+	arch   uint32
+	execve uint32
+	// execveat is 0 where it does not exist
+	execveat uint32
+	// deny are other exec syscalls, which fail: those of compat ABIs, whose
+	// argument layout the supervisor does not read
+	deny []uint32
+}
+
+// execGuardArches returns the exec syscalls for GOARCH: the native ones,
+// which go to the supervisor, then those of the compat ABIs.
+func execGuardArches(goarch string) ([]execSyscalls, error) {
+	switch goarch {
+	case "amd64":
+		const x32 = 0x40000000 // x32 syscalls run with the x86-64 arch
+		return []execSyscalls{
+			{arch: unix.AUDIT_ARCH_X86_64, execve: 59, execveat: 322, deny: []uint32{x32 | 520, x32 | 545}},
+			{arch: unix.AUDIT_ARCH_I386, deny: []uint32{11, 358}},
+		}, nil
+	case "arm64":
+		return []execSyscalls{
+			{arch: unix.AUDIT_ARCH_AARCH64, execve: 221, execveat: 281},
+			{arch: unix.AUDIT_ARCH_ARM, deny: []uint32{11, 387}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("exec guard is not supported on %s", goarch)
+	}
+}
+
+// Offsets in struct seccomp_data
+const (
+	seccompDataNR   = 0
+	seccompDataArch = 4
+)
+
+// execGuardFilter builds the seccomp filter that sends the native exec
+// syscalls to the supervisor and fails the compat ones with EPERM. The
+// other syscalls go on to next, or are allowed if next is empty.
+func execGuardFilter(arches []execSyscalls, next []unix.SockFilter) []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter { return unix.SockFilter{Code: code, K: k} }
+
+	// Jumps to the two returns and to next, at the end, are patched once
+	// the program is complete
+	const (
+		toNotify = iota + 1
+		toDeny
+		toNext
+	)
+	var prog []unix.SockFilter
+	var targets []int // Target of each instruction, 0 for none
+	add := func(inst unix.SockFilter, target int) {
+		prog = append(prog, inst)
+		targets = append(targets, target)
+	}
+
+	add(stmt(BPF_LD|BPF_W|BPF_ABS, seccompDataArch), 0)
+	for _, a := range arches {
+		var body []unix.SockFilter
+		var bodyTargets []int
+		body = append(body, stmt(BPF_LD|BPF_W|BPF_ABS, seccompDataNR))
+		bodyTargets = append(bodyTargets, 0)
+		for _, nr := range []uint32{a.execve, a.execveat} {
+			if nr != 0 {
+				body = append(body, stmt(BPF_JMP|BPF_JEQ|BPF_K, nr))
+				bodyTargets = append(bodyTargets, toNotify)
+			}
+		}
+		for _, nr := range a.deny {
+			body = append(body, stmt(BPF_JMP|BPF_JEQ|BPF_K, nr))
+			bodyTargets = append(bodyTargets, toDeny)
+		}
+		body = append(body, stmt(BPF_JMP|BPF_JA, 0))
+		bodyTargets = append(bodyTargets, toNext)
+
+		// Skip the body unless the arch matches
+		jump := stmt(BPF_JMP|BPF_JEQ|BPF_K, a.arch)
+		jump.Jf = uint8(len(body)) //nolint:gosec // bodies are a few instructions
+		add(jump, 0)
+		for i := range body {
+			add(body[i], bodyTargets[i])
+		}
+	}
+	add(stmt(BPF_JMP|BPF_JA, 0), toNext) // Other arches
+	notify := len(prog)
+	add(stmt(BPF_RET|BPF_K, unix.SECCOMP_RET_USER_NOTIF), 0)
+	deny := len(prog)
+	add(stmt(BPF_RET|BPF_K, SECCOMP_RET_ERRNO|uint32(unix.EPERM)), 0)
+	rest := len(prog)
+
+	for i, target := range targets {
+		switch target {
+		case toNotify:
+			prog[i].Jt = uint8(notify - i - 1) //nolint:gosec // the program is short
+		case toDeny:
+			prog[i].Jt = uint8(deny - i - 1) //nolint:gosec // the program is short
+		case toNext:
+			prog[i].K = uint32(rest - i - 1) //nolint:gosec // the program is short
+		}
+	}
+	if len(next) == 0 {
+		return append(prog, stmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW))
+	}
+	return append(prog, next...)
+}
+
+// guardFilter builds the filter the exec guard's child installs: the exec
+// checks if cfg enforces command.enforceExec, then, with notify, the
+// seccomp policy's syscalls, which are sent to the supervisor too.
+func guardFilter(cfg *config.Config, notify bool) ([]unix.SockFilter, error) {
+	var next []unix.SockFilter
+	if notify {
+		arches, err := seccompArches(runtime.GOARCH)
+		if err != nil {
+			return nil, err
+		}
+		if next, err = seccompFilter(arches, newSeccompPolicy(cfg.Seccomp)); err != nil {
+			return nil, err
+		}
+	}
+	if !cfg.Command.UseExecEnforcement() {
+		if len(next) == 0 {
+			return nil, errors.New("nothing to supervise")
+		}
+		return next, nil
+	}
+	arches, err := execGuardArches(runtime.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	return execGuardFilter(arches, next), nil
+}
+
+// RunExecGuardChild is fence --exec-guard: it reads the filter the
+// supervisor sends on fd 3, installs it, passes the listener back, and
+// executes the command, whose exec is the first one checked.
+func RunExecGuardChild(args []string) int {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		logging.Errorf("exec-guard", "no command specified")
+		return 1
+	}
+	path, argv := args[0], args[1:]
+
+	sock := os.NewFile(3, "exec-guard")
+	data, err := io.ReadAll(sock)
+	if err != nil {
+		logging.Errorf("exec-guard", "failed to read seccomp filter: %v", err)
+		return 1
+	}
+	filter, err := decodeSeccompFilter(data)
+	if err != nil {
+		logging.Errorf("exec-guard", "%v", err)
+		return 1
+	}
+
+	// The filter applies to this thread, which then executes the command
+	runtime.LockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		logging.Errorf("exec-guard", "failed to set no_new_privs: %v", err)
+		return 1
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]} //nolint:gosec // decodeSeccompFilter limits the length
+	listener, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_NEW_LISTENER, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		logging.Errorf("exec-guard", "failed to install seccomp filter (needs Linux 5.5 or later): %v", errno)
+		return 1
+	}
+	if err := unix.Sendmsg(int(sock.Fd()), []byte{0}, unix.UnixRights(int(listener)), nil, 0); err != nil {
+		logging.Errorf("exec-guard", "failed to pass seccomp listener: %v", err)
+		return 1
+	}
+	_ = unix.Close(int(listener))
+	_ = sock.Close()
+
+	err = syscall.Exec(path, argv, os.Environ()) //nolint:gosec // the command is the sandboxed command
+
+	// If the command was blocked, the supervisor has said why
+	if !errors.Is(err, syscall.EPERM) {
+		logging.Errorf("exec-guard", "exec failed: %v", err)
+	}
+	return 126
+}
+
+// RunWithExecGuard runs the command at path with argv and env, checking it
+// and every program it executes against cfg's command policy if
+// command.enforceExec is set, and returns its exit code. With notify, the
+// syscalls cfg's seccomp policy blocks are sent to the supervisor too,
+// which reports them, and asks about them for seccomp.action ask. In audit
+// mode denied programs and syscalls still run, and are only logged.
+func RunWithExecGuard(cfg *config.Config, path string, argv, env []string, notify, audit, debug bool) int {
+	filter, err := guardFilter(cfg, notify)
+	if err != nil {
+		logging.Errorf("exec-guard", "%v", err)
+		return 1
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		logging.Errorf("exec-guard", "failed to create socket pair: %v", err)
+		return 1
+	}
+	ours := os.NewFile(uintptr(fds[0]), "exec-guard")
+	theirs := os.NewFile(uintptr(fds[1]), "exec-guard-child")
+	defer func() { _ = ours.Close() }()
+
+	args := append([]string{ExecGuardFlag, "--", path}, argv...)
+	cmd := exec.Command("/proc/self/exe", args...) //nolint:gosec // re-executes fence itself
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = []*os.File{theirs}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	err = cmd.Start()
+	_ = theirs.Close()
+	if err != nil {
+		logging.Errorf("exec-guard", "failed to start %s: %v", path, err)
+		return 127
+	}
+	go func() {
+		for sig := range sigChan {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	// The child fails and exits without sending a listener if it cannot
+	// read or install the filter
+	if err := binary.Write(ours, binary.NativeEndian, filter); err == nil {
+		_ = unix.Shutdown(int(ours.Fd()), unix.SHUT_WR)
+	}
+	if listener, err := receiveFD(int(ours.Fd())); err == nil {
+		g := &execGuard{
+			cfg:      cfg,
+			listener: listener,
+			exec:     cfg.Command.UseExecEnforcement(),
+			ask:      notify && cfg.Seccomp.Action == config.SeccompActionAsk,
+			audit:    audit,
+			debug:    debug,
+		}
+		go g.serve()
+	}
+	return exitCode(cmd.Wait())
+}
+
+// receiveFD receives a file descriptor sent with SCM_RIGHTS on sock.
+func receiveFD(sock int) (int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(sock, buf, oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, errors.New("no file descriptor received")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return -1, errors.New("no file descriptor received")
+	}
+	return fds[0], nil
+}
+
+// struct seccomp_notif and struct seccomp_notif_resp
+type (
+	seccompNotif struct {
+		ID    uint64
+		PID   uint32
+		Flags uint32
+		Data  struct {
+			NR   int32
+			Arch uint32
+			IP   uint64
+			Args [6]uint64
+		}
+	}
+	seccompNotifResp struct {
+		ID    uint64
+		Val   int64
+		Error int32
+		Flags uint32
+	}
+)
+
+// execGuard is the supervisor side of the exec guard.
+type execGuard struct {
+	cfg      *config.Config
+	listener int
+	exec     bool // Execs are checked against the command policy
+	ask      bool // Blocked syscalls are asked about on the terminal
+	audit    bool
+	debug    bool
+	// allowed are the syscalls the user chose to always allow when asked
+	allowed map[string]bool
+}
+
+// serve answers the filter's notifications until the listener fails,
+// which it does once no process uses the filter.
+func (g *execGuard) serve() {
+	var native *execSyscalls
+	if g.exec {
+		arches, _ := execGuardArches(runtime.GOARCH)
+		native = &arches[0]
+	}
+	for {
+		var req seccompNotif
+		if err := ioctl(g.listener, unix.SECCOMP_IOCTL_NOTIF_RECV, unsafe.Pointer(&req)); err != nil {
+			if errors.Is(err, unix.EINTR) || errors.Is(err, unix.ENOENT) {
+				continue // Interrupted, or the caller died before it was received
+			}
+			return
+		}
+		var resp seccompNotifResp
+		if native != nil && native.isExec(&req) {
+			resp = g.decide(&req, *native)
+		} else {
+			resp = g.decideSyscall(&req)
+		}
+		if err := ioctl(g.listener, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp)); err != nil && !errors.Is(err, unix.ENOENT) {
+			return
+		}
+	}
+}
+
+// isExec reports whether req is for one of the native exec syscalls.
+func (a execSyscalls) isExec(req *seccompNotif) bool {
+	nr := uint32(req.Data.NR) //nolint:gosec // syscall numbers are small
+	return req.Data.Arch == a.arch && (nr == a.execve || (a.execveat != 0 && nr == a.execveat))
+}
+
+// decide answers one notification: the call continues if the program is
+// allowed, and fails with EPERM if not, or if it cannot be read.
+func (g *execGuard) decide(req *seccompNotif, native execSyscalls) seccompNotifResp {
+	allow := seccompNotifResp{ID: req.ID, Flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}
+	deny := seccompNotifResp{ID: req.ID, Error: -int32(unix.EPERM)}
+
+	path, argv, err := readExecArgs(req, native)
+	// The pid may have been reused if the caller died meanwhile
+	if ioctl(g.listener, unix.SECCOMP_IOCTL_NOTIF_ID_VALID, unsafe.Pointer(&req.ID)) != nil {
+		return deny
+	}
+	if err != nil {
+		logging.Warnf("exec", "blocked an exec by pid %d: cannot read its arguments: %v", req.PID, err)
+		return deny
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return allow // The exec fails anyway, e.g. when a shell searches PATH
+	}
+
+	command, err := checkExec(g.cfg, path, argv)
+	switch {
+	case err == nil:
+		if g.debug {
+			logging.Debugf("exec", "allowed: %s", command)
+		}
+		return allow
+	case g.audit:
+		logging.Warnf("exec", "would block: %v", err)
+		return allow
+	default:
+		logging.Warnf("exec", "blocked: %v", err)
+		return deny
+	}
+}
+
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Limits on what readExecArgs reads
+const (
+	maxExecArgs   = 4096
+	maxExecArgLen = 128 * 1024 // MAX_ARG_STRLEN
+)
+
+// readExecArgs reads the program path and arguments of an execve or
+// execveat from the caller's memory. The path is made absolute.
+func readExecArgs(req *seccompNotif, native execSyscalls) (path string, argv []string, err error) {
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", req.PID))
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = mem.Close() }()
+
+	args := req.Data.Args
+	pathAddr, argvAddr := args[0], args[1]
+	execveat := uint32(req.Data.NR) == native.execveat //nolint:gosec // syscall numbers are small
+	if execveat {
+		pathAddr, argvAddr = args[1], args[2]
+	}
+	if path, err = readCString(mem, pathAddr); err != nil {
+		return "", nil, err
+	}
+	if !filepath.IsAbs(path) {
+		// Relative to the working directory, or to the directory fd of
+		// execveat, which is the program itself for fexecve
+		dir := fmt.Sprintf("/proc/%d/cwd", req.PID)
+		if dirfd := int32(args[0]); execveat && dirfd != unix.AT_FDCWD { //nolint:gosec // fds are ints
+			dir = fmt.Sprintf("/proc/%d/fd/%d", req.PID, dirfd)
+		}
+		if dir, err = os.Readlink(dir); err != nil {
+			return "", nil, err
+		}
+		path = filepath.Join(dir, path)
+	}
+	argv, err = readCStringArray(mem, argvAddr)
+	return path, argv, err
+}
+
+// readCStringArray reads a NULL-terminated array of C strings at addr.
+func readCStringArray(mem *os.File, addr uint64) ([]string, error) {
+	var strs []string
+	ptr := make([]byte, 8)
+	for i := range maxExecArgs {
+		if _, err := mem.ReadAt(ptr, int64(addr)+int64(i)*8); err != nil { //nolint:gosec // user addresses fit in int64
+			return nil, err
+		}
+		p := binary.LittleEndian.Uint64(ptr)
+		if p == 0 {
+			break
+		}
+		s, err := readCString(mem, p)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// readCString reads the NUL-terminated string at addr.
+func readCString(mem *os.File, addr uint64) (string, error) {
+	if addr == 0 {
+		return "", nil
+	}
+	var s []byte
+	chunk := make([]byte, 256)
+	for len(s) < maxExecArgLen {
+		// Read up to the page boundary, since the next page may be unmapped
+		n := min(len(chunk), 4096-int((addr+uint64(len(s)))%4096))
+		got, err := mem.ReadAt(chunk[:n], int64(addr)+int64(len(s))) //nolint:gosec // user addresses fit in int64
+		if got == 0 && err != nil {
+			return "", err
+		}
+		for i, c := range chunk[:got] {
+			if c == 0 {
+				return string(append(s, chunk[:i]...)), nil
+			}
+		}
+		s = append(s, chunk[:got]...)
+	}
+	return "", fmt.Errorf("argument is longer than %d bytes", maxExecArgLen)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// RunExecGuardChild is not available on non-Linux platforms.
+func RunExecGuardChild(args []string) int {
+	logging.Errorf("exec-guard", "the exec guard is only available on Linux")
+	return 1
+}
+
+// RunWithExecGuard is not available on non-Linux platforms.
+func RunWithExecGuard(cfg *config.Config, path string, argv, env []string, notify, audit, debug bool) int {
+	logging.Errorf("exec-guard", "the exec guard is only available on Linux")
+	return 1
+}
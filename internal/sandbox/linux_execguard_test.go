@@ -0,0 +1,153 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// runSeccompFilter evaluates the instructions execGuardFilter and
+// seccompFilter use for one syscall, whose first argument is arg0 (as the
+// low word of a little-endian argument).
+func runSeccompFilter(t *testing.T, prog []unix.SockFilter, arch, nr uint32, arg0 ...uint32) uint32 {
+	t.Helper()
+	data := map[uint32]uint32{seccompDataNR: nr, seccompDataArch: arch}
+	if len(arg0) > 0 {
+		data[seccompDataArgs] = arg0[0]
+	}
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		inst := prog[pc]
+		switch inst.Code {
+		case BPF_LD | BPF_W | BPF_ABS:
+			acc = data[inst.K]
+		case BPF_JMP | BPF_JA:
+			pc += int(inst.K)
+		case BPF_JMP | BPF_JEQ | BPF_K:
+			if acc == inst.K {
+				pc += int(inst.Jt)
+			} else {
+				pc += int(inst.Jf)
+			}
+		case BPF_JMP | BPF_JGE | BPF_K:
+			if acc >= inst.K {
+				pc += int(inst.Jt)
+			} else {
+				pc += int(inst.Jf)
+			}
+		case BPF_RET | BPF_K:
+			return inst.K
+		default:
+			t.Fatalf("unexpected instruction %+v", inst)
+		}
+	}
+	t.Fatal("filter does not return")
+	return 0
+}
+
+func TestExecGuardFilter(t *testing.T) {
+	const eperm = SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	for _, goarch := range []string{"amd64", "arm64"} {
+		arches, err := execGuardArches(goarch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog := execGuardFilter(arches, nil)
+		native, compat := arches[0], arches[1]
+
+		tests := []struct {
+			arch, nr, want uint32
+		}{
+			{native.arch, native.execve, unix.SECCOMP_RET_USER_NOTIF},
+			{native.arch, native.execveat, unix.SECCOMP_RET_USER_NOTIF},
+			{native.arch, 1, SECCOMP_RET_ALLOW},
+			{compat.arch, compat.deny[0], eperm},
+			{compat.arch, compat.deny[1], eperm},
+			{compat.arch, native.execve, SECCOMP_RET_ALLOW},
+			{0x1234, native.execve, SECCOMP_RET_ALLOW},
+		}
+		for _, tt := range tests {
+			if got := runSeccompFilter(t, prog, tt.arch, tt.nr); got != tt.want {
+				t.Errorf("%s: arch %#x syscall %#x: got %#x, want %#x", goarch, tt.arch, tt.nr, got, tt.want)
+			}
+		}
+	}
+	if _, err := execGuardArches("mips"); err == nil {
+		t.Error("execGuardArches(mips) succeeded, want an error")
+	}
+}
+
+func TestGuardFilter(t *testing.T) {
+	const eperm = SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	yes := true
+	arches, err := execGuardArches(runtime.GOARCH)
+	if err != nil {
+		t.Skip(err)
+	}
+	native := arches[0]
+	ptrace, ok := syscallNumbers[runtime.GOARCH]["ptrace"]
+	if !ok {
+		t.Skipf("no ptrace on %s", runtime.GOARCH)
+	}
+
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		notify bool
+		nr     uint32
+		want   uint32
+	}{
+		{"exec checked", config.Config{Command: config.CommandConfig{EnforceExec: &yes}}, false, native.execve, unix.SECCOMP_RET_USER_NOTIF},
+		{"exec guard only", config.Config{Command: config.CommandConfig{EnforceExec: &yes}}, false, ptrace, SECCOMP_RET_ALLOW},
+		{"both", config.Config{Command: config.CommandConfig{EnforceExec: &yes}, Seccomp: config.SeccompConfig{Action: config.SeccompActionNotify}}, true, ptrace, unix.SECCOMP_RET_USER_NOTIF},
+		{"notify only", config.Config{Seccomp: config.SeccompConfig{Action: config.SeccompActionAsk}}, true, ptrace, unix.SECCOMP_RET_USER_NOTIF},
+		{"notify allows exec", config.Config{Seccomp: config.SeccompConfig{Action: config.SeccompActionNotify}}, true, native.execve, SECCOMP_RET_ALLOW},
+		{"blocking policy", config.Config{}, true, ptrace, eperm},
+	}
+	for _, tt := range tests {
+		prog, err := guardFilter(&tt.cfg, tt.notify)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := runSeccompFilter(t, prog, native.arch, tt.nr); got != tt.want {
+			t.Errorf("%s: syscall %d: got %#x, want %#x", tt.name, tt.nr, got, tt.want)
+		}
+	}
+	if _, err := guardFilter(&config.Config{}, false); err == nil {
+		t.Error("guardFilter() with nothing to supervise succeeded, want an error")
+	}
+}
+
+func TestReadCStringArray(t *testing.T) {
+	mem, err := os.Open("/proc/self/mem")
+	if err != nil {
+		t.Skipf("cannot read own memory: %v", err)
+	}
+	defer func() { _ = mem.Close() }()
+
+	// The message spans pages
+	want := []string{"git", "commit", "-m", strings.Repeat("a long message ", 1000)}
+	strs := make([][]byte, len(want))
+	ptrs := make([]uintptr, len(want)+1)
+	for i, s := range want {
+		strs[i] = append([]byte(s), 0)
+		ptrs[i] = uintptr(unsafe.Pointer(&strs[i][0]))
+	}
+
+	got, err := readCStringArray(mem, uint64(uintptr(unsafe.Pointer(&ptrs[0]))))
+	runtime.KeepAlive(strs)
+	if err != nil {
+		t.Fatalf("readCStringArray() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCStringArray() = %q, want %q", got, want)
+	}
+}
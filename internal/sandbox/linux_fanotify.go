@@ -0,0 +1,289 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/output"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"golang.org/x/sys/unix"
+)
+
+// Without eBPF, the fanotify monitor reports reads of denyRead paths. An
+// access the sandbox denies fails before fanotify sees it, so the monitor
+// watches what the sandbox gets in place of those paths instead: with it,
+// each is masked with an empty file or directory of its own rather than
+// /dev/null or a tmpfs, and opening a mask is an attempt to read the path it
+// masks. Since Linux 5.13, fanotify lets unprivileged users watch files they
+// own, in a limited mode that identifies files by handle and does not report
+// the process unless fence runs as root. Writes outside allowWrite fail with
+// EROFS before any file is opened, so they are not reported.
+
+// fanotifyFlags are the fanotify_init flags of the monitor's group, the
+// ones unprivileged users may use.
+const fanotifyFlags = unix.FAN_CLASS_NOTIF | unix.FAN_REPORT_FID | unix.FAN_CLOEXEC | unix.FAN_NONBLOCK
+
+// readMasks are the files and directories denyRead paths are masked with
+// for the fanotify monitor. They are removed at Shutdown.
+type readMasks struct {
+	mu       sync.Mutex
+	dir      string
+	masks    map[string]string // Mask of each path masked
+	paths    map[string]string // Path each mask masks
+	watchers []func(mask, path string, dir bool)
+}
+
+func newReadMasks() *readMasks {
+	return &readMasks{masks: make(map[string]string), paths: make(map[string]string)}
+}
+
+// mask returns the mask for path, a directory if dir is set, creating it
+// the first time.
+func (r *readMasks) mask(path string, dir bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if mask, ok := r.masks[path]; ok {
+		return mask, nil
+	}
+	if r.dir == "" {
+		d, err := os.MkdirTemp("", "fence-masks-")
+		if err != nil {
+			return "", err
+		}
+		r.dir = d
+	}
+	mask := filepath.Join(r.dir, strconv.Itoa(len(r.masks)))
+	var err error
+	if dir {
+		err = os.Mkdir(mask, 0o755) // Writable in the sandbox, like the tmpfs it replaces
+	} else {
+		err = os.WriteFile(mask, nil, 0o444)
+	}
+	if err != nil {
+		return "", err
+	}
+	r.masks[path] = mask
+	r.paths[mask] = path
+	for _, watch := range r.watchers {
+		watch(mask, path, dir)
+	}
+	return mask, nil
+}
+
+// watch calls fn with each mask, and then with those created until the
+// returned function is called.
+func (r *readMasks) watch(fn func(mask, path string, dir bool)) (stop func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for mask, path := range r.paths {
+		info, err := os.Stat(mask)
+		if err == nil {
+			fn(mask, path, info.IsDir())
+		}
+	}
+	id := len(r.watchers)
+	r.watchers = append(r.watchers, fn)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.watchers[id] = func(string, string, bool) {}
+	}
+}
+
+// Shutdown removes the masks. Sandboxes still running keep theirs.
+func (r *readMasks) Shutdown(_ context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(r.dir)
+	r.dir = ""
+	clear(r.masks)
+	clear(r.paths)
+	return err
+}
+
+// FanotifyMonitor reports the sandbox's reads of denyRead paths by watching
+// their masks with fanotify, where eBPF is not available.
+type FanotifyMonitor struct {
+	masks      *readMasks
+	debug      bool
+	violations *policy.ViolationLog
+
+	fd        int
+	file      *os.File // The group, for reading its events
+	stopWatch func()
+	mu        sync.Mutex
+	handles   map[string]string // Path masked, by the handle of its mask
+	done      chan struct{}     // Closed when the events are read
+}
+
+// newFanotifyMonitor creates a monitor for the masks in masks.
+func newFanotifyMonitor(masks *readMasks, debug bool) *FanotifyMonitor {
+	return &FanotifyMonitor{masks: masks, debug: debug, handles: make(map[string]string)}
+}
+
+// Start begins watching the masks, including those created later.
+// Monitoring stops when ctx is canceled or Stop is called.
+func (m *FanotifyMonitor) Start(ctx context.Context) error {
+	fd, err := unix.FanotifyInit(fanotifyFlags, unix.O_RDONLY|unix.O_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("fanotify_init: %w", err)
+	}
+	m.fd, m.file = fd, os.NewFile(uintptr(fd), "fanotify")
+	m.stopWatch = m.masks.watch(m.mark)
+	m.done = make(chan struct{})
+	context.AfterFunc(ctx, func() { _ = m.file.Close() })
+	go m.read()
+
+	if m.debug {
+		m.mu.Lock()
+		logging.Debugf("fanotify", "Started fanotify monitoring of %d denyRead masks", len(m.handles))
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop stops the monitor.
+func (m *FanotifyMonitor) Stop() {
+	if m.file == nil {
+		return
+	}
+
+	// Give a moment for pending events
+	time.Sleep(200 * time.Millisecond)
+
+	m.stopWatch()
+	_ = m.file.Close()
+	<-m.done
+	policy.MonitorOutput.Flush()
+	m.file = nil
+}
+
+// mark watches mask for opens, which are reads of path.
+func (m *FanotifyMonitor) mark(mask, path string, dir bool) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, mask, 0)
+	if err != nil {
+		if m.debug {
+			logging.Debugf("fanotify", "Failed to get the handle of %s: %v", mask, err)
+		}
+		return
+	}
+	events := uint64(unix.FAN_OPEN)
+	if dir {
+		events |= unix.FAN_ONDIR
+	}
+	if err := unix.FanotifyMark(m.fd, unix.FAN_MARK_ADD, events, unix.AT_FDCWD, mask); err != nil {
+		if m.debug {
+			logging.Debugf("fanotify", "Failed to watch the mask of %s: %v", path, err)
+		}
+		return
+	}
+	m.mu.Lock()
+	m.handles[fileHandleKey(handle.Type(), handle.Bytes())] = path
+	m.mu.Unlock()
+}
+
+// read reports the events until the group is closed.
+func (m *FanotifyMonitor) read() {
+	defer close(m.done)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := m.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && m.debug {
+				logging.Debugf("fanotify", "Failed to read events: %v", err)
+			}
+			return
+		}
+		parseFanotifyEvents(buf[:n], func(pid int32, handle string) {
+			m.mu.Lock()
+			path, ok := m.handles[handle]
+			m.mu.Unlock()
+			if ok {
+				m.report(path, int(pid))
+			}
+		})
+	}
+}
+
+// report prints a read of path by pid, 0 if unknown, and adds it to the
+// violation log, if one is set.
+func (m *FanotifyMonitor) report(path string, pid int) {
+	comm := ""
+	if pid > 0 {
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+			comm = strings.TrimSpace(string(data))
+		}
+	}
+	process := ""
+	if comm != "" {
+		process = fmt.Sprintf(", %s:%d", comm, pid)
+	}
+	line := fmt.Sprintf("[fence:fanotify] %s %s read: %s (denyRead, masked%s)",
+		time.Now().Format("15:04:05"), output.Render(output.Blocked), path, process)
+	policy.MonitorOutput.Print("read", "fanotify read "+path+" "+comm, line)
+
+	if m.violations == nil {
+		return
+	}
+	m.violations.Record(policy.Event{
+		Source:   policy.SourceFanotify,
+		Kind:     policy.KindFilesystem,
+		Target:   path,
+		Decision: policy.Deny("", "sandbox masked the path (filesystem.denyRead)"),
+		Process:  comm,
+	})
+}
+
+// parseFanotifyEvents calls fn with the pid and the file handle, as
+// fileHandleKey returns it, of each event in buf.
+func parseFanotifyEvents(buf []byte, fn func(pid int32, handle string)) {
+	const metadataLen = int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	for len(buf) >= metadataLen {
+		meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[0]))
+		eventLen := int(meta.Event_len)
+		if eventLen < metadataLen || eventLen > len(buf) {
+			return
+		}
+		// The records after the metadata: a header with the type and
+		// length, the filesystem ID, and the file handle
+		info := buf[meta.Metadata_len:eventLen]
+		for len(info) >= 4 {
+			infoType, infoLen := info[0], int(binary.NativeEndian.Uint16(info[2:]))
+			if infoLen < 4 || infoLen > len(info) {
+				break
+			}
+			if infoType == unix.FAN_EVENT_INFO_TYPE_FID && infoLen >= 4+8+8 {
+				handle := info[4+8 : infoLen]
+				size := int(binary.NativeEndian.Uint32(handle))
+				if 8+size <= len(handle) {
+					fn(meta.Pid, fileHandleKey(int32(binary.NativeEndian.Uint32(handle[4:])), handle[8:8+size]))
+				}
+			}
+			info = info[infoLen:]
+		}
+		buf = buf[eventLen:]
+	}
+}
+
+// fileHandleKey identifies a file by its handle type and bytes.
+func fileHandleKey(handleType int32, handle []byte) string {
+	return strconv.Itoa(int(handleType)) + ":" + string(handle)
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"os/exec"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseFanotifyEvents(t *testing.T) {
+	event := func(pid int32, handleType int32, handle []byte) []byte {
+		info := []byte{unix.FAN_EVENT_INFO_TYPE_FID, 0, 0, 0}
+		info = append(info, make([]byte, 8)...) // fsid
+		info = binary.NativeEndian.AppendUint32(info, uint32(len(handle)))
+		info = binary.NativeEndian.AppendUint32(info, uint32(handleType))
+		info = append(info, handle...)
+		binary.NativeEndian.PutUint16(info[2:], uint16(len(info)))
+
+		metaLen := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+		meta := make([]byte, metaLen)
+		binary.NativeEndian.PutUint32(meta, uint32(metaLen+len(info)))
+		meta[4] = unix.FANOTIFY_METADATA_VERSION
+		binary.NativeEndian.PutUint16(meta[6:], uint16(metaLen))
+		binary.NativeEndian.PutUint64(meta[8:], unix.FAN_OPEN)
+		binary.NativeEndian.PutUint32(meta[16:], uint32(0xffffffff)) // FAN_NOFD
+		binary.NativeEndian.PutUint32(meta[20:], uint32(pid))
+		return append(meta, info...)
+	}
+	buf := append(event(42, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}), event(0, 0x81, []byte{9, 9})...)
+	// A truncated event is ignored
+	buf = append(buf, event(7, 1, []byte{1})[:10]...)
+
+	type got struct {
+		pid    int32
+		handle string
+	}
+	var events []got
+	parseFanotifyEvents(buf, func(pid int32, handle string) {
+		events = append(events, got{pid, handle})
+	})
+	want := []got{
+		{42, fileHandleKey(1, []byte{1, 2, 3, 4, 5, 6, 7, 8})},
+		{0, fileHandleKey(0x81, []byte{9, 9})},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("parseFanotifyEvents() = %q, want %q", events, want)
+	}
+}
+
+func TestReadMasks(t *testing.T) {
+	masks := newReadMasks()
+	file, err := masks.mask("/home/user/.netrc", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := masks.mask("/home/user/.netrc", false); again != file {
+		t.Errorf("mask() of the same path = %s, want %s", again, file)
+	}
+	dir, err := masks.mask("/home/user/.ssh", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir == file || !isDirectory(dir) || !fileExists(file) || isDirectory(file) {
+		t.Errorf("masks %s and %s are not an empty file and a directory", file, dir)
+	}
+
+	if err := masks.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(file) || fileExists(dir) {
+		t.Error("Shutdown() left the masks")
+	}
+}
+
+func TestFanotifyMonitor(t *testing.T) {
+	if !DetectLinuxFeatures().HasFanotify {
+		t.Skip("needs fanotify (Linux 5.13+)")
+	}
+	masks := newReadMasks()
+	defer func() { _ = masks.Shutdown(context.Background()) }()
+	file, err := masks.mask("/home/user/.netrc", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newFanotifyMonitor(masks, false)
+	m.violations = policy.NewViolationLog(false)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Masks created once the monitor runs are watched too
+	dir, err := masks.mask("/home/user/.ssh", true)
+	if err != nil {
+		m.Stop()
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("sh", "-c", `cat "$1"; ls "$2"`, "sh", file, dir).CombinedOutput(); err != nil {
+		m.Stop()
+		t.Fatalf("reading the masks: %v: %s", err, out)
+	}
+	m.Stop()
+
+	var targets []string
+	for _, v := range m.violations.Violations() {
+		if v.Kind == policy.KindFilesystem {
+			targets = append(targets, v.Target)
+		}
+	}
+	slices.Sort(targets)
+	if want := []string{"/home/user/.netrc", "/home/user/.ssh"}; !slices.Equal(targets, want) {
+		t.Errorf("violations = %v, want %v", targets, want)
+	}
+}
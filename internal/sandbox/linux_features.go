@@ -3,14 +3,17 @@
 package sandbox
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"unsafe"
 
+	"github.com/Use-Tusk/fence/internal/config"
 	"golang.org/x/sys/unix"
 )
 
@@ -31,10 +34,26 @@ type LinuxFeatures struct {
 	HasCapBPF  bool
 	HasCapRoot bool
 
+	// fanotify in its unprivileged mode (Linux 5.13+), which the monitor
+	// uses without eBPF
+	HasFanotify bool
+
 	// Network namespace capability
 	// This can be false in containerized environments (Docker, CI) without CAP_NET_ADMIN
 	CanUnshareNet bool
 
+	// Unprivileged user namespaces, which the native backend needs
+	CanUnshareUser bool
+
+	// AppArmor is enabled and apparmor_parser and aa-exec are installed
+	HasAppArmor bool
+
+	// SELinux is enforcing and semodule and runcon are installed
+	HasSELinux bool
+
+	// Active Linux security modules, from /sys/kernel/security/lsm
+	LSMs []string
+
 	// Kernel version
 	KernelMajor int
 	KernelMinor int
@@ -72,8 +91,23 @@ func (f *LinuxFeatures) detect() {
 	// Check eBPF capabilities
 	f.detectEBPF()
 
+	// Check if fanotify can watch files without privileges
+	f.detectFanotify()
+
 	// Check if we can create network namespaces
 	f.detectNetworkNamespace()
+
+	// Check if we can create user namespaces (native backend)
+	f.detectUserNamespace()
+
+	// Check for AppArmor (apparmor backend)
+	f.detectAppArmor()
+
+	// Check for SELinux (selinux backend)
+	f.detectSELinux()
+
+	// List the active LSMs (security.lsm)
+	f.detectLSMs()
 }
 
 func (f *LinuxFeatures) parseKernelVersion() {
@@ -190,6 +224,17 @@ func (f *LinuxFeatures) detectEBPF() {
 	}
 }
 
+// detectFanotify probes whether a fanotify group like the monitor's can be
+// created. Containers may block fanotify_init with seccomp.
+func (f *LinuxFeatures) detectFanotify() {
+	fd, err := unix.FanotifyInit(fanotifyFlags, unix.O_RDONLY|unix.O_CLOEXEC)
+	if err != nil {
+		return
+	}
+	_ = unix.Close(fd)
+	f.HasFanotify = true
+}
+
 // detectNetworkNamespace probes whether bwrap --unshare-net works.
 // This can fail in containerized environments (Docker, GitHub Actions, etc.)
 // that don't have CAP_NET_ADMIN capability needed to set up the loopback interface.
@@ -206,6 +251,122 @@ func (f *LinuxFeatures) detectNetworkNamespace() {
 	f.CanUnshareNet = err == nil
 }
 
+// detectUserNamespace probes whether an unprivileged process can create a
+// user namespace, and a network namespace inside it, as the native backend does.
+func (f *LinuxFeatures) detectUserNamespace() {
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	f.CanUnshareUser = cmd.Run() == nil
+}
+
+func (f *LinuxFeatures) detectAppArmor() {
+	enabled, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	f.HasAppArmor = err == nil && strings.TrimSpace(string(enabled)) == "Y" &&
+		commandExists("apparmor_parser") && commandExists("aa-exec")
+}
+
+// CanUseAppArmor reports whether fence can load AppArmor profiles and run
+// commands under them. Loading a profile needs root (CAP_MAC_ADMIN).
+func (f *LinuxFeatures) CanUseAppArmor() bool {
+	return f.HasAppArmor && f.HasCapRoot
+}
+
+func (f *LinuxFeatures) detectSELinux() {
+	enforce, err := os.ReadFile("/sys/fs/selinux/enforce")
+	f.HasSELinux = err == nil && strings.TrimSpace(string(enforce)) == "1" &&
+		commandExists("semodule") && commandExists("runcon")
+}
+
+// CanUseSELinux reports whether fence can load SELinux policy modules and
+// run commands in their domains. Loading a module needs root (CAP_MAC_ADMIN).
+func (f *LinuxFeatures) CanUseSELinux() bool {
+	return f.HasSELinux && f.HasCapRoot
+}
+
+func (f *LinuxFeatures) detectLSMs() {
+	data, err := os.ReadFile("/sys/kernel/security/lsm")
+	if err != nil {
+		return
+	}
+	for _, name := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		if name != "" {
+			f.LSMs = append(f.LSMs, name)
+		}
+	}
+}
+
+// ResolveLSM returns the LSM that confines the command inside a bwrap or
+// native sandbox for the security.lsm setting: config.LSMAppArmor,
+// config.LSMSELinux, or "" for none. With config.LSMAuto it is AppArmor if
+// fence can load profiles, otherwise SELinux if it is enforcing; a named LSM
+// that cannot be used is an error.
+func (f *LinuxFeatures) ResolveLSM(setting string) (string, error) {
+	switch setting {
+	case "":
+		return "", nil
+	case config.LSMAuto:
+		if f.CanUseAppArmor() {
+			return config.LSMAppArmor, nil
+		}
+		if f.HasSELinux {
+			return config.LSMSELinux, nil
+		}
+		return "", nil
+	case config.LSMAppArmor:
+		if !f.HasAppArmor {
+			return "", errors.New("security.lsm apparmor requires AppArmor to be enabled, with apparmor_parser and aa-exec installed")
+		}
+		if !f.CanUseAppArmor() {
+			return "", errors.New("security.lsm apparmor requires root to load its profile")
+		}
+		return config.LSMAppArmor, nil
+	case config.LSMSELinux:
+		if !f.HasSELinux {
+			return "", errors.New("security.lsm selinux requires SELinux to be enforcing, with semodule and runcon installed")
+		}
+		return config.LSMSELinux, nil
+	}
+	return "", fmt.Errorf("unknown security.lsm %q", setting)
+}
+
+// ResolveBackend returns the backend that runs the sandbox when backend is
+// requested. The default ("") is BackendBwrap, or when neither bwrap nor
+// Landlock is available, BackendAppArmor or BackendSELinux if one is.
+func (f *LinuxFeatures) ResolveBackend(backend string) string {
+	if backend != "" {
+		return backend
+	}
+	if !f.HasBwrap && !f.CanUseLandlock() {
+		if f.CanUseAppArmor() {
+			return BackendAppArmor
+		}
+		if f.CanUseSELinux() {
+			return BackendSELinux
+		}
+	}
+	return BackendBwrap
+}
+
+// CanUnshareNetWith reports whether the given backend can give the sandbox its
+// own network namespace.
+func (f *LinuxFeatures) CanUnshareNetWith(backend string) bool {
+	switch f.ResolveBackend(backend) {
+	case BackendAppArmor, BackendSELinux:
+		// The command runs in the host's namespaces
+		return false
+	case BackendNative:
+		return f.CanUnshareUser
+	case BackendGVisor:
+		// runsc provides the network stack
+		return true
+	}
+	return f.CanUnshareNet
+}
+
 // Summary returns a human-readable summary of available features.
 func (f *LinuxFeatures) Summary() string {
 	var parts []string
@@ -219,6 +380,15 @@ func (f *LinuxFeatures) Summary() string {
 			parts = append(parts, "bwrap(no-netns)")
 		}
 	}
+	if f.CanUnshareUser {
+		parts = append(parts, "userns")
+	}
+	if f.HasAppArmor {
+		parts = append(parts, "apparmor")
+	}
+	if f.HasSELinux {
+		parts = append(parts, "selinux")
+	}
 	if f.HasSeccomp {
 		switch f.SeccompLogLevel {
 		case 2:
@@ -239,6 +409,9 @@ func (f *LinuxFeatures) Summary() string {
 			parts = append(parts, "ebpf(CAP_BPF)")
 		}
 	}
+	if f.HasFanotify {
+		parts = append(parts, "fanotify")
+	}
 
 	return strings.Join(parts, ", ")
 }
@@ -247,7 +420,8 @@ func (f *LinuxFeatures) Summary() string {
 func (f *LinuxFeatures) CanMonitorViolations() bool {
 	// seccomp LOG requires kernel 4.14+
 	// eBPF monitoring requires CAP_BPF or root
-	return f.SeccompLogLevel >= 1 || f.HasEBPF
+	// fanotify monitoring of denyRead paths requires Linux 5.13+
+	return f.SeccompLogLevel >= 1 || f.HasEBPF || f.HasFanotify
 }
 
 // CanUseLandlock returns true if Landlock is available.
@@ -256,8 +430,9 @@ func (f *LinuxFeatures) CanUseLandlock() bool {
 }
 
 // MinimumViable returns true if the minimum required features are available.
+// socat is only needed when fence is used as a library (see fenceHelperPath).
 func (f *LinuxFeatures) MinimumViable() bool {
-	return f.HasBwrap && f.HasSocat
+	return f.HasBwrap
 }
 
 func commandExists(name string) bool {
@@ -308,3 +483,9 @@ type landlockPathBeneathAttr struct {
 	parentFd      int32
 	_             [4]byte // padding
 }
+
+// landlockNetPortAttr is used to add TCP port rules (ABI v4+)
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
@@ -14,7 +14,12 @@ type LinuxFeatures struct {
 	HasEBPF         bool
 	HasCapBPF       bool
 	HasCapRoot      bool
+	HasFanotify     bool
 	CanUnshareNet   bool
+	CanUnshareUser  bool
+	HasAppArmor     bool
+	HasSELinux      bool
+	LSMs            []string
 	KernelMajor     int
 	KernelMinor     int
 }
@@ -39,6 +44,34 @@ func (f *LinuxFeatures) CanUseLandlock() bool {
 	return false
 }
 
+// CanUseAppArmor returns false on non-Linux platforms.
+func (f *LinuxFeatures) CanUseAppArmor() bool {
+	return false
+}
+
+// CanUseSELinux returns false on non-Linux platforms.
+func (f *LinuxFeatures) CanUseSELinux() bool {
+	return false
+}
+
+// ResolveLSM returns "" on non-Linux platforms, where security.lsm is ignored.
+func (f *LinuxFeatures) ResolveLSM(setting string) (string, error) {
+	return "", nil
+}
+
+// ResolveBackend returns backend, or BackendBwrap for the default.
+func (f *LinuxFeatures) ResolveBackend(backend string) string {
+	if backend == "" {
+		return BackendBwrap
+	}
+	return backend
+}
+
+// CanUnshareNetWith returns false on non-Linux platforms.
+func (f *LinuxFeatures) CanUnshareNetWith(backend string) bool {
+	return false
+}
+
 // MinimumViable returns false on non-Linux platforms.
 func (f *LinuxFeatures) MinimumViable() bool {
 	return false
@@ -0,0 +1,266 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// The gVisor backend translates the bwrap-style arguments buildLinuxSandbox
+// generates into an OCI runtime spec and runs it with runsc:
+//
+//   - --ro-bind / / becomes the read-only root; the other binds, tmpfs, and
+//     proc mounts become OCI mounts, in the same order.
+//   - gVisor has its own /dev and does not pass host devices through, so
+//     mounts under /dev are dropped, and files masked with /dev/null are
+//     masked with an empty file instead.
+//   - --unshare-net selects runsc --network=none, which leaves only loopback;
+//     otherwise the sandbox uses the host network. The command reaches the
+//     fence proxies over the bridge sockets, which --host-uds lets it use.
+//   - --seccomp is ignored: the command's syscalls are handled by gVisor's
+//     kernel, not the host's.
+
+// ociSpec is the subset of the OCI runtime spec (config.json) fence writes.
+type ociSpec struct {
+	OCIVersion string     `json:"ociVersion"`
+	Process    ociProcess `json:"process"`
+	Root       ociRoot    `json:"root"`
+	Mounts     []ociMount `json:"mounts"`
+	Linux      ociLinux   `json:"linux"`
+}
+
+type ociProcess struct {
+	Terminal        bool     `json:"terminal"`
+	User            ociUser  `json:"user"`
+	Args            []string `json:"args"`
+	Env             []string `json:"env"`
+	Cwd             string   `json:"cwd"`
+	NoNewPrivileges bool     `json:"noNewPrivileges"`
+}
+
+type ociUser struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+type ociRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+type ociMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type ociLinux struct {
+	Namespaces []ociNamespace `json:"namespaces"`
+}
+
+type ociNamespace struct {
+	Type string `json:"type"`
+}
+
+// gvisorProcess is what the OCI spec needs about the process that is not in
+// the sandbox arguments.
+type gvisorProcess struct {
+	uid, gid  int // Used unless the arguments set --uid/--gid
+	cwd       string
+	env       []string
+	emptyFile string // Stands in for /dev/null as a bind source
+}
+
+// gvisorSpec returns the OCI spec equivalent to a parsed sandbox invocation.
+func gvisorSpec(spec *nativeSpec, proc gvisorProcess) (*ociSpec, error) {
+	oci := &ociSpec{
+		OCIVersion: "1.0.2",
+		Process: ociProcess{
+			User:            ociUser{UID: proc.uid, GID: proc.gid},
+			Args:            spec.command,
+			Env:             proc.env,
+			Cwd:             proc.cwd,
+			NoNewPrivileges: true,
+		},
+		Mounts: []ociMount{},
+		Linux: ociLinux{
+			Namespaces: []ociNamespace{{Type: "pid"}, {Type: "mount"}},
+		},
+	}
+	if spec.uid >= 0 {
+		oci.Process.User.UID = spec.uid
+	}
+	if spec.gid >= 0 {
+		oci.Process.User.GID = spec.gid
+	}
+	if spec.unshareNet {
+		oci.Linux.Namespaces = append(oci.Linux.Namespaces, ociNamespace{Type: "network"})
+	}
+
+	hasRoot := false
+	for _, m := range spec.mounts {
+		if m.dest == "/" {
+			if m.kind != "bind" && m.kind != "ro-bind" || m.src != "/" || hasRoot {
+				return nil, fmt.Errorf("--%s %s /: the root must be a single bind of /", m.kind, m.src)
+			}
+			hasRoot = true
+			oci.Root = ociRoot{Path: "/", Readonly: m.kind == "ro-bind"}
+			continue
+		}
+		if m.kind == "dev-bind" || m.dest == "/dev" || strings.HasPrefix(m.dest, "/dev/") {
+			continue
+		}
+
+		switch m.kind {
+		case "overlay":
+			return nil, fmt.Errorf("--overlay %s: overlays are not supported by the gVisor backend", m.dest)
+		case "dir":
+			return nil, fmt.Errorf("--dir %s: directories are not supported by the gVisor backend", m.dest)
+		case "proc":
+			oci.Mounts = append(oci.Mounts, ociMount{Destination: m.dest, Type: "proc", Source: "proc"})
+		case "tmpfs":
+			oci.Mounts = append(oci.Mounts, ociMount{
+				Destination: m.dest,
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Options:     []string{"nosuid", "nodev", "mode=1777"},
+			})
+		default:
+			src := m.src
+			if src == "/dev/null" {
+				src = proc.emptyFile
+			}
+			mode := "rw"
+			if m.kind == "ro-bind" {
+				mode = "ro"
+			}
+			oci.Mounts = append(oci.Mounts, ociMount{
+				Destination: m.dest,
+				Type:        "bind",
+				Source:      src,
+				Options:     []string{"rbind", mode},
+			})
+		}
+	}
+	if !hasRoot {
+		return nil, errors.New("no root mount (--ro-bind / /) specified")
+	}
+	return oci, nil
+}
+
+// runscArgs returns the runsc arguments that run the bundle in bundleDir as
+// container id, keeping runsc's state under stateDir.
+func runscArgs(spec *nativeSpec, bundleDir, stateDir, id string, rootless bool) []string {
+	args := []string{"--root", stateDir, "--ignore-cgroups", "--host-uds=all"}
+	if rootless {
+		args = append(args, "--rootless")
+	}
+	if spec.unshareNet {
+		args = append(args, "--network=none")
+	} else {
+		args = append(args, "--network=host")
+	}
+	return append(args, "run", "--bundle", bundleDir, id)
+}
+
+// RunGVisorSandbox runs the command described by bwrap-style args under
+// runsc and returns its exit code. It is fence --gvisor-sandbox.
+func RunGVisorSandbox(args []string) int {
+	fail := func(format string, a ...interface{}) int {
+		logging.Errorf("gvisor", format, a...)
+		return 1
+	}
+
+	spec, err := parseNativeArgs(args)
+	if err != nil {
+		return fail("%v", err)
+	}
+	runsc, err := exec.LookPath("runsc")
+	if err != nil {
+		return fail("%v", &MissingDependencyError{Binary: "runsc", Err: err})
+	}
+
+	bundleDir, err := os.MkdirTemp("", "fence-gvisor-")
+	if err != nil {
+		return fail("failed to create bundle: %v", err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	stateDir := filepath.Join(bundleDir, "state")
+	emptyFile := filepath.Join(bundleDir, "empty")
+	if err := os.Mkdir(stateDir, 0o700); err != nil {
+		return fail("failed to create bundle: %v", err)
+	}
+	if err := os.WriteFile(emptyFile, nil, 0o444); err != nil {
+		return fail("failed to create bundle: %v", err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fail("%v", err)
+	}
+	oci, err := gvisorSpec(spec, gvisorProcess{
+		uid:       os.Getuid(),
+		gid:       os.Getgid(),
+		cwd:       cwd,
+		env:       os.Environ(),
+		emptyFile: emptyFile,
+	})
+	if err != nil {
+		return fail("%v", err)
+	}
+	data, err := json.MarshalIndent(oci, "", "  ")
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bundleDir, "config.json"), data, 0o600); err != nil {
+		return fail("failed to create bundle: %v", err)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fail("failed to generate container ID: %v", err)
+	}
+	containerID := "fence-" + hex.EncodeToString(id)
+
+	cmd := exec.Command(runsc, runscArgs(spec, bundleDir, stateDir, containerID, os.Geteuid() != 0)...) //nolint:gosec // runsc from PATH, like bwrap
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: spec.newSession}
+	if spec.dieWithParent {
+		// Pdeathsig fires when the creating thread exits, so stay on it
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+		_ = unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(syscall.SIGKILL), 0, 0, 0)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	if err := cmd.Start(); err != nil {
+		return fail("failed to start runsc: %v", err)
+	}
+	// runsc run forwards signals to the command
+	go func() {
+		for sig := range sigChan {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+	return exitCode(cmd.Wait())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// RunGVisorSandbox is not available on non-Linux platforms.
+func RunGVisorSandbox(args []string) int {
+	logging.Errorf("gvisor", "the gVisor backend is only available on Linux")
+	return 1
+}
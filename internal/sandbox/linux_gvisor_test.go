@@ -0,0 +1,128 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestGVisorSpec(t *testing.T) {
+	spec, err := parseNativeArgs([]string{
+		"--new-session", "--unshare-net", "--unshare-pid", "--uid", "65534", "--gid", "65534",
+		"--ro-bind", "/", "/", "--dev-bind", "/dev", "/dev", "--proc", "/proc",
+		"--tmpfs", "/tmp", "--bind", "/home/u/project", "/home/u/project",
+		"--ro-bind", "/dev/null", "/home/u/.netrc", "--ro-bind", "/dev/null", "/dev/snd",
+		"--", "/bin/bash", "-c", "echo hi",
+	})
+	if err != nil {
+		t.Fatalf("parseNativeArgs() error = %v", err)
+	}
+
+	oci, err := gvisorSpec(spec, gvisorProcess{uid: 1000, gid: 1000, cwd: "/home/u/project", emptyFile: "/bundle/empty"})
+	if err != nil {
+		t.Fatalf("gvisorSpec() error = %v", err)
+	}
+	if oci.Root != (ociRoot{Path: "/", Readonly: true}) {
+		t.Errorf("root = %+v, want read-only /", oci.Root)
+	}
+	if oci.Process.User != (ociUser{UID: 65534, GID: 65534}) {
+		t.Errorf("user = %+v, want --uid/--gid", oci.Process.User)
+	}
+	if want := []string{"/bin/bash", "-c", "echo hi"}; !slices.Equal(oci.Process.Args, want) {
+		t.Errorf("args = %q, want %q", oci.Process.Args, want)
+	}
+	if !slices.Contains(oci.Linux.Namespaces, ociNamespace{Type: "network"}) {
+		t.Error("--unshare-net should add a network namespace")
+	}
+
+	var dests []string
+	for _, m := range oci.Mounts {
+		dests = append(dests, m.Destination)
+	}
+	if want := []string{"/proc", "/tmp", "/home/u/project", "/home/u/.netrc"}; !slices.Equal(dests, want) {
+		t.Errorf("mount destinations = %q, want %q (mounts under /dev dropped)", dests, want)
+	}
+	if m := oci.Mounts[2]; m.Type != "bind" || !slices.Equal(m.Options, []string{"rbind", "rw"}) {
+		t.Errorf("--bind mount = %+v, want a read-write bind", m)
+	}
+	if m := oci.Mounts[3]; m.Source != "/bundle/empty" || !slices.Contains(m.Options, "ro") {
+		t.Errorf("/dev/null mask = %+v, want a read-only bind of the empty file", m)
+	}
+}
+
+func TestGVisorSpecErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no root", []string{"--tmpfs", "/tmp", "--", "true"}},
+		{"root from another directory", []string{"--ro-bind", "/srv", "/", "--", "true"}},
+		{"root tmpfs", []string{"--tmpfs", "/", "--", "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseNativeArgs(tt.args)
+			if err != nil {
+				t.Fatalf("parseNativeArgs() error = %v", err)
+			}
+			if _, err := gvisorSpec(spec, gvisorProcess{}); err == nil {
+				t.Errorf("gvisorSpec(%q) should fail", tt.args)
+			}
+		})
+	}
+}
+
+func TestRunscArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		unshareNet bool
+		rootless   bool
+		want       []string
+	}{
+		{"isolated", true, true, []string{"--root", "/b/state", "--ignore-cgroups", "--host-uds=all", "--rootless", "--network=none", "run", "--bundle", "/b", "fence-1"}},
+		{"host network as root", false, false, []string{"--root", "/b/state", "--ignore-cgroups", "--host-uds=all", "--network=host", "run", "--bundle", "/b", "fence-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runscArgs(&nativeSpec{unshareNet: tt.unshareNet}, "/b", "/b/state", "fence-1", tt.rootless)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("runscArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The gVisor backend must be able to translate every argument fence
+// generates, and leaves syscall filtering to gVisor.
+func TestGVisorBackendTranslatesGeneratedArgs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"."}
+	cfg.Filesystem.DenyRead = []string{"/etc/shadow"}
+	bridge := &LinuxBridge{
+		HTTPSocketPath:  "/tmp/fence-http-test.sock",
+		SOCKSSocketPath: "/tmp/fence-socks-test.sock",
+	}
+
+	spec, err := LinuxSpec(cfg, "echo hello", bridge, nil, LinuxSandboxOptions{UseSeccomp: true, Backend: BackendGVisor})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if len(spec.BwrapArgs) < 2 || spec.BwrapArgs[1] != GVisorSandboxFlag {
+		t.Fatalf("args should run fence %s, got %q", GVisorSandboxFlag, spec.BwrapArgs[:2])
+	}
+	if slices.Contains(spec.BwrapArgs, "--seccomp") || spec.SeccompSyscalls != nil {
+		t.Error("the gVisor backend should not install a host seccomp filter")
+	}
+	parsed, err := parseNativeArgs(spec.BwrapArgs[2:])
+	if err != nil {
+		t.Fatalf("parseNativeArgs() rejected generated args: %v", err)
+	}
+	if _, err := gvisorSpec(parsed, gvisorProcess{emptyFile: "/bundle/empty"}); err != nil {
+		t.Errorf("gvisorSpec() rejected generated args: %v", err)
+	}
+}
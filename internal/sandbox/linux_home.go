@@ -0,0 +1,83 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// privateHomeSkeleton is the directories created in the empty home that
+// filesystem.privateHome mounts, since many programs expect them to exist.
+var privateHomeSkeleton = []string{".cache", ".config", ".local/share", ".local/state"}
+
+// privateHome is the empty tmpfs mounted over $HOME for filesystem.privateHome.
+// Only the paths in visible are mounted back into it.
+type privateHome struct {
+	dir     string
+	visible []string
+}
+
+// newPrivateHome returns the private home for a command run in cwd. The
+// working directory and the fence executable stay visible if they are in
+// the home directory.
+func newPrivateHome(cwd, fenceExePath string) (*privateHome, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	home = filepath.Clean(home)
+	if home == "/" {
+		return nil, errors.New("filesystem.privateHome cannot hide the home directory /")
+	}
+	if cwd != "" && isWithin(home, cwd) {
+		return nil, errors.New("filesystem.privateHome cannot hide the home directory while working in it; run the command from a subdirectory")
+	}
+
+	h := &privateHome{dir: home}
+	for _, p := range []string{cwd, fenceExePath} {
+		if p != "" && isWithin(p, home) {
+			h.visible = append(h.visible, p)
+		}
+	}
+	return h, nil
+}
+
+// mountArgs returns the bwrap arguments that replace the home directory with
+// the skeleton and bind the visible paths back read-only. Writable paths in
+// the home directory are bound afterwards, over these.
+func (h *privateHome) mountArgs() []string {
+	args := []string{"--tmpfs", h.dir}
+	for _, d := range privateHomeSkeleton {
+		args = append(args, "--dir", filepath.Join(h.dir, d))
+	}
+	for _, p := range h.visible {
+		if fileExists(p) {
+			args = append(args, "--ro-bind", p, p)
+		}
+	}
+	return args
+}
+
+// show keeps path visible, e.g. because it is bound writable.
+func (h *privateHome) show(path string) {
+	if isWithin(path, h.dir) {
+		h.visible = append(h.visible, path)
+	}
+}
+
+// hides reports whether path is in the home directory but outside the paths
+// bound back into it. Mounting a deny rule at such a path would expose the
+// real file, or reveal that it exists.
+func (h *privateHome) hides(path string) bool {
+	if h == nil || !isWithin(path, h.dir) {
+		return false
+	}
+	for _, v := range h.visible {
+		if isWithin(path, v) {
+			return false
+		}
+	}
+	return true
+}
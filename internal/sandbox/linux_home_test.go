@@ -0,0 +1,101 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestNewPrivateHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if _, err := newPrivateHome(home, ""); err == nil {
+		t.Error("newPrivateHome() should refuse to hide the working directory")
+	}
+	if _, err := newPrivateHome("/", ""); err == nil {
+		t.Error("newPrivateHome() should refuse to hide a directory containing the working directory")
+	}
+
+	project := filepath.Join(home, "src", "project")
+	h, err := newPrivateHome(project, "/usr/bin/fence")
+	if err != nil {
+		t.Fatalf("newPrivateHome() error = %v", err)
+	}
+	h.show(filepath.Join(home, ".npm"))
+	h.show("/var/cache")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(home, ".ssh", "id_ed25519"), true},
+		{filepath.Join(home, ".bashrc"), true},
+		{filepath.Join(home, "src"), true},
+		{filepath.Join(project, ".git", "hooks"), false},
+		{filepath.Join(home, ".npm", "_logs"), false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if got := h.hides(tt.path); got != tt.want {
+			t.Errorf("hides(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	var none *privateHome
+	if none.hides(filepath.Join(home, ".bashrc")) {
+		t.Error("a nil privateHome should hide nothing")
+	}
+}
+
+func TestLinuxSpecPrivateHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, d := range []string{".ssh", ".npm"} {
+		if err := os.Mkdir(filepath.Join(home, d), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Filesystem.PrivateHome = true
+	cfg.Filesystem.AllowWrite = []string{"~/.npm"}
+	cfg.Filesystem.DenyRead = []string{"~/.ssh"}
+
+	spec, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendBwrap})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	args := spec.BwrapArgs
+
+	homeAt := slices.Index(args, home)
+	if homeAt < 1 || args[homeAt-1] != "--tmpfs" {
+		t.Fatalf("args %q should mount a tmpfs over the home directory", args)
+	}
+	for _, d := range privateHomeSkeleton {
+		if !slices.Contains(args, filepath.Join(home, d)) {
+			t.Errorf("args %q should create %s", args, d)
+		}
+	}
+	npm := filepath.Join(home, ".npm")
+	if npmAt := slices.Index(args, npm); npmAt < homeAt || args[npmAt-1] != "--bind" {
+		t.Errorf("args %q should bind %s writable over the tmpfs", args, npm)
+	}
+	for _, p := range []string{".ssh", ".bashrc"} {
+		if slices.Contains(args, filepath.Join(home, p)) {
+			t.Errorf("args %q should not mount anything at the hidden %s", args, p)
+		}
+	}
+
+	if _, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendGVisor}); err == nil {
+		t.Error("LinuxSpec() should reject filesystem.privateHome with the gvisor backend")
+	}
+}
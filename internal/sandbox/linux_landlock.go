@@ -7,22 +7,27 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"unsafe"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
 	"github.com/bmatcuk/doublestar/v4"
 	"golang.org/x/sys/unix"
 )
 
 // ApplyLandlockFromConfig creates and applies Landlock restrictions based on config.
+// With net, the TCP ports the command may bind and connect to are restricted
+// too, if the kernel supports it (ABI v4+).
 // This should be called before exec'ing the sandboxed command.
 // Returns nil if Landlock is not available (graceful fallback).
-func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []string, debug bool) error {
+func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []string, net *LandlockNetRules, debug bool) error {
 	features := DetectLinuxFeatures()
 	if !features.CanUseLandlock() {
 		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Not available (kernel %d.%d < 5.13), skipping\n",
+			logging.Debugf("landlock", "Not available (kernel %d.%d < 5.13), skipping",
 				features.KernelMajor, features.KernelMinor)
 		}
 		return nil // Graceful fallback - Landlock not available
@@ -31,19 +36,71 @@ func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []strin
 	ruleset, err := NewLandlockRuleset(debug)
 	if err != nil {
 		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Failed to create ruleset: %v\n", err)
+			logging.Debugf("landlock", "Failed to create ruleset: %v", err)
 		}
 		return nil // Graceful fallback
 	}
 	defer func() { _ = ruleset.Close() }()
 
+	if net != nil {
+		if features.LandlockABI >= 4 {
+			ruleset.RestrictNetwork(net.BindPorts != nil, net.ConnectPorts != nil)
+		} else if debug {
+			logging.Debugf("landlock", "Network rules need ABI v4 (have v%d), skipping", features.LandlockABI)
+		}
+	}
+
 	if err := ruleset.Initialize(); err != nil {
 		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Failed to initialize: %v\n", err)
+			logging.Debugf("landlock", "Failed to initialize: %v", err)
 		}
 		return nil // Graceful fallback
 	}
 
+	for _, rule := range LandlockRules(cfg, cwd, socketPaths) {
+		var err error
+		if rule.Write {
+			err = ruleset.AllowReadWrite(rule.Path)
+		} else {
+			err = ruleset.AllowRead(rule.Path)
+		}
+		// Ignore errors for paths that don't exist
+		if err != nil && debug && !os.IsNotExist(err) {
+			logging.Warnf("landlock", "failed to add %s path %s: %v", rule.Access(), rule.Path, err)
+		}
+	}
+
+	if net != nil {
+		for _, port := range net.BindPorts {
+			if err := ruleset.AllowBindPort(port); err != nil && debug {
+				logging.Warnf("landlock", "failed to allow binding port %d: %v", port, err)
+			}
+		}
+		for _, port := range net.ConnectPorts {
+			if err := ruleset.AllowConnectPort(port); err != nil && debug {
+				logging.Warnf("landlock", "failed to allow connecting to port %d: %v", port, err)
+			}
+		}
+	}
+
+	// Apply the ruleset
+	if err := ruleset.Apply(); err != nil {
+		if debug {
+			logging.Debugf("landlock", "Failed to apply: %v", err)
+		}
+		return nil // Graceful fallback
+	}
+
+	if debug {
+		logging.Debugf("landlock", "Applied restrictions (ABI v%d)", features.LandlockABI)
+	}
+
+	return nil
+}
+
+// LandlockRules returns the paths ApplyLandlockFromConfig grants access to,
+// in the order they are added to the ruleset.
+func LandlockRules(cfg *config.Config, cwd string, socketPaths []string) []LandlockRule {
 	// Essential system paths - allow read+execute
 	// Note: /dev is handled separately with read+write for /dev/null, /dev/zero, etc.
 	systemReadPaths := []string{
@@ -62,88 +119,106 @@ func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []strin
 		"/opt",
 	}
 
+	var rules []LandlockRule
 	for _, p := range systemReadPaths {
-		if err := ruleset.AllowRead(p); err != nil && debug {
-			// Ignore errors for paths that don't exist
-			if !os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add read path %s: %v\n", p, err)
-			}
-		}
+		rules = append(rules, LandlockRule{Path: p})
 	}
 
 	// Current working directory - read access (may be upgraded to write below)
 	if cwd != "" {
-		if err := ruleset.AllowRead(cwd); err != nil && debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add cwd read path: %v\n", err)
-		}
+		rules = append(rules, LandlockRule{Path: cwd})
 	}
 
-	// Home directory - read access
+	// Home directory - read access, or read+write when it is the empty
+	// tmpfs of filesystem.privateHome
 	if home, err := os.UserHomeDir(); err == nil {
-		if err := ruleset.AllowRead(home); err != nil && debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add home read path: %v\n", err)
-		}
+		rules = append(rules, LandlockRule{Path: home, Write: cfg != nil && cfg.Filesystem.PrivateHome})
 	}
 
 	// /tmp - allow read+write (many programs need this)
-	if err := ruleset.AllowReadWrite("/tmp"); err != nil && debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add /tmp write path: %v\n", err)
-	}
+	rules = append(rules, LandlockRule{Path: "/tmp", Write: true})
 
 	// /dev needs read+write for /dev/null, /dev/zero, /dev/tty, etc.
 	// Landlock doesn't support rules on device files directly, so we allow the whole /dev
-	if err := ruleset.AllowReadWrite("/dev"); err != nil && debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add /dev write path: %v\n", err)
-	}
+	rules = append(rules, LandlockRule{Path: "/dev", Write: true})
 
 	// Socket paths for proxy communication
 	for _, p := range socketPaths {
-		dir := filepath.Dir(p)
-		if err := ruleset.AllowReadWrite(dir); err != nil && debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add socket path %s: %v\n", dir, err)
-		}
+		rules = append(rules, LandlockRule{Path: filepath.Dir(p), Write: true})
 	}
 
 	// User-configured allowWrite paths
 	if cfg != nil && cfg.Filesystem.AllowWrite != nil {
-		expandedPaths := ExpandGlobPatterns(cfg.Filesystem.AllowWrite)
-		for _, p := range expandedPaths {
-			if err := ruleset.AllowReadWrite(p); err != nil && debug {
-				fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add write path %s: %v\n", p, err)
-			}
+		for _, p := range ExpandGlobPatterns(cfg.Filesystem.AllowWrite) {
+			rules = append(rules, LandlockRule{Path: p, Write: true})
 		}
 		// Also add non-glob paths directly
 		for _, p := range cfg.Filesystem.AllowWrite {
 			if !ContainsGlobChars(p) {
-				normalized := NormalizePath(p)
-				if err := ruleset.AllowReadWrite(normalized); err != nil && debug {
-					fmt.Fprintf(os.Stderr, "[fence:landlock] Warning: failed to add write path %s: %v\n", normalized, err)
-				}
+				rules = append(rules, LandlockRule{Path: NormalizePath(p), Write: true})
 			}
 		}
 	}
 
-	// Apply the ruleset
-	if err := ruleset.Apply(); err != nil {
-		if debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Failed to apply: %v\n", err)
+	// Glob expansion and the non-glob pass can both yield the same path
+	seen := make(map[LandlockRule]bool)
+	return slices.DeleteFunc(rules, func(r LandlockRule) bool {
+		if seen[r] {
+			return true
 		}
-		return nil // Graceful fallback
+		seen[r] = true
+		return false
+	})
+}
+
+// landlockNetRules returns the TCP ports the command may bind and connect
+// to in its own network namespace, where only the sandbox's loopback is
+// reachable: binding only to exposedPorts unless allowLocalBinding is set,
+// and connecting only to the proxy bridge's ports (3128 and 1080), and the
+// DNS resolver's port 53 with dns, unless local outbound is allowed.
+func landlockNetRules(cfg *config.Config, proxy, dns bool, exposedPorts []int) *LandlockNetRules {
+	allowLocalBinding := cfg != nil && cfg.Network.AllowLocalBinding
+	allowLocalOutbound := allowLocalBinding
+	if cfg != nil && cfg.Network.AllowLocalOutbound != nil {
+		allowLocalOutbound = *cfg.Network.AllowLocalOutbound
+	}
+	if allowLocalBinding && allowLocalOutbound {
+		return nil
 	}
 
-	if debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Applied restrictions (ABI v%d)\n", features.LandlockABI)
+	rules := &LandlockNetRules{}
+	if !allowLocalBinding {
+		rules.BindPorts = append([]int{}, exposedPorts...)
+	}
+	if !allowLocalOutbound {
+		rules.ConnectPorts = []int{}
+		if proxy {
+			rules.ConnectPorts = append(rules.ConnectPorts, 3128, 1080)
+		}
+		if dns {
+			rules.ConnectPorts = append(rules.ConnectPorts, 53)
+		}
 	}
+	return rules
+}
 
-	return nil
+// joinPorts returns ports as the comma-separated list the wrapper's
+// --bind-ports and --connect-ports flags take.
+func joinPorts(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+	return strings.Join(parts, ",")
 }
 
-// LandlockRuleset manages Landlock filesystem restrictions.
+// LandlockRuleset manages Landlock filesystem and network restrictions.
 type LandlockRuleset struct {
 	rulesetFd   int
 	abiVersion  int
 	debug       bool
 	initialized bool
+	netAccess   uint64 // Handled network access rights, set by RestrictNetwork
 	readPaths   map[string]bool
 	writePaths  map[string]bool
 	denyPaths   map[string]bool
@@ -176,18 +251,13 @@ func (l *LandlockRuleset) Initialize() error {
 	// Determine which access rights to handle based on ABI version
 	fsAccess := l.getHandledAccessFS()
 
+	// Network access is only handled when RestrictNetwork asked for it: the
+	// proxy connections need rules for the bridge's ports
 	attr := landlockRulesetAttr{
-		handledAccessFS: fsAccess,
+		handledAccessFS:  fsAccess,
+		handledAccessNet: l.netAccess,
 	}
 
-	// Note: We do NOT enable Landlock network restrictions (handledAccessNet)
-	// because:
-	// 1. Network isolation is already handled by bwrap's network namespace
-	// 2. Enabling network restrictions without proper allow rules would break
-	//    the sandbox's proxy connections
-	// 3. The proxy architecture requires localhost connections which would
-	//    need complex rule management
-
 	fd, _, err := unix.Syscall(
 		unix.SYS_LANDLOCK_CREATE_RULESET,
 		uintptr(unsafe.Pointer(&attr)), //nolint:gosec // required for syscall
@@ -202,7 +272,7 @@ func (l *LandlockRuleset) Initialize() error {
 	l.initialized = true
 
 	if l.debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Created ruleset (ABI v%d, fd=%d)\n", l.abiVersion, l.rulesetFd)
+		logging.Debugf("landlock", "Created ruleset (ABI v%d, fd=%d)", l.abiVersion, l.rulesetFd)
 	}
 
 	return nil
@@ -245,6 +315,61 @@ func (l *LandlockRuleset) getHandledAccessFS() uint64 {
 	return access
 }
 
+// RestrictNetwork makes the ruleset handle TCP binds, connects, or both, so
+// that only the ports added with AllowBindPort and AllowConnectPort are
+// permitted. It must be called before Initialize, and needs ABI v4.
+func (l *LandlockRuleset) RestrictNetwork(bind, connect bool) {
+	l.netAccess = 0
+	if bind {
+		l.netAccess |= LANDLOCK_ACCESS_NET_BIND_TCP
+	}
+	if connect {
+		l.netAccess |= LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+}
+
+// AllowBindPort lets the sandbox bind TCP port.
+func (l *LandlockRuleset) AllowBindPort(port int) error {
+	return l.addPortRule(port, LANDLOCK_ACCESS_NET_BIND_TCP)
+}
+
+// AllowConnectPort lets the sandbox connect to TCP port.
+func (l *LandlockRuleset) AllowConnectPort(port int) error {
+	return l.addPortRule(port, LANDLOCK_ACCESS_NET_CONNECT_TCP)
+}
+
+// addPortRule adds a rule for a TCP port, if the ruleset handles access.
+func (l *LandlockRuleset) addPortRule(port int, access uint64) error {
+	if !l.initialized {
+		if err := l.Initialize(); err != nil {
+			return err
+		}
+	}
+	if l.netAccess&access == 0 {
+		return nil
+	}
+
+	attr := landlockNetPortAttr{
+		allowedAccess: access,
+		port:          uint64(port), //nolint:gosec // ports are 0-65535
+	}
+	_, _, errno := unix.Syscall(
+		unix.SYS_LANDLOCK_ADD_RULE,
+		uintptr(l.rulesetFd),
+		LANDLOCK_RULE_NET_PORT,
+		uintptr(unsafe.Pointer(&attr)), //nolint:gosec // required for syscall
+	)
+	if errno != 0 {
+		return fmt.Errorf("failed to add Landlock rule for port %d: %w", port, errno)
+	}
+
+	if l.debug {
+		logging.Debugf("landlock", "Added rule: port %d (access=0x%x)", port, access)
+	}
+
+	return nil
+}
+
 // AllowRead adds read access to a path.
 func (l *LandlockRuleset) AllowRead(path string) error {
 	return l.addPathRule(path, LANDLOCK_ACCESS_FS_READ_FILE|LANDLOCK_ACCESS_FS_READ_DIR|LANDLOCK_ACCESS_FS_EXECUTE)
@@ -308,7 +433,7 @@ func (l *LandlockRuleset) addPathRule(path string, access uint64) error {
 	// Check if path exists
 	if _, err := os.Stat(absPath); os.IsNotExist(err) {
 		if l.debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Skipping non-existent path: %s\n", absPath)
+			logging.Debugf("landlock", "Skipping non-existent path: %s", absPath)
 		}
 		return nil
 	}
@@ -317,7 +442,7 @@ func (l *LandlockRuleset) addPathRule(path string, access uint64) error {
 	fd, err := unix.Open(absPath, unix.O_PATH|unix.O_CLOEXEC, 0)
 	if err != nil {
 		if l.debug {
-			fmt.Fprintf(os.Stderr, "[fence:landlock] Failed to open path %s: %v\n", absPath, err)
+			logging.Debugf("landlock", "Failed to open path %s: %v", absPath, err)
 		}
 		return nil // Don't fail on paths we can't access
 	}
@@ -342,7 +467,7 @@ func (l *LandlockRuleset) addPathRule(path string, access uint64) error {
 	}
 
 	if l.debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Added rule: %s (access=0x%x)\n", absPath, access)
+		logging.Debugf("landlock", "Added rule: %s (access=0x%x)", absPath, access)
 	}
 
 	return nil
@@ -371,7 +496,7 @@ func (l *LandlockRuleset) Apply() error {
 	}
 
 	if l.debug {
-		fmt.Fprintf(os.Stderr, "[fence:landlock] Ruleset applied to process\n")
+		logging.Debugf("landlock", "Ruleset applied to process")
 	}
 
 	return nil
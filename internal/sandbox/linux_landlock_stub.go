@@ -5,7 +5,12 @@ package sandbox
 import "github.com/Use-Tusk/fence/internal/config"
 
 // ApplyLandlockFromConfig is a no-op on non-Linux platforms.
-func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []string, debug bool) error {
+func ApplyLandlockFromConfig(cfg *config.Config, cwd string, socketPaths []string, net *LandlockNetRules, debug bool) error {
+	return nil
+}
+
+// LandlockRules returns nil on non-Linux platforms.
+func LandlockRules(cfg *config.Config, cwd string, socketPaths []string) []LandlockRule {
 	return nil
 }
 
@@ -0,0 +1,76 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestLandlockNetRules(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name        string
+		network     config.NetworkConfig
+		proxy, dns  bool
+		exposed     []int
+		wantNil     bool
+		wantBind    []int
+		wantConnect []int
+	}{
+		{
+			name:        "strict",
+			proxy:       true,
+			exposed:     []int{3000},
+			wantBind:    []int{3000},
+			wantConnect: []int{3128, 1080},
+		},
+		{
+			name:        "strict with the DNS resolver",
+			proxy:       true,
+			dns:         true,
+			wantBind:    []int{},
+			wantConnect: []int{3128, 1080, 53},
+		},
+		{
+			name:    "local binding and outbound allowed",
+			network: config.NetworkConfig{AllowLocalBinding: true},
+			proxy:   true,
+			wantNil: true,
+		},
+		{
+			name:        "local binding without outbound",
+			network:     config.NetworkConfig{AllowLocalBinding: true, AllowLocalOutbound: &no},
+			proxy:       true,
+			wantConnect: []int{3128, 1080},
+		},
+		{
+			name:     "local outbound without binding",
+			network:  config.NetworkConfig{AllowLocalOutbound: &yes},
+			exposed:  []int{8080},
+			wantBind: []int{8080},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := landlockNetRules(&config.Config{Network: tt.network}, tt.proxy, tt.dns, tt.exposed)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("landlockNetRules() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("landlockNetRules() = nil")
+			}
+			if !slices.Equal(got.BindPorts, tt.wantBind) || (got.BindPorts == nil) != (tt.wantBind == nil) {
+				t.Errorf("BindPorts = %#v, want %#v", got.BindPorts, tt.wantBind)
+			}
+			if !slices.Equal(got.ConnectPorts, tt.wantConnect) || (got.ConnectPorts == nil) != (tt.wantConnect == nil) {
+				t.Errorf("ConnectPorts = %#v, want %#v", got.ConnectPorts, tt.wantConnect)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// With security.lsm, the command in a bwrap or native sandbox also runs
+// under an LSM, so a hole in the namespaces or mounts is not enough to
+// escape: under AppArmor, a profile granting what the mounts do (see
+// appArmorRules); under SELinux, an MCS level of its own, which keeps it from
+// the files and processes of other sandboxes and labeled containers.
+
+// mcsCategories is the number of MCS categories in the standard policies.
+const mcsCategories = 1024
+
+// lsmLayer is the LSM confinement inside the sandbox.
+type lsmLayer struct {
+	name string
+	// exec is the command prefix that runs a command under the layer.
+	exec []string
+	// profile is the AppArmor profile text.
+	profile string
+	// level is the SELinux MCS level.
+	level string
+}
+
+// newLSMLayer returns the layer for lsm, config.LSMAppArmor or
+// config.LSMSELinux, with the AppArmor profile derived from mountArgs, the
+// bwrap arguments before "--". In a dry run the profile is not loaded.
+func newLSMLayer(lsm string, mountArgs []string, profiles *appArmorProfiles, dryRun bool) (*lsmLayer, error) {
+	switch lsm {
+	case config.LSMAppArmor:
+		spec, err := parseNativeArgs(mountArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate AppArmor profile: %w", err)
+		}
+		rules, err := appArmorRules(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate AppArmor profile: %w", err)
+		}
+		name, profile := "fence", ""
+		if dryRun {
+			profile = formatAppArmorProfile(name, rules)
+		} else if name, profile, err = profiles.load(rules); err != nil {
+			return nil, fmt.Errorf("failed to load AppArmor profile: %w", err)
+		}
+		return &lsmLayer{name: lsm, exec: []string{"aa-exec", "-p", name, "--"}, profile: profile}, nil
+	case config.LSMSELinux:
+		level, err := randomMCSLevel()
+		if err != nil {
+			return nil, err
+		}
+		return &lsmLayer{name: lsm, exec: []string{"runcon", "-l", level, "--"}, level: level}, nil
+	}
+	return nil, nil
+}
+
+// String describes the layer for debug output.
+func (l *lsmLayer) String() string {
+	if l.level != "" {
+		return fmt.Sprintf("%s(%s)", l.name, l.level)
+	}
+	return l.name
+}
+
+// randomMCSLevel returns an s0 level with two distinct random categories,
+// as container engines assign, so the level is unlikely to be another's.
+func randomMCSLevel() (string, error) {
+	var c [2]int64
+	for c[0] == c[1] {
+		for i := range c {
+			n, err := rand.Int(rand.Reader, big.NewInt(mcsCategories))
+			if err != nil {
+				return "", fmt.Errorf("failed to pick MCS categories: %w", err)
+			}
+			c[i] = n.Int64()
+		}
+	}
+	return mcsLevel(c[0], c[1]), nil
+}
+
+// mcsLevel formats the level with categories a and b, which SELinux expects
+// in ascending order.
+func mcsLevel(a, b int64) string {
+	return fmt.Sprintf("s0:c%d,c%d", min(a, b), max(a, b))
+}
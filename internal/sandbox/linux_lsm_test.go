@@ -0,0 +1,109 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestResolveLSM(t *testing.T) {
+	apparmor := LinuxFeatures{HasAppArmor: true, HasCapRoot: true}
+	selinux := LinuxFeatures{HasSELinux: true}
+	tests := []struct {
+		name     string
+		features LinuxFeatures
+		setting  string
+		want     string
+		wantErr  bool
+	}{
+		{"unset", apparmor, "", "", false},
+		{"auto picks apparmor", LinuxFeatures{HasAppArmor: true, HasSELinux: true, HasCapRoot: true}, config.LSMAuto, config.LSMAppArmor, false},
+		{"auto falls back to selinux", selinux, config.LSMAuto, config.LSMSELinux, false},
+		{"auto without root skips apparmor", LinuxFeatures{HasAppArmor: true}, config.LSMAuto, "", false},
+		{"auto with neither", LinuxFeatures{}, config.LSMAuto, "", false},
+		{"explicit apparmor", apparmor, config.LSMAppArmor, config.LSMAppArmor, false},
+		{"apparmor needs root", LinuxFeatures{HasAppArmor: true}, config.LSMAppArmor, "", true},
+		{"apparmor unavailable", selinux, config.LSMAppArmor, "", true},
+		{"explicit selinux without root", selinux, config.LSMSELinux, config.LSMSELinux, false},
+		{"selinux unavailable", apparmor, config.LSMSELinux, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.features.ResolveLSM(tt.setting)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveLSM(%q) error = %v, wantErr %v", tt.setting, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveLSM(%q) = %q, want %q", tt.setting, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomMCSLevel(t *testing.T) {
+	pattern := regexp.MustCompile(`^s0:c(\d+),c(\d+)$`)
+	for range 100 {
+		level, err := randomMCSLevel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := pattern.FindStringSubmatch(level)
+		if m == nil {
+			t.Fatalf("level %q is not s0 with two categories", level)
+		}
+		if m[1] == m[2] || len(m[1]) > 4 || len(m[2]) > 4 {
+			t.Fatalf("level %q should have two distinct categories below %d", level, mcsCategories)
+		}
+	}
+	if got := mcsLevel(700, 12); got != "s0:c12,c700" {
+		t.Errorf("mcsLevel(700, 12) = %q, want the categories in order", got)
+	}
+}
+
+// With security.lsm, the bwrap sandbox runs the command under the LSM from
+// its inner script.
+func TestLSMLayerSpec(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"/tmp"}
+	cfg.Security.LSM = config.LSMAppArmor
+
+	spec, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if spec.BwrapArgs[0] != "bwrap" {
+		t.Errorf("args start with %q, want bwrap", spec.BwrapArgs[0])
+	}
+	script := spec.BwrapArgs[len(spec.BwrapArgs)-1]
+	if !strings.Contains(script, "aa-exec -p fence -- bash -c 'echo hello'") {
+		t.Errorf("inner script does not run the command under aa-exec:\n%s", script)
+	}
+	if spec.LSM != config.LSMAppArmor || !strings.Contains(spec.AppArmorProfile, `"/tmp{,/**}" rwlk,`) {
+		t.Errorf("LSM = %q, profile:\n%s", spec.LSM, spec.AppArmorProfile)
+	}
+
+	cfg.Security.LSM = config.LSMSELinux
+	spec, err = LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	script = spec.BwrapArgs[len(spec.BwrapArgs)-1]
+	if spec.SELinuxLevel == "" || !strings.Contains(script, "runcon -l "+spec.SELinuxLevel+" -- bash -c 'echo hello'") {
+		t.Errorf("inner script does not run the command at level %q:\n%s", spec.SELinuxLevel, script)
+	}
+
+	// The apparmor backend already confines the command
+	cfg.Security.LSM = config.LSMAppArmor
+	spec, err = LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendAppArmor})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if spec.LSM != "" || strings.Count(strings.Join(spec.BwrapArgs, " "), "aa-exec") != 1 {
+		t.Errorf("LSM = %q, args %q; want no layer with the apparmor backend", spec.LSM, spec.BwrapArgs)
+	}
+}
@@ -0,0 +1,599 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// The native backend interprets the subset of bwrap arguments that
+// buildLinuxSandbox generates, so both backends enforce the same rules:
+//
+//  1. fence --native-sandbox ARGS parses ARGS and re-executes fence with
+//     --native-sandbox-init in new user, mount, PID, and (optionally) network
+//     namespaces, as root of the new user namespace.
+//  2. The init process builds the new root: it moves the old root aside,
+//     applies the mounts in order, pivots into the result, brings up the
+//     loopback interface, and installs the seccomp filter.
+//  3. It starts the command in a nested user namespace that maps the caller's
+//     uid (or --uid), so the command holds no capabilities over the mounts,
+//     and reaps processes as PID 1 until the command exits.
+
+// nativeMount is a mount operation, applied in argument order.
+type nativeMount struct {
+	kind string // "bind", "ro-bind", "dev-bind", "tmpfs", "proc", "dir", or "overlay"
+	src  string // Empty for tmpfs, proc, dir, and overlay
+	dest string
+	// For overlay: the lower directories, bottom first, and the upper and
+	// work directories
+	lowers      []string
+	upper, work string
+}
+
+// nativeSpec is a parsed native sandbox invocation.
+type nativeSpec struct {
+	unshareNet    bool
+	newSession    bool
+	dieWithParent bool
+	uid, gid      int // Identity of the command; -1 keeps the caller's
+	seccompFD     int // fd holding a BPF filter; -1 for none
+	mounts        []nativeMount
+	command       []string
+}
+
+// parseNativeArgs parses bwrap-style arguments, without the leading "bwrap".
+func parseNativeArgs(args []string) (*nativeSpec, error) {
+	spec := &nativeSpec{uid: -1, gid: -1, seccompFD: -1}
+
+	need := func(i, n int) error {
+		if i+n >= len(args) {
+			return fmt.Errorf("%s needs %d argument(s)", args[i], n)
+		}
+		return nil
+	}
+	atoi := func(i int) (int, error) {
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s: invalid value %q", args[i], args[i+1])
+		}
+		return n, nil
+	}
+
+	var overlaySrcs []string // Pending lower directories for --overlay
+	for i := 0; i < len(args); i++ {
+		var err error
+		switch arg := args[i]; arg {
+		case "--":
+			spec.command = args[i+1:]
+			i = len(args)
+		case "--new-session":
+			spec.newSession = true
+		case "--die-with-parent":
+			spec.dieWithParent = true
+		case "--unshare-net":
+			spec.unshareNet = true
+		case "--unshare-pid", "--unshare-user":
+			// Always done
+		case "--uid", "--gid", "--seccomp":
+			if err = need(i, 1); err != nil {
+				return nil, err
+			}
+			var n int
+			if n, err = atoi(i); err != nil {
+				return nil, err
+			}
+			switch arg {
+			case "--uid":
+				spec.uid = n
+			case "--gid":
+				spec.gid = n
+			default:
+				spec.seccompFD = n
+			}
+			i++
+		case "--bind", "--ro-bind", "--dev-bind":
+			if err = need(i, 2); err != nil {
+				return nil, err
+			}
+			spec.mounts = append(spec.mounts, nativeMount{kind: strings.TrimPrefix(arg, "--"), src: args[i+1], dest: args[i+2]})
+			i += 2
+		case "--overlay-src":
+			if err = need(i, 1); err != nil {
+				return nil, err
+			}
+			overlaySrcs = append(overlaySrcs, args[i+1])
+			i++
+		case "--overlay":
+			if err = need(i, 3); err != nil {
+				return nil, err
+			}
+			if len(overlaySrcs) == 0 {
+				return nil, errors.New("--overlay needs --overlay-src")
+			}
+			spec.mounts = append(spec.mounts, nativeMount{kind: "overlay", dest: args[i+3], lowers: overlaySrcs, upper: args[i+1], work: args[i+2]})
+			overlaySrcs = nil
+			i += 3
+		case "--tmpfs", "--proc", "--dir":
+			if err = need(i, 1); err != nil {
+				return nil, err
+			}
+			spec.mounts = append(spec.mounts, nativeMount{kind: strings.TrimPrefix(arg, "--"), dest: args[i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("unsupported argument %q", arg)
+		}
+	}
+
+	if len(spec.command) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	if len(overlaySrcs) > 0 {
+		return nil, errors.New("--overlay-src without --overlay")
+	}
+	for _, m := range spec.mounts {
+		paths := append([]string{m.dest, m.src, m.upper, m.work}, m.lowers...)
+		for _, p := range paths {
+			if p != "" && !filepath.IsAbs(p) {
+				return nil, fmt.Errorf("--%s: paths must be absolute", m.kind)
+			}
+		}
+		if m.kind == "overlay" && slices.ContainsFunc(paths, func(p string) bool { return strings.ContainsAny(p, ",:") }) {
+			return nil, fmt.Errorf("--overlay %s: paths cannot contain ',' or ':'", m.dest)
+		}
+	}
+	return spec, nil
+}
+
+// RunNativeSandbox runs the command described by bwrap-style args in new
+// namespaces and returns its exit code. It is fence --native-sandbox.
+func RunNativeSandbox(args []string) int {
+	spec, err := parseNativeArgs(args)
+	if err != nil {
+		logging.Errorf("native", "%v", err)
+		return 1
+	}
+
+	// The mapping makes the init process root of its user namespace, so it
+	// keeps its capabilities there across exec
+	uid, gid := os.Getuid(), os.Getgid()
+	initArgs := append([]string{NativeSandboxInitFlag, strconv.Itoa(uid), strconv.Itoa(gid)}, args...)
+	cmd := exec.Command("/proc/self/exe", initArgs...) //nolint:gosec // re-executes fence itself
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if spec.unshareNet {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}},
+		Setsid:      spec.newSession,
+	}
+	if spec.dieWithParent {
+		// Pdeathsig fires when the creating thread exits, so stay on it
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+		_ = unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(syscall.SIGKILL), 0, 0, 0)
+	}
+	if spec.seccompFD >= 3 {
+		// Pass the filter on the same fd number
+		cmd.ExtraFiles = make([]*os.File, spec.seccompFD-2)
+		cmd.ExtraFiles[spec.seccompFD-3] = os.NewFile(uintptr(spec.seccompFD), "seccomp")
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	if err := cmd.Start(); err != nil {
+		logging.Errorf("native", "failed to create namespaces: %v", err)
+		return 1
+	}
+	go func() {
+		for sig := range sigChan {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+	return exitCode(cmd.Wait())
+}
+
+// RunNativeSandboxInit is the init process of a native sandbox, started by
+// RunNativeSandbox as fence --native-sandbox-init UID GID ARGS.
+func RunNativeSandboxInit(args []string) int {
+	fail := func(format string, a ...interface{}) int {
+		logging.Errorf("native", format, a...)
+		return 1
+	}
+	if len(args) < 2 {
+		return fail("missing caller uid/gid")
+	}
+	hostUID, err1 := strconv.Atoi(args[0])
+	hostGID, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil {
+		return fail("invalid caller uid/gid")
+	}
+	spec, err := parseNativeArgs(args[2:])
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// Mount and namespace state is per thread until exec
+	runtime.LockOSThread()
+
+	cwd, _ := os.Getwd()
+	if err := buildNativeRoot(spec); err != nil {
+		return fail("%v", err)
+	}
+	if spec.unshareNet {
+		if err := bringUpLoopback(); err != nil {
+			return fail("failed to bring up loopback: %v", err)
+		}
+	}
+	if err := os.Chdir(cwd); err != nil {
+		_ = os.Chdir("/")
+	}
+	if spec.seccompFD >= 0 {
+		if err := installSeccompFilter(spec.seccompFD); err != nil {
+			return fail("failed to install seccomp filter: %v", err)
+		}
+	}
+
+	uid, gid := spec.uid, spec.gid
+	if uid < 0 {
+		uid = hostUID
+	}
+	if gid < 0 {
+		gid = hostGID
+	}
+
+	cmd := exec.Command(spec.command[0], spec.command[1:]...) //nolint:gosec // the sandboxed command
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: 0, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: 0, Size: 1}},
+	}
+	if err := cmd.Start(); err != nil {
+		return fail("failed to start %s: %v", spec.command[0], err)
+	}
+
+	// Forward signals to the command; as PID 1 we would otherwise ignore them
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for sig := range sigChan {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	// Reap everything; the sandbox ends when the command does
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fail("wait: %v", err)
+		}
+		if pid != cmd.Process.Pid {
+			continue
+		}
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+}
+
+// buildNativeRoot replaces the root filesystem with one built from spec's
+// mounts, bwrap style: a tmpfs becomes the root with the old root at
+// /oldroot, the mounts are applied under /newroot, and /newroot becomes the
+// root with the old one detached.
+func buildNativeRoot(spec *nativeSpec) error {
+	// Resolve symlinks while the host tree is still the root, so that
+	// absolute link targets resolve as they do on the host
+	mounts := make([]nativeMount, len(spec.mounts))
+	for i, m := range spec.mounts {
+		m.dest = resolvePath(m.dest)
+		if m.src != "" {
+			m.src = resolvePath(m.src)
+		}
+		mounts[i] = m
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make / private: %w", err)
+	}
+	base := "/tmp"
+	if err := unix.Mount("tmpfs", base, "tmpfs", unix.MS_NODEV|unix.MS_NOSUID, "mode=0755"); err != nil {
+		return fmt.Errorf("mount base tmpfs: %w", err)
+	}
+	for _, dir := range []string{"newroot", "oldroot"} {
+		if err := os.Mkdir(filepath.Join(base, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	if err := unix.PivotRoot(base, filepath.Join(base, "oldroot")); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Mount("/newroot", "/newroot", "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind /newroot: %w", err)
+	}
+
+	for _, m := range mounts {
+		if err := applyNativeMount(m); err != nil {
+			return fmt.Errorf("--%s %s: %w", m.kind, m.dest, err)
+		}
+	}
+
+	if err := os.Chdir("/newroot"); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root into new root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	return os.Chdir("/")
+}
+
+// applyNativeMount applies m inside /newroot, taking sources from /oldroot.
+func applyNativeMount(m nativeMount) error {
+	dest := filepath.Join("/newroot", m.dest)
+
+	switch m.kind {
+	case "tmpfs":
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return err
+		}
+		return unix.Mount("tmpfs", dest, "tmpfs", unix.MS_NODEV|unix.MS_NOSUID, "mode=0755")
+	case "proc":
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return err
+		}
+		return unix.Mount("proc", dest, "proc", unix.MS_NODEV|unix.MS_NOSUID|unix.MS_NOEXEC, "")
+	case "dir":
+		return os.MkdirAll(dest, 0o755)
+	case "overlay":
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return err
+		}
+		// overlayfs lists the lower directories top first; userxattr lets it
+		// keep whiteouts without privileges over the host
+		lowers := make([]string, len(m.lowers))
+		for i, l := range m.lowers {
+			lowers[len(lowers)-1-i] = filepath.Join("/oldroot", l)
+		}
+		data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+			strings.Join(lowers, ":"), filepath.Join("/oldroot", m.upper), filepath.Join("/oldroot", m.work))
+		return unix.Mount("overlay", dest, "overlay", unix.MS_NODEV|unix.MS_NOSUID, data)
+	}
+
+	src := filepath.Join("/oldroot", m.src)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := ensureMountPoint(dest, info.IsDir()); err != nil {
+		return err
+	}
+	if err := unix.Mount(src, dest, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	if m.kind == "ro-bind" {
+		return remountReadOnly(dest)
+	}
+	return nil
+}
+
+// ensureMountPoint creates dest as a directory or empty file if it does not exist.
+func ensureMountPoint(dest string, dir bool) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return nil
+	}
+	if dir {
+		return os.MkdirAll(dest, 0o755)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0o644) //nolint:gosec // mount point inside the new root
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// remountReadOnly makes the bind mount at dest and every mount below it
+// read-only. Flags the kernel locks in a user namespace (nosuid, nodev, ...)
+// must be kept, or the remount fails.
+func remountReadOnly(dest string) error {
+	points, err := mountPointsUnder(dest)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		var st unix.Statfs_t
+		if err := unix.Statfs(p, &st); err != nil {
+			continue // Unreachable or vanished mount (e.g. autofs)
+		}
+		flags := uintptr(unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY) | statfsMountFlags(st.Flags)
+		if err := unix.Mount("", p, "", flags, ""); err != nil {
+			// /proc and /sys submounts may refuse; fence mounts a new /proc
+			// and nothing below them is writable by the command anyway
+			rel := strings.TrimPrefix(p, "/newroot")
+			if p != dest && (strings.HasPrefix(rel, "/proc/") || strings.HasPrefix(rel, "/sys/")) {
+				continue
+			}
+			return fmt.Errorf("remount %s read-only: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// statfsMountFlags converts statfs flags to the mount flags to keep on remount.
+func statfsMountFlags(f int64) uintptr {
+	var flags uintptr
+	for st, ms := range map[int64]uintptr{
+		unix.ST_NOSUID:      unix.MS_NOSUID,
+		unix.ST_NODEV:       unix.MS_NODEV,
+		unix.ST_NOEXEC:      unix.MS_NOEXEC,
+		unix.ST_NOATIME:     unix.MS_NOATIME,
+		unix.ST_NODIRATIME:  unix.MS_NODIRATIME,
+		unix.ST_RELATIME:    unix.MS_RELATIME,
+		unix.ST_SYNCHRONOUS: unix.MS_SYNCHRONOUS,
+	} {
+		if f&st != 0 {
+			flags |= ms
+		}
+	}
+	return flags
+}
+
+// mountPointsUnder returns dest and the mount points below it, parents first.
+func mountPointsUnder(dest string) ([]string, error) {
+	// /proc of the old root still describes this process's mounts
+	f, err := os.Open("/oldroot/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	points := []string{dest}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		p := unescapeMountPath(fields[4])
+		if strings.HasPrefix(p, dest+"/") {
+			points = append(points, p)
+		}
+	}
+	return points, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 etc.) in mountinfo paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// resolvePath resolves symlinks in path, or in its longest existing prefix.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	dir, base := filepath.Split(filepath.Clean(path))
+	if dir == "/" || dir == "" {
+		return path
+	}
+	return filepath.Join(resolvePath(filepath.Clean(dir)), base)
+}
+
+// bringUpLoopback sets the lo interface up in a new network namespace.
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// installSeccompFilter installs the BPF program read from fd on every thread
+// of this process, so the command inherits it.
+func installSeccompFilter(fd int) error {
+	f := os.NewFile(uintptr(fd), "seccomp")
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	filter, err := decodeSeccompFilter(data)
+	if err != nil {
+		return err
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]} //nolint:gosec // decodeSeccompFilter limits the length
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// decodeSeccompFilter decodes a BPF program written as an array of struct
+// sock_filter in the machine's byte order.
+func decodeSeccompFilter(data []byte) ([]unix.SockFilter, error) {
+	const insnSize = int(unsafe.Sizeof(unix.SockFilter{}))
+	if len(data) == 0 || len(data)%insnSize != 0 || len(data)/insnSize > bpfMaxInstructions {
+		return nil, fmt.Errorf("invalid filter size %d", len(data))
+	}
+	filter := make([]unix.SockFilter, len(data)/insnSize)
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&filter[0])), len(data)), data)
+	return filter, nil
+}
+
+// exitCode converts the result of exec.Cmd.Wait to an exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// RunNativeSandbox is not available on non-Linux platforms.
+func RunNativeSandbox(args []string) int {
+	logging.Errorf("native", "the native backend is only available on Linux")
+	return 1
+}
+
+// RunNativeSandboxInit is not available on non-Linux platforms.
+func RunNativeSandboxInit(args []string) int {
+	return RunNativeSandbox(args)
+}
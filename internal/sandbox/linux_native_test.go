@@ -0,0 +1,135 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestParseNativeArgs(t *testing.T) {
+	args := []string{
+		"--new-session", "--die-with-parent", "--unshare-net", "--unshare-pid",
+		"--unshare-user", "--uid", "65534", "--gid", "65534", "--seccomp", "3",
+		"--ro-bind", "/", "/", "--dev-bind", "/dev", "/dev", "--proc", "/proc",
+		"--tmpfs", "/tmp", "--tmpfs", "/home/u", "--dir", "/home/u/.cache",
+		"--bind", "/home/u/project", "/home/u/project",
+		"--overlay-src", "/home/u/project", "--overlay", "/cow/upper", "/cow/work", "/home/u/project",
+		"--", "/bin/bash", "-c", "echo hi",
+	}
+
+	spec, err := parseNativeArgs(args)
+	if err != nil {
+		t.Fatalf("parseNativeArgs() error = %v", err)
+	}
+	if !spec.newSession || !spec.dieWithParent || !spec.unshareNet {
+		t.Error("expected --new-session, --die-with-parent, and --unshare-net to be set")
+	}
+	if spec.uid != 65534 || spec.gid != 65534 || spec.seccompFD != 3 {
+		t.Errorf("uid, gid, seccompFD = %d, %d, %d", spec.uid, spec.gid, spec.seccompFD)
+	}
+	wantMounts := []nativeMount{
+		{kind: "ro-bind", src: "/", dest: "/"},
+		{kind: "dev-bind", src: "/dev", dest: "/dev"},
+		{kind: "proc", dest: "/proc"},
+		{kind: "tmpfs", dest: "/tmp"},
+		{kind: "tmpfs", dest: "/home/u"},
+		{kind: "dir", dest: "/home/u/.cache"},
+		{kind: "bind", src: "/home/u/project", dest: "/home/u/project"},
+		{kind: "overlay", dest: "/home/u/project", lowers: []string{"/home/u/project"}, upper: "/cow/upper", work: "/cow/work"},
+	}
+	if !reflect.DeepEqual(spec.mounts, wantMounts) {
+		t.Errorf("mounts = %+v, want %+v", spec.mounts, wantMounts)
+	}
+	if want := []string{"/bin/bash", "-c", "echo hi"}; !slices.Equal(spec.command, want) {
+		t.Errorf("command = %q, want %q", spec.command, want)
+	}
+}
+
+func TestParseNativeArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", []string{"--unshare-pid"}},
+		{"empty command", []string{"--unshare-pid", "--"}},
+		{"unknown option", []string{"--cap-add", "ALL", "--", "true"}},
+		{"missing bind dest", []string{"--bind", "/a"}},
+		{"relative path", []string{"--bind", "a", "/a", "--", "true"}},
+		{"invalid uid", []string{"--uid", "nobody", "--", "true"}},
+		{"overlay without source", []string{"--overlay", "/u", "/w", "/a", "--", "true"}},
+		{"unused overlay source", []string{"--overlay-src", "/a", "--", "true"}},
+		{"overlay path with comma", []string{"--overlay-src", "/a,b", "--overlay", "/u", "/w", "/a", "--", "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseNativeArgs(tt.args); err == nil {
+				t.Errorf("parseNativeArgs(%q) should fail", tt.args)
+			}
+		})
+	}
+}
+
+// The native backend must understand every argument fence generates for bwrap.
+func TestNativeBackendParsesGeneratedArgs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"."}
+	cfg.Security.MapToNobody = true
+	bridge := &LinuxBridge{
+		HTTPSocketPath:  "/tmp/fence-http-test.sock",
+		SOCKSSocketPath: "/tmp/fence-socks-test.sock",
+	}
+
+	overlay := &WorkspaceOverlay{Dir: t.TempDir(), Upper: "/cow/upper", Work: "/cow/work"}
+	spec, err := LinuxSpec(cfg, "echo hello", bridge, nil, LinuxSandboxOptions{UseSeccomp: true, Backend: BackendNative, Overlay: overlay})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if len(spec.BwrapArgs) < 2 || spec.BwrapArgs[1] != NativeSandboxFlag {
+		t.Fatalf("args should run fence %s, got %q", NativeSandboxFlag, spec.BwrapArgs[:2])
+	}
+	if _, err := parseNativeArgs(spec.BwrapArgs[2:]); err != nil {
+		t.Errorf("parseNativeArgs() rejected generated args: %v", err)
+	}
+}
+
+func TestLinuxSpecOverlay(t *testing.T) {
+	overlay := &WorkspaceOverlay{Dir: t.TempDir(), Upper: "/cow/upper", Work: "/cow/work"}
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{overlay.Dir}
+
+	spec, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendBwrap, Overlay: overlay})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	want := []string{"--overlay-src", overlay.Dir, "--overlay", overlay.Upper, overlay.Work, overlay.Dir}
+	overlayAt := slices.Index(spec.BwrapArgs, "--overlay-src")
+	if overlayAt < 0 || !slices.Equal(spec.BwrapArgs[overlayAt:overlayAt+len(want)], want) {
+		t.Fatalf("args %q should mount the overlay", spec.BwrapArgs)
+	}
+	// The workspace's writable bind must not cover the overlay
+	if bindAt := slices.Index(spec.BwrapArgs, "--bind"); bindAt < 0 || bindAt > overlayAt {
+		t.Errorf("args %q should bind the workspace before the overlay", spec.BwrapArgs)
+	}
+
+	if _, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendGVisor, Overlay: overlay}); err == nil {
+		t.Error("LinuxSpec() should reject an overlay with the gvisor backend")
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	tests := map[string]string{
+		"/home/u":              "/home/u",
+		`/mnt/my\040disk`:      "/mnt/my disk",
+		`/mnt/tab\011and\134x`: "/mnt/tab\tand\\x",
+	}
+	for in, want := range tests {
+		if got := unescapeMountPath(in); got != want {
+			t.Errorf("unescapeMountPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
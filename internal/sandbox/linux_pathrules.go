@@ -0,0 +1,86 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// The apparmor and selinux backends confine the command in the host's
+// namespaces, so the mounts bwrap would set up become access rules instead:
+//
+//   - --ro-bind / / becomes the baseline: read and execute anything.
+//   - Writable binds (and the /tmp and /dev mounts) grant write access.
+//   - Read-only binds of a path over itself deny writes; they come from
+//     denyWrite and the mandatory deny paths.
+//   - Paths masked with /dev/null or an empty tmpfs are denied entirely, and
+//     so are the destinations of binds from elsewhere (such as the D-Bus
+//     proxy sockets), since there is no mount namespace to redirect them.
+
+// pathAccess is the access a path rule grants.
+type pathAccess int
+
+const (
+	pathWritable pathAccess = iota
+	pathReadOnly
+	pathHidden
+)
+
+func (a pathAccess) String() string {
+	switch a {
+	case pathWritable:
+		return "writable"
+	case pathReadOnly:
+		return "read-only"
+	default:
+		return "hidden"
+	}
+}
+
+// pathRule is the access the sandbox has to a path and everything beneath it.
+type pathRule struct {
+	path   string
+	access pathAccess
+}
+
+// pathRules returns the path rules equivalent to the mounts in spec, in
+// mount order and without duplicates.
+func pathRules(spec *nativeSpec) ([]pathRule, error) {
+	var rules []pathRule
+	add := func(path string, access pathAccess) {
+		if r := (pathRule{path: path, access: access}); !slices.Contains(rules, r) {
+			rules = append(rules, r)
+		}
+	}
+
+	hasRoot := false
+	for _, m := range spec.mounts {
+		switch {
+		case m.dest == "/":
+			if m.kind != "ro-bind" || m.src != "/" {
+				return nil, fmt.Errorf("--%s %s /: only a read-only root is supported", m.kind, m.src)
+			}
+			hasRoot = true
+		case m.kind == "proc", m.kind == "dir":
+			// Readable through the root, or created inside a tmpfs
+		case m.kind == "tmpfs" && m.dest == "/tmp":
+			// Shared with the host rather than private
+			add(m.dest, pathWritable)
+		case m.kind == "overlay":
+			// Writes go to the overlay's upper directory
+			add(m.dest, pathWritable)
+		case m.kind == "tmpfs", m.src != m.dest:
+			add(m.dest, pathHidden)
+		case m.kind == "ro-bind":
+			add(m.dest, pathReadOnly)
+		default: // bind or dev-bind of a path over itself
+			add(m.dest, pathWritable)
+		}
+	}
+	if !hasRoot {
+		return nil, errors.New("no root mount (--ro-bind / /) specified")
+	}
+	return rules, nil
+}
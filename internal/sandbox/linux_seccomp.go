@@ -3,21 +3,32 @@
 package sandbox
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
 	"golang.org/x/sys/unix"
 )
 
+//go:generate go run mksysnum.go
+
 // SeccompFilter generates and manages seccomp BPF filters.
 type SeccompFilter struct {
-	debug bool
+	cfg    config.SeccompConfig
+	policy seccompPolicy
+	debug  bool
 }
 
-// NewSeccompFilter creates a new seccomp filter generator.
-func NewSeccompFilter(debug bool) *SeccompFilter {
-	return &SeccompFilter{debug: debug}
+// NewSeccompFilter creates a new seccomp filter generator for the policy
+// cfg describes.
+func NewSeccompFilter(cfg config.SeccompConfig, debug bool) *SeccompFilter {
+	return &SeccompFilter{cfg: cfg, policy: newSeccompPolicy(cfg), debug: debug}
 }
 
 // DangerousSyscalls lists syscalls that should be blocked for security.
@@ -51,6 +62,151 @@ var DangerousSyscalls = []string{
 	"iopl",              // I/O privilege level
 }
 
+// StrictSyscalls lists the syscalls the strict profile allows: those
+// common programs, language runtimes, and fence itself inside the sandbox
+// need. Names an architecture does not have are skipped.
+var StrictSyscalls = []string{
+	// Files and directories
+	"access", "chdir", "chmod", "chown", "chown32", "close", "close_range", "copy_file_range",
+	"creat", "dup", "dup2", "dup3", "faccessat", "faccessat2", "fadvise64", "fadvise64_64",
+	"arm_fadvise64_64", "fallocate", "fchdir", "fchmod", "fchmodat", "fchmodat2", "fchown",
+	"fchown32", "fchownat", "fcntl", "fcntl64", "fdatasync", "fgetxattr", "flistxattr",
+	"flock", "fremovexattr", "fsetxattr", "fstat", "fstat64", "fstatat64", "fstatfs",
+	"fstatfs64", "fsync", "ftruncate", "ftruncate64", "getcwd", "getdents", "getdents64",
+	"getxattr", "inotify_add_watch", "inotify_init", "inotify_init1", "inotify_rm_watch",
+	"lchown", "lchown32", "lgetxattr", "link", "linkat", "listxattr", "llistxattr", "lremovexattr",
+	"lseek", "_llseek", "lsetxattr", "lstat", "lstat64", "mkdir", "mkdirat", "mknod", "mknodat",
+	"newfstatat", "open", "openat", "openat2", "pread64", "preadv", "preadv2", "pwrite64",
+	"pwritev", "pwritev2", "read", "readahead", "readlink", "readlinkat", "readv", "removexattr",
+	"rename", "renameat", "renameat2", "rmdir", "sendfile", "sendfile64", "setxattr", "splice",
+	"stat", "stat64", "statfs", "statfs64", "statx", "symlink", "symlinkat", "sync",
+	"sync_file_range", "sync_file_range2", "arm_sync_file_range", "syncfs", "tee", "truncate",
+	"truncate64", "umask", "unlink", "unlinkat", "utime", "utimensat", "utimensat_time64",
+	"utimes", "futimesat", "vmsplice", "write", "writev", "cachestat",
+	// Polling and events
+	"epoll_create", "epoll_create1", "epoll_ctl", "epoll_ctl_old", "epoll_pwait", "epoll_pwait2",
+	"epoll_wait", "epoll_wait_old", "eventfd", "eventfd2", "poll", "ppoll", "ppoll_time64",
+	"pselect6", "pselect6_time64", "select", "_newselect", "signalfd", "signalfd4",
+	"timerfd_create", "timerfd_gettime", "timerfd_gettime64", "timerfd_settime",
+	"timerfd_settime64", "io_cancel", "io_destroy", "io_getevents", "io_pgetevents",
+	"io_pgetevents_time64", "io_setup", "io_submit",
+	// Memory
+	"brk", "get_mempolicy", "madvise", "membarrier", "memfd_create", "mincore", "mlock",
+	"mlock2", "mlockall", "mmap", "mmap2", "mprotect", "mremap", "msync", "munlock",
+	"munlockall", "munmap", "pkey_alloc", "pkey_free", "pkey_mprotect", "remap_file_pages",
+	// Processes, threads, and signals
+	"arch_prctl", "capget", "capset", "clone", "clone3", "execve", "execveat", "exit",
+	"exit_group", "fork", "futex", "futex_time64", "futex_waitv", "futex_wait", "futex_wake",
+	"futex_requeue", "get_robust_list", "get_thread_area", "getegid", "getegid32", "geteuid",
+	"geteuid32", "getgid", "getgid32", "getgroups", "getgroups32", "getitimer", "getpgid",
+	"getpgrp", "getpid", "getppid", "getpriority", "getrandom", "getresgid", "getresgid32",
+	"getresuid", "getresuid32", "getrlimit", "ugetrlimit", "getrusage", "getsid", "gettid",
+	"getuid", "getuid32", "ioprio_get", "ioprio_set", "kill", "pause", "pidfd_open",
+	"pidfd_send_signal", "prctl", "prlimit64", "rseq", "rt_sigaction", "rt_sigpending",
+	"rt_sigprocmask", "rt_sigqueueinfo", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+	"rt_sigtimedwait_time64", "rt_tgsigqueueinfo", "sched_get_priority_max",
+	"sched_get_priority_min", "sched_getaffinity", "sched_getattr", "sched_getparam",
+	"sched_getscheduler", "sched_rr_get_interval", "sched_rr_get_interval_time64",
+	"sched_setaffinity", "sched_setattr", "sched_setparam", "sched_setscheduler", "sched_yield",
+	"set_robust_list", "set_thread_area", "set_tid_address", "setfsgid", "setfsgid32",
+	"setfsuid", "setfsuid32", "setgid", "setgid32", "setgroups", "setgroups32", "setitimer",
+	"setpgid", "setpriority", "setregid", "setregid32", "setresgid", "setresgid32", "setresuid",
+	"setresuid32", "setreuid", "setreuid32", "setrlimit", "setsid", "setuid", "setuid32",
+	"sigaction", "sigaltstack", "signal", "sigpending", "sigprocmask", "sigreturn", "sigsuspend",
+	"tgkill", "tkill", "vfork", "wait4", "waitid", "waitpid", "cacheflush", "set_tls",
+	"riscv_flush_icache", "riscv_hwprobe", "s390_guarded_storage", "s390_runtime_instr",
+	// Time
+	"alarm", "clock_getres", "clock_getres_time64", "clock_gettime", "clock_gettime64",
+	"clock_nanosleep", "clock_nanosleep_time64", "gettimeofday", "nanosleep", "time",
+	"timer_create", "timer_delete", "timer_getoverrun", "timer_gettime", "timer_gettime64",
+	"timer_settime", "timer_settime64", "times",
+	// Sockets; the calls socketcall multiplexes are allowed with them
+	"accept", "accept4", "bind", "connect", "getpeername", "getsockname", "getsockopt",
+	"listen", "recv", "recvfrom", "recvmmsg", "recvmmsg_time64", "recvmsg", "send", "sendmmsg",
+	"sendmsg", "sendto", "setsockopt", "shutdown", "socket", "socketpair",
+	// IPC
+	"ipc", "mq_getsetattr", "mq_notify", "mq_open", "mq_timedreceive", "mq_timedreceive_time64",
+	"mq_timedsend", "mq_timedsend_time64", "mq_unlink", "msgctl", "msgget", "msgrcv", "msgsnd",
+	"pipe", "pipe2", "semctl", "semget", "semop", "semtimedop", "semtimedop_time64", "shmat",
+	"shmctl", "shmdt", "shmget",
+	// System information and sandboxing
+	"getcpu", "ioctl", "landlock_add_rule", "landlock_create_ruleset", "landlock_restrict_self",
+	"seccomp", "sysinfo", "uname", "olduname", "oldolduname",
+}
+
+// armPrivateSyscalls are the ARM-specific syscalls, which the generated
+// tables do not have.
+var armPrivateSyscalls = map[string]uint32{
+	"breakpoint": 0x0f0001,
+	"cacheflush": 0x0f0002,
+	"set_tls":    0x0f0005,
+	"get_tls":    0x0f0006,
+}
+
+// seccompPolicy is the filter a seccomp config asks for.
+type seccompPolicy struct {
+	// syscalls are the syscalls blocked, or with allowlist the only ones
+	// allowed
+	syscalls  []string
+	allowlist bool
+	action    string // A config.SeccompAction* value
+}
+
+// newSeccompPolicy returns the policy cfg describes. Block wins over allow.
+func newSeccompPolicy(cfg config.SeccompConfig) seccompPolicy {
+	p := seccompPolicy{action: cfg.Action}
+	if p.action == "" {
+		p.action = config.SeccompActionErrno
+	}
+	if cfg.Profile == config.SeccompProfileStrict {
+		p.allowlist = true
+		p.syscalls = withoutSyscalls(appendSyscalls(StrictSyscalls, cfg.Allow), cfg.Block)
+	} else {
+		p.syscalls = appendSyscalls(withoutSyscalls(DangerousSyscalls, cfg.Allow), cfg.Block)
+	}
+	return p
+}
+
+// appendSyscalls returns names with the syscalls in add it does not have
+// appended.
+func appendSyscalls(names, add []string) []string {
+	names = slices.Clone(names)
+	for _, name := range add {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withoutSyscalls returns names without the syscalls in remove.
+func withoutSyscalls(names, remove []string) []string {
+	return slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return slices.Contains(remove, name)
+	})
+}
+
+// notifies reports whether the policy sends the syscalls it blocks to a
+// supervisor.
+func (p seccompPolicy) notifies() bool {
+	return p.action == config.SeccompActionNotify || p.action == config.SeccompActionAsk
+}
+
+// ret returns the filter's return value for the syscalls the policy
+// blocks.
+func (p seccompPolicy) ret() uint32 {
+	switch p.action {
+	case config.SeccompActionKill:
+		return unix.SECCOMP_RET_KILL_PROCESS
+	case config.SeccompActionLog:
+		return SECCOMP_RET_LOG
+	case config.SeccompActionNotify, config.SeccompActionAsk:
+		return unix.SECCOMP_RET_USER_NOTIF
+	default:
+		return SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	}
+}
+
 // GenerateBPFFilter generates a seccomp-bpf filter that blocks dangerous syscalls.
 // Returns the path to the generated BPF filter file.
 func (s *SeccompFilter) GenerateBPFFilter() (string, error) {
@@ -77,93 +233,200 @@ func (s *SeccompFilter) GenerateBPFFilter() (string, error) {
 	}
 
 	if s.debug {
-		fmt.Fprintf(os.Stderr, "[fence:seccomp] Generated BPF filter at %s\n", filterPath)
+		logging.Debugf("seccomp", "Generated BPF filter at %s", filterPath)
 	}
 
 	return filterPath, nil
 }
 
-// writeBPFProgram writes a BPF program that blocks dangerous syscalls.
-// This generates a compact BPF program in the format expected by bwrap --seccomp.
+// writeBPFProgram writes a BPF program that blocks dangerous syscalls, or
+// the ones the config chooses.
+// This generates a compact BPF program in the format expected by bwrap --seccomp:
+// an array of struct sock_filter in the machine's byte order.
 func (s *SeccompFilter) writeBPFProgram(path string) error {
-	// For bwrap, we need to pass the seccomp filter via file descriptor
-	// The filter format is: struct sock_filter array
-	//
-	// We'll build a simple filter:
-	// 1. Load syscall number
-	// 2. For each dangerous syscall: if match, return ERRNO(EPERM) or LOG+ERRNO
-	// 3. Default: allow
-
-	// Get syscall numbers for the current architecture
-	syscallNums := make(map[string]int)
-	for _, name := range DangerousSyscalls {
-		if num, ok := getSyscallNumber(name); ok {
-			syscallNums[name] = num
+	arches, err := seccompArches(runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Concat(s.cfg.Block, s.cfg.Allow) {
+		if !slices.ContainsFunc(arches, func(a seccompArch) bool { _, ok := a.syscallNumber(name); return ok }) {
+			logging.Warnf("seccomp", "Ignoring unknown syscall %q in the seccomp config", name)
 		}
 	}
-
-	if len(syscallNums) == 0 {
-		// No syscalls to block (unknown architecture?)
-		return fmt.Errorf("no syscall numbers found for dangerous syscalls")
+	program, err := seccompFilter(arches, s.policy)
+	if err != nil {
+		return err
 	}
 
-	// Build BPF program
-	var program []bpfInstruction
+	// Write the program to file
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) //nolint:gosec // path is controlled
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
 
-	// Load syscall number from seccomp_data
-	// BPF_LD | BPF_W | BPF_ABS: load word from absolute offset
-	program = append(program, bpfInstruction{
-		code: BPF_LD | BPF_W | BPF_ABS,
-		k:    0, // offsetof(struct seccomp_data, nr)
-	})
+	return binary.Write(f, binary.NativeEndian, program)
+}
 
-	// For each dangerous syscall, add a comparison and block
-	// Note: SECCOMP_RET_ERRNO returns -1 with errno in the low 16 bits
-	// SECCOMP_RET_LOG means "log and allow" which is NOT what we want
-	// We use SECCOMP_RET_ERRNO to block with EPERM
-	action := SECCOMP_RET_ERRNO | (unix.EPERM & 0xFFFF)
+// seccompArch is an architecture whose syscalls the filter checks.
+type seccompArch struct {
+	goarch string // Its table in syscallNumbers
+	audit  uint32 // Its AUDIT_ARCH_* value in seccomp_data
+	// x32 is set for x86-64, whose x32 ABI syscalls carry the x86-64 arch
+	// and have bit 30 set; they are all failed
+	x32 bool
+	// bigEndian is set where the low word of a syscall argument is the
+	// second one
+	bigEndian bool
+}
 
-	for _, name := range DangerousSyscalls {
-		num, ok := syscallNums[name]
-		if !ok {
-			continue
-		}
+// syscallNumber returns the number of the named syscall on a.
+func (a seccompArch) syscallNumber(name string) (uint32, bool) {
+	if nr, ok := syscallNumbers[a.goarch][name]; ok {
+		return nr, true
+	}
+	if a.goarch == "arm" {
+		nr, ok := armPrivateSyscalls[name]
+		return nr, ok
+	}
+	return 0, false
+}
 
-		// BPF_JMP | BPF_JEQ | BPF_K: if A == K, jump jt else jump jf
-		program = append(program, bpfInstruction{
-			code: BPF_JMP | BPF_JEQ | BPF_K,
-			jt:   0,           // if match, go to next instruction (block)
-			jf:   1,           // if not match, skip the block instruction
-			k:    uint32(num), //nolint:gosec // syscall numbers fit in uint32
-		})
-
-		// Return action (block with EPERM)
-		program = append(program, bpfInstruction{
-			code: BPF_RET | BPF_K,
-			k:    uint32(action),
-		})
+// auditArchLoongArch64 is AUDIT_ARCH_LOONGARCH64, which golang.org/x/sys
+// does not define.
+const auditArchLoongArch64 = 0xc0000102
+
+// seccompArches returns the architectures a process on a GOARCH kernel can
+// make syscalls with: the native one, then the compat ABIs. For 32-bit
+// GOARCHes, the 64-bit kernel they may run on is included.
+func seccompArches(goarch string) ([]seccompArch, error) {
+	var (
+		i386    = seccompArch{goarch: "386", audit: unix.AUDIT_ARCH_I386}
+		amd64   = seccompArch{goarch: "amd64", audit: unix.AUDIT_ARCH_X86_64, x32: true}
+		arm     = seccompArch{goarch: "arm", audit: unix.AUDIT_ARCH_ARM}
+		arm64   = seccompArch{goarch: "arm64", audit: unix.AUDIT_ARCH_AARCH64}
+		loong64 = seccompArch{goarch: "loong64", audit: auditArchLoongArch64}
+		ppc64le = seccompArch{goarch: "ppc64le", audit: unix.AUDIT_ARCH_PPC64LE}
+		riscv64 = seccompArch{goarch: "riscv64", audit: unix.AUDIT_ARCH_RISCV64}
+		s390x   = seccompArch{goarch: "s390x", audit: unix.AUDIT_ARCH_S390X, bigEndian: true}
+	)
+	switch goarch {
+	case "amd64", "386":
+		return []seccompArch{amd64, i386}, nil
+	case "arm64", "arm":
+		return []seccompArch{arm64, arm}, nil
+	case "loong64":
+		return []seccompArch{loong64}, nil
+	case "ppc64le":
+		return []seccompArch{ppc64le}, nil
+	case "riscv64":
+		return []seccompArch{riscv64}, nil
+	case "s390x":
+		return []seccompArch{s390x}, nil
+	default:
+		return nil, fmt.Errorf("seccomp filter is not supported on %s", goarch)
 	}
+}
 
-	// Default: allow
-	program = append(program, bpfInstruction{
-		code: BPF_RET | BPF_K,
-		k:    SECCOMP_RET_ALLOW,
-	})
+// socketcallOps are the calls socketcall(2) multiplexes, by the number it
+// takes as its first argument. Where socketcall exists and is not listed
+// itself, its calls are filtered like the corresponding syscalls.
+var socketcallOps = map[string]uint32{
+	"socket":      1,
+	"bind":        2,
+	"connect":     3,
+	"listen":      4,
+	"accept":      5,
+	"getsockname": 6,
+	"getpeername": 7,
+	"socketpair":  8,
+	"send":        9,
+	"recv":        10,
+	"sendto":      11,
+	"recvfrom":    12,
+	"shutdown":    13,
+	"setsockopt":  14,
+	"getsockopt":  15,
+	"sendmsg":     16,
+	"recvmsg":     17,
+	"accept4":     18,
+	"recvmmsg":    19,
+	"sendmmsg":    20,
+}
 
-	// Write the program to file
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) //nolint:gosec // path is controlled
-	if err != nil {
-		return err
+// Offset in struct seccomp_data of the first syscall argument
+const seccompDataArgs = 16
+
+// bpfMaxInstructions is the longest program the kernel loads (BPF_MAXINSNS).
+const bpfMaxInstructions = 4096
+
+// seccompFilter builds the filter for policy on each of arches: the
+// syscalls it lists return its action and the rest are allowed, or with an
+// allowlist, the reverse. Names an arch does not have are skipped; it is an
+// error if the native arch, the first, has none of the names listed.
+// Syscalls with any other arch kill the process, since their numbers are
+// not checked.
+func seccompFilter(arches []seccompArch, policy seccompPolicy) ([]unix.SockFilter, error) {
+	stmt := func(code uint16, k uint32) unix.SockFilter { return unix.SockFilter{Code: code, K: k} }
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: BPF_JMP | BPF_JEQ | BPF_K, Jt: jt, Jf: jf, K: k}
+	}
+	// listed is returned for the listed syscalls and other for the rest
+	listed, other := policy.ret(), uint32(SECCOMP_RET_ALLOW)
+	if policy.allowlist {
+		listed, other = other, listed
 	}
-	defer func() { _ = f.Close() }()
 
-	for _, inst := range program {
-		if err := inst.writeTo(f); err != nil {
-			return err
+	prog := []unix.SockFilter{stmt(BPF_LD|BPF_W|BPF_ABS, seccompDataArch)}
+	for i, a := range arches {
+		var nrs, ops []uint32
+		for _, name := range policy.syscalls {
+			if nr, ok := a.syscallNumber(name); ok {
+				nrs = append(nrs, nr)
+			}
+			if op, ok := socketcallOps[name]; ok {
+				ops = append(ops, op)
+			}
 		}
-	}
+		if i == 0 && len(nrs) == 0 && len(policy.syscalls) > 0 {
+			return nil, fmt.Errorf("no syscall numbers found on %s for %s", a.goarch, strings.Join(policy.syscalls, ", "))
+		}
+
+		body := []unix.SockFilter{stmt(BPF_LD|BPF_W|BPF_ABS, seccompDataNR)}
+		if a.x32 {
+			body = append(body,
+				unix.SockFilter{Code: BPF_JMP | BPF_JGE | BPF_K, Jt: 0, Jf: 1, K: 0x40000000},
+				stmt(BPF_RET|BPF_K, policy.ret()))
+		}
+		socketcall, ok := a.syscallNumber("socketcall")
+		if ok && len(ops) > 0 && !slices.Contains(policy.syscalls, "socketcall") {
+			arg := uint32(seccompDataArgs)
+			if a.bigEndian {
+				arg += 4
+			}
+			// Skip the socketcall checks unless this is socketcall
+			body = append(body,
+				jeq(socketcall, 0, uint8(2+2*len(ops))), //nolint:gosec // socketcall has 20 ops
+				stmt(BPF_LD|BPF_W|BPF_ABS, arg))
+			for _, op := range ops {
+				body = append(body, jeq(op, 0, 1), stmt(BPF_RET|BPF_K, listed))
+			}
+			body = append(body, stmt(BPF_RET|BPF_K, other))
+		}
+		for _, nr := range nrs {
+			body = append(body, jeq(nr, 0, 1), stmt(BPF_RET|BPF_K, listed))
+		}
+		body = append(body, stmt(BPF_RET|BPF_K, other))
 
-	return nil
+		// Jump over the body unless the arch matches
+		prog = append(prog, jeq(a.audit, 1, 0), stmt(BPF_JMP|BPF_JA, uint32(len(body)))) //nolint:gosec // bodies are far below 2^32 instructions
+		prog = append(prog, body...)
+	}
+	prog = append(prog, stmt(BPF_RET|BPF_K, unix.SECCOMP_RET_KILL_PROCESS))
+	if len(prog) > bpfMaxInstructions {
+		return nil, fmt.Errorf("seccomp filter has %d instructions, more than the kernel's limit of %d", len(prog), bpfMaxInstructions)
+	}
+	return prog, nil
 }
 
 // CleanupFilter removes a generated filter file.
@@ -180,7 +443,9 @@ const (
 	BPF_RET = 0x06
 	BPF_W   = 0x00
 	BPF_ABS = 0x20
+	BPF_JA  = 0x00
 	BPF_JEQ = 0x10
+	BPF_JGE = 0x30
 	BPF_K   = 0x00
 )
 
@@ -191,125 +456,12 @@ const (
 	SECCOMP_RET_LOG   = 0x7ffc0000
 )
 
-// bpfInstruction represents a single BPF instruction
-type bpfInstruction struct {
-	code uint16
-	jt   uint8
-	jf   uint8
-	k    uint32
-}
-
-func (i *bpfInstruction) writeTo(f *os.File) error {
-	// BPF instruction is 8 bytes: code(2) + jt(1) + jf(1) + k(4)
-	buf := make([]byte, 8)
-	buf[0] = byte(i.code)
-	buf[1] = byte(i.code >> 8)
-	buf[2] = i.jt
-	buf[3] = i.jf
-	buf[4] = byte(i.k)
-	buf[5] = byte(i.k >> 8)
-	buf[6] = byte(i.k >> 16)
-	buf[7] = byte(i.k >> 24)
-	_, err := f.Write(buf)
-	return err
-}
-
-// getSyscallNumber returns the syscall number for the current architecture.
-func getSyscallNumber(name string) (int, bool) {
-	// Detect architecture using uname
-	var utsname unix.Utsname
-	if err := unix.Uname(&utsname); err != nil {
-		return 0, false
-	}
-
-	// Convert machine to string
-	machine := string(utsname.Machine[:])
-	// Trim null bytes
-	for i, c := range machine {
-		if c == 0 {
-			machine = machine[:i]
-			break
-		}
-	}
-
-	var syscallMap map[string]int
-
-	if machine == "aarch64" || machine == "arm64" {
-		// ARM64 syscall numbers (from asm-generic/unistd.h)
-		syscallMap = map[string]int{
-			"ptrace":            117,
-			"process_vm_readv":  270,
-			"process_vm_writev": 271,
-			"keyctl":            219,
-			"add_key":           217,
-			"request_key":       218,
-			"personality":       92,
-			"userfaultfd":       282,
-			"perf_event_open":   241,
-			"bpf":               280,
-			"kexec_load":        104,
-			"kexec_file_load":   294,
-			"reboot":            142,
-			"syslog":            116,
-			"acct":              89,
-			"mount":             40,
-			"umount2":           39,
-			"pivot_root":        41,
-			"swapon":            224,
-			"swapoff":           225,
-			"sethostname":       161,
-			"setdomainname":     162,
-			"init_module":       105,
-			"finit_module":      273,
-			"delete_module":     106,
-			// ioperm and iopl don't exist on ARM64
-		}
-	} else {
-		// x86_64 syscall numbers
-		syscallMap = map[string]int{
-			"ptrace":            101,
-			"process_vm_readv":  310,
-			"process_vm_writev": 311,
-			"keyctl":            250,
-			"add_key":           248,
-			"request_key":       249,
-			"personality":       135,
-			"userfaultfd":       323,
-			"perf_event_open":   298,
-			"bpf":               321,
-			"kexec_load":        246,
-			"kexec_file_load":   320,
-			"reboot":            169,
-			"syslog":            103,
-			"acct":              163,
-			"mount":             165,
-			"umount2":           166,
-			"pivot_root":        155,
-			"swapon":            167,
-			"swapoff":           168,
-			"sethostname":       170,
-			"setdomainname":     171,
-			"init_module":       175,
-			"finit_module":      313,
-			"delete_module":     176,
-			"ioperm":            173,
-			"iopl":              172,
-		}
-	}
-
-	num, ok := syscallMap[name]
-	return num, ok
-}
-
 // Note: SeccompMonitor was removed because SECCOMP_RET_ERRNO (which we use to block
 // syscalls) is completely silent - it doesn't log to dmesg, audit, or anywhere else.
 // The monitor code attempted to parse dmesg for seccomp events, but those only appear
 // with SECCOMP_RET_LOG (allows the syscall) or SECCOMP_RET_KILL (kills the process).
 //
-// Alternative approaches considered:
-// - SECCOMP_RET_USER_NOTIF: Complex supervisor architecture with latency on every blocked call
-// - auditd integration: Requires audit daemon setup and root access
-// - SECCOMP_RET_LOG: Logs but doesn't block (defeats the purpose)
-//
-// The eBPF monitor in linux_ebpf.go now handles syscall failure detection instead,
-// which catches EPERM/EACCES errors regardless of their source.
+// With seccomp.action set to notify or ask, the filter returns SECCOMP_RET_USER_NOTIF
+// instead, and the exec guard's supervisor reports each blocked syscall (see
+// linux_seccomp_notify.go). Otherwise the eBPF monitor in linux_ebpf.go handles
+// syscall failure detection, which catches EPERM/EACCES errors regardless of their source.
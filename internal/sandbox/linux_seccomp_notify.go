@@ -0,0 +1,169 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// With seccomp.action notify or ask, blocked syscalls are reported instead
+// of failing silently: the exec guard's filter returns
+// SECCOMP_RET_USER_NOTIF for the syscalls the seccomp policy blocks, and
+// its supervisor, which runs outside that filter, logs each one with the
+// process that made it and fails it with EPERM. With ask, it first asks on
+// the terminal, and lets the syscall run if the user allows it.
+//
+// The sandbox's own filter then only checks the architecture: the kernel
+// applies the most restrictive result of all filters, so its EPERM would
+// win over the notification.
+
+// decideSyscall answers a notification for a syscall the seccomp policy
+// blocks.
+func (g *execGuard) decideSyscall(req *seccompNotif) seccompNotifResp {
+	allow := seccompNotifResp{ID: req.ID, Flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}
+	deny := seccompNotifResp{ID: req.ID, Error: -int32(unix.EPERM)}
+
+	name, call := describeSyscall(req)
+	process := describeProcess(req.PID)
+	// The pid may have been reused if the caller died meanwhile
+	if ioctl(g.listener, unix.SECCOMP_IOCTL_NOTIF_ID_VALID, unsafe.Pointer(&req.ID)) != nil {
+		return deny
+	}
+
+	switch {
+	case g.audit:
+		logging.Warnf("seccomp", "would block: %s by %s", call, process)
+		return allow
+	case g.allowed[name]:
+		if g.debug {
+			logging.Debugf("seccomp", "allowed: %s by %s", call, process)
+		}
+		return allow
+	case g.ask:
+		answer, err := g.askTerminal(fmt.Sprintf("%s by %s", call, process))
+		if err != nil {
+			logging.Warnf("seccomp", "blocked: %s by %s (cannot ask: %v)", call, process, err)
+			return deny
+		}
+		switch answer {
+		case "a", "always":
+			if g.allowed == nil {
+				g.allowed = make(map[string]bool)
+			}
+			g.allowed[name] = true
+			fallthrough
+		case "y", "yes":
+			logging.Infof("seccomp", "allowed: %s by %s", call, process)
+			return allow
+		}
+		logging.Warnf("seccomp", "blocked: %s by %s", call, process)
+		return deny
+	default:
+		logging.Warnf("seccomp", "blocked: %s by %s", call, process)
+		return deny
+	}
+}
+
+// askTerminal asks the user on the terminal whether to allow what, and
+// returns the answer, lowercased.
+func (g *execGuard) askTerminal(what string) (string, error) {
+	tty, err := openTerminal()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tty.Close() }()
+	if _, err := fmt.Fprintf(tty, "[fence] Allow %s? [y/N/a(lways)] ", what); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
+
+// openTerminal opens the terminal to ask on: the controlling terminal, or
+// the one stdin is, since the sandbox usually runs in a session of its own.
+func openTerminal() (*os.File, error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		return tty, nil
+	}
+	if _, err := unix.IoctlGetTermios(0, unix.TCGETS); err != nil {
+		return nil, errors.New("no terminal")
+	}
+	return os.OpenFile("/proc/self/fd/0", os.O_RDWR, 0)
+}
+
+// describeSyscall returns the name of the syscall req is for, and the call
+// with its arguments. The calls socketcall multiplexes are named by the
+// call.
+func describeSyscall(req *seccompNotif) (name, call string) {
+	name = syscallName(req.Data.Arch, req.Data.NR)
+	args := req.Data.Args[:]
+	prefix := ""
+	if name == "socketcall" {
+		for op, n := range socketcallOps {
+			if uint64(n) == args[0] {
+				name, prefix, args = op, "socketcall ", args[1:2]
+				break
+			}
+		}
+	}
+	hex := make([]string, len(args))
+	for i, arg := range args {
+		hex[i] = fmt.Sprintf("%#x", arg)
+	}
+	return name, fmt.Sprintf("%s%s(%s)", prefix, name, strings.Join(hex, ", "))
+}
+
+// syscallName returns the name of syscall nr of the architecture arch.
+func syscallName(arch uint32, nr int32) string {
+	arches, _ := seccompArches(runtime.GOARCH)
+	for _, a := range arches {
+		if a.audit != arch {
+			continue
+		}
+		if a.x32 && nr&0x40000000 != 0 {
+			return fmt.Sprintf("x32 syscall %d", nr&^0x40000000)
+		}
+		// Take the first name in order where a number has two
+		name := ""
+		for _, table := range []map[string]uint32{syscallNumbers[a.goarch], armPrivateSyscalls} {
+			for n, number := range table {
+				if int64(number) == int64(nr) && (name == "" || n < name) {
+					name = n
+				}
+			}
+			if a.goarch != "arm" {
+				break
+			}
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("syscall %d", nr)
+}
+
+// describeProcess returns pid with its command line, for messages.
+func describeProcess(pid uint32) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(data) == 0 {
+		if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+			return fmt.Sprintf("pid %d (%s)", pid, strings.TrimSpace(string(comm)))
+		}
+		return fmt.Sprintf("pid %d", pid)
+	}
+	args := strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+	return fmt.Sprintf("pid %d (%s)", pid, ShellQuote(args))
+}
@@ -2,13 +2,15 @@
 
 package sandbox
 
+import "github.com/Use-Tusk/fence/internal/config"
+
 // SeccompFilter is a stub for non-Linux platforms.
 type SeccompFilter struct {
 	debug bool
 }
 
 // NewSeccompFilter creates a stub seccomp filter.
-func NewSeccompFilter(debug bool) *SeccompFilter {
+func NewSeccompFilter(cfg config.SeccompConfig, debug bool) *SeccompFilter {
 	return &SeccompFilter{debug: debug}
 }
 
@@ -22,3 +24,6 @@ func (s *SeccompFilter) CleanupFilter(path string) {}
 
 // DangerousSyscalls is empty on non-Linux platforms.
 var DangerousSyscalls []string
+
+// StrictSyscalls is empty on non-Linux platforms.
+var StrictSyscalls []string
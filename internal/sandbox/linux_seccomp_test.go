@@ -0,0 +1,162 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	const eperm = SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	policy := newSeccompPolicy(config.SeccompConfig{Block: []string{"connect"}})
+
+	tests := []struct {
+		goarch         string
+		arch, nr, arg0 uint32
+		want           uint32
+	}{
+		// ptrace, natively and in the compat ABI
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 101, want: eperm},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 26, want: eperm},
+		{goarch: "arm64", arch: unix.AUDIT_ARCH_AARCH64, nr: 117, want: eperm},
+		{goarch: "arm64", arch: unix.AUDIT_ARCH_ARM, nr: 26, want: eperm},
+		{goarch: "riscv64", arch: unix.AUDIT_ARCH_RISCV64, nr: 117, want: eperm},
+		// read is allowed
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 0, want: SECCOMP_RET_ALLOW},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 3, want: SECCOMP_RET_ALLOW},
+		// x32 syscalls are all failed
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 0x40000000, want: eperm},
+		// socketcall(SYS_CONNECT) is blocked along with connect, other calls are not
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 102, arg0: 3, want: eperm},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 102, arg0: 1, want: SECCOMP_RET_ALLOW},
+		// Unknown arches are killed
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_AARCH64, nr: 0, want: unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tt := range tests {
+		arches, err := seccompArches(tt.goarch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := seccompFilter(arches, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := runSeccompFilter(t, prog, tt.arch, tt.nr, tt.arg0); got != tt.want {
+			t.Errorf("%s: arch %#x syscall %d(%d): got %#x, want %#x", tt.goarch, tt.arch, tt.nr, tt.arg0, got, tt.want)
+		}
+	}
+
+	if _, err := seccompArches("mips"); err == nil {
+		t.Error("seccompArches(mips) succeeded, want an error")
+	}
+	arches, _ := seccompArches("amd64")
+	if _, err := seccompFilter(arches, seccompPolicy{syscalls: []string{"no_such_syscall"}}); err == nil {
+		t.Error("seccompFilter() with no known syscall succeeded, want an error")
+	}
+}
+
+func TestSeccompFilterStrict(t *testing.T) {
+	policy := newSeccompPolicy(config.SeccompConfig{
+		Profile: config.SeccompProfileStrict,
+		Allow:   []string{"unshare"},
+		Block:   []string{"connect"},
+		Action:  config.SeccompActionKill,
+	})
+	const kill = unix.SECCOMP_RET_KILL_PROCESS
+
+	tests := []struct {
+		goarch         string
+		arch, nr, arg0 uint32
+		want           uint32
+	}{
+		// read and the allowed unshare run, io_uring_setup and the blocked
+		// connect do not
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 0, want: SECCOMP_RET_ALLOW},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 272, want: SECCOMP_RET_ALLOW},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 425, want: kill},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 42, want: kill},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_X86_64, nr: 0x40000000, want: kill},
+		// socketcall(SYS_SOCKET) runs, socketcall(SYS_CONNECT) does not
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 102, arg0: 1, want: SECCOMP_RET_ALLOW},
+		{goarch: "amd64", arch: unix.AUDIT_ARCH_I386, nr: 102, arg0: 3, want: kill},
+		// set_tls, an ARM private syscall, runs
+		{goarch: "arm64", arch: unix.AUDIT_ARCH_ARM, nr: 0x0f0005, want: SECCOMP_RET_ALLOW},
+		{goarch: "arm64", arch: unix.AUDIT_ARCH_AARCH64, nr: 117, want: kill},
+	}
+	for _, tt := range tests {
+		arches, err := seccompArches(tt.goarch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := seccompFilter(arches, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := runSeccompFilter(t, prog, tt.arch, tt.nr, tt.arg0); got != tt.want {
+			t.Errorf("%s: arch %#x syscall %d(%d): got %#x, want %#x", tt.goarch, tt.arch, tt.nr, tt.arg0, got, tt.want)
+		}
+	}
+}
+
+func TestNewSeccompPolicy(t *testing.T) {
+	p := newSeccompPolicy(config.SeccompConfig{Allow: []string{"ptrace", "personality"}, Block: []string{"personality", "unshare"}})
+	if p.allowlist || p.action != config.SeccompActionErrno {
+		t.Errorf("default policy: allowlist %v, action %q", p.allowlist, p.action)
+	}
+	if slices.Contains(p.syscalls, "ptrace") {
+		t.Error("allowed ptrace is still blocked")
+	}
+	if !slices.Contains(p.syscalls, "personality") || !slices.Contains(p.syscalls, "unshare") {
+		t.Errorf("syscalls = %v, want personality and unshare blocked", p.syscalls)
+	}
+	if !slices.Contains(DangerousSyscalls, "ptrace") {
+		t.Error("newSeccompPolicy() modified DangerousSyscalls")
+	}
+
+	p = newSeccompPolicy(config.SeccompConfig{Profile: config.SeccompProfileStrict, Action: config.SeccompActionLog})
+	if !p.allowlist || !slices.Equal(p.syscalls, StrictSyscalls) || p.ret() != SECCOMP_RET_LOG {
+		t.Errorf("strict policy = %+v", p)
+	}
+}
+
+func TestSyscallNumbersMatchHost(t *testing.T) {
+	table, ok := syscallNumbers[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no syscall table for %s", runtime.GOARCH)
+	}
+	for name, want := range map[string]uint32{"getpid": unix.SYS_GETPID, "ptrace": unix.SYS_PTRACE, "mount": unix.SYS_MOUNT} {
+		if got := table[name]; got != want {
+			t.Errorf("syscallNumbers[%s][%s] = %d, want %d", runtime.GOARCH, name, got, want)
+		}
+	}
+}
+
+func TestDescribeSyscall(t *testing.T) {
+	tests := []struct {
+		arch               uint32
+		nr                 int32
+		args               [6]uint64
+		wantName, wantCall string
+	}{
+		{unix.AUDIT_ARCH_X86_64, 101, [6]uint64{0x10, 42}, "ptrace", "ptrace(0x10, 0x2a, 0x0, 0x0, 0x0, 0x0)"},
+		{unix.AUDIT_ARCH_I386, 102, [6]uint64{3, 0xffd0}, "connect", "socketcall connect(0xffd0)"},
+		{unix.AUDIT_ARCH_X86_64, 0x40000000 | 101, [6]uint64{}, "x32 syscall 101", "x32 syscall 101(0x0, 0x0, 0x0, 0x0, 0x0, 0x0)"},
+		{0x1234, 1, [6]uint64{}, "syscall 1", "syscall 1(0x0, 0x0, 0x0, 0x0, 0x0, 0x0)"},
+	}
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the cases are for amd64")
+	}
+	for _, tt := range tests {
+		req := &seccompNotif{}
+		req.Data.Arch, req.Data.NR, req.Data.Args = tt.arch, tt.nr, tt.args
+		name, call := describeSyscall(req)
+		if name != tt.wantName || call != tt.wantCall {
+			t.Errorf("describeSyscall(%#x, %d) = %q, %q; want %q, %q", tt.arch, tt.nr, name, call, tt.wantName, tt.wantCall)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// The selinux backend runs the command under runcon in a domain defined by
+// a generated CIL policy module, for SELinux-enforcing systems (such as
+// Fedora and RHEL) where neither bwrap nor Landlock is available. SELinux
+// rules apply to the types files are labeled with rather than to paths, so
+// the path rules (see pathRules) are mapped through the types of the paths:
+//
+//   - The domain can read and run files of every type except those of the
+//     hidden paths.
+//   - It can write files of the types of the writable paths. That extends
+//     to every file of those types, not only the paths themselves.
+//   - A read-only or hidden path whose type is writable cannot be protected,
+//     and neither can a hidden path with the same type as its parent
+//     directory, since denying the type would hide unrelated files too.
+//     These rules are listed in the module as comments.
+//   - /dev is not made writable; the domain can use the common character
+//     devices (/dev/null, terminals, and so on) instead.
+
+// selinuxDevices are the character devices the domain can read and write,
+// along with the terminal fence is attached to.
+var selinuxDevices = []string{
+	"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty", "/dev/ptmx",
+	"/proc/self/fd/0", "/proc/self/fd/1", "/proc/self/fd/2",
+}
+
+// selinuxPolicy is what a generated module grants, in terms of file types.
+type selinuxPolicy struct {
+	role   string // Role of the fence process, which runcon keeps
+	caller string // Domain of the fence process, which runcon transitions from
+
+	writable   []string // Types the domain can create and write files of
+	devices    []string // Character device types it can read and write
+	hidden     []string // Types it cannot read
+	unenforced []string // Path rules that types cannot express
+}
+
+// newSELinuxPolicy returns the policy equivalent to rules, looking up the
+// type of each path with fileType. Paths that do not exist are skipped.
+func newSELinuxPolicy(rules []pathRule, fileType func(path string) (string, error)) selinuxPolicy {
+	p := selinuxPolicy{}
+	p.role, p.caller = selinuxProcessContext()
+
+	add := func(types []string, t string) []string {
+		if slices.Contains(types, t) {
+			return types
+		}
+		return append(types, t)
+	}
+
+	// Paths whose type cannot be looked up are not granted anything
+	for _, r := range rules {
+		if r.access != pathWritable || r.path == "/dev" {
+			continue
+		}
+		if t, err := fileType(r.path); err == nil {
+			p.writable = add(p.writable, t)
+		}
+	}
+	for _, path := range selinuxDevices {
+		if info, err := os.Stat(path); err != nil || info.Mode()&fs.ModeCharDevice == 0 {
+			continue
+		}
+		if t, err := fileType(path); err == nil {
+			p.devices = add(p.devices, t)
+		}
+	}
+
+	for _, r := range rules {
+		if r.access == pathWritable {
+			continue
+		}
+		t, err := fileType(r.path)
+		var pathErr *fs.PathError
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case errors.As(err, &pathErr):
+			p.unenforced = append(p.unenforced, fmt.Sprintf("%s %s: cannot read its type: %v", r.access, r.path, pathErr.Err))
+		case err != nil:
+			p.unenforced = append(p.unenforced, fmt.Sprintf("%s %s: %v", r.access, r.path, err))
+		case slices.Contains(p.writable, t):
+			p.unenforced = append(p.unenforced, fmt.Sprintf("%s %s: its type %s is writable", r.access, r.path, t))
+		case r.access == pathReadOnly:
+			// Not writable unless granted
+		case parentType(r.path, fileType) == t:
+			p.unenforced = append(p.unenforced, fmt.Sprintf("%s %s: its type %s is also its parent directory's", r.access, r.path, t))
+		default:
+			p.hidden = add(p.hidden, t)
+		}
+	}
+	return p
+}
+
+// parentType returns the type of path's parent directory, or "" if it cannot
+// be looked up.
+func parentType(path string, fileType func(string) (string, error)) string {
+	t, err := fileType(filepath.Dir(path))
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+// selinuxFileType returns the type path is labeled with.
+func selinuxFileType(path string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, "security.selinux", buf)
+	if err != nil {
+		return "", &fs.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+	label := strings.TrimRight(string(buf[:n]), "\x00")
+	parts := strings.Split(label, ":")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("unexpected SELinux label %q", label)
+	}
+	return parts[2], nil
+}
+
+// selinuxProcessContext returns the role and domain of the current process,
+// or the targeted policy's unconfined ones if they cannot be read.
+func selinuxProcessContext() (role, domain string) {
+	data, err := os.ReadFile("/proc/self/attr/current")
+	if err == nil {
+		parts := strings.Split(strings.TrimRight(string(data), "\x00\n"), ":")
+		if len(parts) >= 3 {
+			return parts[1], parts[2]
+		}
+	}
+	return "unconfined_r", "unconfined_t"
+}
+
+// formatSELinuxModule returns the CIL module name, defining the domain
+// name_t with policy p.
+func formatSELinuxModule(name string, p selinuxPolicy) string {
+	d := name + "_t"
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Generated by fence\n")
+	for _, u := range p.unenforced {
+		fmt.Fprintf(&b, "; Not enforced: %s\n", u)
+	}
+	readable := fmt.Sprintf("(typeattributeset %s_readable (file_type filesystem_type))", name)
+	if len(p.hidden) > 0 {
+		readable = fmt.Sprintf("(typeattribute %[1]s_hidden)\n(typeattributeset %[1]s_hidden (%[2]s))\n"+
+			"(typeattributeset %[1]s_readable (and (or file_type filesystem_type) (not %[1]s_hidden)))", name, strings.Join(p.hidden, " "))
+	}
+	fmt.Fprintf(&b, `
+(type %[1]s)
+(roletype %[2]s %[1]s)
+(typeattributeset domain (%[1]s))
+
+; Entered from fence's domain with runcon
+(allow %[3]s %[1]s (process (transition signal sigkill sigstop signull noatsecure rlimitinh siginh)))
+(allow %[1]s %[3]s (fd (use)))
+(allow %[1]s %[3]s (fifo_file (getattr read write append ioctl lock)))
+(allow %[1]s %[3]s (process (sigchld)))
+(allow %[1]s %[3]s (unix_stream_socket (connectto)))
+
+; Read and run anything except the hidden types
+(typeattribute %[4]s_readable)
+%[5]s
+(allow %[1]s %[4]s_readable (dir (getattr open read search ioctl lock)))
+(allow %[1]s %[4]s_readable (file (getattr open read execute execute_no_trans entrypoint map ioctl lock)))
+(allow %[1]s %[4]s_readable (lnk_file (getattr read)))
+(allow %[1]s %[4]s_readable (sock_file (getattr read write)))
+(allow %[1]s %[4]s_readable (fifo_file (getattr open read)))
+(allow %[1]s filesystem_type (filesystem (getattr)))
+`, d, p.role, p.caller, name, readable)
+
+	if len(p.writable) > 0 {
+		fmt.Fprintf(&b, `
+; Write the types of the writable paths
+(typeattribute %[2]s_writable)
+(typeattributeset %[2]s_writable (%[3]s))
+(allow %[1]s %[2]s_writable (dir (getattr open read search write add_name remove_name create rmdir rename reparent setattr)))
+(allow %[1]s %[2]s_writable (file (getattr open read write append create unlink rename link setattr ioctl lock)))
+(allow %[1]s %[2]s_writable (lnk_file (getattr read create unlink rename)))
+(allow %[1]s %[2]s_writable (sock_file (getattr write create unlink)))
+(allow %[1]s %[2]s_writable (fifo_file (getattr open read write create unlink)))
+`, d, name, strings.Join(p.writable, " "))
+	}
+	if len(p.devices) > 0 {
+		fmt.Fprintf(&b, `
+; Common devices and the terminal
+(typeattribute %[2]s_devices)
+(typeattributeset %[2]s_devices (%[3]s))
+(allow %[1]s %[2]s_devices (chr_file (getattr open read write append ioctl lock)))
+`, d, name, strings.Join(p.devices, " "))
+	}
+
+	fmt.Fprintf(&b, `
+; Processes, pipes, and its own /proc entries
+(allow %[1]s self (process (fork sigchld signal signull sigkill sigstop getsched setsched getpgid setpgid getcap getattr setrlimit)))
+(allow %[1]s self (fifo_file (getattr open read write append ioctl)))
+(allow %[1]s self (dir (getattr open read search)))
+(allow %[1]s self (file (getattr open read)))
+(allow %[1]s self (lnk_file (getattr read)))
+
+; Network filtering relies on the proxy environment variables
+(allow %[1]s self (tcp_socket (create connect bind listen accept read write getattr setattr getopt setopt shutdown)))
+(allow %[1]s self (udp_socket (create connect bind read write getattr setattr getopt setopt shutdown)))
+(allow %[1]s self (unix_stream_socket (create connect bind listen accept read write getattr setattr getopt setopt shutdown)))
+(allow %[1]s self (unix_dgram_socket (create connect bind read write getattr setattr getopt setopt shutdown)))
+(allow %[1]s port_type (tcp_socket (name_connect name_bind)))
+(allow %[1]s port_type (udp_socket (name_bind)))
+(allow %[1]s node_type (tcp_socket (node_bind)))
+(allow %[1]s node_type (udp_socket (node_bind)))
+
+; Capabilities a command run as root commonly needs; no mounts or other
+; administration
+(allow %[1]s self (capability (chown dac_override dac_read_search fowner fsetid kill setgid setuid net_bind_service)))
+`, d)
+	return b.String()
+}
+
+// selinuxModules loads policy modules for a Manager and removes them at
+// Shutdown. Module names include a random prefix, so removing them does not
+// affect sandboxes run by other Managers or fence processes.
+type selinuxModules struct {
+	prefix string
+
+	mu     sync.Mutex
+	loaded []loadedSELinuxModule
+}
+
+type loadedSELinuxModule struct {
+	name   string
+	key    string // The module formatted with a fixed name, to find repeats
+	module string
+}
+
+func newSELinuxModules() *selinuxModules {
+	return &selinuxModules{prefix: randomSELinuxPrefix()}
+}
+
+func randomSELinuxPrefix() string {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	// Type names cannot contain hyphens
+	return "fence_" + hex.EncodeToString(id)
+}
+
+// load loads a module with policy, unless m already has, and returns its name
+// and text. A nil m loads a new module that is never removed.
+func (m *selinuxModules) load(policy selinuxPolicy) (name, module string, err error) {
+	if m == nil {
+		name = randomSELinuxPrefix()
+		module = formatSELinuxModule(name, policy)
+		return name, module, installSELinuxModule(context.Background(), name, module)
+	}
+
+	key := formatSELinuxModule("fence", policy)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.loaded {
+		if l.key == key {
+			return l.name, l.module, nil
+		}
+	}
+	name = fmt.Sprintf("%s_%d", m.prefix, len(m.loaded)+1)
+	module = formatSELinuxModule(name, policy)
+	if err := installSELinuxModule(context.Background(), name, module); err != nil {
+		return "", "", err
+	}
+	m.loaded = append(m.loaded, loadedSELinuxModule{name: name, key: key, module: module})
+	return name, module, nil
+}
+
+// Shutdown removes the loaded modules. Commands still running in their
+// domains must have been stopped first.
+func (m *selinuxModules) Shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	loaded := m.loaded
+	m.loaded = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for _, l := range loaded {
+		errs = append(errs, runSemodule(ctx, "-r", l.name))
+	}
+	return errors.Join(errs...)
+}
+
+// installSELinuxModule installs the CIL module as name, which semodule takes
+// from the file name.
+func installSELinuxModule(ctx context.Context, name, module string) error {
+	dir, err := os.MkdirTemp("", "fence-selinux-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, name+".cil")
+	if err := os.WriteFile(path, []byte(module), 0o600); err != nil {
+		return err
+	}
+	return runSemodule(ctx, "-i", path)
+}
+
+// runSemodule runs semodule with op on arg.
+func runSemodule(ctx context.Context, op, arg string) error {
+	cmd := exec.CommandContext(ctx, "semodule", op, arg)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("semodule %s: %w: %s", op, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
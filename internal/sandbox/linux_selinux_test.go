@@ -0,0 +1,131 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestNewSELinuxPolicy(t *testing.T) {
+	types := map[string]string{
+		"/tmp":                       "tmp_t",
+		"/home/u":                    "user_home_dir_t",
+		"/home/u/project":            "user_home_t",
+		"/home/u/project/.git/hooks": "user_home_t",
+		"/home/u/.ssh":               "ssh_home_t",
+		"/etc":                       "etc_t",
+		"/etc/hosts":                 "net_conf_t",
+		"/etc/shadow":                "shadow_t",
+		"/etc/ssl":                   "cert_t",
+		"/etc/ssl/private":           "cert_t",
+		"/dev/null":                  "null_device_t",
+	}
+	fileType := func(path string) (string, error) {
+		if path == "/srv/nfs" {
+			return "", &fs.PathError{Op: "getxattr", Path: path, Err: syscall.ENOTSUP}
+		}
+		if t, ok := types[path]; ok {
+			return t, nil
+		}
+		return "", &fs.PathError{Op: "getxattr", Path: path, Err: syscall.ENOENT}
+	}
+
+	p := newSELinuxPolicy([]pathRule{
+		{path: "/dev", access: pathWritable},
+		{path: "/tmp", access: pathWritable},
+		{path: "/home/u/project", access: pathWritable},
+		{path: "/home/u/.ssh", access: pathHidden},
+		{path: "/etc/shadow", access: pathHidden},
+		{path: "/etc/ssl/private", access: pathHidden},
+		{path: "/home/u/project/.git/hooks", access: pathReadOnly},
+		{path: "/etc/hosts", access: pathReadOnly},
+		{path: "/home/u/.netrc", access: pathHidden},
+		{path: "/srv/nfs", access: pathReadOnly},
+	}, fileType)
+
+	if want := []string{"tmp_t", "user_home_t"}; !slices.Equal(p.writable, want) {
+		t.Errorf("writable = %q, want %q", p.writable, want)
+	}
+	if want := []string{"ssh_home_t", "shadow_t"}; !slices.Equal(p.hidden, want) {
+		t.Errorf("hidden = %q, want %q", p.hidden, want)
+	}
+	if want := []string{"null_device_t"}; !slices.Equal(p.devices, want) {
+		t.Errorf("devices = %q, want %q", p.devices, want)
+	}
+	want := []string{
+		"hidden /etc/ssl/private: its type cert_t is also its parent directory's",
+		"read-only /home/u/project/.git/hooks: its type user_home_t is writable",
+		fmt.Sprintf("read-only /srv/nfs: cannot read its type: %v", syscall.ENOTSUP),
+	}
+	if !slices.Equal(p.unenforced, want) {
+		t.Errorf("unenforced =\n%q\nwant\n%q", p.unenforced, want)
+	}
+}
+
+func TestFormatSELinuxModule(t *testing.T) {
+	p := selinuxPolicy{
+		role:       "unconfined_r",
+		caller:     "unconfined_t",
+		writable:   []string{"tmp_t", "user_home_t"},
+		devices:    []string{"null_device_t"},
+		unenforced: []string{"read-only /home/u/project/.git/hooks: its type user_home_t is writable"},
+	}
+
+	module := formatSELinuxModule("fence_1", p)
+	for _, want := range []string{
+		"; Not enforced: read-only /home/u/project/.git/hooks: its type user_home_t is writable\n",
+		"(type fence_1_t)\n",
+		"(roletype unconfined_r fence_1_t)\n",
+		"(allow unconfined_t fence_1_t (process (transition ",
+		"(typeattributeset fence_1_readable (file_type filesystem_type))\n",
+		"(typeattributeset fence_1_writable (tmp_t user_home_t))\n",
+		"(typeattributeset fence_1_devices (null_device_t))\n",
+	} {
+		if !strings.Contains(module, want) {
+			t.Errorf("module does not contain %q:\n%s", want, module)
+		}
+	}
+	if strings.Contains(module, "_hidden") {
+		t.Errorf("module without hidden types defines the hidden attribute:\n%s", module)
+	}
+
+	p.hidden = []string{"ssh_home_t", "shadow_t"}
+	p.writable = nil
+	module = formatSELinuxModule("fence_1", p)
+	for _, want := range []string{
+		"(typeattributeset fence_1_hidden (ssh_home_t shadow_t))\n",
+		"(typeattributeset fence_1_readable (and (or file_type filesystem_type) (not fence_1_hidden)))\n",
+	} {
+		if !strings.Contains(module, want) {
+			t.Errorf("module does not contain %q:\n%s", want, module)
+		}
+	}
+	if strings.Contains(module, "_writable") {
+		t.Errorf("module without writable types defines the writable attribute:\n%s", module)
+	}
+}
+
+// The selinux backend runs the same command the bwrap backend would, in the
+// domain of a module made from its mounts.
+func TestSELinuxBackendSpec(t *testing.T) {
+	spec, err := LinuxSpec(config.Default(), "echo hello", nil, nil, LinuxSandboxOptions{UseSeccomp: true, Backend: BackendSELinux})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	if want := []string{"runcon", "-t", "fence_t", "--"}; !slices.Equal(spec.BwrapArgs[:4], want) {
+		t.Errorf("args start with %q, want %q", spec.BwrapArgs[:4], want)
+	}
+	if spec.SeccompSyscalls != nil {
+		t.Error("the selinux backend cannot install a seccomp filter")
+	}
+	if !strings.Contains(spec.SELinuxModule, "(type fence_t)") {
+		t.Errorf("module does not define fence_t:\n%s", spec.SELinuxModule)
+	}
+}
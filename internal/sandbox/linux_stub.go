@@ -3,15 +3,21 @@
 package sandbox
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
 )
 
 // LinuxBridge is a stub for non-Linux platforms.
 type LinuxBridge struct {
 	HTTPSocketPath  string
 	SOCKSSocketPath string
+	DNSSocketPath   string
+	ResolvConfPath  string
 }
 
 // ReverseBridge is a stub for non-Linux platforms.
@@ -22,41 +28,140 @@ type ReverseBridge struct {
 
 // LinuxSandboxOptions is a stub for non-Linux platforms.
 type LinuxSandboxOptions struct {
-	UseLandlock bool
-	UseSeccomp  bool
-	UseEBPF     bool
-	Monitor     bool
-	Debug       bool
+	UseLandlock  bool
+	UseSeccomp   bool
+	UseEBPF      bool
+	Monitor      bool
+	Debug        bool
+	DBusProxy    *DBusProxy
+	Violations   *policy.ViolationLog
+	ShareNetwork bool
+	Terminal     bool
+	Overlay      *WorkspaceOverlay
+	CABundle     string
+	Audit        bool
+	ProxyAuth    bool
+	Backend      string
+	appArmor     *appArmorProfiles
+	selinux      *selinuxModules
+	cgroup       *runCgroup
+	readMasks    *readMasks
 }
 
+// appArmorProfiles is a stub for non-Linux platforms.
+type appArmorProfiles struct{}
+
+func newAppArmorProfiles() *appArmorProfiles { return nil }
+
+func (p *appArmorProfiles) Shutdown(_ context.Context) error { return nil }
+
+// readMasks is a stub for non-Linux platforms.
+type readMasks struct{}
+
+func newReadMasks() *readMasks { return nil }
+
+func (r *readMasks) Shutdown(_ context.Context) error { return nil }
+
+// selinuxModules is a stub for non-Linux platforms.
+type selinuxModules struct{}
+
+func newSELinuxModules() *selinuxModules { return nil }
+
+func (m *selinuxModules) Shutdown(_ context.Context) error { return nil }
+
+// runCgroup is a stub for non-Linux platforms, which have no resource limits.
+type runCgroup struct{}
+
+func newRunCgroup(_ *config.Config) (*runCgroup, error) {
+	return nil, errors.New("resource limits are only supported on Linux")
+}
+
+func (c *runCgroup) restrictEgress(_ *config.Config, _ bool) error {
+	return errors.New("egress filtering is only supported on Linux")
+}
+
+func (c *runCgroup) limits() []string           { return nil }
+func (c *runCgroup) events() LimitEvents        { return LimitEvents{} }
+func (c *runCgroup) sessionResources() []string { return nil }
+
+func (c *runCgroup) Shutdown(_ context.Context) error { return nil }
+
+func releaseCgroup(_ string) bool { return false }
+
+// DBusProxy is a stub for non-Linux platforms.
+type DBusProxy struct {
+	SessionSocketPath string
+	SystemSocketPath  string
+}
+
+// NewDBusProxy returns nil on non-Linux platforms.
+func NewDBusProxy(_ context.Context, cfg config.DBusConfig, debug bool) (*DBusProxy, error) {
+	return nil, nil
+}
+
+// Cleanup is a no-op on non-Linux platforms.
+func (p *DBusProxy) Cleanup() {}
+
+// Shutdown is a no-op on non-Linux platforms.
+func (p *DBusProxy) Shutdown(_ context.Context) error { return nil }
+
+func (p *DBusProxy) sessionResources() ([]helperProcess, []string) { return nil, nil }
+
 // NewLinuxBridge returns an error on non-Linux platforms.
-func NewLinuxBridge(httpProxyPort, socksProxyPort int, debug bool) (*LinuxBridge, error) {
-	return nil, fmt.Errorf("Linux bridge not available on this platform")
+func NewLinuxBridge(_ context.Context, httpProxyPort, socksProxyPort int, debug bool) (*LinuxBridge, error) {
+	return nil, fmt.Errorf("%w: Linux bridge requires Linux", ErrSandboxUnsupported)
+}
+
+// NewLinuxSocketBridge returns an error on non-Linux platforms.
+func NewLinuxSocketBridge(_ context.Context, http, socks, debug bool) (*LinuxBridge, net.Listener, net.Listener, error) {
+	return nil, nil, nil, fmt.Errorf("%w: Linux bridge requires Linux", ErrSandboxUnsupported)
 }
 
+func (b *LinuxBridge) listenDNS(_ context.Context, _ []string) (net.Listener, error) {
+	return nil, fmt.Errorf("%w: DNS bridge requires Linux", ErrSandboxUnsupported)
+}
+
+// clearAmbientCaps is a no-op on non-Linux platforms.
+func clearAmbientCaps() error { return nil }
+
 // Cleanup is a no-op on non-Linux platforms.
 func (b *LinuxBridge) Cleanup() {}
 
+// Shutdown is a no-op on non-Linux platforms.
+func (b *LinuxBridge) Shutdown(_ context.Context) error { return nil }
+
+func (b *LinuxBridge) sessionResources() ([]helperProcess, []string) { return nil, nil }
+
 // NewReverseBridge returns an error on non-Linux platforms.
-func NewReverseBridge(ports []int, debug bool) (*ReverseBridge, error) {
-	return nil, fmt.Errorf("reverse bridge not available on this platform")
+func NewReverseBridge(_ context.Context, ports []int, debug bool) (*ReverseBridge, error) {
+	return nil, fmt.Errorf("%w: reverse bridge requires Linux", ErrSandboxUnsupported)
 }
 
 // Cleanup is a no-op on non-Linux platforms.
 func (b *ReverseBridge) Cleanup() {}
 
+// Shutdown is a no-op on non-Linux platforms.
+func (b *ReverseBridge) Shutdown(_ context.Context) error { return nil }
+
+func (b *ReverseBridge) sessionResources() ([]helperProcess, []string) { return nil, nil }
+
 // WrapCommandLinux returns an error on non-Linux platforms.
 func WrapCommandLinux(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, debug bool) (string, error) {
-	return "", fmt.Errorf("Linux sandbox not available on this platform")
+	return "", fmt.Errorf("%w: Linux sandbox requires Linux", ErrSandboxUnsupported)
 }
 
 // WrapCommandLinuxWithOptions returns an error on non-Linux platforms.
 func WrapCommandLinuxWithOptions(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, opts LinuxSandboxOptions) (string, error) {
-	return "", fmt.Errorf("Linux sandbox not available on this platform")
+	return "", fmt.Errorf("%w: Linux sandbox requires Linux", ErrSandboxUnsupported)
+}
+
+// LinuxSpec returns an error on non-Linux platforms.
+func LinuxSpec(cfg *config.Config, command string, bridge *LinuxBridge, reverseBridge *ReverseBridge, opts LinuxSandboxOptions) (*Spec, error) {
+	return nil, fmt.Errorf("%w: Linux sandbox requires Linux", ErrSandboxUnsupported)
 }
 
 // StartLinuxMonitor returns nil on non-Linux platforms.
-func StartLinuxMonitor(pid int, opts LinuxSandboxOptions) (*LinuxMonitors, error) {
+func StartLinuxMonitor(_ context.Context, pid int, opts LinuxSandboxOptions) (*LinuxMonitors, error) {
 	return nil, nil
 }
 
@@ -0,0 +1,27 @@
+//go:build linux
+
+package sandbox
+
+import "path/filepath"
+
+// caBundleMountArgs returns bwrap arguments that bind the CA bundle written
+// for network.inspectTLS into the sandbox read-only, at its own path, which
+// is in the host's /tmp that the sandbox replaces, and over each system CA
+// bundle, so that clients reading the system bundle directly rather than
+// the environment trust the session CA too.
+func caBundleMountArgs(bundle string) []string {
+	if bundle == "" {
+		return nil
+	}
+	args := []string{"--ro-bind", bundle, bundle}
+	seen := make(map[string]bool)
+	for _, p := range systemCABundles {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		args = append(args, "--ro-bind", bundle, resolved)
+	}
+	return args
+}
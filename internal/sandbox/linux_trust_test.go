@@ -0,0 +1,35 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCABundleMountArgs(t *testing.T) {
+	if args := caBundleMountArgs(""); args != nil {
+		t.Errorf("caBundleMountArgs(\"\") = %v, want nil", args)
+	}
+
+	dir := t.TempDir()
+	system := filepath.Join(dir, "ca-certificates.crt")
+	if err := os.WriteFile(system, []byte("HOST ROOTS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "cert.pem")
+	if err := os.Symlink(system, link); err != nil {
+		t.Fatal(err)
+	}
+	saved := systemCABundles
+	systemCABundles = []string{system, link, filepath.Join(dir, "missing.pem")}
+	defer func() { systemCABundles = saved }()
+
+	bundle := "/tmp/fence-ca-test.pem"
+	want := []string{"--ro-bind", bundle, bundle, "--ro-bind", bundle, system}
+	if args := caBundleMountArgs(bundle); !slices.Equal(args, want) {
+		t.Errorf("caBundleMountArgs() = %v, want %v", args, want)
+	}
+}
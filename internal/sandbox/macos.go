@@ -12,6 +12,8 @@ import (
 	"strings"
 
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
 )
 
 // sessionSuffix is a unique identifier for this process session.
@@ -31,15 +33,21 @@ type MacOSSandboxParams struct {
 	NeedsNetworkRestriction bool
 	HTTPProxyPort           int
 	SOCKSProxyPort          int
+	SOCKSUDPPort            int // The SOCKS proxy's UDP ASSOCIATE relay
 	AllowUnixSockets        []string
 	AllowAllUnixSockets     bool
 	AllowLocalBinding       bool
 	AllowLocalOutbound      bool
+	ExposedPorts            []int // Ports that accept inbound connections without AllowLocalBinding
 	ReadDenyPaths           []string
 	WriteAllowPaths         []string
 	WriteDenyPaths          []string
 	AllowPty                bool
 	AllowGitConfig          bool
+	AllowAudio              bool
+	AllowCamera             bool
+	ProxyAuth               bool     // Proxy URLs carry the credentials from proxyAuthEnvVar
+	ExtraRules              []string // Appended to the profile, from macos.extraProfileRules
 	Shell                   string
 }
 
@@ -418,6 +426,31 @@ func GenerateSandboxProfile(params MacOSSandboxParams) string {
 
 `)
 
+	// Media capture: denied by (deny default) unless explicitly allowed
+	if params.AllowAudio {
+		profile.WriteString(`; Audio (devices.allowAudio)
+(allow device-microphone)
+(allow mach-lookup
+  (global-name "com.apple.audio.audiohald")
+  (global-name "com.apple.audio.coreaudiod")
+  (global-name "com.apple.audio.AudioComponentRegistrar")
+)
+(allow iokit-open (iokit-user-client-class "IOAudioEngineUserClient"))
+
+`)
+	}
+	if params.AllowCamera {
+		profile.WriteString(`; Camera (devices.allowCamera)
+(allow device-camera)
+(allow mach-lookup
+  (global-name "com.apple.cmio.registerassistantservice")
+  (global-name "com.apple.cmio.VDCAssistant")
+  (global-name "com.apple.cmio.AppleCameraAssistant")
+)
+
+`)
+	}
+
 	// Network rules
 	profile.WriteString("; Network\n")
 	if !params.NeedsNetworkRestriction {
@@ -428,12 +461,20 @@ func GenerateSandboxProfile(params MacOSSandboxParams) string {
 			profile.WriteString(`(allow network-bind (local ip "localhost:*"))
 (allow network-inbound (local ip "localhost:*"))
 `)
-			// Process can make outbound connections to localhost
-			if params.AllowLocalOutbound {
-				profile.WriteString(`(allow network-outbound (local ip "localhost:*"))
-`)
+		} else {
+			// Only the exposed ports, on any address, like the host side of
+			// the reverse bridge on Linux
+			for _, port := range params.ExposedPorts {
+				profile.WriteString(fmt.Sprintf(`(allow network-bind (local ip "*:%d"))
+(allow network-inbound (local ip "*:%d"))
+`, port, port))
 			}
 		}
+		// Process can make outbound connections to localhost
+		if params.AllowLocalOutbound {
+			profile.WriteString(`(allow network-outbound (local ip "localhost:*"))
+`)
+		}
 
 		if params.AllowAllUnixSockets {
 			profile.WriteString("(allow network* (subpath \"/\"))\n")
@@ -457,6 +498,10 @@ func GenerateSandboxProfile(params MacOSSandboxParams) string {
 (allow network-outbound (remote ip "localhost:%d"))
 `, params.SOCKSProxyPort, params.SOCKSProxyPort, params.SOCKSProxyPort))
 		}
+
+		if params.SOCKSUDPPort > 0 {
+			profile.WriteString(fmt.Sprintf("(allow network-outbound (remote ip \"localhost:%d\"))\n", params.SOCKSUDPPort))
+		}
 	}
 	profile.WriteString("\n")
 
@@ -489,11 +534,82 @@ func GenerateSandboxProfile(params MacOSSandboxParams) string {
 `)
 	}
 
+	// User rules last, so they take precedence
+	if len(params.ExtraRules) > 0 {
+		profile.WriteString("\n; Extra rules (macos.extraProfileRules)\n")
+		for _, rule := range params.ExtraRules {
+			profile.WriteString(strings.TrimSpace(rule) + "\n")
+		}
+	}
+
 	return profile.String()
 }
 
 // WrapCommandMacOS wraps a command with macOS sandbox restrictions.
 func WrapCommandMacOS(cfg *config.Config, command string, httpPort, socksPort int, exposedPorts []int, debug bool) (string, error) {
+	return wrapCommandMacOS(newMacOSSandboxParams(cfg, command, httpPort, socksPort, exposedPorts, debug))
+}
+
+// wrapCommandMacOS runs params.Command under sandbox-exec with the profile
+// for params.
+func wrapCommandMacOS(params MacOSSandboxParams) (string, error) {
+	profile := GenerateSandboxProfile(params)
+
+	// Find shell
+	shell := params.Shell
+	if shell == "" {
+		shell = "bash"
+	}
+	shellPath, err := exec.LookPath(shell)
+	if err != nil {
+		return "", &MissingDependencyError{Binary: shell, Err: err}
+	}
+	if _, err := exec.LookPath("sandbox-exec"); err != nil {
+		return "", &MissingDependencyError{Binary: "sandbox-exec", Err: err}
+	}
+
+	// Build the command
+	// env VAR1=val1 VAR2=val2 sandbox-exec -p 'profile' shell -c 'command'
+	parts := []string{"env"}
+	for _, v := range macOSProxyEnv(params) {
+		if strings.Contains(v, proxyAuthRef) {
+			// Double quoted, so the shell expands the credentials
+			parts = append(parts, `"`+v+`"`)
+		} else {
+			parts = append(parts, ShellQuoteSingle(v))
+		}
+	}
+	parts = append(parts, ShellQuote([]string{"sandbox-exec", "-p", profile, shellPath, "-c", params.Command}))
+
+	return strings.Join(parts, " "), nil
+}
+
+// macOSProxyEnv returns the proxy environment variables for params.
+func macOSProxyEnv(params MacOSSandboxParams) []string {
+	if params.ProxyAuth {
+		return proxyEnvVars(params.HTTPProxyPort, params.SOCKSProxyPort, proxyAuthRef)
+	}
+	return GenerateProxyEnvVars(params.HTTPProxyPort, params.SOCKSProxyPort)
+}
+
+// MacOSSpec returns the enforcement artifacts WrapCommandMacOS would generate for command.
+func MacOSSpec(cfg *config.Config, command string, httpPort, socksPort int, exposedPorts []int) *Spec {
+	return macOSSpec(newMacOSSandboxParams(cfg, command, httpPort, socksPort, exposedPorts, false))
+}
+
+// macOSSpec returns the enforcement artifacts wrapCommandMacOS would
+// generate for params.
+func macOSSpec(params MacOSSandboxParams) *Spec {
+	return &Spec{
+		Platform:        platform.MacOS,
+		Command:         params.Command,
+		SeatbeltProfile: GenerateSandboxProfile(params),
+		Env:             macOSProxyEnv(params),
+	}
+}
+
+// newMacOSSandboxParams derives the Seatbelt profile parameters from config.
+func newMacOSSandboxParams(cfg *config.Config, command string, httpPort, socksPort int, exposedPorts []int, debug bool) MacOSSandboxParams {
 	// Check if allowedDomains contains "*" (wildcard = allow all direct network)
 	// In this mode, we still run the proxy for apps that respect HTTP_PROXY,
 	// but allow direct connections for apps that don't (like cursor-agent, opencode).
@@ -505,8 +621,9 @@ func WrapCommandMacOS(cfg *config.Config, command string, httpPort, socksPort in
 	// Build allow paths: default + configured
 	allowPaths := append(GetDefaultWritePaths(), cfg.Filesystem.AllowWrite...)
 
-	// Enable local binding if ports are exposed or if explicitly configured
-	allowLocalBinding := cfg.Network.AllowLocalBinding || len(exposedPorts) > 0
+	// Exposed ports are allowed on their own, as the reverse bridge exposes
+	// only them on Linux
+	allowLocalBinding := cfg.Network.AllowLocalBinding
 
 	allowLocalOutbound := allowLocalBinding
 	if cfg.Network.AllowLocalOutbound != nil {
@@ -518,8 +635,8 @@ func WrapCommandMacOS(cfg *config.Config, command string, httpPort, socksPort in
 	needsNetworkRestriction := !hasWildcardAllow && (needsNetwork || len(cfg.Network.AllowedDomains) == 0)
 
 	if debug && hasWildcardAllow {
-		fmt.Fprintf(os.Stderr, "[fence:macos] Wildcard allowedDomains detected - allowing direct network connections\n")
-		fmt.Fprintf(os.Stderr, "[fence:macos] Note: deniedDomains only enforced for apps that respect HTTP_PROXY\n")
+		logging.Debugf("macos", "Wildcard allowedDomains detected - allowing direct network connections")
+		logging.Debugf("macos", "Note: deniedDomains only enforced for apps that respect HTTP_PROXY")
 	}
 
 	params := MacOSSandboxParams{
@@ -531,40 +648,36 @@ func WrapCommandMacOS(cfg *config.Config, command string, httpPort, socksPort in
 		AllowAllUnixSockets:     cfg.Network.AllowAllUnixSockets,
 		AllowLocalBinding:       allowLocalBinding,
 		AllowLocalOutbound:      allowLocalOutbound,
-		ReadDenyPaths:           cfg.Filesystem.DenyRead,
+		ExposedPorts:            exposedPorts,
+		ReadDenyPaths:           cfg.Filesystem.DenyReadPaths(),
 		WriteAllowPaths:         allowPaths,
 		WriteDenyPaths:          cfg.Filesystem.DenyWrite,
 		AllowPty:                cfg.AllowPty,
 		AllowGitConfig:          cfg.Filesystem.AllowGitConfig,
+		AllowAudio:              cfg.Devices.AllowAudio,
+		AllowCamera:             cfg.Devices.AllowCamera,
+		ExtraRules:              cfg.MacOS.ExtraProfileRules,
 	}
 
-	if debug && len(exposedPorts) > 0 {
-		fmt.Fprintf(os.Stderr, "[fence:macos] Enabling local binding for exposed ports: %v\n", exposedPorts)
-	}
-	if debug && allowLocalBinding && !allowLocalOutbound {
-		fmt.Fprintf(os.Stderr, "[fence:macos] Blocking localhost outbound (AllowLocalOutbound=false)\n")
+	// XQuartz sets DISPLAY to its launchd socket, e.g. /private/tmp/com.apple.launchd.xxx/org.xquartz:0.
+	// Native macOS windows go through WindowServer, which the profile never allows.
+	if cfg.GUI.AllowDisplay {
+		if socket, _, ok := strings.Cut(os.Getenv("DISPLAY"), ":"); ok && filepath.IsAbs(socket) {
+			params.AllowUnixSockets = append(slices.Clone(params.AllowUnixSockets), filepath.Dir(socket))
+		} else if debug {
+			logging.Debugf("macos", "gui.allowDisplay: no XQuartz socket in DISPLAY, nothing to expose")
+		}
 	}
 
-	profile := GenerateSandboxProfile(params)
-
-	// Find shell
-	shell := params.Shell
-	if shell == "" {
-		shell = "bash"
+	if debug && cfg.Security.MapToNobody {
+		logging.Debugf("macos", "security.mapToNobody is Linux-only, ignoring")
 	}
-	shellPath, err := exec.LookPath(shell)
-	if err != nil {
-		return "", fmt.Errorf("shell %q not found: %w", shell, err)
+	if debug && len(exposedPorts) > 0 {
+		logging.Debugf("macos", "Allowing inbound connections on exposed ports: %v", exposedPorts)
+	}
+	if debug && (allowLocalBinding || len(exposedPorts) > 0) && !allowLocalOutbound {
+		logging.Debugf("macos", "Blocking localhost outbound (AllowLocalOutbound=false)")
 	}
 
-	proxyEnvs := GenerateProxyEnvVars(httpPort, socksPort)
-
-	// Build the command
-	// env VAR1=val1 VAR2=val2 sandbox-exec -p 'profile' shell -c 'command'
-	var parts []string
-	parts = append(parts, "env")
-	parts = append(parts, proxyEnvs...)
-	parts = append(parts, "sandbox-exec", "-p", profile, shellPath, "-c", command)
-
-	return ShellQuote(parts), nil
+	return params
 }
@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
@@ -176,3 +177,180 @@ func TestMacOS_ProfileNetworkSection(t *testing.T) {
 		})
 	}
 }
+
+// TestMacOS_ProfileAllowsSOCKSUDPRelay verifies that the profile lets the
+// sandbox send datagrams to the SOCKS proxy's UDP relay.
+func TestMacOS_ProfileAllowsSOCKSUDPRelay(t *testing.T) {
+	params := MacOSSandboxParams{
+		Command:                 "echo test",
+		NeedsNetworkRestriction: true,
+		SOCKSProxyPort:          1080,
+		SOCKSUDPPort:            5353,
+	}
+	want := `(allow network-outbound (remote ip "localhost:5353"))`
+	if profile := GenerateSandboxProfile(params); !strings.Contains(profile, want) {
+		t.Errorf("profile should contain %q, got:\n%s", want, profile)
+	}
+}
+
+// TestMacOS_AllowDisplayExposesXQuartzSocket verifies that gui.allowDisplay
+// allows the XQuartz launchd socket named by DISPLAY, and only then.
+func TestMacOS_AllowDisplayExposesXQuartzSocket(t *testing.T) {
+	t.Setenv("DISPLAY", "/private/tmp/com.apple.launchd.abc/org.xquartz:0")
+	socketDir := "/private/tmp/com.apple.launchd.abc"
+
+	cfg := config.Default()
+	cfg.Network.AllowUnixSockets = []string{"/var/run/docker.sock"}
+
+	params := newMacOSSandboxParams(cfg, "xeyes", 3128, 1080, nil, false)
+	if slices.Contains(params.AllowUnixSockets, socketDir) {
+		t.Errorf("display hidden: AllowUnixSockets = %v, should not include the XQuartz socket", params.AllowUnixSockets)
+	}
+
+	cfg.GUI.AllowDisplay = true
+	params = newMacOSSandboxParams(cfg, "xeyes", 3128, 1080, nil, false)
+	if !slices.Contains(params.AllowUnixSockets, socketDir) {
+		t.Errorf("display allowed: AllowUnixSockets = %v, want %s", params.AllowUnixSockets, socketDir)
+	}
+	if len(cfg.Network.AllowUnixSockets) != 1 {
+		t.Errorf("config was modified: %v", cfg.Network.AllowUnixSockets)
+	}
+}
+
+// TestMacOS_MediaDevices verifies that microphone and camera access is only
+// added to the profile when devices.allowAudio/allowCamera is set.
+func TestMacOS_MediaDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices config.DevicesConfig
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "denied by default",
+			notWant: []string{"device-microphone", "device-camera", "com.apple.audio.audiohald", "com.apple.cmio.VDCAssistant"},
+		},
+		{
+			name:    "audio allowed",
+			devices: config.DevicesConfig{AllowAudio: true},
+			want:    []string{"(allow device-microphone)", "com.apple.audio.audiohald"},
+			notWant: []string{"device-camera"},
+		},
+		{
+			name:    "camera allowed",
+			devices: config.DevicesConfig{AllowCamera: true},
+			want:    []string{"(allow device-camera)", "com.apple.cmio.VDCAssistant"},
+			notWant: []string{"device-microphone"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Devices = tt.devices
+			profile := GenerateSandboxProfile(newMacOSSandboxParams(cfg, "true", 3128, 1080, nil, false))
+			for _, s := range tt.want {
+				if !strings.Contains(profile, s) {
+					t.Errorf("profile missing %q", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(profile, s) {
+					t.Errorf("profile should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+// TestMacOS_ExtraProfileRules verifies that macos.extraProfileRules are
+// appended after fence's own rules, so they take precedence.
+func TestMacOS_ExtraProfileRules(t *testing.T) {
+	cfg := config.Default()
+	cfg.AllowPty = true
+	cfg.MacOS.ExtraProfileRules = []string{
+		`(allow mach-lookup (global-name "com.apple.pasteboard.1"))`,
+		"\n(allow file-read* (literal \"/Library/Preferences/com.example.plist\"))\n",
+	}
+
+	profile := GenerateSandboxProfile(newMacOSSandboxParams(cfg, "pbpaste", 3128, 1080, nil, false))
+	want := `
+; Extra rules (macos.extraProfileRules)
+(allow mach-lookup (global-name "com.apple.pasteboard.1"))
+(allow file-read* (literal "/Library/Preferences/com.example.plist"))
+`
+	if !strings.HasSuffix(profile, want) {
+		t.Errorf("profile should end with the extra rules, got:\n%s", profile)
+	}
+
+	cfg.MacOS.ExtraProfileRules = nil
+	if profile := GenerateSandboxProfile(newMacOSSandboxParams(cfg, "pbpaste", 3128, 1080, nil, false)); strings.Contains(profile, "extraProfileRules") {
+		t.Error("profile without extra rules should not have their section")
+	}
+}
+
+// TestMacOS_ExposedPorts verifies that ports exposed with -p accept inbound
+// connections on their own, as the reverse bridge exposes them on Linux, and
+// that other ports stay blocked.
+func TestMacOS_ExposedPorts(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name    string
+		network config.NetworkConfig
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "exposed ports only",
+			want: []string{
+				`(allow network-bind (local ip "*:3000"))`,
+				`(allow network-inbound (local ip "*:3000"))`,
+				`(allow network-bind (local ip "*:8080"))`,
+				`(allow network-inbound (local ip "*:8080"))`,
+			},
+			notWant: []string{
+				`(allow network-bind (local ip "localhost:*"))`,
+				`(allow network-inbound (local ip "localhost:*"))`,
+				`(allow network-outbound (local ip "localhost:*"))`,
+				`:3001"`,
+			},
+		},
+		{
+			name:    "exposed ports with local outbound",
+			network: config.NetworkConfig{AllowLocalOutbound: &yes},
+			want: []string{
+				`(allow network-inbound (local ip "*:3000"))`,
+				`(allow network-outbound (local ip "localhost:*"))`,
+			},
+			notWant: []string{`(allow network-inbound (local ip "localhost:*"))`},
+		},
+		{
+			name:    "local binding allows every port",
+			network: config.NetworkConfig{AllowLocalBinding: true},
+			want: []string{
+				`(allow network-bind (local ip "localhost:*"))`,
+				`(allow network-inbound (local ip "localhost:*"))`,
+				`(allow network-outbound (local ip "localhost:*"))`,
+			},
+			notWant: []string{`"*:3000"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Network = tt.network
+			profile := GenerateSandboxProfile(newMacOSSandboxParams(cfg, "npm start", 3128, 1080, []int{3000, 8080}, false))
+			for _, s := range tt.want {
+				if !strings.Contains(profile, s) {
+					t.Errorf("profile missing %q", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(profile, s) {
+					t.Errorf("profile should not contain %q", s)
+				}
+			}
+		})
+	}
+}
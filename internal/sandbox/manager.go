@@ -1,36 +1,94 @@
 package sandbox
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/Use-Tusk/fence/internal/allowance"
 	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/history"
+	"github.com/Use-Tusk/fence/internal/logging"
 	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
 	"github.com/Use-Tusk/fence/internal/proxy"
+	"github.com/Use-Tusk/fence/internal/stats"
+	"github.com/Use-Tusk/fence/internal/telemetry"
 )
 
+// Proxy is a proxy server the sandboxed command's traffic is routed through.
+// proxy.HTTPProxy and proxy.SOCKSProxy implement it; SetHTTPProxy and
+// SetSOCKSProxy substitute other implementations.
+type Proxy interface {
+	// Start starts listening on localhost and returns the port.
+	Start(ctx context.Context) (int, error)
+	// Stop stops the proxy, waiting for in-flight connections until ctx is done.
+	Stop(ctx context.Context) error
+	// Port returns the port the proxy listens on, once started.
+	Port() int
+}
+
 // Manager handles sandbox initialization and command wrapping.
 type Manager struct {
 	config        *config.Config
-	httpProxy     *proxy.HTTPProxy
-	socksProxy    *proxy.SOCKSProxy
+	httpProxy     Proxy
+	socksProxy    Proxy
+	customHTTP    Proxy // Set by SetHTTPProxy; used instead of the built-in proxy
+	customSOCKS   Proxy // Set by SetSOCKSProxy
 	linuxBridge   *LinuxBridge
 	reverseBridge *ReverseBridge
+	dbusProxy     *DBusProxy
+	appArmor      *appArmorProfiles
+	selinux       *selinuxModules
+	readMasks     *readMasks         // Masks of denyRead paths, for the fanotify monitor
+	cgroup        *runCgroup         // Enforces the resources limits and write quota, if any
+	caBundle      string             // CA bundle the sandbox trusts, for network.inspectTLS
+	proxyCreds    *proxy.Credentials // For network.proxyAuth
+	dnsServer     *proxy.DNSServer   // For network.filterDNS
 	httpPort      int
 	socksPort     int
+	socksUDPPort  int // UDP ASSOCIATE relay of the built-in SOCKS proxy
 	exposedPorts  []int
+	backend       string
+	overlay       *WorkspaceOverlay
 	debug         bool
 	monitor       bool
+	log           *logging.Logger
+	filter        proxy.FilterFunc // Set by WithFilter
+	customPolicy  Policy           // Set by WithPolicy
+	noHTTPProxy   bool
+	noSOCKSProxy  bool
+	noSandbox     bool // Proxies only; see WithoutSandbox
+	shareNetwork  bool // Host network, no proxies; see WithoutNetworkSandbox
+	terminal      bool // Commands run on their own PTY; see WithTerminal
+	violations    *policy.ViolationLog
+	downloads     *policy.DownloadLog
+	execs         *policy.ExecLog
+	history       *history.Recorder
+	stats         *stats.Recorder
+	allowances    *allowance.Watcher
+	telemetry     *telemetry.Exporter
+	domainFilter  *atomic.Pointer[proxy.FilterFunc]
+	requests      *atomic.Int64 // Requests the proxies were asked to allow
+	conns         *proxy.ConnTracker
+	tracked       *tracked
+	statePath     string // Session state file, removed by a clean Shutdown
 	initialized   bool
+	derived       bool // Shares another Manager's infrastructure; Cleanup is a no-op
 }
 
-// NewManager creates a new sandbox manager.
+// NewManager creates a new sandbox manager. It is New with WithDebug and
+// WithMonitor, kept for compatibility.
 func NewManager(cfg *config.Config, debug, monitor bool) *Manager {
-	return &Manager{
-		config:  cfg,
-		debug:   debug,
-		monitor: monitor,
-	}
+	m, _ := New(cfg, WithDebug(debug), WithMonitor(monitor)) // These options cannot fail
+	return m
 }
 
 // SetExposedPorts sets the ports to expose for inbound connections.
@@ -38,111 +96,1021 @@ func (m *Manager) SetExposedPorts(ports []int) {
 	m.exposedPorts = ports
 }
 
+// SetHTTPProxy replaces the built-in HTTP proxy with p, such as an adapter
+// for an existing egress gateway. The Manager starts p in Initialize and
+// stops it in Shutdown, and the sandbox routes HTTP traffic to its port as
+// usual. Fence's network filtering, TLS and request rules, download
+// inspection, and request counting are part of the built-in proxy, so with p
+// they are p's responsibility, as is checking the Proxy-Authorization
+// header with network.proxyAuth. Must be called before Initialize.
+func (m *Manager) SetHTTPProxy(p Proxy) {
+	m.customHTTP = p
+}
+
+// SetSOCKSProxy replaces the built-in SOCKS5 proxy with p, as SetHTTPProxy
+// does for HTTP. Must be called before Initialize.
+func (m *Manager) SetSOCKSProxy(p Proxy) {
+	m.customSOCKS = p
+}
+
+// SetBackend selects the Linux sandbox backend: BackendBwrap, BackendNative,
+// BackendGVisor, BackendAppArmor, or BackendSELinux. The default ("") is
+// bwrap, or AppArmor or SELinux when neither bwrap nor Landlock is available.
+// It has no effect on other platforms. Must be called before Initialize.
+func (m *Manager) SetBackend(name string) error {
+	if err := ValidateBackend(name); err != nil {
+		return err
+	}
+	m.backend = name
+	return nil
+}
+
+// SetAuditMode enables or disables audit mode. In audit mode, network and
+// command policy are not enforced; operations they would deny are allowed and
+// recorded in Violations instead. Filesystem restrictions are still enforced.
+// Must be called before Initialize.
+func (m *Manager) SetAuditMode(enabled bool) {
+	m.violations.SetAudit(enabled)
+}
+
+// Violations returns the log of operations denied during the run (or, in
+// audit mode, that would have been denied). Filesystem denials are only
+// recorded by the violation monitors.
+func (m *Manager) Violations() *policy.ViolationLog {
+	return m.violations
+}
+
+// Downloads returns the log of downloads flagged by network.downloads
+// during the run.
+func (m *Manager) Downloads() *policy.DownloadLog {
+	return m.downloads
+}
+
+// Execs returns the log of processes executed inside the sandbox, filled
+// by StartExecTrace.
+func (m *Manager) Execs() *policy.ExecLog {
+	return m.execs
+}
+
+// Connections returns the connections the built-in proxies have open, and
+// how many each host has had, for live monitoring.
+func (m *Manager) Connections() *proxy.ConnTracker {
+	return m.conns
+}
+
+// Stats returns the number of requests the proxies handled during the run
+// and what was blocked, as recorded in Violations.
+func (m *Manager) Stats() policy.RunStats {
+	return m.violations.Stats(m.requests.Load())
+}
+
+// LimitEvents counts the times the resources limits and the disk write quota
+// intervened.
+type LimitEvents struct {
+	// OOMKills is the number of processes killed for exceeding
+	// resources.memory.
+	OOMKills int64 `json:"oomKills"`
+	// PidsLimited is the number of forks refused at resources.pidsMax.
+	PidsLimited int64 `json:"pidsLimited"`
+	// WriteQuotaExceeded is set once the commands have written
+	// filesystem.maxWriteBytes to disk and were killed.
+	WriteQuotaExceeded bool `json:"writeQuotaExceeded"`
+}
+
+// needsCgroup reports whether cfg sets limits enforced with a cgroup.
+func needsCgroup(cfg *config.Config) bool {
+	return cfg.Resources.Limited() || cfg.Filesystem.MaxWriteBytes > 0
+}
+
+// needsEgressFilter reports whether the command's direct connections are
+// limited with cgroup eBPF programs: on Linux, when the sandbox cannot have a
+// network namespace of its own and eBPF is available. Otherwise only the
+// commands that use the proxy environment variables are filtered.
+func (m *Manager) needsEgressFilter() bool {
+	if platform.Detect() != platform.Linux || m.noSandbox || m.shareNetwork || slices.Contains(m.config.Network.AllowedDomains, "*") {
+		return false
+	}
+	features := DetectLinuxFeatures()
+	return !features.CanUnshareNetWith(m.backend) && features.HasEBPF
+}
+
+// LimitEvents reports how often the resources limits and the disk write
+// quota intervened in the commands run so far. Read it after a command exits to tell whether a
+// limit killed it.
+func (m *Manager) LimitEvents() LimitEvents {
+	return m.cgroup.events()
+}
+
+// Subscribe registers fn to receive each violation as it is detected: proxy
+// blocks, command blocks, and, when the monitors are started with Violations,
+// log-stream or eBPF reports. fn runs on the detecting goroutine and must not
+// block. The returned function removes the subscription.
+func (m *Manager) Subscribe(fn func(policy.Event)) (unsubscribe func()) {
+	return m.violations.Subscribe(fn)
+}
+
+// WithConfig returns a Manager that wraps commands under cfg while sharing
+// m's proxies, bridges, and violation log, so several sub-sandboxes with
+// different filesystem and command rules can run at once without starting
+// new proxies. m must be initialized. Network filtering follows m's config,
+// since the proxies are shared. Cleanup on the returned Manager does nothing;
+// clean up m once all of its derived Managers are done. Processes tracked on
+// the returned Manager are stopped by m's Shutdown.
+func (m *Manager) WithConfig(cfg *config.Config) (*Manager, error) {
+	if !m.initialized {
+		return nil, errors.New("sandbox manager is not initialized")
+	}
+	derived := *m
+	derived.config = cfg
+	if m.customPolicy != nil {
+		derived.config = policyConfig(cfg, m.customPolicy)
+	}
+	derived.derived = true
+	return &derived, nil
+}
+
 // Initialize sets up the sandbox infrastructure (proxies, etc.).
-func (m *Manager) Initialize() error {
+// The context bounds setup time; canceling it aborts initialization and
+// releases anything started so far. It does not affect an initialized sandbox.
+func (m *Manager) Initialize(ctx context.Context) error {
 	if m.initialized {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	if !platform.IsSupported() {
-		return fmt.Errorf("sandbox is not supported on platform: %s", platform.Detect())
+		return fmt.Errorf("%w: %s", ErrSandboxUnsupported, platform.Detect())
+	}
+	if m.config.Filesystem.PrivateHome && platform.Detect() != platform.Linux && !m.noSandbox {
+		return errors.New("filesystem.privateHome is only supported on Linux")
+	}
+	// The proxies are reached through the bridge sockets only
+	unixSockets := m.config.Network.UnixSocketProxies && !m.shareNetwork
+	if unixSockets && (platform.Detect() != platform.Linux || m.noSandbox) {
+		return errors.New("network.unixSocketProxies requires the Linux sandbox")
+	}
+	filterDNS := m.config.Network.FilterDNS && !m.shareNetwork
+	if filterDNS && (platform.Detect() != platform.Linux || m.noSandbox) {
+		return errors.New("network.filterDNS requires the Linux sandbox")
 	}
 
-	filter := proxy.CreateDomainFilter(m.config, m.debug)
+	// Release whatever crashed fence sessions left behind (helpers, sockets, ports)
+	recoverSessions(sessionStateDir(), m.log)
 
-	m.httpProxy = proxy.NewHTTPProxy(filter, m.debug, m.monitor)
-	httpPort, err := m.httpProxy.Start()
-	if err != nil {
-		return fmt.Errorf("failed to start HTTP proxy: %w", err)
+	// Create the cgroup before starting any helpers, which would otherwise
+	// share fence's cgroup and keep it from enabling controllers
+	defer func() {
+		if !m.initialized {
+			m.removeTrustBundle()
+		}
+	}()
+	filterEgress := m.needsEgressFilter()
+	if (needsCgroup(m.config) || filterEgress) && !m.noSandbox {
+		cg, err := newRunCgroup(m.config)
+		switch {
+		case err != nil && needsCgroup(m.config):
+			return fmt.Errorf("failed to set up resource limits: %w", err)
+		case err != nil:
+			m.log.Warnf("", "Direct connections are not restricted without a network namespace: %v", err)
+			filterEgress = false
+		default:
+			m.cgroup = cg
+			defer func() {
+				if !m.initialized {
+					_ = m.cgroup.Shutdown(context.Background())
+					m.cgroup = nil
+				}
+			}()
+			if needsCgroup(m.config) {
+				m.logDebug("Resource limits: %s", strings.Join(cg.limits(), ", "))
+			}
+		}
 	}
-	m.httpPort = httpPort
 
-	m.socksProxy = proxy.NewSOCKSProxy(filter, m.debug, m.monitor)
-	socksPort, err := m.socksProxy.Start()
+	var filter proxy.FilterFunc
+	if m.customPolicy != nil {
+		filter = policyFilter(m.customPolicy, m.violations, m.debug || m.monitor)
+		if m.stats != nil {
+			filter = countDomains(filter, nil, m.stats)
+		}
+	} else if m.filter != nil {
+		filter = proxy.RecordFilterDenials(m.filter, m.violations, m.debug || m.monitor)
+		if m.stats != nil {
+			filter = countDomains(filter, nil, m.stats)
+		}
+	} else {
+		// Indirect, so SetNetworkPolicy can replace the rules
+		m.domainFilter = &atomic.Pointer[proxy.FilterFunc]{}
+		m.setDomainFilter(m.config)
+		filter = func(host string, port int) bool { return (*m.domainFilter.Load())(host, port) }
+	}
+	filter = proxy.CountRequests(filter, m.requests)
+	if m.history != nil {
+		filter = recordConnections(filter, m.history)
+	}
+	if m.telemetry != nil {
+		filter = exportConnections(filter, m.telemetry)
+	}
+
+	tlsEnforcer := proxy.NewTLSEnforcer(m.config, m.violations, m.debug || m.monitor)
+	sniVerifier := proxy.NewSNIVerifier(m.violations, m.debug || m.monitor)
+	limiter := proxy.NewLimiter(m.config, m.violations, m.debug || m.monitor)
+	downloads, err := proxy.NewDownloadInspector(m.config, m.downloads, m.debug || m.monitor)
 	if err != nil {
-		_ = m.httpProxy.Stop()
-		return fmt.Errorf("failed to start SOCKS proxy: %w", err)
+		return fmt.Errorf("failed to set up download inspection: %w", err)
+	}
+	upstream, err := proxy.NewUpstream(m.config, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if upstream != nil {
+		m.logDebug("Forwarding allowed connections through upstream proxy %s", upstream)
+	}
+	resolver, err := proxy.NewResolver(m.config.Network.DNS, upstream)
+	if err != nil {
+		return err
+	}
+	if resolver != nil {
+		m.logDebug("Resolving names with %s (network.dns)", resolver)
+	}
+	if m.config.Network.ProxyAuth {
+		m.proxyCreds = proxy.NewCredentials()
+		m.logDebug("Proxies require the session's credentials (network.proxyAuth)")
 	}
-	m.socksPort = socksPort
 
-	// On Linux, set up the socat bridges
-	if platform.Detect() == platform.Linux {
-		bridge, err := NewLinuxBridge(m.httpPort, m.socksPort, m.debug)
+	switch {
+	case m.noHTTPProxy:
+	case m.customHTTP != nil:
+		m.httpProxy = m.customHTTP
+	default:
+		httpProxy := proxy.NewHTTPProxy(filter, m.debug, m.monitor)
+		httpProxy.SetTLSEnforcer(tlsEnforcer)
+		httpProxy.SetSNIVerifier(sniVerifier)
+		httpProxy.SetLimiter(limiter)
+		httpProxy.SetConnTracker(m.conns)
+		httpProxy.SetRequestEnforcer(proxy.NewRequestEnforcer(m.config, m.violations, m.debug || m.monitor))
+		httpProxy.SetDownloadInspector(downloads)
+		httpProxy.SetUpstream(upstream)
+		httpProxy.SetResolver(resolver)
+		httpProxy.SetCredentials(m.proxyCreds)
+		if m.config.Network.InspectTLS {
+			if err := m.setUpTLSInspection(httpProxy); err != nil {
+				return err
+			}
+		}
+		m.httpProxy = httpProxy
+	}
+	if m.httpProxy != nil && !unixSockets {
+		httpPort, err := m.httpProxy.Start(ctx)
 		if err != nil {
-			_ = m.httpProxy.Stop()
-			_ = m.socksProxy.Stop()
-			return fmt.Errorf("failed to initialize Linux bridge: %w", err)
+			return fmt.Errorf("failed to start HTTP proxy: %w", err)
+		}
+		m.httpPort = httpPort
+	}
+
+	switch {
+	case m.noSOCKSProxy:
+	case m.customSOCKS != nil:
+		m.socksProxy = m.customSOCKS
+	default:
+		socksProxy := proxy.NewSOCKSProxy(filter, m.debug, m.monitor)
+		socksProxy.SetTLSEnforcer(tlsEnforcer)
+		socksProxy.SetSNIVerifier(sniVerifier)
+		socksProxy.SetLimiter(limiter)
+		socksProxy.SetConnTracker(m.conns)
+		socksProxy.SetUpstream(upstream)
+		socksProxy.SetResolver(resolver)
+		socksProxy.SetCredentials(m.proxyCreds)
+		// The Linux sandbox's network namespace cannot reach a relay on the host
+		socksProxy.SetUDPRelay(platform.Detect() != platform.Linux || m.noSandbox)
+		m.socksProxy = socksProxy
+	}
+	if m.socksProxy != nil && !unixSockets {
+		socksPort, err := m.socksProxy.Start(ctx)
+		if err != nil {
+			m.stopProxies()
+			return fmt.Errorf("failed to start SOCKS proxy: %w", err)
+		}
+		m.socksPort = socksPort
+		if p, ok := m.socksProxy.(*proxy.SOCKSProxy); ok {
+			m.socksUDPPort = p.UDPPort()
+		}
+	}
+
+	// On Linux, set up the socat bridges
+	if platform.Detect() == platform.Linux && !m.noSandbox {
+		if unixSockets {
+			if err := m.serveOnSockets(ctx); err != nil {
+				m.stopProxies()
+				return err
+			}
+		} else if m.httpProxy != nil || m.socksProxy != nil {
+			bridge, err := NewLinuxBridge(ctx, m.httpPort, m.socksPort, m.debug)
+			if err != nil {
+				m.stopProxies()
+				return fmt.Errorf("failed to initialize Linux bridge: %w", err)
+			}
+			m.linuxBridge = bridge
+		}
+		if filterDNS {
+			if err := m.serveDNS(ctx, filter, resolver); err != nil {
+				m.cleanupLinuxBridge()
+				m.stopProxies()
+				return err
+			}
 		}
-		m.linuxBridge = bridge
 
 		// Set up reverse bridge for exposed ports (inbound connections)
 		// Only needed when network namespace is available - otherwise they share the network
 		features := DetectLinuxFeatures()
-		if len(m.exposedPorts) > 0 && features.CanUnshareNet {
-			reverseBridge, err := NewReverseBridge(m.exposedPorts, m.debug)
+		if len(m.exposedPorts) > 0 && features.CanUnshareNetWith(m.backend) && !m.shareNetwork {
+			reverseBridge, err := NewReverseBridge(ctx, m.exposedPorts, m.debug)
 			if err != nil {
-				m.linuxBridge.Cleanup()
-				_ = m.httpProxy.Stop()
-				_ = m.socksProxy.Stop()
+				m.cleanupLinuxBridge()
+				m.stopProxies()
 				return fmt.Errorf("failed to initialize reverse bridge: %w", err)
 			}
 			m.reverseBridge = reverseBridge
 		} else if len(m.exposedPorts) > 0 && m.debug {
 			m.logDebug("Skipping reverse bridge (no network namespace, ports accessible directly)")
 		}
+
+		dbusProxy, err := NewDBusProxy(ctx, m.config.DBus, m.debug)
+		if err != nil {
+			if m.reverseBridge != nil {
+				m.reverseBridge.Cleanup()
+			}
+			m.cleanupLinuxBridge()
+			m.stopProxies()
+			return fmt.Errorf("failed to initialize D-Bus proxy: %w", err)
+		}
+		m.dbusProxy = dbusProxy
 	}
 
+	if filterEgress {
+		if err := m.cgroup.restrictEgress(m.config, m.linuxBridge != nil); err != nil {
+			m.log.Warnf("", "Direct connections are not restricted without a network namespace: %v", err)
+		} else {
+			m.logDebug("No network namespace; restricting direct connections: %s", strings.Join(m.cgroup.limits(), ", "))
+		}
+	}
+
+	m.writeSessionState()
 	m.initialized = true
 	m.logDebug("Sandbox manager initialized (HTTP proxy: %d, SOCKS proxy: %d)", m.httpPort, m.socksPort)
 	return nil
 }
 
-// WrapCommand wraps a command with sandbox restrictions.
+// serveDNS starts the filtering DNS resolver on a bridge socket, for
+// network.filterDNS, forwarding allowed queries to resolver or, if it is nil,
+// the host's nameserver.
+func (m *Manager) serveDNS(ctx context.Context, filter proxy.FilterFunc, resolver *proxy.Resolver) error {
+	if m.linuxBridge == nil {
+		bridge, err := NewLinuxBridge(ctx, 0, 0, m.debug)
+		if err != nil {
+			return fmt.Errorf("failed to initialize Linux bridge: %w", err)
+		}
+		m.linuxBridge = bridge
+	}
+	listener, err := m.linuxBridge.listenDNS(ctx, m.config.Network.DNS.Search)
+	if err != nil {
+		return err
+	}
+	dns := proxy.NewDNSServer(filter, m.debug, m.monitor)
+	dns.SetResolver(resolver)
+	dns.Serve(listener)
+	m.dnsServer = dns
+	return nil
+}
+
+// socketProxy is a Proxy that can serve on a listener it is given, as the
+// built-in proxies can.
+type socketProxy interface {
+	Serve(listener net.Listener)
+}
+
+// serveOnSockets starts the proxies on the bridge sockets, for
+// network.unixSocketProxies, leaving them without a TCP port.
+func (m *Manager) serveOnSockets(ctx context.Context) error {
+	httpProxy, httpOK := m.httpProxy.(socketProxy)
+	socksProxy, socksOK := m.socksProxy.(socketProxy)
+	if (m.httpProxy != nil && !httpOK) || (m.socksProxy != nil && !socksOK) {
+		return errors.New("network.unixSocketProxies requires proxies that can serve on a Unix socket")
+	}
+	bridge, httpListener, socksListener, err := NewLinuxSocketBridge(ctx, httpOK, socksOK, m.debug)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Linux bridge: %w", err)
+	}
+	if httpListener != nil {
+		httpProxy.Serve(httpListener)
+	}
+	if socksListener != nil {
+		socksProxy.Serve(socksListener)
+	}
+	m.linuxBridge = bridge
+	m.logDebug("Proxies listen only on Unix sockets (network.unixSocketProxies)")
+	return nil
+}
+
+// setUpTLSInspection gives httpProxy a session CA and writes the bundle the
+// sandbox trusts in its place. The bundle is removed again if Initialize
+// fails.
+func (m *Manager) setUpTLSInspection(httpProxy *proxy.HTTPProxy) error {
+	ca, err := proxy.NewCertAuthority()
+	if err != nil {
+		return fmt.Errorf("failed to set up TLS inspection: %w", err)
+	}
+	bundle, err := writeTrustBundle(ca.CertPEM())
+	if err != nil {
+		return fmt.Errorf("failed to set up TLS inspection: %w", err)
+	}
+	httpProxy.SetCertAuthority(ca)
+	m.caBundle = bundle
+	m.logDebug("Inspecting TLS to network.httpRules domains (CA bundle: %s)", bundle)
+	return nil
+}
+
+// removeTrustBundle removes the CA bundle written for network.inspectTLS,
+// if there is one.
+func (m *Manager) removeTrustBundle() {
+	if m.caBundle != "" {
+		_ = os.Remove(m.caBundle)
+		m.caBundle = ""
+	}
+}
+
+// WrapCommand wraps a command with sandbox restrictions, initializing the
+// sandbox with ctx first if needed.
 // Returns an error if the command is blocked by policy.
-func (m *Manager) WrapCommand(command string) (string, error) {
+func (m *Manager) WrapCommand(ctx context.Context, command string) (string, error) {
+	if m.noSandbox {
+		return "", errNoSandbox
+	}
 	if !m.initialized {
-		if err := m.Initialize(); err != nil {
+		if err := m.Initialize(ctx); err != nil {
 			return "", err
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
 	// Check if command is blocked by policy
-	if err := CheckCommand(command, m.config); err != nil {
+	err := m.checkCommand(command)
+	m.history.Record(policy.KindCommand, command, err == nil)
+	m.countCommand(command)
+	if err != nil {
 		return "", err
 	}
+	for _, c := range DetectToolConflicts(command, m.config) {
+		m.log.Warnf("", "%s", c)
+	}
 
 	plat := platform.Detect()
 	switch plat {
 	case platform.MacOS:
-		return WrapCommandMacOS(m.config, command, m.httpPort, m.socksPort, m.exposedPorts, m.debug)
+		if m.config != nil && m.config.Command.UseExecEnforcement() {
+			m.log.Warnf("macos", "command.enforceExec is not supported on macOS; commands are only checked before they start")
+		}
+		return wrapCommandMacOS(m.macOSParams(command, m.debug))
 	case platform.Linux:
-		return WrapCommandLinux(m.config, command, m.linuxBridge, m.reverseBridge, m.debug)
+		return WrapCommandLinuxWithOptions(m.config, command, m.linuxBridge, m.reverseBridge, m.linuxOptions())
 	default:
-		return "", fmt.Errorf("unsupported platform: %s", plat)
+		return "", fmt.Errorf("%w: %s", ErrSandboxUnsupported, plat)
+	}
+}
+
+// WrapArgv is WrapCommand for a command given as an argument vector. Each
+// element reaches the sandboxed process as exactly one argument, with no
+// shell expansion; argv[0] is looked up in PATH.
+func (m *Manager) WrapArgv(ctx context.Context, argv []string) (string, error) {
+	if len(argv) == 0 {
+		return "", errors.New("empty command")
 	}
+	return m.WrapCommand(ctx, ShellQuote(argv))
 }
 
-// Cleanup stops the proxies and cleans up resources.
+// Spec returns the enforcement artifacts WrapCommand would use for command
+// without running it. The sandbox is initialized first, so proxy ports and
+// bridge sockets match a real run.
+// Returns an error if the command is blocked by policy.
+func (m *Manager) Spec(ctx context.Context, command string) (*Spec, error) {
+	if m.noSandbox {
+		return nil, errNoSandbox
+	}
+	if !m.initialized {
+		if err := m.Initialize(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.checkCommand(command); err != nil {
+		return nil, err
+	}
+
+	plat := platform.Detect()
+	switch plat {
+	case platform.MacOS:
+		return macOSSpec(m.macOSParams(command, false)), nil
+	case platform.Linux:
+		return LinuxSpec(m.config, command, m.linuxBridge, m.reverseBridge, m.linuxOptions())
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSandboxUnsupported, plat)
+	}
+}
+
+// StartMonitor starts the platform's violation monitor for the sandboxed
+// command with the given pid, recording filesystem (and direct network)
+// denials in Violations: the sandbox log stream on macOS (pid is unused), or
+// the eBPF monitor on Linux, which needs CAP_BPF or root. Without eBPF, the
+// fanotify monitor reports reads of denyRead paths instead, if the Manager
+// was created WithMonitor, which masks them for it. Call it right after
+// starting the command, and call stop once it exits. The monitor also stops
+// when ctx is canceled, and is stopped by Shutdown if still running.
+func (m *Manager) StartMonitor(ctx context.Context, pid int) (stop func(), err error) {
+	plat := platform.Detect()
+	switch plat {
+	case platform.MacOS:
+		logMonitor := NewLogMonitor(GetSessionSuffix())
+		logMonitor.SetViolationLog(m.violations)
+		if err := logMonitor.Start(ctx); err != nil {
+			return nil, err
+		}
+		return m.tracked.addMonitor(logMonitor.Stop), nil
+	case platform.Linux:
+		monitors, err := StartLinuxMonitor(ctx, pid, LinuxSandboxOptions{
+			Monitor:    true,
+			UseEBPF:    true,
+			Debug:      m.debug,
+			Violations: m.violations,
+			readMasks:  m.monitorMasks(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if monitors == nil {
+			return func() {}, nil
+		}
+		return m.tracked.addMonitor(monitors.Stop), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSandboxUnsupported, plat)
+	}
+}
+
+// StartESMonitor starts reporting, on the Manager's logger, what the
+// sandboxed command with the given pid and its descendants do as it happens:
+// the processes they run and exit, and the files they create, modify,
+// delete, and rename. It reads Endpoint Security events from eslogger, so it
+// is only available on macOS 13 and later, as root, with Full Disk Access
+// for the terminal. It does not report sandbox denials; use StartMonitor for
+// those. Call it right after starting the command, and call stop once it
+// exits. The monitor also stops when ctx is canceled, and is stopped by
+// Shutdown if still running.
+func (m *Manager) StartESMonitor(ctx context.Context, pid int) (stop func(), err error) {
+	es, err := startESMonitor(ctx, pid, m.log, m.debug)
+	if err != nil {
+		return nil, err
+	}
+	return m.tracked.addMonitor(es.Stop), nil
+}
+
+// StartExecTrace starts recording in Execs every process the sandboxed
+// command executes, with its arguments and parent PID: with bpftrace on
+// Linux, which needs CAP_BPF or root, and with eslogger, or dtrace on older
+// releases, as root on macOS. Call it before starting the command, so its
+// first exec is seen, and call SetRoot on the tracer with the command's pid
+// once it has started. Stop the tracer once the command exits; it is also
+// stopped by Shutdown, or when ctx is canceled.
+func (m *Manager) StartExecTrace(ctx context.Context) (*ExecTracer, error) {
+	t, err := startExecTracer(ctx, m.execs, m.debug)
+	if err != nil {
+		return nil, err
+	}
+	m.tracked.addMonitor(t.Stop)
+	return t, nil
+}
+
+// macOSParams returns the Seatbelt profile parameters used by WrapCommand
+// and Spec.
+func (m *Manager) macOSParams(command string, debug bool) MacOSSandboxParams {
+	params := newMacOSSandboxParams(m.config, command, m.httpPort, m.socksPort, m.exposedPorts, debug)
+	if m.shareNetwork {
+		params.NeedsNetworkRestriction = false
+	}
+	if m.terminal {
+		params.AllowPty = true
+	}
+	params.ProxyAuth = m.proxyCreds != nil
+	params.SOCKSUDPPort = m.socksUDPPort
+	return params
+}
+
+// linuxOptions returns the Linux sandbox options used by WrapCommand and Spec.
+func (m *Manager) linuxOptions() LinuxSandboxOptions {
+	return LinuxSandboxOptions{
+		UseLandlock:  true,
+		UseSeccomp:   true,
+		UseEBPF:      true,
+		Debug:        m.debug,
+		DBusProxy:    m.dbusProxy,
+		Backend:      m.backend,
+		ShareNetwork: m.shareNetwork,
+		Terminal:     m.terminal,
+		Overlay:      m.overlay,
+		CABundle:     m.caBundle,
+		ProxyAuth:    m.proxyCreds != nil,
+		Audit:        m.violations.Audit(),
+		appArmor:     m.appArmor,
+		selinux:      m.selinux,
+		cgroup:       m.cgroup,
+		readMasks:    m.monitorMasks(),
+	}
+}
+
+// monitorMasks returns the masks of denyRead paths the fanotify monitor
+// watches, if violations are monitored.
+func (m *Manager) monitorMasks() *readMasks {
+	if !m.monitor {
+		return nil
+	}
+	return m.readMasks
+}
+
+// TrackProcess registers a process running a command from WrapCommand, so
+// Shutdown stops it before the proxies and bridges it depends on. p must be a
+// child of the current process. Processes that have already exited are skipped.
+func (m *Manager) TrackProcess(p *os.Process) {
+	m.tracked.addProcess(p)
+}
+
+// Cleanup stops the proxies and cleans up resources. It is Shutdown with a
+// bounded deadline, ignoring anything that failed to stop.
 func (m *Manager) Cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		m.logDebug("Cleanup incomplete: %v", err)
+	}
+}
+
+// Shutdown tears the sandbox down in dependency order: tracked processes
+// (see TrackProcess), their AppArmor profiles or SELinux modules, the reverse
+// bridge, the bridges and D-Bus proxy, the
+// proxies, the monitors started by StartMonitor, so violations are recorded
+// until the end, and finally the resource limits cgroup, killing anything
+// the command left running in it. Each step gets its own deadline within ctx;
+// processes that do not exit after SIGTERM are sent SIGKILL. Every step is
+// attempted even if an earlier one fails or ctx expires, in which case the
+// remaining steps are forced. The returned *ShutdownError lists the steps
+// that failed. Shutdown on a Manager from WithConfig does nothing.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	if m.derived {
+		return nil
+	}
+
+	var failures []TeardownFailure
+	step := func(name string, timeout time.Duration, fn func(context.Context) error) {
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := fn(stepCtx); err != nil {
+			failures = append(failures, TeardownFailure{Step: name, Err: err})
+		}
+	}
+
+	processes, monitors := m.tracked.take()
+	for _, p := range processes {
+		step(fmt.Sprintf("sandboxed process %d", p.Pid), processStepTimeout, func(ctx context.Context) error {
+			return terminate(ctx, p, func() error {
+				_, err := p.Wait()
+				return err
+			})
+		})
+	}
+	// Stopped processes no longer need their AppArmor profiles or SELinux modules
+	step("AppArmor profiles", stepTimeout, m.appArmor.Shutdown)
+	step("SELinux modules", moduleStepTimeout, m.selinux.Shutdown)
+	step("denyRead masks", stepTimeout, m.readMasks.Shutdown)
 	if m.reverseBridge != nil {
-		m.reverseBridge.Cleanup()
+		step("reverse bridge", stepTimeout, m.reverseBridge.Shutdown)
+		m.reverseBridge = nil
 	}
 	if m.linuxBridge != nil {
-		m.linuxBridge.Cleanup()
+		step("bridge", stepTimeout, m.linuxBridge.Shutdown)
+		m.linuxBridge = nil
+	}
+	if m.dbusProxy != nil {
+		step("D-Bus proxy", processStepTimeout, m.dbusProxy.Shutdown)
+		m.dbusProxy = nil
 	}
 	if m.httpProxy != nil {
-		_ = m.httpProxy.Stop()
+		step("HTTP proxy", stepTimeout, m.httpProxy.Stop)
+		m.httpProxy = nil
 	}
 	if m.socksProxy != nil {
-		_ = m.socksProxy.Stop()
+		step("SOCKS proxy", stepTimeout, m.socksProxy.Stop)
+		m.socksProxy = nil
 	}
+	if m.dnsServer != nil {
+		step("DNS resolver", stepTimeout, m.dnsServer.Stop)
+		m.dnsServer = nil
+	}
+	m.removeTrustBundle()
+	for _, stop := range monitors {
+		step("monitor", stepTimeout, func(ctx context.Context) error {
+			return runStep(ctx, func() error {
+				stop()
+				return nil
+			})
+		})
+	}
+	if m.cgroup != nil {
+		step("cgroup", stepTimeout, m.cgroup.Shutdown)
+		m.cgroup = nil
+	}
+	// Summarize denial lines the proxies and monitors held back
+	policy.MonitorOutput.Flush()
+
+	m.initialized = false
 	m.logDebug("Sandbox manager cleaned up")
+	if len(failures) > 0 {
+		// Keep the state file so the next fence run can finish the job
+		return &ShutdownError{Failures: failures}
+	}
+	if m.statePath != "" {
+		_ = os.Remove(m.statePath)
+		m.statePath = ""
+	}
+	return nil
+}
+
+// writeSessionState records the helper processes, sockets, and cgroups this
+// Manager holds, so a later fence run can release them if this process dies
+// without calling Shutdown.
+func (m *Manager) writeSessionState() {
+	state := &sessionState{PID: os.Getpid(), Started: time.Now()}
+	add := func(helpers []helperProcess, files []string) {
+		state.Helpers = append(state.Helpers, helpers...)
+		state.Files = append(state.Files, files...)
+	}
+	if m.linuxBridge != nil {
+		add(m.linuxBridge.sessionResources())
+	}
+	if m.reverseBridge != nil {
+		add(m.reverseBridge.sessionResources())
+	}
+	if m.dbusProxy != nil {
+		add(m.dbusProxy.sessionResources())
+	}
+	if m.caBundle != "" {
+		state.Files = append(state.Files, m.caBundle)
+	}
+	state.Cgroups = m.cgroup.sessionResources()
+	if state.empty() {
+		return
+	}
+
+	path, err := writeSessionState(sessionStateDir(), state)
+	if err != nil {
+		m.logDebug("failed to write session state: %v", err)
+		return
+	}
+	m.statePath = path
+}
+
+// cleanupLinuxBridge stops the Linux bridge, if there is one.
+func (m *Manager) cleanupLinuxBridge() {
+	if m.linuxBridge != nil {
+		m.linuxBridge.Cleanup()
+		m.linuxBridge = nil
+	}
+}
+
+// stopProxies stops whichever proxies were started, giving in-flight
+// requests a few seconds to finish.
+func (m *Manager) stopProxies() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if m.httpProxy != nil {
+		_ = m.httpProxy.Stop(ctx)
+	}
+	if m.socksProxy != nil {
+		_ = m.socksProxy.Stop(ctx)
+	}
+	if m.dnsServer != nil {
+		_ = m.dnsServer.Stop(ctx)
+	}
+}
+
+// checkCommand enforces command policy and records violations, including
+// commands a warn rule allows. In audit mode the command is allowed.
+func (m *Manager) checkCommand(command string) error {
+	d, err := m.decideCommand(command)
+	if err == nil {
+		if d.Warn {
+			m.violations.Record(policy.Event{Source: policy.SourceCommand, Kind: policy.KindCommand, Target: command, Decision: d})
+			m.log.Warnf("warn", "Allowing command %q (%s)", command, d.Basis())
+		}
+		return nil
+	}
+	m.violations.Record(policy.Event{Source: policy.SourceCommand, Kind: policy.KindCommand, Target: command, Decision: d})
+	if !m.violations.Audit() {
+		return newPolicyViolation(err)
+	}
+
+	if m.debug || m.monitor {
+		m.log.Infof("audit", "Would block command %q (%s)", command, d.Basis())
+	}
+	return nil
+}
+
+// decideCommand decides command by m's policy, or the config if it has
+// none. The error is what WrapCommand reports if the command is denied.
+func (m *Manager) decideCommand(command string) (policy.Decision, error) {
+	if m.customPolicy == nil {
+		return evaluateCommand(command, m.config)
+	}
+	d := m.customPolicy.DecideCommand(command)
+	if d.Allowed {
+		return d, nil
+	}
+	basis := d.Reason
+	if d.Rule != "" {
+		basis = d.Rule + ": " + d.Reason
+	}
+	return d, &PolicyViolationError{Rule: d.Basis(), Err: fmt.Errorf("command %q blocked by sandbox policy: %s", command, basis)}
+}
+
+// setDomainFilter makes the proxies decide hosts by cfg's domain rules.
+func (m *Manager) setDomainFilter(cfg *config.Config) {
+	var filter proxy.FilterFunc
+	if m.violations.Audit() {
+		filter = proxy.CreateAuditFilter(cfg, m.violations, m.debug || m.monitor)
+	} else {
+		filter = proxy.RecordDenials(proxy.CreateDomainFilter(cfg, m.debug), cfg, m.violations)
+	}
+	if m.allowances != nil {
+		filter = allowTemporarily(filter, cfg, m.allowances, m.debug)
+	}
+	if m.stats != nil {
+		filter = countDomains(filter, cfg, m.stats)
+	}
+	m.domainFilter.Store(&filter)
+}
+
+// SetNetworkPolicy replaces network.allowedDomains and network.deniedDomains
+// for the connections the proxies decide from now on, such as when a
+// supervisor pushes a new policy. The rest of the config, including the
+// other network rules, is unchanged, and so is whether the sandbox has a
+// network namespace. m must be initialized and not use WithFilter.
+func (m *Manager) SetNetworkPolicy(allowedDomains, deniedDomains []string) error {
+	return m.setDomainRules(allowedDomains, deniedDomains, nil)
+}
+
+// ReloadDomainRules is SetNetworkPolicy with the domain rules of cfg, such
+// as a config reloaded after its file changed, including deniedDomains
+// entries whose action is warn. The rest of cfg is ignored.
+func (m *Manager) ReloadDomainRules(cfg *config.Config) error {
+	if cfg == nil {
+		return errors.New("nil config")
+	}
+	return m.setDomainRules(cfg.Network.AllowedDomains, cfg.Network.DeniedDomains, cfg.Network.WarnDomains)
+}
+
+func (m *Manager) setDomainRules(allowedDomains, deniedDomains, warnDomains []string) error {
+	if !m.initialized {
+		return errors.New("sandbox manager is not initialized")
+	}
+	if m.filter != nil || m.customPolicy != nil {
+		return errors.New("the network policy is decided by a custom filter or policy")
+	}
+	cfg := config.Default()
+	if m.config != nil {
+		c := *m.config
+		cfg = &c
+	}
+	cfg.Network.AllowedDomains = slices.Clone(allowedDomains)
+	cfg.Network.DeniedDomains = slices.Clone(deniedDomains)
+	cfg.Network.WarnDomains = slices.Clone(warnDomains)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	m.setDomainFilter(cfg)
+	return nil
+}
+
+// allowTemporarily wraps filter so that hosts no domain rule in cfg matches
+// are allowed while w has an active allowance for them. A deniedDomains
+// entry still denies the host.
+func allowTemporarily(filter proxy.FilterFunc, cfg *config.Config, w *allowance.Watcher, debug bool) proxy.FilterFunc {
+	return func(host string, port int) bool {
+		if d := policy.EvaluateDomain(cfg, host); d.Rule == "" {
+			if a, ok := w.Match(host); ok {
+				if debug {
+					logging.Debugf("filter", "Allowed by temporary allowance: %s:%d (matched %s, expires %s)", host, port, a.Domain, a.Expires.Format(time.DateTime))
+				}
+				return true
+			}
+		}
+		return filter(host, port)
+	}
+}
+
+// recordConnections wraps filter so that every connection it decides on is
+// recorded in rec with the outcome.
+func recordConnections(filter proxy.FilterFunc, rec *history.Recorder) proxy.FilterFunc {
+	return func(host string, port int) bool {
+		allowed := filter(host, port)
+		rec.Record(policy.KindNetwork, net.JoinHostPort(host, strconv.Itoa(port)), allowed)
+		return allowed
+	}
+}
+
+// countDomains wraps filter so that every connection it decides on is
+// counted in rec with the outcome and the rule in cfg that matched the host.
+// cfg is nil for a custom filter, whose rules stats know nothing of.
+func countDomains(filter proxy.FilterFunc, cfg *config.Config, rec *stats.Recorder) proxy.FilterFunc {
+	return func(host string, port int) bool {
+		allowed := filter(host, port)
+		d := policy.Decision{Allowed: allowed}
+		if cfg != nil {
+			d.Rule = policy.EvaluateDomain(cfg, host).Rule
+		}
+		rec.Domain(host, d)
+		return allowed
+	}
+}
+
+// countCommand counts each command in a pipeline or chain in m's stats,
+// with the rule that decided it. In audit mode denied commands run, so
+// they are counted as allowed, as connections are.
+func (m *Manager) countCommand(command string) {
+	if m.stats == nil {
+		return
+	}
+	if m.customPolicy != nil {
+		d, _ := m.decideCommand(command)
+		d.Allowed = d.Allowed || m.violations.Audit()
+		m.stats.Command(command, d)
+		return
+	}
+	cfg := m.config
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	for _, sub := range parseShellCommand(command) {
+		d, _ := evaluateSingleCommand(sub, cfg)
+		d.Allowed = d.Allowed || m.violations.Audit()
+		m.stats.Command(sub, d)
+	}
+}
+
+// exportConnections wraps filter so that every connection it decides on is
+// exported to exp as a span.
+func exportConnections(filter proxy.FilterFunc, exp *telemetry.Exporter) proxy.FilterFunc {
+	return func(host string, port int) bool {
+		allowed := filter(host, port)
+		exp.Connection(host, port, allowed)
+		return allowed
+	}
 }
 
 func (m *Manager) logDebug(format string, args ...interface{}) {
 	if m.debug {
-		fmt.Fprintf(os.Stderr, "[fence] "+format+"\n", args...)
+		m.log.Debugf("", format, args...)
+	}
+}
+
+// Env returns the environment to run wrapped commands with: environ, such
+// as os.Environ(), without the dangerous variables and those env.allow and
+// env.deny exclude, plus env.set. With network.inspectTLS, the variables
+// pointing TLS clients at the session CA bundle follow, and with
+// network.proxyAuth, the proxy credentials the wrapped command passes on.
+func (m *Manager) Env(environ []string) []string {
+	env := append(FilterEnv(environ, &m.config.Env), trustEnvVars(m.caBundle)...)
+	if m.proxyCreds != nil {
+		env = append(env, proxyAuthEnvVar+"="+m.proxyCreds.Userinfo())
+	}
+	return env
+}
+
+// ProxyEnv returns the environment variables that point clients at the
+// proxies (HTTP_PROXY, ALL_PROXY, NO_PROXY, and the like), with the
+// credentials in the URLs with network.proxyAuth, and with
+// network.inspectTLS to the session CA bundle, for commands run
+// outside the sandbox, such as with a Manager created WithoutSandbox. Only
+// clients that honor them are filtered.
+func (m *Manager) ProxyEnv() []string {
+	userinfo := ""
+	if m.proxyCreds != nil {
+		userinfo = m.proxyCreds.Userinfo()
 	}
+	env := slices.DeleteFunc(proxyEnvVars(m.httpPort, m.socksPort, userinfo), func(v string) bool {
+		// These describe the sandbox, which such commands are not in
+		return strings.HasPrefix(v, "FENCE_SANDBOX=") || strings.HasPrefix(v, "TMPDIR=")
+	})
+	return append(env, trustEnvVars(m.caBundle)...)
 }
 
 // HTTPPort returns the HTTP proxy port.
@@ -0,0 +1,612 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/allowance"
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/history"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+	"github.com/Use-Tusk/fence/internal/stats"
+)
+
+func TestManagerCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := NewManager(config.Default(), false, false)
+	defer m.Cleanup()
+
+	if err := m.Initialize(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Initialize() error = %v, want context.Canceled", err)
+	}
+	if _, err := m.WrapCommand(ctx, "echo hello"); !errors.Is(err, context.Canceled) {
+		t.Errorf("WrapCommand() error = %v, want context.Canceled", err)
+	}
+	if m.HTTPPort() != 0 || m.SOCKSPort() != 0 {
+		t.Error("expected no proxies to be started with a canceled context")
+	}
+}
+
+func TestManagerAuditModeCommands(t *testing.T) {
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"git push"}
+
+	m := NewManager(cfg, false, false)
+	if err := m.checkCommand("git push origin main"); !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("enforcing: checkCommand() error = %v, want ErrPolicyViolation", err)
+	}
+	if m.Violations().Audit() {
+		t.Error("Violations() should not be in audit mode when audit mode is off")
+	}
+	if events := m.Violations().Violations(); len(events) != 1 || events[0].Kind != policy.KindCommand {
+		t.Errorf("enforcing: Violations() = %+v, want the blocked command", events)
+	}
+
+	m.SetAuditMode(true)
+	for _, cmd := range []string{"git push origin main", "ls", "git push origin main"} {
+		if err := m.checkCommand(cmd); err != nil {
+			t.Errorf("audit: checkCommand(%q) error = %v, want nil", cmd, err)
+		}
+	}
+
+	// The log is kept across SetAuditMode, so the enforcing block is counted too
+	events := m.Violations().Violations()
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1: %+v", len(events), events)
+	}
+	if events[0].Kind != policy.KindCommand || events[0].Count != 3 || events[0].Decision.Rule != `command.deny "git push"` {
+		t.Errorf("events[0] = %+v", events[0])
+	}
+}
+
+func TestRecordConnections(t *testing.T) {
+	rec := history.NewRecorder()
+	filter := recordConnections(func(host string, port int) bool { return host == "github.com" }, rec)
+	if !filter("github.com", 443) || filter("evil.com", 443) || filter("evil.com", 443) {
+		t.Fatal("recordConnections should not change the filter's decisions")
+	}
+	want := []history.Event{
+		{Kind: policy.KindNetwork, Target: "evil.com:443", Allowed: false, Count: 2},
+		{Kind: policy.KindNetwork, Target: "github.com:443", Allowed: true, Count: 1},
+	}
+	if got := rec.Events(); !slices.Equal(got, want) {
+		t.Errorf("Events() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCountDomains(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"*.github.com"}
+	rec := stats.NewRecorder()
+	filter := countDomains(func(host string, port int) bool { return host == "api.github.com" }, cfg, rec)
+	if !filter("api.github.com", 443) || filter("evil.com", 443) {
+		t.Fatal("countDomains should not change the filter's decisions")
+	}
+	s := rec.Stats()
+	if c := s.Rules[`network.allowedDomains "*.github.com"`]; c.Allowed != 1 {
+		t.Errorf("rule counter = %+v, want 1 allowed", c)
+	}
+	if c := s.Domains["evil.com"]; c.Denied != 1 {
+		t.Errorf("evil.com counter = %+v, want 1 denied", c)
+	}
+}
+
+func TestAllowTemporarily(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	cfg.Network.DeniedDomains = []string{"evil.example.com"}
+	store := &allowance.Store{Dir: t.TempDir()}
+	for _, domain := range []string{"*.example.com", "api.foo.com"} {
+		if _, err := store.Add(domain, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := allowTemporarily(proxy.CreateDomainFilter(cfg, false), cfg, allowance.NewWatcher(store), false)
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"github.com", true},
+		{"api.foo.com", true},
+		{"docs.example.com", true},
+		{"evil.example.com", false},
+		{"other.com", false},
+	}
+	for _, tt := range tests {
+		if got := filter(tt.host, 443); got != tt.want {
+			t.Errorf("filter(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if _, err := store.Remove("api.foo.com"); err != nil {
+		t.Fatal(err)
+	}
+	if filter("api.foo.com", 443) {
+		t.Error("a revoked allowance should stop applying to a running filter")
+	}
+}
+
+func TestNewProtectsAllowances(t *testing.T) {
+	store := &allowance.Store{Dir: t.TempDir()}
+	cfg := config.Default()
+	m, err := New(cfg, WithAllowances(allowance.NewWatcher(store)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(m.config.Filesystem.DenyWrite, store.Dir) {
+		t.Errorf("denyWrite = %v, want the allowances directory", m.config.Filesystem.DenyWrite)
+	}
+	if len(cfg.Filesystem.DenyWrite) != 0 {
+		t.Errorf("New should not modify the caller's config, got denyWrite %v", cfg.Filesystem.DenyWrite)
+	}
+}
+
+func TestManagerCountCommand(t *testing.T) {
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"git push"}
+	rec := stats.NewRecorder()
+	m, err := New(cfg, WithStats(rec))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	m.countCommand("git status && git push origin main")
+	s := rec.Stats()
+	if s.Commands["git status"].Allowed != 1 || s.Commands["git push"].Denied != 1 {
+		t.Errorf("Commands = %+v, want git status allowed and git push denied", s.Commands)
+	}
+	if s.Rules[`command.deny "git push"`].Denied != 1 {
+		t.Errorf("Rules = %+v, want the deny rule counted", s.Rules)
+	}
+}
+
+func TestManagerSubscribe(t *testing.T) {
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"npm publish"}
+
+	m := NewManager(cfg, false, false)
+	var events []policy.Event
+	m.Subscribe(func(e policy.Event) { events = append(events, e) })
+	m.SetAuditMode(true)
+
+	if err := m.checkCommand("npm publish"); err != nil {
+		t.Fatalf("audit: checkCommand() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if e := events[0]; e.Source != policy.SourceCommand || e.Target != "npm publish" || !e.Audit {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestManagerWithConfig(t *testing.T) {
+	m := NewManager(config.Default(), false, false)
+	if _, err := m.WithConfig(config.Default()); err == nil {
+		t.Fatal("WithConfig() on an uninitialized manager should fail")
+	}
+
+	// Stand in for Initialize, which needs the platform's sandbox tools
+	m.initialized = true
+	m.httpPort, m.socksPort = 8080, 1080
+
+	cfg := config.Default()
+	cfg.Command.Deny = []string{"make deploy"}
+	derived, err := m.WithConfig(cfg)
+	if err != nil {
+		t.Fatalf("WithConfig() error = %v", err)
+	}
+	if derived.HTTPPort() != 8080 || derived.SOCKSPort() != 1080 {
+		t.Error("derived manager should share the proxies")
+	}
+	if derived.Violations() != m.Violations() {
+		t.Error("derived manager should share the violation log")
+	}
+
+	if err := derived.checkCommand("make deploy"); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("derived: checkCommand() error = %v, want ErrPolicyViolation", err)
+	}
+	if err := m.checkCommand("make deploy"); err != nil {
+		t.Errorf("parent: checkCommand() error = %v, want nil", err)
+	}
+	if n := len(m.Violations().Violations()); n != 1 {
+		t.Errorf("parent sees %d violations, want 1", n)
+	}
+
+	derived.Cleanup()
+	if !m.initialized || m.derived {
+		t.Error("derived Cleanup() should not affect the parent")
+	}
+}
+
+func TestManagerSetBackend(t *testing.T) {
+	m := NewManager(config.Default(), false, false)
+	for _, name := range []string{"", BackendBwrap, BackendGVisor, BackendAppArmor, BackendSELinux, BackendNative} {
+		if err := m.SetBackend(name); err != nil {
+			t.Errorf("SetBackend(%q) error = %v", name, err)
+		}
+	}
+	if err := m.SetBackend("docker"); err == nil {
+		t.Error("SetBackend(\"docker\") should fail")
+	}
+	if m.linuxOptions().Backend != BackendNative {
+		t.Error("an invalid backend should not replace the selected one")
+	}
+}
+
+// fakeProxy is a Proxy that records being started and stopped.
+type fakeProxy struct {
+	port             int
+	started, stopped bool
+}
+
+func (p *fakeProxy) Start(context.Context) (int, error) {
+	p.started = true
+	return p.port, nil
+}
+
+func (p *fakeProxy) Stop(context.Context) error {
+	p.stopped = true
+	return nil
+}
+
+func (p *fakeProxy) Port() int { return p.port }
+
+func TestManagerCustomProxies(t *testing.T) {
+	httpProxy, socksProxy := &fakeProxy{port: 18080}, &fakeProxy{port: 11080}
+
+	m := NewManager(config.Default(), false, false)
+	m.SetHTTPProxy(httpProxy)
+	m.SetSOCKSProxy(socksProxy)
+
+	// The bridges may fail to start (e.g. without socat), but the proxies
+	// come first and are stopped either way
+	err := m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if !httpProxy.started || !socksProxy.started {
+		t.Fatalf("started = %v, %v; want both custom proxies started", httpProxy.started, socksProxy.started)
+	}
+	if err == nil {
+		if m.HTTPPort() != 18080 || m.SOCKSPort() != 11080 {
+			t.Errorf("ports = %d, %d; want the custom proxies' ports", m.HTTPPort(), m.SOCKSPort())
+		}
+		m.Cleanup()
+	}
+	if !httpProxy.stopped || !socksProxy.stopped {
+		t.Errorf("stopped = %v, %v; want both custom proxies stopped", httpProxy.stopped, socksProxy.stopped)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	var log strings.Builder
+	m, err := New(config.Default(), WithDebug(true), WithBackend(BackendNative), WithLogger(&log))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !m.debug || m.linuxOptions().Backend != BackendNative {
+		t.Errorf("options not applied: debug = %v, backend = %q", m.debug, m.linuxOptions().Backend)
+	}
+	m.logDebug("hello")
+	if log.String() != "[fence] hello\n" {
+		t.Errorf("logged %q, want it written to the WithLogger writer", log.String())
+	}
+
+	if _, err := New(config.Default(), WithBackend("docker")); err == nil {
+		t.Error("New() with an unknown backend should fail")
+	}
+}
+
+func TestManagerWithoutSandbox(t *testing.T) {
+	m, err := New(config.Default(), WithoutSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Cleanup()
+
+	if _, err := m.WrapCommand(context.Background(), "echo hello"); err == nil {
+		t.Error("WrapCommand() should fail without a sandbox")
+	}
+	if _, err := m.Spec(context.Background(), "echo hello"); err == nil {
+		t.Error("Spec() should fail without a sandbox")
+	}
+
+	// Only the proxies are started, so no bridge dependencies are needed
+	err = m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if m.HTTPPort() == 0 || m.SOCKSPort() == 0 {
+		t.Errorf("ports = %d, %d; want both proxies started", m.HTTPPort(), m.SOCKSPort())
+	}
+	if m.linuxBridge != nil || m.dbusProxy != nil {
+		t.Error("a network-only Manager should not start bridges")
+	}
+
+	env := m.ProxyEnv()
+	if !slices.Contains(env, fmt.Sprintf("HTTP_PROXY=http://localhost:%d", m.HTTPPort())) {
+		t.Errorf("ProxyEnv() = %q, want HTTP_PROXY pointing at the proxy", env)
+	}
+	for _, v := range env {
+		if strings.HasPrefix(v, "FENCE_SANDBOX=") || strings.HasPrefix(v, "TMPDIR=") {
+			t.Errorf("ProxyEnv() contains %q, which only applies inside the sandbox", v)
+		}
+	}
+}
+
+func TestManagerProxyAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.ProxyAuth = true
+	m, err := New(cfg, WithoutSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Cleanup()
+	err = m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	userinfo := m.proxyCreds.Userinfo()
+	if !slices.Contains(m.Env(nil), proxyAuthEnvVar+"="+userinfo) {
+		t.Errorf("Env() = %q, want the proxy credentials in %s", m.Env(nil), proxyAuthEnvVar)
+	}
+	want := fmt.Sprintf("HTTP_PROXY=http://%s@localhost:%d", userinfo, m.HTTPPort())
+	if env := m.ProxyEnv(); !slices.Contains(env, want) {
+		t.Errorf("ProxyEnv() = %q, want %q", env, want)
+	}
+
+	// The credentials reach wrapped commands only through the environment
+	params := m.macOSParams("true", false)
+	if !params.ProxyAuth {
+		t.Error("macOSParams().ProxyAuth = false, want true")
+	}
+	if !m.linuxOptions().ProxyAuth {
+		t.Error("linuxOptions().ProxyAuth = false, want true")
+	}
+	if spec := macOSSpec(params); slices.ContainsFunc(spec.Env, func(v string) bool { return strings.Contains(v, userinfo) }) {
+		t.Errorf("macOS spec env %q contains the credentials", spec.Env)
+	}
+}
+
+func TestManagerUnixSocketProxies(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.UnixSocketProxies = true
+
+	// The proxies need the sandbox's bridge sockets
+	m, err := New(cfg, WithoutSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := m.Initialize(context.Background()); err == nil {
+		m.Cleanup()
+		t.Error("Initialize() without a sandbox should fail with network.unixSocketProxies")
+	}
+
+	if platform.Detect() != platform.Linux {
+		t.Skip("network.unixSocketProxies is Linux only")
+	}
+	m = NewManager(cfg, false, false)
+	defer m.Cleanup()
+	err = m.Initialize(context.Background())
+	var missing *MissingDependencyError
+	if errors.As(err, &missing) {
+		t.Skipf("bridge dependency missing: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if m.HTTPPort() != 0 || m.SOCKSPort() != 0 {
+		t.Errorf("ports = %d, %d; want no TCP listeners", m.HTTPPort(), m.SOCKSPort())
+	}
+
+	socket := m.linuxBridge.HTTPSocketPath
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("HTTP proxy socket %s: %v, %v; want mode 0600", socket, info, err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "fence-proxy"}),
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://blocked.example.com/")
+	if err != nil {
+		t.Fatalf("GET through the socket error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want the proxy's %d for a domain not allowed", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestManagerFilterDNS(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.FilterDNS = true
+	cfg.Network.DNS.Search = []string{"corp.example"}
+
+	// The resolver listens in the sandbox's network namespace
+	m, err := New(cfg, WithoutSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := m.Initialize(context.Background()); err == nil {
+		m.Cleanup()
+		t.Error("Initialize() without a sandbox should fail with network.filterDNS")
+	}
+
+	if platform.Detect() != platform.Linux {
+		t.Skip("network.filterDNS is Linux only")
+	}
+	m = NewManager(cfg, false, false)
+	defer m.Cleanup()
+	err = m.Initialize(context.Background())
+	var missing *MissingDependencyError
+	if errors.As(err, &missing) {
+		t.Skipf("bridge dependency missing: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(m.linuxBridge.ResolvConfPath); err != nil || string(data) != "nameserver 127.0.0.1\nsearch corp.example\n" {
+		t.Errorf("resolv.conf = %q, %v; want the sandbox's nameserver and search domains", data, err)
+	}
+	conn, err := net.Dial("unix", m.linuxBridge.DNSSocketPath)
+	if err != nil {
+		t.Fatalf("dial the DNS socket: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A framed A query for blocked.example.com, which the default config denies
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range []string{"blocked", "example", "com"} {
+		query = append(append(query, byte(len(label))), label...)
+	}
+	query = append(query, 0, 0, 1, 0, 1)
+	if _, err := conn.Write(append([]byte{0, byte(len(query))}, query...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp := make([]byte, 512)
+	n, err := io.ReadAtLeast(conn, resp, 2+12)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	if rcode := resp[2+3] & 0x0f; rcode != 3 {
+		t.Errorf("rcode = %d (%x), want NXDOMAIN", rcode, resp[:n])
+	}
+}
+
+func TestManagerSetNetworkPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	m, err := New(cfg, WithoutSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Cleanup()
+
+	if err := m.SetNetworkPolicy([]string{"example.com"}, nil); err == nil {
+		t.Error("SetNetworkPolicy() should fail before Initialize")
+	}
+	err = m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	filter := func(host string) bool { return (*m.domainFilter.Load())(host, 443) }
+	if !filter("github.com") || filter("example.com") {
+		t.Fatal("the filter should follow the config before a policy is set")
+	}
+	if err := m.SetNetworkPolicy([]string{"*.example.com", "example.com"}, []string{"bad.example.com"}); err != nil {
+		t.Fatalf("SetNetworkPolicy() error = %v", err)
+	}
+	for host, want := range map[string]bool{"github.com": false, "example.com": true, "api.example.com": true, "bad.example.com": false} {
+		if got := filter(host); got != want {
+			t.Errorf("after SetNetworkPolicy, filter(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if events := m.Violations().Violations(); !slices.ContainsFunc(events, func(v policy.Violation) bool {
+		return v.Decision.Rule == `network.deniedDomains "bad.example.com"`
+	}) {
+		t.Errorf("Violations() = %+v, want the pushed denial recorded with its rule", events)
+	}
+	if cfg.Network.AllowedDomains[0] != "github.com" {
+		t.Error("SetNetworkPolicy() should not modify the Manager's config")
+	}
+	if err := m.SetNetworkPolicy([]string{""}, nil); err == nil {
+		t.Error("SetNetworkPolicy() should reject an invalid domain")
+	}
+
+	reloaded := config.Default()
+	reloaded.Network.AllowedDomains = []string{"github.com", "gist.github.com"}
+	reloaded.Network.WarnDomains = []string{"gist.github.com"}
+	if err := m.ReloadDomainRules(reloaded); err != nil {
+		t.Fatalf("ReloadDomainRules() error = %v", err)
+	}
+	if !filter("github.com") || !filter("gist.github.com") || filter("example.com") {
+		t.Error("the filter should follow the reloaded config")
+	}
+	if events := m.Violations().Violations(); !slices.ContainsFunc(events, func(v policy.Violation) bool {
+		return v.Decision.Warn && v.Target == "gist.github.com:443"
+	}) {
+		t.Errorf("Violations() = %+v, want the reloaded warn rule recorded", events)
+	}
+}
+
+func TestManagerWithoutNetworkSandbox(t *testing.T) {
+	m, err := New(config.Default(), WithoutNetworkSandbox())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Cleanup()
+
+	err = m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if m.httpProxy != nil || m.socksProxy != nil || m.linuxBridge != nil {
+		t.Error("no proxies or bridges should run without a network sandbox")
+	}
+	if len(m.ProxyEnv()) != 0 {
+		t.Errorf("ProxyEnv() = %q, want none", m.ProxyEnv())
+	}
+	if !m.linuxOptions().ShareNetwork || m.macOSParams("true", false).NeedsNetworkRestriction {
+		t.Error("the command should share the host network")
+	}
+
+	if _, err := New(config.Default(), WithoutSandbox(), WithoutNetworkSandbox()); err == nil {
+		t.Error("New() with neither a sandbox nor a network sandbox should fail")
+	}
+}
+
+func TestManagerWithoutProxies(t *testing.T) {
+	m, err := New(config.Default(), WithoutHTTPProxy(), WithoutSOCKS())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Cleanup()
+
+	err = m.Initialize(context.Background())
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip("sandbox not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if m.HTTPPort() != 0 || m.SOCKSPort() != 0 || m.httpProxy != nil || m.socksProxy != nil {
+		t.Errorf("ports = %d, %d; want no proxies", m.HTTPPort(), m.SOCKSPort())
+	}
+	if m.linuxBridge != nil {
+		t.Error("no bridge is needed without proxies")
+	}
+}
@@ -0,0 +1,91 @@
+//go:build ignore
+
+// mksysnum generates zsysnum_linux.go, the syscall numbers of each
+// architecture the seccomp filter supports, from the tables of the
+// golang.org/x/sys/unix version in go.mod. Run it with go generate after
+// updating golang.org/x/sys to pick up new syscalls.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// goarches are the architectures whose tables are generated. Keep them in
+// sync with seccompArches.
+var goarches = []string{"386", "amd64", "arm", "arm64", "loong64", "ppc64le", "riscv64", "s390x"}
+
+var sysnumRE = regexp.MustCompile(`^\s*SYS_([A-Z0-9_]+)\s*=\s*(\d+)\s*$`)
+
+func main() {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sys").Output()
+	if err != nil {
+		log.Fatalf("locating golang.org/x/sys: %v", err)
+	}
+	unixDir := filepath.Join(strings.TrimSpace(string(out)), "unix")
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by mksysnum.go from golang.org/x/sys/unix; DO NOT EDIT.\n\n")
+	buf.WriteString("//go:build linux\n\npackage sandbox\n\n")
+	buf.WriteString("// syscallNumbers maps each GOARCH the seccomp filter supports to its\n// syscall numbers by name.\n")
+	buf.WriteString("var syscallNumbers = map[string]map[string]uint32{\n")
+	for _, goarch := range goarches {
+		nums, err := readTable(filepath.Join(unixDir, "zsysnum_linux_"+goarch+".go"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := make([]string, 0, len(nums))
+		for name := range nums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&buf, "\t%q: {\n", goarch)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "\t\t%q: %s,\n", name, nums[name])
+		}
+		buf.WriteString("\t},\n")
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("formatting: %v", err)
+	}
+	if err := os.WriteFile("zsysnum_linux.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readTable returns the syscall numbers defined in a zsysnum file, by the
+// kernel's lowercase names.
+func readTable(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := sysnumRE.FindStringSubmatch(scanner.Text()); m != nil {
+			nums[strings.ToLower(m[1])] = m[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, fmt.Errorf("%s: no syscall numbers found", path)
+	}
+	return nums, nil
+}
@@ -4,13 +4,14 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/Use-Tusk/fence/internal/output"
 	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
 )
 
 // LogMonitor monitors sandbox violations via macOS log stream.
@@ -19,6 +20,7 @@ type LogMonitor struct {
 	cmd           *exec.Cmd
 	cancel        context.CancelFunc
 	running       bool
+	violations    *policy.ViolationLog
 }
 
 // NewLogMonitor creates a new log monitor for the given session suffix.
@@ -32,13 +34,21 @@ func NewLogMonitor(sessionSuffix string) *LogMonitor {
 	}
 }
 
+// SetViolationLog makes the monitor record each violation it reports in log.
+func (m *LogMonitor) SetViolationLog(log *policy.ViolationLog) {
+	if m != nil {
+		m.violations = log
+	}
+}
+
 // Start begins monitoring the macOS unified log for sandbox violations.
-func (m *LogMonitor) Start() error {
+// Monitoring stops when ctx is canceled or Stop is called.
+func (m *LogMonitor) Start(ctx context.Context) error {
 	if m == nil {
 		return nil
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	m.cancel = cancel
 
 	// Build predicate to filter for this session's violations only
@@ -65,14 +75,31 @@ func (m *LogMonitor) Start() error {
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
 			line := scanner.Text()
-			if violation := parseViolation(line); violation != "" {
-				fmt.Fprintf(os.Stderr, "%s\n", violation)
+			v, ok := parseLogViolation(line)
+			if !ok {
+				continue
+			}
+			policy.MonitorOutput.Print(v.operation, v.key(), v.format(time.Now()))
+			if m.violations != nil {
+				m.violations.Record(policy.Event{
+					Source:   policy.SourceLogStream,
+					Kind:     v.kind(),
+					Target:   v.target(),
+					Decision: policy.Deny("", "sandbox denied "+v.operation),
+					Process:  v.process,
+				})
 			}
 		}
 	}()
 
 	// Give log stream a moment to initialize
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-ctx.Done():
+		_ = m.cmd.Wait()
+		m.running = false
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+	}
 
 	return nil
 }
@@ -94,6 +121,7 @@ func (m *LogMonitor) Stop() {
 		_ = m.cmd.Process.Kill()
 		_ = m.cmd.Wait()
 	}
+	policy.MonitorOutput.Flush()
 
 	m.running = false
 }
@@ -101,46 +129,81 @@ func (m *LogMonitor) Stop() {
 // violationPattern matches sandbox denial log entries
 var violationPattern = regexp.MustCompile(`Sandbox: (\w+)\((\d+)\) deny\(\d+\) (\S+)(.*)`)
 
-// parseViolation extracts and formats a sandbox violation from a log line.
-// Returns empty string if the line should be filtered out.
-func parseViolation(line string) string {
+// logViolation is a sandbox denial parsed from the macOS unified log.
+type logViolation struct {
+	process   string
+	pid       string
+	operation string // e.g. "file-read-data" or "network-outbound"
+	details   string // e.g. the path or address
+}
+
+// parseLogViolation extracts a sandbox violation from a log line.
+// Returns false if the line should be filtered out.
+func parseLogViolation(line string) (logViolation, bool) {
 	if strings.HasPrefix(line, "Filtering") || strings.HasPrefix(line, "Timestamp") {
-		return ""
+		return logViolation{}, false
 	}
 
 	if strings.Contains(line, "duplicate report") {
-		return ""
+		return logViolation{}, false
 	}
 
 	if strings.HasPrefix(line, "CMD64_") {
-		return ""
+		return logViolation{}, false
 	}
 
 	// Match violation pattern
 	matches := violationPattern.FindStringSubmatch(line)
 	if matches == nil {
-		return ""
+		return logViolation{}, false
+	}
+
+	v := logViolation{
+		process:   matches[1],
+		pid:       matches[2],
+		operation: matches[3],
+		details:   strings.TrimSpace(matches[4]),
 	}
 
-	process := matches[1]
-	pid := matches[2]
-	operation := matches[3]
-	details := strings.TrimSpace(matches[4])
+	if !shouldShowViolation(v.operation) {
+		return logViolation{}, false
+	}
 
-	if !shouldShowViolation(operation) {
-		return ""
+	if isNoisyViolation(v.details) {
+		return logViolation{}, false
 	}
 
-	if isNoisyViolation(details) {
-		return ""
+	return v, true
+}
+
+// format renders the violation as a monitor log line.
+func (v logViolation) format(t time.Time) string {
+	timestamp := t.Format("15:04:05")
+	if v.details != "" {
+		return fmt.Sprintf("[fence:logstream] %s %s %s %s (%s:%s)", timestamp, output.Render(output.Blocked), v.operation, v.details, v.process, v.pid)
 	}
+	return fmt.Sprintf("[fence:logstream] %s %s %s (%s:%s)", timestamp, output.Render(output.Blocked), v.operation, v.process, v.pid)
+}
 
-	timestamp := time.Now().Format("15:04:05")
+// key identifies repeats of the same denial for policy.MonitorOutput.
+func (v logViolation) key() string {
+	return "logstream " + v.operation + " " + v.details + " " + v.process + ":" + v.pid
+}
+
+// kind returns the policy.Kind* the violation belongs to.
+func (v logViolation) kind() string {
+	if strings.HasPrefix(v.operation, "network-") {
+		return policy.KindNetwork
+	}
+	return policy.KindFilesystem
+}
 
-	if details != "" {
-		return fmt.Sprintf("[fence:logstream] %s ✗ %s %s (%s:%s)", timestamp, operation, details, process, pid)
+// target returns what was accessed, falling back to the operation name.
+func (v logViolation) target() string {
+	if v.details != "" {
+		return v.details
 	}
-	return fmt.Sprintf("[fence:logstream] %s ✗ %s (%s:%s)", timestamp, operation, process, pid)
+	return v.operation
 }
 
 // shouldShowViolation returns true if this violation type should be displayed.
@@ -0,0 +1,227 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
+)
+
+// esMonitorEvents are the Endpoint Security events the ES monitor reads
+// from eslogger.
+var esMonitorEvents = []string{"fork", "exec", "exit", "create", "close", "unlink", "rename"}
+
+// ESMonitor reports what the sandboxed command's process tree does as it
+// happens: the processes it runs and how they exit, and the files it
+// creates, modifies, deletes, and renames. It reads Endpoint Security events
+// from eslogger, the Endpoint Security client macOS 13 and later ship with
+// the entitlement, so fence itself need not be signed with it; eslogger
+// needs root, and Full Disk Access for the terminal. The events come from
+// the kernel as they happen and only for the tree, unlike the log stream's
+// denials, which arrive late and need filtering. Endpoint Security does not
+// report sandbox denials, so the log stream still reports those.
+type ESMonitor struct {
+	root  int
+	log   *logging.Logger
+	debug bool
+	cmd   *exec.Cmd
+	stop  sync.Once
+	done  chan struct{} // Closed when the events are read
+
+	mu      sync.Mutex
+	parents map[int]int // Parent of each process in the tree
+}
+
+func newESMonitor(pid int, log *logging.Logger, debug bool) *ESMonitor {
+	return &ESMonitor{root: pid, log: log, debug: debug, parents: map[int]int{pid: 0}}
+}
+
+// startESMonitor starts an ES monitor for the tree of pid.
+func startESMonitor(ctx context.Context, pid int, log *logging.Logger, debug bool) (*ESMonitor, error) {
+	if plat := platform.Detect(); plat != platform.MacOS {
+		return nil, fmt.Errorf("%w: %s", ErrSandboxUnsupported, plat)
+	}
+	if os.Geteuid() != 0 {
+		return nil, errors.New("the Endpoint Security monitor needs root")
+	}
+	path, err := exec.LookPath("eslogger")
+	if err != nil {
+		return nil, errors.New("the Endpoint Security monitor needs eslogger (macOS 13 or later)")
+	}
+
+	m := newESMonitor(pid, log, debug)
+	m.cmd = exec.CommandContext(ctx, path, esMonitorEvents...) //nolint:gosec // path is from LookPath
+	stdout, err := m.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr strings.Builder
+	m.cmd.Stderr = &stderr
+	if err := m.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start eslogger: %w", err)
+	}
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		lines := bufio.NewScanner(stdout)
+		lines.Buffer(make([]byte, 64*1024), 1024*1024)
+		for lines.Scan() {
+			m.event(lines.Bytes())
+		}
+	}()
+
+	// eslogger has no ready signal; it exits at once if it cannot connect,
+	// e.g. without Full Disk Access
+	select {
+	case <-m.done:
+		_ = m.cmd.Wait()
+		return nil, fmt.Errorf("eslogger exited: %s", strings.TrimSpace(stderr.String()))
+	case <-time.After(500 * time.Millisecond):
+	}
+	if debug {
+		logging.Debugf("es", "Endpoint Security monitor started for PID %d", pid)
+	}
+	return m, nil
+}
+
+// Stop stops the monitor, after giving eslogger a moment to report the
+// last events. It is safe to call more than once.
+func (m *ESMonitor) Stop() {
+	m.stop.Do(func() {
+		if m.cmd == nil || m.cmd.Process == nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		_ = m.cmd.Process.Kill()
+		<-m.done
+		_ = m.cmd.Wait()
+	})
+}
+
+// esFile is a file in an Endpoint Security event.
+type esFile struct {
+	Path string `json:"path"`
+}
+
+// esDestination is where a file is created or renamed to: an existing file
+// it replaces, or a new name in a directory.
+type esDestination struct {
+	ExistingFile *esFile `json:"existing_file"`
+	NewPath      *struct {
+		Dir      esFile `json:"dir"`
+		Filename string `json:"filename"`
+	} `json:"new_path"`
+}
+
+func (d esDestination) path() string {
+	switch {
+	case d.ExistingFile != nil:
+		return d.ExistingFile.Path
+	case d.NewPath != nil:
+		return filepath.Join(d.NewPath.Dir.Path, d.NewPath.Filename)
+	}
+	return ""
+}
+
+// event handles one line of eslogger output, reporting it if it is by a
+// process in the tree.
+func (m *ESMonitor) event(line []byte) {
+	var msg struct {
+		Process esProcess `json:"process"`
+		Event   struct {
+			Fork *struct {
+				Child esProcess `json:"child"`
+			} `json:"fork"`
+			Exec *struct {
+				Target esProcess `json:"target"`
+				Args   []string  `json:"args"`
+			} `json:"exec"`
+			Exit *struct {
+				Stat int `json:"stat"`
+			} `json:"exit"`
+			Create *struct {
+				Destination esDestination `json:"destination"`
+			} `json:"create"`
+			Close *struct {
+				Modified bool   `json:"modified"`
+				Target   esFile `json:"target"`
+			} `json:"close"`
+			Unlink *struct {
+				Target esFile `json:"target"`
+			} `json:"unlink"`
+			Rename *struct {
+				Source      esFile        `json:"source"`
+				Destination esDestination `json:"destination"`
+			} `json:"rename"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	pid := msg.Process.AuditToken.PID
+	if !m.inTree(pid, msg.Process.PPID) {
+		return
+	}
+
+	e := msg.Event
+	var op, what string
+	switch {
+	case e.Fork != nil:
+		m.mu.Lock()
+		m.parents[e.Fork.Child.AuditToken.PID] = pid
+		m.mu.Unlock()
+		return
+	case e.Exec != nil:
+		op, what = "exec", ShellQuote(e.Exec.Args)
+		if len(e.Exec.Args) == 0 {
+			what = e.Exec.Target.Executable.Path
+		}
+	case e.Exit != nil:
+		m.mu.Lock()
+		if pid != m.root {
+			delete(m.parents, pid)
+		}
+		m.mu.Unlock()
+		op, what = "exit", fmt.Sprintf("status %d", e.Exit.Stat>>8)
+	case e.Create != nil:
+		op, what = "create", e.Create.Destination.path()
+	case e.Close != nil && e.Close.Modified:
+		op, what = "modify", e.Close.Target.Path
+	case e.Unlink != nil:
+		op, what = "delete", e.Unlink.Target.Path
+	case e.Rename != nil:
+		op, what = "rename", e.Rename.Source.Path+" -> "+e.Rename.Destination.path()
+	default:
+		return
+	}
+	if isNoisyViolation(what) {
+		return
+	}
+	m.log.Infof("es", "%s %s %s (%s:%d)", time.Now().Format("15:04:05"), op, what,
+		filepath.Base(msg.Process.Executable.Path), pid)
+}
+
+// inTree reports whether pid, whose parent is ppid, is the root or one of
+// its descendants, adding it to the tree if its fork was missed.
+func (m *ESMonitor) inTree(pid, ppid int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.parents[pid]; ok {
+		return true
+	}
+	if _, ok := m.parents[ppid]; ok && ppid != 0 {
+		m.parents[pid] = ppid
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package sandbox
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+func TestESMonitorEvent(t *testing.T) {
+	var out strings.Builder
+	m := newESMonitor(100, logging.New(&out), false)
+	for _, line := range []string{
+		`{"process":{"audit_token":{"pid":100},"ppid":1,"executable":{"path":"/bin/sh"}},"event":{"fork":{"child":{"audit_token":{"pid":101}}}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/bin/sh"}},"event":{"exec":{"target":{"audit_token":{"pid":101},"executable":{"path":"/usr/bin/git"}},"args":["git","commit","-m","a b"]}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/usr/bin/git"}},"event":{"create":{"destination_type":1,"destination":{"new_path":{"dir":{"path":"/src/.git"},"filename":"index.lock","mode":420}}}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/usr/bin/git"}},"event":{"close":{"modified":false,"target":{"path":"/src/README.md"}}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/usr/bin/git"}},"event":{"close":{"modified":true,"target":{"path":"/dev/ttys001"}}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/usr/bin/git"}},"event":{"rename":{"source":{"path":"/src/.git/index.lock"},"destination_type":0,"destination":{"existing_file":{"path":"/src/.git/index"}}}}}`,
+		// A descendant whose fork was missed
+		`{"process":{"audit_token":{"pid":102},"ppid":101,"executable":{"path":"/bin/rm"}},"event":{"unlink":{"target":{"path":"/src/tmp.txt"}}}}`,
+		`{"process":{"audit_token":{"pid":101},"ppid":100,"executable":{"path":"/usr/bin/git"}},"event":{"exit":{"stat":256}}}`,
+		// Outside the tree
+		`{"process":{"audit_token":{"pid":200},"ppid":1,"executable":{"path":"/usr/bin/mdworker"}},"event":{"unlink":{"target":{"path":"/tmp/x"}}}}`,
+		`not json`,
+	} {
+		m.event([]byte(line))
+	}
+
+	timestamps := regexp.MustCompile(`\d\d:\d\d:\d\d `)
+	got := timestamps.ReplaceAllString(out.String(), "")
+	want := `[fence:es] exec git commit -m 'a b' (sh:101)
+[fence:es] create /src/.git/index.lock (git:101)
+[fence:es] rename /src/.git/index.lock -> /src/.git/index (git:101)
+[fence:es] delete /src/tmp.txt (rm:102)
+[fence:es] exit status 1 (git:101)
+`
+	if got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+	if m.inTree(101, 1) {
+		t.Error("an exited process is still in the tree")
+	}
+}
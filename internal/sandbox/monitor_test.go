@@ -0,0 +1,59 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestParseLogViolation(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantOK     bool
+		wantKind   string
+		wantTarget string
+	}{
+		{
+			name:       "file read",
+			line:       "2024-01-01 12:00:00.000 E kernel[0:1] Sandbox: cat(123) deny(1) file-read-data /Users/me/.ssh/id_rsa",
+			wantOK:     true,
+			wantKind:   policy.KindFilesystem,
+			wantTarget: "/Users/me/.ssh/id_rsa",
+		},
+		{
+			name:       "network",
+			line:       "Sandbox: curl(456) deny(1) network-outbound 1.2.3.4:443",
+			wantOK:     true,
+			wantKind:   policy.KindNetwork,
+			wantTarget: "1.2.3.4:443",
+		},
+		{
+			name: "tty noise",
+			line: "Sandbox: sh(1) deny(1) file-write-data /dev/ttys001",
+		},
+		{
+			name: "mach lookup",
+			line: "Sandbox: node(1) deny(1) mach-lookup com.apple.foo",
+		},
+		{
+			name: "header",
+			line: "Filtering the log data using ...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := parseLogViolation(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("parseLogViolation() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if v.kind() != tt.wantKind || v.target() != tt.wantTarget {
+				t.Errorf("kind, target = %q, %q; want %q, %q", v.kind(), v.target(), tt.wantKind, tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Use-Tusk/fence/internal/allowance"
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/history"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+	"github.com/Use-Tusk/fence/internal/stats"
+	"github.com/Use-Tusk/fence/internal/telemetry"
+)
+
+// Option configures a Manager created with New.
+type Option func(*Manager) error
+
+// New creates a sandbox manager for cfg. By default it runs both proxies and
+// wraps commands in the platform sandbox, like NewManager; the options leave
+// out the parts an embedder does not need.
+func New(cfg *config.Config, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		config:     cfg,
+		log:        logging.Default(),
+		violations: policy.NewViolationLog(false),
+		downloads:  policy.NewDownloadLog(),
+		execs:      policy.NewExecLog(),
+		requests:   &atomic.Int64{},
+		conns:      proxy.NewConnTracker(),
+		tracked:    &tracked{},
+		appArmor:   newAppArmorProfiles(),
+		selinux:    newSELinuxModules(),
+		readMasks:  newReadMasks(),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.noSandbox && m.shareNetwork {
+		return nil, errors.New("WithoutSandbox and WithoutNetworkSandbox leave nothing to enforce")
+	}
+	if m.allowances != nil && cfg != nil {
+		// The directory must exist to be mounted read-only
+		dir := m.allowances.Store().Dir
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create allowances directory: %w", err)
+		}
+		c := *cfg
+		c.Filesystem.DenyWrite = append(slices.Clone(cfg.Filesystem.DenyWrite), dir)
+		m.config = &c
+	}
+	if m.customPolicy == nil && cfg != nil && cfg.Policy.Rego != "" {
+		p, err := LoadRegoPolicy(cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.customPolicy = p
+	}
+	if m.customPolicy != nil {
+		if m.filter != nil {
+			return nil, errors.New("WithFilter and WithPolicy both decide connections; use one")
+		}
+		m.config = policyConfig(m.config, m.customPolicy)
+	}
+	return m, nil
+}
+
+// WithDebug enables verbose logging of proxy activity and sandbox setup.
+func WithDebug(enabled bool) Option {
+	return func(m *Manager) error {
+		m.debug = enabled
+		return nil
+	}
+}
+
+// WithMonitor enables logging of violations (blocked requests) only.
+func WithMonitor(enabled bool) Option {
+	return func(m *Manager) error {
+		m.monitor = enabled
+		return nil
+	}
+}
+
+// WithLogger sends the Manager's own log lines (debug messages, audit notices
+// for commands, and reports of cleaned-up crashed sessions) to w instead of
+// stderr. The proxies and monitors still log to stderr.
+func WithLogger(w io.Writer) Option {
+	return func(m *Manager) error {
+		m.log = logging.Default().WithOutput(w)
+		return nil
+	}
+}
+
+// WithBackend selects the Linux sandbox backend, as SetBackend does.
+func WithBackend(name string) Option {
+	return func(m *Manager) error {
+		return m.SetBackend(name)
+	}
+}
+
+// WithFilter makes filter decide which hosts the built-in proxies allow,
+// instead of network.allowedDomains and network.deniedDomains. Hosts it
+// denies are recorded in Violations; in audit mode they are allowed.
+func WithFilter(filter proxy.FilterFunc) Option {
+	return func(m *Manager) error {
+		m.filter = filter
+		return nil
+	}
+}
+
+// WithPolicy makes p decide the command's connections and commands, and
+// narrow the paths it may write, instead of the config's rules. The rest of
+// the config, such as the filesystem view and resource limits, still
+// applies, except policy.rego, which p replaces. It cannot be combined with
+// WithFilter.
+func WithPolicy(p Policy) Option {
+	return func(m *Manager) error {
+		if p == nil {
+			return errors.New("nil policy")
+		}
+		m.customPolicy = p
+		return nil
+	}
+}
+
+// WithHistory records in rec every host the proxies are asked to connect
+// to and every command wrapped, allowed or not, for replaying against
+// another config with history.Analyze.
+func WithHistory(rec *history.Recorder) Option {
+	return func(m *Manager) error {
+		m.history = rec
+		return nil
+	}
+}
+
+// WithStats counts in rec every host the proxies are asked to connect to
+// and every command wrapped, with the config rule that decided each, for
+// fence stats.
+func WithStats(rec *stats.Recorder) Option {
+	return func(m *Manager) error {
+		m.stats = rec
+		return nil
+	}
+}
+
+// WithAllowances lets connections through to hosts that no domain rule
+// matches while w has an active allowance for them, such as fence allow
+// grants. The allowances are read as they change, so they apply to running
+// commands. The store's directory is made read-only in the sandbox, so the
+// command cannot grant itself allowances. Allowances do not apply with
+// WithFilter or WithPolicy.
+func WithAllowances(w *allowance.Watcher) Option {
+	return func(m *Manager) error {
+		m.allowances = w
+		return nil
+	}
+}
+
+// WithTelemetry exports a span to exp for every connection the proxies
+// decide on. Violations are not exported here; subscribe exp.Violation to
+// Violations for those.
+func WithTelemetry(exp *telemetry.Exporter) Option {
+	return func(m *Manager) error {
+		m.telemetry = exp
+		return nil
+	}
+}
+
+// WithoutHTTPProxy leaves out the HTTP proxy. The sandboxed command gets no
+// HTTP_PROXY and cannot make HTTP requests, except through SOCKS if it is
+// still enabled.
+func WithoutHTTPProxy() Option {
+	return func(m *Manager) error {
+		m.noHTTPProxy = true
+		return nil
+	}
+}
+
+// WithoutSOCKS leaves out the SOCKS5 proxy. The sandboxed command gets no
+// ALL_PROXY. With WithoutHTTPProxy as well, it has no network access at all,
+// which makes a filesystem-only sandbox with nothing running on the host.
+func WithoutSOCKS() Option {
+	return func(m *Manager) error {
+		m.noSOCKSProxy = true
+		return nil
+	}
+}
+
+// WithoutNetworkSandbox leaves the network out of the sandbox: no proxies
+// run, and the command uses the host's network directly, so the network
+// rules are not enforced. Filesystem and command policy still apply. Use it
+// when the command's network is controlled elsewhere.
+func WithoutNetworkSandbox() Option {
+	return func(m *Manager) error {
+		m.noHTTPProxy = true
+		m.noSOCKSProxy = true
+		m.shareNetwork = true
+		return nil
+	}
+}
+
+// WithTerminal tells the Manager that commands run on a pseudo-terminal
+// created for them, such as fence --tty allocates. On Linux the command keeps
+// it as its controlling terminal, which interactive shells need for job
+// control; otherwise bwrap starts the command in a new session, so that it
+// cannot push input into the user's terminal with TIOCSTI. A private
+// terminal has no one else's input to inject into. On macOS the profile
+// allows the terminal, as with allowPty.
+func WithTerminal() Option {
+	return func(m *Manager) error {
+		m.terminal = true
+		return nil
+	}
+}
+
+// errNoSandbox is returned when wrapping a command with a Manager created
+// WithoutSandbox.
+var errNoSandbox = errors.New("sandbox manager only runs the proxies (created WithoutSandbox)")
+
+// WithoutSandbox makes a network-only Manager: Initialize starts just the
+// proxies, without the Linux bridges or D-Bus proxy, and WrapCommand and
+// Spec return an error. Point your own clients at HTTPPort and SOCKSPort to
+// filter their traffic with fence's network policy.
+func WithoutSandbox() Option {
+	return func(m *Manager) error {
+		m.noSandbox = true
+		return nil
+	}
+}
+
+// WorkspaceOverlay is a copy-on-write overlay over a directory: sandboxed
+// commands see Dir as usual, but what they write there goes to Upper and Dir
+// itself is left unchanged.
+type WorkspaceOverlay struct {
+	Dir   string
+	Upper string
+	// Work is overlayfs's scratch directory, on the same filesystem as Upper.
+	Work string
+}
+
+// WithCopyOnWrite mounts overlay over its directory in the sandbox. It
+// requires Linux and the bwrap (0.8 or later) or native backend. Paths in Dir
+// that the config protects stay protected, and writes outside Dir are not
+// affected.
+func WithCopyOnWrite(overlay WorkspaceOverlay) Option {
+	return func(m *Manager) error {
+		if platform.Detect() != platform.Linux {
+			return errors.New("copy-on-write mode is only supported on Linux")
+		}
+		if !filepath.IsAbs(overlay.Dir) || !filepath.IsAbs(overlay.Upper) || !filepath.IsAbs(overlay.Work) {
+			return errors.New("copy-on-write overlay paths must be absolute")
+		}
+		m.overlay = &overlay
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// EvaluatePath decides whether the sandbox allows reading path, or writing it
+// if write is true, and reports the rule that decided it. It follows the same
+// precedence as the generated bwrap mounts and Seatbelt profile:
+//   - reads are allowed unless the path is under filesystem.denyRead
+//   - writes are denied unless under filesystem.allowWrite or a default write
+//     path, and filesystem.denyWrite and mandatory protections always win
+func EvaluatePath(path string, write bool, cfg *config.Config) policy.Decision {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	target := NormalizePath(path)
+
+	if !write {
+		for _, p := range cfg.Filesystem.DenyRead {
+			if pathMatchesPattern(target, p) {
+				return policy.Deny(policy.RuleRef("filesystem.denyRead", p), "path is hidden from the sandbox")
+			}
+		}
+		if cfg.Filesystem.DenySecrets {
+			for _, p := range config.DefaultSecretPaths {
+				if pathMatchesPattern(target, p) {
+					return policy.Deny(policy.RuleRef("filesystem.denySecrets", p), "credential stores are hidden from the sandbox")
+				}
+			}
+		}
+		return policy.Allow("", "reads are allowed unless denied by filesystem.denyRead")
+	}
+
+	for _, p := range cfg.Filesystem.DenyWrite {
+		if pathMatchesPattern(target, p) {
+			return policy.Deny(policy.RuleRef("filesystem.denyWrite", p), "denyWrite takes precedence over allowWrite")
+		}
+	}
+
+	cwd, _ := os.Getwd()
+	for _, p := range GetMandatoryDenyPatterns(cwd, cfg.Filesystem.AllowGitConfig) {
+		if pathMatchesPattern(target, p) {
+			return policy.Deny(policy.RuleRef("mandatory deny", p), "always write-protected (can be used for code execution)")
+		}
+	}
+
+	for _, p := range cfg.Filesystem.AllowWrite {
+		if pathMatchesPattern(target, p) {
+			return policy.Allow(policy.RuleRef("filesystem.allowWrite", p), "path is writable")
+		}
+	}
+
+	for _, p := range GetDefaultWritePaths() {
+		if pathMatchesPattern(target, p) {
+			return policy.Allow(policy.RuleRef("default write path", p), "needed for common tools to work")
+		}
+	}
+
+	// On Linux, /tmp is replaced by a private tmpfs (see WrapCommandLinuxWithOptions)
+	if runtime.GOOS == "linux" && isWithin(target, "/tmp") {
+		return policy.Allow("", "/tmp is a private tmpfs inside the sandbox; writes are discarded on exit")
+	}
+
+	return policy.Deny("", "no filesystem.allowWrite entry matches; writes are denied by default")
+}
+
+// pathMatchesPattern reports whether target (already normalized) is matched by a
+// config path pattern. Non-glob patterns match the path and everything beneath it.
+func pathMatchesPattern(target, pattern string) bool {
+	normalized := NormalizePath(pattern)
+	if ContainsGlobChars(normalized) {
+		matched, err := regexp.MatchString(GlobToRegex(normalized), target)
+		return err == nil && matched
+	}
+	return isWithin(target, normalized)
+}
+
+// isWithin reports whether path equals dir or is beneath it.
+func isWithin(path, dir string) bool {
+	if dir == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+func TestEvaluatePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "workspace")
+	secrets := filepath.Join(tmpDir, "secrets")
+	for _, d := range []string{workspace, secrets, filepath.Join(workspace, "vendor")} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Resolve symlinks (e.g. /var -> /private/var on macOS) to match NormalizePath
+	workspace, _ = filepath.EvalSymlinks(workspace)
+	secrets, _ = filepath.EvalSymlinks(secrets)
+
+	cfg := &config.Config{
+		Filesystem: config.FilesystemConfig{
+			DenyRead:   []string{secrets, "**/*.pem"},
+			AllowWrite: []string{workspace},
+			DenyWrite:  []string{filepath.Join(workspace, "vendor")},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		write       bool
+		wantAllowed bool
+		wantRule    string
+	}{
+		{"read allowed by default", "/usr/bin/env", false, true, ""},
+		{"read denied by subpath", filepath.Join(secrets, "token"), false, false, `filesystem.denyRead "` + secrets + `"`},
+		{"read denied by glob", filepath.Join(workspace, "certs/key.pem"), false, false, `filesystem.denyRead "**/*.pem"`},
+		{"write allowed", filepath.Join(workspace, "out.txt"), true, true, `filesystem.allowWrite "` + workspace + `"`},
+		{"denyWrite wins", filepath.Join(workspace, "vendor", "x"), true, false, `filesystem.denyWrite "` + filepath.Join(workspace, "vendor") + `"`},
+		{"mandatory deny wins", filepath.Join(workspace, ".bashrc"), true, false, `mandatory deny "**/.bashrc"`},
+		{"write denied by default", "/usr/local/bin/tool", true, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluatePath(tt.path, tt.write, cfg)
+			if d.Allowed != tt.wantAllowed {
+				t.Errorf("EvaluatePath(%q, write=%v) = %s, want allowed=%v", tt.path, tt.write, d, tt.wantAllowed)
+			}
+			if d.Rule != tt.wantRule {
+				t.Errorf("EvaluatePath(%q, write=%v).Rule = %q, want %q", tt.path, tt.write, d.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestEvaluatePathDenySecrets(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	home, _ = filepath.EvalSymlinks(home)
+
+	cfg := &config.Config{Filesystem: config.FilesystemConfig{DenySecrets: true}}
+	tests := []struct {
+		path     string
+		wantRule string
+	}{
+		{filepath.Join(home, ".aws", "credentials"), `filesystem.denySecrets "~/.aws"`},
+		{filepath.Join(home, ".config", "google-chrome", "Default", "Cookies"), `filesystem.denySecrets "~/.config/google-chrome/*/Cookies"`},
+		{filepath.Join(home, ".config", "google-chrome", "Default", "History"), ""},
+	}
+	for _, tt := range tests {
+		d := EvaluatePath(tt.path, false, cfg)
+		if d.Rule != tt.wantRule || d.Allowed != (tt.wantRule == "") {
+			t.Errorf("EvaluatePath(%q) = %s, want rule %q", tt.path, d, tt.wantRule)
+		}
+	}
+
+	cfg.Filesystem.DenySecrets = false
+	if d := EvaluatePath(filepath.Join(home, ".aws", "credentials"), false, cfg); !d.Allowed {
+		t.Errorf("EvaluatePath() = %s, want allowed without denySecrets", d)
+	}
+}
+
+func TestEvaluatePathTmp(t *testing.T) {
+	d := EvaluatePath("/tmp/fence/build.log", true, config.Default())
+	if !d.Allowed {
+		t.Errorf("expected /tmp/fence to be writable by default, got %s", d)
+	}
+
+	d = EvaluatePath("/tmp/other", true, config.Default())
+	if wantAllowed := runtime.GOOS == "linux"; d.Allowed != wantAllowed {
+		t.Errorf("EvaluatePath(/tmp/other) = %s, want allowed=%v", d, wantAllowed)
+	}
+}
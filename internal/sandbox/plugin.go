@@ -0,0 +1,254 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// DefaultPluginTimeout is how long a policy plugin has to answer a query
+// before the operation is denied.
+const DefaultPluginTimeout = 5 * time.Second
+
+// pluginRule names the plugin in the decisions it makes.
+const pluginRule = "policy plugin"
+
+// PluginRequest is a query fence writes to a policy plugin's stdin, one JSON
+// object per line. Queries may be outstanding concurrently; the plugin
+// answers each with a PluginResponse carrying its ID, in any order.
+type PluginRequest struct {
+	ID   uint64 `json:"id"`
+	Kind string `json:"kind"` // policy.KindNetwork, KindCommand, or KindFilesystem
+
+	Host    string `json:"host,omitempty"`    // Network
+	Port    int    `json:"port,omitempty"`    // Network
+	Command string `json:"command,omitempty"` // Command: the whole command line
+	Path    string `json:"path,omitempty"`    // Filesystem
+	Write   bool   `json:"write,omitempty"`   // Filesystem
+
+	// Default is the config's decision, which no rule made.
+	Default policy.Decision `json:"default"`
+}
+
+// PluginResponse is a policy plugin's answer to a PluginRequest, one JSON
+// object per line on its stdout.
+type PluginResponse struct {
+	ID      uint64 `json:"id"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// PluginPolicy is a Policy that applies a config and asks an external
+// program about the operations no config rule decides: hosts in no domain
+// list, commands no command rule matches, and paths no filesystem rule
+// covers. An explicit rule is final. If the plugin does not answer within
+// the timeout, or has exited, the operation is denied.
+type PluginPolicy struct {
+	ConfigPolicy
+	timeout time.Duration
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex // Keeps concurrent requests whole
+
+	mu      sync.Mutex
+	pending map[uint64]chan PluginResponse
+	nextID  uint64
+	err     error // Why the plugin stopped answering, once it has
+	exited  chan struct{}
+}
+
+// StartPolicyPlugin starts cmd as a policy plugin for cfg. cmd's stdin and
+// stdout carry the queries; its stderr is left as set, so set it to see the
+// plugin's own messages. A timeout of 0 is DefaultPluginTimeout. Close stops
+// the plugin.
+func StartPolicyPlugin(cmd *exec.Cmd, cfg *config.Config, timeout time.Duration) (*PluginPolicy, error) {
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start policy plugin: %w", err)
+	}
+
+	p := &PluginPolicy{
+		ConfigPolicy: ConfigPolicy{Config: cfg},
+		timeout:      timeout,
+		cmd:          cmd,
+		stdin:        stdin,
+		pending:      make(map[uint64]chan PluginResponse),
+		exited:       make(chan struct{}),
+	}
+	go p.read(stdout)
+	return p, nil
+}
+
+// read delivers the plugin's responses until its stdout closes, then fails
+// the outstanding and later queries.
+func (p *PluginPolicy) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var resp PluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			logging.Warnf("plugin", "ignoring invalid response %q: %v", scanner.Text(), err)
+			continue
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("policy plugin exited")
+	}
+	p.mu.Lock()
+	p.err = err
+	p.pending = nil
+	p.mu.Unlock()
+	close(p.exited)
+}
+
+// ask sends req to the plugin and waits for its response.
+func (p *PluginPolicy) ask(req PluginRequest) (PluginResponse, error) {
+	ch := make(chan PluginResponse, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return PluginResponse{}, err
+	}
+	p.nextID++
+	req.ID = p.nextID
+	p.pending[req.ID] = ch
+	p.mu.Unlock()
+
+	forget := func() {
+		p.mu.Lock()
+		delete(p.pending, req.ID)
+		p.mu.Unlock()
+	}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		forget()
+		return PluginResponse{}, err
+	}
+	p.writeMu.Lock()
+	_, err = p.stdin.Write(append(line, '\n'))
+	p.writeMu.Unlock()
+	if err != nil {
+		forget()
+		return PluginResponse{}, fmt.Errorf("failed to write to policy plugin: %w", err)
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-p.exited:
+		return PluginResponse{}, p.err
+	case <-timer.C:
+		forget()
+		return PluginResponse{}, fmt.Errorf("policy plugin did not answer within %s", p.timeout)
+	}
+}
+
+// decide returns the plugin's decision on req, or a denial if it fails.
+func (p *PluginPolicy) decide(req PluginRequest) policy.Decision {
+	resp, err := p.ask(req)
+	if err != nil {
+		logging.Warnf("plugin", "denying %s: %v", req.target(), err)
+		return policy.Deny(pluginRule, err.Error())
+	}
+	reason := resp.Reason
+	if reason == "" {
+		reason = "decided by the policy plugin"
+	}
+	if resp.Allowed {
+		return policy.Allow(pluginRule, reason)
+	}
+	return policy.Deny(pluginRule, reason)
+}
+
+// target describes what req asks about, for log messages.
+func (req PluginRequest) target() string {
+	switch req.Kind {
+	case policy.KindNetwork:
+		return fmt.Sprintf("connection to %s:%d", req.Host, req.Port)
+	case policy.KindCommand:
+		return fmt.Sprintf("command %q", req.Command)
+	default:
+		return fmt.Sprintf("access to %s", req.Path)
+	}
+}
+
+// DecideConnection decides host by the config's domain rules, or the plugin
+// if none matches.
+func (p *PluginPolicy) DecideConnection(host string, port int) policy.Decision {
+	d := p.ConfigPolicy.DecideConnection(host, port)
+	if d.Rule != "" {
+		return d
+	}
+	return p.decide(PluginRequest{Kind: policy.KindNetwork, Host: host, Port: port, Default: d})
+}
+
+// DecidePath decides path by the config's filesystem rules, or the plugin if
+// none covers it.
+func (p *PluginPolicy) DecidePath(path string, write bool) policy.Decision {
+	d := p.ConfigPolicy.DecidePath(path, write)
+	if d.Rule != "" {
+		return d
+	}
+	return p.decide(PluginRequest{Kind: policy.KindFilesystem, Path: path, Write: write, Default: d})
+}
+
+// DecideCommand decides command by the config's command rules, or the
+// plugin if none matches.
+func (p *PluginPolicy) DecideCommand(command string) policy.Decision {
+	d := p.ConfigPolicy.DecideCommand(command)
+	if d.Rule != "" {
+		return d
+	}
+	return p.decide(PluginRequest{Kind: policy.KindCommand, Command: command, Default: d})
+}
+
+// Close stops the plugin: its stdin is closed, and it is killed if it has
+// not exited a second later.
+func (p *PluginPolicy) Close() error {
+	_ = p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(time.Second):
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	err := p.cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A plugin killed or failing on the way out has nothing left to decide
+		return nil
+	}
+	return err
+}
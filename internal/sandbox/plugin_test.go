@@ -0,0 +1,110 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// TestPolicyPluginHelper is the plugin the tests below start: it allows
+// ok.example.com and commands other than deploys, never answers about
+// slow.example.com, and exits when asked about crash.example.com.
+func TestPolicyPluginHelper(t *testing.T) {
+	if os.Getenv("FENCE_TEST_POLICY_PLUGIN") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req PluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+		resp := PluginResponse{ID: req.ID}
+		switch {
+		case req.Host == "slow.example.com":
+			continue
+		case req.Host == "crash.example.com":
+			os.Exit(1)
+		case req.Kind == policy.KindNetwork:
+			resp.Allowed = req.Host == "ok.example.com" && !req.Default.Allowed
+		case req.Kind == policy.KindCommand:
+			resp.Allowed = !strings.HasPrefix(req.Command, "deploy")
+			if !resp.Allowed {
+				resp.Reason = "deploys need a ticket"
+			}
+		}
+		line, _ := json.Marshal(resp)
+		os.Stdout.Write(append(line, '\n'))
+	}
+	os.Exit(0)
+}
+
+func startTestPlugin(t *testing.T, cfg *config.Config) *PluginPolicy {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPolicyPluginHelper$")
+	cmd.Env = append(os.Environ(), "FENCE_TEST_POLICY_PLUGIN=1")
+	p, err := StartPolicyPlugin(cmd, cfg, 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartPolicyPlugin() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestPluginPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	cfg.Network.DeniedDomains = []string{"ok.example.com"}
+	cfg.Command.Deny = []string{"git push"}
+	p := startTestPlugin(t, cfg)
+
+	tests := []struct {
+		name string
+		got  policy.Decision
+		want bool
+		rule string
+	}{
+		{"allowed by rule", p.DecideConnection("github.com", 443), true, `network.allowedDomains "github.com"`},
+		{"denied by rule", p.DecideConnection("ok.example.com", 443), false, `network.deniedDomains "ok.example.com"`},
+		{"denied by plugin", p.DecideConnection("example.org", 443), false, pluginRule},
+		{"command denied by rule", p.DecideCommand("git push"), false, `command.deny "git push"`},
+		{"command allowed by plugin", p.DecideCommand("ls"), true, pluginRule},
+		{"command denied by plugin", p.DecideCommand("deploy prod"), false, pluginRule},
+		{"timeout", p.DecideConnection("slow.example.com", 443), false, pluginRule},
+	}
+	for _, tt := range tests {
+		if tt.got.Allowed != tt.want || tt.got.Rule != tt.rule {
+			t.Errorf("%s: decision = %v, want allowed=%v by %s", tt.name, tt.got, tt.want, tt.rule)
+		}
+	}
+	if d := p.DecideCommand("deploy prod"); d.Reason != "deploys need a ticket" {
+		t.Errorf("Reason = %q, want the plugin's", d.Reason)
+	}
+}
+
+func TestPluginPolicyAllowsUnlistedHost(t *testing.T) {
+	p := startTestPlugin(t, config.Default())
+	if d := p.DecideConnection("ok.example.com", 443); !d.Allowed {
+		t.Errorf("DecideConnection(ok.example.com) = %v, want allowed by the plugin", d)
+	}
+}
+
+func TestPluginPolicyFailsClosed(t *testing.T) {
+	p := startTestPlugin(t, config.Default())
+	if d := p.DecideCommand("ls"); !d.Allowed {
+		t.Fatalf("DecideCommand(ls) = %v, want allowed", d)
+	}
+	if d := p.DecideConnection("crash.example.com", 443); d.Allowed {
+		t.Errorf("DecideConnection(crash.example.com) = %v, want denied", d)
+	}
+	if d := p.DecideCommand("ls"); d.Allowed {
+		t.Errorf("after the plugin exited, DecideCommand(ls) = %v, want denied", d)
+	}
+}
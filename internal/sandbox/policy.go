@@ -0,0 +1,100 @@
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+)
+
+// Policy decides what a sandboxed command may do, in place of the config's
+// rules. Implementations may consult anything, such as a supervisor or a
+// ticket system; ConfigPolicy applies a config and can be wrapped to change
+// some decisions. Methods may be called concurrently.
+type Policy interface {
+	// DecideConnection decides whether the proxies allow a connection to
+	// host:port. It is called for every connection, which waits for it.
+	DecideConnection(host string, port int) policy.Decision
+
+	// DecidePath decides whether the command may read path, or write it if
+	// write is true. The sandbox's filesystem view is built from the config
+	// before the command starts, so this can only narrow it: each
+	// filesystem.allowWrite path is asked about once, and left out if
+	// denied.
+	DecidePath(path string, write bool) policy.Decision
+
+	// DecideCommand decides whether WrapCommand runs command, the whole
+	// command line it was given.
+	DecideCommand(command string) policy.Decision
+}
+
+// ConfigPolicy is the Policy fence applies by default: the rules in Config,
+// decided as EvaluatePath and EvaluateCommand do. A nil Config is
+// config.Default().
+type ConfigPolicy struct {
+	Config *config.Config
+}
+
+// DecideConnection decides host by network.allowedDomains and
+// network.deniedDomains.
+func (p ConfigPolicy) DecideConnection(host string, port int) policy.Decision {
+	cfg := p.Config
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	return policy.EvaluateDomain(cfg, host)
+}
+
+// DecidePath decides path by the filesystem rules.
+func (p ConfigPolicy) DecidePath(path string, write bool) policy.Decision {
+	return EvaluatePath(path, write, p.Config)
+}
+
+// DecideCommand decides command by the command and ssh rules.
+func (p ConfigPolicy) DecideCommand(command string) policy.Decision {
+	return EvaluateCommand(command, p.Config)
+}
+
+// policyFilter makes p decide the proxies' connections. Denials and
+// warnings are recorded in log; in audit mode denied connections are
+// allowed, and when verbose is true each would-be denial is also logged to
+// stderr.
+func policyFilter(p Policy, log *policy.ViolationLog, verbose bool) proxy.FilterFunc {
+	return func(host string, port int) bool {
+		d := p.DecideConnection(host, port)
+		if d.Warn {
+			proxy.RecordWarning(log, host, port, d)
+		}
+		if d.Allowed {
+			return true
+		}
+		target := net.JoinHostPort(host, strconv.Itoa(port))
+		log.Record(policy.Event{Source: policy.SourceProxy, Kind: policy.KindNetwork, Target: target, Decision: d})
+		if !log.Audit() {
+			return false
+		}
+		if verbose {
+			policy.MonitorOutput.Print(d.Basis(), "audit "+target, fmt.Sprintf("[fence:audit] Would block %s:%d (%s)", host, port, d.Basis()))
+		}
+		return true
+	}
+}
+
+// policyConfig returns cfg without the filesystem.allowWrite paths p denies
+// writing to. cfg itself is not modified.
+func policyConfig(cfg *config.Config, p Policy) *config.Config {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	c := *cfg
+	c.Filesystem.AllowWrite = nil
+	for _, path := range cfg.Filesystem.AllowWrite {
+		if p.DecidePath(path, true).Allowed {
+			c.Filesystem.AllowWrite = append(c.Filesystem.AllowWrite, path)
+		}
+	}
+	return &c
+}
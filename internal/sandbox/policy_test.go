@@ -0,0 +1,122 @@
+package sandbox
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// ticketPolicy allows what its config allows, and in addition the hosts and
+// commands that have a ticket.
+type ticketPolicy struct {
+	ConfigPolicy
+	tickets []string
+}
+
+func (p ticketPolicy) DecideConnection(host string, port int) policy.Decision {
+	if slices.Contains(p.tickets, host) {
+		return policy.Allow("ticket "+host, "approved")
+	}
+	return p.ConfigPolicy.DecideConnection(host, port)
+}
+
+func (p ticketPolicy) DecidePath(path string, write bool) policy.Decision {
+	if write && strings.HasPrefix(path, "/tmp/locked") {
+		return policy.Deny("ticket", "locked")
+	}
+	return p.ConfigPolicy.DecidePath(path, write)
+}
+
+func (p ticketPolicy) DecideCommand(command string) policy.Decision {
+	if strings.HasPrefix(command, "deploy") && !slices.Contains(p.tickets, command) {
+		return policy.Deny("ticket", "deploys need a ticket")
+	}
+	return p.ConfigPolicy.DecideCommand(command)
+}
+
+func TestConfigPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	cfg.Command.Deny = []string{"git push"}
+	cfg.Filesystem.DenyRead = []string{"/etc/secret"}
+	p := ConfigPolicy{Config: cfg}
+
+	if !p.DecideConnection("github.com", 443).Allowed || p.DecideConnection("example.com", 443).Allowed {
+		t.Error("DecideConnection should follow network.allowedDomains")
+	}
+	if d := p.DecideCommand("ls && git push"); d.Allowed || d.Rule != `command.deny "git push"` {
+		t.Errorf("DecideCommand() = %v, want denied by command.deny", d)
+	}
+	if p.DecidePath("/etc/secret", false).Allowed {
+		t.Error("DecidePath should follow filesystem.denyRead")
+	}
+	if !(ConfigPolicy{}).DecideCommand("ls").Allowed {
+		t.Error("a nil Config should be the default config")
+	}
+}
+
+func TestPolicyFilter(t *testing.T) {
+	p := ticketPolicy{ConfigPolicy: ConfigPolicy{Config: config.Default()}, tickets: []string{"example.com"}}
+	log := policy.NewViolationLog(false)
+	filter := policyFilter(p, log, false)
+
+	if !filter("example.com", 443) {
+		t.Error("the policy should allow example.com")
+	}
+	if filter("github.com", 443) {
+		t.Error("the policy should deny github.com")
+	}
+	if v := log.Violations(); len(v) != 1 || v[0].Target != "github.com:443" {
+		t.Errorf("Violations() = %+v, want the denial of github.com:443", v)
+	}
+
+	log.SetAudit(true)
+	if !filter("github.com", 443) {
+		t.Error("audit mode should allow the connection")
+	}
+}
+
+func TestPolicyConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"/tmp/work", "/tmp/locked"}
+	p := ticketPolicy{ConfigPolicy: ConfigPolicy{Config: cfg}}
+
+	got := policyConfig(cfg, p)
+	if !slices.Equal(got.Filesystem.AllowWrite, []string{"/tmp/work"}) {
+		t.Errorf("AllowWrite = %v, want [/tmp/work]", got.Filesystem.AllowWrite)
+	}
+	if len(cfg.Filesystem.AllowWrite) != 2 {
+		t.Error("policyConfig should not modify cfg")
+	}
+}
+
+func TestManagerWithPolicy(t *testing.T) {
+	p := ticketPolicy{ConfigPolicy: ConfigPolicy{Config: config.Default()}, tickets: []string{"deploy prod"}}
+	m, err := New(config.Default(), WithPolicy(p))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := m.checkCommand("deploy prod"); err != nil {
+		t.Errorf("checkCommand(deploy prod) = %v, want allowed", err)
+	}
+	err = m.checkCommand("deploy staging")
+	var pv *PolicyViolationError
+	if !errors.As(err, &pv) || pv.Rule != "ticket" {
+		t.Errorf("checkCommand(deploy staging) = %v, want a PolicyViolationError for rule ticket", err)
+	}
+	if v := m.Violations().Violations(); len(v) != 1 || v[0].Decision.Rule != "ticket" {
+		t.Errorf("Violations() = %+v, want the denial recorded with the policy's rule", v)
+	}
+
+	if _, err := New(config.Default(), WithPolicy(p), WithFilter(func(string, int) bool { return true })); err == nil {
+		t.Error("New() should reject WithPolicy with WithFilter")
+	}
+	if _, err := New(config.Default(), WithPolicy(nil)); err == nil {
+		t.Error("New() should reject a nil policy")
+	}
+}
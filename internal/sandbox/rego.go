@@ -0,0 +1,201 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// DefaultRegoQuery is the rule a Rego policy decides with unless
+// policy.query names another.
+const DefaultRegoQuery = "data.fence.decision"
+
+// regoTimeout bounds a single evaluation, so a runaway policy denies an
+// operation rather than hanging it.
+const regoTimeout = 5 * time.Second
+
+// RegoInput is the input document a Rego policy is evaluated with, as
+// input.kind, input.host, and so on.
+type RegoInput struct {
+	Kind string `json:"kind"` // policy.KindNetwork, KindCommand, or KindFilesystem
+
+	Host     string   `json:"host,omitempty"`     // Network
+	Port     int      `json:"port,omitempty"`     // Network
+	Command  string   `json:"command,omitempty"`  // Command: the whole command line
+	Commands []string `json:"commands,omitempty"` // Command: each simple command in it, as the command rules see them
+	Path     string   `json:"path,omitempty"`     // Filesystem
+	Write    bool     `json:"write,omitempty"`    // Filesystem
+
+	// Default is the config's decision, which the policy may keep.
+	Default policy.Decision `json:"default"`
+
+	// Config is the fence config in effect, as JSON.
+	Config any `json:"config"`
+	// Builtin holds fence's built-in lists, which apply on top of the
+	// config.
+	Builtin RegoBuiltin `json:"builtin"`
+}
+
+// RegoBuiltin is input.builtin: the lists fence applies without being told
+// to.
+type RegoBuiltin struct {
+	DeniedCommands       []string                 `json:"deniedCommands"`
+	DeniedCommandFlags   []config.CommandFlagRule `json:"deniedCommandFlags"`
+	SecretPaths          []string                 `json:"secretPaths"`
+	DangerousFiles       []string                 `json:"dangerousFiles"`
+	DangerousDirectories []string                 `json:"dangerousDirectories"`
+	DefaultWritePaths    []string                 `json:"defaultWritePaths"`
+}
+
+// RegoPolicy is a Policy that evaluates a Rego policy with Open Policy
+// Agent. The query's value decides: true or false, or an object with
+// "allowed" and an optional "reason". If the query is undefined for an
+// operation, the config's decision stands; if evaluation fails, the
+// operation is denied.
+type RegoPolicy struct {
+	ConfigPolicy
+	source  string
+	name    string // The query, e.g. data.fence.decision
+	query   rego.PreparedEvalQuery
+	config  any
+	builtin RegoBuiltin
+}
+
+// LoadRegoPolicy compiles the Rego policy cfg.Policy.Rego names: a .rego
+// file, a directory of .rego and data files, or a bundle .tar.gz.
+func LoadRegoPolicy(cfg *config.Config) (*RegoPolicy, error) {
+	if cfg == nil || cfg.Policy.Rego == "" {
+		return nil, errors.New("no policy.rego set")
+	}
+	source := NormalizePath(cfg.Policy.Rego)
+	query := cfg.Policy.Query
+	if query == "" {
+		query = DefaultRegoQuery
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy.rego: %w", err)
+	}
+	load := rego.Load([]string{source}, nil)
+	if info.IsDir() || strings.HasSuffix(source, ".tar.gz") {
+		load = rego.LoadBundle(source)
+	}
+	prepared, err := rego.New(rego.Query(query), load).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile policy.rego %s: %w", cfg.Policy.Rego, err)
+	}
+
+	// Round-trip the config so the policy sees it as written in JSON
+	var doc any
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return &RegoPolicy{
+		ConfigPolicy: ConfigPolicy{Config: cfg},
+		source:       cfg.Policy.Rego,
+		name:         query,
+		query:        prepared,
+		config:       doc,
+		builtin: RegoBuiltin{
+			DeniedCommands:       config.DefaultDeniedCommands,
+			DeniedCommandFlags:   config.DefaultDeniedCommandFlags,
+			SecretPaths:          config.DefaultSecretPaths,
+			DangerousFiles:       DangerousFiles,
+			DangerousDirectories: DangerousDirectories,
+			DefaultWritePaths:    GetDefaultWritePaths(),
+		},
+	}, nil
+}
+
+// decide evaluates the policy on in, falling back to in.Default if the
+// query is undefined.
+func (p *RegoPolicy) decide(in RegoInput) policy.Decision {
+	in.Config = p.config
+	in.Builtin = p.builtin
+	rule := policy.RuleRef("policy.rego", p.source)
+
+	// The input is passed as JSON-shaped values, which OPA reads directly
+	var input any
+	data, err := json.Marshal(in)
+	if err == nil {
+		err = json.Unmarshal(data, &input)
+	}
+	if err != nil {
+		return policy.Deny(rule, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), regoTimeout)
+	defer cancel()
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		logging.Warnf("rego", "denying %s: %v", in.target(), err)
+		return policy.Deny(rule, fmt.Sprintf("policy evaluation failed: %v", err))
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return in.Default
+	}
+
+	switch v := results[0].Expressions[0].Value.(type) {
+	case bool:
+		if v {
+			return policy.Allow(rule, "allowed by the Rego policy")
+		}
+		return policy.Deny(rule, "denied by the Rego policy")
+	case map[string]any:
+		allowed, ok := v["allowed"].(bool)
+		if !ok {
+			break
+		}
+		reason, _ := v["reason"].(string)
+		if reason == "" {
+			reason = "decided by the Rego policy"
+		}
+		if allowed {
+			return policy.Allow(rule, reason)
+		}
+		return policy.Deny(rule, reason)
+	}
+	logging.Warnf("rego", "denying %s: %s is not a boolean or an object with a boolean \"allowed\"", in.target(), p.name)
+	return policy.Deny(rule, "the Rego policy returned an invalid decision")
+}
+
+// target describes what in asks about, for log messages.
+func (in RegoInput) target() string {
+	return PluginRequest{Kind: in.Kind, Host: in.Host, Port: in.Port, Command: in.Command, Path: in.Path}.target()
+}
+
+// DecideConnection decides host by the policy, given the config's decision
+// by the domain rules.
+func (p *RegoPolicy) DecideConnection(host string, port int) policy.Decision {
+	d := p.ConfigPolicy.DecideConnection(host, port)
+	return p.decide(RegoInput{Kind: policy.KindNetwork, Host: host, Port: port, Default: d})
+}
+
+// DecidePath decides path by the policy, given the config's decision by the
+// filesystem rules.
+func (p *RegoPolicy) DecidePath(path string, write bool) policy.Decision {
+	d := p.ConfigPolicy.DecidePath(path, write)
+	return p.decide(RegoInput{Kind: policy.KindFilesystem, Path: path, Write: write, Default: d})
+}
+
+// DecideCommand decides command by the policy, given the config's decision
+// by the command rules.
+func (p *RegoPolicy) DecideCommand(command string) policy.Decision {
+	d := p.ConfigPolicy.DecideCommand(command)
+	return p.decide(RegoInput{Kind: policy.KindCommand, Command: command, Commands: parseShellCommand(command), Default: d})
+}
@@ -0,0 +1,153 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+const testRegoPolicy = `package fence
+
+# Deploys need a change window, whatever the config says
+decision := {"allowed": false, "reason": "deploys are frozen"} if {
+	input.kind == "command"
+	some cmd in input.commands
+	startswith(cmd, "deploy")
+}
+
+# Hosts the config allows are fine, and so are internal ones
+decision := true if {
+	input.kind == "network"
+	endswith(input.host, ".internal")
+}
+
+# Built-in lists are available to build on
+decision := {"allowed": false, "reason": "no writes to dangerous files"} if {
+	input.kind == "filesystem"
+	input.write
+	some name in input.builtin.dangerousFiles
+	endswith(input.path, name)
+}
+
+decision := false if {
+	input.kind == "network"
+	input.config.network.allowedDomains[_] == input.host
+	input.port == 22
+}
+`
+
+func writeRegoPolicy(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fence.rego")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRegoPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+	cfg.Command.Deny = []string{"git push"}
+	cfg.Policy.Rego = writeRegoPolicy(t, testRegoPolicy)
+
+	p, err := LoadRegoPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		d       policy.Decision
+		allowed bool
+		rule    string
+		reason  string
+	}{
+		{
+			name:    "policy denies command",
+			d:       p.DecideCommand("ls && deploy prod"),
+			allowed: false, rule: "policy.rego", reason: "deploys are frozen",
+		},
+		{
+			name:    "undefined keeps config denial",
+			d:       p.DecideCommand("git push"),
+			allowed: false, rule: `command.deny "git push"`,
+		},
+		{
+			name:    "undefined keeps config allow",
+			d:       p.DecideCommand("ls"),
+			allowed: true,
+		},
+		{
+			name:    "policy allows host the config does not",
+			d:       p.DecideConnection("db.internal", 5432),
+			allowed: true, rule: "policy.rego", reason: "allowed by the Rego policy",
+		},
+		{
+			name:    "policy sees the config",
+			d:       p.DecideConnection("github.com", 22),
+			allowed: false, rule: "policy.rego",
+		},
+		{
+			name:    "config allows host",
+			d:       p.DecideConnection("github.com", 443),
+			allowed: true, rule: "network.allowedDomains",
+		},
+		{
+			name:    "policy sees built-in lists",
+			d:       p.DecidePath("/home/u/.bashrc", true),
+			allowed: false, rule: "policy.rego", reason: "no writes to dangerous files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.d.Allowed != tt.allowed || !strings.HasPrefix(tt.d.Rule, tt.rule) || !strings.Contains(tt.d.Reason, tt.reason) {
+				t.Errorf("got %+v, want allowed=%v, rule %q..., reason containing %q", tt.d, tt.allowed, tt.rule, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRegoPolicyErrors(t *testing.T) {
+	cfg := config.Default()
+	cfg.Policy.Rego = writeRegoPolicy(t, "package fence\n\ndecision := \"yes\" if input.kind == \"command\"\n")
+	p, err := LoadRegoPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := p.DecideCommand("ls"); d.Allowed {
+		t.Errorf("an invalid decision should deny, got %+v", d)
+	}
+
+	cfg.Policy.Rego = writeRegoPolicy(t, "package fence\n\ndecision := {")
+	if _, err := LoadRegoPolicy(cfg); err == nil {
+		t.Error("a policy that does not parse should fail to load")
+	}
+	cfg.Policy.Rego = filepath.Join(t.TempDir(), "missing.rego")
+	if _, err := LoadRegoPolicy(cfg); err == nil {
+		t.Error("a missing policy should fail to load")
+	}
+}
+
+func TestNewLoadsRegoPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.AllowWrite = []string{"/tmp/ok", "/tmp/frozen"}
+	cfg.Policy.Rego = writeRegoPolicy(t, `package fence
+
+decision := false if {
+	input.kind == "filesystem"
+	input.path == "/tmp/frozen"
+}
+`)
+	m, err := New(cfg, WithoutNetworkSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.config.Filesystem.AllowWrite; len(got) != 1 || got[0] != "/tmp/ok" {
+		t.Errorf("allowWrite = %v, want the policy to drop /tmp/frozen", got)
+	}
+}
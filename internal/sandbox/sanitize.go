@@ -1,9 +1,13 @@
 package sandbox
 
 import (
+	"maps"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
 )
 
 // DangerousEnvPrefixes lists environment variable prefixes that can be used
@@ -59,6 +63,25 @@ func FilterDangerousEnv(env []string) []string {
 	return filtered
 }
 
+// FilterEnv returns env without the dangerous variables and those cfg's
+// allow and deny lists exclude, followed by the variables cfg sets. A
+// dangerous variable is removed even if cfg sets it.
+func FilterEnv(env []string, cfg *config.EnvConfig) []string {
+	filtered := make([]string, 0, len(env)+len(cfg.Set))
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		if _, replaced := cfg.Set[key]; !replaced && cfg.Passes(key) && !isDangerousEnvVar(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(cfg.Set)) {
+		if e := key + "=" + cfg.Set[key]; !isDangerousEnvVar(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
 // isDangerousEnvVar checks if an environment variable entry (KEY=VALUE) is dangerous.
 func isDangerousEnvVar(entry string) bool {
 	// Split on first '=' to get the key
@@ -1,7 +1,10 @@
 package sandbox
 
 import (
+	"slices"
 	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
 )
 
 func TestIsDangerousEnvVar(t *testing.T) {
@@ -154,3 +157,48 @@ func TestFilterDangerousEnv_AllSafe(t *testing.T) {
 		t.Errorf("expected all 3 vars to pass through, got %d", len(filtered))
 	}
 }
+
+func TestFilterEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/user",
+		"AWS_ACCESS_KEY_ID=AKIA",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"GITHUB_TOKEN=ghp",
+		"NODE_ENV=development",
+		"LD_PRELOAD=/tmp/evil.so",
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.EnvConfig
+		want []string
+	}{
+		{
+			name: "defaults strip only dangerous vars",
+			want: []string{"PATH=/usr/bin", "HOME=/home/user", "AWS_ACCESS_KEY_ID=AKIA", "AWS_SECRET_ACCESS_KEY=secret", "GITHUB_TOKEN=ghp", "NODE_ENV=development"},
+		},
+		{
+			name: "deny with wildcards",
+			cfg:  config.EnvConfig{Deny: []string{"AWS_*", "*_TOKEN"}},
+			want: []string{"PATH=/usr/bin", "HOME=/home/user", "NODE_ENV=development"},
+		},
+		{
+			name: "allow list",
+			cfg:  config.EnvConfig{Allow: []string{"PATH", "HOME", "AWS_*", "LD_*"}, Deny: []string{"AWS_SECRET_ACCESS_KEY"}},
+			want: []string{"PATH=/usr/bin", "HOME=/home/user", "AWS_ACCESS_KEY_ID=AKIA"},
+		},
+		{
+			name: "set replaces and adds, but not dangerous vars",
+			cfg:  config.EnvConfig{Allow: []string{"PATH"}, Set: map[string]string{"NODE_ENV": "test", "CI": "1", "LD_PRELOAD": "/x.so"}},
+			want: []string{"PATH=/usr/bin", "CI=1", "NODE_ENV=test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterEnv(env, &tt.cfg); !slices.Equal(got, tt.want) {
+				t.Errorf("FilterEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
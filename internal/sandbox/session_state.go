@@ -0,0 +1,170 @@
+package sandbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// sessionState records the host resources a Manager holds outside the
+// sandbox, so the next fence invocation can release them if this one dies
+// without running Shutdown. Mounts are not recorded: bwrap mounts live in the
+// sandbox's private mount namespace and disappear with it.
+type sessionState struct {
+	PID     int             `json:"pid"` // The fence process that owns the session
+	Started time.Time       `json:"started"`
+	Helpers []helperProcess `json:"helpers,omitempty"`
+	Files   []string        `json:"files,omitempty"`   // Sockets and CA bundles to remove
+	Cgroups []string        `json:"cgroups,omitempty"` // Resource limit cgroups to empty and remove
+}
+
+// helperProcess is a process started by a session that outlives it if fence
+// dies: xdg-dbus-proxy. The bridges run in the fence process and go with it.
+type helperProcess struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+	// Match is an argument unique to the process, such as its socket path.
+	// A PID is only killed if its command line still contains Match, so a
+	// reused PID is left alone.
+	Match string `json:"match"`
+}
+
+// empty reports whether the session holds nothing worth recovering.
+func (s *sessionState) empty() bool {
+	return len(s.Helpers) == 0 && len(s.Files) == 0 && len(s.Cgroups) == 0
+}
+
+// sessionStateDir returns the per-user directory for session state files:
+// $XDG_RUNTIME_DIR/fence, or fence-<uid> in the temp directory.
+func sessionStateDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "fence")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("fence-%d", os.Getuid()))
+}
+
+// ensureStateDir creates dir if needed and refuses to use it unless it is a
+// real directory accessible only to its owner.
+func ensureStateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("refusing to use %s: not a private directory", dir)
+	}
+	return nil
+}
+
+// writeSessionState writes state to a new file in dir and returns its path.
+func writeSessionState(dir string, state *sessionState) (string, error) {
+	if err := ensureStateDir(dir); err != nil {
+		return "", err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("session-%d-%s.json", state.PID, hex.EncodeToString(id)))
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// recoverSessions releases the resources of sessions in dir whose fence
+// process is no longer running, logging a line to log for each one recovered.
+func recoverSessions(dir string, log *logging.Logger) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "session-*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path) //nolint:gosec // path is in our private state directory
+		if err != nil {
+			continue
+		}
+		var state sessionState
+		if err := json.Unmarshal(data, &state); err != nil || state.PID <= 0 {
+			_ = os.Remove(path)
+			continue
+		}
+		if processAlive(state.PID) {
+			continue
+		}
+		// Claim the session; another fence starting now may race us for it
+		if err := os.Remove(path); err != nil {
+			continue
+		}
+
+		stopped := 0
+		for _, h := range state.Helpers {
+			if !processMatches(h.PID, h.Match) {
+				continue
+			}
+			if p, err := os.FindProcess(h.PID); err == nil && p.Kill() == nil {
+				stopped++
+			}
+		}
+		removed := 0
+		for _, f := range state.Files {
+			if os.Remove(f) == nil {
+				removed++
+			}
+		}
+		cgroups := 0
+		for _, c := range state.Cgroups {
+			if releaseCgroup(c) {
+				cgroups++
+			}
+		}
+
+		if stopped == 0 && removed == 0 && cgroups == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("Recovered crashed session (pid %d, started %s): stopped %d helper process(es), removed %d file(s)",
+			state.PID, state.Started.Format(time.RFC3339), stopped, removed)
+		if cgroups > 0 {
+			msg += fmt.Sprintf(", removed %d cgroup(s)", cgroups)
+		}
+		log.Infof("", "%s", msg)
+	}
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processMatches reports whether the process pid is running with match among
+// its arguments. It reads /proc, so it always reports false off Linux, where
+// sessions start no helper processes.
+func processMatches(pid int, match string) bool {
+	if match == "" {
+		return false
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(cmdline, []byte(match))
+}
@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+)
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestRecoverSessions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("helper processes are only matched via /proc on Linux")
+	}
+
+	dir := filepath.Join(t.TempDir(), "state")
+	socket := filepath.Join(t.TempDir(), "fence-http-test.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// An empty directory stands in for the resource limits cgroup
+	cgroup := filepath.Join(t.TempDir(), "fence-0a1b2c")
+	if err := os.Mkdir(cgroup, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A leftover helper, and an unrelated process that reused a recorded PID
+	helper := startProcess(t, "exec sleep 31.4159")
+	unrelated := startProcess(t, "exec sleep 27.1828")
+
+	crashed, err := writeSessionState(dir, &sessionState{
+		PID:     deadPID(t),
+		Started: time.Now(),
+		Helpers: []helperProcess{
+			{PID: helper.Process.Pid, Name: "xdg-dbus-proxy", Match: "31.4159"},
+			{PID: unrelated.Process.Pid, Name: "xdg-dbus-proxy", Match: "fence-dbus-gone.sock"},
+		},
+		Files:   []string{socket},
+		Cgroups: []string{cgroup},
+	})
+	if err != nil {
+		t.Fatalf("writeSessionState() error = %v", err)
+	}
+	live, err := writeSessionState(dir, &sessionState{PID: os.Getpid(), Files: []string{socket}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	recoverSessions(dir, logging.New(&out))
+
+	if _, err := os.Stat(crashed); !os.IsNotExist(err) {
+		t.Error("crashed session's state file was not removed")
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Error("live session's state file was removed")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Error("crashed session's socket was not removed")
+	}
+	if _, err := os.Stat(cgroup); !os.IsNotExist(err) {
+		t.Error("crashed session's cgroup was not removed")
+	}
+	if err := helper.Wait(); err == nil {
+		t.Error("leftover helper exited cleanly, want killed")
+	}
+	if !processAlive(unrelated.Process.Pid) {
+		t.Error("process with a reused PID was killed")
+	}
+
+	log := out.String()
+	for _, want := range []string{"Recovered crashed session", "stopped 1 helper", "removed 1 file", "removed 1 cgroup(s)"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log %q does not contain %q", log, want)
+		}
+	}
+}
+
+func TestEnsureStateDirRejectsSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o777); err != nil { //nolint:gosec // testing the permission check
+		t.Fatal(err)
+	}
+	if err := ensureStateDir(dir); err == nil {
+		t.Error("ensureStateDir() accepted a world-writable directory")
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	private := filepath.Join(t.TempDir(), "private")
+	if err := os.Mkdir(private, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureStateDir(link); err == nil {
+		t.Error("ensureStateDir() accepted a symlink")
+	}
+}
+
+func TestSessionStateEmpty(t *testing.T) {
+	if !(&sessionState{PID: 1}).empty() {
+		t.Error("a session without helpers or files should be empty")
+	}
+}
@@ -6,10 +6,13 @@ import (
 )
 
 // ShellQuote quotes a slice of strings for shell execution.
+// The result is a command line that a POSIX shell splits back into exactly
+// args, so it can carry an argv through sh -c.
 func ShellQuote(args []string) string {
 	var quoted []string
-	for _, arg := range args {
-		if needsQuoting(arg) {
+	for i, arg := range args {
+		// A leading NAME=value word would be taken as a variable assignment
+		if needsQuoting(arg) || (i == 0 && strings.Contains(arg, "=")) {
 			quoted = append(quoted, fmt.Sprintf("'%s'", strings.ReplaceAll(arg, "'", "'\\''")))
 		} else {
 			quoted = append(quoted, arg)
@@ -29,7 +32,7 @@ func ShellQuoteSingle(s string) string {
 // needsQuoting returns true if a string contains shell metacharacters.
 func needsQuoting(s string) bool {
 	for _, c := range s {
-		if c == ' ' || c == '\t' || c == '\n' || c == '"' || c == '\'' ||
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '"' || c == '\'' || c == '~' ||
 			c == '\\' || c == '$' || c == '`' || c == '!' || c == '*' ||
 			c == '?' || c == '[' || c == ']' || c == '(' || c == ')' ||
 			c == '{' || c == '}' || c == '<' || c == '>' || c == '|' ||
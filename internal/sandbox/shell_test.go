@@ -0,0 +1,43 @@
+package sandbox
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"plain", []string{"a", "b"}},
+		{"spaces and quotes", []string{"print('a b')", `say "hi"`}},
+		{"empty argument", []string{"", "x", ""}},
+		{"expansions", []string{"$HOME", "`id`", "$(id)", "*", "~", "~/x", "a;b", "a&&b", "#c"}},
+		{"whitespace", []string{"a\nb", "tab\there", "cr\r"}},
+		{"backslashes", []string{`a\b`, `\`, `'\''`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// printf prints each argument NUL-terminated, so we can split them back out
+			argv := append([]string{"printf", `%s\0`}, tt.args...)
+			out, err := exec.Command("sh", "-c", ShellQuote(argv)).Output() //nolint:gosec // test input
+			if err != nil {
+				t.Fatalf("sh -c %q: %v", ShellQuote(argv), err)
+			}
+			got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+			if !slices.Equal(got, tt.args) {
+				t.Errorf("ShellQuote(%q) round-tripped to %q", tt.args, got)
+			}
+		})
+	}
+}
+
+func TestShellQuoteLeadingAssignment(t *testing.T) {
+	if got := ShellQuote([]string{"FOO=bar", "X=y"}); got != "'FOO=bar' X=y" {
+		t.Errorf("ShellQuote() = %q, want the first word quoted", got)
+	}
+}
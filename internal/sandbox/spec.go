@@ -0,0 +1,76 @@
+package sandbox
+
+import "github.com/Use-Tusk/fence/internal/platform"
+
+// Spec describes the enforcement artifacts fence generates for a command, so
+// they can be audited without running anything. Only the fields for Platform
+// are set.
+type Spec struct {
+	Platform platform.Type
+	Command  string
+
+	// BwrapArgs is the full bubblewrap argument list, starting with "bwrap".
+	// With the native backend it starts with the fence binary and
+	// --native-sandbox instead, followed by the same arguments. With the
+	// apparmor and selinux backends it is the aa-exec or runcon command line.
+	// The last argument is the inner script that runs the command.
+	BwrapArgs []string
+	// SeccompSyscalls lists the syscalls the seccomp filter blocks, or with
+	// SeccompAllowlist the only ones it allows; nil if seccomp is not
+	// applied.
+	SeccompSyscalls  []string
+	SeccompAllowlist bool
+	// SeccompAction is what the filter does to the syscalls it blocks: one
+	// of the config.SeccompAction* values.
+	SeccompAction string
+	// LandlockRules lists the paths the Landlock ruleset grants access to, or
+	// nil if Landlock is not applied.
+	LandlockRules []LandlockRule
+	// LandlockNet restricts the TCP ports the command may bind and connect
+	// to, or is nil if Landlock does not restrict the network.
+	LandlockNet *LandlockNetRules
+	// AppArmorProfile is the profile the command runs under with the
+	// apparmor backend or security.lsm set to apparmor.
+	AppArmorProfile string
+	// SELinuxModule is the CIL policy module the command runs under with the
+	// selinux backend.
+	SELinuxModule string
+	// LSM is the LSM that confines the command inside the sandbox for
+	// security.lsm, "apparmor" or "selinux", or empty for none. With SELinux,
+	// SELinuxLevel is the MCS level the command runs at.
+	LSM          string
+	SELinuxLevel string
+	// Cgroup is the cgroup the command runs in to enforce the resources
+	// limits, and CgroupLimits the values written to it, as "file value".
+	Cgroup       string
+	CgroupLimits []string
+
+	// SeatbeltProfile is the sandbox-exec profile used on macOS.
+	SeatbeltProfile string
+	// Env holds the environment variables set for the command on macOS. On
+	// Linux they are exported by the inner script instead.
+	Env []string
+}
+
+// LandlockRule grants the sandbox access to a path and everything beneath it.
+type LandlockRule struct {
+	Path string
+	// Write grants read-write access; otherwise access is read and execute only.
+	Write bool
+}
+
+// Access returns "read" or "read-write".
+func (r LandlockRule) Access() string {
+	if r.Write {
+		return "read-write"
+	}
+	return "read"
+}
+
+// LandlockNetRules are the TCP ports Landlock (ABI v4+) lets the sandbox
+// bind and connect to. A nil list leaves that access unrestricted; an empty
+// one allows no port.
+type LandlockNetRules struct {
+	BindPorts    []int
+	ConnectPorts []int
+}
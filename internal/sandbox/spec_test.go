@@ -0,0 +1,224 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/platform"
+)
+
+func TestMacOSSpec(t *testing.T) {
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com"}
+
+	spec := MacOSSpec(cfg, "echo hello", 18080, 11080, nil)
+
+	if spec.Platform != platform.MacOS {
+		t.Errorf("Platform = %q, want %q", spec.Platform, platform.MacOS)
+	}
+	if !strings.Contains(spec.SeatbeltProfile, `(remote ip "localhost:18080")`) {
+		t.Error("profile should allow outbound connections to the HTTP proxy")
+	}
+	if !slices.Contains(spec.Env, "FENCE_SANDBOX=1") {
+		t.Errorf("Env = %v, want FENCE_SANDBOX=1", spec.Env)
+	}
+	if spec.BwrapArgs != nil || spec.LandlockRules != nil {
+		t.Error("Linux fields should not be set in a macOS spec")
+	}
+
+	// The spec must match what WrapCommandMacOS actually runs
+	if got := GenerateSandboxProfile(newMacOSSandboxParams(cfg, "echo hello", 18080, 11080, nil, false)); got != spec.SeatbeltProfile {
+		t.Error("spec profile differs from the generated profile")
+	}
+}
+
+func TestLinuxSpec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	cfg := config.Default()
+	bridge := &LinuxBridge{
+		HTTPSocketPath:  "/tmp/fence-http-test.sock",
+		SOCKSSocketPath: "/tmp/fence-socks-test.sock",
+	}
+
+	// Other tests in this process may have generated the filter already
+	filterPath := filepath.Join(os.TempDir(), "fence-seccomp", fmt.Sprintf("fence-seccomp-%d.bpf", os.Getpid()))
+	_ = os.Remove(filterPath)
+
+	spec, err := LinuxSpec(cfg, "echo hello", bridge, nil, LinuxSandboxOptions{UseSeccomp: true})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+
+	if spec.Platform != platform.Linux {
+		t.Errorf("Platform = %q, want %q", spec.Platform, platform.Linux)
+	}
+	if len(spec.BwrapArgs) == 0 || spec.BwrapArgs[0] != "bwrap" {
+		t.Fatalf("BwrapArgs should start with bwrap, got %v", spec.BwrapArgs)
+	}
+	args := strings.Join(spec.BwrapArgs, " ")
+	for _, want := range []string{"--ro-bind / /", "--tmpfs /tmp", "--bind " + bridge.HTTPSocketPath} {
+		if !strings.Contains(args, want) {
+			t.Errorf("BwrapArgs missing %q", want)
+		}
+	}
+	if script := spec.BwrapArgs[len(spec.BwrapArgs)-1]; !strings.Contains(script, "echo hello") {
+		t.Error("inner script should run the command")
+	}
+	if spec.SeatbeltProfile != "" {
+		t.Error("SeatbeltProfile should not be set in a Linux spec")
+	}
+
+	if DetectLinuxFeatures().HasSeccomp && len(spec.SeccompSyscalls) == 0 {
+		t.Error("expected seccomp syscalls when seccomp is available")
+	}
+	if _, err := os.Stat(filterPath); err == nil {
+		t.Error("LinuxSpec should not write a seccomp filter file")
+	}
+}
+
+// Without an HTTP proxy, the sandbox gets only the SOCKS socket and variables.
+func TestLinuxSpecSOCKSOnly(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	bridge := &LinuxBridge{SOCKSSocketPath: "/tmp/fence-socks-test.sock"}
+	spec, err := LinuxSpec(config.Default(), "echo hello", bridge, nil, LinuxSandboxOptions{})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+
+	args := strings.Join(spec.BwrapArgs, " ")
+	if !strings.Contains(args, "--bind "+bridge.SOCKSSocketPath) {
+		t.Errorf("BwrapArgs missing the SOCKS socket: %s", args)
+	}
+	script := spec.BwrapArgs[len(spec.BwrapArgs)-1]
+	for _, want := range []string{"export ALL_PROXY=", "export FENCE_SANDBOX=1"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("inner script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "HTTP_PROXY") || strings.Contains(args, "3128") {
+		t.Errorf("sandbox should not be routed to an HTTP proxy:\n%s", args)
+	}
+}
+
+func TestLinuxSpecMapToNobody(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	userns := "--unshare-user --uid 65534 --gid 65534"
+	for _, enabled := range []bool{false, true} {
+		cfg := config.Default()
+		cfg.Security.MapToNobody = enabled
+
+		spec, err := LinuxSpec(cfg, "id", nil, nil, LinuxSandboxOptions{})
+		if err != nil {
+			t.Fatalf("LinuxSpec() error = %v", err)
+		}
+		if got := strings.Contains(strings.Join(spec.BwrapArgs, " "), userns); got != enabled {
+			t.Errorf("mapToNobody=%v: BwrapArgs contain %q = %v", enabled, userns, got)
+		}
+	}
+}
+
+func TestLinuxSpecDenySecrets(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cookies := filepath.Join(home, ".config", "chromium", "Default", "Cookies")
+	for _, d := range []string{filepath.Join(home, ".kube"), filepath.Dir(cookies)} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(cookies, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Filesystem.DenySecrets = true
+	spec, err := LinuxSpec(cfg, "echo hello", nil, nil, LinuxSandboxOptions{Backend: BackendBwrap})
+	if err != nil {
+		t.Fatalf("LinuxSpec() error = %v", err)
+	}
+	args := spec.BwrapArgs
+
+	if at := slices.Index(args, filepath.Join(home, ".kube")); at < 1 || args[at-1] != "--tmpfs" {
+		t.Errorf("args %q should hide ~/.kube under a tmpfs", args)
+	}
+	if at := slices.Index(args, cookies); at < 2 || args[at-1] != "/dev/null" {
+		t.Errorf("args %q should mask the cookie store with /dev/null", args)
+	}
+	if slices.Contains(args, filepath.Join(home, ".aws")) {
+		t.Errorf("args %q should skip secret paths that do not exist", args)
+	}
+}
+
+func TestMacOSSpecDenySecrets(t *testing.T) {
+	cfg := config.Default()
+	cfg.Filesystem.DenySecrets = true
+	spec := MacOSSpec(cfg, "echo hello", 18080, 11080, nil)
+	if !strings.Contains(spec.SeatbeltProfile, "Library/Keychains") {
+		t.Error("profile should deny reading the keychains")
+	}
+}
+
+// On a terminal of its own, the command keeps it as its controlling terminal.
+func TestLinuxSpecTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	for _, terminal := range []bool{false, true} {
+		spec, err := LinuxSpec(config.Default(), "bash -i", nil, nil, LinuxSandboxOptions{Terminal: terminal})
+		if err != nil {
+			t.Fatalf("LinuxSpec() error = %v", err)
+		}
+		if got := slices.Contains(spec.BwrapArgs, "--new-session"); got == terminal {
+			t.Errorf("Terminal=%v: BwrapArgs contain --new-session = %v", terminal, got)
+		}
+	}
+}
+
+func TestLandlockRules(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+
+	workspace := t.TempDir()
+	cfg := &config.Config{
+		Filesystem: config.FilesystemConfig{AllowWrite: []string{workspace}},
+	}
+
+	rules := LandlockRules(cfg, "/work", nil)
+
+	tests := []struct {
+		path  string
+		write bool
+	}{
+		{"/usr", false},
+		{"/work", false},
+		{"/tmp", true},
+		{"/dev", true},
+		{workspace, true},
+	}
+	for _, tt := range tests {
+		if !slices.Contains(rules, LandlockRule{Path: tt.path, Write: tt.write}) {
+			t.Errorf("LandlockRules missing %s %s", LandlockRule{Path: tt.path, Write: tt.write}.Access(), tt.path)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Teardown deadlines. Each step of Manager.Shutdown gets its own deadline,
+// bounded by the caller's context.
+const (
+	// defaultShutdownTimeout bounds Cleanup.
+	defaultShutdownTimeout = 15 * time.Second
+	// processStepTimeout is the time a process gets to exit after SIGTERM
+	// before it is sent SIGKILL.
+	processStepTimeout = 3 * time.Second
+	// killWait is how long to wait for a process to exit after SIGKILL.
+	killWait = time.Second
+	// stepTimeout bounds the other teardown steps (proxies, monitors).
+	stepTimeout = 5 * time.Second
+	// moduleStepTimeout bounds removing SELinux modules, for which semodule
+	// rebuilds the whole policy.
+	moduleStepTimeout = 10 * time.Second
+)
+
+// terminate stops p with SIGTERM, escalating to SIGKILL if it has not exited
+// when ctx is done. wait must block until the process exits. It returns an
+// error only if the process is still running after SIGKILL.
+func terminate(ctx context.Context, p *os.Process, wait func() error) error {
+	exited := make(chan struct{})
+	go func() {
+		_ = wait()
+		close(exited)
+	}()
+
+	if err := p.Signal(syscall.SIGTERM); errors.Is(err, os.ErrProcessDone) {
+		<-exited
+		return nil
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+	}
+
+	_ = p.Kill()
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(killWait):
+		return fmt.Errorf("pid %d still running after SIGKILL", p.Pid)
+	}
+}
+
+// stopCmd terminates a helper process started by the sandbox, if it is running.
+func stopCmd(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	return terminate(ctx, cmd.Process, cmd.Wait)
+}
+
+// runStep runs fn, returning ctx's error if fn does not finish before ctx is
+// done. fn keeps running in the background in that case.
+func runStep(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tracked records the processes and monitors Shutdown must stop. It is
+// shared by a Manager and the Managers derived from it with WithConfig.
+type tracked struct {
+	mu        sync.Mutex
+	processes []*os.Process
+	monitors  []func()
+}
+
+func (t *tracked) addProcess(p *os.Process) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.processes = append(t.processes, p)
+}
+
+// addMonitor records stop and returns a version of it that is safe to call
+// more than once, so the caller and Shutdown can both stop the monitor.
+func (t *tracked) addMonitor(stop func()) func() {
+	stop = sync.OnceFunc(stop)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.monitors = append(t.monitors, stop)
+	return stop
+}
+
+// take returns the tracked processes and monitors and forgets them.
+func (t *tracked) take() (processes []*os.Process, monitors []func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	processes, monitors = t.processes, t.monitors
+	t.processes, t.monitors = nil, nil
+	return processes, monitors
+}
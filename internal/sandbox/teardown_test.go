@@ -0,0 +1,103 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func startProcess(t *testing.T, script string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start %q: %v", script, err)
+	}
+	t.Cleanup(func() { _ = cmd.Process.Kill() })
+	return cmd
+}
+
+func TestStopCmd(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"exits on SIGTERM", "exec sleep 30"},
+		{"escalates to SIGKILL", `trap "" TERM; exec sleep 30`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := startProcess(t, tt.script)
+			time.Sleep(50 * time.Millisecond) // Let the shell install its trap
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if err := stopCmd(ctx, cmd); err != nil {
+				t.Fatalf("stopCmd() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 200*time.Millisecond+killWait {
+				t.Errorf("stopCmd() took %s", elapsed)
+			}
+			if cmd.ProcessState == nil {
+				t.Error("process was not reaped")
+			}
+		})
+	}
+
+	if err := stopCmd(context.Background(), exec.Command("true")); err != nil {
+		t.Errorf("stopCmd() on an unstarted command: error = %v", err)
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	m := NewManager(nil, false, false)
+	cmd := startProcess(t, "exec sleep 30")
+	m.TrackProcess(cmd.Process)
+
+	stopped := 0
+	stop := m.tracked.addMonitor(func() { stopped++ })
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := cmd.Process.Signal(nil); err == nil {
+		t.Error("tracked process is still running")
+	}
+	if stopped != 1 {
+		t.Errorf("monitor stopped %d times, want 1", stopped)
+	}
+
+	// The caller's stop and a second Shutdown are no-ops
+	stop()
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown() error = %v", err)
+	}
+	if stopped != 1 {
+		t.Errorf("monitor stopped %d times, want 1", stopped)
+	}
+}
+
+func TestManagerShutdownReportsFailures(t *testing.T) {
+	m := NewManager(nil, false, false)
+	release := make(chan struct{})
+	defer close(release)
+	m.tracked.addMonitor(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := m.Shutdown(ctx)
+
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("Shutdown() error = %v, want *ShutdownError", err)
+	}
+	if len(shutdownErr.Failures) != 1 || shutdownErr.Failures[0].Step != "monitor" {
+		t.Errorf("Failures = %+v, want the monitor", shutdownErr.Failures)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("errors.Is(%v, context.DeadlineExceeded) = false", err)
+	}
+}
@@ -0,0 +1,235 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/platform"
+)
+
+// Some tools need something the sandbox blocks and then fail in ways that
+// do not point at fence: docker cannot reach its daemon, Chrome cannot start
+// its own sandbox, watchman runs out of inotify watches. WrapCommand
+// recognizes them and says what to change instead.
+
+// minWatchmanWatches is the inotify watch limit below which watchman is
+// likely to run out on a large tree, and recommendedWatches what to raise it
+// to, as watchman's documentation suggests.
+const (
+	minWatchmanWatches = 65536
+	recommendedWatches = 524288
+)
+
+// chromeBinaries are the names Chrome and Chromium are run as.
+var chromeBinaries = []string{
+	"chrome",
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"google-chrome-beta",
+	"google-chrome-unstable",
+}
+
+// ToolConflict is a tool in a command that needs something the sandbox
+// blocks, with the change that allows it.
+type ToolConflict struct {
+	Tool string // e.g. "docker"
+	Need string // What the tool needs, e.g. "the Docker daemon's socket"
+	Fix  string // The config change or flag that allows it
+}
+
+func (c ToolConflict) String() string {
+	return fmt.Sprintf("%s needs %s. %s", c.Tool, c.Need, c.Fix)
+}
+
+// toolHost is what conflict detection needs to know about the host.
+type toolHost struct {
+	platform platform.Type
+	home     string
+	getenv   func(string) string
+	// inotifyWatches is fs.inotify.max_user_watches, or 0 if unknown.
+	inotifyWatches int
+}
+
+// currentToolHost describes the host fence runs on.
+func currentToolHost() toolHost {
+	home, _ := os.UserHomeDir()
+	host := toolHost{platform: platform.Detect(), home: home, getenv: os.Getenv}
+	if data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches"); err == nil {
+		host.inotifyWatches, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	return host
+}
+
+// DetectToolConflicts returns the known tools in command that need
+// something cfg or the sandbox blocks on this platform. The command is not
+// rejected: the tool may still do what it is asked without it.
+func DetectToolConflicts(command string, cfg *config.Config) []ToolConflict {
+	return detectToolConflicts(command, cfg, currentToolHost())
+}
+
+func detectToolConflicts(command string, cfg *config.Config, host toolHost) []ToolConflict {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+
+	var conflicts []ToolConflict
+	seen := make(map[string]bool)
+	for _, subCmd := range parseShellCommand(command) {
+		args := commandArgs(subCmd)
+		if len(args) == 0 {
+			continue
+		}
+		for _, conflict := range toolConflicts(args, cfg, host) {
+			if !seen[conflict.String()] {
+				seen[conflict.String()] = true
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts
+}
+
+// commandArgs returns the words of a single command, without leading
+// environment assignments and with the program's directory stripped.
+func commandArgs(command string) []string {
+	tokens := tokenizeCommand(command)
+	for len(tokens) > 0 && isEnvAssignment(tokens[0]) {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	tokens[0] = filepath.Base(tokens[0])
+	return tokens
+}
+
+// isEnvAssignment reports whether word is NAME=value.
+func isEnvAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// toolConflicts returns the conflicts for the tool args runs.
+func toolConflicts(args []string, cfg *config.Config, host toolHost) []ToolConflict {
+	// Asking a tool for its version or usage needs none of it
+	if len(args) == 2 && slices.Contains([]string{"--version", "-v", "--help", "-h"}, args[1]) {
+		return nil
+	}
+
+	tool := args[0]
+	switch {
+	case tool == "docker" || tool == "docker-compose":
+		if c, ok := dockerConflict(tool, args, cfg, host); ok {
+			return []ToolConflict{c}
+		}
+	case slices.Contains(chromeBinaries, tool):
+		return chromeConflicts(tool, args, cfg, host)
+	case tool == "watchman":
+		if host.platform == platform.Linux && host.inotifyWatches > 0 && host.inotifyWatches < minWatchmanWatches {
+			return []ToolConflict{{
+				Tool: tool,
+				Need: fmt.Sprintf("more inotify watches than fs.inotify.max_user_watches (%d) allows on a large tree, and the limit cannot be raised inside the sandbox", host.inotifyWatches),
+				Fix:  fmt.Sprintf("Raise it on the host: sudo sysctl fs.inotify.max_user_watches=%d", recommendedWatches),
+			}}
+		}
+	}
+	return nil
+}
+
+// dockerConflict reports a docker command that cannot reach the daemon's
+// socket. On Linux the socket is not filtered; the macOS profile only
+// allows the sockets in network.allowUnixSockets.
+func dockerConflict(tool string, args []string, cfg *config.Config, host toolHost) (ToolConflict, bool) {
+	if host.platform != platform.MacOS || cfg.Network.AllowAllUnixSockets {
+		return ToolConflict{}, false
+	}
+
+	// DOCKER_HOST names the socket; otherwise the CLI uses Docker Desktop's,
+	// which /var/run/docker.sock links to
+	var sockets []string
+	if dockerHost := host.getenv("DOCKER_HOST"); dockerHost != "" {
+		socket, ok := strings.CutPrefix(dockerHost, "unix://")
+		if !ok {
+			// A TCP daemon goes through the proxy like any other host
+			return ToolConflict{}, false
+		}
+		sockets = []string{socket}
+	} else {
+		sockets = []string{filepath.Join(host.home, ".docker/run/docker.sock"), "/var/run/docker.sock"}
+	}
+	for _, socket := range sockets {
+		if unixSocketAllowed(socket, cfg.Network.AllowUnixSockets, host.home) {
+			return ToolConflict{}, false
+		}
+	}
+	return ToolConflict{
+		Tool: tool,
+		Need: fmt.Sprintf("the Docker daemon's socket %s", sockets[0]),
+		Fix:  fmt.Sprintf("Add %q to network.allowUnixSockets, which gives the command control of Docker and the containers it can start", sockets[0]),
+	}, true
+}
+
+// unixSocketAllowed reports whether the macOS profile generated from
+// allowed lets the sandbox connect to socket: an entry allows the socket
+// itself and everything under it. ~ in entries is home.
+func unixSocketAllowed(socket string, allowed []string, home string) bool {
+	for _, a := range allowed {
+		if rest, ok := strings.CutPrefix(a, "~/"); ok {
+			a = filepath.Join(home, rest)
+		}
+		a = NormalizePath(a)
+		if socket == a || strings.HasPrefix(socket, strings.TrimSuffix(a, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// chromeConflicts reports a Chrome that will fail to start its own sandbox,
+// which cannot be set up inside fence's (on Linux it needs user namespaces
+// or a setuid helper, on macOS a Seatbelt profile of its own), and on Linux
+// a Chrome with a window and no display.
+func chromeConflicts(tool string, args []string, cfg *config.Config, host toolHost) []ToolConflict {
+	if host.platform != platform.Linux && host.platform != platform.MacOS {
+		return nil
+	}
+	hasFlag := func(flag string) bool {
+		return slices.ContainsFunc(args[1:], func(a string) bool { return a == flag || strings.HasPrefix(a, flag+"=") })
+	}
+
+	var conflicts []ToolConflict
+	if !hasFlag("--no-sandbox") {
+		need := "its own sandbox, which cannot start inside fence's"
+		if host.platform == platform.Linux {
+			need = "user namespaces or a setuid helper for its own sandbox, which fence's sandbox does not provide"
+		}
+		conflicts = append(conflicts, ToolConflict{
+			Tool: tool,
+			Need: need,
+			Fix:  "Pass --no-sandbox; fence's sandbox confines it instead",
+		})
+	}
+	if host.platform == platform.Linux && !cfg.GUI.AllowDisplay && !hasFlag("--headless") {
+		conflicts = append(conflicts, ToolConflict{
+			Tool: tool,
+			Need: "a display for its window, which the sandbox hides",
+			Fix:  "Pass --headless, or set gui.allowDisplay",
+		})
+	}
+	return conflicts
+}
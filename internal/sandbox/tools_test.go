@@ -0,0 +1,87 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/platform"
+)
+
+func TestDetectToolConflicts(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+	macOS := toolHost{platform: platform.MacOS, home: "/Users/me", getenv: env(nil)}
+	linux := toolHost{platform: platform.Linux, home: "/home/me", getenv: env(nil), inotifyWatches: 8192}
+
+	withSockets := func(sockets ...string) *config.Config {
+		cfg := config.Default()
+		cfg.Network.AllowUnixSockets = sockets
+		return cfg
+	}
+	allSockets := config.Default()
+	allSockets.Network.AllowAllUnixSockets = true
+	display := config.Default()
+	display.GUI.AllowDisplay = true
+
+	tests := []struct {
+		name    string
+		command string
+		cfg     *config.Config
+		host    toolHost
+		want    []string // Substrings of each conflict, in order
+	}{
+		{"docker on macOS", "docker ps", nil, macOS, []string{`docker needs the Docker daemon's socket /Users/me/.docker/run/docker.sock. Add "/Users/me/.docker/run/docker.sock" to network.allowUnixSockets`}},
+		{"docker socket allowed", "docker ps", withSockets("/var/run/docker.sock"), macOS, nil},
+		{"docker socket directory allowed", "docker ps", withSockets("~/.docker/run"), macOS, nil},
+		{"all sockets allowed", "docker ps", allSockets, macOS, nil},
+		{"DOCKER_HOST socket", "docker compose up", withSockets("/var/run/docker.sock"),
+			toolHost{platform: platform.MacOS, getenv: env(map[string]string{"DOCKER_HOST": "unix:///Users/me/.colima/default/docker.sock"})},
+			[]string{`"/Users/me/.colima/default/docker.sock" to network.allowUnixSockets`}},
+		{"DOCKER_HOST over TCP", "docker ps", nil, toolHost{platform: platform.MacOS, getenv: env(map[string]string{"DOCKER_HOST": "tcp://build:2375"})}, nil},
+		{"docker version", "docker --version", nil, macOS, nil},
+		{"chrome version", "google-chrome --version", nil, linux, nil},
+		{"docker on Linux", "docker ps", nil, linux, nil},
+		{"docker in a chain", "cd app && FOO=1 /usr/local/bin/docker-compose up", nil, macOS, []string{"docker-compose needs"}},
+		{"docker in sh -c", `sh -c "make && docker build ."`, nil, macOS, []string{"docker needs"}},
+		{"chrome on Linux", "google-chrome https://example.com", nil, linux, []string{"Pass --no-sandbox", "Pass --headless, or set gui.allowDisplay"}},
+		{"headless chrome", "chromium --headless=new --no-sandbox --dump-dom https://example.com", nil, linux, nil},
+		{"chrome with display", "chromium --no-sandbox", display, linux, nil},
+		{"chrome on macOS", "chrome --headless", nil, macOS, []string{"chrome needs its own sandbox"}},
+		{"watchman with few watches", "watchman watch-project .", nil, linux, []string{"(8192) allows on a large tree, and the limit cannot be raised inside the sandbox. Raise it on the host: sudo sysctl fs.inotify.max_user_watches=524288"}},
+		{"watchman with enough watches", "watchman watch-project .", nil, toolHost{platform: platform.Linux, inotifyWatches: 1048576}, nil},
+		{"unrelated", "npm test", nil, macOS, nil},
+		{"tool as argument", "echo docker ps", nil, macOS, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectToolConflicts(tt.command, tt.cfg, tt.host)
+			if len(got) != len(tt.want) {
+				t.Fatalf("detectToolConflicts(%q) = %v, want %d conflict(s)", tt.command, got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i].String(), want) {
+					t.Errorf("conflict %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsEnvAssignment(t *testing.T) {
+	for word, want := range map[string]bool{
+		"FOO=1":     true,
+		"_x2=":      true,
+		"=value":    false,
+		"2FOO=1":    false,
+		"--flag=1":  false,
+		"docker":    false,
+		"A-B=value": false,
+	} {
+		if got := isEnvAssignment(word); got != want {
+			t.Errorf("isEnvAssignment(%q) = %v, want %v", word, got, want)
+		}
+	}
+}
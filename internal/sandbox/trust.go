@@ -0,0 +1,76 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// systemCABundles are the usual locations of the host's CA bundle: Debian,
+// Fedora, openSUSE, Alpine, and macOS (where Homebrew OpenSSL also uses it).
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// caEnvVars are the variables common TLS clients read their trusted CAs
+// from: OpenSSL, curl, Python requests and pip, Node.js, git, and Deno.
+var caEnvVars = []string{
+	"SSL_CERT_FILE",
+	"CURL_CA_BUNDLE",
+	"REQUESTS_CA_BUNDLE",
+	"PIP_CERT",
+	"NODE_EXTRA_CA_CERTS",
+	"GIT_SSL_CAINFO",
+	"DENO_CERT",
+}
+
+// writeTrustBundle writes the host's CA bundle followed by caPEM to a new
+// file in the temporary directory, so that the sandbox trusts the proxy's
+// session CA as well as the public ones, and returns its path. The bundle
+// is the host's SSL_CERT_FILE if set, or else the first of systemCABundles
+// that exists.
+func writeTrustBundle(caPEM []byte) (string, error) {
+	var bundle bytes.Buffer
+	for _, path := range append([]string{os.Getenv("SSL_CERT_FILE")}, systemCABundles...) {
+		if path == "" {
+			continue
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			bundle.Write(data)
+			bundle.WriteString("\n")
+			break
+		}
+	}
+	bundle.Write(caPEM)
+
+	f, err := os.CreateTemp("", "fence-ca-*.pem")
+	if err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	if _, err := f.Write(bundle.Bytes()); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// trustEnvVars returns the environment variables that point TLS clients at
+// the CA bundle at path, or nil if path is empty.
+func trustEnvVars(path string) []string {
+	if path == "" {
+		return nil
+	}
+	env := make([]string, 0, len(caEnvVars))
+	for _, name := range caEnvVars {
+		env = append(env, name+"="+path)
+	}
+	return env
+}
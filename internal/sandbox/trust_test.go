@@ -0,0 +1,43 @@
+package sandbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWriteTrustBundle(t *testing.T) {
+	host := filepath.Join(t.TempDir(), "host.pem")
+	if err := os.WriteFile(host, []byte("HOST ROOTS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", host)
+
+	path, err := writeTrustBundle([]byte("SESSION CA"))
+	if err != nil {
+		t.Fatalf("writeTrustBundle() error = %v", err)
+	}
+	defer func() { _ = os.Remove(path) }()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("HOST ROOTS")) || !bytes.HasSuffix(data, []byte("SESSION CA")) {
+		t.Errorf("bundle = %q, want the host's roots followed by the session CA", data)
+	}
+}
+
+func TestTrustEnvVars(t *testing.T) {
+	if env := trustEnvVars(""); env != nil {
+		t.Errorf("trustEnvVars(\"\") = %v, want nil", env)
+	}
+	env := trustEnvVars("/tmp/fence-ca.pem")
+	for _, want := range []string{"SSL_CERT_FILE=/tmp/fence-ca.pem", "NODE_EXTRA_CA_CERTS=/tmp/fence-ca.pem"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("trustEnvVars() = %v, missing %s", env, want)
+		}
+	}
+}
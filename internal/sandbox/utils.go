@@ -48,8 +48,24 @@ func NormalizePath(pathPattern string) string {
 	return normalized
 }
 
+// proxyAuthEnvVar holds the proxies' credentials, as "username:password",
+// in the environment of wrapped commands with network.proxyAuth. The wrapped
+// commands expand it into the proxy URLs (see proxyAuthRef), which keeps the
+// credentials off their command lines.
+const proxyAuthEnvVar = "FENCE_PROXY_AUTH"
+
+// proxyAuthRef is the shell expansion of proxyAuthEnvVar.
+const proxyAuthRef = "${" + proxyAuthEnvVar + "}"
+
 // GenerateProxyEnvVars creates environment variables for proxy configuration.
 func GenerateProxyEnvVars(httpPort, socksPort int) []string {
+	return proxyEnvVars(httpPort, socksPort, "")
+}
+
+// proxyEnvVars is GenerateProxyEnvVars with userinfo, if not empty, in the
+// proxy URLs. GIT_SSH_COMMAND is left out then, since nc cannot
+// authenticate to the SOCKS proxy.
+func proxyEnvVars(httpPort, socksPort int, userinfo string) []string {
 	envVars := []string{
 		"FENCE_SANDBOX=1",
 		"TMPDIR=/tmp/fence",
@@ -77,8 +93,12 @@ func GenerateProxyEnvVars(httpPort, socksPort int) []string {
 		"no_proxy="+noProxy,
 	)
 
+	if userinfo != "" {
+		userinfo += "@"
+	}
+
 	if httpPort > 0 {
-		proxyURL := "http://localhost:" + itoa(httpPort)
+		proxyURL := "http://" + userinfo + "localhost:" + itoa(httpPort)
 		envVars = append(envVars,
 			"HTTP_PROXY="+proxyURL,
 			"HTTPS_PROXY="+proxyURL,
@@ -88,17 +108,19 @@ func GenerateProxyEnvVars(httpPort, socksPort int) []string {
 	}
 
 	if socksPort > 0 {
-		socksURL := "socks5h://localhost:" + itoa(socksPort)
+		socksURL := "socks5h://" + userinfo + "localhost:" + itoa(socksPort)
 		envVars = append(envVars,
 			"ALL_PROXY="+socksURL,
 			"all_proxy="+socksURL,
 			"FTP_PROXY="+socksURL,
 			"ftp_proxy="+socksURL,
 		)
-		// Git SSH through SOCKS
-		envVars = append(envVars,
-			"GIT_SSH_COMMAND=ssh -o ProxyCommand='nc -X 5 -x localhost:"+itoa(socksPort)+" %h %p'",
-		)
+		if userinfo == "" {
+			// Git SSH through SOCKS
+			envVars = append(envVars,
+				"GIT_SSH_COMMAND=ssh -o ProxyCommand='nc -X 5 -x localhost:"+itoa(socksPort)+" %h %p'",
+			)
+		}
 	}
 
 	return envVars
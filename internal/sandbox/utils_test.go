@@ -3,6 +3,7 @@ package sandbox
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -223,6 +224,24 @@ func TestGenerateProxyEnvVars(t *testing.T) {
 	}
 }
 
+func TestProxyEnvVarsWithCredentials(t *testing.T) {
+	got := proxyEnvVars(8080, 1080, proxyAuthRef)
+	for _, want := range []string{
+		"HTTP_PROXY=http://${FENCE_PROXY_AUTH}@localhost:8080",
+		"ALL_PROXY=socks5h://${FENCE_PROXY_AUTH}@localhost:1080",
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("proxyEnvVars() = %q, missing %q", got, want)
+		}
+	}
+	for _, env := range got {
+		// nc cannot authenticate to the SOCKS proxy
+		if strings.HasPrefix(env, "GIT_SSH_COMMAND=") {
+			t.Errorf("proxyEnvVars() with credentials contains %q", env)
+		}
+	}
+}
+
 func TestEncodeSandboxedCommand(t *testing.T) {
 	tests := []struct {
 		name    string
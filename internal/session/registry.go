@@ -0,0 +1,155 @@
+// Package session implements named fence sessions: a background fence
+// process that initializes a sandbox's proxies and bridges once ("fence
+// session start") and wraps commands for other fence invocations ("fence
+// session exec"), so pipelines with many fenced steps pay for the setup once.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no session has the given name.
+var ErrNotFound = errors.New("session not found")
+
+// namePattern restricts names to what is safe in a file name.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Info is the registry record of a running session.
+type Info struct {
+	Name   string `json:"name"`
+	PID    int    `json:"pid"`
+	Socket string `json:"socket"`
+	// Dir is the directory the session was started in, which relative
+	// paths in its config (such as ".") are resolved against.
+	Dir     string    `json:"dir"`
+	Config  string    `json:"config,omitempty"` // The template or settings file, for display
+	Started time.Time `json:"started"`
+}
+
+// ValidateName returns an error if name cannot be used as a session name.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid session name %q: use up to 64 letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// Registry is the directory holding the record, socket and log of each
+// session.
+type Registry struct {
+	Dir string
+}
+
+// DefaultRegistry returns the registry in ~/.fence/sessions.
+func DefaultRegistry() (*Registry, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Registry{Dir: filepath.Join(home, ".fence", "sessions")}, nil
+}
+
+// SocketPath returns the path of the socket the session listens on.
+func (r *Registry) SocketPath(name string) string {
+	return filepath.Join(r.Dir, name+".sock")
+}
+
+// LogPath returns the path the output of a background session goes to.
+func (r *Registry) LogPath(name string) string {
+	return filepath.Join(r.Dir, name+".log")
+}
+
+func (r *Registry) recordPath(name string) string {
+	return filepath.Join(r.Dir, name+".json")
+}
+
+// Init creates the registry directory if needed and refuses to use it
+// unless it is a real directory accessible only to its owner.
+func (r *Registry) Init() error {
+	if err := os.MkdirAll(r.Dir, 0o700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(r.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("refusing to use %s: not a private directory", r.Dir)
+	}
+	return nil
+}
+
+// Register records a running session, replacing any record of the same name.
+func (r *Registry) Register(info *Info) error {
+	if err := r.Init(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so a reader never sees a partial record
+	tmp := r.recordPath(info.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.recordPath(info.Name))
+}
+
+// Lookup returns the record of the named session, or ErrNotFound.
+func (r *Registry) Lookup(name string) (*Info, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(r.recordPath(name)) //nolint:gosec // path is in the private registry directory
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid session record %s: %w", r.recordPath(name), err)
+	}
+	return &info, nil
+}
+
+// List returns the records of all registered sessions, sorted by name.
+// Records that cannot be read are skipped.
+func (r *Registry) List() ([]*Info, error) {
+	paths, err := filepath.Glob(filepath.Join(r.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []*Info
+	for _, path := range paths {
+		info, err := r.Lookup(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Remove deletes the record and socket of the named session. The log is
+// kept, for finding out why a session stopped.
+func (r *Registry) Remove(name string) error {
+	var errs []error
+	for _, path := range []string{r.recordPath(name), r.SocketPath(name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
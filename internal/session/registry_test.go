@@ -0,0 +1,81 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"build", "ci-42", "a.b_c", "X"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".hidden", "-flag", "a/b", "../x", "with space", string(make([]byte, 65))} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) should fail", name)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{Dir: filepath.Join(t.TempDir(), "sessions")}
+
+	if _, err := r.Lookup("build"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup() before Register error = %v, want ErrNotFound", err)
+	}
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"test", "build"} {
+		if err := r.Register(&Info{Name: name, PID: 42, Socket: r.SocketPath(name), Dir: "/src", Started: started}); err != nil {
+			t.Fatalf("Register(%q) error = %v", name, err)
+		}
+	}
+	info, err := os.Stat(r.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("registry mode = %v, want 0700", info.Mode().Perm())
+	}
+
+	got, err := r.Lookup("build")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.PID != 42 || got.Socket != filepath.Join(r.Dir, "build.sock") || !got.Started.Equal(started) {
+		t.Errorf("Lookup() = %+v", got)
+	}
+
+	infos, err := r.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(infos) != 2 || infos[0].Name != "build" || infos[1].Name != "test" {
+		t.Errorf("List() = %+v, want build and test", infos)
+	}
+
+	if err := r.Remove("build"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := r.Lookup("build"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() after Remove error = %v, want ErrNotFound", err)
+	}
+	if err := r.Remove("build"); err != nil {
+		t.Errorf("Remove() of a removed session error = %v", err)
+	}
+}
+
+func TestRegistryRejectsSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &Registry{Dir: dir}
+	if err := r.Register(&Info{Name: "build"}); err == nil {
+		t.Error("Register() in a directory others can read should fail")
+	}
+}
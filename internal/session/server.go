@@ -0,0 +1,241 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+)
+
+// Limits for the socket protocol.
+const (
+	maxRequestBytes = 1 << 20
+	requestTimeout  = 30 * time.Second
+	dialTimeout     = 5 * time.Second
+)
+
+// Sandbox is the part of sandbox.Manager a session needs.
+type Sandbox interface {
+	WrapCommand(ctx context.Context, command string) (string, error)
+	Violations() *policy.ViolationLog
+	Connections() *proxy.ConnTracker
+	Env(environ []string) []string
+}
+
+// Request operations.
+const (
+	OpPing = "ping"
+	OpWrap = "wrap"
+	OpStop = "stop"
+	OpTop  = "top"
+)
+
+// Request is what a client sends on a connection to the session socket.
+// Each connection carries one request and its response, as JSON.
+type Request struct {
+	Op      string `json:"op"` // One of the Op* constants
+	Command string `json:"command,omitempty"`
+	// Env is the client's environment, for OpWrap.
+	Env []string `json:"env,omitempty"`
+}
+
+// Response answers a Request.
+type Response struct {
+	// Command is the wrapped command, and Env the environment to run it
+	// with, for OpWrap.
+	Command string   `json:"command,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	// Commands is the number of commands the session has wrapped, and
+	// Report its violation report, for OpStop.
+	Commands int    `json:"commands,omitempty"`
+	Report   string `json:"report,omitempty"`
+	// Top is the session's live state, for OpTop.
+	Top   *Snapshot `json:"top,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Server answers requests for one session's sandbox.
+type Server struct {
+	sandbox  Sandbox
+	activity *activity
+
+	// Wrapping is serialized, as commands share the sandbox's state
+	mu       sync.Mutex
+	commands int
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewServer creates a server that wraps commands with sb.
+func NewServer(sb Sandbox) *Server {
+	return &Server{sandbox: sb, activity: newActivity(), stopped: make(chan struct{})}
+}
+
+// Serve answers requests on l until ctx is canceled or a client sends
+// OpStop, then closes l.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	defer s.sandbox.Violations().Subscribe(s.activity.record)()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.stopped:
+		}
+		_ = l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(&limitedConn{conn, maxRequestBytes}).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else {
+		resp = s.do(ctx, req)
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *Server) do(ctx context.Context, req Request) Response {
+	switch req.Op {
+	case OpPing:
+		return Response{}
+	case OpWrap:
+		if req.Command == "" {
+			return Response{Error: "invalid request: command is required"}
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		wrapped, err := s.sandbox.WrapCommand(ctx, req.Command)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		s.commands++
+		return Response{Command: wrapped, Env: s.sandbox.Env(req.Env)}
+	case OpStop:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var report bytes.Buffer
+		_ = s.sandbox.Violations().WriteReport(&report)
+		s.stopOnce.Do(func() { close(s.stopped) })
+		return Response{Commands: s.commands, Report: report.String()}
+	case OpTop:
+		s.mu.Lock()
+		commands := s.commands
+		s.mu.Unlock()
+		return Response{Top: s.activity.snapshot(s.sandbox, commands)}
+	}
+	return Response{Error: fmt.Sprintf("invalid request: unknown op %q", req.Op)}
+}
+
+// limitedConn bounds how much of a request is read.
+type limitedConn struct {
+	conn net.Conn
+	n    int64
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, errors.New("request too large")
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.conn.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+// call sends req to the session listening on socket and returns its
+// response. An error in the response is returned as an error.
+func call(socket string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socket, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("session is not running: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid response from session: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Ping returns nil if a session answers on socket.
+func Ping(socket string) error {
+	_, err := call(socket, Request{Op: OpPing})
+	return err
+}
+
+// Wrap asks the session listening on socket to wrap command, and returns
+// the command to run and the environment to run it with, which is env as
+// the session's config filters it. It returns an error if the command is
+// blocked by policy.
+func Wrap(socket, command string, env []string) (wrapped string, cmdEnv []string, err error) {
+	resp, err := call(socket, Request{Op: OpWrap, Command: command, Env: env})
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.Command, resp.Env, nil
+}
+
+// Stop asks the session listening on socket to shut down, and returns the
+// number of commands it wrapped and its violation report.
+func Stop(socket string) (commands int, report string, err error) {
+	resp, err := call(socket, Request{Op: OpStop})
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.Commands, resp.Report, nil
+}
+
+// Top returns the live state of the session listening on socket.
+func Top(socket string) (*Snapshot, error) {
+	resp, err := call(socket, Request{Op: OpTop})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Top == nil {
+		return nil, errors.New("invalid response from session: no snapshot")
+	}
+	return resp.Top, nil
+}
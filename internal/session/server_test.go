@@ -0,0 +1,159 @@
+package session
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+	"github.com/Use-Tusk/fence/internal/sandbox"
+)
+
+// fakeSandbox prefixes commands with "wrapped:", enforcing only a deny
+// prefix.
+type fakeSandbox struct {
+	deny       string
+	violations *policy.ViolationLog
+	conns      *proxy.ConnTracker
+}
+
+func (f *fakeSandbox) WrapCommand(_ context.Context, command string) (string, error) {
+	if strings.HasPrefix(command, f.deny) {
+		d := policy.Decision{Rule: policy.RuleRef("command.deny", f.deny), Reason: "matches a deny rule"}
+		f.violations.Record(policy.Event{Source: policy.SourceCommand, Kind: policy.KindCommand, Target: command, Decision: d})
+		return "", &sandbox.PolicyViolationError{Rule: d.Rule}
+	}
+	return "wrapped:" + command, nil
+}
+
+func (f *fakeSandbox) Violations() *policy.ViolationLog {
+	return f.violations
+}
+
+func (f *fakeSandbox) Connections() *proxy.ConnTracker {
+	return f.conns
+}
+
+func (f *fakeSandbox) Env(environ []string) []string {
+	return sandbox.FilterEnv(environ, &config.EnvConfig{Deny: []string{"SECRET"}})
+}
+
+// startServer serves a fake sandbox on a socket and returns the socket and
+// the channel Serve's result is sent on.
+func startServer(t *testing.T, ctx context.Context) (string, <-chan error) {
+	t.Helper()
+	return serveSandbox(t, ctx, &fakeSandbox{deny: "git push", violations: policy.NewViolationLog(false)})
+}
+
+// serveSandbox serves sb on a socket and returns the socket and the channel
+// Serve's result is sent on.
+func serveSandbox(t *testing.T, ctx context.Context, sb *fakeSandbox) (string, <-chan error) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "s.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- NewServer(sb).Serve(ctx, l) }()
+	return socket, done
+}
+
+func TestServer(t *testing.T) {
+	socket, done := startServer(t, context.Background())
+
+	if err := Ping(socket); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	wrapped, env, err := Wrap(socket, "make test", []string{"PATH=/usr/bin", "SECRET=hunter2"})
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	if wrapped != "wrapped:make test" {
+		t.Errorf("Wrap() = %q", wrapped)
+	}
+	if !slices.Equal(env, []string{"PATH=/usr/bin"}) {
+		t.Errorf("Wrap() env = %q, want it filtered by the session", env)
+	}
+	if _, _, err := Wrap(socket, "git push origin", nil); err == nil || !strings.Contains(err.Error(), "command.deny") {
+		t.Errorf("Wrap() of a denied command error = %v, want the rule", err)
+	}
+	if _, _, err := Wrap(socket, "", nil); err == nil {
+		t.Error("Wrap() of an empty command should fail")
+	}
+	if _, err := call(socket, Request{Op: "bogus"}); err == nil {
+		t.Error("unknown op should fail")
+	}
+
+	commands, report, err := Stop(socket)
+	if err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if commands != 1 {
+		t.Errorf("Stop() commands = %d, want 1", commands)
+	}
+	if !strings.Contains(report, "1 operation(s) blocked") || !strings.Contains(report, "git push origin") {
+		t.Errorf("Stop() report:\n%s", report)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+	if err := Ping(socket); err == nil {
+		t.Error("Ping() after Stop should fail")
+	}
+}
+
+func TestServerContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	socket, done := startServer(t, ctx)
+	if err := Ping(socket); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+}
+
+func TestServerTop(t *testing.T) {
+	sb := &fakeSandbox{deny: "git push", violations: policy.NewViolationLog(false)}
+	socket, _ := serveSandbox(t, context.Background(), sb)
+	if err := Ping(socket); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	sb.violations.Record(policy.Event{Kind: policy.KindNetwork, Target: "evil.com:443", Decision: policy.Deny("", "not allowed")})
+	sb.violations.Record(policy.Event{Kind: policy.KindNetwork, Target: "evil.com:443", Decision: policy.Deny("", "not allowed")})
+	sb.violations.Record(policy.Event{Kind: policy.KindFilesystem, Target: "/etc/shadow", Process: "cat", Decision: policy.Deny("", "sandbox denied file-read-data")})
+	if _, _, err := Wrap(socket, "make", nil); err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+
+	snap, err := Top(socket)
+	if err != nil {
+		t.Fatalf("Top() error = %v", err)
+	}
+	if snap.Commands != 1 {
+		t.Errorf("Commands = %d, want 1", snap.Commands)
+	}
+	if len(snap.Recent) != 3 || snap.Recent[0].Target != "/etc/shadow" {
+		t.Errorf("Recent = %+v, want 3 violations, newest first", snap.Recent)
+	}
+	if len(snap.Domains) != 1 || snap.Domains[0] != (DomainCount{Host: "evil.com", Blocked: 2}) {
+		t.Errorf("Domains = %+v, want evil.com blocked twice", snap.Domains)
+	}
+	if len(snap.Processes) != 1 || snap.Processes[0] != (ProcessCount{Process: "cat", Denials: 1}) {
+		t.Errorf("Processes = %+v, want one denial for cat", snap.Processes)
+	}
+}
+
+func TestPingNoSession(t *testing.T) {
+	if err := Ping(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("Ping() without a session should fail")
+	}
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
+)
+
+// Limits on what a Snapshot carries.
+const (
+	maxRecent    = 50
+	maxDomains   = 20
+	maxProcesses = 20
+)
+
+// Snapshot is the live state of a session, for fence top.
+type Snapshot struct {
+	Time     time.Time `json:"time"`
+	Commands int       `json:"commands"`
+	// Connections are the connections the proxies have open, oldest first.
+	Connections []proxy.Conn `json:"connections"`
+	// Recent are the latest violations, newest first.
+	Recent []policy.Event `json:"recent"`
+	// Domains are the hosts with the most connections, allowed or blocked.
+	Domains []DomainCount `json:"domains"`
+	// Processes are the processes with the most filesystem denials.
+	Processes []ProcessCount `json:"processes"`
+}
+
+// DomainCount is how often the session connected to a host, or was blocked
+// from it.
+type DomainCount struct {
+	Host    string `json:"host"`
+	Allowed int    `json:"allowed"`
+	Blocked int    `json:"blocked"`
+}
+
+// ProcessCount is how many filesystem denials a process had.
+type ProcessCount struct {
+	Process string `json:"process"`
+	Denials int    `json:"denials"`
+}
+
+// activity keeps what a Snapshot needs beyond the sandbox's own state: the
+// latest violations and the filesystem denials per process, which the
+// violation log does not keep.
+type activity struct {
+	mu        sync.Mutex
+	recent    []policy.Event // Oldest first
+	processes map[string]int
+}
+
+func newActivity() *activity {
+	return &activity{processes: make(map[string]int)}
+}
+
+// record notes a violation. It subscribes to the sandbox's violation log.
+func (a *activity) record(e policy.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.recent) >= maxRecent {
+		a.recent = a.recent[1:]
+	}
+	a.recent = append(a.recent, e)
+	if e.Kind == policy.KindFilesystem {
+		process := e.Process
+		if process == "" {
+			process = "unknown"
+		}
+		a.processes[process]++
+	}
+}
+
+// snapshot combines the recorded activity with the sandbox's connections
+// and violations.
+func (a *activity) snapshot(sb Sandbox, commands int) *Snapshot {
+	snap := &Snapshot{Time: time.Now(), Commands: commands, Connections: sb.Connections().Open()}
+
+	a.mu.Lock()
+	snap.Recent = slices.Clone(a.recent)
+	for process, n := range a.processes {
+		snap.Processes = append(snap.Processes, ProcessCount{Process: process, Denials: n})
+	}
+	a.mu.Unlock()
+	slices.Reverse(snap.Recent)
+	slices.SortFunc(snap.Processes, func(x, y ProcessCount) int {
+		if x.Denials != y.Denials {
+			return y.Denials - x.Denials
+		}
+		return strings.Compare(x.Process, y.Process)
+	})
+	snap.Processes = snap.Processes[:min(len(snap.Processes), maxProcesses)]
+
+	domains := make(map[string]*DomainCount)
+	count := func(host string) *DomainCount {
+		if d, ok := domains[host]; ok {
+			return d
+		}
+		d := &DomainCount{Host: host}
+		domains[host] = d
+		return d
+	}
+	for host, n := range sb.Connections().Opened() {
+		count(host).Allowed += n
+	}
+	for _, v := range sb.Violations().Violations() {
+		if v.Kind == policy.KindNetwork && !v.Decision.Warn {
+			count(policy.TargetHost(v.Target)).Blocked += v.Count
+		}
+	}
+	for _, d := range domains {
+		snap.Domains = append(snap.Domains, *d)
+	}
+	slices.SortFunc(snap.Domains, func(x, y DomainCount) int {
+		if tx, ty := x.Allowed+x.Blocked, y.Allowed+y.Blocked; tx != ty {
+			return ty - tx
+		}
+		return strings.Compare(x.Host, y.Host)
+	})
+	snap.Domains = snap.Domains[:min(len(snap.Domains), maxDomains)]
+	return snap
+}
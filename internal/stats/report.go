@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Entry is a counted domain, rule, or command.
+type Entry struct {
+	Name string `json:"name"`
+	Counter
+}
+
+// Report is what fence stats shows: the counters sorted by use, and the
+// rules in a config that have never matched.
+type Report struct {
+	Since    time.Time `json:"since"`
+	Runs     int       `json:"runs"`
+	Rules    []Entry   `json:"rules"`
+	Domains  []Entry   `json:"domains"`
+	Commands []Entry   `json:"commands"`
+	// Unused are the rules in the config that no counted connection or
+	// command matched, in config order.
+	Unused []string `json:"unused"`
+}
+
+// NewReport reports s, with at most top domains and commands (0 for all),
+// and the rules in cfg that never matched. cfg may be nil.
+func NewReport(s *Stats, cfg *config.Config, top int) *Report {
+	r := &Report{
+		Since:    s.Since,
+		Runs:     s.Runs,
+		Rules:    sortedEntries(s.Rules),
+		Domains:  sortedEntries(s.Domains),
+		Commands: sortedEntries(s.Commands),
+		Unused:   []string{},
+	}
+	if top > 0 {
+		r.Domains = r.Domains[:min(len(r.Domains), top)]
+		r.Commands = r.Commands[:min(len(r.Commands), top)]
+	}
+	for _, rule := range ConfigRules(cfg) {
+		if s.Rules[rule].Total() == 0 {
+			r.Unused = append(r.Unused, rule)
+		}
+	}
+	return r
+}
+
+// ConfigRules returns the rules in cfg that stats are kept for: the
+// allowed and denied domains and commands, in config order.
+func ConfigRules(cfg *config.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	var rules []string
+	for _, list := range []struct {
+		key      string
+		patterns []string
+	}{
+		{"network.allowedDomains", cfg.Network.AllowedDomains},
+		{"network.deniedDomains", cfg.Network.DeniedDomains},
+		{"network.deniedDomains", cfg.Network.WarnDomains},
+		{"command.allow", cfg.Command.Allow},
+		{"command.deny", cfg.Command.Deny},
+		{"command.deny", cfg.Command.Warn},
+	} {
+		for _, p := range list.patterns {
+			rules = append(rules, policy.RuleRef(list.key, p))
+		}
+	}
+	for _, r := range cfg.Command.DenyFlags {
+		rules = append(rules, policy.RuleRef("command.denyFlags", r.String()))
+	}
+	return rules
+}
+
+// sortedEntries returns the counters in m, most used first.
+func sortedEntries(m map[string]Counter) []Entry {
+	entries := make([]Entry, 0, len(m))
+	for name, c := range m {
+		entries = append(entries, Entry{Name: name, Counter: c})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if a.Total() != b.Total() {
+			return b.Total() - a.Total()
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries
+}
+
+// WriteReport writes a human-readable summary of the report.
+func (r *Report) WriteReport(w io.Writer) error {
+	if r.Runs == 0 {
+		_, err := fmt.Fprintln(w, "No runs counted yet")
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Counted %d run(s) since %s\n", r.Runs, r.Since.Local().Format("2006-01-02 15:04")); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, section := range []struct {
+		header, column string
+		entries        []Entry
+	}{
+		{"RULES", "RULE", r.Rules},
+		{"DOMAINS", "HOST", r.Domains},
+		{"COMMANDS", "COMMAND", r.Commands},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s\n  %s\tALLOWED\tDENIED\tLAST SEEN\n", section.header, section.column)
+		for _, e := range section.entries {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", e.Name, e.Allowed, e.Denied, e.LastSeen.Local().Format("2006-01-02"))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Unused) > 0 {
+		if _, err := fmt.Fprintf(w, "\n%d rule(s) in the config never matched; consider removing them:\n", len(r.Unused)); err != nil {
+			return err
+		}
+		for _, rule := range r.Unused {
+			if _, err := fmt.Fprintf(w, "  %s\n", rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as a JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,270 @@
+// Package stats keeps totals, across fence runs, of how often each domain,
+// config rule, and command was allowed or denied. "fence stats" shows them,
+// so allowlist entries that never match can be trimmed from the config.
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// Counter counts the decisions for one domain, rule, or command.
+type Counter struct {
+	Allowed  int       `json:"allowed"`
+	Denied   int       `json:"denied"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Total returns the number of decisions counted.
+func (c Counter) Total() int {
+	return c.Allowed + c.Denied
+}
+
+func (c Counter) add(o Counter) Counter {
+	c.Allowed += o.Allowed
+	c.Denied += o.Denied
+	if o.LastSeen.After(c.LastSeen) {
+		c.LastSeen = o.LastSeen
+	}
+	return c
+}
+
+// Stats are the counters. Rules are keyed as in policy.Decision.Rule, e.g.
+// `network.allowedDomains "github.com"`, and commands by CommandPrefix.
+type Stats struct {
+	Since    time.Time          `json:"since"` // When counting started
+	Runs     int                `json:"runs"`
+	Domains  map[string]Counter `json:"domains,omitempty"`
+	Rules    map[string]Counter `json:"rules,omitempty"`
+	Commands map[string]Counter `json:"commands,omitempty"`
+}
+
+// Merge adds the counters in o to s.
+func (s *Stats) Merge(o *Stats) {
+	if s.Since.IsZero() || (!o.Since.IsZero() && o.Since.Before(s.Since)) {
+		s.Since = o.Since
+	}
+	s.Runs += o.Runs
+	s.Domains = mergeCounters(s.Domains, o.Domains)
+	s.Rules = mergeCounters(s.Rules, o.Rules)
+	s.Commands = mergeCounters(s.Commands, o.Commands)
+}
+
+func mergeCounters(dst, src map[string]Counter) map[string]Counter {
+	if len(src) > 0 && dst == nil {
+		dst = make(map[string]Counter, len(src))
+	}
+	for k, c := range src {
+		dst[k] = dst[k].add(c)
+	}
+	return dst
+}
+
+// count adds a decision on key to m.
+func count(m map[string]Counter, key string, allowed bool, now time.Time) {
+	c := m[key]
+	if allowed {
+		c.Allowed++
+	} else {
+		c.Denied++
+	}
+	c.LastSeen = now
+	m[key] = c
+}
+
+// Recorder counts the decisions of a run. It is safe for concurrent use,
+// and a nil Recorder records nothing.
+type Recorder struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewRecorder returns a recorder for a run starting now.
+func NewRecorder() *Recorder {
+	return &Recorder{stats: Stats{
+		Since:    time.Now().UTC(),
+		Runs:     1,
+		Domains:  make(map[string]Counter),
+		Rules:    make(map[string]Counter),
+		Commands: make(map[string]Counter),
+	}}
+}
+
+// Domain counts a connection to host, and the rule that decided it if any.
+// d.Allowed is the outcome, which in audit mode is allowed even if the rule
+// denies.
+func (r *Recorder) Domain(host string, d policy.Decision) {
+	if r == nil {
+		return
+	}
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count(r.stats.Domains, strings.ToLower(host), d.Allowed, now)
+	if d.Rule != "" {
+		count(r.stats.Rules, d.Rule, d.Allowed, now)
+	}
+}
+
+// Command counts a command, by its prefix, and the rule that decided it if
+// any. command is a single command, not a pipeline or chain.
+func (r *Recorder) Command(command string, d policy.Decision) {
+	prefix := CommandPrefix(command)
+	if r == nil || prefix == "" {
+		return
+	}
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count(r.stats.Commands, prefix, d.Allowed, now)
+	if d.Rule != "" {
+		count(r.stats.Rules, d.Rule, d.Allowed, now)
+	}
+}
+
+// Stats returns a copy of the counters recorded so far.
+func (r *Recorder) Stats() *Stats {
+	if r == nil {
+		return &Stats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &Stats{}
+	s.Merge(&r.stats)
+	return s
+}
+
+var subcommandPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// CommandPrefix returns what stats count command by: the program's name,
+// followed by its second word if that looks like a subcommand (lowercase
+// letters, digits, and dashes). "git push origin main" is "git push", and
+// "ls -la" is "ls". Leading variable assignments are skipped.
+func CommandPrefix(command string) string {
+	fields := strings.Fields(command)
+	for len(fields) > 0 && strings.Contains(fields[0], "=") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	prefix := filepath.Base(fields[0])
+	if len(fields) > 1 && subcommandPattern.MatchString(fields[1]) {
+		prefix += " " + fields[1]
+	}
+	return prefix
+}
+
+// lockTimeout bounds how long Add waits for another fence to finish
+// updating the stats, and how old a lock must be to be considered left
+// behind by a crashed fence.
+const lockTimeout = 5 * time.Second
+
+// Store is the directory holding the totals.
+type Store struct {
+	Dir string
+}
+
+// DefaultStore returns the store in ~/.fence/stats.
+func DefaultStore() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return &Store{Dir: filepath.Join(home, ".fence", "stats")}, nil
+}
+
+func (s *Store) path() string {
+	return filepath.Join(s.Dir, "stats.json")
+}
+
+// Load returns the totals, which are empty if nothing has been counted.
+func (s *Store) Load() (*Stats, error) {
+	data, err := os.ReadFile(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return &Stats{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stats Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("invalid stats file %s: %w", s.path(), err)
+	}
+	return &stats, nil
+}
+
+// Add adds run's counters to the totals. Concurrent fence runs may add at
+// the same time.
+func (s *Store) Add(run *Stats) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stats, err := s.Load()
+	if err != nil {
+		return err
+	}
+	stats.Merge(run)
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file whole, so a crash cannot leave it half written
+	tmp := s.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	if err := os.Rename(tmp, s.path()); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return nil
+}
+
+// Reset deletes the totals.
+func (s *Store) Reset() error {
+	if err := os.Remove(s.path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// lock takes the store's lock file, removing it first if a crashed fence
+// left it behind.
+func (s *Store) lock() (unlock func(), err error) {
+	path := filepath.Join(s.Dir, "stats.lock")
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec // path is in our private stats directory
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock stats: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockTimeout {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock stats: %s is held by another fence", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
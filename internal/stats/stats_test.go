@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Use-Tusk/fence/internal/config"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	github := policy.Allow(policy.RuleRef("network.allowedDomains", "github.com"), "domain is allowlisted")
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Domain("GitHub.com", github)
+		}()
+	}
+	wg.Wait()
+	r.Domain("evil.com", policy.Deny("", "no allowedDomains entry matches"))
+	r.Command("git push origin main", policy.Deny(policy.RuleRef("command.deny", "git push"), "denied"))
+	r.Command("CI=1 /usr/bin/ls -la", policy.Allow("", "allowed by default"))
+
+	s := r.Stats()
+	if s.Runs != 1 || s.Since.IsZero() {
+		t.Errorf("Runs = %d, Since = %v, want 1 run with a start time", s.Runs, s.Since)
+	}
+	counts := func(m map[string]Counter) map[string][2]int {
+		got := make(map[string][2]int)
+		for k, c := range m {
+			got[k] = [2]int{c.Allowed, c.Denied}
+		}
+		return got
+	}
+	if got, want := counts(s.Domains), map[string][2]int{"github.com": {10, 0}, "evil.com": {0, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Domains = %v, want %v", got, want)
+	}
+	if got, want := counts(s.Rules), map[string][2]int{`network.allowedDomains "github.com"`: {10, 0}, `command.deny "git push"`: {0, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rules = %v, want %v", got, want)
+	}
+	if got, want := counts(s.Commands), map[string][2]int{"git push": {0, 1}, "ls": {1, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Commands = %v, want %v", got, want)
+	}
+
+	var none *Recorder
+	none.Domain("github.com", github)
+	if got := none.Stats(); got.Runs != 0 || got.Domains != nil {
+		t.Errorf("nil Recorder Stats() = %+v, want none", got)
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	tests := map[string]string{
+		"git push origin main": "git push",
+		"npm install":          "npm install",
+		"ls -la":               "ls",
+		"cat ./README.md":      "cat",
+		"FOO=1 make test":      "make test",
+		"/usr/bin/python3":     "python3",
+		"  ":                   "",
+	}
+	for command, want := range tests {
+		if got := CommandPrefix(command); got != want {
+			t.Errorf("CommandPrefix(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestStoreAdd(t *testing.T) {
+	s := &Store{Dir: filepath.Join(t.TempDir(), "stats")}
+	if got, err := s.Load(); err != nil || got.Runs != 0 {
+		t.Fatalf("Load() on an empty store = %+v, %v", got, err)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := NewRecorder()
+			r.Domain("github.com", policy.Allow("", ""))
+			if err := s.Add(r.Stats()); err != nil {
+				t.Errorf("Add() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.Runs != 5 || got.Domains["github.com"].Allowed != 5 {
+		t.Errorf("after 5 concurrent runs, Runs = %d and github.com allowed %d times, want 5", got.Runs, got.Domains["github.com"].Allowed)
+	}
+
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if got, _ := s.Load(); got.Runs != 0 {
+		t.Errorf("Runs after Reset() = %d, want 0", got.Runs)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := NewRecorder()
+	r.Domain("github.com", policy.Allow(policy.RuleRef("network.allowedDomains", "github.com"), ""))
+	r.Domain("registry.npmjs.org", policy.Allow(policy.RuleRef("network.allowedDomains", "*.npmjs.org"), ""))
+	r.Domain("github.com", policy.Allow(policy.RuleRef("network.allowedDomains", "github.com"), ""))
+
+	cfg := config.Default()
+	cfg.Network.AllowedDomains = []string{"github.com", "*.npmjs.org", "*.pypi.org"}
+	cfg.Command.Deny = []string{"git push"}
+	report := NewReport(r.Stats(), cfg, 1)
+
+	if len(report.Domains) != 1 || report.Domains[0].Name != "github.com" {
+		t.Errorf("Domains = %+v, want only the most used, github.com", report.Domains)
+	}
+	want := []string{`network.allowedDomains "*.pypi.org"`, `command.deny "git push"`}
+	if !reflect.DeepEqual(report.Unused, want) {
+		t.Errorf("Unused = %v, want %v", report.Unused, want)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "2 rule(s) in the config never matched") {
+		t.Errorf("report does not list the unused rules:\n%s", buf.String())
+	}
+}
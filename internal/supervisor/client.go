@@ -0,0 +1,338 @@
+// Package supervisor connects a fence run to a central supervisor, making
+// fence the enforcement node of a fleet of sandboxed agents. The client
+// streams the run's violations and heartbeats over a WebSocket, and the
+// supervisor can push network policy to the running sandbox or stop its
+// command.
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// DefaultHeartbeat is the interval between heartbeats if Options leaves it unset.
+const DefaultHeartbeat = 15 * time.Second
+
+// DefaultTokenEnv is the environment variable holding the bearer token if
+// supervisor.tokenEnv is unset.
+const DefaultTokenEnv = "FENCE_SUPERVISOR_TOKEN"
+
+// Reconnection backoff, and how many messages are kept while disconnected.
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+	maxQueued  = 1000
+)
+
+// Sandbox is the part of sandbox.Manager the client needs.
+type Sandbox interface {
+	Subscribe(fn func(policy.Event)) (unsubscribe func())
+	Stats() policy.RunStats
+	SetNetworkPolicy(allowedDomains, deniedDomains []string) error
+}
+
+// Message is one JSON text message in either direction. Which fields are
+// set depends on Type.
+type Message struct {
+	Type string    `json:"type"` // One of the Message* constants
+	Time time.Time `json:"time,omitzero"`
+	// ID identifies a supervisor message; its ack carries the same ID.
+	ID string `json:"id,omitempty"`
+
+	// Hello
+	Node    string `json:"node,omitempty"`
+	Session string `json:"session,omitempty"`
+	Command string `json:"command,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	Event    *policy.Event    `json:"event,omitempty"`
+	Stats    *policy.RunStats `json:"stats,omitempty"` // Heartbeat and exit
+	ExitCode *int             `json:"exitCode,omitempty"`
+	Network  *NetworkPolicy   `json:"network,omitempty"` // Policy
+	Reason   string           `json:"reason,omitempty"`  // Kill
+	Error    string           `json:"error,omitempty"`   // Ack
+}
+
+// NetworkPolicy replaces the sandbox's domain rules.
+type NetworkPolicy struct {
+	AllowedDomains []string `json:"allowedDomains"`
+	DeniedDomains  []string `json:"deniedDomains"`
+}
+
+// Message types. Fence sends hello first on every connection, then events,
+// heartbeats, and acks, and exit last. The supervisor sends policy and kill,
+// each answered with an ack.
+const (
+	MessageHello     = "hello"
+	MessageEvent     = "event"
+	MessageHeartbeat = "heartbeat"
+	MessageExit      = "exit"
+	MessageAck       = "ack"
+	MessagePolicy    = "policy"
+	MessageKill      = "kill"
+)
+
+// Options configure a Client.
+type Options struct {
+	URL   string
+	Token string // Sent as "Authorization: Bearer <token>" if set
+	// Node, Session, Command, and Version describe the run in the hello message.
+	Node      string
+	Session   string
+	Command   string
+	Version   string
+	Heartbeat time.Duration // 0 is DefaultHeartbeat
+	// OnKill is called when the supervisor asks to stop the command.
+	OnKill func(reason string)
+	Debug  bool
+}
+
+// Client streams a run to the supervisor, reconnecting as needed. Messages
+// produced while disconnected are queued, dropping the oldest beyond a
+// limit.
+type Client struct {
+	opts    Options
+	sandbox Sandbox
+
+	mu        sync.Mutex
+	queue     []Message
+	dropped   int
+	finishing bool
+	flushed   chan struct{} // Closed once the exit message is sent
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a client for the run sandboxed by sb.
+func New(sb Sandbox, opts Options) *Client {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+	if opts.Node == "" {
+		opts.Node, _ = os.Hostname()
+	}
+	return &Client{
+		opts:    opts,
+		sandbox: sb,
+		flushed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run connects to the supervisor and streams the run until ctx is done or
+// Finish is called, reconnecting with backoff when the connection fails.
+func (c *Client) Run(ctx context.Context) {
+	defer close(c.done)
+	unsubscribe := c.sandbox.Subscribe(func(e policy.Event) {
+		c.send(Message{Type: MessageEvent, Time: e.Time, Event: &e})
+	})
+	defer unsubscribe()
+
+	backoff := minBackoff
+	for {
+		ws, err := dial(ctx, c.opts.URL, c.opts.Token)
+		if err == nil {
+			backoff = minBackoff
+			err = c.serve(ctx, ws)
+			ws.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		default:
+		}
+		c.logDebug("Connection to %s failed, retrying in %s: %v", c.opts.URL, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Finish sends the exit message and stops the client, waiting up to
+// timeout for queued messages to reach the supervisor. Run must have been
+// started.
+func (c *Client) Finish(exitCode int, timeout time.Duration) {
+	stats := c.sandbox.Stats()
+	c.enqueue(Message{Type: MessageExit, Time: time.Now(), ExitCode: &exitCode, Stats: &stats}, true)
+
+	select {
+	case <-c.flushed:
+	case <-c.done:
+	case <-time.After(timeout):
+		c.logDebug("Timed out sending the exit message to the supervisor")
+	}
+	close(c.stop)
+	<-c.done
+}
+
+// send queues m for the supervisor. It never blocks, so it is safe to call
+// from violation subscribers.
+func (c *Client) send(m Message) {
+	c.enqueue(m, false)
+}
+
+// enqueue queues m, marking it as the last message if last is set.
+func (c *Client) enqueue(m Message, last bool) {
+	c.mu.Lock()
+	if len(c.queue) >= maxQueued {
+		c.queue = c.queue[1:]
+		c.dropped++
+	}
+	c.queue = append(c.queue, m)
+	c.finishing = c.finishing || last
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// serve streams over one connection until it fails or the client stops.
+func (c *Client) serve(ctx context.Context, ws *conn) error {
+	if err := c.write(ws, Message{
+		Type:    MessageHello,
+		Time:    time.Now(),
+		Node:    c.opts.Node,
+		Session: c.opts.Session,
+		Command: c.opts.Command,
+		PID:     os.Getpid(),
+		Version: c.opts.Version,
+	}); err != nil {
+		return err
+	}
+	c.logDebug("Connected to %s", c.opts.URL)
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.receive(ws) }()
+
+	heartbeat := time.NewTicker(c.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		if err := c.flush(ws); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.stop:
+			return nil
+		case err := <-errc:
+			return err
+		case <-heartbeat.C:
+			stats := c.sandbox.Stats()
+			c.send(Message{Type: MessageHeartbeat, Time: time.Now(), Stats: &stats})
+		case <-c.wake:
+		}
+	}
+}
+
+// flush writes the queued messages. A message whose write fails goes back
+// to the front of the queue, to be sent on the next connection.
+func (c *Client) flush(ws *conn) error {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			if c.finishing {
+				select {
+				case <-c.flushed:
+				default:
+					close(c.flushed)
+				}
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		m := c.queue[0]
+		c.queue = c.queue[1:]
+		dropped := c.dropped
+		c.dropped = 0
+		c.mu.Unlock()
+
+		if dropped > 0 {
+			c.logDebug("Dropped %d message(s) queued while disconnected", dropped)
+		}
+		if err := c.write(ws, m); err != nil {
+			c.mu.Lock()
+			c.queue = append([]Message{m}, c.queue...)
+			c.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (c *Client) write(ws *conn, m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(data)
+}
+
+// receive handles messages from the supervisor until the connection fails.
+func (c *Client) receive(ws *conn) error {
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			c.send(Message{Type: MessageAck, Error: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+		ack := Message{Type: MessageAck, ID: m.ID}
+		if err := c.handle(m); err != nil {
+			ack.Error = err.Error()
+		}
+		c.send(ack)
+	}
+}
+
+func (c *Client) handle(m Message) error {
+	switch m.Type {
+	case MessagePolicy:
+		if m.Network == nil {
+			return errors.New("policy message has no network section")
+		}
+		if err := c.sandbox.SetNetworkPolicy(m.Network.AllowedDomains, m.Network.DeniedDomains); err != nil {
+			return err
+		}
+		c.logDebug("Applied network policy from the supervisor: %d allowed, %d denied domain(s)",
+			len(m.Network.AllowedDomains), len(m.Network.DeniedDomains))
+		return nil
+	case MessageKill:
+		c.logDebug("Supervisor asked to stop the command: %s", m.Reason)
+		if c.opts.OnKill != nil {
+			c.opts.OnKill(m.Reason)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown message type %q", m.Type)
+	}
+}
+
+func (c *Client) logDebug(format string, args ...any) {
+	if c.opts.Debug {
+		logging.Debugf("supervisor", format, args...)
+	}
+}
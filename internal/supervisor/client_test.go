@@ -0,0 +1,328 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+const testToken = "secret"
+
+// fakeSandbox records network policy pushes and lets tests emit violations.
+type fakeSandbox struct {
+	mu      sync.Mutex
+	subs    []func(policy.Event)
+	allowed []string
+}
+
+func (f *fakeSandbox) Subscribe(fn func(policy.Event)) (unsubscribe func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs = append(f.subs, fn)
+	return func() {}
+}
+
+func (f *fakeSandbox) emit(e policy.Event) {
+	f.mu.Lock()
+	subs := slices.Clone(f.subs)
+	f.mu.Unlock()
+	for _, fn := range subs {
+		fn(e)
+	}
+}
+
+func (f *fakeSandbox) subscribed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs) > 0
+}
+
+func (f *fakeSandbox) Stats() policy.RunStats {
+	return policy.RunStats{Requests: 7, Blocked: 1}
+}
+
+func (f *fakeSandbox) SetNetworkPolicy(allowedDomains, _ []string) error {
+	if slices.Contains(allowedDomains, "") {
+		return errors.New("invalid domain")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allowed = allowedDomains
+	return nil
+}
+
+// serverConn is the supervisor end of a connection.
+type serverConn struct {
+	t  *testing.T
+	nc net.Conn
+	br *bufio.Reader
+}
+
+// fakeSupervisor accepts WebSocket connections carrying testToken and hands
+// them to the test.
+func fakeSupervisor(t *testing.T) (url string, conns <-chan *serverConn) {
+	t.Helper()
+	ch := make(chan *serverConn, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		nc, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		_, _ = io.WriteString(nc, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+acceptKey(r.Header.Get("Sec-WebSocket-Key"))+"\r\n\r\n")
+		ch <- &serverConn{t: t, nc: nc, br: brw.Reader}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/fence", ch
+}
+
+// accept waits for the client to connect.
+func accept(t *testing.T, conns <-chan *serverConn) *serverConn {
+	t.Helper()
+	select {
+	case sc := <-conns:
+		t.Cleanup(func() { sc.nc.Close() })
+		return sc
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// writeFrame sends an unmasked frame, as servers do.
+func (s *serverConn) writeFrame(fin bool, op byte, payload []byte) {
+	s.t.Helper()
+	b := byte(op)
+	if fin {
+		b |= 0x80
+	}
+	frame := []byte{b, byte(len(payload))}
+	if _, err := s.nc.Write(append(frame, payload...)); err != nil {
+		s.t.Fatalf("write frame: %v", err)
+	}
+}
+
+func (s *serverConn) send(m Message) {
+	s.t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.writeFrame(true, opText, data)
+}
+
+// readFrame reads and unmasks a client frame.
+func (s *serverConn) readFrame() (op byte, payload []byte) {
+	s.t.Helper()
+	_ = s.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(s.br, head[:]); err != nil {
+		s.t.Fatalf("read frame: %v", err)
+	}
+	if head[1]&0x80 == 0 {
+		s.t.Fatal("client frame is not masked")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(s.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(s.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(s.br, mask[:]); err != nil {
+		s.t.Fatalf("read frame: %v", err)
+	}
+	if _, err := io.ReadFull(s.br, payload); err != nil {
+		s.t.Fatalf("read frame: %v", err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload
+}
+
+// next returns the next message of type typ, skipping heartbeats.
+func (s *serverConn) next(typ string) Message {
+	s.t.Helper()
+	for {
+		op, payload := s.readFrame()
+		if op != opText {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(payload, &m); err != nil {
+			s.t.Fatalf("invalid message %s: %v", payload, err)
+		}
+		if m.Type == MessageHeartbeat && typ != MessageHeartbeat {
+			continue
+		}
+		if m.Type != typ {
+			s.t.Fatalf("got %s message %s, want %s", m.Type, payload, typ)
+		}
+		return m
+	}
+}
+
+func TestClient(t *testing.T) {
+	url, conns := fakeSupervisor(t)
+	sb := &fakeSandbox{}
+	killed := make(chan string, 1)
+	c := New(sb, Options{
+		URL:       url,
+		Token:     testToken,
+		Node:      "builder-1",
+		Session:   "abc123",
+		Command:   "npm test",
+		Heartbeat: 20 * time.Millisecond,
+		OnKill:    func(reason string) { killed <- reason },
+	})
+	go c.Run(context.Background())
+
+	sc := accept(t, conns)
+	hello := sc.next(MessageHello)
+	if hello.Node != "builder-1" || hello.Session != "abc123" || hello.Command != "npm test" || hello.PID == 0 {
+		t.Errorf("hello = %+v", hello)
+	}
+	if hb := sc.next(MessageHeartbeat); hb.Stats == nil || hb.Stats.Requests != 7 {
+		t.Errorf("heartbeat = %+v, want the sandbox's stats", hb)
+	}
+
+	sb.emit(policy.Event{Kind: policy.KindNetwork, Target: "evil.com:443", Decision: policy.Deny("", "not allowed")})
+	if e := sc.next(MessageEvent); e.Event == nil || e.Event.Target != "evil.com:443" {
+		t.Errorf("event = %+v", e)
+	}
+
+	sc.send(Message{Type: MessagePolicy, ID: "p1", Network: &NetworkPolicy{AllowedDomains: []string{"github.com"}}})
+	if ack := sc.next(MessageAck); ack.ID != "p1" || ack.Error != "" {
+		t.Errorf("policy ack = %+v, want success", ack)
+	}
+	sb.mu.Lock()
+	allowed := sb.allowed
+	sb.mu.Unlock()
+	if !slices.Equal(allowed, []string{"github.com"}) {
+		t.Errorf("allowed domains = %q, want the pushed policy", allowed)
+	}
+
+	sc.send(Message{Type: MessagePolicy, ID: "p2", Network: &NetworkPolicy{AllowedDomains: []string{""}}})
+	if ack := sc.next(MessageAck); ack.ID != "p2" || ack.Error == "" {
+		t.Errorf("policy ack = %+v, want the sandbox's error", ack)
+	}
+	sc.send(Message{Type: "reboot", ID: "r1"})
+	if ack := sc.next(MessageAck); !strings.Contains(ack.Error, "unknown message type") {
+		t.Errorf("ack = %+v, want an unknown type error", ack)
+	}
+
+	sc.send(Message{Type: MessageKill, ID: "k1", Reason: "budget exceeded"})
+	select {
+	case reason := <-killed:
+		if reason != "budget exceeded" {
+			t.Errorf("OnKill reason = %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnKill was not called")
+	}
+	sc.next(MessageAck)
+
+	go c.Finish(3, 5*time.Second)
+	if exit := sc.next(MessageExit); exit.ExitCode == nil || *exit.ExitCode != 3 || exit.Stats == nil {
+		t.Errorf("exit = %+v", exit)
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	url, conns := fakeSupervisor(t)
+	sb := &fakeSandbox{}
+	c := New(sb, Options{URL: url, Token: testToken, Heartbeat: time.Hour})
+	go c.Run(context.Background())
+
+	first := accept(t, conns)
+	first.next(MessageHello)
+	for !sb.subscribed() {
+		time.Sleep(time.Millisecond)
+	}
+	first.writeFrame(true, opClose, []byte{0x03, 0xE8})
+	if op, _ := first.readFrame(); op != opClose {
+		t.Errorf("client answered a close with opcode %#x, want close", op)
+	}
+	first.nc.Close()
+
+	// Events while disconnected are delivered on the next connection
+	sb.emit(policy.Event{Kind: policy.KindCommand, Target: "git push"})
+	second := accept(t, conns)
+	second.next(MessageHello)
+	if e := second.next(MessageEvent); e.Event == nil || e.Event.Target != "git push" {
+		t.Errorf("event = %+v, want the one queued while disconnected", e)
+	}
+
+	go c.Finish(0, 5*time.Second)
+	second.next(MessageExit)
+}
+
+func TestConnReadMessage(t *testing.T) {
+	url, conns := fakeSupervisor(t)
+	done := make(chan struct{})
+	var got []byte
+	var readErr error
+	go func() {
+		defer close(done)
+		ws, err := dial(context.Background(), url, testToken)
+		if err != nil {
+			readErr = err
+			return
+		}
+		defer ws.Close()
+		got, readErr = ws.ReadMessage()
+	}()
+
+	sc := accept(t, conns)
+	sc.writeFrame(false, opText, []byte(`{"type":`))
+	sc.writeFrame(true, opPing, []byte("hi"))
+	if op, payload := sc.readFrame(); op != opPong || string(payload) != "hi" {
+		t.Errorf("ping answered with opcode %#x %q, want pong \"hi\"", op, payload)
+	}
+	sc.writeFrame(true, opContinuation, []byte(`"kill"}`))
+	<-done
+	if readErr != nil || string(got) != `{"type":"kill"}` {
+		t.Errorf("ReadMessage() = %q, %v, want the reassembled message", got, readErr)
+	}
+}
+
+func TestDialRejectsBadToken(t *testing.T) {
+	url, _ := fakeSupervisor(t)
+	_, err := dial(context.Background(), url, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("dial() error = %v, want the supervisor's 401", err)
+	}
+}
+
+func TestDialRequiresTLSToRemoteHosts(t *testing.T) {
+	for _, url := range []string{"ws://fleet.example.com/fence", "ws://10.0.0.5:9000/fence", "http://fleet.example.com/fence"} {
+		if _, err := dial(context.Background(), url, testToken); err == nil {
+			t.Errorf("dial(%q) succeeded, want it refused without TLS", url)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // Required by the WebSocket handshake, not used for security
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Use-Tusk/fence/internal/config"
+)
+
+// maxMessageBytes bounds a message from the supervisor.
+const maxMessageBytes = 1 << 20
+
+// websocketGUID is appended to the handshake key (RFC 6455 section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// errClosed is returned by ReadMessage once the supervisor closes the connection.
+var errClosed = errors.New("connection closed by supervisor")
+
+// conn is the client end of a WebSocket connection. It only speaks what the
+// supervisor protocol needs: unfragmented text messages out, text messages
+// in, and control frames. Reads must come from one goroutine; writes may
+// come from any.
+type conn struct {
+	nc  net.Conn
+	br  *bufio.Reader
+	wmu sync.Mutex
+}
+
+// dial opens a WebSocket connection to rawURL (wss://, or ws:// to a loopback
+// host), sending token as a bearer token if it is set.
+func dial(ctx context.Context, rawURL, token string) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "ws" && !config.IsLoopbackHost(u.Hostname()):
+		return nil, fmt.Errorf("refusing ws:// to %s: use wss:// for a supervisor that is not on this host", u.Hostname())
+	case u.Scheme != "ws" && u.Scheme != "wss":
+		return nil, fmt.Errorf("unsupported scheme %q: must be wss:// or ws://", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tc := tls.Client(nc, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	// Abort the handshake if ctx ends while it is in progress
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+
+	c := &conn{nc: nc, br: bufio.NewReader(nc)}
+	if err := c.handshake(u, token); err != nil {
+		nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) handshake(u *url.URL, token string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.EscapedPath(), RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if err := req.Write(c.nc); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(c.br, req)
+	if err != nil {
+		return fmt.Errorf("invalid handshake response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("supervisor refused the connection: %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return errors.New("invalid handshake response: not a WebSocket upgrade")
+	}
+	return nil
+}
+
+// acceptKey is the Sec-WebSocket-Accept value the server must answer key with.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID)) //nolint:gosec // See import
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WriteMessage sends data as a text message.
+func (c *conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// writeFrame sends a single, final, masked frame, as clients must.
+func (c *conn) writeFrame(op byte, payload []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n)) //nolint:gosec // n is in range
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n)) //nolint:gosec // n is not negative
+	}
+	header[1] |= 0x80
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.nc.Write(append(header, masked...))
+	return err
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// reassembling fragments on the way. It returns errClosed once the
+// supervisor closes the connection.
+func (c *conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	inMessage := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Echo the status code back, as the protocol asks
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(opClose, payload)
+			return nil, errClosed
+		case opText, opBinary:
+			if inMessage {
+				return nil, errors.New("protocol error: new message before the last one ended")
+			}
+			inMessage = true
+		case opContinuation:
+			if !inMessage {
+				return nil, errors.New("protocol error: continuation without a message")
+			}
+		default:
+			return nil, fmt.Errorf("protocol error: unknown opcode %#x", op)
+		}
+
+		if len(msg)+len(payload) > maxMessageBytes {
+			return nil, fmt.Errorf("message from supervisor exceeds %d bytes", maxMessageBytes)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0F
+	if head[1]&0x80 != 0 {
+		return false, 0, nil, errors.New("protocol error: masked frame from server")
+	}
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessageBytes {
+		return false, 0, nil, fmt.Errorf("message from supervisor exceeds %d bytes", maxMessageBytes)
+	}
+	if op >= opClose && (!fin || n > 125) {
+		return false, 0, nil, errors.New("protocol error: invalid control frame")
+	}
+
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	return fin, op, payload, nil
+}
+
+// Close sends a normal closure and closes the connection without waiting
+// for the supervisor's reply.
+func (c *conn) Close() error {
+	_ = c.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000: normal closure
+	return c.nc.Close()
+}
@@ -0,0 +1,420 @@
+// Package telemetry exports a fence run to an OpenTelemetry collector as a
+// trace: a span for the run, and a child span for each connection the
+// proxies decide on and each violation. Spans are sent with OTLP over HTTP in
+// its JSON encoding, so no collector-specific client is needed.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// DefaultInterval is the time between exports if Options leaves it unset.
+const DefaultInterval = 5 * time.Second
+
+// DefaultHeadersEnv is the environment variable holding the headers to send
+// if telemetry.headersEnv is unset, as in the OpenTelemetry SDKs.
+const DefaultHeadersEnv = "OTEL_EXPORTER_OTLP_HEADERS"
+
+// Export limits: spans per request, spans kept while the collector is
+// unreachable, and how long one request may take.
+const (
+	maxBatch      = 512
+	maxQueued     = 4096
+	exportTimeout = 10 * time.Second
+)
+
+// OTLP span kinds and status codes.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusCodeOK     = 1
+	statusCodeError  = 2
+)
+
+// Options configure an Exporter.
+type Options struct {
+	Endpoint    string            // OTLP/HTTP base URL; spans are posted to its /v1/traces
+	Headers     map[string]string // Sent with every request, e.g. for authentication
+	ServiceName string            // "" is "fence"
+	// Session, Command, and Version describe the run in every span's resource.
+	Session string
+	Command string
+	Version string
+	// TraceParent is a W3C traceparent, such as the caller's TRACEPARENT
+	// environment variable, that the run's span becomes a child of.
+	TraceParent string
+	Interval    time.Duration // 0 is DefaultInterval
+	Debug       bool
+}
+
+// Exporter collects the spans of a run and sends them to the collector in
+// batches. Spans produced while the collector is unreachable are queued,
+// dropping the oldest beyond a limit. A nil Exporter records nothing.
+type Exporter struct {
+	opts     Options
+	client   *http.Client
+	traceID  string
+	runID    string
+	parentID string // Span the run's span is a child of, if any
+	start    time.Time
+
+	mu       sync.Mutex
+	queue    []span
+	dropped  int
+	finished bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates an exporter for a run starting now.
+func New(opts Options) *Exporter {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "fence"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	e := &Exporter{
+		opts:   opts,
+		client: &http.Client{},
+		runID:  randomHex(8),
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if traceID, parentID, ok := parseTraceParent(opts.TraceParent); ok {
+		e.traceID, e.parentID = traceID, parentID
+	} else {
+		e.traceID = randomHex(16)
+	}
+	return e
+}
+
+// TraceParent returns the W3C traceparent of the run's span, for the
+// sandboxed command to continue the trace.
+func (e *Exporter) TraceParent() string {
+	return "00-" + e.traceID + "-" + e.runID + "-01"
+}
+
+// Connection records the proxies' decision on a connection or request to
+// host:port.
+func (e *Exporter) Connection(host string, port int, allowed bool) {
+	if e == nil {
+		return
+	}
+	now := time.Now()
+	s := e.child("fence.connect", spanKindClient, now, now,
+		stringAttr("server.address", host),
+		intAttr("server.port", port),
+		boolAttr("fence.allowed", allowed))
+	if !allowed {
+		s.Status = &status{Code: statusCodeError, Message: "blocked by network policy"}
+	}
+	e.enqueue(s)
+}
+
+// Violation records a violation. Its signature matches the subscribers of
+// policy.ViolationLog.
+func (e *Exporter) Violation(ev policy.Event) {
+	if e == nil {
+		return
+	}
+	s := e.child("fence.violation", spanKindInternal, ev.Time, ev.Time,
+		stringAttr("fence.kind", ev.Kind),
+		stringAttr("fence.source", ev.Source),
+		stringAttr("fence.target", ev.Target),
+		stringAttr("fence.rule", ev.Decision.Rule),
+		stringAttr("fence.reason", ev.Decision.Reason),
+		boolAttr("fence.audit", ev.Audit))
+	s.Status = &status{Code: statusCodeError, Message: ev.Decision.Reason}
+	e.enqueue(s)
+}
+
+// Run sends queued spans every interval until ctx is done or Finish is
+// called.
+func (e *Exporter) Run(ctx context.Context) {
+	defer close(e.done)
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if err := e.flush(ctx); err != nil {
+				e.logDebug("Export to %s failed: %v", e.opts.Endpoint, err)
+			}
+		}
+	}
+}
+
+// Finish ends the run's span with exitCode and stops the exporter, waiting
+// up to timeout for the queued spans to reach the collector. Run must have
+// been started.
+func (e *Exporter) Finish(exitCode int, timeout time.Duration) {
+	run := span{
+		TraceID:           e.traceID,
+		SpanID:            e.runID,
+		ParentSpanID:      e.parentID,
+		Name:              "fence.run",
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: unixNano(e.start),
+		EndTimeUnixNano:   unixNano(time.Now()),
+		Attributes:        []keyValue{intAttr("process.exit.code", exitCode)},
+		Status:            &status{Code: statusCodeOK},
+	}
+	if exitCode != 0 {
+		run.Status = &status{Code: statusCodeError, Message: fmt.Sprintf("exit status %d", exitCode)}
+	}
+	e.enqueue(run)
+	e.mu.Lock()
+	e.finished = true
+	e.mu.Unlock()
+
+	close(e.stop)
+	<-e.done
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for e.pending() > 0 {
+		if err := e.flush(ctx); err != nil {
+			e.logDebug("Export to %s failed, dropping %d spans: %v", e.opts.Endpoint, e.pending(), err)
+			return
+		}
+	}
+}
+
+func (e *Exporter) child(name string, kind int, start, end time.Time, attrs ...keyValue) span {
+	return span{
+		TraceID:           e.traceID,
+		SpanID:            randomHex(8),
+		ParentSpanID:      e.runID,
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        attrs,
+	}
+}
+
+// enqueue queues s for export. It never blocks, so it is safe to call from
+// violation subscribers and the proxies' filters.
+func (e *Exporter) enqueue(s span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.finished {
+		return
+	}
+	if len(e.queue) >= maxQueued {
+		e.queue = e.queue[1:]
+		e.dropped++
+	}
+	e.queue = append(e.queue, s)
+}
+
+func (e *Exporter) pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.queue)
+}
+
+// flush sends the queued spans, a batch at a time. Spans that could not be
+// sent stay queued for the next attempt.
+func (e *Exporter) flush(ctx context.Context) error {
+	for {
+		e.mu.Lock()
+		batch := e.queue[:min(len(e.queue), maxBatch)]
+		e.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.export(ctx, batch); err != nil {
+			return err
+		}
+		e.mu.Lock()
+		e.queue = e.queue[len(batch):]
+		e.mu.Unlock()
+	}
+}
+
+// export posts spans to the collector as one OTLP ExportTraceServiceRequest.
+func (e *Exporter) export(ctx context.Context, spans []span) error {
+	resourceAttrs := []keyValue{
+		stringAttr("service.name", e.opts.ServiceName),
+		stringAttr("service.version", e.opts.Version),
+		stringAttr("fence.session.id", e.opts.Session),
+		stringAttr("process.command_line", e.opts.Command),
+	}
+	body, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: resourceAttrs},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "fence", Version: e.opts.Version}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.opts.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *Exporter) logDebug(format string, args ...interface{}) {
+	if e.opts.Debug {
+		logging.Debugf("telemetry", format, args...)
+	}
+}
+
+// ParseHeaders parses headers in the OTEL_EXPORTER_OTLP_HEADERS format,
+// "key1=value1,key2=value2" with URL-encoded values. Malformed entries are
+// skipped.
+func ParseHeaders(s string) map[string]string {
+	headers := make(map[string]string)
+	for entry := range strings.SplitSeq(s, ",") {
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			headers[key] = decoded
+		}
+	}
+	return headers
+}
+
+// parseTraceParent returns the trace and parent span IDs of a W3C
+// traceparent (version 00), such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceParent(s string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	for _, id := range parts[1:3] {
+		b, err := hex.DecodeString(id)
+		if err != nil || strings.ToLower(id) != id || isZero(b) {
+			return "", "", false
+		}
+	}
+	return parts[1], parts[2], true
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// The OTLP JSON encoding of a trace export request, with only the fields
+// fence sets. Trace and span IDs are hex strings and 64-bit integers are
+// decimal strings, as the encoding requires.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int) keyValue {
+	s := strconv.Itoa(value)
+	return keyValue{Key: key, Value: anyValue{IntValue: &s}}
+}
+
+func boolAttr(key string, value bool) keyValue {
+	return keyValue{Key: key, Value: anyValue{BoolValue: &value}}
+}
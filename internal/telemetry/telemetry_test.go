@@ -0,0 +1,196 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// collector is a fake OTLP/HTTP collector that keeps the spans it receives.
+type collector struct {
+	mu      sync.Mutex
+	spans   []span
+	headers http.Header
+	fail    bool
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if c.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.headers = r.Header.Clone()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func (c *collector) received() []span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]span(nil), c.spans...)
+}
+
+func attr(s span, key string) string {
+	for _, kv := range s.Attributes {
+		if kv.Key != key {
+			continue
+		}
+		switch {
+		case kv.Value.StringValue != nil:
+			return *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			return *kv.Value.IntValue
+		case kv.Value.BoolValue != nil && *kv.Value.BoolValue:
+			return "true"
+		default:
+			return "false"
+		}
+	}
+	return ""
+}
+
+func TestExporterSpans(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	exp := New(Options{
+		Endpoint:    srv.URL + "/",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		Session:     "abc123",
+		Command:     "npm install",
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Interval:    time.Hour,
+	})
+	go exp.Run(context.Background())
+	exp.Connection("registry.npmjs.org", 443, true)
+	exp.Connection("evil.com", 443, false)
+	exp.Violation(policy.Event{
+		Time:     time.Now(),
+		Source:   policy.SourceCommand,
+		Kind:     policy.KindCommand,
+		Target:   "git push",
+		Decision: policy.Deny("command.deny", "denied by command.deny"),
+	})
+	exp.Finish(1, 5*time.Second)
+
+	spans := c.received()
+	if len(spans) != 4 {
+		t.Fatalf("received %d spans, want 4", len(spans))
+	}
+	if got := c.headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+	run := spans[3]
+	if run.Name != "fence.run" || run.ParentSpanID != "00f067aa0ba902b7" || run.Status.Code != statusCodeError {
+		t.Errorf("run span = %+v, want a failed fence.run child of the traceparent", run)
+	}
+	for _, s := range spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s has trace ID %s, want the traceparent's", s.Name, s.TraceID)
+		}
+		if s.Name != "fence.run" && s.ParentSpanID != run.SpanID {
+			t.Errorf("span %s is not a child of the run span", s.Name)
+		}
+	}
+	if attr(spans[0], "server.address") != "registry.npmjs.org" || attr(spans[0], "fence.allowed") != "true" || spans[0].Status != nil {
+		t.Errorf("allowed connection span = %+v", spans[0])
+	}
+	if attr(spans[1], "fence.allowed") != "false" || spans[1].Status == nil || spans[1].Status.Code != statusCodeError {
+		t.Errorf("blocked connection span = %+v, want an error status", spans[1])
+	}
+	if attr(spans[2], "fence.kind") != policy.KindCommand || attr(spans[2], "fence.rule") != "command.deny" {
+		t.Errorf("violation span = %+v", spans[2])
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + run.SpanID + "-01"; exp.TraceParent() != want {
+		t.Errorf("TraceParent() = %q, want %q", exp.TraceParent(), want)
+	}
+}
+
+func TestExporterKeepsSpansWhileCollectorFails(t *testing.T) {
+	c := &collector{fail: true}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	exp := New(Options{Endpoint: srv.URL})
+	exp.Connection("example.com", 443, true)
+	if err := exp.flush(context.Background()); err == nil {
+		t.Fatal("flush() succeeded against a failing collector")
+	}
+	if exp.pending() != 1 {
+		t.Fatalf("pending() = %d after a failed export, want 1", exp.pending())
+	}
+
+	c.mu.Lock()
+	c.fail = false
+	c.mu.Unlock()
+	if err := exp.flush(context.Background()); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	if exp.pending() != 0 || len(c.received()) != 1 {
+		t.Errorf("pending() = %d, received %d, want the queued span delivered", exp.pending(), len(c.received()))
+	}
+}
+
+func TestNilExporter(t *testing.T) {
+	var exp *Exporter
+	exp.Connection("example.com", 443, true)
+	exp.Violation(policy.Event{Kind: policy.KindFilesystem, Target: "/etc/shadow"})
+}
+
+func TestParseHeaders(t *testing.T) {
+	got := ParseHeaders("api-key=abc%20123, x-team = agents,malformed,=empty")
+	if len(got) != 2 || got["api-key"] != "abc 123" || got["x-team"] != "agents" {
+		t.Errorf("ParseHeaders() = %v", got)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", false},
+	}
+	for _, tt := range tests {
+		if _, _, ok := parseTraceParent(tt.in); ok != tt.ok {
+			t.Errorf("parseTraceParent(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+		}
+	}
+}
+
+func TestSpansUseOTLPJSONEncoding(t *testing.T) {
+	data, err := json.Marshal(intAttr("server.port", 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"intValue":"443"`) {
+		t.Errorf("int attribute = %s, want a decimal string", data)
+	}
+}
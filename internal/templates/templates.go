@@ -41,6 +41,8 @@ var templateDescriptions = map[string]string{
 	"pip-install":       "Allow PyPI; allow writes to workspace/tmp",
 	"local-dev-server":  "Allow binding and localhost outbound; allow writes to workspace/tmp",
 	"git-readonly":      "Blocks destructive commands like git push, rm -rf, etc.",
+	"nix":               "Allow Nix binary caches, flake inputs, and the Nix daemon; allow writes to workspace/tmp/Nix user state",
+	"bazel":             "Allow Bazel registries, mirrors, and toolchains; allow writes to workspace/tmp/output bases (see fence import --bazel)",
 	"code":              "Production-ready config for AI coding agents (Claude Code, Codex, Copilot, etc.)",
 	"code-relaxed":      "Like 'code' but allows direct network for apps that ignore HTTP_PROXY (cursor-agent, opencode)",
 }
@@ -75,11 +77,21 @@ func List() []Template {
 // Load loads a template by name and returns the parsed config.
 // If the template uses "extends", the inheritance chain is resolved.
 func Load(name string) (*config.Config, error) {
+	layers, err := LoadLayers(name)
+	if err != nil {
+		return nil, err
+	}
+	return config.MergeLayers(layers), nil
+}
+
+// LoadLayers loads a template by name and returns its inheritance chain
+// as layers, base first. Each layer's Source is "template:<name>".
+func LoadLayers(name string) ([]config.Layer, error) {
 	return loadWithDepth(name, 0, nil)
 }
 
 // loadWithDepth loads a template with cycle and depth tracking.
-func loadWithDepth(name string, depth int, seen map[string]bool) (*config.Config, error) {
+func loadWithDepth(name string, depth int, seen map[string]bool) ([]config.Layer, error) {
 	if depth > maxExtendsDepth {
 		return nil, fmt.Errorf("extends chain too deep (max %d)", maxExtendsDepth)
 	}
@@ -107,16 +119,23 @@ func loadWithDepth(name string, depth int, seen map[string]bool) (*config.Config
 		return nil, fmt.Errorf("failed to parse template %q: %w", name, err)
 	}
 
+	layer := config.Layer{Source: TemplateSource(name), Config: &cfg}
+
 	// If this template extends another, resolve the chain
 	if cfg.Extends != "" {
-		baseCfg, err := loadWithDepth(cfg.Extends, depth+1, seen)
+		baseLayers, err := loadWithDepth(cfg.Extends, depth+1, seen)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load base template %q: %w", cfg.Extends, err)
 		}
-		return config.Merge(baseCfg, &cfg), nil
+		return append(baseLayers, layer), nil
 	}
 
-	return &cfg, nil
+	return []config.Layer{layer}, nil
+}
+
+// TemplateSource returns the layer source label used for a built-in template.
+func TemplateSource(name string) string {
+	return "template:" + strings.TrimSuffix(name, ".json")
 }
 
 // Exists checks if a template with the given name exists.
@@ -157,13 +176,29 @@ func ResolveExtendsWithBaseDir(cfg *config.Config, baseDir string) (*config.Conf
 		return cfg, nil
 	}
 
-	return resolveExtendsWithDepth(cfg, baseDir, 0, nil)
+	layers, err := ResolveLayersWithBaseDir(cfg, "", baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return config.MergeLayers(layers), nil
+}
+
+// ResolveLayersWithBaseDir is like ResolveExtendsWithBaseDir but returns the
+// unmerged inheritance chain, base first, ending with cfg itself labeled source.
+// Extended files are labeled with their resolved path and templates with
+// TemplateSource.
+func ResolveLayersWithBaseDir(cfg *config.Config, source, baseDir string) ([]config.Layer, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	return resolveExtendsWithDepth(cfg, source, baseDir, 0, nil)
 }
 
 // resolveExtendsWithDepth resolves extends with cycle and depth tracking.
-func resolveExtendsWithDepth(cfg *config.Config, baseDir string, depth int, seen map[string]bool) (*config.Config, error) {
-	if cfg == nil || cfg.Extends == "" {
-		return cfg, nil
+func resolveExtendsWithDepth(cfg *config.Config, source, baseDir string, depth int, seen map[string]bool) ([]config.Layer, error) {
+	layer := config.Layer{Source: source, Config: cfg}
+	if cfg.Extends == "" {
+		return []config.Layer{layer}, nil
 	}
 
 	if depth > maxExtendsDepth {
@@ -174,35 +209,33 @@ func resolveExtendsWithDepth(cfg *config.Config, baseDir string, depth int, seen
 		seen = make(map[string]bool)
 	}
 
-	var baseCfg *config.Config
-	var newBaseDir string
-	var err error
+	var baseLayers []config.Layer
 
 	// Handle file path or template name extends
 	if isPath(cfg.Extends) {
-		baseCfg, newBaseDir, err = loadConfigFile(cfg.Extends, baseDir, seen)
-	} else {
-		baseCfg, err = loadWithDepth(cfg.Extends, depth+1, seen)
-		newBaseDir = ""
-	}
-
-	if err != nil {
-		return nil, err
-	}
+		baseCfg, basePath, err := loadConfigFile(cfg.Extends, baseDir, seen)
+		if err != nil {
+			return nil, err
+		}
 
-	// If the base config also has extends, resolve it recursively
-	if baseCfg.Extends != "" {
-		baseCfg, err = resolveExtendsWithDepth(baseCfg, newBaseDir, depth+1, seen)
+		// If the base config also has extends, resolve it recursively
+		baseLayers, err = resolveExtendsWithDepth(baseCfg, basePath, filepath.Dir(basePath), depth+1, seen)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		baseLayers, err = loadWithDepth(cfg.Extends, depth+1, seen)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return config.Merge(baseCfg, cfg), nil
+	return append(baseLayers, layer), nil
 }
 
 // loadConfigFile loads a config from a file path with cycle detection.
-// Returns the loaded config, the directory of the loaded file (for resolving nested extends), and any error.
+// Returns the loaded config, its resolved path (for resolving nested extends), and any error.
 func loadConfigFile(path, baseDir string, seen map[string]bool) (*config.Config, string, error) {
 	var resolvedPath string
 	switch {
@@ -226,27 +259,17 @@ func loadConfigFile(path, baseDir string, seen map[string]bool) (*config.Config,
 	}
 	seen[resolvedPath] = true
 
-	data, err := os.ReadFile(resolvedPath) //nolint:gosec // user-provided config path - intentional
+	// Load keeps the bytes it parsed, for checking the file's signature
+	cfg, err := config.Load(resolvedPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("extends file %q: %w", path, err)
+	}
+	if cfg == nil {
+		if _, err := os.Stat(resolvedPath); os.IsNotExist(err) {
 			return nil, "", fmt.Errorf("extends file not found: %q", path)
 		}
-		return nil, "", fmt.Errorf("failed to read extends file %q: %w", path, err)
-	}
-
-	// Handle empty file
-	if len(strings.TrimSpace(string(data))) == 0 {
 		return nil, "", fmt.Errorf("extends file is empty: %q", path)
 	}
 
-	var cfg config.Config
-	if err := json.Unmarshal(jsonc.ToJSON(data), &cfg); err != nil {
-		return nil, "", fmt.Errorf("invalid JSON in extends file %q: %w", path, err)
-	}
-
-	if err := cfg.Validate(); err != nil {
-		return nil, "", fmt.Errorf("invalid configuration in extends file %q: %w", path, err)
-	}
-
-	return &cfg, filepath.Dir(resolvedPath), nil
+	return cfg, resolvedPath, nil
 }
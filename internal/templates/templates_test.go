@@ -3,6 +3,8 @@ package templates
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/Use-Tusk/fence/internal/config"
@@ -126,6 +128,25 @@ func TestCodeTemplate(t *testing.T) {
 	}
 }
 
+func TestNixTemplate(t *testing.T) {
+	cfg, err := Load("nix")
+	if err != nil {
+		t.Fatalf("failed to load nix template: %v", err)
+	}
+
+	if !slices.Contains(cfg.Network.AllowedDomains, "cache.nixos.org") {
+		t.Error("cache.nixos.org should be in allowed domains")
+	}
+	if !slices.Contains(cfg.Network.AllowUnixSockets, "/nix/var/nix/daemon-socket/socket") {
+		t.Error("nix template should allow the Nix daemon socket")
+	}
+	for _, path := range cfg.Filesystem.AllowWrite {
+		if strings.HasPrefix(path, "/nix") {
+			t.Errorf("nix template should keep /nix read-only, allows writes to %s", path)
+		}
+	}
+}
+
 func TestCodeRelaxedTemplate(t *testing.T) {
 	cfg, err := Load("code-relaxed")
 	if err != nil {
@@ -559,3 +580,54 @@ func TestExtendsFilePath(t *testing.T) {
 		}
 	})
 }
+
+func TestResolveLayersSources(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	basePath := filepath.Join(tmpDir, "base.json")
+	baseContent := `{"extends": "code", "network": {"allowedDomains": ["base.example.com"]}}`
+	if err := os.WriteFile(basePath, []byte(baseContent), 0o600); err != nil {
+		t.Fatalf("failed to write base config: %v", err)
+	}
+
+	cfg := &config.Config{
+		Extends: "./base.json",
+		Network: config.NetworkConfig{AllowedDomains: []string{"child.example.com"}},
+	}
+
+	layers, err := ResolveLayersWithBaseDir(cfg, "child.json", tmpDir)
+	if err != nil {
+		t.Fatalf("ResolveLayersWithBaseDir() error = %v", err)
+	}
+
+	wantSources := []string{"template:code", basePath, "child.json"}
+	if len(layers) != len(wantSources) {
+		t.Fatalf("got %d layers, want %d", len(layers), len(wantSources))
+	}
+	for i, want := range wantSources {
+		if layers[i].Source != want {
+			t.Errorf("layer %d source = %q, want %q", i, layers[i].Source, want)
+		}
+	}
+
+	// Merging layers must match ResolveExtendsWithBaseDir
+	merged := config.MergeLayers(layers)
+	resolved, err := ResolveExtendsWithBaseDir(cfg, tmpDir)
+	if err != nil {
+		t.Fatalf("ResolveExtendsWithBaseDir() error = %v", err)
+	}
+	if len(merged.Network.AllowedDomains) != len(resolved.Network.AllowedDomains) {
+		t.Errorf("merged layers have %d domains, resolved has %d",
+			len(merged.Network.AllowedDomains), len(resolved.Network.AllowedDomains))
+	}
+}
+
+func TestLoadLayersNoExtends(t *testing.T) {
+	layers, err := LoadLayers("code")
+	if err != nil {
+		t.Fatalf("LoadLayers() error = %v", err)
+	}
+	if len(layers) != 1 || layers[0].Source != "template:code" {
+		t.Errorf("LoadLayers(code) = %+v, want single template:code layer", layers)
+	}
+}
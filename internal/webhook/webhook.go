@@ -0,0 +1,238 @@
+// Package webhook posts a fence run's violations to a URL as they happen, so
+// security teams can alert on blocked operations centrally. Each violation
+// is one JSON POST, optionally signed with HMAC-SHA256 so the receiver can
+// check it came from fence.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/logging"
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+// DefaultSecretEnv is the environment variable holding the signing secret
+// if webhook.secretEnv is unset.
+const DefaultSecretEnv = "FENCE_WEBHOOK_SECRET"
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the request
+// body, keyed with the secret.
+const SignatureHeader = "X-Fence-Signature"
+
+// Retry backoff, how many notifications are kept while the endpoint is
+// unreachable, and how long one request may take.
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	maxQueued   = 1000
+	sendTimeout = 10 * time.Second
+)
+
+// Payload is the JSON body of a notification.
+type Payload struct {
+	Time    time.Time    `json:"time"` // When the notification was sent
+	Host    string       `json:"host,omitempty"`
+	Session string       `json:"session,omitempty"`
+	Command string       `json:"command,omitempty"`
+	Version string       `json:"version,omitempty"`
+	Event   policy.Event `json:"event"`
+}
+
+// Options configure a Notifier.
+type Options struct {
+	URL    string
+	Secret string // Signs each request in SignatureHeader if set
+	// Session, Command, and Version describe the run in every payload.
+	Session string
+	Command string
+	Version string
+	Debug   bool
+}
+
+// Notifier posts violations to the webhook in order, retrying with backoff
+// when the endpoint fails. Violations recorded meanwhile are queued,
+// dropping the oldest beyond a limit.
+type Notifier struct {
+	opts   Options
+	client *http.Client
+	host   string
+
+	mu      sync.Mutex
+	queue   []policy.Event
+	dropped int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a notifier for a run.
+func New(opts Options) *Notifier {
+	host, _ := os.Hostname()
+	return &Notifier{
+		opts:   opts,
+		client: &http.Client{},
+		host:   host,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Notify queues a violation. It never blocks, so it is safe to subscribe to
+// policy.ViolationLog.
+func (n *Notifier) Notify(e policy.Event) {
+	n.mu.Lock()
+	if len(n.queue) >= maxQueued {
+		n.queue = n.queue[1:]
+		n.dropped++
+	}
+	n.queue = append(n.queue, e)
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run posts queued violations until ctx is done or Finish is called.
+func (n *Notifier) Run(ctx context.Context) {
+	defer close(n.done)
+	backoff := minBackoff
+	for {
+		err := n.flush(ctx)
+		if err == nil {
+			backoff = minBackoff
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.stop:
+				return
+			case <-n.wake:
+			}
+			continue
+		}
+		n.logDebug("Posting to %s failed, retrying in %s: %v", n.opts.URL, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Finish stops the notifier, waiting up to timeout for the queued
+// violations to be posted. Run must have been started.
+func (n *Notifier) Finish(timeout time.Duration) {
+	close(n.stop)
+	<-n.done
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := n.flush(ctx); err != nil {
+		n.mu.Lock()
+		pending := len(n.queue)
+		n.mu.Unlock()
+		n.logDebug("Posting to %s failed, dropping %d notifications: %v", n.opts.URL, pending, err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.dropped > 0 {
+		n.logDebug("Dropped %d notifications while %s was unreachable", n.dropped, n.opts.URL)
+	}
+}
+
+// flush posts the queued violations in order. One that could not be posted
+// stays queued, with those after it, for the next attempt.
+func (n *Notifier) flush(ctx context.Context) error {
+	for {
+		n.mu.Lock()
+		if len(n.queue) == 0 {
+			n.mu.Unlock()
+			return nil
+		}
+		e, dropped := n.queue[0], n.dropped
+		n.mu.Unlock()
+
+		if err := n.post(ctx, e); err != nil {
+			return err
+		}
+
+		n.mu.Lock()
+		// If the queue overflowed meanwhile, e was the first to be dropped
+		if n.dropped == dropped {
+			n.queue = n.queue[1:]
+		}
+		n.mu.Unlock()
+	}
+}
+
+// post sends one violation to the webhook.
+func (n *Notifier) post(ctx context.Context, e policy.Event) error {
+	body, err := json.Marshal(Payload{
+		Time:    time.Now().UTC(),
+		Host:    n.host,
+		Session: n.opts.Session,
+		Command: n.opts.Command,
+		Version: n.opts.Version,
+		Event:   e,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fence/"+n.opts.Version)
+	if n.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(n.opts.Secret), body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value for body: "sha256=" and the hex
+// HMAC-SHA256 of body keyed with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the SignatureHeader value of body
+// for secret, for receivers written in Go.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (n *Notifier) logDebug(format string, args ...any) {
+	if n.opts.Debug {
+		logging.Debugf("webhook", format, args...)
+	}
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Use-Tusk/fence/internal/policy"
+)
+
+const testSecret = "s3cret"
+
+// receiver is a fake webhook endpoint that keeps the payloads it accepts.
+type receiver struct {
+	mu       sync.Mutex
+	payloads []Payload
+	failures int // Requests to fail before accepting
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	if !Verify([]byte(testSecret), body, req.Header.Get(SignatureHeader)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.payloads = append(r.payloads, p)
+}
+
+func (r *receiver) received() []Payload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Payload(nil), r.payloads...)
+}
+
+func TestNotifierPostsSignedViolations(t *testing.T) {
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	n := New(Options{URL: srv.URL, Secret: testSecret, Session: "abc123", Command: "npm install"})
+	go n.Run(context.Background())
+	n.Notify(policy.Event{Kind: policy.KindNetwork, Source: policy.SourceProxy, Target: "evil.com:443", Decision: policy.Deny("", "not in allowedDomains")})
+	n.Notify(policy.Event{Kind: policy.KindCommand, Source: policy.SourceCommand, Target: "git push", Decision: policy.Deny("command.deny", "denied")})
+	n.Finish(5 * time.Second)
+
+	got := r.received()
+	if len(got) != 2 {
+		t.Fatalf("received %d payloads, want 2", len(got))
+	}
+	if got[0].Event.Target != "evil.com:443" || got[1].Event.Target != "git push" {
+		t.Errorf("payloads out of order: %+v", got)
+	}
+	if got[0].Session != "abc123" || got[0].Command != "npm install" {
+		t.Errorf("payload = %+v, want the run's session and command", got[0])
+	}
+}
+
+func TestNotifierRetriesFailedPosts(t *testing.T) {
+	r := &receiver{failures: 1}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	n := New(Options{URL: srv.URL, Secret: testSecret})
+	n.Notify(policy.Event{Kind: policy.KindFilesystem, Target: "/etc/shadow"})
+	if err := n.flush(context.Background()); err == nil {
+		t.Fatal("flush() succeeded against a failing endpoint")
+	}
+	if err := n.flush(context.Background()); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	if got := r.received(); len(got) != 1 || got[0].Event.Target != "/etc/shadow" {
+		t.Errorf("received %+v, want the violation after the retry", got)
+	}
+}
+
+func TestNotifierDropsOldestWhenFull(t *testing.T) {
+	n := New(Options{URL: "http://127.0.0.1:0"})
+	for i := 0; i < maxQueued+5; i++ {
+		n.Notify(policy.Event{Target: "t", Time: time.Unix(int64(i), 0)})
+	}
+	if len(n.queue) != maxQueued || n.dropped != 5 {
+		t.Fatalf("queue = %d, dropped = %d, want %d and 5", len(n.queue), n.dropped, maxQueued)
+	}
+	if !n.queue[0].Time.Equal(time.Unix(5, 0)) {
+		t.Errorf("oldest queued = %v, want the sixth violation", n.queue[0].Time)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// Reference value from: printf 'hello' | openssl dgst -sha256 -hmac key
+	const want = "sha256=9307b3b915efb5171ff14d8cb55fbcc798c6c0ef1456d66ded1a6aa723a58b7b"
+	if got := Sign([]byte("key"), []byte("hello")); got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+	if Verify([]byte("other"), []byte("hello"), want) {
+		t.Error("Verify() accepted a signature made with another secret")
+	}
+}
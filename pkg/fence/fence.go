@@ -2,8 +2,15 @@
 package fence
 
 import (
+	"io"
+	"os/exec"
+	"slices"
+	"time"
+
 	"github.com/Use-Tusk/fence/internal/config"
 	"github.com/Use-Tusk/fence/internal/platform"
+	"github.com/Use-Tusk/fence/internal/policy"
+	"github.com/Use-Tusk/fence/internal/proxy"
 	"github.com/Use-Tusk/fence/internal/sandbox"
 )
 
@@ -21,6 +28,62 @@ type NetworkConfig = config.NetworkConfig
 // FilesystemConfig defines filesystem restrictions.
 type FilesystemConfig = config.FilesystemConfig
 
+// CommandConfig defines the commands the sandbox blocks. See CheckCommand.
+type CommandConfig = config.CommandConfig
+
+// CommandFlagRule blocks a program only when it is run with one of a set of
+// flags, e.g. curl with --upload-file. See CommandConfig.DenyFlags.
+type CommandFlagRule = config.CommandFlagRule
+
+// SSHConfig defines the hosts and remote commands ssh may reach.
+type SSHConfig = config.SSHConfig
+
+// CommandRegexPrefix marks a command rule as a regular expression, e.g.
+// "re:^git\s+push\s+--force". Rules containing * are globs; others are
+// prefixes.
+const CommandRegexPrefix = config.CommandRegexPrefix
+
+// DefaultDeniedCommands are the command prefixes blocked unless
+// CommandConfig.UseDefaults is false.
+func DefaultDeniedCommands() []string {
+	return slices.Clone(config.DefaultDeniedCommands)
+}
+
+// DefaultDeniedCommandFlags are the flag rules blocked unless
+// CommandConfig.UseDefaults is false.
+func DefaultDeniedCommandFlags() []CommandFlagRule {
+	return slices.Clone(config.DefaultDeniedCommandFlags)
+}
+
+// DBusConfig defines which D-Bus names the sandbox may reach (Linux).
+type DBusConfig = config.DBusConfig
+
+// GUIConfig controls access to the graphical display.
+type GUIConfig = config.GUIConfig
+
+// DevicesConfig controls access to microphones and cameras.
+type DevicesConfig = config.DevicesConfig
+
+// MacOSConfig adds rules to the generated sandbox-exec profile (macOS).
+type MacOSConfig = config.MacOSConfig
+
+// SecurityConfig defines additional process isolation options.
+type SecurityConfig = config.SecurityConfig
+
+// SeccompConfig adjusts the seccomp filter the sandboxed command runs under (Linux).
+type SeccompConfig = config.SeccompConfig
+
+// ResourcesConfig limits the memory, CPU, and processes of the sandboxed command (Linux).
+type ResourcesConfig = config.ResourcesConfig
+
+// EnvConfig controls the environment variables sandboxed commands get. See
+// Manager.Env.
+type EnvConfig = config.EnvConfig
+
+// PolicyConfig names a Rego policy that decides in place of the config's
+// rules.
+type PolicyConfig = config.PolicyConfig
+
 // Manager handles sandbox initialization and command wrapping.
 type Manager = sandbox.Manager
 
@@ -31,6 +94,246 @@ func NewManager(cfg *Config, debug, monitor bool) *Manager {
 	return sandbox.NewManager(cfg, debug, monitor)
 }
 
+// Option configures a Manager created with New.
+type Option = sandbox.Option
+
+// FilterFunc decides whether the proxies allow a connection to host:port.
+// See WithFilter.
+type FilterFunc = proxy.FilterFunc
+
+// New creates a sandbox manager configured by opts. Without options it is
+// NewManager(cfg, false, false).
+func New(cfg *Config, opts ...Option) (*Manager, error) {
+	return sandbox.New(cfg, opts...)
+}
+
+// WithDebug enables verbose logging of proxy activity and sandbox setup.
+func WithDebug(enabled bool) Option { return sandbox.WithDebug(enabled) }
+
+// WithMonitor enables logging of violations (blocked requests) only.
+func WithMonitor(enabled bool) Option { return sandbox.WithMonitor(enabled) }
+
+// WithLogger sends the Manager's own log lines to w instead of stderr.
+func WithLogger(w io.Writer) Option { return sandbox.WithLogger(w) }
+
+// WithBackend selects the Linux sandbox backend ("bwrap", "native", "gvisor",
+// "apparmor", or "selinux").
+func WithBackend(name string) Option { return sandbox.WithBackend(name) }
+
+// WithFilter makes filter decide which hosts the proxies allow, instead of
+// the config's domain rules.
+func WithFilter(filter FilterFunc) Option { return sandbox.WithFilter(filter) }
+
+// Policy decides a sandboxed command's connections and commands, and may
+// narrow the paths it can write, in place of the config's rules. See
+// WithPolicy.
+type Policy = sandbox.Policy
+
+// ConfigPolicy is the Policy applied by default: the rules in its Config.
+// Embed it in a Policy to change only some decisions.
+type ConfigPolicy = sandbox.ConfigPolicy
+
+// WithPolicy makes p decide instead of the config's rules. It cannot be
+// combined with WithFilter.
+func WithPolicy(p Policy) Option { return sandbox.WithPolicy(p) }
+
+// PluginPolicy is a Policy that asks an external program about what the
+// config's rules leave open. See StartPolicyPlugin.
+type PluginPolicy = sandbox.PluginPolicy
+
+// PluginRequest and PluginResponse are the lines of JSON exchanged with a
+// policy plugin.
+type (
+	PluginRequest  = sandbox.PluginRequest
+	PluginResponse = sandbox.PluginResponse
+)
+
+// StartPolicyPlugin starts cmd as a policy plugin for cfg. Operations it
+// does not answer about within timeout (0 for 5 seconds) are denied. Close
+// the plugin when done.
+func StartPolicyPlugin(cmd *exec.Cmd, cfg *Config, timeout time.Duration) (*PluginPolicy, error) {
+	return sandbox.StartPolicyPlugin(cmd, cfg, timeout)
+}
+
+// RegoPolicy is a Policy that evaluates the Rego policy a config's
+// policy.rego names. See LoadRegoPolicy.
+type RegoPolicy = sandbox.RegoPolicy
+
+// RegoInput and RegoBuiltin are the input document a Rego policy is
+// evaluated with.
+type (
+	RegoInput   = sandbox.RegoInput
+	RegoBuiltin = sandbox.RegoBuiltin
+)
+
+// LoadRegoPolicy compiles the Rego policy cfg.Policy.Rego names. New does
+// this itself for a config with policy.rego set.
+func LoadRegoPolicy(cfg *Config) (*RegoPolicy, error) { return sandbox.LoadRegoPolicy(cfg) }
+
+// WithoutHTTPProxy leaves out the HTTP proxy.
+func WithoutHTTPProxy() Option { return sandbox.WithoutHTTPProxy() }
+
+// WithoutSOCKS leaves out the SOCKS5 proxy. With WithoutHTTPProxy as well,
+// the Manager is a filesystem-only sandbox and commands have no network.
+func WithoutSOCKS() Option { return sandbox.WithoutSOCKS() }
+
+// WithoutSandbox makes a network-only Manager that runs just the proxies;
+// WrapCommand and Spec return an error.
+func WithoutSandbox() Option { return sandbox.WithoutSandbox() }
+
+// WithoutNetworkSandbox keeps the filesystem and command policy but leaves
+// the command on the host network, with no proxies or network namespace.
+func WithoutNetworkSandbox() Option { return sandbox.WithoutNetworkSandbox() }
+
+// WithTerminal tells the Manager that commands run on a pseudo-terminal
+// created for them, which they may keep as their controlling terminal.
+func WithTerminal() Option { return sandbox.WithTerminal() }
+
+// WorkspaceOverlay is a copy-on-write overlay over a directory. See
+// WithCopyOnWrite.
+type WorkspaceOverlay = sandbox.WorkspaceOverlay
+
+// WithCopyOnWrite mounts overlay over its directory on Linux, so what
+// commands write there goes to its upper directory instead.
+func WithCopyOnWrite(overlay WorkspaceOverlay) Option { return sandbox.WithCopyOnWrite(overlay) }
+
+// Proxy is a proxy server the sandboxed command's traffic is routed through.
+// See Manager.SetHTTPProxy and Manager.SetSOCKSProxy.
+type Proxy = sandbox.Proxy
+
+// Spec describes the enforcement artifacts generated for a command. See Manager.Spec.
+type Spec = sandbox.Spec
+
+// LandlockRule is a path the Linux Landlock ruleset grants access to.
+type LandlockRule = sandbox.LandlockRule
+
+// ViolationLog collects the operations denied during a run, or in audit mode
+// those that would have been denied. See Manager.Violations.
+type ViolationLog = policy.ViolationLog
+
+// Violation is an operation the policy denied (or would have denied in audit mode).
+type Violation = policy.Violation
+
+// DownloadLog collects the downloads flagged by network.downloads. See Manager.Downloads.
+type DownloadLog = policy.DownloadLog
+
+// Download is a response flagged by network.downloads.
+type Download = policy.Download
+
+// RunStats counts the requests the proxies handled and the operations blocked
+// during a run. See Manager.Stats.
+type RunStats = policy.RunStats
+
+// LimitEvents counts the times the resources limits intervened. See Manager.LimitEvents.
+type LimitEvents = sandbox.LimitEvents
+
+// Event is a single violation delivered to Manager.Subscribe callbacks.
+type Event = policy.Event
+
+// Kinds of violations (Event.Kind, Violation.Kind).
+const (
+	KindNetwork    = policy.KindNetwork
+	KindCommand    = policy.KindCommand
+	KindFilesystem = policy.KindFilesystem
+)
+
+// Sources that detect violations (Event.Source).
+const (
+	SourceProxy     = policy.SourceProxy
+	SourceCommand   = policy.SourceCommand
+	SourceLogStream = policy.SourceLogStream
+	SourceEBPF      = policy.SourceEBPF
+)
+
+// Errors returned by Manager. Use errors.Is to test for the sentinels and
+// errors.As to extract the typed errors.
+var (
+	// ErrSandboxUnsupported is returned when the current platform cannot be sandboxed.
+	ErrSandboxUnsupported = sandbox.ErrSandboxUnsupported
+
+	// ErrMissingDependency matches any *MissingDependencyError.
+	ErrMissingDependency = sandbox.ErrMissingDependency
+
+	// ErrPolicyViolation matches any *PolicyViolationError.
+	ErrPolicyViolation = sandbox.ErrPolicyViolation
+
+	// ErrInitTimeout is returned when sandbox infrastructure does not become ready in time.
+	ErrInitTimeout = sandbox.ErrInitTimeout
+)
+
+// MissingDependencyError reports a required binary (bwrap, socat, ...) that was not found.
+type MissingDependencyError = sandbox.MissingDependencyError
+
+// PolicyViolationError reports an operation refused by sandbox policy and the rule that refused it.
+type PolicyViolationError = sandbox.PolicyViolationError
+
+// CommandBlockedError is wrapped by PolicyViolationError when a command matches a deny rule.
+type CommandBlockedError = sandbox.CommandBlockedError
+
+// SSHBlockedError is wrapped by PolicyViolationError when an SSH command is refused.
+type SSHBlockedError = sandbox.SSHBlockedError
+
+// Decision is the outcome of evaluating a command against the config, with
+// the rule that decided it.
+type Decision = policy.Decision
+
+// CheckCommand checks command against cfg's command and SSH policy, as
+// Manager.WrapCommand does before running it, without starting a sandbox.
+// Each command in pipelines, chains, substitutions, and shell -c scripts is
+// checked. It returns nil if the command is allowed, or a
+// *CommandBlockedError or *SSHBlockedError. A nil cfg is DefaultConfig().
+func CheckCommand(command string, cfg *Config) error {
+	return sandbox.CheckCommand(command, cfg)
+}
+
+// EvaluateCommand decides command as CheckCommand does and reports the rule
+// that decided it.
+func EvaluateCommand(command string, cfg *Config) Decision {
+	return sandbox.EvaluateCommand(command, cfg)
+}
+
+// CheckSSHCommand checks only the ssh.* policy for an ssh command line.
+// Commands other than ssh are allowed.
+func CheckSSHCommand(command string, cfg *Config) error {
+	return sandbox.CheckSSHCommand(command, cfg)
+}
+
+// ShutdownError reports the teardown steps Manager.Shutdown could not complete.
+type ShutdownError = sandbox.ShutdownError
+
+// TeardownFailure is a teardown step that did not complete.
+type TeardownFailure = sandbox.TeardownFailure
+
+// ToolConflict is a tool in a command that needs something the sandbox
+// blocks, with the change that allows it. See DetectToolConflicts.
+type ToolConflict = sandbox.ToolConflict
+
+// DetectToolConflicts returns the known tools in command (docker, Chrome,
+// watchman) that need something cfg or the sandbox blocks on this platform.
+// Manager.WrapCommand logs them as warnings.
+func DetectToolConflicts(command string, cfg *Config) []ToolConflict {
+	return sandbox.DetectToolConflicts(command, cfg)
+}
+
+// FileChange is a file or directory a command created, modified, or deleted.
+type FileChange = sandbox.FileChange
+
+// FileSnapshot records the files under a set of paths. See SnapshotFiles.
+type FileSnapshot = sandbox.FileSnapshot
+
+// ChangeRoots returns the existing paths in cfg's filesystem.allowWrite,
+// the paths to snapshot for a report of what a command changed.
+func ChangeRoots(cfg *Config) []string {
+	return sandbox.ChangeRoots(cfg)
+}
+
+// SnapshotFiles records the files under roots. Take one before running a
+// command and call its Changes method afterwards for what the command
+// created, modified, or deleted.
+func SnapshotFiles(roots []string) (*FileSnapshot, error) {
+	return sandbox.SnapshotFiles(roots)
+}
+
 // DefaultConfig returns the default configuration with all network blocked.
 func DefaultConfig() *Config {
 	return config.Default()